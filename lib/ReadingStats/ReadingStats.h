@@ -33,6 +33,7 @@ class ReadingStats {
   void endSession();                      // saves accumulated time
   void recordPageTurn();                  // increment page count
   void recordBookFinished();
+  bool inSession() const { return inSession_; }
 
   // Persistence
   bool load();
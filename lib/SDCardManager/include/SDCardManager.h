@@ -115,6 +115,10 @@ class SDCardManager {
 
   bool removeDir(const char* path);
 
+  // Card size and free space in bytes. Counting the free clusters reads
+  // the whole FAT, which takes a few seconds on a large card.
+  bool space(uint64_t& totalBytes, uint64_t& freeBytes);
+
   static SDCardManager& getInstance() { return instance; }
 
   // Raw SdFat access for libraries that take an SdFat& directly (ported
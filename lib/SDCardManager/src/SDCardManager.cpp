@@ -336,6 +336,17 @@ bool SDCardManager::rename(const char* path, const char* newPath) {
   return sd.rename(path, newPath);
 }
 
+bool SDCardManager::space(uint64_t& totalBytes, uint64_t& freeBytes) {
+  if (!initialized) return false;
+  SdLockGuard lock(sdMutex_);
+  const int32_t freeClusters = sd.freeClusterCount();
+  if (freeClusters < 0) return false;
+  const uint64_t clusterBytes = sd.bytesPerCluster();
+  totalBytes = clusterBytes * sd.clusterCount();
+  freeBytes = clusterBytes * (uint64_t)freeClusters;
+  return true;
+}
+
 // ─── Atomic write protocol ────────────────────────────────────────────
 //
 // 3-rename rotation per docs/ATOMIC_WRITE_DESIGN.md.
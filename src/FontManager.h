@@ -120,6 +120,14 @@ class FontManager {
   /// Returns true if the active reader font was loaded from SD card (not a builtin font).
   bool isUsingCustomReaderFont() const { return _activeReaderFontId != 0; }
 
+  /// Number of font families loaded from SD card.
+  size_t loadedFamilyCount() const { return loadedFamilies.size(); }
+
+  /// File name of the loaded external font, or "" if none is loaded.
+  const char* externalFontName() const {
+    return (_externalFont && _externalFont->isLoaded()) ? _externalFontPath.c_str() : "";
+  }
+
   /**
    * Log information about all loaded fonts.
    */
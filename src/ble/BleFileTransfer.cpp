@@ -4,6 +4,7 @@
 
 #if FEATURE_BLUETOOTH
 
+#include <Crc32.h>
 #include <NimBLEDevice.h>
 #include <SdFat.h>
 #include <SDCardManager.h>
@@ -55,6 +56,21 @@ uint32_t _lastAckBytes = 0;  // Bytes at last ACK
 FsFile _file;
 uint16_t _mtu = 20;  // Will be negotiated
 
+// Resumable transfers ("resume":true in the metadata) are written to
+// _partPath (_fullPath + ".part") and renamed into place once complete.
+// A dropped connection keeps the part file, and the next metadata for
+// the same name carries on from its end instead of starting over. The
+// web page doesn't ask for this, so its transfers behave as before.
+bool _resumable = false;
+char _partPath[232] = {0};
+
+// CRC-32 of everything written so far (including a resumed part), sent
+// back in the done status. A sender that puts "crc" in the metadata has
+// the file rejected if it doesn't match.
+sumi::Crc32 _crc;
+uint32_t _expectedCrc = 0;
+bool _checkCrc = false;
+
 // Stats for debugging
 uint32_t _transferStartTime = 0;
 uint32_t _chunksReceived = 0;
@@ -98,6 +114,9 @@ void notifyCallback(TransferEvent event, const char* data);
 void resetTransfer();
 void storeResult(bool success, float speedKBs, const char* errorMsg);
 
+// Where the incoming bytes go: the part file for resumable transfers.
+const char* writePath() { return _resumable ? _partPath : _fullPath; }
+
 // ── Simple JSON Parser ────────────────────────────────────────
 
 bool parseJsonString(const char* json, const char* key, char* out, size_t outLen) {
@@ -253,7 +272,10 @@ void onServerDisconnect(NimBLEServer* /*pServer*/, NimBLEConnInfo& /*connInfo*/,
         Serial.printf("[BLE-FT] Received %lu / %lu bytes (%d%%)\n",
                       _receivedBytes, _expectedSize,
                       _expectedSize > 0 ? (int)((_receivedBytes * 100) / _expectedSize) : 0);
-        if (_file.isOpen()) {
+        if (_file.isOpen() && _resumable) {
+            SdMan.syncAndClose(_file);
+            Serial.printf("[BLE-FT] Kept partial file for resume: %s\n", _partPath);
+        } else if (_file.isOpen()) {
             _file.close();
             SdMan.remove(_fullPath);
             Serial.printf("[BLE-FT] Removed partial file: %s\n", _fullPath);
@@ -346,6 +368,17 @@ class MetadataCallbacks : public NimBLECharacteristicCallbacks {
         if (!parseJsonString(value.c_str(), "folder", _folder, sizeof(_folder))) {
             strcpy(_folder, "books");
         }
+        _resumable = parseJsonBool(value.c_str(), "resume");
+        char crcHex[12];
+        _checkCrc = parseJsonString(value.c_str(), "crc", crcHex, sizeof(crcHex));
+        if (_checkCrc) {
+            char* end = nullptr;
+            _expectedCrc = strtoul(crcHex, &end, 16);
+            if (!crcHex[0] || *end) {
+                sendStatus("{\"state\":\"error\",\"msg\":\"Bad crc\"}");
+                return;
+            }
+        }
 
         // Parse queue info. Reject values that would silently truncate
         // when cast to uint8_t — a sender that sends queueTotal=300 would
@@ -453,30 +486,64 @@ class MetadataCallbacks : public NimBLECharacteristicCallbacks {
         }
         if (!SdMan.exists(dirPath)) SdMan.mkdir(dirPath);
 
-        // Open file
+        // Open file. A resumable transfer picks up an existing part file
+        // unless it is longer than the whole file or the sender asked to
+        // start over ("restart":true, after it found the part's CRC didn't
+        // match its own copy). The part is read through once to seed the
+        // CRC; ready reports its length and CRC so the sender can check.
         if (_file.isOpen()) _file.close();
-        _file = SdMan.open(_fullPath, O_WRONLY | O_CREAT | O_TRUNC);
+        _crc.reset();
+        uint32_t offset = 0;
+        if (_resumable) {
+            snprintf(_partPath, sizeof(_partPath), "%s.part", _fullPath);
+            if (!parseJsonBool(value.c_str(), "restart")) {
+                FsFile part;
+                if (SdMan.openFileForRead("BLE", _partPath, part)) {
+                    if (part.fileSize() <= _expectedSize) {
+                        uint8_t buf[256];
+                        int n;
+                        while ((n = part.read(buf, sizeof(buf))) > 0) {
+                            _crc.update(buf, n);
+                            offset += n;
+                        }
+                    }
+                    part.close();
+                }
+            }
+        }
+        if (offset > 0) {
+            _file = SdMan.open(_partPath, O_WRONLY);
+            if (_file && !_file.seekSet(offset)) _file.close();
+        } else {
+            _file = SdMan.open(writePath(), O_WRONLY | O_CREAT | O_TRUNC);
+        }
         if (!_file) {
             Serial.printf("[BLE-FT] ERROR: Failed to create file\n");
             sendStatus("{\"state\":\"error\",\"msg\":\"Failed to create file\"}");
             return;
         }
+        if (offset > 0) {
+            Serial.printf("[BLE-FT] Resuming %s at %lu bytes\n", _partPath, (unsigned long)offset);
+        }
 
-        _receivedBytes = 0;
-        _lastAckBytes = 0;
+        _receivedBytes = offset;
+        _lastAckBytes = offset;
         _chunksReceived = 0;
         _transferring = true;
         _hasResult = false;  // Clear previous result when new transfer starts
         _transferStartTime = millis();
         _lastProgressLog = 0;
 
-        Serial.printf("[BLE-FT] File opened: %s\n", _fullPath);
+        Serial.printf("[BLE-FT] File opened: %s\n", writePath());
         Serial.printf("[BLE-FT] Ready to receive %lu bytes\n", _expectedSize);
         Serial.printf("[BLE-FT] Free heap: %lu\n", (unsigned long)ESP.getFreeHeap());
         Serial.printf("[BLE-FT] ──────────────────────────────────────\n");
 
+        // _crc isn't finalized here: finalize() doesn't disturb the running
+        // state, so the part's CRC can be reported and then carried on.
         char status[128];
-        snprintf(status, sizeof(status), "{\"state\":\"ready\",\"mtu\":%d,\"ack\":%lu}", _mtu, ACK_INTERVAL_BYTES);
+        snprintf(status, sizeof(status), "{\"state\":\"ready\",\"mtu\":%d,\"ack\":%lu,\"offset\":%lu,\"crc\":\"%08lx\"}",
+                 _mtu, ACK_INTERVAL_BYTES, (unsigned long)offset, (unsigned long)_crc.finalize());
         sendStatus(status);
         notifyCallback(TransferEvent::TRANSFER_START, _filename);
     }
@@ -526,7 +593,15 @@ class DataCallbacks : public NimBLECharacteristicCallbacks {
                           (_receivedBytes == _expectedSize) ? "YES" : "NO");
             Serial.printf("[BLE-FT] Chunks: %lu | Time: %lums | Speed: %.1f KB/s\n", _chunksReceived, elapsed, kbps);
             
-            if (_receivedBytes == _expectedSize) {
+            const uint32_t crc = _crc.finalize();
+            const bool crcOk = !_checkCrc || crc == _expectedCrc;
+            bool placed = _receivedBytes == _expectedSize && crcOk;
+            if (placed && _resumable) {
+                if (SdMan.exists(_fullPath)) SdMan.remove(_fullPath);
+                placed = SdMan.rename(_partPath, _fullPath);
+            }
+
+            if (placed) {
                 Serial.printf("[BLE-FT] ✓ SUCCESS: %s (%lu bytes, %.1f KB/s)\n", _filename, _receivedBytes, kbps);
                 Serial.printf("[BLE-FT] Free heap: %lu\n", (unsigned long)ESP.getFreeHeap());
                 Serial.printf("[BLE-FT] ══════════════════════════════════════\n");
@@ -536,10 +611,10 @@ class DataCallbacks : public NimBLECharacteristicCallbacks {
                 _queueReceived++;
                 
                 // Send done status with file info
-                char status[192];
-                snprintf(status, sizeof(status), 
-                         "{\"state\":\"done\",\"name\":\"%s\",\"size\":%lu,\"speed\":%.1f}", 
-                         _filename, _receivedBytes, kbps);
+                char status[208];
+                snprintf(status, sizeof(status),
+                         "{\"state\":\"done\",\"name\":\"%s\",\"size\":%lu,\"speed\":%.1f,\"crc\":\"%08lx\"}",
+                         _filename, _receivedBytes, kbps, (unsigned long)crc);
                 sendStatus(status);
                 
                 notifyCallback(TransferEvent::TRANSFER_COMPLETE, _filename);
@@ -551,21 +626,30 @@ class DataCallbacks : public NimBLECharacteristicCallbacks {
                     notifyCallback(TransferEvent::QUEUE_FILE_DONE, buf);
                 }
             } else {
-                Serial.printf("[BLE-FT] ✗ SIZE MISMATCH: expected %lu, got %lu (delta %ld)\n", 
-                              _expectedSize, _receivedBytes, (long)(_expectedSize - _receivedBytes));
+                char errMsg[64];
+                if (_receivedBytes != _expectedSize) {
+                    Serial.printf("[BLE-FT] ✗ SIZE MISMATCH: expected %lu, got %lu (delta %ld)\n",
+                                  _expectedSize, _receivedBytes, (long)(_expectedSize - _receivedBytes));
+                    snprintf(errMsg, sizeof(errMsg), "Size mismatch: %lu/%lu", _receivedBytes, _expectedSize);
+                } else if (!crcOk) {
+                    Serial.printf("[BLE-FT] ✗ CRC MISMATCH: expected %08lx, got %08lx\n",
+                                  (unsigned long)_expectedCrc, (unsigned long)crc);
+                    snprintf(errMsg, sizeof(errMsg), "Checksum mismatch: %08lx", (unsigned long)crc);
+                } else {
+                    Serial.printf("[BLE-FT] ✗ RENAME FAILED: %s\n", _partPath);
+                    snprintf(errMsg, sizeof(errMsg), "Rename failed");
+                }
                 Serial.printf("[BLE-FT] Chunks received: %lu\n", _chunksReceived);
                 Serial.printf("[BLE-FT] Free heap: %lu\n", (unsigned long)ESP.getFreeHeap());
                 Serial.printf("[BLE-FT] ══════════════════════════════════════\n");
-                SdMan.remove(_fullPath);
-                
-                char errMsg[64];
-                snprintf(errMsg, sizeof(errMsg), "Size mismatch: %lu/%lu", _receivedBytes, _expectedSize);
+                SdMan.remove(writePath());
+
                 storeResult(false, kbps, errMsg);
-                
+
                 char status[128];
-                snprintf(status, sizeof(status), "{\"state\":\"error\",\"msg\":\"Size mismatch: %lu/%lu\"}", _receivedBytes, _expectedSize);
+                snprintf(status, sizeof(status), "{\"state\":\"error\",\"msg\":\"%s\"}", errMsg);
                 sendStatus(status);
-                notifyCallback(TransferEvent::TRANSFER_ERROR, "Size mismatch");
+                notifyCallback(TransferEvent::TRANSFER_ERROR, errMsg);
             }
             
             // Reset transfer state but keep queue state and result.
@@ -606,7 +690,7 @@ class DataCallbacks : public NimBLECharacteristicCallbacks {
             Serial.printf("[BLE-FT] Total received so far: %lu / %lu\n", _receivedBytes, _expectedSize);
             Serial.printf("[BLE-FT] Free heap: %lu\n", (unsigned long)ESP.getFreeHeap());
             _file.close();
-            SdMan.remove(_fullPath);
+            SdMan.remove(writePath());
             storeResult(false, 0, "SD write failed");
             sendStatus("{\"state\":\"error\",\"msg\":\"SD write failed\"}");
             notifyCallback(TransferEvent::TRANSFER_ERROR, "SD write failed");
@@ -614,6 +698,7 @@ class DataCallbacks : public NimBLECharacteristicCallbacks {
             return;
         }
 
+        _crc.update(value.data(), written);
         _receivedBytes += written;
 
         // Log progress every 20KB
@@ -678,6 +763,9 @@ void resetTransfer() {
     memset(_filename, 0, sizeof(_filename));
     memset(_folder, 0, sizeof(_folder));
     memset(_fullPath, 0, sizeof(_fullPath));
+    memset(_partPath, 0, sizeof(_partPath));
+    _resumable = false;
+    _checkCrc = false;
     portEXIT_CRITICAL(&_state_mux);
 }
 
@@ -839,7 +927,7 @@ void cancelTransfer() {
     Serial.println("[BLE-FT] Cancelled");
     if (_file.isOpen()) {
         _file.close();
-        SdMan.remove(_fullPath);
+        SdMan.remove(writePath());
     }
     storeResult(false, 0, "Cancelled");
     sendStatus("{\"state\":\"cancelled\"}");
@@ -6,6 +6,11 @@
 #include <SdFat.h>
 #include <Serialization.h>
 
+#include <cctype>
+#include <cstdio>
+#include <cstdlib>
+#include <cstring>
+#include <limits>
 #include <type_traits>
 
 #include "../FontManager.h"
@@ -221,6 +226,124 @@ uint8_t computeSettingsCount() {
   return v.count;
 }
 
+// Rows that record where the firmware was rather than what the user
+// chose. forEachField and setField leave them out: copying them between
+// devices would reopen a book or a folder that may not exist there.
+bool isInternalField(const char* name) {
+  static const char* const internal[] = {
+      "_reserved", "lastBookPath", "pendingTransition", "transitionReturnTo",
+      "fileListDir", "fileListSelectedName", "fileListSelectedIndex",
+  };
+  for (const char* n : internal) {
+    if (strcmp(n, name) == 0) return true;
+  }
+  return false;
+}
+
+// Formats each user-facing field as text for Settings::forEachField.
+struct FormatVisitor {
+  void (*fn)(const char*, const char*, void*);
+  void* ctx;
+
+  template <typename T>
+  void operator()(const char* name, T& field, T) {
+    (*this)(name, field);
+  }
+  template <typename T>
+  void operator()(const char* name, T& field) {
+    if (isInternalField(name)) return;
+    char text[12];
+    snprintf(text, sizeof(text), "%ld", static_cast<long>(field));
+    fn(name, text, ctx);
+  }
+  template <typename T, size_t N>
+  void operator()(const char* name, T (&field)[N]) {
+    if (isInternalField(name)) return;
+    if (std::is_same<T, char>::value) {
+      fn(name, reinterpret_cast<const char*>(field), ctx);
+      return;
+    }
+    // Byte arrays are short (hiddenPluginMask is 3 bytes); 8 covers them.
+    char text[2 * 8 + 1] = "";
+    const auto* bytes = reinterpret_cast<const uint8_t*>(field);
+    for (size_t i = 0; i < N * sizeof(T) && i < 8; i++) {
+      snprintf(text + 2 * i, 3, "%02x", bytes[i]);
+    }
+    fn(name, text, ctx);
+  }
+};
+
+// Parses a value into the field called `key`, with the same bounds the
+// loader applies. `err` stays FileNotFound if no field has that name.
+struct SetVisitor {
+  const char* key;
+  const char* value;
+  Error err = Error::FileNotFound;
+
+  SetVisitor(const char* k, const char* v) : key(k), value(v) {}
+
+  bool parse(long& out) const {
+    char* end = nullptr;
+    out = strtol(value, &end, 10);
+    return value[0] != '\0' && *end == '\0';
+  }
+
+  template <typename T>
+  void operator()(const char* name, T& field, T maxValue) {
+    if (strcmp(name, key) != 0 || isInternalField(name)) return;
+    long v = 0;
+    if (!parse(v) || v < 0 || v >= static_cast<long>(maxValue)) {
+      err = Error::InvalidFormat;
+      return;
+    }
+    field = static_cast<T>(v);
+    err = Error::None;
+  }
+  template <typename T>
+  void operator()(const char* name, T& field) {
+    if (strcmp(name, key) != 0 || isInternalField(name)) return;
+    long v = 0;
+    if (!parse(v) || v < static_cast<long>(std::numeric_limits<T>::min()) ||
+        v > static_cast<long>(std::numeric_limits<T>::max())) {
+      err = Error::InvalidFormat;
+      return;
+    }
+    field = static_cast<T>(v);
+    err = Error::None;
+  }
+  template <typename T, size_t N>
+  void operator()(const char* name, T (&field)[N]) {
+    if (strcmp(name, key) != 0 || isInternalField(name)) return;
+    const size_t len = strlen(value);
+    if (std::is_same<T, char>::value) {
+      if (len >= N) {
+        err = Error::InvalidFormat;
+        return;
+      }
+      memcpy(field, value, len + 1);
+      err = Error::None;
+      return;
+    }
+    // Byte arrays: exactly two hex digits per byte.
+    if (len != 2 * N * sizeof(T)) {
+      err = Error::InvalidFormat;
+      return;
+    }
+    for (size_t i = 0; i < len; i++) {
+      if (!isxdigit(static_cast<unsigned char>(value[i]))) {
+        err = Error::InvalidFormat;
+        return;
+      }
+    }
+    auto* bytes = reinterpret_cast<uint8_t*>(field);
+    for (size_t i = 0; i < N * sizeof(T); i++) {
+      const char digits[3] = {value[2 * i], value[2 * i + 1], '\0'};
+      bytes[i] = static_cast<uint8_t>(strtoul(digits, nullptr, 16));
+    }
+    err = Error::None;
+  }
+};
+
 }  // namespace
 
 Result<void> Settings::save(drivers::Storage& storage) const {
@@ -555,4 +678,16 @@ bool Settings::loadFromFile() {
   return true;
 }
 
+void Settings::forEachField(void (*fn)(const char* name, const char* value, void* ctx), void* ctx) const {
+  FormatVisitor visitor{fn, ctx};
+  forEachSettingsField(const_cast<Settings&>(*this), visitor);
+}
+
+Result<void> Settings::setField(const char* name, const char* value) {
+  SetVisitor visitor(name, value);
+  forEachSettingsField(*this, visitor);
+  if (visitor.err != Error::None) return ErrVoid(visitor.err);
+  return Ok();
+}
+
 }  // namespace sumi
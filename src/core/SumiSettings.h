@@ -167,6 +167,16 @@ struct Settings {
   bool loadFromFile();
   bool saveToFile() const;
 
+  // Access by the names in the file schema (SumiSettings.cpp), for the
+  // serial "config" command. Numbers are decimal, strings are as-is and
+  // byte arrays are hex. Fields the firmware keeps for itself (last book,
+  // file browser position, boot transitions) are not listed or settable.
+  // setField returns Error::FileNotFound for an unknown name and
+  // Error::InvalidFormat for a value out of the field's range; it does
+  // not save.
+  void forEachField(void (*fn)(const char* name, const char* value, void* ctx), void* ctx) const;
+  Result<void> setField(const char* name, const char* value);
+
   // Computed values
   uint16_t getPowerButtonDuration() const { return (shortPwrBtn == PowerSleep) ? 10 : 400; }
 
@@ -121,6 +121,24 @@ void Input::checkButton(Button btn, uint8_t mask) {
   }
 }
 
+bool Input::inject(Button btn, bool longPress) {
+  if (!initialized_ || !queue_) {
+    return false;
+  }
+  const size_t needed = longPress ? 3 : 2;
+  // The ring buffer keeps one slot empty to tell full from empty.
+  if (queue_->size() + needed > EventQueue::CAPACITY - 1) {
+    return false;
+  }
+  queue_->push(Event::buttonPress(btn));
+  if (longPress) {
+    queue_->push(Event::buttonLongPress(btn));
+  }
+  queue_->push(Event::buttonRelease(btn));
+  lastActivityMs_ = millis();
+  return true;
+}
+
 uint32_t Input::idleTimeMs() const { return millis() - lastActivityMs_; }
 
 void Input::markActivity() { lastActivityMs_ = millis(); }
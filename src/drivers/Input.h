@@ -31,6 +31,12 @@ class Input {
   // timer from firing immediately after they finish.
   void markActivity();
 
+  // Queue the events of a press of `btn` as if it had been pressed and
+  // released, with a long press in between if `longPress`. For remote
+  // control over serial (sumi press); isPressed() doesn't see these.
+  // Returns false, queuing nothing, if the event queue has no room.
+  bool inject(Button btn, bool longPress);
+
   // Direct state queries (for hold detection)
   bool isPressed(Button btn) const;
 
@@ -58,6 +58,8 @@
 #include "states/SleepState.h"
 #include "states/StartupState.h"
 #include "ui/views/BootSleepViews.h"
+#include "util/SerialCommands.h"
+#include "util/SerialScreenshot.h"
 
 // Plugin system
 #if FEATURE_PLUGINS
@@ -497,6 +499,9 @@ bool earlyInit() {
   pinMode(UART0_RXD, INPUT_PULLDOWN);
   gpio_deep_sleep_hold_dis();  // Release GPIO hold from deep sleep to allow fresh readings
   if (isUsbConnected()) {
+    // Room for a whole SerialCommands record, so a busy loop doesn't drop
+    // the tail of a file chunk.
+    Serial.setRxBufferSize(1024);
     Serial.begin(115200);
     delay(SERIAL_INIT_DELAY_MS);  // Allow USB CDC to initialize
     unsigned long start = millis();
@@ -1194,7 +1199,6 @@ void loop() {
       const int rowBytes = (W + 31) / 32 * 4;  // BMP row stride (4-byte aligned)
       const int imageSize = rowBytes * H;
       const int fileSize = 62 + imageSize;  // 14 (file hdr) + 40 (info hdr) + 8 (palette) + image
-      const int physRowBytes = physW / 8;   // bytes per row of physical framebuffer
 
       FsFile f;
       if (SdMan.openFileForWrite("SCR", path, f)) {
@@ -1221,20 +1225,15 @@ void loop() {
         // Max rowBytes on any supported panel is 792/8=99 bytes + padding = 100.
         uint8_t row[100];
         for (int outY = H - 1; outY >= 0; outY--) {
-          memset(row, 0, sizeof(row));
-          for (int outX = 0; outX < W; outX++) {
-            // Map portrait (outX, outY) → physical (inX, inY)
-            int inX = outY;               // portrait Y maps to physical X
-            int inY = W - 1 - outX;       // portrait X maps to inverted physical Y
-            int srcByte = inY * physRowBytes + (inX / 8);
-            int srcBit = 7 - (inX % 8);
-            int pixel = (fb[srcByte] >> srcBit) & 1;
-            if (pixel) row[outX / 8] |= (0x80 >> (outX % 8));  // BMP MSB-first
-          }
+          memset(row, 0, sizeof(row));  // keeps the 4-byte stride padding zero
+          sumi::SerialScreenshot::portraitRow(fb, physW, physH, outY, row);
           f.write(row, rowBytes);
         }
         SdMan.syncAndClose(f);
         Serial.printf("[%lu] [SCR] Screenshot saved: %s\n", millis(), path);
+        // With a host attached, send the same image over serial as well so
+        // tools/monitor can save it without pulling the SD card.
+        sumi::SerialScreenshot::send(fb, physW, physH);
 
         // Brief on-screen confirmation banner. User reported Back+Up
         // appearing to do nothing because there was no visual cue —
@@ -1252,9 +1251,20 @@ void loop() {
     }
   }
 
+  // Host commands (tools/device) and host-requested screenshots
+  // (tools/monitor -screenshot, ~s or S in -tui). Nothing else reads
+  // Serial, so the command parser owns the RX side.
+  if (Serial && sumi::SerialCommands::poll()) {
+    sumi::SerialScreenshot::send(einkDisplay.getFrameBuffer(), einkDisplay.getDisplayWidth(),
+                                 einkDisplay.getDisplayHeight());
+  }
+
   if (Serial && millis() - lastMemPrint >= 10000) {
     Serial.printf("[%lu] [MEM] Free: %d bytes, Total: %d bytes, Min Free: %d bytes\n", millis(), ESP.getFreeHeap(),
                   ESP.getHeapSize(), ESP.getMinFreeHeap());
+    const uint16_t millivolts = batteryMonitor.readMillivolts();
+    Serial.printf("[%lu] [BAT] Battery: %u mV, %u%%\n", millis(), millivolts,
+                  BatteryMonitor::percentageFromMillivolts(millivolts));
     lastMemPrint = millis();
   }
 
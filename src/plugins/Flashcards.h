@@ -170,6 +170,11 @@ public:
     // Statistics
     FlashcardStats stats;
     DeckMetadataFile deckMeta;
+
+    // True while the app is open. It saves the stats and deck metadata it
+    // loaded when it closes, so sumi decks won't write them until then
+    // (util/SerialDecks.cpp).
+    static inline bool running = false;
     
     // Local settings (replaces settingsManager.flashcards)
     uint8_t cfgFontSize = 1;
@@ -196,6 +201,7 @@ public:
         closeDeck();
         saveStats();
         saveDeckMeta();
+        running = false;
     }
     
     // ==========================================================================
@@ -204,6 +210,7 @@ public:
     void init(int w, int h) override {
         screenW = w;
         screenH = h;
+        running = true;
         
         loadStats();
         loadDeckMeta();
@@ -0,0 +1,219 @@
+#include "SerialCommands.h"
+
+#include <Arduino.h>
+
+#include <cstdarg>
+#include <cstring>
+
+#include "SerialScreenshot.h"
+
+namespace sumi {
+namespace SerialCommands {
+
+namespace {
+
+// Bumped when a verb changes incompatibly; tools/device checks it.
+constexpr int PROTOCOL_VERSION = 1;
+
+constexpr char B64[] = "ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz0123456789+/";
+
+int b64Value(char c) {
+  const char* p = strchr(B64, c);
+  return c != '\0' && p ? (int)(p - B64) : -1;
+}
+
+int hexValue(char c) {
+  if (c >= '0' && c <= '9') return c - '0';
+  if (c >= 'a' && c <= 'f') return c - 'a' + 10;
+  if (c >= 'A' && c <= 'F') return c - 'A' + 10;
+  return -1;
+}
+
+// Decode %XX escapes in place.
+void decodeArg(char* s) {
+  char* out = s;
+  for (const char* in = s; *in; in++) {
+    int hi, lo;
+    if (in[0] == '%' && (hi = hexValue(in[1])) >= 0 && (lo = hexValue(in[2])) >= 0) {
+      *out++ = (char)(hi << 4 | lo);
+      in += 2;
+    } else {
+      *out++ = *in;
+    }
+  }
+  *out = '\0';
+}
+
+void reply(const Request& req, const char* kind, const char* fmt, va_list ap) {
+  char text[MAX_RECORD];
+  vsnprintf(text, sizeof(text), fmt, ap);
+  Serial.printf("\x1b_SUMI %s %s%s%s\x1b\\\n", req.id, kind, text[0] ? " " : "", text);
+}
+
+void run(Request& req) {
+  if (strcmp(req.verb, "ping") == 0) {
+    ok(req, "protocol=%d version=%s", PROTOCOL_VERSION, SUMI_VERSION);
+    return;
+  }
+  if (handleFiles(req)) return;
+  if (handleStats(req)) return;
+  if (handleSettings(req)) return;
+  if (handleStatus(req)) return;
+  if (handleInput(req)) return;
+  if (handleTime(req)) return;
+  if (handleDecks(req)) return;
+  fail(req, "unknown command %s", req.verb);
+}
+
+// Split a record body, "SUMI <id> <verb> <args...>", and run it.
+void dispatch(char* body) {
+  if (strncmp(body, "SUMI ", 5) != 0) return;
+  Request req = {};
+  char* save = nullptr;
+  req.id = strtok_r(body + 5, " ", &save);
+  req.verb = strtok_r(nullptr, " ", &save);
+  if (!req.id || !req.verb) return;
+  char* arg;
+  while ((arg = strtok_r(nullptr, " ", &save))) {
+    if (req.argc == MAX_ARGS) {
+      fail(req, "too many arguments");
+      return;
+    }
+    decodeArg(arg);
+    req.args[req.argc++] = arg;
+  }
+  run(req);
+}
+
+}  // namespace
+
+void item(const Request& req, const char* fmt, ...) {
+  va_list ap;
+  va_start(ap, fmt);
+  reply(req, "item", fmt, ap);
+  va_end(ap);
+}
+
+void ok(const Request& req, const char* fmt, ...) {
+  va_list ap;
+  va_start(ap, fmt);
+  reply(req, "ok", fmt, ap);
+  va_end(ap);
+}
+
+void fail(const Request& req, const char* fmt, ...) {
+  va_list ap;
+  va_start(ap, fmt);
+  reply(req, "err", fmt, ap);
+  va_end(ap);
+}
+
+bool encodeArg(const char* in, char* out, size_t outSize) {
+  size_t o = 0;
+  for (; *in; in++) {
+    const unsigned char c = (unsigned char)*in;
+    if (c <= ' ' || c == '%' || c == 0x7f) {
+      if (o + 3 >= outSize) return false;
+      snprintf(out + o, 4, "%%%02X", c);
+      o += 3;
+    } else {
+      if (o + 1 >= outSize) return false;
+      out[o++] = (char)c;
+    }
+  }
+  out[o] = '\0';
+  return true;
+}
+
+void encodeBase64(const uint8_t* in, size_t len, char* out) {
+  size_t o = 0;
+  for (size_t i = 0; i < len; i += 3) {
+    const uint32_t n = (uint32_t)in[i] << 16 | (i + 1 < len ? (uint32_t)in[i + 1] << 8 : 0) |
+                       (i + 2 < len ? in[i + 2] : 0);
+    out[o++] = B64[(n >> 18) & 63];
+    out[o++] = B64[(n >> 12) & 63];
+    out[o++] = i + 1 < len ? B64[(n >> 6) & 63] : '=';
+    out[o++] = i + 2 < len ? B64[n & 63] : '=';
+  }
+  out[o] = '\0';
+}
+
+int decodeBase64(const char* in, uint8_t* out, size_t outSize) {
+  const size_t len = strlen(in);
+  if (len % 4 != 0) return -1;
+  size_t o = 0;
+  for (size_t i = 0; i < len; i += 4) {
+    int v[4];
+    int pad = 0;
+    for (int j = 0; j < 4; j++) {
+      if (in[i + j] == '=' && i + 4 == len && j >= 2) {
+        v[j] = 0;
+        pad++;
+      } else if (pad > 0 || (v[j] = b64Value(in[i + j])) < 0) {
+        return -1;
+      }
+    }
+    const uint32_t n = (uint32_t)v[0] << 18 | (uint32_t)v[1] << 12 | (uint32_t)v[2] << 6 | (uint32_t)v[3];
+    const uint8_t bytes[3] = {(uint8_t)(n >> 16), (uint8_t)(n >> 8), (uint8_t)n};
+    for (int j = 0; j < 3 - pad; j++) {
+      if (o == outSize) return -1;
+      out[o++] = bytes[j];
+    }
+  }
+  return (int)o;
+}
+
+bool poll() {
+  // Records are ESC _ <body> ESC \; anything between them is ignored.
+  // An over-long record is dropped whole, as the host will time out and
+  // retry with a shorter one anyway.
+  static char body[MAX_RECORD];
+  static size_t len = 0;
+  static enum { IDLE, OPENING, BODY, CLOSING } state = IDLE;
+  static bool overflow = false;
+  bool screenshot = false;
+  while (Serial.available() > 0) {
+    const char c = (char)Serial.read();
+    switch (state) {
+      case IDLE:
+        if (c == '\x1b') state = OPENING;
+        break;
+      case OPENING:
+        state = c == '_' ? BODY : (c == '\x1b' ? OPENING : IDLE);
+        len = 0;
+        overflow = false;
+        break;
+      case BODY:
+        if (c == '\x1b') {
+          state = CLOSING;
+        } else if (len + 1 < sizeof(body)) {
+          body[len++] = c;
+        } else {
+          overflow = true;
+        }
+        break;
+      case CLOSING:
+        if (c != '\\') {
+          // A bare ESC inside a record: start over from it.
+          state = c == '_' ? BODY : IDLE;
+          len = 0;
+          overflow = false;
+          break;
+        }
+        state = IDLE;
+        body[len] = '\0';
+        if (overflow) break;
+        // REQUEST without its ESC _ and ESC \.
+        if (len == strlen(SerialScreenshot::REQUEST) - 4 && memcmp(body, SerialScreenshot::REQUEST + 2, len) == 0) {
+          screenshot = true;
+        } else {
+          dispatch(body);
+        }
+        break;
+    }
+  }
+  return screenshot;
+}
+
+}  // namespace SerialCommands
+}  // namespace sumi
@@ -0,0 +1,76 @@
+#pragma once
+
+#include <cstddef>
+#include <cstdint>
+
+namespace sumi {
+
+/**
+ * Host commands over the USB serial port, for tools/device. Like the
+ * screenshot records (SerialScreenshot.h), requests and replies are APC
+ * escape records, one per line, so terminals and tools/monitor hide them
+ * among the log lines:
+ *
+ *   host:   ESC _ SUMI <id> <verb> <args...> ESC \
+ *   device: ESC _ SUMI <id> item <fields...> ESC \     (zero or more)
+ *   device: ESC _ SUMI <id> ok <fields...> ESC \
+ *   device: ESC _ SUMI <id> err <message> ESC \
+ *
+ * <id> is chosen by the host and echoed back, so a reply to an earlier,
+ * abandoned request is never taken for the current one. Arguments and
+ * fields are separated by single spaces; paths and names are
+ * percent-encoded (%20 for a space), and file data travels as base64
+ * followed by the CRC-32 of the decoded bytes, in hex.
+ *
+ * The host side is tools/device/protocol.go.
+ */
+namespace SerialCommands {
+
+// Longest request record accepted, escape bytes included.
+constexpr size_t MAX_RECORD = 800;
+
+// Most file bytes one read or write request carries.
+constexpr size_t CHUNK = 512;
+
+constexpr int MAX_ARGS = 6;
+
+struct Request {
+  const char* id;
+  const char* verb;
+  char* args[MAX_ARGS];
+  int argc;
+};
+
+// Reply with a list item, success, or failure. Every request gets exactly
+// one ok or err.
+void item(const Request& req, const char* fmt, ...) __attribute__((format(printf, 2, 3)));
+void ok(const Request& req, const char* fmt = "", ...) __attribute__((format(printf, 2, 3)));
+void fail(const Request& req, const char* fmt, ...) __attribute__((format(printf, 2, 3)));
+
+// Percent-encode `in` into `out` (outSize bytes), escaping spaces, '%' and
+// control characters. Returns false if it does not fit.
+bool encodeArg(const char* in, char* out, size_t outSize);
+
+// Base64 helpers for file data and screenshot rows. encodeBase64's `out`
+// must hold 4 * ceil(len / 3) + 1. decodeBase64 returns the decoded length,
+// or -1 if `in` is not base64 or does not fit in outSize.
+void encodeBase64(const uint8_t* in, size_t len, char* out);
+int decodeBase64(const char* in, uint8_t* out, size_t outSize);
+
+// Consume pending Serial input and run any complete commands. Returns true
+// if the host asked for a screenshot (SerialScreenshot::REQUEST), which the
+// caller sends. Call once per loop.
+bool poll();
+
+// Verb handlers, by area. Each returns false if it does not know the verb.
+bool handleFiles(const Request& req);
+bool handleStats(const Request& req);
+bool handleSettings(const Request& req);
+bool handleStatus(const Request& req);
+bool handleInput(const Request& req);
+bool handleTime(const Request& req);
+bool handleDecks(const Request& req);
+
+}  // namespace SerialCommands
+
+}  // namespace sumi
@@ -0,0 +1,40 @@
+// Flashcards commands for SerialCommands, for sumi decks:
+//
+//   decks    ok open=<0|1>
+//
+// open is 1 while the Flashcards app is open. It writes back the stats
+// and deck metadata it loaded when it closes, so sumi decks push waits
+// for it rather than have its merged history overwritten. The decks
+// themselves, /flashcards, and those two files in /.sumi move with the
+// file commands.
+
+#include <Arduino.h>
+
+#include <cstring>
+
+#include "../config.h"
+#include "SerialCommands.h"
+
+#if FEATURE_PLUGINS && FEATURE_FLASHCARDS
+#include "../plugins/Flashcards.h"
+#endif
+
+namespace sumi {
+namespace SerialCommands {
+
+bool handleDecks(const Request& req) {
+  if (strcmp(req.verb, "decks") != 0) return false;
+  if (req.argc != 0) {
+    fail(req, "bad arguments");
+    return true;
+  }
+#if FEATURE_PLUGINS && FEATURE_FLASHCARDS
+  ok(req, "open=%d", FlashcardsApp::running ? 1 : 0);
+#else
+  fail(req, "no flashcards in this build");
+#endif
+  return true;
+}
+
+}  // namespace SerialCommands
+}  // namespace sumi
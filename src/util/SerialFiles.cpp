@@ -0,0 +1,262 @@
+// SD card file commands for SerialCommands: ls, stat, read, put, write,
+// done, abort, rm, mkdir and sum. Uploads go through the atomic-write
+// protocol (docs/ATOMIC_WRITE_DESIGN.md), so an interrupted "put" never
+// leaves half a book where the old one was.
+
+#include <Arduino.h>
+#include <Crc32.h>
+#include <SDCardManager.h>
+
+#include <cstdlib>
+#include <cstring>
+
+#include "SerialCommands.h"
+
+namespace sumi {
+namespace SerialCommands {
+
+namespace {
+
+// The file being read, kept open across "read" requests for the same path.
+FsFile readFile;
+char readPath[256] = {0};
+
+// The upload in progress, if any.
+FsFile writeFile;
+char writePath[256] = {0};
+uint32_t writeSize = 0;
+uint32_t writePos = 0;
+Crc32 writeCrc;
+
+bool parseU32(const char* s, uint32_t& out, int base = 10) {
+  char* end = nullptr;
+  const unsigned long v = strtoul(s, &end, base);
+  if (!s[0] || *end) return false;
+  out = (uint32_t)v;
+  return true;
+}
+
+bool validPath(const Request& req, const char* path) {
+  if (path[0] != '/' || strlen(path) >= sizeof(readPath)) {
+    fail(req, "bad path");
+    return false;
+  }
+  return true;
+}
+
+void closeRead() {
+  if (readFile) readFile.close();
+  readPath[0] = '\0';
+}
+
+void abortUpload() {
+  if (writePath[0]) {
+    SdMan.atomicAbort(writeFile, writePath);
+    Serial.printf("[%lu] [SER] Upload of %s abandoned at %lu bytes\n", millis(), writePath, (unsigned long)writePos);
+  }
+  writePath[0] = '\0';
+}
+
+void listDir(const Request& req, const char* path) {
+  FsFile dir = SdMan.open(path);
+  if (!dir || !dir.isDirectory()) {
+    fail(req, "not a directory");
+    return;
+  }
+  char name[256];
+  char encoded[3 * sizeof(name)];
+  FsFile entry;
+  while ((entry = dir.openNextFile())) {
+    entry.getName(name, sizeof(name));
+    if (encodeArg(name, encoded, sizeof(encoded))) {
+      item(req, "%c %lu %s", entry.isDirectory() ? 'd' : 'f', (unsigned long)entry.fileSize(), encoded);
+    }
+    entry.close();
+  }
+  dir.close();
+  ok(req);
+}
+
+void statPath(const Request& req, const char* path) {
+  FsFile f = SdMan.open(path);
+  if (!f) {
+    fail(req, "not found");
+    return;
+  }
+  ok(req, "%c %lu", f.isDirectory() ? 'd' : 'f', (unsigned long)(f.isDirectory() ? 0 : f.fileSize()));
+  f.close();
+}
+
+void readChunk(const Request& req, const char* path, uint32_t offset, uint32_t len) {
+  if (strcmp(path, readPath) != 0) {
+    closeRead();
+    if (!SdMan.openFileForRead("SER", path, readFile)) {
+      fail(req, "not found");
+      return;
+    }
+    strcpy(readPath, path);
+  }
+  uint8_t buf[CHUNK];
+  if (len > CHUNK) len = CHUNK;
+  int n = 0;
+  if (offset < readFile.fileSize()) {
+    if (!readFile.seekSet(offset) || (n = readFile.read(buf, len)) < 0) {
+      closeRead();
+      fail(req, "read failed");
+      return;
+    }
+  }
+  char text[CHUNK / 3 * 4 + 5];
+  if (n == 0) {
+    strcpy(text, "-");
+  } else {
+    encodeBase64(buf, n, text);
+  }
+  ok(req, "%d %s %08lx", n, text, (unsigned long)crc32(buf, n));
+}
+
+void beginUpload(const Request& req, const char* path, uint32_t size) {
+  abortUpload();
+  if (strcmp(path, readPath) == 0) closeRead();
+  if (!SdMan.atomicOpenWrite("SER", path, writeFile)) {
+    fail(req, "cannot create file");
+    return;
+  }
+  strcpy(writePath, path);
+  writeSize = size;
+  writePos = 0;
+  writeCrc.reset();
+  ok(req);
+}
+
+void writeChunk(const Request& req, uint32_t offset, const char* data, uint32_t crc) {
+  if (!writePath[0]) {
+    fail(req, "no upload in progress");
+    return;
+  }
+  if (offset != writePos) {
+    // The host resends from where the device is, e.g. after a lost reply.
+    fail(req, "at %lu", (unsigned long)writePos);
+    return;
+  }
+  uint8_t buf[CHUNK];
+  const int n = decodeBase64(data, buf, sizeof(buf));
+  if (n < 0 || crc32(buf, n) != crc) {
+    fail(req, "bad chunk");
+    return;
+  }
+  if (writePos + n > writeSize || writeFile.write(buf, n) != (size_t)n) {
+    abortUpload();
+    fail(req, "write failed");
+    return;
+  }
+  writeCrc.update(buf, n);
+  writePos += n;
+  ok(req, "%lu", (unsigned long)writePos);
+}
+
+void finishUpload(const Request& req, uint32_t crc) {
+  if (!writePath[0]) {
+    fail(req, "no upload in progress");
+    return;
+  }
+  if (writePos != writeSize || writeCrc.finalize() != crc) {
+    abortUpload();
+    fail(req, "file does not match");
+    return;
+  }
+  const bool committed = SdMan.atomicCommit(writeFile, writePath);
+  if (committed) {
+    Serial.printf("[%lu] [SER] Received %s (%lu bytes)\n", millis(), writePath, (unsigned long)writeSize);
+  }
+  writePath[0] = '\0';
+  if (committed) {
+    ok(req);
+  } else {
+    fail(req, "commit failed");
+  }
+}
+
+void removePath(const Request& req, const char* path) {
+  if (strcmp(path, readPath) == 0) closeRead();
+  FsFile f = SdMan.open(path);
+  if (!f) {
+    fail(req, "not found");
+    return;
+  }
+  const bool dir = f.isDirectory();
+  f.close();
+  if (!(dir ? SdMan.rmdir(path) : SdMan.remove(path))) {
+    fail(req, dir ? "directory not empty" : "remove failed");
+    return;
+  }
+  ok(req);
+}
+
+void checksum(const Request& req, const char* path) {
+  closeRead();
+  FsFile f;
+  if (!SdMan.openFileForRead("SER", path, f)) {
+    fail(req, "not found");
+    return;
+  }
+  Crc32 crc;
+  uint8_t buf[CHUNK];
+  int n;
+  while ((n = f.read(buf, sizeof(buf))) > 0) {
+    crc.update(buf, n);
+  }
+  ok(req, "%lu %08lx", (unsigned long)f.fileSize(), (unsigned long)crc.finalize());
+  f.close();
+}
+
+}  // namespace
+
+bool handleFiles(const Request& req) {
+  const char* verb = req.verb;
+  const int argc = req.argc;
+  char* const* a = req.args;
+  uint32_t x = 0, y = 0;
+
+  if (strcmp(verb, "ls") == 0 && argc == 1) {
+    if (validPath(req, a[0])) listDir(req, a[0]);
+  } else if (strcmp(verb, "stat") == 0 && argc == 1) {
+    if (validPath(req, a[0])) statPath(req, a[0]);
+  } else if (strcmp(verb, "read") == 0 && argc == 3 && parseU32(a[1], x) && parseU32(a[2], y)) {
+    if (validPath(req, a[0])) readChunk(req, a[0], x, y);
+  } else if (strcmp(verb, "put") == 0 && argc == 2 && parseU32(a[1], x)) {
+    if (validPath(req, a[0])) beginUpload(req, a[0], x);
+  } else if (strcmp(verb, "write") == 0 && argc == 3 && parseU32(a[0], x) && parseU32(a[2], y, 16)) {
+    writeChunk(req, x, a[1], y);
+  } else if (strcmp(verb, "done") == 0 && argc == 1 && parseU32(a[0], x, 16)) {
+    finishUpload(req, x);
+  } else if (strcmp(verb, "abort") == 0 && argc == 0) {
+    abortUpload();
+    ok(req);
+  } else if (strcmp(verb, "rm") == 0 && argc == 1) {
+    if (validPath(req, a[0])) removePath(req, a[0]);
+  } else if (strcmp(verb, "mkdir") == 0 && argc == 1) {
+    if (validPath(req, a[0])) {
+      if (SdMan.mkdir(a[0])) {
+        ok(req);
+      } else {
+        fail(req, "mkdir failed");
+      }
+    }
+  } else if (strcmp(verb, "sum") == 0 && argc == 1) {
+    if (validPath(req, a[0])) checksum(req, a[0]);
+  } else {
+    static const char* const VERBS[] = {"ls", "stat", "read", "put", "write", "done", "abort", "rm", "mkdir", "sum"};
+    for (const char* v : VERBS) {
+      if (strcmp(verb, v) == 0) {
+        fail(req, "bad arguments");
+        return true;
+      }
+    }
+    return false;
+  }
+  return true;
+}
+
+}  // namespace SerialCommands
+}  // namespace sumi
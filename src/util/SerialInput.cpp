@@ -0,0 +1,61 @@
+// Input commands for SerialCommands, for sumi press:
+//
+//   press <button> [long]   queues a press and release of the button
+//
+// Buttons are up, down, left, right, confirm, back and power, by what they
+// do, so the front button layout setting doesn't change which is which.
+// The press goes through the same event queue as the real buttons; it is
+// acted on by the next loop, after the ok.
+
+#include <Arduino.h>
+
+#include <cstring>
+
+#include "../core/Core.h"
+#include "SerialCommands.h"
+
+namespace sumi {
+namespace SerialCommands {
+
+namespace {
+
+struct ButtonName {
+  const char* name;
+  Button button;
+};
+
+constexpr ButtonName BUTTONS[] = {
+    {"up", Button::Up},
+    {"down", Button::Down},
+    {"left", Button::Left},
+    {"right", Button::Right},
+    {"confirm", Button::Center},
+    {"back", Button::Back},
+    {"power", Button::Power},
+};
+
+}  // namespace
+
+bool handleInput(const Request& req) {
+  if (strcmp(req.verb, "press") != 0) return false;
+  const bool longPress = req.argc == 2 && strcmp(req.args[1], "long") == 0;
+  if (req.argc != 1 && !longPress) {
+    fail(req, "bad arguments");
+    return true;
+  }
+  for (const ButtonName& b : BUTTONS) {
+    if (strcmp(req.args[0], b.name) != 0) continue;
+    if (!core.input.inject(b.button, longPress)) {
+      fail(req, "busy");
+      return true;
+    }
+    Serial.printf("[%lu] [SER] Pressed %s%s\n", millis(), b.name, longPress ? " (long)" : "");
+    ok(req);
+    return true;
+  }
+  fail(req, "unknown button");
+  return true;
+}
+
+}  // namespace SerialCommands
+}  // namespace sumi
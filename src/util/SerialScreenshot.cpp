@@ -0,0 +1,71 @@
+#include "SerialScreenshot.h"
+
+#include <Arduino.h>
+#include <Crc32.h>
+
+#include <cstring>
+
+#include "SerialCommands.h"
+
+namespace sumi {
+namespace SerialScreenshot {
+
+namespace {
+
+// 48 bytes encode to exactly 64 base64 characters, keeping records short
+// enough for any line-buffered reader.
+constexpr size_t CHUNK = 48;
+
+}  // namespace
+
+void portraitRow(const uint8_t* fb, int physW, int physH, int outY, uint8_t* row) {
+  const int W = physH;  // portrait width comes from physical height
+  const int physRowBytes = physW / 8;
+  memset(row, 0, (W + 7) / 8);
+  for (int outX = 0; outX < W; outX++) {
+    // Map portrait (outX, outY) → physical (inX, inY)
+    const int inX = outY;          // portrait Y maps to physical X
+    const int inY = W - 1 - outX;  // portrait X maps to inverted physical Y
+    const int pixel = (fb[inY * physRowBytes + inX / 8] >> (7 - inX % 8)) & 1;
+    if (pixel) row[outX / 8] |= (0x80 >> (outX % 8));
+  }
+}
+
+void send(const uint8_t* fb, int physW, int physH) {
+  if (!Serial) return;
+  const int W = physH;
+  const int H = physW;
+  const int rowBytes = (W + 7) / 8;  // max 528/8 = 66 on X3
+  const uint32_t size = (uint32_t)rowBytes * H;
+
+  Serial.printf("\x1b_SUMIFB begin w=%d h=%d bpp=1 size=%lu\x1b\\\n", W, H, (unsigned long)size);
+
+  // Rows are generated one at a time and streamed through a CHUNK-sized
+  // staging buffer, so no portrait copy of the framebuffer is needed.
+  uint8_t row[66];
+  uint8_t chunk[CHUNK];
+  char text[CHUNK / 3 * 4 + 1];
+  size_t fill = 0;
+  Crc32 crc;
+  for (int y = 0; y < H; y++) {
+    portraitRow(fb, physW, physH, y, row);
+    crc.update(row, rowBytes);
+    for (int i = 0; i < rowBytes; i++) {
+      chunk[fill++] = row[i];
+      if (fill == CHUNK) {
+        SerialCommands::encodeBase64(chunk, fill, text);
+        Serial.printf("\x1b_SUMIFB data %s\x1b\\\n", text);
+        fill = 0;
+      }
+    }
+  }
+  if (fill > 0) {
+    SerialCommands::encodeBase64(chunk, fill, text);
+    Serial.printf("\x1b_SUMIFB data %s\x1b\\\n", text);
+  }
+  Serial.printf("\x1b_SUMIFB end crc=%08lx\x1b\\\n", (unsigned long)crc.finalize());
+  Serial.printf("[%lu] [SCR] Screenshot sent over serial (%dx%d)\n", millis(), W, H);
+}
+
+}  // namespace SerialScreenshot
+}  // namespace sumi
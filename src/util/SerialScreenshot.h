@@ -0,0 +1,35 @@
+#pragma once
+
+#include <cstddef>
+#include <cstdint>
+
+namespace sumi {
+
+/**
+ * Software screenshots over the USB serial port, for when the Back+Up combo
+ * can't be pressed. The framebuffer is sent as APC escape records, one per
+ * line, which terminals hide and tools/monitor turns into a PNG:
+ *
+ *   ESC _ SUMIFB begin w=480 h=800 bpp=1 size=48000 ESC \
+ *   ESC _ SUMIFB data <base64, 48 bytes per record> ESC \
+ *   ESC _ SUMIFB end crc=<crc32 of the pixels, hex> ESC \
+ *
+ * Pixels are portrait, rotated like the SD card BMP, rows top to bottom,
+ * MSB first, 1 = white. The host asks for one by sending REQUEST, which
+ * SerialCommands::poll() picks out of the serial input.
+ * The host side is tools/monitor/screenshot.go.
+ */
+namespace SerialScreenshot {
+
+constexpr const char* REQUEST = "\x1b_SUMIFB shot\x1b\\";
+
+// Fill `row` with portrait row `outY` of the landscape framebuffer `fb`
+// (physW x physH). `row` must hold (physH + 7) / 8 bytes.
+void portraitRow(const uint8_t* fb, int physW, int physH, int outY, uint8_t* row);
+
+// Stream the framebuffer over Serial. No-op when no host is connected.
+void send(const uint8_t* fb, int physW, int physH);
+
+}  // namespace SerialScreenshot
+
+}  // namespace sumi
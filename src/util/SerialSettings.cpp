@@ -0,0 +1,95 @@
+// Settings commands for SerialCommands, for sumi config:
+//
+//   config                  item <name> <value> for every setting
+//   config get <name>       ok <value>
+//   config set <name> [v]   sets, saves and applies; no v means ""
+//
+// Names and bounds are the settings.bin schema (core/SumiSettings.cpp).
+// An empty value is sent as no field at all.
+
+#include <Arduino.h>
+#include <I18n.h>
+#include <SumiClock.h>
+
+#include <cstring>
+
+#include "../core/Core.h"
+#include "../ui/Elements.h"
+#include "SerialCommands.h"
+
+namespace sumi {
+namespace SerialCommands {
+
+namespace {
+
+struct Lookup {
+  const Request* req;
+  const char* name;  // null to list them all
+  bool found;
+};
+
+void reportField(const char* name, const char* value, void* ctx) {
+  auto* l = static_cast<Lookup*>(ctx);
+  if (l->name && strcmp(l->name, name) != 0) return;
+  char encoded[3 * 256];
+  if (!encodeArg(value, encoded, sizeof(encoded))) return;
+  if (l->name) {
+    ok(*l->req, "%s", encoded);
+  } else {
+    item(*l->req, "%s%s%s", name, encoded[0] ? " " : "", encoded);
+  }
+  l->found = true;
+}
+
+void setSetting(const Request& req, const char* name, const char* value) {
+  Settings& settings = core.settings;
+  const Result<void> result = settings.setField(name, value);
+  if (result.err == Error::FileNotFound) {
+    fail(req, "unknown setting");
+    return;
+  }
+  if (!result.ok()) {
+    fail(req, "bad value");
+    return;
+  }
+  if (!settings.save(core.storage).ok()) {
+    fail(req, "save failed");
+    return;
+  }
+  // What main.cpp applies at boot; most of the rest is read where it's
+  // used. The theme and reader font are loaded at boot only.
+  if (strcmp(name, "language") == 0) {
+    I18n::instance().setLanguage(static_cast<Language>(settings.language));
+  } else if (strcmp(name, "timeZoneOffsetMinutes") == 0) {
+    SumiClock::setTimeZoneOffsetMinutes(settings.timeZoneOffsetMinutes);
+  } else if (strcmp(name, "frontButtonLayout") == 0) {
+    ui::setFrontButtonLayout(settings.frontButtonLayout);
+  }
+  Serial.printf("[%lu] [SER] Setting %s changed\n", millis(), name);
+  ok(req);
+}
+
+}  // namespace
+
+bool handleSettings(const Request& req) {
+  if (strcmp(req.verb, "config") != 0) return false;
+  const int argc = req.argc;
+  char* const* a = req.args;
+  if (argc == 0) {
+    Lookup l = {&req, nullptr, false};
+    core.settings.forEachField(reportField, &l);
+    ok(req);
+  } else if (strcmp(a[0], "get") == 0 && argc == 2) {
+    Lookup l = {&req, a[1], false};
+    core.settings.forEachField(reportField, &l);
+    if (!l.found) fail(req, "unknown setting");
+  } else if (strcmp(a[0], "set") == 0 && (argc == 2 || argc == 3)) {
+    setSetting(req, a[1], argc == 3 ? a[2] : "");
+  } else {
+    fail(req, "bad arguments");
+  }
+  return true;
+}
+
+}  // namespace SerialCommands
+}  // namespace sumi
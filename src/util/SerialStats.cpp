@@ -0,0 +1,39 @@
+// Reading statistics commands for SerialCommands: "stats" reports whether
+// a reading session is open, and "stats reload" rereads
+// /.sumi/reading_stats.bin after the host has replaced it (sumi progress
+// import). The stats live in RAM between saves, so without the reload the
+// next session end would write the old figures back over the import.
+
+#include <Arduino.h>
+#include <ReadingStats.h>
+
+#include <cstring>
+
+#include "SerialCommands.h"
+
+extern sumi::ReadingStats readingStats;
+
+namespace sumi {
+namespace SerialCommands {
+
+bool handleStats(const Request& req) {
+  if (strcmp(req.verb, "stats") != 0) return false;
+  if (req.argc == 0) {
+    ok(req, "session=%d", readingStats.inSession() ? 1 : 0);
+  } else if (req.argc == 1 && strcmp(req.args[0], "reload") == 0) {
+    // A book's session, and its progress, are saved when it is closed.
+    if (readingStats.inSession()) {
+      fail(req, "a book is open");
+      return true;
+    }
+    readingStats.load();
+    Serial.printf("[%lu] [SER] Reading stats reloaded\n", millis());
+    ok(req);
+  } else {
+    fail(req, "bad arguments");
+  }
+  return true;
+}
+
+}  // namespace SerialCommands
+}  // namespace sumi
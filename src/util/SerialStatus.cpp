@@ -0,0 +1,67 @@
+// Status command for SerialCommands, for sumi status:
+//
+//   status    item <name> <value> for each figure below, then ok
+//
+// Names: version, uptime (ms), heap and heapmin (bytes), millivolts,
+// battery (%), sdtotal and sdfree (bytes), font (the reader font family,
+// or builtin), fonts (families loaded from the card), fontmem (bytes),
+// cjk (external font file) and book (the open book's path). A figure the
+// device can't give, such as cjk with no external font loaded, is left out.
+
+#include <Arduino.h>
+#include <SDCardManager.h>
+
+#include <cstring>
+
+#include "../Battery.h"
+#include "../FontManager.h"
+#include "../core/Core.h"
+#include "SerialCommands.h"
+
+namespace sumi {
+namespace SerialCommands {
+
+namespace {
+
+void textItem(const Request& req, const char* name, const char* value) {
+  char encoded[3 * 256];
+  if (value[0] && encodeArg(value, encoded, sizeof(encoded))) item(req, "%s %s", name, encoded);
+}
+
+}  // namespace
+
+bool handleStatus(const Request& req) {
+  if (strcmp(req.verb, "status") != 0) return false;
+  if (req.argc != 0) {
+    fail(req, "bad arguments");
+    return true;
+  }
+  item(req, "version %s", SUMI_VERSION);
+  item(req, "uptime %lu", millis());
+  item(req, "heap %lu", (unsigned long)ESP.getFreeHeap());
+  item(req, "heapmin %lu", (unsigned long)ESP.getMinFreeHeap());
+
+  const uint16_t millivolts = batteryMonitor.readMillivolts();
+  item(req, "millivolts %u", millivolts);
+  item(req, "battery %u", BatteryMonitor::percentageFromMillivolts(millivolts));
+
+  uint64_t total, unused;
+  if (SdMan.space(total, unused)) {
+    item(req, "sdtotal %llu", (unsigned long long)total);
+    item(req, "sdfree %llu", (unsigned long long)unused);
+  }
+
+  const FontManager& fonts = FONT_MANAGER;
+  textItem(req, "font", fonts.isUsingCustomReaderFont() ? core.settings.readerFont : "builtin");
+  item(req, "fonts %u", (unsigned)fonts.loadedFamilyCount());
+  item(req, "fontmem %u", (unsigned)fonts.getTotalFontMemoryUsage());
+  textItem(req, "cjk", fonts.externalFontName());
+
+  // ReaderState records the book it opens as the one to return to.
+  if (core.content.isOpen()) textItem(req, "book", core.settings.lastBookPath);
+  ok(req);
+  return true;
+}
+
+}  // namespace SerialCommands
+}  // namespace sumi
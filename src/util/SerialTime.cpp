@@ -0,0 +1,47 @@
+// Clock commands for SerialCommands, for sumi time:
+//
+//   time               ok epoch=<s> synced=<0|1>; epoch is 0 if the device
+//                      has no time at all
+//   time set <epoch>   sets the clock, in seconds since 1970 UTC
+//
+// The clock is kept in UTC; the timezone is the timeZoneOffsetMinutes
+// setting, which sumi time sync changes with config set.
+
+#include <Arduino.h>
+#include <SumiClock.h>
+
+#include <cstdlib>
+#include <cstring>
+
+#include "SerialCommands.h"
+
+namespace sumi {
+namespace SerialCommands {
+
+bool handleTime(const Request& req) {
+  if (strcmp(req.verb, "time") != 0) return false;
+  if (req.argc == 0) {
+    ok(req, "epoch=%lu synced=%d", (unsigned long)SumiClock::getEpoch(), SumiClock::isSynced() ? 1 : 0);
+    return true;
+  }
+  if (req.argc != 2 || strcmp(req.args[0], "set") != 0) {
+    fail(req, "bad arguments");
+    return true;
+  }
+  // The same bounds as the Bluetooth time sync (BleFileTransfer).
+  char* end;
+  const unsigned long epoch = strtoul(req.args[1], &end, 10);
+  if (*end != '\0' || epoch <= 1700000000UL || epoch >= 4000000000UL) {
+    fail(req, "bad time");
+    return true;
+  }
+  SumiClock::setTime(epoch);
+  // Kept across a power loss, stale, until the next sync.
+  SumiClock::saveToFlash();
+  Serial.printf("[%lu] [SER] Clock set: epoch=%lu\n", millis(), epoch);
+  ok(req);
+  return true;
+}
+
+}  // namespace SerialCommands
+}  // namespace sumi
@@ -0,0 +1,353 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"hash/crc32"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// The firmware's BLE file transfer service, protocol v2
+// (docs/BLE_FILE_TRANSFER.md, src/ble/BleFileTransfer.cpp): JSON metadata is
+// written to one characteristic, the file's bytes to another, and the device
+// answers with JSON status notifications on a third.
+const (
+	bleServiceUUID  = "19B10000-E8F2-537E-4F6C-D104768A1214"
+	bleMetadataUUID = "19B10001-E8F2-537E-4F6C-D104768A1214"
+	bleDataUUID     = "19B10002-E8F2-537E-4F6C-D104768A1214"
+	bleStatusUUID   = "19B10003-E8F2-537E-4F6C-D104768A1214"
+)
+
+// bleFolders is the folder a file goes to by default, by extension. The
+// device rejects folders outside its own list.
+var bleFolders = map[string]string{
+	".epub":     "books",
+	".txt":      "books",
+	".md":       "books",
+	".markdown": "books",
+	".xtc":      "books",
+	".xtch":     "books",
+	".xtg":      "books",
+	".xth":      "books",
+	".comic":    "comics",
+	".bmp":      "images",
+	".epdfont":  "config/fonts",
+	".theme":    "config/themes",
+	".lua":      "custom",
+}
+
+// bleFolder returns the folder for a file, or "" if its type has no default.
+func bleFolder(name string) string {
+	return bleFolders[strings.ToLower(filepath.Ext(name))]
+}
+
+// bleLink is a connection to the file transfer service.
+type bleLink interface {
+	// writeMeta writes to the metadata characteristic.
+	writeMeta(p []byte) error
+	// writeData writes a chunk of the file, without response.
+	writeData(p []byte) error
+	// writeEnd writes the empty chunk that ends a file, with response.
+	writeEnd() error
+	// statuses delivers the device's status notifications.
+	statuses() <-chan []byte
+	// chunkSize is the most data one write carries.
+	chunkSize() int
+	Close() error
+}
+
+// bleStatus is a status notification.
+type bleStatus struct {
+	State    string `json:"state"`
+	Msg      string `json:"msg"`
+	Ack      int64  `json:"ack"`
+	Offset   int64  `json:"offset"`
+	CRC      string `json:"crc"`
+	Bytes    int64  `json:"bytes"`
+	Size     int64  `json:"size"`
+	Received int    `json:"received"`
+	Total    int    `json:"total"`
+}
+
+// bleMeta is the metadata that starts a file. Every file is sent
+// resumable and with its CRC.
+type bleMeta struct {
+	Name       string `json:"name"`
+	Size       int64  `json:"size"`
+	Folder     string `json:"folder"`
+	CRC        string `json:"crc"`
+	Resume     bool   `json:"resume"`
+	Restart    bool   `json:"restart,omitempty"`
+	Queue      int    `json:"queue,omitempty"`
+	QueueTotal int    `json:"queueTotal,omitempty"`
+}
+
+// errLinkLost is returned when the device stops answering mid-transfer; the
+// transfer can be resumed on a new connection.
+var errLinkLost = errors.New("lost the connection to the device")
+
+// bleSender sends files over a link.
+type bleSender struct {
+	link    bleLink
+	timeout time.Duration
+}
+
+// await waits for a status in one of states. Errors and cancellation end
+// the wait; stale statuses from an earlier step are skipped.
+func (s *bleSender) await(states ...string) (bleStatus, error) {
+	deadline := time.NewTimer(s.timeout)
+	defer deadline.Stop()
+	for {
+		select {
+		case p, ok := <-s.link.statuses():
+			if !ok {
+				return bleStatus{}, errLinkLost
+			}
+			var st bleStatus
+			if err := json.Unmarshal(p, &st); err != nil {
+				return bleStatus{}, fmt.Errorf("bad status %q", p)
+			}
+			switch st.State {
+			case "error":
+				return st, errors.New(st.Msg)
+			case "cancelled":
+				return st, errors.New("cancelled on the device")
+			}
+			for _, want := range states {
+				if st.State == want {
+					return st, nil
+				}
+			}
+		case <-deadline.C:
+			return bleStatus{}, fmt.Errorf("%w: no %s status", errLinkLost, strings.Join(states, "/"))
+		}
+	}
+}
+
+// start writes a file's metadata and waits until the device is ready.
+func (s *bleSender) start(m bleMeta) (bleStatus, error) {
+	p, err := json.Marshal(m)
+	if err != nil {
+		return bleStatus{}, err
+	}
+	if err := s.link.writeMeta(p); err != nil {
+		return bleStatus{}, fmt.Errorf("%w: %v", errLinkLost, err)
+	}
+	return s.await("ready")
+}
+
+// send transfers one file, carrying on from a part the device kept from an
+// earlier attempt if it matches data. queue and total number the file in a
+// queue; both are zero for a single file.
+func (s *bleSender) send(name, folder string, data []byte, queue, total int, progress func(done, size int64)) error {
+	size := int64(len(data))
+	crc := fmt.Sprintf("%08x", crc32.ChecksumIEEE(data))
+	m := bleMeta{Name: name, Size: size, Folder: folder, CRC: crc, Resume: true, Queue: queue, QueueTotal: total}
+	st, err := s.start(m)
+	if err != nil {
+		return err
+	}
+	if st.Offset > 0 && (st.Offset > size || st.CRC != fmt.Sprintf("%08x", crc32.ChecksumIEEE(data[:st.Offset]))) {
+		// The part on the device is of some other file.
+		m.Restart = true
+		if st, err = s.start(m); err != nil {
+			return err
+		}
+	}
+	window := st.Ack
+	if window <= 0 {
+		window = 4096
+	}
+	sent, acked := st.Offset, st.Offset
+	chunk := int64(s.link.chunkSize())
+	for sent < size {
+		// The device acknowledges every window bytes, so with a window
+		// outstanding an ack is due.
+		for sent-acked >= window {
+			ack, err := s.await("ack")
+			if err != nil {
+				return err
+			}
+			acked = ack.Bytes
+			if progress != nil {
+				progress(acked, size)
+			}
+		}
+		end := min(sent+chunk, size)
+		if err := s.link.writeData(data[sent:end]); err != nil {
+			return fmt.Errorf("%w: %v", errLinkLost, err)
+		}
+		sent = end
+	}
+	if err := s.link.writeEnd(); err != nil {
+		return fmt.Errorf("%w: %v", errLinkLost, err)
+	}
+	done, err := s.await("done")
+	if err != nil {
+		return err
+	}
+	if done.Size != size || done.CRC != crc {
+		return fmt.Errorf("device wrote %d bytes with CRC %s, sent %d with CRC %s", done.Size, done.CRC, size, crc)
+	}
+	if progress != nil {
+		progress(size, size)
+	}
+	return nil
+}
+
+// finishQueue tells the device a queue is over and returns how many of its
+// files the device received.
+func (s *bleSender) finishQueue() (int, error) {
+	if err := s.link.writeMeta([]byte(`{"queueComplete":true}`)); err != nil {
+		return 0, err
+	}
+	st, err := s.await("queueDone")
+	return st.Received, err
+}
+
+// bleDevice is a SUMI found by a scan.
+type bleDevice struct {
+	address, name string
+	rssi          int16
+}
+
+// bleFile is a file to send.
+type bleFile struct {
+	local, name, folder string
+}
+
+// bleFiles picks the name and folder of each file to send.
+func bleFiles(paths []string, folder string) ([]bleFile, error) {
+	var files []bleFile
+	for _, p := range paths {
+		f := bleFile{local: p, name: filepath.Base(p), folder: folder}
+		if f.folder == "" {
+			if f.folder = bleFolder(p); f.folder == "" {
+				return nil, fmt.Errorf("%s: no default folder for this type of file; pick one with -folder", p)
+			}
+		}
+		files = append(files, f)
+	}
+	return files, nil
+}
+
+// sendAll sends files over links from dial, reconnecting and resuming up to
+// retries times when a connection drops.
+func sendAll(dial func() (bleLink, error), files []bleFile, timeout time.Duration, retries int) error {
+	next := 0
+	for attempt := 0; ; attempt++ {
+		err := sendFrom(dial, files, &next, timeout)
+		if err == nil || !errors.Is(err, errLinkLost) || attempt == retries {
+			return err
+		}
+		fmt.Fprintf(os.Stderr, "\n%v; reconnecting to resume\n", err)
+		time.Sleep(time.Second)
+	}
+}
+
+// sendFrom sends files[*next:] on a new connection, advancing *next as each
+// file is done.
+func sendFrom(dial func() (bleLink, error), files []bleFile, next *int, timeout time.Duration) error {
+	link, err := dial()
+	if err != nil {
+		return fmt.Errorf("%w: %v", errLinkLost, err)
+	}
+	defer link.Close()
+	s := &bleSender{link: link, timeout: timeout}
+	queue := len(files) > 1
+	for ; *next < len(files); *next++ {
+		f := files[*next]
+		data, err := os.ReadFile(f.local)
+		if err != nil {
+			return err
+		}
+		idx, total := 0, 0
+		if queue {
+			idx, total = *next+1, len(files)
+		}
+		remote := "/" + f.folder + "/" + f.name
+		if err := s.send(f.name, f.folder, data, idx, total, printProgress("Sending", remote)); err != nil {
+			return fmt.Errorf("%s: %w", f.local, err)
+		}
+		fmt.Fprintf(os.Stderr, "\rSent %s to %s (%d bytes, verified)\n", f.local, remote, len(data))
+	}
+	if queue {
+		if _, err := s.finishQueue(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// runBle implements "ble put" and "ble scan".
+func runBle(args []string) int {
+	usage := func() {
+		fmt.Fprintf(os.Stderr, "Usage: %s ble put [flags] <file>...\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "       %s ble scan [flags]\n\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "Sends files over Bluetooth, as the web page does. Turn on Settings >\n")
+		fmt.Fprintf(os.Stderr, "Wireless Transfer on the device first. Books, fonts, images and themes go\n")
+		fmt.Fprintf(os.Stderr, "to their usual folder unless -folder says otherwise. A dropped connection\n")
+		fmt.Fprintf(os.Stderr, "resumes where it stopped, and each file is checked against its CRC.\n")
+	}
+	if len(args) == 0 {
+		usage()
+		return 2
+	}
+	verb := args[0]
+	fs := flag.NewFlagSet("ble "+verb, flag.ContinueOnError)
+	device := fs.String("device", "", "address of the device to use (the first SUMI found if empty)")
+	scanTime := fs.Duration("scan", 10*time.Second, "how long to look for devices")
+	var folder *string
+	var timeout *time.Duration
+	var retries *int
+	if verb == "put" {
+		folder = fs.String("folder", "", "folder on the SD card, e.g. books, comics or config/fonts/<family> (by file type if empty)")
+		timeout = fs.Duration("timeout", 10*time.Second, "how long to wait for the device to answer")
+		retries = fs.Int("retries", 3, "how many times to reconnect and resume after the connection drops")
+	}
+	fs.Usage = func() {
+		usage()
+		fmt.Fprintf(os.Stderr, "\n")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args[1:]); err != nil {
+		return 2
+	}
+	switch {
+	case verb == "scan" && fs.NArg() == 0:
+		found, err := bleScan(*scanTime)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%v\n", err)
+			return 1
+		}
+		for _, d := range found {
+			fmt.Printf("%s  %s  %d dBm\n", d.address, d.name, d.rssi)
+		}
+		if len(found) == 0 {
+			fmt.Fprintf(os.Stderr, "no SUMI devices found; is Wireless Transfer on?\n")
+			return 1
+		}
+		return 0
+	case verb == "put" && fs.NArg() > 0:
+	default:
+		fs.Usage()
+		return 2
+	}
+
+	files, err := bleFiles(fs.Args(), *folder)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		return 1
+	}
+	dial := func() (bleLink, error) { return bleDial(*device, *scanTime) }
+	if err := sendAll(dial, files, *timeout, *retries); err != nil {
+		fmt.Fprintf(os.Stderr, "\n%v\n", err)
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,189 @@
+//go:build linux || windows || (darwin && cgo)
+
+package main
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"tinygo.org/x/bluetooth"
+)
+
+// scanned is a device found by a scan, with the address to connect to.
+type scanned struct {
+	bleDevice
+	addr bluetooth.Address
+}
+
+var enableAdapter = sync.OnceValue(func() error {
+	if err := bluetooth.DefaultAdapter.Enable(); err != nil {
+		return fmt.Errorf("cannot use Bluetooth: %v", err)
+	}
+	return nil
+})
+
+// bleScan lists the devices advertising the file transfer service for up to
+// d.
+func bleScan(d time.Duration) ([]bleDevice, error) {
+	found, err := scan(d, nil)
+	var devs []bleDevice
+	for _, f := range found {
+		devs = append(devs, f.bleDevice)
+	}
+	return devs, err
+}
+
+// scan looks for devices for up to d, stopping early at one stop accepts if
+// stop is set.
+func scan(d time.Duration, stop func(bleDevice) bool) ([]scanned, error) {
+	if err := enableAdapter(); err != nil {
+		return nil, err
+	}
+	service, _ := bluetooth.ParseUUID(bleServiceUUID)
+	adapter := bluetooth.DefaultAdapter
+	var mu sync.Mutex
+	var found []scanned
+	seen := map[string]bool{}
+	timer := time.AfterFunc(d, func() { adapter.StopScan() })
+	defer timer.Stop()
+	err := adapter.Scan(func(a *bluetooth.Adapter, r bluetooth.ScanResult) {
+		if !r.HasServiceUUID(service) {
+			return
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		if seen[r.Address.String()] {
+			return
+		}
+		seen[r.Address.String()] = true
+		dev := bleDevice{address: r.Address.String(), name: r.LocalName(), rssi: r.RSSI}
+		found = append(found, scanned{dev, r.Address})
+		if stop != nil && stop(dev) {
+			a.StopScan()
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	return found, nil
+}
+
+// tinygoLink is a bleLink over the host's Bluetooth stack.
+type tinygoLink struct {
+	device     bluetooth.Device
+	meta, data bluetooth.DeviceCharacteristic
+	status     chan []byte
+	chunk      int
+	mu         sync.Mutex
+	closed     bool
+}
+
+// bleDial connects to the device at address, or to the first SUMI a scan
+// finds.
+func bleDial(address string, d time.Duration) (bleLink, error) {
+	match := func(d bleDevice) bool {
+		return address == "" || strings.EqualFold(d.address, address)
+	}
+	found, err := scan(d, match)
+	if err != nil {
+		return nil, err
+	}
+	var dev *scanned
+	for i := range found {
+		if match(found[i].bleDevice) {
+			dev = &found[i]
+			break
+		}
+	}
+	if dev == nil {
+		return nil, fmt.Errorf("no SUMI device found; is Wireless Transfer on?")
+	}
+	device, err := bluetooth.DefaultAdapter.Connect(dev.addr, bluetooth.ConnectionParams{})
+	if err != nil {
+		return nil, fmt.Errorf("connecting to %s: %v", dev.address, err)
+	}
+	l := &tinygoLink{device: device, status: make(chan []byte, 64)}
+	if err := l.discover(); err != nil {
+		device.Disconnect()
+		return nil, err
+	}
+	return l, nil
+}
+
+func (l *tinygoLink) discover() error {
+	var uuids [4]bluetooth.UUID
+	for i, s := range []string{bleServiceUUID, bleMetadataUUID, bleDataUUID, bleStatusUUID} {
+		uuids[i], _ = bluetooth.ParseUUID(s)
+	}
+	services, err := l.device.DiscoverServices(uuids[:1])
+	if err != nil || len(services) == 0 {
+		return fmt.Errorf("the device has no file transfer service: %v", err)
+	}
+	chars, err := services[0].DiscoverCharacteristics(uuids[1:])
+	if err != nil || len(chars) != 3 {
+		return fmt.Errorf("the device's file transfer service is incomplete: %v", err)
+	}
+	var status bluetooth.DeviceCharacteristic
+	for _, c := range chars {
+		switch c.UUID() {
+		case uuids[1]:
+			l.meta = c
+		case uuids[2]:
+			l.data = c
+		case uuids[3]:
+			status = c
+		}
+	}
+	err = status.EnableNotifications(func(buf []byte) {
+		p := append([]byte(nil), buf...)
+		l.mu.Lock()
+		defer l.mu.Unlock()
+		if !l.closed {
+			select {
+			case l.status <- p:
+			default:
+				// Nobody is reading: the transfer has failed already.
+			}
+		}
+	})
+	if err != nil {
+		return fmt.Errorf("cannot subscribe to the device's status: %v", err)
+	}
+	// The firmware caps a chunk at 509 bytes, an MTU of 512 less the ATT
+	// header.
+	l.chunk = 20
+	if mtu, err := l.data.GetMTU(); err == nil && mtu > 23 {
+		l.chunk = min(int(mtu)-3, 509)
+	}
+	return nil
+}
+
+func (l *tinygoLink) writeMeta(p []byte) error {
+	_, err := l.meta.Write(p)
+	return err
+}
+
+func (l *tinygoLink) writeData(p []byte) error {
+	_, err := l.data.WriteWithoutResponse(p)
+	return err
+}
+
+func (l *tinygoLink) writeEnd() error {
+	_, err := l.data.Write(nil)
+	return err
+}
+
+func (l *tinygoLink) statuses() <-chan []byte { return l.status }
+
+func (l *tinygoLink) chunkSize() int { return l.chunk }
+
+func (l *tinygoLink) Close() error {
+	l.mu.Lock()
+	l.closed = true
+	l.mu.Unlock()
+	return l.device.Disconnect()
+}
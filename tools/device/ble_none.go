@@ -0,0 +1,16 @@
+//go:build !linux && !windows && !(darwin && cgo)
+
+package main
+
+import (
+	"errors"
+	"time"
+)
+
+// errNoBluetooth is returned where tinygo.org/x/bluetooth has no backend:
+// the BSDs, and macOS builds without cgo.
+var errNoBluetooth = errors.New("Bluetooth is not supported by this build; use a Linux, Windows or macOS (cgo) build")
+
+func bleScan(time.Duration) ([]bleDevice, error) { return nil, errNoBluetooth }
+
+func bleDial(string, time.Duration) (bleLink, error) { return nil, errNoBluetooth }
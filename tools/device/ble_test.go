@@ -0,0 +1,200 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"os"
+	"testing"
+	"time"
+)
+
+// fakeBle is the firmware's BLE file transfer service, keeping its SD card
+// across connections so that transfers can be resumed.
+type fakeBle struct {
+	files map[string][]byte
+	// parts are the part files of resumable transfers.
+	parts map[string][]byte
+	// dropAt, if set, loses the connection once the device holds that many
+	// bytes of a file.
+	dropAt int64
+	// connects counts the links made.
+	connects int
+}
+
+func newFakeBle() *fakeBle {
+	return &fakeBle{files: map[string][]byte{}, parts: map[string][]byte{}}
+}
+
+func (d *fakeBle) dial() (bleLink, error) {
+	d.connects++
+	return &fakeBleLink{dev: d, status: make(chan []byte, 256)}, nil
+}
+
+type fakeBleLink struct {
+	dev      *fakeBle
+	status   chan []byte
+	meta     bleMeta
+	path     string
+	buf      []byte
+	lastAck  int
+	received int
+	dead     bool
+}
+
+var errFakeDrop = errors.New("connection dropped")
+
+func (l *fakeBleLink) send(v map[string]any) {
+	p, _ := json.Marshal(v)
+	l.status <- p
+}
+
+func (l *fakeBleLink) writeMeta(p []byte) error {
+	if l.dead {
+		return errFakeDrop
+	}
+	if bytes.Contains(p, []byte(`"queueComplete"`)) {
+		l.send(map[string]any{"state": "queueDone", "received": l.received, "total": l.meta.QueueTotal})
+		return nil
+	}
+	var m bleMeta
+	if err := json.Unmarshal(p, &m); err != nil {
+		return err
+	}
+	l.meta, l.path, l.buf = m, "/"+m.Folder+"/"+m.Name, nil
+	if m.Resume && !m.Restart {
+		if part := l.dev.parts[l.path]; int64(len(part)) <= m.Size {
+			l.buf = append([]byte(nil), part...)
+		}
+	}
+	l.lastAck = len(l.buf)
+	l.send(map[string]any{"state": "ready", "mtu": 509, "ack": 4096, "offset": len(l.buf), "crc": fmt.Sprintf("%08x", crc32.ChecksumIEEE(l.buf))})
+	return nil
+}
+
+func (l *fakeBleLink) writeData(p []byte) error {
+	if l.dead {
+		return errFakeDrop
+	}
+	l.buf = append(l.buf, p...)
+	if l.dev.dropAt > 0 && int64(len(l.buf)) >= l.dev.dropAt {
+		l.dev.dropAt = 0
+		l.dead = true
+		if l.meta.Resume {
+			l.dev.parts[l.path] = l.buf
+		}
+		return nil
+	}
+	if len(l.buf)-l.lastAck >= 4096 {
+		l.lastAck = len(l.buf)
+		l.send(map[string]any{"state": "ack", "bytes": len(l.buf)})
+	}
+	return nil
+}
+
+func (l *fakeBleLink) writeEnd() error {
+	if l.dead {
+		return errFakeDrop
+	}
+	crc := fmt.Sprintf("%08x", crc32.ChecksumIEEE(l.buf))
+	delete(l.dev.parts, l.path)
+	if int64(len(l.buf)) != l.meta.Size || crc != l.meta.CRC {
+		l.send(map[string]any{"state": "error", "msg": "Checksum mismatch: " + crc})
+		return nil
+	}
+	l.dev.files[l.path] = l.buf
+	l.received++
+	l.send(map[string]any{"state": "done", "name": l.meta.Name, "size": len(l.buf), "crc": crc})
+	return nil
+}
+
+func (l *fakeBleLink) statuses() <-chan []byte { return l.status }
+func (l *fakeBleLink) chunkSize() int          { return 509 }
+func (l *fakeBleLink) Close() error            { return nil }
+
+func TestBleFiles(t *testing.T) {
+	files, err := bleFiles([]string{"a/Dune.EPUB", "b/Bookerly.epdfont", "c/sky.bmp"}, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []bleFile{{"a/Dune.EPUB", "Dune.EPUB", "books"}, {"b/Bookerly.epdfont", "Bookerly.epdfont", "config/fonts"}, {"c/sky.bmp", "sky.bmp", "images"}}
+	for i, f := range files {
+		if f != want[i] {
+			t.Errorf("file %d = %+v, want %+v", i, f, want[i])
+		}
+	}
+	if _, err := bleFiles([]string{"notes.pdf"}, ""); err == nil {
+		t.Errorf("a .pdf got a default folder")
+	}
+	if files, _ := bleFiles([]string{"notes.pdf"}, "notes"); files[0].folder != "notes" {
+		t.Errorf("-folder ignored: %+v", files[0])
+	}
+}
+
+func TestBleSend(t *testing.T) {
+	d := newFakeBle()
+	link, _ := d.dial()
+	s := &bleSender{link: link, timeout: time.Second}
+	data := testData(20000)
+	if err := s.send("dune.epub", "books", data, 0, 0, nil); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(d.files["/books/dune.epub"], data) {
+		t.Errorf("device has %d bytes, want %d", len(d.files["/books/dune.epub"]), len(data))
+	}
+}
+
+func TestBleSend_Resumes(t *testing.T) {
+	dir := t.TempDir()
+	data := testData(30000)
+	if err := os.WriteFile(dir+"/dune.epub", data, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	files, _ := bleFiles([]string{dir + "/dune.epub"}, "")
+
+	d := newFakeBle()
+	d.dropAt = 12000
+	if err := sendAll(d.dial, files, 100*time.Millisecond, 1); err != nil {
+		t.Fatal(err)
+	}
+	if d.connects != 2 {
+		t.Errorf("connected %d times, want 2", d.connects)
+	}
+	if !bytes.Equal(d.files["/books/dune.epub"], data) {
+		t.Errorf("resumed file differs")
+	}
+}
+
+func TestBleSend_StalePart(t *testing.T) {
+	d := newFakeBle()
+	d.parts["/books/dune.epub"] = []byte("some other book")
+	link, _ := d.dial()
+	s := &bleSender{link: link, timeout: time.Second}
+	data := testData(5000)
+	if err := s.send("dune.epub", "books", data, 0, 0, nil); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(d.files["/books/dune.epub"], data) {
+		t.Errorf("stale part was kept")
+	}
+}
+
+func TestBleSend_Queue(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(dir+"/a.epub", testData(100), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(dir+"/b.txt", testData(9000), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	files, _ := bleFiles([]string{dir + "/a.epub", dir + "/b.txt"}, "")
+	d := newFakeBle()
+	if err := sendAll(d.dial, files, time.Second, 0); err != nil {
+		t.Fatal(err)
+	}
+	if len(d.files) != 2 {
+		t.Errorf("device has %d files, want 2", len(d.files))
+	}
+}
@@ -0,0 +1,225 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// tzSetting is the setting holding the device's offset from UTC, in
+// minutes east; the device keeps its clock in UTC.
+const tzSetting = "timeZoneOffsetMinutes"
+
+// clock reads the device's clock. ok is false if it has no time at all,
+// as after a power loss with nothing saved.
+func (c *client) clock() (now time.Time, ok bool, err error) {
+	rep, err := c.call("time")
+	if err != nil {
+		return time.Time{}, false, err
+	}
+	for _, f := range rep.fields {
+		if k, v, _ := strings.Cut(f, "="); k == "epoch" {
+			n, err := strconv.ParseInt(v, 10, 64)
+			if err != nil {
+				return time.Time{}, false, fmt.Errorf("bad time %q", v)
+			}
+			return time.Unix(n, 0), n != 0, nil
+		}
+	}
+	return time.Time{}, false, fmt.Errorf("no time in %q", rep.fields)
+}
+
+// tzOffset works out the offset, in minutes east of UTC, that zone has at
+// t. zone is local for the host's, an IANA name such as Europe/Berlin, or
+// a fixed offset: UTC, +2, -08:00, UTC+05:30.
+func tzOffset(zone string, t time.Time) (int, error) {
+	switch zone {
+	case "local":
+		_, secs := t.Zone()
+		return secs / 60, nil
+	case "UTC", "utc", "Z":
+		return 0, nil
+	}
+	fixed := strings.TrimPrefix(strings.TrimPrefix(zone, "UTC"), "utc")
+	if fixed != "" && (fixed[0] == '+' || fixed[0] == '-') {
+		h, m, hasMin := strings.Cut(fixed[1:], ":")
+		hours, err := strconv.Atoi(h)
+		mins := 0
+		if err == nil && hasMin {
+			mins, err = strconv.Atoi(m)
+		}
+		if err != nil || hours > 14 || mins < 0 || mins >= 60 {
+			return 0, fmt.Errorf("bad offset %q; want +HH:MM", zone)
+		}
+		off := hours*60 + mins
+		if fixed[0] == '-' {
+			off = -off
+		}
+		// What the device accepts, UTC-12 to UTC+14.
+		if off < -720 || off > 840 {
+			return 0, fmt.Errorf("offset %q is out of range", zone)
+		}
+		return off, nil
+	}
+	loc, err := time.LoadLocation(zone)
+	if err != nil {
+		return 0, fmt.Errorf("unknown timezone %q", zone)
+	}
+	_, secs := t.In(loc).Zone()
+	return secs / 60, nil
+}
+
+// formatOffset shows minutes east of UTC as UTC+05:30.
+func formatOffset(min int) string {
+	sign := '+'
+	if min < 0 {
+		sign, min = '-', -min
+	}
+	return fmt.Sprintf("UTC%c%02d:%02d", sign, min/60, min%60)
+}
+
+// deviceOffset reads the device's timezone setting.
+func (c *client) deviceOffset() (int, error) {
+	all, err := c.settings()
+	if err != nil {
+		return 0, err
+	}
+	for _, s := range all {
+		if s.key == tzSetting {
+			return strconv.Atoi(s.value)
+		}
+	}
+	return 0, fmt.Errorf("the firmware has no %s setting", tzSetting)
+}
+
+// showClock prints the device's time, in its timezone, and how far it is
+// from the host's clock.
+func showClock(c *client, now time.Time, out io.Writer) error {
+	dev, ok, err := c.clock()
+	if err != nil {
+		return err
+	}
+	off, err := c.deviceOffset()
+	if err != nil {
+		return err
+	}
+	if !ok {
+		fmt.Fprintf(out, "The device has no time; %s time sync sets it.\n", os.Args[0])
+		return nil
+	}
+	zone := time.FixedZone(formatOffset(off), off*60)
+	fmt.Fprintf(out, "%s (%s)\n", dev.In(zone).Format("2006-01-02 15:04:05"), zone)
+	fmt.Fprintf(out, "It is %s.\n", drift(dev, now))
+	return nil
+}
+
+// drift says how far the device's clock dev is from the host's now.
+func drift(dev, now time.Time) string {
+	d := dev.Sub(now).Round(time.Second)
+	switch {
+	case d == 0:
+		return "in step with this computer"
+	case d > 0:
+		return fmt.Sprintf("%s ahead of this computer", d)
+	default:
+		return fmt.Sprintf("%s behind this computer", -d)
+	}
+}
+
+// syncClock sets the device's clock from now and, unless zone is keep,
+// its timezone to zone's offset at now.
+func syncClock(c *client, now func() time.Time, zone string, out io.Writer) error {
+	var off int
+	var err error
+	if zone == "keep" {
+		off, err = c.deviceOffset()
+	} else if off, err = tzOffset(zone, now()); err == nil {
+		err = c.setSetting(setting{tzSetting, strconv.Itoa(off)})
+	}
+	if err != nil {
+		return err
+	}
+
+	old, hadTime, err := c.clock()
+	if err != nil {
+		return err
+	}
+	// Sent in whole seconds, rounded to the nearest.
+	t := now().Add(500 * time.Millisecond)
+	if _, err := c.call("time", "set", strconv.FormatInt(t.Unix(), 10)); err != nil {
+		return err
+	}
+	zoneName := formatOffset(off)
+	fmt.Fprintf(out, "Set the clock to %s %s.", t.In(time.FixedZone(zoneName, off*60)).Format("2006-01-02 15:04:05"), zoneName)
+	if hadTime {
+		fmt.Fprintf(out, " It was %s.", drift(old, t))
+	}
+	fmt.Fprintf(out, "\n")
+	return nil
+}
+
+// runTime implements "time [show]|sync".
+func runTime(args []string) int {
+	usage := func() {
+		fmt.Fprintf(os.Stderr, "Usage: %s time [show] [flags]\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "       %s time sync [flags] [-tz zone]\n\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "show prints the device's clock and how far it is from this computer's.\n")
+		fmt.Fprintf(os.Stderr, "sync sets it from this computer's clock, for reading statistics and\n")
+		fmt.Fprintf(os.Stderr, "flashcard reviews, and sets the device's timezone. The device keeps a\n")
+		fmt.Fprintf(os.Stderr, "fixed offset from UTC, so sync again after a daylight saving change.\n")
+	}
+	verb := "show"
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		verb, args = args[0], args[1:]
+	}
+	if verb != "show" && verb != "sync" {
+		usage()
+		return 2
+	}
+	fs := flag.NewFlagSet("time "+verb, flag.ContinueOnError)
+	conn := addConnFlags(fs)
+	var zone *string
+	if verb == "sync" {
+		zone = fs.String("tz", "local", "timezone to set: local for this computer's, keep for the device's own, an IANA name or an offset such as +05:30")
+	}
+	fs.Usage = func() {
+		usage()
+		fmt.Fprintf(os.Stderr, "\n")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if fs.NArg() != 0 {
+		fs.Usage()
+		return 2
+	}
+	if zone != nil && *zone != "keep" {
+		// Checked before anything changes on the device.
+		if _, err := tzOffset(*zone, time.Now()); err != nil {
+			fmt.Fprintf(os.Stderr, "%v\n", err)
+			return 2
+		}
+	}
+
+	c, port, err := conn.dial()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		return 1
+	}
+	defer port.Close()
+	if verb == "sync" {
+		err = syncClock(c, time.Now, *zone, os.Stdout)
+	} else {
+		err = showClock(c, time.Now(), os.Stdout)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		return 1
+	}
+	return 0
+}
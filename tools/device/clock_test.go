@@ -0,0 +1,71 @@
+package main
+
+import (
+	"bytes"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestTzOffset(t *testing.T) {
+	summer := time.Date(2026, 7, 1, 12, 0, 0, 0, time.UTC)
+	for _, tc := range []struct {
+		zone string
+		want int
+	}{
+		{"UTC", 0}, {"+2", 120}, {"-08:00", -480}, {"UTC+05:30", 330}, {"Europe/Berlin", 120}, {"America/New_York", -240},
+	} {
+		if got, err := tzOffset(tc.zone, summer); err != nil || got != tc.want {
+			t.Errorf("tzOffset(%q) = %d, %v; want %d", tc.zone, got, err, tc.want)
+		}
+	}
+	for _, bad := range []string{"+5:75", "-13", "Mars/Olympus", "+x"} {
+		if _, err := tzOffset(bad, summer); err == nil {
+			t.Errorf("tzOffset(%q) took it", bad)
+		}
+	}
+	if got := formatOffset(-210); got != "UTC-03:30" {
+		t.Errorf("formatOffset(-210) = %q", got)
+	}
+}
+
+func TestSyncClock(t *testing.T) {
+	d := newFakeDevice()
+	settings := map[string]string{tzSetting: "0", "fontSize": "2"}
+	withSettings(d, settings)
+	config := d.handle
+	var epoch int64 = 1790000000
+	d.handle = func(d *fakeDevice, id, verb string, args []string) bool {
+		if verb != "time" {
+			return config(d, id, verb, args)
+		}
+		if len(args) == 2 {
+			epoch, _ = strconv.ParseInt(args[1], 10, 64)
+			d.reply(id, "ok")
+		} else {
+			d.reply(id, "ok", "epoch="+strconv.FormatInt(epoch, 10), "synced=0")
+		}
+		return true
+	}
+	c := d.start(t)
+
+	now := time.Unix(1790000125, 600e6)
+	var out bytes.Buffer
+	if err := syncClock(c, func() time.Time { return now }, "Asia/Kolkata", &out); err != nil {
+		t.Fatal(err)
+	}
+	if epoch != 1790000126 || settings[tzSetting] != "330" {
+		t.Errorf("device at %d, offset %s", epoch, settings[tzSetting])
+	}
+	if want := "Set the clock to 2026-09-21 19:45:26 UTC+05:30. It was 2m6s behind this computer.\n"; out.String() != want {
+		t.Errorf("printed %q, want %q", out.String(), want)
+	}
+
+	out.Reset()
+	if err := showClock(c, now, &out); err != nil {
+		t.Fatal(err)
+	}
+	if want := "2026-09-21 19:45:26 (UTC+05:30)\nIt is in step with this computer.\n"; out.String() != want {
+		t.Errorf("printed %q, want %q", out.String(), want)
+	}
+}
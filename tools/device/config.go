@@ -0,0 +1,247 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// configNames are the values of the settings that are choices, in the
+// order of their enums (src/core/SumiSettings.h), so that get shows
+// fontSize=large rather than fontSize=3. set takes either.
+var configNames = map[string][]string{
+	"sleepScreen":        {"dark", "light", "custom", "cover", "page-overlay"},
+	"textLayout":         {"compact", "standard", "large"},
+	"shortPwrBtn":        {"ignore", "sleep", "page-turn", "refresh"},
+	"statusBar":          {"none", "show"},
+	"orientation":        {"portrait", "landscape-cw", "inverted", "landscape-ccw"},
+	"fontSize":           {"xsmall", "small", "medium", "large"},
+	"pagesPerRefresh":    {"1", "5", "10", "15", "30", "never"},
+	"sideButtonLayout":   {"prev-next", "next-prev"},
+	"autoSleepMinutes":   {"5", "10", "15", "30", "never"},
+	"paragraphAlignment": {"justified", "left", "center", "right"},
+	"hyphenation":        {"off", "on"},
+	"textAntiAliasing":   {"off", "on"},
+	"showImages":         {"suppress", "show", "placeholder"},
+	"startupBehavior":    {"last-document", "home"},
+	"lineSpacing":        {"compact", "normal", "relaxed", "large"},
+	"sunlightFadingFix":  {"off", "on"},
+	"frontButtonLayout":  {"bclr", "lrbc"},
+	"showTables":         {"off", "on"},
+	"bleTimeout":         {"3", "5", "10", "30", "never"},
+	"textDarkness":       {"normal", "dark", "extra-dark", "maximum"},
+	"language":           {"en", "es", "fr", "de", "pt", "it", "ru", "pl", "nl", "ja", "zh", "ko", "ar"},
+}
+
+// showConfig turns a value as the device stores it into its name.
+func showConfig(key, v string) string {
+	if n, err := strconv.Atoi(v); err == nil && n >= 0 && n < len(configNames[key]) {
+		return configNames[key][n]
+	}
+	return v
+}
+
+// storeConfig turns a value given to set into what the device stores. The
+// device checks numbers against the setting's range itself.
+func storeConfig(key, v string) string {
+	for i, name := range configNames[key] {
+		if strings.EqualFold(v, name) {
+			return strconv.Itoa(i)
+		}
+	}
+	return v
+}
+
+// setting is one name=value line, as get prints and set -f reads.
+type setting struct {
+	key, value string
+}
+
+// settings reads the device's settings.
+func (c *client) settings() ([]setting, error) {
+	rep, err := c.call("config")
+	if err != nil {
+		return nil, err
+	}
+	var all []setting
+	for _, it := range rep.items {
+		if len(it) == 0 || len(it) > 2 {
+			return nil, fmt.Errorf("bad setting %q", it)
+		}
+		s := setting{key: it[0]}
+		if len(it) == 2 {
+			s.value = showConfig(s.key, it[1])
+		}
+		all = append(all, s)
+	}
+	return all, nil
+}
+
+// setSetting changes one setting; the device saves it at once.
+func (c *client) setSetting(s setting) error {
+	args := []string{"set", s.key}
+	if v := storeConfig(s.key, s.value); v != "" {
+		args = append(args, v)
+	}
+	_, err := c.call("config", args...)
+	if err != nil {
+		return fmt.Errorf("%s: %w", s.key, err)
+	}
+	return nil
+}
+
+// parseSettings reads name=value lines, skipping blank lines and # comments.
+func parseSettings(r io.Reader) ([]setting, error) {
+	var all []setting
+	sc := bufio.NewScanner(r)
+	for n := 1; sc.Scan(); n++ {
+		line := strings.TrimSpace(sc.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		k, v, ok := strings.Cut(line, "=")
+		if !ok || strings.TrimSpace(k) == "" {
+			return nil, fmt.Errorf("line %d: want name=value, got %q", n, line)
+		}
+		all = append(all, setting{strings.TrimSpace(k), strings.TrimSpace(v)})
+	}
+	return all, sc.Err()
+}
+
+// configCommand runs one config verb on a connected device.
+func configCommand(c *client, verb string, args []string, file string, out io.Writer) error {
+	switch verb {
+	case "get":
+		all, err := c.settings()
+		if err != nil {
+			return err
+		}
+		if len(args) == 0 {
+			for _, s := range all {
+				fmt.Fprintf(out, "%s=%s\n", s.key, s.value)
+			}
+			return nil
+		}
+		byKey := map[string]string{}
+		for _, s := range all {
+			byKey[s.key] = s.value
+		}
+		for _, k := range args {
+			v, ok := byKey[k]
+			if !ok {
+				return fmt.Errorf("no setting %q; %s config get lists them", k, os.Args[0])
+			}
+			if len(args) == 1 {
+				fmt.Fprintf(out, "%s\n", v)
+			} else {
+				fmt.Fprintf(out, "%s=%s\n", k, v)
+			}
+		}
+
+	case "set":
+		var todo []setting
+		if file != "" {
+			f, err := os.Open(file)
+			if err != nil {
+				return err
+			}
+			defer f.Close()
+			if todo, err = parseSettings(f); err != nil {
+				return fmt.Errorf("%s: %v", file, err)
+			}
+		}
+		if len(args) == 2 && !strings.Contains(args[0], "=") {
+			args = []string{args[0] + "=" + args[1]}
+		}
+		more, err := parseSettings(strings.NewReader(strings.Join(args, "\n")))
+		if err != nil {
+			return err
+		}
+		for _, s := range append(todo, more...) {
+			if err := c.setSetting(s); err != nil {
+				return err
+			}
+		}
+
+	case "names":
+		var keys []string
+		for k := range configNames {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			fmt.Fprintf(out, "%s: %s\n", k, strings.Join(configNames[k], " "))
+		}
+	}
+	return nil
+}
+
+// runConfig implements "config get|set|names".
+func runConfig(args []string) int {
+	usage := func() {
+		fmt.Fprintf(os.Stderr, "Usage: %s config get [flags] [name...]\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "       %s config set [flags] <name> <value>\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "       %s config set [flags] [-f file] [name=value...]\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "       %s config names\n\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "Reads and changes the device's settings over the USB cable. get with no\n")
+		fmt.Fprintf(os.Stderr, "names prints every setting as name=value, which set -f reads back, so\n")
+		fmt.Fprintf(os.Stderr, "one device's settings can be copied to others. Choices such as fontSize\n")
+		fmt.Fprintf(os.Stderr, "take the names config names lists, or their number. Changes are saved at\n")
+		fmt.Fprintf(os.Stderr, "once; a new theme or reader font shows after the device restarts.\n")
+	}
+	if len(args) == 0 {
+		usage()
+		return 2
+	}
+	verb := args[0]
+	fs := flag.NewFlagSet("config "+verb, flag.ContinueOnError)
+	conn := addConnFlags(fs)
+	var file *string
+	if verb == "set" {
+		file = fs.String("f", "", "file of name=value lines to set, as config get prints")
+	}
+	fs.Usage = func() {
+		usage()
+		fmt.Fprintf(os.Stderr, "\n")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args[1:]); err != nil {
+		return 2
+	}
+	rest := fs.Args()
+	var ok bool
+	switch verb {
+	case "get":
+		ok = true
+	case "set":
+		ok = len(rest) > 0 || *file != ""
+	case "names":
+		configCommand(nil, verb, nil, "", os.Stdout)
+		return 0
+	}
+	if !ok {
+		fs.Usage()
+		return 2
+	}
+	var from string
+	if file != nil {
+		from = *file
+	}
+
+	c, port, err := conn.dial()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		return 1
+	}
+	defer port.Close()
+	if err := configCommand(c, verb, rest, from, os.Stdout); err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		return 1
+	}
+	return 0
+}
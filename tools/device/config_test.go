@@ -0,0 +1,104 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+)
+
+// withSettings makes the fake answer the "config" verb from settings,
+// which holds values as the firmware stores them.
+func withSettings(d *fakeDevice, settings map[string]string) {
+	d.handle = func(d *fakeDevice, id, verb string, args []string) bool {
+		if verb != "config" {
+			return false
+		}
+		switch {
+		case len(args) == 0:
+			var keys []string
+			for k := range settings {
+				keys = append(keys, k)
+			}
+			sort.Strings(keys)
+			for _, k := range keys {
+				if settings[k] == "" {
+					d.reply(id, "item", k)
+				} else {
+					d.reply(id, "item", k, encodeArg(settings[k]))
+				}
+			}
+			d.reply(id, "ok")
+		case args[0] == "set" && len(args) >= 2:
+			if _, ok := settings[args[1]]; !ok {
+				d.reply(id, "err", "unknown", "setting")
+				return true
+			}
+			settings[args[1]] = ""
+			if len(args) == 3 {
+				settings[args[1]] = args[2]
+			}
+			d.reply(id, "ok")
+		default:
+			d.reply(id, "err", "bad", "arguments")
+		}
+		return true
+	}
+}
+
+func TestConfigGet(t *testing.T) {
+	d := newFakeDevice()
+	withSettings(d, map[string]string{"fontSize": "3", "readerFont": "Literata Pro", "dictionaryName": "", "pagesPerRefresh": "5"})
+	c := d.start(t)
+
+	var out bytes.Buffer
+	if err := configCommand(c, "get", nil, "", &out); err != nil {
+		t.Fatal(err)
+	}
+	want := "dictionaryName=\nfontSize=large\npagesPerRefresh=never\nreaderFont=Literata Pro\n"
+	if out.String() != want {
+		t.Errorf("get printed\n%s\nwant\n%s", out.String(), want)
+	}
+	out.Reset()
+	if err := configCommand(c, "get", []string{"fontSize"}, "", &out); err != nil || out.String() != "large\n" {
+		t.Errorf("get fontSize = %q, %v", out.String(), err)
+	}
+	if err := configCommand(c, "get", []string{"margins"}, "", &out); err == nil {
+		t.Errorf("got a setting that doesn't exist")
+	}
+}
+
+func TestConfigSet(t *testing.T) {
+	d := newFakeDevice()
+	settings := map[string]string{"fontSize": "2", "readerFont": "", "orientation": "0", "textDarkness": "0"}
+	withSettings(d, settings)
+	c := d.start(t)
+
+	if err := configCommand(c, "set", []string{"fontSize", "Small"}, "", nil); err != nil {
+		t.Fatal(err)
+	}
+	file := filepath.Join(t.TempDir(), "settings.txt")
+	lines := "# provisioning\norientation = landscape-cw\nreaderFont=Literata Pro\n\n"
+	if err := os.WriteFile(file, []byte(lines), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := configCommand(c, "set", []string{"textDarkness=2"}, file, nil); err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]string{"fontSize": "1", "readerFont": "Literata Pro", "orientation": "1", "textDarkness": "2"}
+	for k, v := range want {
+		if settings[k] != v {
+			t.Errorf("%s = %q, want %q", k, settings[k], v)
+		}
+	}
+
+	if err := configCommand(c, "set", []string{"readerFont="}, "", nil); err != nil || settings["readerFont"] != "" {
+		t.Errorf("clearing readerFont: %v, now %q", err, settings["readerFont"])
+	}
+	err := configCommand(c, "set", []string{"margins=3"}, "", nil)
+	if err == nil || !strings.Contains(err.Error(), "unknown setting") {
+		t.Errorf("set margins: %v", err)
+	}
+}
@@ -0,0 +1,538 @@
+package main
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Where the Flashcards app keeps its decks and what it knows of them
+// (src/plugins/Flashcards.h). The two .bin files are the app's structs as
+// they lie in memory, little-endian.
+const (
+	deckRoot       = "/flashcards"
+	deckMetaPath   = "/.sumi/flashcards_decks.bin"
+	deckStatsPath  = "/.sumi/flashcards_stats.bin"
+	deckSyncIDPath = "/.sumi/deck_sync_id"
+	historyName    = "sumi-history.json" // in the local deck folder
+	historyFormat  = "sumi-decks"
+	historyVer     = 1
+	deckMetaMagic  = 0x444B4D54 // "TMKD"
+	deckStatsMagic = 0x464C5354 // "TSLF"
+	maxDecks       = 20         // DeckMetadataFile::decks
+	maxDeckName    = 31         // DeckMetadata::filename, less its NUL
+)
+
+// deckTypes are the deck files the app reads.
+var deckTypes = map[string]bool{".txt": true, ".csv": true, ".tsv": true, ".json": true}
+
+func isDeck(name string) bool {
+	return deckTypes[strings.ToLower(path.Ext(name))] && !strings.HasPrefix(name, ".") && name != historyName
+}
+
+// deckMeta is a DeckMetadata.
+type deckMeta struct {
+	Filename [32]byte
+	Cards    uint16
+	Studied  uint16 // capped at Cards
+	Correct  uint16
+	_        [2]byte
+	LastUsed uint32 // YYYYMMDD
+	Sessions uint8  // wraps at 256
+	_        [7]byte
+}
+
+// deckStats is a FlashcardStats: the app's totals over all decks.
+type deckStats struct {
+	Magic         uint32
+	Studied       uint32
+	Correct       uint32
+	Incorrect     uint32
+	CurrentStreak uint16
+	BestStreak    uint16
+	LastDate      uint32 // YYYYMMDD
+	Today, Week   uint16
+	Daily         [7]uint16
+	_             [18]byte
+}
+
+func parseDeckMeta(b []byte) ([]deckMeta, error) {
+	var f struct {
+		Magic uint32
+		Count uint8
+		_     [3]byte
+		Decks [maxDecks]deckMeta
+	}
+	if err := binary.Read(bytes.NewReader(b), binary.LittleEndian, &f); err != nil || f.Magic != deckMetaMagic || f.Count > maxDecks {
+		return nil, errors.New("flashcards_decks.bin: not a deck list")
+	}
+	return f.Decks[:f.Count], nil
+}
+
+func encodeDeckMeta(decks []deckMeta) []byte {
+	var buf bytes.Buffer
+	le := binary.LittleEndian
+	binary.Write(&buf, le, uint32(deckMetaMagic))
+	buf.Write([]byte{uint8(len(decks)), 0, 0, 0})
+	var all [maxDecks]deckMeta
+	copy(all[:], decks)
+	binary.Write(&buf, le, all)
+	return buf.Bytes()
+}
+
+func parseDeckStats(b []byte) (deckStats, error) {
+	var s deckStats
+	if err := binary.Read(bytes.NewReader(b), binary.LittleEndian, &s); err != nil || s.Magic != deckStatsMagic {
+		return deckStats{}, errors.New("flashcards_stats.bin: bad magic")
+	}
+	return s, nil
+}
+
+func encodeDeckStats(s deckStats) []byte {
+	var buf bytes.Buffer
+	s.Magic = deckStatsMagic
+	binary.Write(&buf, binary.LittleEndian, s)
+	return buf.Bytes()
+}
+
+// deckHistory is how far a deck has been studied. Studied counts cards
+// answered, up to the size of the deck; Correct counts right answers.
+type deckHistory struct {
+	Cards       int    `json:"cards"`
+	Studied     int    `json:"studied"`
+	Correct     int    `json:"correct"`
+	Sessions    int    `json:"sessions"`
+	LastStudied string `json:"lastStudied,omitempty"`
+}
+
+// deckTotals are the app's totals over all decks.
+type deckTotals struct {
+	Studied    int `json:"studied"`
+	Correct    int `json:"correct"`
+	Incorrect  int `json:"incorrect"`
+	BestStreak int `json:"bestStreak"`
+}
+
+// deviceSnapshot is what a device held when it was last synced: the base
+// that its later studying is measured from.
+type deviceSnapshot struct {
+	Synced string                 `json:"synced"`
+	Decks  map[string]deckHistory `json:"decks"`
+	Totals deckTotals             `json:"totals"`
+}
+
+// studyHistory is sumi-history.json, kept beside the decks: the study
+// history merged from every device, and each device's snapshot
+// (docs/DECK_SYNC.md).
+type studyHistory struct {
+	Format  string                    `json:"format"`
+	Version int                       `json:"version"`
+	Decks   map[string]deckHistory    `json:"decks"`
+	Totals  deckTotals                `json:"totals"`
+	Devices map[string]deviceSnapshot `json:"devices"`
+}
+
+func newHistory() *studyHistory {
+	return &studyHistory{Format: historyFormat, Version: historyVer, Decks: map[string]deckHistory{}, Devices: map[string]deviceSnapshot{}}
+}
+
+func readHistory(dir string) (*studyHistory, error) {
+	name := filepath.Join(dir, historyName)
+	data, err := os.ReadFile(name)
+	if errors.Is(err, os.ErrNotExist) {
+		return newHistory(), nil
+	} else if err != nil {
+		return nil, err
+	}
+	h := newHistory()
+	if err := json.Unmarshal(data, h); err != nil {
+		return nil, fmt.Errorf("%s: %v", name, err)
+	}
+	if h.Format != historyFormat || h.Version != historyVer {
+		return nil, fmt.Errorf("%s is not a version %d %s history", name, historyVer, historyFormat)
+	}
+	return h, nil
+}
+
+func writeHistory(dir string, h *studyHistory) error {
+	data, err := json.MarshalIndent(h, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(dir, historyName), append(data, '\n'), 0o644)
+}
+
+// yyyymmdd and its inverse convert the app's dates.
+func yyyymmdd(d uint32) string {
+	if d == 0 {
+		return ""
+	}
+	return fmt.Sprintf("%04d-%02d-%02d", d/10000, d/100%100, d%100)
+}
+
+func dateNumber(s string) uint32 {
+	t, err := time.Parse("2006-01-02", s)
+	if err != nil {
+		return 0
+	}
+	return uint32(t.Year()*10000 + int(t.Month())*100 + t.Day())
+}
+
+func metaHistory(m deckMeta) (string, deckHistory) {
+	name := string(bytes.TrimRight(m.Filename[:], "\x00"))
+	return name, deckHistory{int(m.Cards), int(m.Studied), int(m.Correct), int(m.Sessions), yyyymmdd(m.LastUsed)}
+}
+
+// since is how much a counter grew from base to now. A counter that went
+// down was reset, as by a wiped card, and counts from zero.
+func since(now, base int) int {
+	if now < base {
+		return now
+	}
+	return now - base
+}
+
+// mergeDeck adds what a device studied since its snapshot base to the
+// merged history h. That's what makes a deck studied on two devices add
+// up rather than one overwrite the other.
+func mergeDeck(h, dev, base deckHistory) deckHistory {
+	if dev.Cards > 0 {
+		h.Cards = dev.Cards
+	}
+	h.Correct += since(dev.Correct, base.Correct)
+	h.Studied = min(h.Studied+since(dev.Studied, base.Studied), max(h.Cards, dev.Studied))
+	// The device counts sessions in a byte, which wraps.
+	h.Sessions += (dev.Sessions - base.Sessions + 256) % 256
+	if dev.LastStudied > h.LastStudied {
+		h.LastStudied = dev.LastStudied
+	}
+	return h
+}
+
+// mergeDevice folds the device id's decks and totals into h, and makes
+// them its new snapshot.
+func mergeDevice(h *studyHistory, id string, meta []deckMeta, stats deckStats) {
+	snap := h.Devices[id]
+	now := deviceSnapshot{Synced: time.Now().UTC().Format(time.RFC3339), Decks: map[string]deckHistory{}}
+	for _, m := range meta {
+		name, dev := metaHistory(m)
+		h.Decks[name] = mergeDeck(h.Decks[name], dev, snap.Decks[name])
+		now.Decks[name] = dev
+	}
+	now.Totals = deckTotals{int(stats.Studied), int(stats.Correct), int(stats.Incorrect), int(stats.BestStreak)}
+	h.Totals.Studied += since(now.Totals.Studied, snap.Totals.Studied)
+	h.Totals.Correct += since(now.Totals.Correct, snap.Totals.Correct)
+	h.Totals.Incorrect += since(now.Totals.Incorrect, snap.Totals.Incorrect)
+	h.Totals.BestStreak = max(h.Totals.BestStreak, now.Totals.BestStreak)
+	h.Devices[id] = now
+}
+
+// deviceHistory reads the device's deck list and totals; either may not
+// exist yet.
+func (c *client) deviceHistory() ([]deckMeta, deckStats, error) {
+	var meta []deckMeta
+	var stats deckStats
+	b, err := c.fetch(deckMetaPath)
+	if err == nil && b != nil {
+		meta, err = parseDeckMeta(b)
+	}
+	if err != nil {
+		return nil, stats, err
+	}
+	b, err = c.fetch(deckStatsPath)
+	if err == nil && b != nil {
+		stats, err = parseDeckStats(b)
+	}
+	return meta, stats, err
+}
+
+// syncID returns the name the card goes by in the history, giving it one
+// if it has none. The study data lives on the card, so it is the card
+// that is named rather than the device.
+func (c *client) syncID() (string, error) {
+	b, err := c.fetch(deckSyncIDPath)
+	if err != nil {
+		return "", err
+	}
+	if id := strings.TrimSpace(string(b)); id != "" {
+		return id, nil
+	}
+	var r [6]byte
+	if _, err := rand.Read(r[:]); err != nil {
+		return "", err
+	}
+	id := hex.EncodeToString(r[:])
+	if err := c.ensureDir("/.sumi"); err != nil {
+		return "", err
+	}
+	return id, c.upload(deckSyncIDPath, []byte(id+"\n"), nil)
+}
+
+// deviceDecks lists the decks in the device's /flashcards, which the app
+// makes the first time it opens.
+func (c *client) deviceDecks() ([]string, error) {
+	if _, err := c.stat(deckRoot); isNotFound(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	entries, err := c.list(deckRoot)
+	if err != nil {
+		return nil, err
+	}
+	var names []string
+	for _, e := range entries {
+		if !e.dir && isDeck(e.name) {
+			names = append(names, e.name)
+		}
+	}
+	return names, nil
+}
+
+// pullDecks copies the device's decks into dir and merges its study
+// history into dir's sumi-history.json. A deck that differs from the one
+// already in dir is left as it is, and reported.
+func pullDecks(c *client, dir string, out io.Writer) error {
+	names, err := c.deviceDecks()
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return err
+	}
+	for _, name := range names {
+		var buf bytes.Buffer
+		if _, err := c.download(deckRoot+"/"+name, &buf, nil); err != nil {
+			return fmt.Errorf("%s: %w", name, err)
+		}
+		local := filepath.Join(dir, name)
+		have, err := os.ReadFile(local)
+		switch {
+		case errors.Is(err, os.ErrNotExist):
+			if err := os.WriteFile(local, buf.Bytes(), 0o644); err != nil {
+				return err
+			}
+			fmt.Fprintf(out, "%s\n", name)
+		case err != nil:
+			return err
+		case !bytes.Equal(have, buf.Bytes()):
+			fmt.Fprintf(out, "%s differs from the device's copy; kept yours\n", name)
+		}
+	}
+	h, err := readHistory(dir)
+	if err != nil {
+		return err
+	}
+	if _, _, err := mergeFrom(c, h); err != nil {
+		return err
+	}
+	return writeHistory(dir, h)
+}
+
+// mergeFrom merges the device's study history into h, and returns the
+// card's sync ID and its totals as they were.
+func mergeFrom(c *client, h *studyHistory) (string, deckStats, error) {
+	id, err := c.syncID()
+	if err != nil {
+		return "", deckStats{}, err
+	}
+	meta, stats, err := c.deviceHistory()
+	if err != nil {
+		return "", deckStats{}, err
+	}
+	mergeDevice(h, id, meta, stats)
+	return id, stats, nil
+}
+
+// localDecks lists the deck files in dir.
+func localDecks(dir string) ([]string, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	var names []string
+	for _, e := range entries {
+		if e.Type().IsRegular() && isDeck(e.Name()) {
+			if len(e.Name()) > maxDeckName {
+				return nil, fmt.Errorf("%s: the device only takes deck names of up to %d bytes", e.Name(), maxDeckName)
+			}
+			names = append(names, e.Name())
+		}
+	}
+	return names, nil
+}
+
+// pushDecks copies dir's decks to the device and gives it the merged
+// study history, after merging in what it studied since it was last
+// synced.
+func pushDecks(c *client, dir string, out io.Writer) error {
+	rep, err := c.call("decks")
+	if err != nil {
+		return err
+	}
+	if len(rep.fields) == 1 && rep.fields[0] == "open=1" {
+		return errors.New("Flashcards is open on the device; close it first, or it will save over the history")
+	}
+	names, err := localDecks(dir)
+	if err != nil {
+		return err
+	}
+	there, err := c.deviceDecks()
+	if err != nil {
+		return err
+	}
+	onDevice := map[string]bool{}
+	for _, n := range append(there, names...) {
+		onDevice[n] = true
+	}
+	if len(onDevice) > maxDecks {
+		return fmt.Errorf("that makes %d decks on the device, and it shows %d", len(onDevice), maxDecks)
+	}
+
+	if err := c.ensureDir(deckRoot); err != nil {
+		return err
+	}
+	for _, n := range names {
+		data, err := os.ReadFile(filepath.Join(dir, n))
+		if err != nil {
+			return err
+		}
+		size, crc, err := c.checksum(deckRoot + "/" + n)
+		if err == nil && size == int64(len(data)) && crc == crc32.ChecksumIEEE(data) {
+			continue
+		} else if err != nil && !isNotFound(err) {
+			return err
+		}
+		if err := c.upload(deckRoot+"/"+n, data, nil); err != nil {
+			return fmt.Errorf("%s: %w", n, err)
+		}
+		fmt.Fprintf(out, "%s\n", n)
+	}
+
+	h, err := readHistory(dir)
+	if err != nil {
+		return err
+	}
+	id, stats, err := mergeFrom(c, h)
+	if err != nil {
+		return err
+	}
+
+	// The device's list, from the merged history: the decks on it, most
+	// recently studied first when there are more than it holds.
+	var keep []string
+	for name := range h.Decks {
+		if onDevice[name] && len(name) <= maxDeckName {
+			keep = append(keep, name)
+		}
+	}
+	sort.Slice(keep, func(i, j int) bool {
+		a, b := h.Decks[keep[i]], h.Decks[keep[j]]
+		if a.LastStudied != b.LastStudied {
+			return a.LastStudied > b.LastStudied
+		}
+		return keep[i] < keep[j]
+	})
+	keep = keep[:min(len(keep), maxDecks)]
+	sort.Strings(keep)
+	// The device's new snapshot is what it is given.
+	snap := deviceSnapshot{Synced: h.Devices[id].Synced, Decks: map[string]deckHistory{}}
+	var list []deckMeta
+	for _, name := range keep {
+		d := h.Decks[name]
+		m := deckMeta{
+			Cards:    uint16(min(d.Cards, 0xFFFF)),
+			Studied:  uint16(min(d.Studied, 0xFFFF)),
+			Correct:  uint16(min(d.Correct, 0xFFFF)),
+			LastUsed: dateNumber(d.LastStudied),
+			Sessions: uint8(d.Sessions % 256),
+		}
+		copy(m.Filename[:], name)
+		list = append(list, m)
+		_, snap.Decks[name] = metaHistory(m)
+	}
+	stats.Studied = uint32(h.Totals.Studied)
+	stats.Correct = uint32(h.Totals.Correct)
+	stats.Incorrect = uint32(h.Totals.Incorrect)
+	stats.BestStreak = uint16(max(int(stats.BestStreak), min(h.Totals.BestStreak, 0xFFFF)))
+	if err := c.ensureDir("/.sumi"); err != nil {
+		return err
+	}
+	if err := c.upload(deckMetaPath, encodeDeckMeta(list), nil); err != nil {
+		return err
+	}
+	if err := c.upload(deckStatsPath, encodeDeckStats(stats), nil); err != nil {
+		return err
+	}
+	snap.Totals = deckTotals{int(stats.Studied), int(stats.Correct), int(stats.Incorrect), int(stats.BestStreak)}
+	h.Devices[id] = snap
+	return writeHistory(dir, h)
+}
+
+// runDecks implements "decks push|pull".
+func runDecks(args []string) int {
+	usage := func() {
+		fmt.Fprintf(os.Stderr, "Usage: %s decks pull [flags] [dir]\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "       %s decks push [flags] [dir]\n\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "Keeps a folder of flashcard decks (.txt, .csv, .tsv or .json; default\n")
+		fmt.Fprintf(os.Stderr, "flashcards) and the device's Flashcards study history in step. pull\n")
+		fmt.Fprintf(os.Stderr, "copies new decks from the device and merges its history into\n")
+		fmt.Fprintf(os.Stderr, "%s in the folder; push copies the folder's decks to the device\n", historyName)
+		fmt.Fprintf(os.Stderr, "and gives it the merged history. A deck studied on two devices adds up:\n")
+		fmt.Fprintf(os.Stderr, "each device's studying since its last sync is added to the history.\n")
+		fmt.Fprintf(os.Stderr, "Close Flashcards on the device before pushing.\n")
+	}
+	if len(args) == 0 {
+		usage()
+		return 2
+	}
+	verb := args[0]
+	fs := flag.NewFlagSet("decks "+verb, flag.ContinueOnError)
+	conn := addConnFlags(fs)
+	fs.Usage = func() {
+		usage()
+		fmt.Fprintf(os.Stderr, "\n")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args[1:]); err != nil {
+		return 2
+	}
+	if (verb != "pull" && verb != "push") || fs.NArg() > 1 {
+		fs.Usage()
+		return 2
+	}
+	dir := "flashcards"
+	if fs.NArg() == 1 {
+		dir = fs.Arg(0)
+	}
+
+	c, port, err := conn.dial()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		return 1
+	}
+	defer port.Close()
+	if verb == "pull" {
+		err = pullDecks(c, dir, os.Stdout)
+	} else {
+		err = pushDecks(c, dir, os.Stdout)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// withDecks makes the fake answer the "decks" verb, with Flashcards open
+// if open is set.
+func withDecks(d *fakeDevice, open bool) {
+	d.handle = func(d *fakeDevice, id, verb string, args []string) bool {
+		switch {
+		case verb != "decks":
+			return false
+		case open:
+			d.reply(id, "ok", "open=1")
+		default:
+			d.reply(id, "ok", "open=0")
+		}
+		return true
+	}
+}
+
+func TestDeckFiles(t *testing.T) {
+	m := deckMeta{Cards: 40, Studied: 12, Correct: 9, LastUsed: 20261016, Sessions: 3}
+	copy(m.Filename[:], "spanish.csv")
+	b := encodeDeckMeta([]deckMeta{m})
+	if len(b) != 1048 {
+		t.Errorf("flashcards_decks.bin is %d bytes, want sizeof(DeckMetadataFile) 1048", len(b))
+	}
+	if got, err := parseDeckMeta(b); err != nil || len(got) != 1 || got[0] != m {
+		t.Errorf("deck list round trip = %+v, %v", got, err)
+	}
+	name, h := metaHistory(m)
+	if name != "spanish.csv" || h != (deckHistory{40, 12, 9, 3, "2026-10-16"}) || dateNumber(h.LastStudied) != 20261016 {
+		t.Errorf("metaHistory = %q %+v", name, h)
+	}
+	s := encodeDeckStats(deckStats{Studied: 5})
+	if len(s) != 60 {
+		t.Errorf("flashcards_stats.bin is %d bytes, want sizeof(FlashcardStats) 60", len(s))
+	}
+	if got, err := parseDeckStats(s); err != nil || got.Studied != 5 {
+		t.Errorf("stats round trip = %+v, %v", got, err)
+	}
+}
+
+// studied makes a device's deck list and totals: one deck, spanish.csv.
+func studied(d *fakeDevice, cards, studied, correct, sessions int) {
+	m := deckMeta{Cards: uint16(cards), Studied: uint16(studied), Correct: uint16(correct), LastUsed: 20261001 + uint32(sessions), Sessions: uint8(sessions)}
+	copy(m.Filename[:], "spanish.csv")
+	d.dirs["/.sumi"] = true
+	d.files[deckMetaPath] = encodeDeckMeta([]deckMeta{m})
+	d.files[deckStatsPath] = encodeDeckStats(deckStats{Studied: uint32(studied), Correct: uint32(correct), Incorrect: uint32(studied - correct), BestStreak: uint16(sessions)})
+}
+
+func TestDecks_TwoDevices(t *testing.T) {
+	dir := t.TempDir()
+	deck := []byte("hola,hello\nadiós,goodbye\n")
+
+	a := newFakeDevice()
+	withDecks(a, false)
+	ca := a.start(t)
+	a.dirs[deckRoot] = true
+	a.files[deckRoot+"/spanish.csv"] = deck
+	studied(a, 40, 10, 8, 2)
+	if err := pullDecks(ca, dir, io.Discard); err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := os.ReadFile(filepath.Join(dir, "spanish.csv")); string(got) != string(deck) {
+		t.Errorf("pulled deck = %q", got)
+	}
+
+	b := newFakeDevice()
+	withDecks(b, false)
+	cb := b.start(t)
+	if err := pushDecks(cb, dir, io.Discard); err != nil {
+		t.Fatal(err)
+	}
+	if string(b.files[deckRoot+"/spanish.csv"]) != string(deck) {
+		t.Errorf("pushed deck = %q", b.files[deckRoot+"/spanish.csv"])
+	}
+
+	// Both study spanish.csv, then sync in turn.
+	studied(b, 40, 18, 14, 4)
+	studied(a, 40, 13, 10, 3)
+	if err := pullDecks(cb, dir, io.Discard); err != nil {
+		t.Fatal(err)
+	}
+	if err := pushDecks(ca, dir, io.Discard); err != nil {
+		t.Fatal(err)
+	}
+
+	list, err := parseDeckMeta(a.files[deckMetaPath])
+	if err != nil || len(list) != 1 {
+		t.Fatalf("device list = %+v, %v", list, err)
+	}
+	if _, got := metaHistory(list[0]); got != (deckHistory{40, 21, 16, 5, "2026-10-05"}) {
+		t.Errorf("merged spanish.csv = %+v, want 21 studied, 16 right in 5 sessions", got)
+	}
+	stats, _ := parseDeckStats(a.files[deckStatsPath])
+	if stats.Studied != 21 || stats.Correct != 16 || stats.Incorrect != 5 || stats.BestStreak != 4 {
+		t.Errorf("merged totals = %+v", stats)
+	}
+
+	// Pushing again changes nothing.
+	h, _ := readHistory(dir)
+	if err := pushDecks(ca, dir, io.Discard); err != nil {
+		t.Fatal(err)
+	}
+	if again, _ := readHistory(dir); again.Decks["spanish.csv"] != h.Decks["spanish.csv"] || again.Totals != h.Totals {
+		t.Errorf("second push moved the history from %+v to %+v", h.Decks, again.Decks)
+	}
+}
+
+func TestDecks_PushWhileOpen(t *testing.T) {
+	dir := t.TempDir()
+	os.WriteFile(filepath.Join(dir, "spanish.csv"), []byte("hola,hello\n"), 0o644)
+	d := newFakeDevice()
+	withDecks(d, true)
+	c := d.start(t)
+	if err := pushDecks(c, dir, io.Discard); err == nil {
+		t.Errorf("pushed with Flashcards open")
+	}
+	if len(d.files) != 0 {
+		t.Errorf("wrote %d files with Flashcards open", len(d.files))
+	}
+
+	os.WriteFile(filepath.Join(dir, "a name far too long for the device.csv"), nil, 0o644)
+	if _, err := localDecks(dir); err == nil {
+		t.Errorf("took a deck name the device would cut short")
+	}
+}
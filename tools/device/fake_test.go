@@ -0,0 +1,214 @@
+package main
+
+import (
+	"bufio"
+	"encoding/base64"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// fakeDevice answers SerialCommands records the way the firmware does, from
+// an in-memory SD card, with log lines in between.
+type fakeDevice struct {
+	files    map[string][]byte
+	dirs     map[string]bool
+	protocol int
+	// handle, if set, answers verbs the fake does not know.
+	handle func(d *fakeDevice, id, verb string, args []string) bool
+	// screen, if set, is the framebuffer sent for a screenshot request,
+	// screenW pixels wide; corruptScreen sends it with a wrong CRC.
+	screen        []byte
+	screenW       int
+	corruptScreen bool
+	// dropWrite drops the reply to that many write requests, after
+	// applying them; corruptRead garbles that many read replies.
+	dropWrite, corruptRead int
+	upload                 struct {
+		path string
+		size int
+		data []byte
+	}
+	out io.Writer
+}
+
+func newFakeDevice() *fakeDevice {
+	return &fakeDevice{files: map[string][]byte{}, dirs: map[string]bool{"/": true}, protocol: protocolVersion}
+}
+
+// start connects a client to the fake.
+func (d *fakeDevice) start(t *testing.T) *client {
+	return d.startLogging(t, nil)
+}
+
+// startLogging connects a client that passes log lines to logf.
+func (d *fakeDevice) startLogging(t *testing.T, logf func(line string)) *client {
+	hostR, devW := io.Pipe()
+	devR, hostW := io.Pipe()
+	d.out = devW
+	go d.serve(devR)
+	t.Cleanup(func() { hostW.Close(); devW.Close() })
+	return newClient(hostR, hostW, 200*time.Millisecond, logf)
+}
+
+func (d *fakeDevice) reply(id, kind string, fields ...string) {
+	line := recordPrefix + id + " " + kind
+	for _, f := range fields {
+		line += " " + f
+	}
+	fmt.Fprintf(d.out, "[123] [MEM] Free: 100000 bytes\n%s%s\n", line, recordSuffix)
+}
+
+func (d *fakeDevice) serve(r io.Reader) {
+	sc := bufio.NewScanner(r)
+	for sc.Scan() {
+		if sc.Text() == screenRequest {
+			d.sendScreen()
+			continue
+		}
+		rec, ok := parseRecord(sc.Text())
+		if !ok {
+			continue
+		}
+		d.run(rec.id, rec.kind, rec.fields)
+	}
+}
+
+// sendScreen streams the framebuffer as SerialScreenshot::send does, 48
+// bytes a record.
+func (d *fakeDevice) sendScreen() {
+	stride := (d.screenW + 7) / 8
+	fmt.Fprintf(d.out, "%sbegin w=%d h=%d bpp=1 size=%d%s\n", screenPrefix, d.screenW, len(d.screen)/stride, len(d.screen), recordSuffix)
+	for i := 0; i < len(d.screen); i += 48 {
+		chunk := d.screen[i:min(i+48, len(d.screen))]
+		fmt.Fprintf(d.out, "%sdata %s%s\n", screenPrefix, base64.StdEncoding.EncodeToString(chunk), recordSuffix)
+	}
+	crc := crc32.ChecksumIEEE(d.screen)
+	if d.corruptScreen {
+		crc++
+	}
+	fmt.Fprintf(d.out, "%send crc=%08x%s\n", screenPrefix, crc, recordSuffix)
+}
+
+func (d *fakeDevice) run(id, verb string, args []string) {
+	fail := func(msg string) { d.reply(id, "err", strings.Split(msg, " ")...) }
+	switch verb {
+	case "ping":
+		d.reply(id, "ok", "protocol="+strconv.Itoa(d.protocol), "version=0.6.4")
+	case "ls":
+		if !d.dirs[args[0]] {
+			fail("not a directory")
+			return
+		}
+		var names []string
+		for p := range d.files {
+			if path.Dir(p) == args[0] {
+				names = append(names, p)
+			}
+		}
+		for p := range d.dirs {
+			if p != "/" && path.Dir(p) == args[0] {
+				names = append(names, p)
+			}
+		}
+		sort.Strings(names)
+		for _, p := range names {
+			if d.dirs[p] {
+				d.reply(id, "item", "d", "0", encodeArg(path.Base(p)))
+			} else {
+				d.reply(id, "item", "f", strconv.Itoa(len(d.files[p])), encodeArg(path.Base(p)))
+			}
+		}
+		d.reply(id, "ok")
+	case "stat":
+		if d.dirs[args[0]] {
+			d.reply(id, "ok", "d", "0")
+		} else if data, ok := d.files[args[0]]; ok {
+			d.reply(id, "ok", "f", strconv.Itoa(len(data)))
+		} else {
+			fail("not found")
+		}
+	case "read":
+		data, ok := d.files[args[0]]
+		if !ok {
+			fail("not found")
+			return
+		}
+		off, _ := strconv.Atoi(args[1])
+		n, _ := strconv.Atoi(args[2])
+		n = min(n, chunkSize, max(len(data)-off, 0))
+		b64, crc := encodeChunk(data[off : off+n])
+		if n == 0 {
+			b64 = "-"
+		}
+		if d.corruptRead > 0 {
+			d.corruptRead--
+			crc = "00000000"
+		}
+		d.reply(id, "ok", strconv.Itoa(n), b64, crc)
+	case "put":
+		size, _ := strconv.Atoi(args[1])
+		d.upload.path, d.upload.size, d.upload.data = args[0], size, nil
+		d.reply(id, "ok")
+	case "write":
+		off, _ := strconv.Atoi(args[0])
+		if off != len(d.upload.data) {
+			fail("at " + strconv.Itoa(len(d.upload.data)))
+			return
+		}
+		chunk, err := base64.StdEncoding.DecodeString(args[1])
+		if err != nil || fmt.Sprintf("%08x", crc32.ChecksumIEEE(chunk)) != args[2] {
+			fail("bad chunk")
+			return
+		}
+		d.upload.data = append(d.upload.data, chunk...)
+		if d.dropWrite > 0 {
+			d.dropWrite--
+			return
+		}
+		d.reply(id, "ok", strconv.Itoa(len(d.upload.data)))
+	case "done":
+		if len(d.upload.data) != d.upload.size || fmt.Sprintf("%08x", crc32.ChecksumIEEE(d.upload.data)) != args[0] {
+			fail("file does not match")
+			return
+		}
+		d.files[d.upload.path] = d.upload.data
+		d.upload.path = ""
+		d.reply(id, "ok")
+	case "abort":
+		d.upload.path = ""
+		d.reply(id, "ok")
+	case "mkdir":
+		for p := args[0]; p != "/"; p = path.Dir(p) {
+			d.dirs[p] = true
+		}
+		d.reply(id, "ok")
+	case "rm":
+		if _, ok := d.files[args[0]]; ok {
+			delete(d.files, args[0])
+		} else if d.dirs[args[0]] {
+			delete(d.dirs, args[0])
+		} else {
+			fail("not found")
+			return
+		}
+		d.reply(id, "ok")
+	case "sum":
+		data, ok := d.files[args[0]]
+		if !ok {
+			fail("not found")
+			return
+		}
+		d.reply(id, "ok", strconv.Itoa(len(data)), fmt.Sprintf("%08x", crc32.ChecksumIEEE(data)))
+	default:
+		if d.handle == nil || !d.handle(d, id, verb, args) {
+			fail("unknown command " + verb)
+		}
+	}
+}
@@ -0,0 +1,370 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// chunkRetries is how many times a chunk is resent after a timeout or a
+// checksum failure.
+const chunkRetries = 3
+
+// remoteEntry is a file or directory on the device's SD card.
+type remoteEntry struct {
+	name string
+	dir  bool
+	size int64
+}
+
+func parseEntry(f []string) (remoteEntry, error) {
+	if len(f) < 2 || (f[0] != "d" && f[0] != "f") {
+		return remoteEntry{}, fmt.Errorf("bad entry %q", f)
+	}
+	size, err := strconv.ParseInt(f[1], 10, 64)
+	if err != nil {
+		return remoteEntry{}, fmt.Errorf("bad entry %q", f)
+	}
+	e := remoteEntry{dir: f[0] == "d", size: size}
+	if len(f) > 2 {
+		e.name = f[2]
+	}
+	return e, nil
+}
+
+// isNotFound reports whether err is the device saying a path does not exist.
+func isNotFound(err error) bool {
+	var de *deviceError
+	return errors.As(err, &de) && de.msg == "not found"
+}
+
+// retryable reports whether a chunk is worth sending again after err.
+func retryable(err error) bool {
+	var de *deviceError
+	return errors.Is(err, errNoReply) || errors.Is(err, errBadChunk) || errors.As(err, &de) && de.msg == "bad chunk"
+}
+
+// list returns the entries of a directory.
+func (c *client) list(dir string) ([]remoteEntry, error) {
+	rep, err := c.call("ls", dir)
+	if err != nil {
+		return nil, err
+	}
+	var entries []remoteEntry
+	for _, it := range rep.items {
+		e, err := parseEntry(it)
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, e)
+	}
+	return entries, nil
+}
+
+// stat returns what is at p.
+func (c *client) stat(p string) (remoteEntry, error) {
+	rep, err := c.call("stat", p)
+	if err != nil {
+		return remoteEntry{}, err
+	}
+	e, err := parseEntry(rep.fields)
+	e.name = path.Base(p)
+	return e, err
+}
+
+// checksum returns the size and CRC-32 of a file on the device.
+func (c *client) checksum(p string) (int64, uint32, error) {
+	rep, err := c.call("sum", p)
+	if err != nil {
+		return 0, 0, err
+	}
+	if len(rep.fields) != 2 {
+		return 0, 0, fmt.Errorf("bad sum reply %q", rep.fields)
+	}
+	size, err1 := strconv.ParseInt(rep.fields[0], 10, 64)
+	crc, err2 := strconv.ParseUint(rep.fields[1], 16, 32)
+	if err1 != nil || err2 != nil {
+		return 0, 0, fmt.Errorf("bad sum reply %q", rep.fields)
+	}
+	return size, uint32(crc), nil
+}
+
+// download copies a file from the device to w, checking each chunk and then
+// the whole file against the device's checksum.
+func (c *client) download(remote string, w io.Writer, progress func(done, total int64)) (int64, error) {
+	e, err := c.stat(remote)
+	if err != nil {
+		return 0, err
+	}
+	if e.dir {
+		return 0, fmt.Errorf("%s is a directory", remote)
+	}
+	crc := crc32.NewIEEE()
+	var done int64
+	for done < e.size {
+		var data []byte
+		for attempt := 0; ; attempt++ {
+			var rep reply
+			rep, err = c.call("read", remote, strconv.FormatInt(done, 10), strconv.Itoa(chunkSize))
+			if err == nil {
+				data, err = decodeChunk(rep.fields)
+			}
+			if err == nil || !retryable(err) || attempt == chunkRetries {
+				break
+			}
+		}
+		if err != nil {
+			return done, err
+		}
+		if len(data) == 0 {
+			return done, fmt.Errorf("%s ended at %d of %d bytes", remote, done, e.size)
+		}
+		if _, err := w.Write(data); err != nil {
+			return done, err
+		}
+		crc.Write(data)
+		done += int64(len(data))
+		if progress != nil {
+			progress(done, e.size)
+		}
+	}
+	size, sum, err := c.checksum(remote)
+	if err != nil {
+		return done, err
+	}
+	if size != done || sum != crc.Sum32() {
+		return done, fmt.Errorf("%s changed on the device while it was read", remote)
+	}
+	return done, nil
+}
+
+// upload writes data to a file on the device. The device keeps the old file
+// until the new one is complete and its checksum matches.
+func (c *client) upload(remote string, data []byte, progress func(done, total int64)) error {
+	if _, err := c.call("put", remote, strconv.Itoa(len(data))); err != nil {
+		return err
+	}
+	abort := func(err error) error {
+		c.call("abort")
+		return err
+	}
+	total := int64(len(data))
+	var done int64
+	for done < total {
+		end := done + chunkSize
+		if end > total {
+			end = total
+		}
+		b64, crc := encodeChunk(data[done:end])
+		var rep reply
+		var err error
+		for attempt := 0; ; attempt++ {
+			rep, err = c.call("write", strconv.FormatInt(done, 10), b64, crc)
+			if err == nil || !retryable(err) || attempt == chunkRetries {
+				break
+			}
+		}
+		var de *deviceError
+		if errors.As(err, &de) && strings.HasPrefix(de.msg, "at ") {
+			// The device got a chunk whose reply was lost: carry on from
+			// where it is.
+			at, perr := strconv.ParseInt(strings.TrimPrefix(de.msg, "at "), 10, 64)
+			if perr != nil || at > total {
+				return abort(err)
+			}
+			done = at
+			continue
+		}
+		if err != nil {
+			return abort(err)
+		}
+		if len(rep.fields) != 1 || rep.fields[0] != strconv.FormatInt(end, 10) {
+			return abort(fmt.Errorf("device is at %q after writing up to %d", rep.fields, end))
+		}
+		done = end
+		if progress != nil {
+			progress(done, total)
+		}
+	}
+	_, err := c.call("done", fmt.Sprintf("%08x", crc32.ChecksumIEEE(data)))
+	return err
+}
+
+// ensureDir creates a directory on the device, and its parents, unless it
+// exists.
+func (c *client) ensureDir(dir string) error {
+	e, err := c.stat(dir)
+	switch {
+	case err == nil && e.dir:
+		return nil
+	case err == nil:
+		return fmt.Errorf("%s is a file", dir)
+	case !isNotFound(err):
+		return err
+	}
+	_, err = c.call("mkdir", dir)
+	return err
+}
+
+// remotePath makes p absolute on the device.
+func remotePath(p string) string {
+	return path.Clean("/" + p)
+}
+
+// printProgress shows a transfer on stderr.
+func printProgress(verb, name string) func(done, total int64) {
+	return func(done, total int64) {
+		fmt.Fprintf(os.Stderr, "\r%s %s... %3d%%", verb, name, done*100/max(total, 1))
+	}
+}
+
+// runFiles implements "files ls|get|put|rm|mkdir".
+func runFiles(args []string) int {
+	usage := func() {
+		fmt.Fprintf(os.Stderr, "Usage: %s files ls [flags] [dir]\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "       %s files get [flags] <remote> [local]\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "       %s files put [flags] <local>... <remote>\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "       %s files rm [flags] <remote>...\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "       %s files mkdir [flags] <remote>...\n\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "Manages files on the device's SD card over the USB cable. Remote paths\n")
+		fmt.Fprintf(os.Stderr, "are from the card's root, e.g. /books/dune.epub.\n")
+	}
+	if len(args) == 0 {
+		usage()
+		return 2
+	}
+	verb := args[0]
+	fs := flag.NewFlagSet("files "+verb, flag.ContinueOnError)
+	conn := addConnFlags(fs)
+	fs.Usage = func() {
+		usage()
+		fmt.Fprintf(os.Stderr, "\n")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args[1:]); err != nil {
+		return 2
+	}
+	rest := fs.Args()
+	var ok bool
+	switch verb {
+	case "ls":
+		ok = len(rest) <= 1
+	case "get":
+		ok = len(rest) == 1 || len(rest) == 2
+	case "put":
+		ok = len(rest) >= 2
+	case "rm", "mkdir":
+		ok = len(rest) >= 1
+	}
+	if !ok {
+		fs.Usage()
+		return 2
+	}
+
+	c, port, err := conn.dial()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		return 1
+	}
+	defer port.Close()
+	if err := filesCommand(c, verb, rest, os.Stdout); err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		return 1
+	}
+	return 0
+}
+
+// filesCommand runs one files verb on a connected device.
+func filesCommand(c *client, verb string, args []string, out io.Writer) error {
+	switch verb {
+	case "ls":
+		dir := "/"
+		if len(args) == 1 {
+			dir = remotePath(args[0])
+		}
+		entries, err := c.list(dir)
+		if err != nil {
+			return err
+		}
+		for _, e := range entries {
+			if e.dir {
+				fmt.Fprintf(out, "%10s  %s/\n", "-", e.name)
+			} else {
+				fmt.Fprintf(out, "%10d  %s\n", e.size, e.name)
+			}
+		}
+
+	case "get":
+		remote := remotePath(args[0])
+		local := path.Base(remote)
+		if len(args) == 2 {
+			local = args[1]
+		}
+		if st, err := os.Stat(local); err == nil && st.IsDir() {
+			local = filepath.Join(local, path.Base(remote))
+		}
+		tmp, err := os.CreateTemp(filepath.Dir(local), ".sumi-get-*")
+		if err != nil {
+			return err
+		}
+		defer os.Remove(tmp.Name())
+		n, err := c.download(remote, tmp, printProgress("Reading", remote))
+		if cerr := tmp.Close(); err == nil {
+			err = cerr
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "\n")
+			return err
+		}
+		if err := os.Rename(tmp.Name(), local); err != nil {
+			return err
+		}
+		fmt.Fprintf(os.Stderr, "\rRead %s to %s (%d bytes)\n", remote, local, n)
+
+	case "put":
+		locals, dest := args[:len(args)-1], remotePath(args[len(args)-1])
+		toDir := len(locals) > 1 || strings.HasSuffix(args[len(args)-1], "/")
+		if !toDir {
+			if e, err := c.stat(dest); err == nil && e.dir {
+				toDir = true
+			}
+		}
+		dir := path.Dir(dest)
+		if toDir {
+			dir = dest
+		}
+		if err := c.ensureDir(dir); err != nil {
+			return err
+		}
+		for _, local := range locals {
+			data, err := os.ReadFile(local)
+			if err != nil {
+				return err
+			}
+			remote := dest
+			if toDir {
+				remote = path.Join(dest, filepath.Base(local))
+			}
+			if err := c.upload(remote, data, printProgress("Writing", remote)); err != nil {
+				fmt.Fprintf(os.Stderr, "\n")
+				return fmt.Errorf("%s: %w", local, err)
+			}
+			fmt.Fprintf(os.Stderr, "\rWrote %s to %s (%d bytes)\n", local, remote, len(data))
+		}
+
+	case "rm", "mkdir":
+		for _, a := range args {
+			if _, err := c.call(verb, remotePath(a)); err != nil {
+				return fmt.Errorf("%s: %w", a, err)
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func testData(n int) []byte {
+	data := make([]byte, n)
+	for i := range data {
+		data[i] = byte(i * 7)
+	}
+	return data
+}
+
+func TestUploadDownload(t *testing.T) {
+	d := newFakeDevice()
+	c := d.start(t)
+	data := testData(3*chunkSize + 100)
+	var calls int
+	if err := c.upload("/books/a b.epub", data, func(done, total int64) { calls++ }); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(d.files["/books/a b.epub"], data) || calls != 4 {
+		t.Fatalf("uploaded %d bytes in %d chunks", len(d.files["/books/a b.epub"]), calls)
+	}
+	var got bytes.Buffer
+	n, err := c.download("/books/a b.epub", &got, nil)
+	if err != nil || n != int64(len(data)) || !bytes.Equal(got.Bytes(), data) {
+		t.Fatalf("download = %d, %v", n, err)
+	}
+}
+
+func TestDownload_RetriesBadChunk(t *testing.T) {
+	d := newFakeDevice()
+	d.files["/f"] = testData(chunkSize + 1)
+	d.corruptRead = 2
+	c := d.start(t)
+	var got bytes.Buffer
+	if _, err := c.download("/f", &got, nil); err != nil || !bytes.Equal(got.Bytes(), d.files["/f"]) {
+		t.Fatalf("download: %v", err)
+	}
+	d.corruptRead = chunkRetries + 1
+	if _, err := c.download("/f", &bytes.Buffer{}, nil); err == nil {
+		t.Error("persistently bad chunks: expected error")
+	}
+}
+
+func TestUpload_LostReply(t *testing.T) {
+	d := newFakeDevice()
+	d.dropWrite = 1
+	c := d.start(t)
+	data := testData(2 * chunkSize)
+	if err := c.upload("/f", data, nil); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(d.files["/f"], data) {
+		t.Errorf("device has %d bytes, want %d", len(d.files["/f"]), len(data))
+	}
+}
+
+func TestFilesCommand(t *testing.T) {
+	d := newFakeDevice()
+	c := d.start(t)
+	dir := t.TempDir()
+	local := filepath.Join(dir, "dune.epub")
+	if err := os.WriteFile(local, testData(1000), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := filesCommand(c, "put", []string{local, "books/"}, nil); err != nil {
+		t.Fatal(err)
+	}
+	if len(d.files["/books/dune.epub"]) != 1000 {
+		t.Fatalf("files = %v", d.files)
+	}
+	if err := filesCommand(c, "mkdir", []string{"/books/sci fi"}, nil); err != nil {
+		t.Fatal(err)
+	}
+	var out bytes.Buffer
+	if err := filesCommand(c, "ls", []string{"/books"}, &out); err != nil {
+		t.Fatal(err)
+	}
+	if want := "      1000  dune.epub\n         -  sci fi/\n"; out.String() != want {
+		t.Errorf("ls:\n%s\nwant:\n%s", out.String(), want)
+	}
+
+	if err := filesCommand(c, "get", []string{"/books/dune.epub", dir + "/copy.epub"}, nil); err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := os.ReadFile(filepath.Join(dir, "copy.epub")); !bytes.Equal(got, testData(1000)) {
+		t.Error("get wrote the wrong bytes")
+	}
+
+	if err := filesCommand(c, "rm", []string{"/books/dune.epub"}, nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := filesCommand(c, "rm", []string{"/books/dune.epub"}, nil); err == nil || !strings.Contains(err.Error(), "not found") {
+		t.Errorf("second rm: got %v", err)
+	}
+}
@@ -0,0 +1,63 @@
+// Command sumi talks to a running SUMI device. Over its USB cable it uses
+// the firmware's serial command protocol (src/util/SerialCommands.h) to
+// manage files, reading progress, settings and flashcard decks, take
+// screenshots, report the device's status, press its buttons and set its
+// clock; over Bluetooth it sends files through the file transfer service
+// (docs/BLE_FILE_TRANSFER.md).
+package main
+
+import (
+	"fmt"
+	"os"
+)
+
+func usage() {
+	fmt.Fprintf(os.Stderr, "Usage: %s files ls|get|put|rm|mkdir [flags] [args]\n", os.Args[0])
+	fmt.Fprintf(os.Stderr, "       %s sync [flags] <local dir>\n", os.Args[0])
+	fmt.Fprintf(os.Stderr, "       %s progress export|import [flags] [file]\n", os.Args[0])
+	fmt.Fprintf(os.Stderr, "       %s config get|set|names [flags] [args]\n", os.Args[0])
+	fmt.Fprintf(os.Stderr, "       %s screenshot [flags] [out.png]\n", os.Args[0])
+	fmt.Fprintf(os.Stderr, "       %s status [flags]\n", os.Args[0])
+	fmt.Fprintf(os.Stderr, "       %s press [flags] <button>... | -f script\n", os.Args[0])
+	fmt.Fprintf(os.Stderr, "       %s time [show|sync] [flags]\n", os.Args[0])
+	fmt.Fprintf(os.Stderr, "       %s decks pull|push [flags] [dir]\n", os.Args[0])
+	fmt.Fprintf(os.Stderr, "       %s ble put|scan [flags] [files]\n\n", os.Args[0])
+	fmt.Fprintf(os.Stderr, "Run a subcommand with -h for its flags. The device must be running SUMI:\n")
+	fmt.Fprintf(os.Stderr, "ble needs Settings > Wireless Transfer turned on, and the others the USB\n")
+	fmt.Fprintf(os.Stderr, "cable connected when it booted.\n")
+}
+
+func main() {
+	if len(os.Args) < 2 {
+		usage()
+		os.Exit(2)
+	}
+	switch os.Args[1] {
+	case "files":
+		os.Exit(runFiles(os.Args[2:]))
+	case "sync":
+		os.Exit(runSync(os.Args[2:]))
+	case "progress":
+		os.Exit(runProgress(os.Args[2:]))
+	case "config":
+		os.Exit(runConfig(os.Args[2:]))
+	case "screenshot":
+		os.Exit(runScreenshot(os.Args[2:]))
+	case "status":
+		os.Exit(runStatus(os.Args[2:]))
+	case "press":
+		os.Exit(runPress(os.Args[2:]))
+	case "time":
+		os.Exit(runTime(os.Args[2:]))
+	case "decks":
+		os.Exit(runDecks(os.Args[2:]))
+	case "ble":
+		os.Exit(runBle(os.Args[2:]))
+	case "-h", "-help", "--help", "help":
+		usage()
+		os.Exit(0)
+	}
+	fmt.Fprintf(os.Stderr, "%s: unknown subcommand %q\n\n", os.Args[0], os.Args[1])
+	usage()
+	os.Exit(2)
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"go.bug.st/serial"
+)
+
+// Espressif's USB vendor ID, which the ESP32-C3's built-in USB Serial/JTAG
+// port reports.
+const espressifVID = "303A"
+
+// portDetail is the USB identity of a serial port, where it has one.
+type portDetail struct {
+	name     string
+	usb      bool
+	vid, pid string
+}
+
+// detectPort returns the only Espressif USB port.
+func detectPort() (string, error) {
+	details, err := listPortDetails()
+	if err != nil {
+		return "", fmt.Errorf("%v; name the port with -port", err)
+	}
+	var boards, all []string
+	for _, d := range details {
+		all = append(all, d.name)
+		if d.usb && strings.EqualFold(d.vid, espressifVID) {
+			boards = append(boards, d.name)
+		}
+	}
+	switch len(boards) {
+	case 0:
+		return "", fmt.Errorf("no SUMI device found (ports: %v); is it plugged in?", all)
+	case 1:
+		return boards[0], nil
+	}
+	return "", fmt.Errorf("several devices found, pick one with -port: %v", boards)
+}
+
+// connFlags are the flags every subcommand that talks to a device takes.
+type connFlags struct {
+	port    *string
+	timeout *time.Duration
+	verbose *bool
+}
+
+func addConnFlags(fs *flag.FlagSet) connFlags {
+	return connFlags{
+		port:    fs.String("port", "", "serial port (auto-detected when a single SUMI device is plugged in)"),
+		timeout: fs.Duration("timeout", 5*time.Second, "how long to wait for each reply"),
+		verbose: fs.Bool("v", false, "show the device's log lines"),
+	}
+}
+
+// dial opens the device's port, without resetting it, and checks that the
+// firmware answers.
+func (f connFlags) dial() (*client, io.Closer, error) {
+	name := *f.port
+	if name == "" {
+		var err error
+		if name, err = detectPort(); err != nil {
+			return nil, nil, fmt.Errorf("auto-detect failed: %v", err)
+		}
+	}
+	// DTR and RTS stay deasserted: on the USB Serial/JTAG port they reset
+	// the chip.
+	port, err := serial.Open(name, &serial.Mode{BaudRate: 115200, InitialStatusBits: &serial.ModemOutputBits{}})
+	if err != nil {
+		return nil, nil, err
+	}
+	var logf func(string)
+	if *f.verbose {
+		logf = func(line string) { fmt.Fprintf(os.Stderr, "%s\n", line) }
+	}
+	c := newClient(port, port, *f.timeout, logf)
+	if _, err := c.hello(); err != nil {
+		port.Close()
+		return nil, nil, err
+	}
+	return c, port, nil
+}
@@ -0,0 +1,200 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// buttons are the names the firmware's "press" verb takes
+// (src/util/SerialInput.cpp), by what the button does.
+var buttons = []string{"up", "down", "left", "right", "confirm", "back", "power"}
+
+func isButton(name string) bool {
+	for _, b := range buttons {
+		if name == b {
+			return true
+		}
+	}
+	return false
+}
+
+// step is one line of a press script: a button press, a pause or a
+// screenshot.
+type step struct {
+	button string
+	long   bool
+	wait   time.Duration
+	shot   string
+}
+
+// parseScript reads a press script, one step a line:
+//
+//	down 3             press down three times
+//	long power         hold power
+//	wait 2s            pause
+//	screenshot a.png   save the screen
+//
+// Blank lines and # comments are skipped.
+func parseScript(r io.Reader) ([]step, error) {
+	var steps []step
+	sc := bufio.NewScanner(r)
+	for n := 1; sc.Scan(); n++ {
+		line, _, _ := strings.Cut(sc.Text(), "#")
+		f := strings.Fields(line)
+		if len(f) == 0 {
+			continue
+		}
+		bad := func(format string, a ...any) error {
+			return fmt.Errorf("line %d: %s", n, fmt.Sprintf(format, a...))
+		}
+		switch {
+		case f[0] == "wait" && len(f) == 2:
+			d, err := time.ParseDuration(f[1])
+			if err != nil || d < 0 {
+				return nil, bad("bad duration %q", f[1])
+			}
+			steps = append(steps, step{wait: d})
+		case f[0] == "screenshot" && len(f) == 2:
+			steps = append(steps, step{shot: f[1]})
+		case f[0] == "long" && len(f) == 2:
+			if !isButton(f[1]) {
+				return nil, bad("no button %q", f[1])
+			}
+			steps = append(steps, step{button: f[1], long: true})
+		case isButton(f[0]) && len(f) <= 2:
+			count := 1
+			if len(f) == 2 {
+				var err error
+				if count, err = strconv.Atoi(f[1]); err != nil || count < 1 {
+					return nil, bad("bad count %q", f[1])
+				}
+			}
+			for i := 0; i < count; i++ {
+				steps = append(steps, step{button: f[0]})
+			}
+		default:
+			return nil, bad("want a button, long, wait or screenshot, got %q", strings.TrimSpace(line))
+		}
+	}
+	return steps, sc.Err()
+}
+
+// press presses one button. The device turns down a press while its
+// event queue is full, so that is retried until the reply timeout.
+func (c *client) press(button string, long bool) error {
+	args := []string{button}
+	if long {
+		args = append(args, "long")
+	}
+	deadline := time.Now().Add(c.timeout)
+	for {
+		_, err := c.call("press", args...)
+		var de *deviceError
+		if errors.As(err, &de) && de.msg == "busy" && time.Now().Before(deadline) {
+			time.Sleep(100 * time.Millisecond)
+			continue
+		}
+		return err
+	}
+}
+
+// runSteps carries out steps in order, pausing for delay after each
+// press so that the device can draw the page before the next one.
+func runSteps(c *client, steps []step, delay time.Duration, log io.Writer) error {
+	for _, s := range steps {
+		switch {
+		case s.button != "":
+			if err := c.press(s.button, s.long); err != nil {
+				return fmt.Errorf("%s: %w", s.button, err)
+			}
+			time.Sleep(delay)
+		case s.shot != "":
+			img, err := c.screenshot()
+			if err != nil {
+				return err
+			}
+			if err := savePNG(s.shot, img); err != nil {
+				return err
+			}
+			fmt.Fprintf(log, "Saved %s\n", s.shot)
+		default:
+			time.Sleep(s.wait)
+		}
+	}
+	return nil
+}
+
+// runPress implements "press".
+func runPress(args []string) int {
+	fs := flag.NewFlagSet("press", flag.ContinueOnError)
+	conn := addConnFlags(fs)
+	long := fs.Bool("long", false, "hold the buttons, as for a long press")
+	delay := fs.Duration("delay", time.Second, "pause after each press, for the screen to refresh")
+	script := fs.String("f", "", "run the steps in this `file` (- for stdin)")
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: %s press [flags] <button>...\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "       %s press [flags] -f file\n\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "Presses the device's buttons over the USB cable, in order: %s.\n", strings.Join(buttons, ", "))
+		fmt.Fprintf(os.Stderr, "They are named for what they do, whatever the button layout setting.\n")
+		fmt.Fprintf(os.Stderr, "A script has a step a line: a button with an optional count (down 3),\n")
+		fmt.Fprintf(os.Stderr, "long and a button, wait and a duration (wait 2s), or screenshot and a\n")
+		fmt.Fprintf(os.Stderr, "PNG file name. # starts a comment.\n\n")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	var steps []step
+	if *script != "" {
+		if fs.NArg() != 0 {
+			fs.Usage()
+			return 2
+		}
+		var r io.Reader = os.Stdin
+		if *script != "-" {
+			f, err := os.Open(*script)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "%v\n", err)
+				return 1
+			}
+			defer f.Close()
+			r = f
+		}
+		var err error
+		if steps, err = parseScript(r); err != nil {
+			fmt.Fprintf(os.Stderr, "%s: %v\n", *script, err)
+			return 1
+		}
+	} else {
+		if fs.NArg() == 0 {
+			fs.Usage()
+			return 2
+		}
+		for _, b := range fs.Args() {
+			if !isButton(b) {
+				fmt.Fprintf(os.Stderr, "no button %q; the buttons are %s\n", b, strings.Join(buttons, ", "))
+				return 2
+			}
+			steps = append(steps, step{button: b, long: *long})
+		}
+	}
+
+	c, port, err := conn.dial()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		return 1
+	}
+	defer port.Close()
+	if err := runSteps(c, steps, *delay, os.Stderr); err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseScript(t *testing.T) {
+	script := "# open the second book\ndown 2\nconfirm\nwait 1.5s\n\nlong power  # sleep\nscreenshot sleep.png\n"
+	steps, err := parseScript(strings.NewReader(script))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []step{{button: "down"}, {button: "down"}, {button: "confirm"}, {wait: 1500e6}, {button: "power", long: true}, {shot: "sleep.png"}}
+	if !reflect.DeepEqual(steps, want) {
+		t.Errorf("steps = %+v, want %+v", steps, want)
+	}
+	for _, bad := range []string{"select", "down 0", "wait soon", "long", "up up"} {
+		if _, err := parseScript(strings.NewReader("up\n" + bad + "\n")); err == nil || !strings.HasPrefix(err.Error(), "line 2:") {
+			t.Errorf("parseScript(%q) = %v", bad, err)
+		}
+	}
+}
+
+func TestRunSteps(t *testing.T) {
+	d := newFakeDevice()
+	d.screenW = 8
+	d.screen = make([]byte, 10)
+	var pressed []string
+	busy := 1
+	d.handle = func(d *fakeDevice, id, verb string, args []string) bool {
+		if verb != "press" {
+			return false
+		}
+		if busy > 0 {
+			busy--
+			d.reply(id, "err", "busy")
+			return true
+		}
+		pressed = append(pressed, strings.Join(args, " "))
+		d.reply(id, "ok")
+		return true
+	}
+	c := d.start(t)
+
+	shot := filepath.Join(t.TempDir(), "home.png")
+	steps := []step{{button: "down"}, {button: "power", long: true}, {wait: 1}, {shot: shot}}
+	if err := runSteps(c, steps, 0, io.Discard); err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"down", "power long"}; !reflect.DeepEqual(pressed, want) {
+		t.Errorf("pressed %q, want %q", pressed, want)
+	}
+	if _, err := os.Stat(shot); err != nil {
+		t.Errorf("no screenshot: %v", err)
+	}
+}
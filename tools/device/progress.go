@@ -0,0 +1,671 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Where the firmware keeps what it knows about each book. All three files
+// are little-endian and end in a CRC-32 of what precedes it.
+const (
+	cacheRoot   = "/.sumi/cache"
+	libraryPath = "/.sumi/library.bin"       // src/content/LibraryIndex.cpp
+	statsPath   = "/.sumi/reading_stats.bin" // lib/ReadingStats/ReadingStats.cpp
+	progressBin = "progress.bin"             // src/content/ProgressManager.cpp
+	exportName  = "sumi-progress"            // the "format" of an export
+	exportVer   = 1
+)
+
+// cachePrefixes names each book's cache folder by type; the rest of the
+// name is the hash of its path.
+var cachePrefixes = map[string]string{
+	".epub": "epub", ".xtc": "xtc", ".xtch": "xtc", ".xtg": "xtc", ".xth": "xtc",
+	".txt": "txt", ".md": "md", ".markdown": "md", ".comic": "comic",
+}
+
+// murmurPath is std::hash<std::string> as the device's toolchain builds it:
+// 32-bit MurmurHash2 (LibraryIndex::hashPath).
+func murmurPath(p string) uint32 {
+	const m = 0x5bd1e995
+	data := []byte(p)
+	h := uint32(0xc70f6907) ^ uint32(len(data))
+	for ; len(data) >= 4; data = data[4:] {
+		k := binary.LittleEndian.Uint32(data)
+		k *= m
+		k ^= k >> 24
+		k *= m
+		h *= m
+		h ^= k
+	}
+	switch len(data) {
+	case 3:
+		h ^= uint32(data[2]) << 16
+		fallthrough
+	case 2:
+		h ^= uint32(data[1]) << 8
+		fallthrough
+	case 1:
+		h ^= uint32(data[0])
+		h *= m
+	}
+	h ^= h >> 13
+	h *= m
+	h ^= h >> 15
+	return h
+}
+
+// fnvPath is the FNV-1a hash reading stats key books by
+// (ReadingStats::hashPath).
+func fnvPath(p string) uint32 {
+	h := uint32(2166136261)
+	for i := 0; i < len(p); i++ {
+		h ^= uint32(p[i])
+		h *= 16777619
+	}
+	return h
+}
+
+// cacheDir is the folder holding a book's progress.bin.
+func cacheDir(book string) string {
+	prefix := cachePrefixes[strings.ToLower(path.Ext(book))]
+	return cacheRoot + "/" + prefix + "_" + strconv.FormatUint(uint64(murmurPath(book)), 10)
+}
+
+// position is a book's progress.bin. Which fields count depends on the
+// type: EPUBs use spine and page, XTC and comics flatPage, text page.
+type position struct {
+	Spine    int32  `json:"spine"`
+	Page     int32  `json:"page"`
+	FlatPage uint32 `json:"flatPage"`
+}
+
+const progressMagic = 0x474F5250 // "PROG"
+
+// parsePosition reads any progress.bin the firmware has written: v1 and v2,
+// and the 4-byte files from before they had a header.
+func parsePosition(b []byte, book string) (position, error) {
+	le := binary.LittleEndian
+	switch {
+	case len(b) >= 18 && le.Uint32(b) == progressMagic:
+		if b[4] < 1 || b[4] > 2 {
+			return position{}, fmt.Errorf("progress version %d", b[4])
+		}
+		return position{int32(le.Uint32(b[6:])), int32(le.Uint32(b[10:])), le.Uint32(b[14:])}, nil
+	case len(b) == 4:
+		switch cachePrefixes[strings.ToLower(path.Ext(book))] {
+		case "epub":
+			return position{Spine: int32(int16(le.Uint16(b))), Page: int32(int16(le.Uint16(b[2:])))}, nil
+		case "xtc", "comic":
+			return position{FlatPage: le.Uint32(b)}, nil
+		default:
+			return position{Page: int32(int16(le.Uint16(b)))}, nil
+		}
+	}
+	return position{}, fmt.Errorf("unrecognised %d-byte progress file", len(b))
+}
+
+// encodePosition writes a v2 progress.bin.
+func encodePosition(p position) []byte {
+	b := make([]byte, 22)
+	le := binary.LittleEndian
+	le.PutUint32(b, progressMagic)
+	b[4] = 2
+	le.PutUint32(b[6:], uint32(p.Spine))
+	le.PutUint32(b[10:], uint32(p.Page))
+	le.PutUint32(b[14:], p.FlatPage)
+	le.PutUint32(b[18:], crc32.ChecksumIEEE(b[:18]))
+	return b
+}
+
+// libraryEntry is a book's entry in library.bin, which the file browser
+// draws its progress bars from.
+type libraryEntry struct {
+	Hash  uint32
+	Page  uint16
+	Pages uint16
+	Hint  uint8
+}
+
+const (
+	libraryVersion = 3
+	libraryMax     = 200
+)
+
+func parseLibrary(b []byte) ([]libraryEntry, error) {
+	if len(b) < 3 || b[0] < 2 || b[0] > libraryVersion {
+		return nil, errors.New("library.bin: unknown version")
+	}
+	r := bytes.NewReader(b[1:])
+	var count uint16
+	binary.Read(r, binary.LittleEndian, &count)
+	entries := make([]libraryEntry, min(int(count), libraryMax))
+	if err := binary.Read(r, binary.LittleEndian, entries); err != nil {
+		return nil, fmt.Errorf("library.bin: %v", err)
+	}
+	return entries, nil
+}
+
+func encodeLibrary(entries []libraryEntry) []byte {
+	var buf bytes.Buffer
+	buf.WriteByte(libraryVersion)
+	binary.Write(&buf, binary.LittleEndian, uint16(len(entries)))
+	binary.Write(&buf, binary.LittleEndian, entries)
+	binary.Write(&buf, binary.LittleEndian, crc32.ChecksumIEEE(buf.Bytes()))
+	return buf.Bytes()
+}
+
+// readingStats is reading_stats.bin.
+type readingStats struct {
+	Totals statsTotals
+	Books  []bookStat
+	Daily  []dailyEntry
+}
+
+type statsTotals struct {
+	ReadingMs     uint32 `json:"readingMs"`
+	Sessions      uint16 `json:"sessions"`
+	PagesRead     uint16 `json:"pagesRead"`
+	BooksStarted  uint16 `json:"booksStarted"`
+	BooksFinished uint16 `json:"booksFinished"`
+	CurrentStreak uint16 `json:"currentStreak"`
+	LongestStreak uint16 `json:"longestStreak"`
+}
+
+type bookStat struct {
+	Hash      uint32
+	ReadingMs uint32
+	Sessions  uint16
+	PagesRead uint16
+	LastRead  uint32
+}
+
+type dailyEntry struct {
+	Day     uint16 // days since statsEpoch
+	Minutes uint16
+}
+
+const (
+	statsMagic   = 0x54535253 // "SRST"
+	statsVersion = 3
+	statsBooks   = 30
+	statsDays    = 90
+	statsEpoch   = 1704067200 // 2024-01-01, day 0 of the daily log
+)
+
+func parseStats(b []byte) (readingStats, error) {
+	var s readingStats
+	r := bytes.NewReader(b)
+	le := binary.LittleEndian
+	var magic uint32
+	var version, count uint8
+	if binary.Read(r, le, &magic) != nil || magic != statsMagic {
+		return s, errors.New("reading_stats.bin: bad magic")
+	}
+	if binary.Read(r, le, &version) != nil || version < 1 || version > statsVersion {
+		return s, errors.New("reading_stats.bin: unknown version")
+	}
+	if err := binary.Read(r, le, &s.Totals); err != nil {
+		return s, fmt.Errorf("reading_stats.bin: %v", err)
+	}
+	binary.Read(r, le, &count)
+	s.Books = make([]bookStat, min(int(count), statsBooks))
+	if err := binary.Read(r, le, s.Books); err != nil {
+		return s, fmt.Errorf("reading_stats.bin: %v", err)
+	}
+	if version >= 2 {
+		count = 0
+		binary.Read(r, le, &count)
+		s.Daily = make([]dailyEntry, min(int(count), statsDays))
+		if err := binary.Read(r, le, s.Daily); err != nil {
+			return s, fmt.Errorf("reading_stats.bin: %v", err)
+		}
+	}
+	return s, nil
+}
+
+func encodeStats(s readingStats) []byte {
+	var buf bytes.Buffer
+	le := binary.LittleEndian
+	binary.Write(&buf, le, uint32(statsMagic))
+	buf.WriteByte(statsVersion)
+	binary.Write(&buf, le, s.Totals)
+	buf.WriteByte(uint8(len(s.Books)))
+	binary.Write(&buf, le, s.Books)
+	buf.WriteByte(uint8(len(s.Daily)))
+	binary.Write(&buf, le, s.Daily)
+	binary.Write(&buf, le, crc32.ChecksumIEEE(buf.Bytes()))
+	return buf.Bytes()
+}
+
+// progressExport is the JSON document export writes and import reads
+// (docs/PROGRESS_EXPORT.md).
+type progressExport struct {
+	Format   string         `json:"format"`
+	Version  int            `json:"version"`
+	Exported string         `json:"exported"`
+	Firmware string         `json:"firmware,omitempty"`
+	Books    []bookProgress `json:"books"`
+	Totals   *statsTotals   `json:"totals,omitempty"`
+	Daily    []dayMinutes   `json:"daily,omitempty"`
+}
+
+type bookProgress struct {
+	Path     string       `json:"path"`
+	Position *position    `json:"position,omitempty"`
+	Library  *libraryPage `json:"library,omitempty"`
+	Stats    *bookStats   `json:"stats,omitempty"`
+}
+
+type libraryPage struct {
+	Page  uint16 `json:"page"`
+	Pages uint16 `json:"pages"`
+	Hint  uint8  `json:"hint,omitempty"`
+}
+
+type bookStats struct {
+	ReadingMs uint32 `json:"readingMs"`
+	Sessions  uint16 `json:"sessions"`
+	PagesRead uint16 `json:"pagesRead"`
+	// LastRead is in Unix seconds, 0 if the clock wasn't set.
+	LastRead uint32 `json:"lastRead,omitempty"`
+}
+
+type dayMinutes struct {
+	Date    string `json:"date"`
+	Minutes uint16 `json:"minutes"`
+}
+
+func dayDate(day uint16) string {
+	return time.Unix(statsEpoch+int64(day)*86400, 0).UTC().Format(time.DateOnly)
+}
+
+func dateDay(date string) (uint16, error) {
+	t, err := time.Parse(time.DateOnly, date)
+	if err != nil {
+		return 0, err
+	}
+	d := (t.Unix() - statsEpoch) / 86400
+	if d < 0 || d > 0xFFFF {
+		return 0, fmt.Errorf("%s is outside the daily log", date)
+	}
+	return uint16(d), nil
+}
+
+// fetch reads a small file from the device; a missing one is nil.
+func (c *client) fetch(p string) ([]byte, error) {
+	var buf bytes.Buffer
+	if _, err := c.download(p, &buf, nil); isNotFound(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// deviceBooks lists every book on the SD card by absolute path.
+func (c *client) deviceBooks() ([]string, error) {
+	found, err := c.remoteBooks("/")
+	if err != nil {
+		return nil, err
+	}
+	var books []string
+	for rel := range found {
+		books = append(books, "/"+rel)
+	}
+	sort.Strings(books)
+	return books, nil
+}
+
+// exportProgress gathers the position, library entry and statistics of
+// every book on the device. Statistics of books no longer on the card can't
+// be named, so only the totals keep them.
+func exportProgress(c *client) (progressExport, error) {
+	x := progressExport{Format: exportName, Version: exportVer, Exported: time.Now().UTC().Format(time.RFC3339), Books: []bookProgress{}}
+	x.Firmware, _ = c.hello()
+	books, err := c.deviceBooks()
+	if err != nil {
+		return x, err
+	}
+	cached := map[string]bool{}
+	if entries, err := c.list(cacheRoot); err == nil {
+		for _, e := range entries {
+			cached[e.name] = e.dir
+		}
+	} else if !isNotFound(err) {
+		return x, err
+	}
+	library := map[uint32]libraryEntry{}
+	if b, err := c.fetch(libraryPath); err != nil {
+		return x, err
+	} else if b != nil {
+		entries, err := parseLibrary(b)
+		if err != nil {
+			return x, err
+		}
+		for _, e := range entries {
+			library[e.Hash] = e
+		}
+	}
+	stats := map[uint32]bookStat{}
+	if b, err := c.fetch(statsPath); err != nil {
+		return x, err
+	} else if b != nil {
+		s, err := parseStats(b)
+		if err != nil {
+			return x, err
+		}
+		x.Totals = &s.Totals
+		for _, st := range s.Books {
+			stats[st.Hash] = st
+		}
+		for _, d := range s.Daily {
+			x.Daily = append(x.Daily, dayMinutes{dayDate(d.Day), d.Minutes})
+		}
+		sort.Slice(x.Daily, func(i, j int) bool { return x.Daily[i].Date < x.Daily[j].Date })
+	}
+
+	for _, book := range books {
+		bp := bookProgress{Path: book}
+		if dir := cacheDir(book); cached[path.Base(dir)] {
+			b, err := c.fetch(dir + "/" + progressBin)
+			if err != nil {
+				return x, err
+			}
+			if b != nil {
+				if pos, err := parsePosition(b, book); err == nil {
+					bp.Position = &pos
+				} else {
+					fmt.Fprintf(os.Stderr, "%s: %v\n", book, err)
+				}
+			}
+		}
+		if e, ok := library[murmurPath(book)]; ok {
+			bp.Library = &libraryPage{e.Page, e.Pages, e.Hint}
+		}
+		if st, ok := stats[fnvPath(book)]; ok {
+			bp.Stats = &bookStats{st.ReadingMs, st.Sessions, st.PagesRead, st.LastRead}
+		}
+		if bp.Position != nil || bp.Library != nil || bp.Stats != nil {
+			x.Books = append(x.Books, bp)
+		}
+	}
+	return x, nil
+}
+
+// matchBooks finds each exported book on the device: at the same path, or
+// else the one book there with the same file name, for a card laid out
+// differently.
+func matchBooks(x progressExport, onDevice []string) (map[string]string, []string) {
+	have := map[string]bool{}
+	byName := map[string][]string{}
+	for _, p := range onDevice {
+		have[p] = true
+		byName[path.Base(p)] = append(byName[path.Base(p)], p)
+	}
+	match := map[string]string{}
+	var missing []string
+	for _, b := range x.Books {
+		switch {
+		case have[b.Path]:
+			match[b.Path] = b.Path
+		case len(byName[path.Base(b.Path)]) == 1:
+			match[b.Path] = byName[path.Base(b.Path)][0]
+		default:
+			missing = append(missing, b.Path)
+		}
+	}
+	return match, missing
+}
+
+// mergeStats adds imported statistics to the device's. Counters take the
+// larger of the two, so importing twice, or into the device the export
+// came from, changes nothing.
+func mergeStats(s readingStats, x progressExport, match map[string]string) readingStats {
+	if t := x.Totals; t != nil {
+		s.Totals.ReadingMs = max(s.Totals.ReadingMs, t.ReadingMs)
+		s.Totals.Sessions = max(s.Totals.Sessions, t.Sessions)
+		s.Totals.PagesRead = max(s.Totals.PagesRead, t.PagesRead)
+		s.Totals.BooksStarted = max(s.Totals.BooksStarted, t.BooksStarted)
+		s.Totals.BooksFinished = max(s.Totals.BooksFinished, t.BooksFinished)
+		s.Totals.CurrentStreak = max(s.Totals.CurrentStreak, t.CurrentStreak)
+		s.Totals.LongestStreak = max(s.Totals.LongestStreak, t.LongestStreak)
+	}
+	books := map[uint32]bookStat{}
+	for _, st := range s.Books {
+		books[st.Hash] = st
+	}
+	for _, b := range x.Books {
+		dst, ok := match[b.Path]
+		if !ok || b.Stats == nil {
+			continue
+		}
+		h := fnvPath(dst)
+		st := books[h]
+		st.Hash = h
+		st.ReadingMs = max(st.ReadingMs, b.Stats.ReadingMs)
+		st.Sessions = max(st.Sessions, b.Stats.Sessions)
+		st.PagesRead = max(st.PagesRead, b.Stats.PagesRead)
+		st.LastRead = max(st.LastRead, b.Stats.LastRead)
+		books[h] = st
+	}
+	s.Books = s.Books[:0]
+	for _, st := range books {
+		s.Books = append(s.Books, st)
+	}
+	// The firmware evicts the book read longest ago when it runs out of
+	// room; so does the merge.
+	sort.Slice(s.Books, func(i, j int) bool {
+		if s.Books[i].LastRead != s.Books[j].LastRead {
+			return s.Books[i].LastRead > s.Books[j].LastRead
+		}
+		return s.Books[i].Hash < s.Books[j].Hash
+	})
+	s.Books = s.Books[:min(len(s.Books), statsBooks)]
+
+	days := map[uint16]uint16{}
+	for _, d := range s.Daily {
+		days[d.Day] = d.Minutes
+	}
+	for _, d := range x.Daily {
+		if day, err := dateDay(d.Date); err == nil {
+			days[day] = max(days[day], d.Minutes)
+		}
+	}
+	s.Daily = s.Daily[:0]
+	for day, minutes := range days {
+		s.Daily = append(s.Daily, dailyEntry{day, minutes})
+	}
+	sort.Slice(s.Daily, func(i, j int) bool { return s.Daily[i].Day > s.Daily[j].Day })
+	s.Daily = s.Daily[:min(len(s.Daily), statsDays)]
+	sort.Slice(s.Daily, func(i, j int) bool { return s.Daily[i].Day < s.Daily[j].Day })
+	return s
+}
+
+// importProgress writes an export's positions, library entries and
+// statistics to the device, for the books it has. It returns the exported
+// paths it couldn't place.
+func importProgress(c *client, x progressExport, out io.Writer) ([]string, error) {
+	rep, err := c.call("stats")
+	if err != nil {
+		return nil, err
+	}
+	if len(rep.fields) == 1 && rep.fields[0] == "session=1" {
+		return nil, errors.New("a book is open on the device; close it first, or it will save over the import")
+	}
+	onDevice, err := c.deviceBooks()
+	if err != nil {
+		return nil, err
+	}
+	match, missing := matchBooks(x, onDevice)
+
+	var library []libraryEntry
+	if b, err := c.fetch(libraryPath); err != nil {
+		return missing, err
+	} else if b != nil {
+		if library, err = parseLibrary(b); err != nil {
+			return missing, err
+		}
+	}
+	for _, b := range x.Books {
+		dst, ok := match[b.Path]
+		if !ok {
+			continue
+		}
+		if b.Position != nil {
+			dir := cacheDir(dst)
+			if err := c.ensureDir(cacheRoot); err != nil {
+				return missing, err
+			}
+			if err := c.ensureDir(dir); err != nil {
+				return missing, err
+			}
+			if err := c.upload(dir+"/"+progressBin, encodePosition(*b.Position), nil); err != nil {
+				return missing, fmt.Errorf("%s: %w", dst, err)
+			}
+		}
+		if b.Library != nil {
+			e := libraryEntry{murmurPath(dst), b.Library.Page, b.Library.Pages, b.Library.Hint}
+			i := 0
+			for i < len(library) && library[i].Hash != e.Hash {
+				i++
+			}
+			if i < len(library) {
+				library[i] = e
+			} else {
+				// Like the firmware, make room by dropping the oldest.
+				if len(library) == libraryMax {
+					library = library[1:]
+				}
+				library = append(library, e)
+			}
+		}
+		if dst != b.Path {
+			fmt.Fprintf(out, "%s (as %s)\n", dst, b.Path)
+		} else {
+			fmt.Fprintf(out, "%s\n", dst)
+		}
+	}
+	if len(library) > 0 {
+		if err := c.upload(libraryPath, encodeLibrary(library), nil); err != nil {
+			return missing, err
+		}
+	}
+
+	var stats readingStats
+	if b, err := c.fetch(statsPath); err != nil {
+		return missing, err
+	} else if b != nil {
+		if stats, err = parseStats(b); err != nil {
+			return missing, err
+		}
+	}
+	stats = mergeStats(stats, x, match)
+	if err := c.upload(statsPath, encodeStats(stats), nil); err != nil {
+		return missing, err
+	}
+	_, err = c.call("stats", "reload")
+	return missing, err
+}
+
+// runProgress implements "progress export|import".
+func runProgress(args []string) int {
+	usage := func() {
+		fmt.Fprintf(os.Stderr, "Usage: %s progress export [flags]\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "       %s progress import [flags] <file.json>\n\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "Saves where you are in every book on the device, and your reading\n")
+		fmt.Fprintf(os.Stderr, "statistics, as JSON (docs/PROGRESS_EXPORT.md), and puts them back on\n")
+		fmt.Fprintf(os.Stderr, "a new SD card or device. Import matches books by path, or by file name\n")
+		fmt.Fprintf(os.Stderr, "when the folders differ. Close any open book on the device first.\n")
+	}
+	if len(args) == 0 {
+		usage()
+		return 2
+	}
+	verb := args[0]
+	fs := flag.NewFlagSet("progress "+verb, flag.ContinueOnError)
+	conn := addConnFlags(fs)
+	var output *string
+	if verb == "export" {
+		output = fs.String("o", "", "file to write (standard output if empty)")
+	}
+	fs.Usage = func() {
+		usage()
+		fmt.Fprintf(os.Stderr, "\n")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args[1:]); err != nil {
+		return 2
+	}
+	var x progressExport
+	switch {
+	case verb == "export" && fs.NArg() == 0:
+	case verb == "import" && fs.NArg() == 1:
+		data, err := os.ReadFile(fs.Arg(0))
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%v\n", err)
+			return 1
+		}
+		if err := json.Unmarshal(data, &x); err != nil {
+			fmt.Fprintf(os.Stderr, "%s: %v\n", fs.Arg(0), err)
+			return 1
+		}
+		if x.Format != exportName || x.Version != exportVer {
+			fmt.Fprintf(os.Stderr, "%s is not a version %d %s export\n", fs.Arg(0), exportVer, exportName)
+			return 1
+		}
+	default:
+		fs.Usage()
+		return 2
+	}
+
+	c, port, err := conn.dial()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		return 1
+	}
+	defer port.Close()
+	if verb == "import" {
+		missing, err := importProgress(c, x, os.Stdout)
+		for _, p := range missing {
+			fmt.Fprintf(os.Stderr, "not on the device: %s\n", p)
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%v\n", err)
+			return 1
+		}
+		fmt.Fprintf(os.Stderr, "%d of %d books restored\n", len(x.Books)-len(missing), len(x.Books))
+		return 0
+	}
+
+	x, err = exportProgress(c)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		return 1
+	}
+	data, err := json.MarshalIndent(x, "", "  ")
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		return 1
+	}
+	data = append(data, '\n')
+	if *output == "" {
+		os.Stdout.Write(data)
+	} else if err := os.WriteFile(*output, data, 0o644); err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		return 1
+	}
+	fmt.Fprintf(os.Stderr, "%d books exported\n", len(x.Books))
+	return 0
+}
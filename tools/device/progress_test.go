@@ -0,0 +1,156 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"reflect"
+	"testing"
+)
+
+func TestPathHashes(t *testing.T) {
+	// From LibraryIndex::hashPath and ReadingStats::hashPath.
+	for _, tc := range []struct {
+		path        string
+		murmur, fnv uint32
+	}{
+		{"/books/Dune.epub", 3995431232, 695601773},
+		{"/a.txt", 2141556308, 2292285195},
+		{"/books/sci fi/Hyperion.epub", 4239247166, 1807378280},
+	} {
+		if got := murmurPath(tc.path); got != tc.murmur {
+			t.Errorf("murmurPath(%q) = %d, want %d", tc.path, got, tc.murmur)
+		}
+		if got := fnvPath(tc.path); got != tc.fnv {
+			t.Errorf("fnvPath(%q) = %d, want %d", tc.path, got, tc.fnv)
+		}
+	}
+	if got, want := cacheDir("/books/Dune.epub"), "/.sumi/cache/epub_3995431232"; got != want {
+		t.Errorf("cacheDir = %q, want %q", got, want)
+	}
+}
+
+func TestParsePosition(t *testing.T) {
+	want := position{Spine: -1, Page: 7, FlatPage: 300}
+	if got, err := parsePosition(encodePosition(want), "/a.epub"); err != nil || got != want {
+		t.Errorf("round trip = %+v, %v; want %+v", got, err, want)
+	}
+	legacy := []byte{3, 0, 9, 0}
+	if got, _ := parsePosition(legacy, "/a.epub"); got != (position{Spine: 3, Page: 9}) {
+		t.Errorf("legacy epub = %+v", got)
+	}
+	if got, _ := parsePosition(legacy, "/a.xtc"); got != (position{FlatPage: 0x90003}) {
+		t.Errorf("legacy xtc = %+v", got)
+	}
+	if _, err := parsePosition([]byte("garbage!"), "/a.txt"); err == nil {
+		t.Errorf("parsed garbage")
+	}
+}
+
+// withStats makes the fake answer the "stats" verb, with a book open if
+// session is set.
+func withStats(d *fakeDevice, session bool, reloads *int) {
+	d.handle = func(d *fakeDevice, id, verb string, args []string) bool {
+		switch {
+		case verb != "stats":
+			return false
+		case len(args) == 0 && session:
+			d.reply(id, "ok", "session=1")
+		case len(args) == 0:
+			d.reply(id, "ok", "session=0")
+		default:
+			*reloads++
+			d.reply(id, "ok")
+		}
+		return true
+	}
+}
+
+func TestProgress_ExportImport(t *testing.T) {
+	old := newFakeDevice()
+	c := old.start(t)
+	old.dirs["/books"] = true
+	old.dirs["/.sumi/cache"] = true
+	old.files["/books/Dune.epub"] = testData(100)
+	old.files["/books/a.txt"] = testData(100)
+	old.files["/books/unread.epub"] = testData(100)
+	dune := cacheDir("/books/Dune.epub")
+	old.dirs[dune] = true
+	old.files[dune+"/progress.bin"] = encodePosition(position{Spine: 4, Page: 12, FlatPage: 40})
+	old.files[libraryPath] = encodeLibrary([]libraryEntry{{murmurPath("/books/Dune.epub"), 5, 30, 0}, {12345, 1, 2, 0}})
+	old.files[statsPath] = encodeStats(readingStats{
+		Totals: statsTotals{ReadingMs: 9000000, Sessions: 12, PagesRead: 300, BooksStarted: 3, LongestStreak: 4},
+		Books:  []bookStat{{fnvPath("/books/Dune.epub"), 8000000, 10, 250, 1760000000}, {999, 1, 1, 1, 1}},
+		Daily:  []dailyEntry{{650, 45}, {651, 20}},
+	})
+
+	x, err := exportProgress(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []bookProgress{{
+		Path:     "/books/Dune.epub",
+		Position: &position{4, 12, 40},
+		Library:  &libraryPage{5, 30, 0},
+		Stats:    &bookStats{8000000, 10, 250, 1760000000},
+	}}
+	if !reflect.DeepEqual(x.Books, want) {
+		t.Errorf("books = %+v, want %+v", x.Books, want)
+	}
+	if x.Firmware != "0.6.4" || x.Totals == nil || x.Totals.Sessions != 12 || len(x.Daily) != 2 || x.Daily[0].Date != "2025-10-12" {
+		t.Errorf("export = %+v", x)
+	}
+
+	// A new card, with Dune in another folder and some reading of its own.
+	d := newFakeDevice()
+	c = d.start(t)
+	var reloads int
+	withStats(d, false, &reloads)
+	d.dirs["/sf"] = true
+	d.files["/sf/Dune.epub"] = testData(100)
+	d.files[statsPath] = encodeStats(readingStats{
+		Totals: statsTotals{ReadingMs: 60000, Sessions: 20},
+		Daily:  []dailyEntry{{651, 30}, {700, 5}},
+	})
+	x.Books = append(x.Books, bookProgress{Path: "/books/gone.epub", Position: &position{}})
+	var out bytes.Buffer
+	missing, err := importProgress(c, x, &out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(missing, []string{"/books/gone.epub"}) {
+		t.Errorf("missing = %q", missing)
+	}
+	if reloads != 1 {
+		t.Errorf("device reloaded its stats %d times, want 1", reloads)
+	}
+
+	back, err := exportProgress(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want[0].Path = "/sf/Dune.epub"
+	if !reflect.DeepEqual(back.Books, want) {
+		t.Errorf("imported books = %+v, want %+v", back.Books, want)
+	}
+	if wantTotals := (statsTotals{ReadingMs: 9000000, Sessions: 20, PagesRead: 300, BooksStarted: 3, LongestStreak: 4}); *back.Totals != wantTotals {
+		t.Errorf("totals = %+v, want %+v", *back.Totals, wantTotals)
+	}
+	wantDaily := []dayMinutes{{"2025-10-12", 45}, {"2025-10-13", 30}, {"2025-12-01", 5}}
+	if !reflect.DeepEqual(back.Daily, wantDaily) {
+		t.Errorf("daily = %+v, want %+v", back.Daily, wantDaily)
+	}
+}
+
+func TestProgress_ImportBookOpen(t *testing.T) {
+	d := newFakeDevice()
+	c := d.start(t)
+	var reloads int
+	withStats(d, true, &reloads)
+	x := progressExport{Format: exportName, Version: exportVer}
+	if _, err := importProgress(c, x, io.Discard); err == nil {
+		t.Errorf("imported with a book open")
+	}
+	if len(d.files) != 0 {
+		t.Errorf("wrote %d files with a book open", len(d.files))
+	}
+}
@@ -0,0 +1,238 @@
+package main
+
+import (
+	"bufio"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// The firmware takes commands as APC escape records, one per line, and
+// answers the same way among its log lines (src/util/SerialCommands.h):
+//
+//	host:   ESC_SUMI <id> <verb> <args...> ESC\
+//	device: ESC_SUMI <id> item <fields...> ESC\   (zero or more)
+//	device: ESC_SUMI <id> ok <fields...> ESC\  or  ESC_SUMI <id> err <message> ESC\
+//
+// Arguments and fields are percent-encoded; file data is base64 followed by
+// the CRC-32 of the decoded bytes in hex.
+const (
+	recordPrefix = "\x1b_SUMI "
+	recordSuffix = "\x1b\\"
+)
+
+// protocolVersion is the SerialCommands version this tool speaks.
+const protocolVersion = 1
+
+// chunkSize is the most file data one request carries, as the firmware's
+// SerialCommands::CHUNK.
+const chunkSize = 512
+
+// errNoReply is returned when the device does not answer in time.
+var errNoReply = errors.New("no reply from the device; it must be running SUMI with serial commands, and only listens if the USB cable was connected when it booted")
+
+// errBadChunk is returned for file data that fails its checksum.
+var errBadChunk = errors.New("bad chunk")
+
+// deviceError is an err reply.
+type deviceError struct {
+	verb, msg string
+}
+
+func (e *deviceError) Error() string { return e.verb + ": " + e.msg }
+
+// reply is a command's item records and the fields of its ok record.
+type reply struct {
+	items  [][]string
+	fields []string
+}
+
+// record is one parsed reply record.
+type record struct {
+	id, kind string
+	fields   []string
+}
+
+// client sends commands to the firmware and waits for their replies.
+type client struct {
+	w       io.Writer
+	records chan record
+	timeout time.Duration
+	next    uint32
+	// logf, if set, receives the device's log lines. It is called from
+	// the reading goroutine.
+	logf func(line string)
+}
+
+// newClient starts reading replies from r. Requests go to w, and log lines
+// to logf unless it is nil.
+func newClient(r io.Reader, w io.Writer, timeout time.Duration, logf func(line string)) *client {
+	c := &client{
+		w:       w,
+		records: make(chan record, 64),
+		timeout: timeout,
+		logf:    logf,
+		// Start from the clock, so a late reply to an earlier run's request
+		// cannot match.
+		next: uint32(time.Now().UnixNano()),
+	}
+	go c.read(r)
+	return c
+}
+
+// read splits the device's output into lines and passes on reply records.
+func (c *client) read(r io.Reader) {
+	defer close(c.records)
+	br := bufio.NewReaderSize(r, 4096)
+	for {
+		line, err := br.ReadString('\n')
+		if line != "" {
+			if rec, ok := parseRecord(line); ok {
+				c.records <- rec
+			} else if rec, ok := parseScreenRecord(line); ok {
+				c.records <- rec
+			} else if c.logf != nil {
+				c.logf(strings.TrimRight(line, "\r\n"))
+			}
+		}
+		if err != nil {
+			return
+		}
+	}
+}
+
+// parseRecord picks a reply record out of a line of output.
+func parseRecord(line string) (record, bool) {
+	i := strings.Index(line, recordPrefix)
+	if i < 0 {
+		return record{}, false
+	}
+	body, _, ok := strings.Cut(line[i+len(recordPrefix):], recordSuffix)
+	if !ok {
+		return record{}, false
+	}
+	f := strings.Split(body, " ")
+	if len(f) < 2 {
+		return record{}, false
+	}
+	rec := record{id: f[0], kind: f[1]}
+	for _, s := range f[2:] {
+		if v, err := url.PathUnescape(s); err == nil {
+			s = v
+		}
+		rec.fields = append(rec.fields, s)
+	}
+	return rec, true
+}
+
+// encodeArg percent-encodes what would break a record: spaces, '%' and
+// control characters.
+func encodeArg(s string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if c := s[i]; c <= ' ' || c == '%' || c == 0x7f {
+			fmt.Fprintf(&b, "%%%02X", c)
+		} else {
+			b.WriteByte(c)
+		}
+	}
+	return b.String()
+}
+
+// call sends a command and waits for its ok or err.
+func (c *client) call(verb string, args ...string) (reply, error) {
+	c.next++
+	id := strconv.FormatUint(uint64(c.next), 36)
+	var req strings.Builder
+	req.WriteString(recordPrefix + id + " " + verb)
+	for _, a := range args {
+		req.WriteString(" " + encodeArg(a))
+	}
+	req.WriteString(recordSuffix + "\n")
+	if _, err := io.WriteString(c.w, req.String()); err != nil {
+		return reply{}, err
+	}
+
+	var rep reply
+	deadline := time.NewTimer(c.timeout)
+	defer deadline.Stop()
+	for {
+		select {
+		case rec, ok := <-c.records:
+			if !ok {
+				return reply{}, errors.New("the device went away")
+			}
+			if rec.id != id {
+				continue
+			}
+			switch rec.kind {
+			case "item":
+				rep.items = append(rep.items, rec.fields)
+			case "ok":
+				rep.fields = rec.fields
+				return rep, nil
+			case "err":
+				return reply{}, &deviceError{verb, strings.Join(rec.fields, " ")}
+			}
+		case <-deadline.C:
+			return reply{}, fmt.Errorf("%s: %w", verb, errNoReply)
+		}
+	}
+}
+
+// hello checks that the firmware speaks this protocol and returns its
+// version.
+func (c *client) hello() (string, error) {
+	rep, err := c.call("ping")
+	if err != nil {
+		return "", err
+	}
+	var proto int
+	var version string
+	for _, f := range rep.fields {
+		k, v, _ := strings.Cut(f, "=")
+		switch k {
+		case "protocol":
+			proto, _ = strconv.Atoi(v)
+		case "version":
+			version = v
+		}
+	}
+	if proto != protocolVersion {
+		return "", fmt.Errorf("firmware %s speaks protocol %d, this tool %d; update both to the same release", version, proto, protocolVersion)
+	}
+	return version, nil
+}
+
+// encodeChunk returns data as the base64 and CRC arguments of a write.
+func encodeChunk(data []byte) (string, string) {
+	return base64.StdEncoding.EncodeToString(data), fmt.Sprintf("%08x", crc32.ChecksumIEEE(data))
+}
+
+// decodeChunk checks and decodes the length, base64 and CRC fields of a
+// read reply.
+func decodeChunk(fields []string) ([]byte, error) {
+	if len(fields) != 3 {
+		return nil, fmt.Errorf("bad read reply %q", fields)
+	}
+	n, err := strconv.Atoi(fields[0])
+	if err != nil {
+		return nil, fmt.Errorf("bad read reply %q", fields)
+	}
+	var data []byte
+	if fields[1] != "-" {
+		if data, err = base64.StdEncoding.DecodeString(fields[1]); err != nil {
+			return nil, fmt.Errorf("%w: %v", errBadChunk, err)
+		}
+	}
+	if len(data) != n || fmt.Sprintf("%08x", crc32.ChecksumIEEE(data)) != strings.ToLower(fields[2]) {
+		return nil, fmt.Errorf("%w: checksum mismatch", errBadChunk)
+	}
+	return data, nil
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseRecord(t *testing.T) {
+	tests := []struct {
+		line string
+		want record
+		ok   bool
+	}{
+		{"\x1b_SUMI 1a ok protocol=1\x1b\\\r\n", record{"1a", "ok", []string{"protocol=1"}}, true},
+		{"\x1b_SUMI 7 item f 12 my%20book.epub\x1b\\\n", record{"7", "item", []string{"f", "12", "my book.epub"}}, true},
+		{"[100] [SER] log line\n", record{}, false},
+		{"\x1b_SUMIFB data AAAA\x1b\\\n", record{}, false},
+		{"\x1b_SUMI 7 ok cut off\n", record{}, false},
+	}
+	for _, tt := range tests {
+		got, ok := parseRecord(tt.line)
+		if ok != tt.ok || !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("parseRecord(%q) = %+v, %v; want %+v, %v", tt.line, got, ok, tt.want, tt.ok)
+		}
+	}
+}
+
+func TestEncodeArg(t *testing.T) {
+	in := "/books/100% true\x1bstory.epub"
+	enc := encodeArg(in)
+	if strings.ContainsAny(enc, " \x1b") || enc != "/books/100%25%20true%1Bstory.epub" {
+		t.Errorf("encodeArg = %q", enc)
+	}
+	rec, ok := parseRecord(recordPrefix + "1 ok " + enc + recordSuffix)
+	if !ok || rec.fields[0] != in {
+		t.Errorf("round trip gave %q", rec.fields)
+	}
+}
+
+func TestCall(t *testing.T) {
+	d := newFakeDevice()
+	var logged []string
+	c := d.startLogging(t, func(line string) { logged = append(logged, line) })
+	version, err := c.hello()
+	if err != nil || version != "0.6.4" {
+		t.Fatalf("hello = %q, %v", version, err)
+	}
+	if len(logged) == 0 || !strings.Contains(logged[0], "[MEM]") {
+		t.Errorf("log lines not passed on: %q", logged)
+	}
+
+	_, err = c.call("frobnicate")
+	var de *deviceError
+	if !errors.As(err, &de) || de.msg != "unknown command frobnicate" {
+		t.Errorf("unknown verb: got %v", err)
+	}
+
+	// A reply to some other request is not taken for this one.
+	d.handle = func(d *fakeDevice, id, verb string, args []string) bool {
+		d.reply("stale", "ok", "wrong")
+		d.reply(id, "ok", "right")
+		return true
+	}
+	rep, err := c.call("custom")
+	if err != nil || len(rep.fields) != 1 || rep.fields[0] != "right" {
+		t.Errorf("custom = %+v, %v", rep, err)
+	}
+
+	d.handle = func(*fakeDevice, string, string, []string) bool { return true }
+	if _, err := c.call("silent"); !errors.Is(err, errNoReply) {
+		t.Errorf("silent: got %v, want errNoReply", err)
+	}
+}
+
+func TestHello_WrongProtocol(t *testing.T) {
+	d := newFakeDevice()
+	d.protocol = protocolVersion + 1
+	c := d.start(t)
+	if _, err := c.hello(); err == nil || !strings.Contains(err.Error(), "update both") {
+		t.Errorf("got %v, want a protocol mismatch", err)
+	}
+}
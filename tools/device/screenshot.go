@@ -0,0 +1,183 @@
+package main
+
+import (
+	"encoding/base64"
+	"errors"
+	"flag"
+	"fmt"
+	"hash/crc32"
+	"image"
+	"image/png"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Screenshots have their own records (src/util/SerialScreenshot.h), sent
+// whole after a request rather than as a reply to a command:
+//
+//	ESC_SUMIFB begin w=480 h=800 bpp=1 size=48000 ESC\
+//	ESC_SUMIFB data <base64> ESC\     (repeated)
+//	ESC_SUMIFB end crc=<crc32 of the pixels, hex> ESC\
+//
+// Pixels are portrait rows, top to bottom, MSB first, with 1 for white.
+// tools/monitor reads the same records.
+const (
+	screenPrefix  = "\x1b_SUMIFB "
+	screenRequest = screenPrefix + "shot" + recordSuffix
+)
+
+// parseScreenRecord picks a screenshot record out of a line of output, as a
+// record with no id: its kind is begin, data or end, and its one field the
+// rest of the record.
+func parseScreenRecord(line string) (record, bool) {
+	i := strings.Index(line, screenPrefix)
+	if i < 0 {
+		return record{}, false
+	}
+	body, _, ok := strings.Cut(line[i+len(screenPrefix):], recordSuffix)
+	if !ok {
+		return record{}, false
+	}
+	kind, rest, _ := strings.Cut(body, " ")
+	return record{kind: kind, fields: []string{rest}}, true
+}
+
+// screenshot asks the device for its framebuffer and returns it once it
+// has arrived whole.
+func (c *client) screenshot() (*image.Gray, error) {
+	if _, err := io.WriteString(c.w, screenRequest+"\n"); err != nil {
+		return nil, err
+	}
+	var w, h, bpp, size int
+	var data []byte
+	begun := false
+	for {
+		var rec record
+		select {
+		case r, ok := <-c.records:
+			if !ok {
+				return nil, errors.New("the device went away")
+			}
+			rec = r
+		case <-time.After(c.timeout):
+			return nil, fmt.Errorf("screenshot: %w", errNoReply)
+		}
+		if rec.id != "" || (!begun && rec.kind != "begin") {
+			continue
+		}
+		switch rec.kind {
+		case "begin":
+			begun, data = true, nil
+			w, h, bpp, size = 0, 0, 0, 0
+			for _, kv := range strings.Fields(rec.fields[0]) {
+				k, v, _ := strings.Cut(kv, "=")
+				n, _ := strconv.Atoi(v)
+				switch k {
+				case "w":
+					w = n
+				case "h":
+					h = n
+				case "bpp":
+					bpp = n
+				case "size":
+					size = n
+				}
+			}
+			if bpp != 1 {
+				return nil, fmt.Errorf("screenshot: the device sent %d bits per pixel; this tool reads 1", bpp)
+			}
+			if w <= 0 || h <= 0 || size != (w+7)/8*h {
+				return nil, fmt.Errorf("screenshot: bad header %q", rec.fields[0])
+			}
+		case "data":
+			chunk, err := base64.StdEncoding.DecodeString(rec.fields[0])
+			if err != nil || len(data)+len(chunk) > size {
+				return nil, errors.New("screenshot: corrupt data record")
+			}
+			data = append(data, chunk...)
+		case "end":
+			want, _ := strings.CutPrefix(rec.fields[0], "crc=")
+			if len(data) != size {
+				return nil, fmt.Errorf("screenshot: got %d of %d bytes", len(data), size)
+			}
+			if fmt.Sprintf("%08x", crc32.ChecksumIEEE(data)) != strings.ToLower(want) {
+				return nil, errors.New("screenshot: checksum mismatch")
+			}
+			return decodeFramebuffer(data, w, h), nil
+		}
+	}
+}
+
+// decodeFramebuffer turns 1-bit rows (MSB first, 1 = white) into a grayscale
+// image.
+func decodeFramebuffer(data []byte, w, h int) *image.Gray {
+	img := image.NewGray(image.Rect(0, 0, w, h))
+	stride := (w + 7) / 8
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			if data[y*stride+x/8]&(0x80>>(x%8)) != 0 {
+				img.Pix[y*img.Stride+x] = 0xff
+			}
+		}
+	}
+	return img
+}
+
+// savePNG writes img to the file name.
+func savePNG(name string, img image.Image) error {
+	f, err := os.Create(name)
+	if err != nil {
+		return err
+	}
+	if err := png.Encode(f, img); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// runScreenshot implements "screenshot".
+func runScreenshot(args []string) int {
+	fs := flag.NewFlagSet("screenshot", flag.ContinueOnError)
+	conn := addConnFlags(fs)
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: %s screenshot [flags] [out.png]\n\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "Saves what the device's screen shows as a PNG, in portrait as the\n")
+		fmt.Fprintf(os.Stderr, "Back+Up screenshots are, named after the time unless out.png is given.\n")
+		fmt.Fprintf(os.Stderr, "Anti-aliasing and grayscale images are left out: the device only keeps\n")
+		fmt.Fprintf(os.Stderr, "the black and white layer of a page in memory.\n\n")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if fs.NArg() > 1 {
+		fs.Usage()
+		return 2
+	}
+	out := "sumi-" + time.Now().Format("20060102-150405") + ".png"
+	if fs.NArg() == 1 {
+		out = fs.Arg(0)
+	}
+
+	c, port, err := conn.dial()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		return 1
+	}
+	defer port.Close()
+	img, err := c.screenshot()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		return 1
+	}
+	if err := savePNG(out, img); err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		return 1
+	}
+	fmt.Fprintf(os.Stderr, "Saved %dx%d screenshot to %s\n", img.Rect.Dx(), img.Rect.Dy(), out)
+	return 0
+}
@@ -0,0 +1,39 @@
+package main
+
+import "testing"
+
+func TestScreenshot(t *testing.T) {
+	d := newFakeDevice()
+	// 12x100: two bytes a row, the first pixel of each row black and the
+	// rest white.
+	d.screenW = 12
+	for y := 0; y < 100; y++ {
+		d.screen = append(d.screen, 0x7f, 0xff)
+	}
+	c := d.start(t)
+	// A command reply in flight is no screenshot record.
+	if _, err := c.hello(); err != nil {
+		t.Fatal(err)
+	}
+	img, err := c.screenshot()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if img.Rect.Dx() != 12 || img.Rect.Dy() != 100 {
+		t.Fatalf("size %v, want 12x100", img.Rect)
+	}
+	if img.GrayAt(0, 50).Y != 0 || img.GrayAt(1, 50).Y != 0xff || img.GrayAt(11, 99).Y != 0xff {
+		t.Errorf("pixels %v %v %v", img.GrayAt(0, 50), img.GrayAt(1, 50), img.GrayAt(11, 99))
+	}
+}
+
+func TestScreenshot_BadChecksum(t *testing.T) {
+	d := newFakeDevice()
+	d.screenW = 8
+	d.screen = make([]byte, 10)
+	d.corruptScreen = true
+	c := d.start(t)
+	if _, err := c.screenshot(); err == nil {
+		t.Errorf("took a screenshot that fails its checksum")
+	}
+}
@@ -0,0 +1,179 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"time"
+)
+
+// deviceStatus is what the firmware's "status" verb reports
+// (src/util/SerialStatus.cpp). Figures the device left out stay zero.
+type deviceStatus struct {
+	Firmware string `json:"firmware"`
+	UptimeMs int64  `json:"uptime_ms"`
+	Battery  struct {
+		Percent    int `json:"percent"`
+		Millivolts int `json:"millivolts"`
+	} `json:"battery"`
+	Storage *storageSpace `json:"storage,omitempty"`
+	Memory  struct {
+		Free    int64 `json:"free_bytes"`
+		MinFree int64 `json:"min_free_bytes"`
+	} `json:"memory"`
+	Fonts struct {
+		Reader string `json:"reader"`
+		Loaded int    `json:"loaded"`
+		Bytes  int64  `json:"bytes"`
+		CJK    string `json:"cjk,omitempty"`
+	} `json:"fonts"`
+	Book string `json:"book,omitempty"`
+}
+
+// storageSpace is the card's size; nil in deviceStatus when the device
+// couldn't read it.
+type storageSpace struct {
+	Total int64 `json:"total_bytes"`
+	Free  int64 `json:"free_bytes"`
+}
+
+// statusTimeout is the least time status waits for its reply.
+const statusTimeout = 30 * time.Second
+
+// status asks the device how it is doing.
+func (c *client) status() (deviceStatus, error) {
+	var s deviceStatus
+	// The device counts the card's free clusters before it answers, which
+	// can outlast the usual reply timeout on a large card.
+	if old := c.timeout; old < statusTimeout {
+		c.timeout = statusTimeout
+		defer func() { c.timeout = old }()
+	}
+	rep, err := c.call("status")
+	if err != nil {
+		return s, err
+	}
+	for _, it := range rep.items {
+		if len(it) != 2 {
+			return s, fmt.Errorf("bad status item %q", it)
+		}
+		k, v := it[0], it[1]
+		n, _ := strconv.ParseInt(v, 10, 64)
+		switch k {
+		case "version":
+			s.Firmware = v
+		case "uptime":
+			s.UptimeMs = n
+		case "heap":
+			s.Memory.Free = n
+		case "heapmin":
+			s.Memory.MinFree = n
+		case "millivolts":
+			s.Battery.Millivolts = int(n)
+		case "battery":
+			s.Battery.Percent = int(n)
+		case "sdtotal", "sdfree":
+			if s.Storage == nil {
+				s.Storage = &storageSpace{}
+			}
+			if k == "sdtotal" {
+				s.Storage.Total = n
+			} else {
+				s.Storage.Free = n
+			}
+		case "font":
+			s.Fonts.Reader = v
+		case "fonts":
+			s.Fonts.Loaded = int(n)
+		case "fontmem":
+			s.Fonts.Bytes = n
+		case "cjk":
+			s.Fonts.CJK = v
+		case "book":
+			s.Book = v
+		}
+	}
+	return s, nil
+}
+
+// byteSize formats n bytes for people, in powers of 1024.
+func byteSize(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGT"[exp])
+}
+
+// printStatus writes s as aligned lines, or as JSON.
+func printStatus(w io.Writer, s deviceStatus, asJSON bool) error {
+	if asJSON {
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(s)
+	}
+	fmt.Fprintf(w, "Firmware:  %s\n", s.Firmware)
+	fmt.Fprintf(w, "Uptime:    %s\n", time.Duration(s.UptimeMs/1000)*time.Second)
+	fmt.Fprintf(w, "Battery:   %d%% (%.2f V)\n", s.Battery.Percent, float64(s.Battery.Millivolts)/1000)
+	if s.Storage != nil {
+		fmt.Fprintf(w, "Storage:   %s free of %s\n", byteSize(s.Storage.Free), byteSize(s.Storage.Total))
+	} else {
+		fmt.Fprintf(w, "Storage:   unknown\n")
+	}
+	fmt.Fprintf(w, "Memory:    %s free, %s at least\n", byteSize(s.Memory.Free), byteSize(s.Memory.MinFree))
+	fmt.Fprintf(w, "Font:      %s, %d loaded using %s\n", s.Fonts.Reader, s.Fonts.Loaded, byteSize(s.Fonts.Bytes))
+	if s.Fonts.CJK != "" {
+		fmt.Fprintf(w, "CJK font:  %s\n", s.Fonts.CJK)
+	}
+	book := s.Book
+	if book == "" {
+		book = "none open"
+	}
+	fmt.Fprintf(w, "Book:      %s\n", book)
+	return nil
+}
+
+// runStatus implements "status".
+func runStatus(args []string) int {
+	fs := flag.NewFlagSet("status", flag.ContinueOnError)
+	conn := addConnFlags(fs)
+	asJSON := fs.Bool("json", false, "print JSON, for scripts")
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: %s status [flags]\n\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "Shows the device's battery, free storage, firmware version, memory and\n")
+		fmt.Fprintf(os.Stderr, "fonts, the book it has open and how long since it booted.\n\n")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if fs.NArg() != 0 {
+		fs.Usage()
+		return 2
+	}
+
+	c, port, err := conn.dial()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		return 1
+	}
+	defer port.Close()
+	s, err := c.status()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		return 1
+	}
+	if err := printStatus(os.Stdout, s, *asJSON); err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		return 1
+	}
+	return 0
+}
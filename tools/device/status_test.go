@@ -0,0 +1,52 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestStatus(t *testing.T) {
+	d := newFakeDevice()
+	d.handle = func(d *fakeDevice, id, verb string, args []string) bool {
+		if verb != "status" {
+			return false
+		}
+		for _, it := range [][]string{
+			{"version", "0.6.4"}, {"uptime", "3723500"}, {"heap", "81920"}, {"heapmin", "40960"},
+			{"millivolts", "3912"}, {"battery", "71"},
+			{"sdtotal", "31914983424"}, {"sdfree", "12884901888"},
+			{"font", "Literata"}, {"fonts", "1"}, {"fontmem", "24576"},
+			{"book", encodeArg("/books/sci fi/Hyperion.epub")},
+		} {
+			d.reply(id, "item", it...)
+		}
+		d.reply(id, "ok")
+		return true
+	}
+	c := d.start(t)
+
+	s, err := c.status()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.Firmware != "0.6.4" || s.Battery.Percent != 71 || s.Storage == nil || s.Storage.Free != 12884901888 ||
+		s.Fonts.Reader != "Literata" || s.Fonts.CJK != "" || s.Book != "/books/sci fi/Hyperion.epub" {
+		t.Errorf("status = %+v", s)
+	}
+
+	var out bytes.Buffer
+	printStatus(&out, s, false)
+	for _, want := range []string{"Uptime:    1h2m3s\n", "Battery:   71% (3.91 V)\n", "Storage:   12.0 GiB free of 29.7 GiB\n", "Memory:    80.0 KiB free"} {
+		if !strings.Contains(out.String(), want) {
+			t.Errorf("printed\n%s\nwithout %q", out.String(), want)
+		}
+	}
+	out.Reset()
+	printStatus(&out, s, true)
+	var back deviceStatus
+	if err := json.Unmarshal(out.Bytes(), &back); err != nil || back.Book != s.Book || back.UptimeMs != 3723500 || *back.Storage != *s.Storage {
+		t.Errorf("JSON %s read back as %+v, %v", out.String(), back, err)
+	}
+}
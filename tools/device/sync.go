@@ -0,0 +1,283 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// bookTypes are the files the reader opens (src/content/ContentTypes.cpp);
+// sync leaves everything else alone on both sides.
+var bookTypes = map[string]bool{
+	".epub": true, ".xtc": true, ".xtch": true, ".xtg": true, ".xth": true,
+	".txt": true, ".md": true, ".markdown": true, ".comic": true,
+}
+
+func isBook(name string) bool {
+	return bookTypes[strings.ToLower(path.Ext(name))] && !strings.HasPrefix(name, ".")
+}
+
+// syncPlan is what a sync does. Paths are relative to the two roots, with
+// forward slashes.
+type syncPlan struct {
+	add, update, remove []string
+	// same counts the books already on the device; skipped lists local
+	// files the reader can't open.
+	same    int
+	skipped []string
+}
+
+// localBooks returns the size of every book under dir, and the files that
+// aren't books.
+func localBooks(dir string) (map[string]int64, []string, error) {
+	books := map[string]int64{}
+	var skipped []string
+	err := filepath.WalkDir(dir, func(p string, d os.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if strings.HasPrefix(d.Name(), ".") && p != dir {
+			if d.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if d.IsDir() {
+			return nil
+		}
+		rel, err := filepath.Rel(dir, p)
+		if err != nil {
+			return err
+		}
+		rel = filepath.ToSlash(rel)
+		if !isBook(d.Name()) {
+			skipped = append(skipped, rel)
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		books[rel] = info.Size()
+		return nil
+	})
+	return books, skipped, err
+}
+
+// remoteBooks returns the size of every book under dir on the device. A
+// missing dir has no books.
+func (c *client) remoteBooks(dir string) (map[string]int64, error) {
+	books := map[string]int64{}
+	var walk func(rel string) error
+	walk = func(rel string) error {
+		entries, err := c.list(path.Join(dir, rel))
+		if err != nil {
+			return err
+		}
+		for _, e := range entries {
+			p := path.Join(rel, e.name)
+			switch {
+			case strings.HasPrefix(e.name, "."):
+			case e.dir:
+				if err := walk(p); err != nil {
+					return err
+				}
+			case isBook(e.name):
+				books[p] = e.size
+			}
+		}
+		return nil
+	}
+	if e, err := c.stat(dir); isNotFound(err) {
+		return books, nil
+	} else if err != nil {
+		return nil, err
+	} else if !e.dir {
+		return nil, fmt.Errorf("%s is a file", dir)
+	}
+	return books, walk("")
+}
+
+// planSync compares the books under local with those under remote. Books
+// whose size differs are updated; with checksum, so are those whose CRC
+// does, at the cost of the device reading each one through.
+func planSync(c *client, local, remote string, checksum, remove bool) (syncPlan, error) {
+	var plan syncPlan
+	have, skipped, err := localBooks(local)
+	if err != nil {
+		return plan, err
+	}
+	plan.skipped = skipped
+	onDevice, err := c.remoteBooks(remote)
+	if err != nil {
+		return plan, err
+	}
+	for rel, size := range have {
+		devSize, ok := onDevice[rel]
+		switch {
+		case !ok:
+			plan.add = append(plan.add, rel)
+		case devSize != size:
+			plan.update = append(plan.update, rel)
+		case checksum:
+			same, err := sameCRC(c, filepath.Join(local, filepath.FromSlash(rel)), path.Join(remote, rel))
+			if err != nil {
+				return plan, err
+			}
+			if same {
+				plan.same++
+			} else {
+				plan.update = append(plan.update, rel)
+			}
+		default:
+			plan.same++
+		}
+	}
+	if remove {
+		for rel := range onDevice {
+			if _, ok := have[rel]; !ok {
+				plan.remove = append(plan.remove, rel)
+			}
+		}
+	}
+	sort.Strings(plan.add)
+	sort.Strings(plan.update)
+	sort.Strings(plan.remove)
+	return plan, nil
+}
+
+func sameCRC(c *client, local, remote string) (bool, error) {
+	f, err := os.Open(local)
+	if err != nil {
+		return false, err
+	}
+	defer f.Close()
+	crc := crc32.NewIEEE()
+	if _, err := io.Copy(crc, f); err != nil {
+		return false, err
+	}
+	_, sum, err := c.checksum(remote)
+	return sum == crc.Sum32(), err
+}
+
+// applySync carries out a plan. Each upload replaces its book only once
+// complete, so an interrupted sync leaves every book whole, and running it
+// again picks up where it stopped.
+func applySync(c *client, plan syncPlan, local, remote string, out io.Writer) error {
+	made := map[string]bool{}
+	send := func(mark, rel string) error {
+		dst := path.Join(remote, rel)
+		if dir := path.Dir(dst); !made[dir] {
+			if err := c.ensureDir(dir); err != nil {
+				return err
+			}
+			made[dir] = true
+		}
+		data, err := os.ReadFile(filepath.Join(local, filepath.FromSlash(rel)))
+		if err != nil {
+			return err
+		}
+		if err := c.upload(dst, data, printProgress("Writing", rel)); err != nil {
+			fmt.Fprintf(os.Stderr, "\n")
+			return fmt.Errorf("%s: %w", rel, err)
+		}
+		// Blank the progress line before listing the book.
+		fmt.Fprintf(os.Stderr, "\r%*s\r", len(rel)+16, "")
+		fmt.Fprintf(out, "%s %s\n", mark, rel)
+		return nil
+	}
+	for _, rel := range plan.add {
+		if err := send("+", rel); err != nil {
+			return err
+		}
+	}
+	for _, rel := range plan.update {
+		if err := send("~", rel); err != nil {
+			return err
+		}
+	}
+	for _, rel := range plan.remove {
+		if _, err := c.call("rm", path.Join(remote, rel)); err != nil && !isNotFound(err) {
+			return fmt.Errorf("%s: %w", rel, err)
+		}
+		fmt.Fprintf(out, "- %s\n", rel)
+	}
+	return nil
+}
+
+// printPlan lists what a dry run would do.
+func printPlan(w io.Writer, plan syncPlan) {
+	for _, rel := range plan.add {
+		fmt.Fprintf(w, "+ %s\n", rel)
+	}
+	for _, rel := range plan.update {
+		fmt.Fprintf(w, "~ %s\n", rel)
+	}
+	for _, rel := range plan.remove {
+		fmt.Fprintf(w, "- %s\n", rel)
+	}
+}
+
+// runSync implements "sync".
+func runSync(args []string) int {
+	fs := flag.NewFlagSet("sync", flag.ContinueOnError)
+	conn := addConnFlags(fs)
+	remote := fs.String("remote", "/books", "folder on the device to sync with")
+	remove := fs.Bool("delete", false, "delete books from the device that aren't in the local folder")
+	checksum := fs.Bool("checksum", false, "compare books of the same size by CRC too (slower: the device reads each one)")
+	dryRun := fs.Bool("n", false, "only show what would change")
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: %s sync [flags] <local dir>\n\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "Copies the books in a local folder, and its subfolders, to the device over\n")
+		fmt.Fprintf(os.Stderr, "the USB cable: new ones are added (+) and changed ones replaced (~). Only\n")
+		fmt.Fprintf(os.Stderr, "files the reader opens are synced; others are listed and skipped. Running it\n")
+		fmt.Fprintf(os.Stderr, "again only sends what is still missing.\n\n")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return 2
+	}
+	local := fs.Arg(0)
+	if st, err := os.Stat(local); err != nil || !st.IsDir() {
+		fmt.Fprintf(os.Stderr, "%s is not a folder\n", local)
+		return 1
+	}
+
+	c, port, err := conn.dial()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		return 1
+	}
+	defer port.Close()
+	root := remotePath(*remote)
+	plan, err := planSync(c, local, root, *checksum, *remove)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		return 1
+	}
+	for _, rel := range plan.skipped {
+		fmt.Fprintf(os.Stderr, "skipping %s: not a format SUMI reads\n", rel)
+	}
+	if *dryRun {
+		printPlan(os.Stdout, plan)
+	} else if err := applySync(c, plan, local, root, os.Stdout); err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		return 1
+	}
+	summary := "%d added, %d updated, %d deleted, %d up to date\n"
+	if *dryRun {
+		summary = "would have: " + summary
+	}
+	fmt.Fprintf(os.Stderr, summary, len(plan.add), len(plan.update), len(plan.remove), plan.same)
+	return 0
+}
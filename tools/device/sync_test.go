@@ -0,0 +1,105 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func writeBooks(t *testing.T, dir string, files map[string][]byte) {
+	t.Helper()
+	for name, data := range files {
+		p := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(p), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(p, data, 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestSync(t *testing.T) {
+	d := newFakeDevice()
+	c := d.start(t)
+	dir := t.TempDir()
+	writeBooks(t, dir, map[string][]byte{
+		"dune.epub":            testData(700),
+		"sci fi/hyperion.epub": testData(1200),
+		"notes.pdf":            testData(10),
+		".hidden/x.epub":       testData(10),
+	})
+	d.dirs["/books"] = true
+	d.files["/books/old.txt"] = testData(5)
+	d.files["/books/cover.bmp"] = testData(5)
+
+	plan, err := planSync(c, dir, "/books", false, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"dune.epub", "sci fi/hyperion.epub"}; !reflect.DeepEqual(plan.add, want) {
+		t.Errorf("add = %q, want %q", plan.add, want)
+	}
+	if want := []string{"old.txt"}; !reflect.DeepEqual(plan.remove, want) {
+		t.Errorf("remove = %q, want %q", plan.remove, want)
+	}
+	if want := []string{"notes.pdf"}; !reflect.DeepEqual(plan.skipped, want) {
+		t.Errorf("skipped = %q, want %q", plan.skipped, want)
+	}
+	var out bytes.Buffer
+	if err := applySync(c, plan, dir, "/books", &out); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(d.files["/books/sci fi/hyperion.epub"], testData(1200)) || d.files["/books/old.txt"] != nil {
+		t.Errorf("device files after sync: %d", len(d.files))
+	}
+	if d.files["/books/cover.bmp"] == nil {
+		t.Errorf("sync deleted a file that isn't a book")
+	}
+
+	// A second run has nothing to do.
+	plan, err = planSync(c, dir, "/books", false, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(plan.add)+len(plan.update)+len(plan.remove) != 0 || plan.same != 2 {
+		t.Errorf("second plan = %+v", plan)
+	}
+}
+
+func TestSync_Checksum(t *testing.T) {
+	d := newFakeDevice()
+	c := d.start(t)
+	dir := t.TempDir()
+	writeBooks(t, dir, map[string][]byte{"dune.epub": testData(100)})
+	d.dirs["/books"] = true
+	d.files["/books/dune.epub"] = make([]byte, 100)
+
+	plan, err := planSync(c, dir, "/books", false, false)
+	if err != nil || plan.same != 1 {
+		t.Fatalf("by size: %+v, %v", plan, err)
+	}
+	plan, err = planSync(c, dir, "/books", true, false)
+	if err != nil || !reflect.DeepEqual(plan.update, []string{"dune.epub"}) {
+		t.Fatalf("by CRC: %+v, %v", plan, err)
+	}
+}
+
+func TestSync_NoRemoteFolder(t *testing.T) {
+	d := newFakeDevice()
+	c := d.start(t)
+	dir := t.TempDir()
+	writeBooks(t, dir, map[string][]byte{"a.txt": testData(10)})
+	plan, err := planSync(c, dir, "/books", false, true)
+	if err != nil || len(plan.add) != 1 {
+		t.Fatalf("plan = %+v, %v", plan, err)
+	}
+	if err := applySync(c, plan, dir, "/books", &bytes.Buffer{}); err != nil {
+		t.Fatal(err)
+	}
+	if !d.dirs["/books"] || d.files["/books/a.txt"] == nil {
+		t.Errorf("books folder not created")
+	}
+}
@@ -0,0 +1,238 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/md5"
+	"encoding/hex"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+)
+
+// backupChunk is how much flash a backup reads between writes to its file.
+const backupChunk = 0x10000
+
+// gzipMagic starts a gzip stream, which is how restore tells a compressed
+// backup from a raw one.
+var gzipMagic = []byte{0x1F, 0x8B}
+
+// backupFlash reads the first size bytes of flash into w as it goes, then
+// checks them against the flash's own MD5, since a dropped byte in thousands
+// of reads would otherwise only show when the backup is restored.
+func backupFlash(l *loader, w io.Writer, size uint32, progress func(done, total int)) error {
+	h := md5.New()
+	for off := uint32(0); off < size; off += backupChunk {
+		data, err := l.readFlash(off, min(backupChunk, size-off), func(done, _ int) {
+			if progress != nil {
+				progress(int(off)+done, int(size))
+			}
+		})
+		if err != nil {
+			return err
+		}
+		h.Write(data)
+		if _, err := w.Write(data); err != nil {
+			return err
+		}
+	}
+	want, err := l.flashMD5(0, size)
+	if err != nil {
+		return err
+	}
+	if got := hex.EncodeToString(h.Sum(nil)); got != want {
+		return fmt.Errorf("backup MD5 %s does not match the flash's %s", got, want)
+	}
+	return nil
+}
+
+// loadBackup reads a backup file, decompressing it if it is gzipped.
+func loadBackup(path string) ([]byte, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	if bytes.HasPrefix(data, gzipMagic) {
+		zr, err := gzip.NewReader(bytes.NewReader(data))
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", path, err)
+		}
+		if data, err = io.ReadAll(zr); err != nil {
+			return nil, fmt.Errorf("%s: %v", path, err)
+		}
+	}
+	if len(data) == 0 {
+		return nil, fmt.Errorf("%s is empty", path)
+	}
+	return data, nil
+}
+
+// progressLine returns a progress callback that shows the percentage done and
+// an estimate of the time left, for reads and writes that take minutes.
+func progressLine(verb string) func(done, total int) {
+	start := time.Now()
+	return func(done, total int) {
+		left := ""
+		if elapsed := time.Since(start); done > 0 && elapsed > time.Second {
+			rem := time.Duration(float64(elapsed) * float64(total-done) / float64(done))
+			left = fmt.Sprintf(", %s left", rem.Round(time.Second))
+		}
+		fmt.Fprintf(os.Stderr, "\r%s flash... %3d%%%s   ", verb, done*100/total, left)
+	}
+}
+
+// runBackup implements "sumi-flash backup": it saves the board's whole flash
+// to a file.
+func runBackup(args []string) int {
+	fs := flag.NewFlagSet("backup", flag.ContinueOnError)
+	portFlag := fs.String("port", "", "serial port (auto-detected when a single ESP32 board is plugged in)")
+	baudFlag := fs.Int("baud", 115200, "baud rate to reach the bootloader at")
+	flashBaudFlag := fs.Int("flash-baud", 921600, "baud rate to switch to for reading (0 stays at -baud)")
+	flashSizeFlag := fs.String("flash-size", "16MB", "size of the board's flash chip")
+	resetFlag := fs.Bool("reset", true, "reset the board into its firmware when done")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: %s backup [flags] <backup.bin[.gz]>\n\n", os.Args[0])
+		fmt.Fprintf(fs.Output(), "Saves the whole flash, gzip-compressed as it is read when the file name\n")
+		fmt.Fprintf(fs.Output(), "ends in .gz. The bootloader reads slowly; 16MB takes several minutes.\n\n")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return 2
+	}
+	flashSize, err := parseFlashSize(*flashSizeFlag)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "-flash-size: %v\n", err)
+		return 2
+	}
+	path := fs.Arg(0)
+	if err := backup(*portFlag, *baudFlag, *flashBaudFlag, flashSize, path, *resetFlag); err != nil {
+		fmt.Fprintf(os.Stderr, "\nBackup failed: %v\n", err)
+		return 1
+	}
+	return 0
+}
+
+// backup saves the flash of the board on the named port to path. A failed
+// backup leaves no file behind, so it cannot be mistaken for a good one.
+func backup(name string, baud, flashBaud int, flashSize uint32, path string, reset bool) (err error) {
+	if name == "" {
+		if name, err = detectPort(); err != nil {
+			return fmt.Errorf("auto-detect failed: %v", err)
+		}
+		fmt.Fprintf(os.Stderr, "Auto-detected port: %s\n", name)
+	}
+	s, err := connect(name, baud, flashBaud, flashSize)
+	if err != nil {
+		return err
+	}
+	defer s.port.Close()
+
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			f.Close()
+			os.Remove(path)
+		}
+	}()
+	var w io.Writer = f
+	var zw *gzip.Writer
+	if strings.HasSuffix(path, ".gz") {
+		zw = gzip.NewWriter(f)
+		w = zw
+	}
+	start := time.Now()
+	if err := backupFlash(s.loader, w, flashSize, progressLine("Reading")); err != nil {
+		return err
+	}
+	if zw != nil {
+		if err := zw.Close(); err != nil {
+			return err
+		}
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	fmt.Fprintf(os.Stderr, "\rSaved %s of flash to %s in %s; hash verified.\n", humanSize(flashSize), path, time.Since(start).Round(time.Second))
+	s.finish(reset)
+	return nil
+}
+
+// runRestore implements "sumi-flash restore": it writes a backup back over
+// the whole flash.
+func runRestore(args []string) int {
+	fs := flag.NewFlagSet("restore", flag.ContinueOnError)
+	portFlag := fs.String("port", "", "serial port (auto-detected when a single ESP32 board is plugged in)")
+	baudFlag := fs.Int("baud", 115200, "baud rate to reach the bootloader at")
+	flashBaudFlag := fs.Int("flash-baud", 921600, "baud rate to switch to for writing (0 stays at -baud)")
+	flashSizeFlag := fs.String("flash-size", "16MB", "size of the board's flash chip")
+	resetFlag := fs.Bool("reset", true, "reset the board into the restored firmware when done")
+	forceFlag := fs.Bool("force", false, "restore a file that does not start with a bootloader and partition table")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: %s restore [flags] <backup.bin[.gz]>\n\n", os.Args[0])
+		fmt.Fprintf(fs.Output(), "Writes a backup made by %s backup at 0 and verifies it.\n\n", os.Args[0])
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return 2
+	}
+	flashSize, err := parseFlashSize(*flashSizeFlag)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "-flash-size: %v\n", err)
+		return 2
+	}
+	data, err := loadBackup(fs.Arg(0))
+	if err == nil {
+		err = checkBackup(data, flashSize, *forceFlag)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		return 1
+	}
+	if uint32(len(data)) < flashSize {
+		fmt.Fprintf(os.Stderr, "Warning: the backup covers %s of the %s flash; the rest is left as it is.\n",
+			humanSize(uint32(len(data))), *flashSizeFlag)
+	}
+
+	name := *portFlag
+	if name == "" {
+		if name, err = detectPort(); err != nil {
+			fmt.Fprintf(os.Stderr, "Auto-detect failed: %v\n", err)
+			return 1
+		}
+		fmt.Fprintf(os.Stderr, "Auto-detected port: %s\n", name)
+	}
+	images := []flashImage{{path: fs.Arg(0), offset: 0, data: data}}
+	if err := flash(name, *baudFlag, *flashBaudFlag, flashSize, images, true, *resetFlag); err != nil {
+		fmt.Fprintf(os.Stderr, "\nRestore failed: %v\n", err)
+		return 1
+	}
+	return 0
+}
+
+// checkBackup refuses data that cannot be a backup of this board's flash.
+func checkBackup(data []byte, flashSize uint32, force bool) error {
+	switch {
+	case uint64(len(data)) > uint64(flashSize):
+		return fmt.Errorf("the backup is %d bytes, larger than the %s flash", len(data), humanSize(flashSize))
+	case len(data)%int(dataAlign) != 0:
+		return fmt.Errorf("the backup is %d bytes, not a whole number of 0x%x-byte sectors", len(data), dataAlign)
+	case !force && !isFlashDump(data):
+		return errors.New("the backup does not start with a bootloader and partition table; give -force to write it anyway")
+	}
+	return nil
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestBackupFlash(t *testing.T) {
+	rom := newFakeROM()
+	img := testImage()
+	copy(rom.flash[0x1F000:], img) // across a chunk boundary
+	const size = 3 * backupChunk
+	var out bytes.Buffer
+	var last int
+	if err := backupFlash(newLoader(rom), &out, size, func(done, total int) { last = done }); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(out.Bytes(), rom.flash[:size]) || last != size {
+		t.Errorf("backup of %d bytes (progress %d) does not match the flash", out.Len(), last)
+	}
+}
+
+func TestLoadBackup(t *testing.T) {
+	dir := t.TempDir()
+	data := testImage()
+	raw := filepath.Join(dir, "all.bin")
+	os.WriteFile(raw, data, 0644)
+	var comp bytes.Buffer
+	zw := gzip.NewWriter(&comp)
+	zw.Write(data)
+	zw.Close()
+	gz := filepath.Join(dir, "all.bin.gz")
+	os.WriteFile(gz, comp.Bytes(), 0644)
+	for _, path := range []string{raw, gz} {
+		got, err := loadBackup(path)
+		if err != nil || !bytes.Equal(got, data) {
+			t.Errorf("loadBackup(%s) = %d bytes, %v", filepath.Base(path), len(got), err)
+		}
+	}
+	truncated := filepath.Join(dir, "short.bin.gz")
+	os.WriteFile(truncated, comp.Bytes()[:comp.Len()/2], 0644)
+	if _, err := loadBackup(truncated); err == nil {
+		t.Error("truncated gzip: expected error")
+	}
+}
+
+func TestCheckBackup(t *testing.T) {
+	dump := bytes.Repeat([]byte{0xFF}, 0x10000)
+	dump[0] = imageMagic
+	copy(dump[partitionTableOffset:], partitionMagic)
+	if err := checkBackup(dump, 4<<20, false); err != nil {
+		t.Errorf("dump: %v", err)
+	}
+	if err := checkBackup(dump, 0x8000, false); err == nil {
+		t.Error("larger than the flash: expected error")
+	}
+	if err := checkBackup(dump[:0x9001], 4<<20, false); err == nil {
+		t.Error("partial sector: expected error")
+	}
+	blank := bytes.Repeat([]byte{0xFF}, 0x10000)
+	if err := checkBackup(blank, 4<<20, false); err == nil {
+		t.Error("no bootloader: expected error")
+	}
+	if err := checkBackup(blank, 4<<20, true); err != nil {
+		t.Errorf("no bootloader with -force: %v", err)
+	}
+}
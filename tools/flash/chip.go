@@ -0,0 +1,218 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"time"
+)
+
+// efuseBlock1 is the first word of the ESP32-C3's eFuse block 1, as esptool
+// reads it: the base MAC address is in words 0 and 1, and the package,
+// revision and embedded flash fields in words 3 to 5.
+const efuseBlock1 = 0x60008844
+
+// SPI1 registers the loader drives to send the flash chip a command, here
+// RDID to read its JEDEC ID.
+const (
+	spiBase      = 0x60002000
+	spiCmdReg    = spiBase + 0x00
+	spiUsrReg    = spiBase + 0x18
+	spiUsr2Reg   = spiBase + 0x20
+	spiMISOLen   = spiBase + 0x28
+	spiW0Reg     = spiBase + 0x58
+	spiCmdUsr    = 1 << 18
+	spiUsrCmd    = 1 << 31
+	spiUsrMISO   = 1 << 28
+	spiFlashRDID = 0x9F
+)
+
+var (
+	chipPackages  = map[uint32]string{0: "ESP32-C3 (QFN32)", 1: "ESP8685 (QFN28)", 2: "ESP32-C3 AZ (QFN32)", 3: "ESP8686 (QFN24)"}
+	embeddedFlash = map[uint32]uint32{1: 4 << 20, 2: 2 << 20, 3: 1 << 20, 4: 8 << 20}
+	flashVendors  = map[byte]string{0x20: "XMC", 0x68: "Boya", 0x85: "Puya", 0xA1: "Fudan", 0xC8: "GigaDevice", 0xEF: "Winbond", 0x5E: "Zbit"}
+)
+
+// chipInfo is what a board's eFuses and flash chip say about its hardware.
+type chipInfo struct {
+	Model         string `json:"model"`
+	Revision      string `json:"revision"`
+	MAC           string `json:"mac"`
+	FlashID       string `json:"flash_id,omitempty"`
+	FlashVendor   string `json:"flash_vendor,omitempty"`
+	FlashSize     string `json:"flash_size,omitempty"`
+	EmbeddedFlash bool   `json:"embedded_flash"`
+	// PSRAM is always "none": the ESP32-C3 has no PSRAM interface.
+	PSRAM string `json:"psram"`
+}
+
+// regReader reads a chip register.
+type regReader func(addr uint32) (uint32, error)
+
+// readChipInfo decodes the eFuse fields esptool's chip_id reports.
+func readChipInfo(read regReader) (chipInfo, error) {
+	var w [6]uint32
+	for i := range w {
+		if i == 2 {
+			continue
+		}
+		v, err := read(efuseBlock1 + uint32(i)*4)
+		if err != nil {
+			return chipInfo{}, err
+		}
+		w[i] = v
+	}
+	info := chipInfo{PSRAM: "none"}
+	pkg := w[3] >> 21 & 7
+	if info.Model = chipPackages[pkg]; info.Model == "" {
+		info.Model = fmt.Sprintf("unknown ESP32-C3 package %d", pkg)
+	}
+	major, minor := w[5]>>24&3, (w[5]>>23&1)<<3|w[3]>>18&7
+	info.Revision = fmt.Sprintf("v%d.%d", major, minor)
+	mac := net.HardwareAddr{byte(w[1] >> 8), byte(w[1]), byte(w[0] >> 24), byte(w[0] >> 16), byte(w[0] >> 8), byte(w[0])}
+	info.MAC = mac.String()
+	if size, ok := embeddedFlash[w[3]>>27&7]; ok {
+		info.EmbeddedFlash, info.FlashSize = true, humanSize(size)+"B"
+	}
+	return info, nil
+}
+
+// flashID sends the flash chip RDID through the SPI1 user-command registers
+// and returns its JEDEC ID: manufacturer, memory type and capacity bytes.
+func (l *loader) flashID() (uint32, error) {
+	oldUsr, err := l.readReg(spiUsrReg)
+	if err != nil {
+		return 0, err
+	}
+	oldUsr2, err := l.readReg(spiUsr2Reg)
+	if err != nil {
+		return 0, err
+	}
+	steps := [][2]uint32{
+		{spiMISOLen, 24 - 1},
+		{spiUsrReg, spiUsrCmd | spiUsrMISO},
+		{spiUsr2Reg, 7<<28 | spiFlashRDID},
+		{spiW0Reg, 0},
+		{spiCmdReg, spiCmdUsr},
+	}
+	for _, s := range steps {
+		if err := l.writeReg(s[0], s[1]); err != nil {
+			return 0, err
+		}
+	}
+	deadline := time.Now().Add(defaultTimeout)
+	for {
+		v, err := l.readReg(spiCmdReg)
+		if err != nil {
+			return 0, err
+		}
+		if v&spiCmdUsr == 0 {
+			break
+		}
+		if time.Now().After(deadline) {
+			return 0, errors.New("flash chip did not answer RDID")
+		}
+	}
+	id, err := l.readReg(spiW0Reg)
+	if err != nil {
+		return 0, err
+	}
+	if err := l.writeReg(spiUsrReg, oldUsr); err != nil {
+		return 0, err
+	}
+	return id & 0xFFFFFF, l.writeReg(spiUsr2Reg, oldUsr2)
+}
+
+// addFlashID fills in the flash chip from its JEDEC ID, whose third byte is
+// log2 of its size.
+func (c *chipInfo) addFlashID(id uint32) {
+	if id == 0 || id == 0xFFFFFF {
+		return
+	}
+	maker, capacity := byte(id), id>>16&0xFF
+	c.FlashID = fmt.Sprintf("%02x%02x%02x", maker, id>>8&0xFF, capacity)
+	c.FlashVendor = flashVendors[maker]
+	if c.FlashVendor == "" {
+		c.FlashVendor = fmt.Sprintf("0x%02x", maker)
+	}
+	if capacity >= 16 && capacity <= 31 {
+		c.FlashSize = humanSize(1<<capacity) + "B"
+	}
+}
+
+// printChip writes c as text.
+func printChip(w io.Writer, c chipInfo) {
+	fmt.Fprintf(w, "Chip:      %s, revision %s\n", c.Model, c.Revision)
+	fmt.Fprintf(w, "MAC:       %s\n", c.MAC)
+	flash := c.FlashSize
+	switch {
+	case flash == "":
+		flash = "unknown"
+	case c.EmbeddedFlash:
+		flash += " embedded"
+	}
+	if c.FlashVendor != "" {
+		flash += fmt.Sprintf(" (%s, JEDEC ID %s)", c.FlashVendor, c.FlashID)
+	}
+	fmt.Fprintf(w, "Flash:     %s\n", flash)
+	fmt.Fprintf(w, "PSRAM:     %s\n", c.PSRAM)
+}
+
+// runChip implements "chip [-json]".
+func runChip(args []string) int {
+	fs := flag.NewFlagSet("chip", flag.ContinueOnError)
+	portFlag := fs.String("port", "", "serial port (auto-detected when a single ESP32 board is plugged in)")
+	baudFlag := fs.Int("baud", 115200, "baud rate to reach the bootloader at")
+	jsonFlag := fs.Bool("json", false, "print JSON instead of text")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: %s chip [-json] [flags]\n\n", os.Args[0])
+		fmt.Fprintf(fs.Output(), "Reads the chip model, revision and MAC address from eFuses, and the\n")
+		fmt.Fprintf(fs.Output(), "flash chip's ID and size.\n\n")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if fs.NArg() != 0 {
+		fs.Usage()
+		return 2
+	}
+	name := *portFlag
+	if name == "" {
+		var err error
+		if name, err = detectPort(); err != nil {
+			fmt.Fprintf(os.Stderr, "Auto-detect failed: %v\n", err)
+			return 1
+		}
+		fmt.Fprintf(os.Stderr, "Auto-detected port: %s\n", name)
+	}
+	// The flash size is only used to bound writes, so any size will do.
+	s, err := connect(name, *baudFlag, 0, 16<<20)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		return 1
+	}
+	defer s.port.Close()
+	c, err := readChipInfo(s.loader.readReg)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		return 1
+	}
+	if id, err := s.loader.flashID(); err == nil {
+		c.addFlashID(id)
+	} else {
+		fmt.Fprintf(os.Stderr, "Warning: could not read the flash ID: %v\n", err)
+	}
+	s.finish(true)
+	if *jsonFlag {
+		b, _ := json.MarshalIndent(c, "", "  ")
+		fmt.Printf("%s\n", b)
+		return 0
+	}
+	printChip(os.Stdout, c)
+	return 0
+}
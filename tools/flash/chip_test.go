@@ -0,0 +1,54 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestReadChipInfo(t *testing.T) {
+	rom := newFakeROM()
+	// An ESP32-C3 revision v0.4 with MAC 34:85:18:01:02:03, as esptool
+	// would read it.
+	rom.regs[efuseBlock1] = 0x18010203
+	rom.regs[efuseBlock1+4] = 0x3485
+	rom.regs[efuseBlock1+12] = 4 << 18
+	rom.jedec = 0x184020 // XMC, 16MB
+	l := newLoader(rom)
+	c, err := readChipInfo(l.readReg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	id, err := l.flashID()
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.addFlashID(id)
+	want := chipInfo{Model: "ESP32-C3 (QFN32)", Revision: "v0.4", MAC: "34:85:18:01:02:03",
+		FlashID: "204018", FlashVendor: "XMC", FlashSize: "16MB", PSRAM: "none"}
+	if c != want {
+		t.Errorf("got  %+v\nwant %+v", c, want)
+	}
+	if rom.regs[spiUsrReg] != 0 || rom.regs[spiUsr2Reg] != 0 {
+		t.Error("flashID did not restore the SPI user registers")
+	}
+
+	var out strings.Builder
+	printChip(&out, c)
+	if !strings.Contains(out.String(), "Flash:     16MB (XMC, JEDEC ID 204018)\n") {
+		t.Errorf("output:\n%s", out.String())
+	}
+}
+
+func TestReadChipInfo_EmbeddedFlash(t *testing.T) {
+	regs := map[uint32]uint32{
+		efuseBlock1 + 12: 1<<21 | 1<<27, // ESP8685 with 4MB in the package
+		efuseBlock1 + 20: 1<<24 | 1<<23,
+	}
+	c, err := readChipInfo(func(addr uint32) (uint32, error) { return regs[addr], nil })
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.Model != "ESP8685 (QFN28)" || c.Revision != "v1.8" || !c.EmbeddedFlash || c.FlashSize != "4MB" {
+		t.Errorf("got %+v", c)
+	}
+}
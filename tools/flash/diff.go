@@ -0,0 +1,287 @@
+package main
+
+import (
+	"bytes"
+	"debug/elf"
+	"encoding/binary"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+)
+
+// appHeaderSize is the image header plus its extended header; segment
+// headers follow.
+const appHeaderSize = 24
+
+// memoryRegions name where an ESP32-C3 app segment loads, by address.
+var memoryRegions = []struct {
+	name       string
+	start, end uint32
+}{
+	{"flash code", 0x42000000, 0x42800000},
+	{"flash data", 0x3C000000, 0x3C800000},
+	{"IRAM", 0x4037C000, 0x403E0000},
+	{"DRAM", 0x3FC80000, 0x3FCE0000},
+	{"RTC", 0x50000000, 0x50002000},
+}
+
+// appBuild is what diff compares about one build.
+type appBuild struct {
+	path string
+	desc *appDesc
+	// size is the app image's length, limit the app partition it goes in,
+	// when known.
+	size, limit uint32
+	limitLabel  string
+	// regions are segment sizes by memory region for an image, or section
+	// sizes for an ELF file.
+	regions map[string]uint32
+	// objects are the sizes of the data objects in flash (the built-in fonts
+	// and images among them), from an ELF file's symbols.
+	objects map[string]uint32
+}
+
+// appSegments returns the size of each memory region an app image loads,
+// and the image's length with its checksum and appended hash.
+func appSegments(data []byte) (map[string]uint32, uint32, error) {
+	if len(data) < appHeaderSize || data[0] != imageMagic {
+		return nil, 0, errors.New("not an ESP32 app image")
+	}
+	regions := map[string]uint32{}
+	off := uint32(appHeaderSize)
+	for i := 0; i < int(data[1]); i++ {
+		if uint64(off)+8 > uint64(len(data)) {
+			return nil, 0, fmt.Errorf("segment %d header is past the end of the image", i)
+		}
+		addr, size := binary.LittleEndian.Uint32(data[off:]), binary.LittleEndian.Uint32(data[off+4:])
+		off += 8
+		if uint64(off)+uint64(size) > uint64(len(data)) {
+			return nil, 0, fmt.Errorf("segment %d at 0x%08x runs past the end of the image", i, addr)
+		}
+		off += size
+		name := fmt.Sprintf("0x%08x", addr)
+		for _, r := range memoryRegions {
+			if addr >= r.start && addr < r.end {
+				name = r.name
+			}
+		}
+		regions[name] += size
+	}
+	// A checksum byte, padding to 16 bytes, then a SHA-256 if the header
+	// says one is appended.
+	end := (off + 16) &^ 15
+	if data[23] == 1 {
+		end += 32
+	}
+	return regions, end, nil
+}
+
+// loadBuild reads an app image, a merged image, whose first OTA slot is
+// used, or an ELF file.
+func loadBuild(path string) (appBuild, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return appBuild{}, err
+	}
+	b := appBuild{path: path}
+	if bytes.HasPrefix(data, []byte(elf.ELFMAG)) {
+		err = b.loadELF(data)
+		return b, err
+	}
+	if isFlashDump(data) {
+		parts, err := parsePartitionTable(data[partitionTableOffset:])
+		if err != nil {
+			return appBuild{}, fmt.Errorf("%s: %v", path, err)
+		}
+		slots := otaSlots(parts)
+		if len(slots) == 0 || slots[0].offset >= uint32(len(data)) {
+			return appBuild{}, fmt.Errorf("%s has no app in its first OTA slot", path)
+		}
+		b.limit, b.limitLabel = slots[0].size, slots[0].label
+		data = data[slots[0].offset:]
+	}
+	if b.regions, b.size, err = appSegments(data); err != nil {
+		return appBuild{}, fmt.Errorf("%s: %v", path, err)
+	}
+	if d, err := parseAppDesc(data); err == nil {
+		b.desc = &d
+	}
+	return b, nil
+}
+
+// loadELF fills b from the firmware.elf a build leaves next to its
+// firmware.bin, which has section and symbol sizes the image does not.
+func (b *appBuild) loadELF(data []byte) error {
+	f, err := elf.NewFile(bytes.NewReader(data))
+	if err != nil {
+		return fmt.Errorf("%s: %v", b.path, err)
+	}
+	b.regions, b.objects = map[string]uint32{}, map[string]uint32{}
+	flashData := map[elf.SectionIndex]bool{}
+	for i, s := range f.Sections {
+		if s.Flags&elf.SHF_ALLOC == 0 || s.Size == 0 {
+			continue
+		}
+		b.regions[s.Name] += uint32(s.Size)
+		if s.Type != elf.SHT_NOBITS {
+			b.size += uint32(s.Size)
+		}
+		if s.Addr >= 0x3C000000 && s.Addr < 0x3C800000 {
+			flashData[elf.SectionIndex(i)] = true
+		}
+		if s.Name == ".flash.appdesc" {
+			if raw, err := s.Data(); err == nil {
+				if d, err := decodeAppDesc(raw); err == nil {
+					b.desc = &d
+				}
+			}
+		}
+	}
+	syms, err := f.Symbols()
+	if err != nil && !errors.Is(err, elf.ErrNoSymbols) {
+		return fmt.Errorf("%s: %v", b.path, err)
+	}
+	for _, s := range syms {
+		if elf.ST_TYPE(s.Info) == elf.STT_OBJECT && s.Size > 0 && flashData[s.Section] {
+			b.objects[s.Name] += uint32(s.Size)
+		}
+	}
+	return nil
+}
+
+// sizeChange is how one named size differs between two builds.
+type sizeChange struct {
+	name     string
+	old, new uint32
+}
+
+func (c sizeChange) delta() int64 { return int64(c.new) - int64(c.old) }
+
+// sizeChanges lists every name in old or new, in order.
+func sizeChanges(old, new map[string]uint32) []sizeChange {
+	seen := map[string]bool{}
+	var changes []sizeChange
+	for _, m := range []map[string]uint32{old, new} {
+		for name := range m {
+			if !seen[name] {
+				seen[name] = true
+				changes = append(changes, sizeChange{name, old[name], new[name]})
+			}
+		}
+	}
+	sort.Slice(changes, func(i, j int) bool { return changes[i].name < changes[j].name })
+	return changes
+}
+
+// largestChanges returns the top changes by how much they grew or shrank.
+func largestChanges(old, new map[string]uint32, top int) []sizeChange {
+	var changes []sizeChange
+	for _, c := range sizeChanges(old, new) {
+		if c.delta() != 0 {
+			changes = append(changes, c)
+		}
+	}
+	abs := func(n int64) int64 {
+		if n < 0 {
+			return -n
+		}
+		return n
+	}
+	sort.SliceStable(changes, func(i, j int) bool { return abs(changes[i].delta()) > abs(changes[j].delta()) })
+	if len(changes) > top {
+		changes = changes[:top]
+	}
+	return changes
+}
+
+// printDiff writes how new differs from old.
+func printDiff(w io.Writer, old, new appBuild, top int) {
+	describe := func(d *appDesc) [3]string {
+		if d == nil {
+			return [3]string{"no app description", "", ""}
+		}
+		return [3]string{d.Project + " " + d.Version, d.BuildDate, d.IDF}
+	}
+	od, nd := describe(old.desc), describe(new.desc)
+	for i, label := range []string{"Firmware:", "Built:", "ESP-IDF:"} {
+		if od[i] == nd[i] {
+			fmt.Fprintf(w, "%-10s %s\n", label, nd[i])
+		} else {
+			fmt.Fprintf(w, "%-10s %s -> %s\n", label, od[i], nd[i])
+		}
+	}
+
+	fmt.Fprintf(w, "\n%-22s %10s %10s %10s\n", "", "old", "new", "change")
+	for _, c := range sizeChanges(old.regions, new.regions) {
+		fmt.Fprintf(w, "%-22s %10d %10d %+10d\n", c.name, c.old, c.new, c.delta())
+	}
+	total := sizeChange{"image", old.size, new.size}
+	fmt.Fprintf(w, "%-22s %10d %10d %+10d\n", total.name, total.old, total.new, total.delta())
+	if new.limit > 0 {
+		if new.size > new.limit {
+			fmt.Fprintf(w, "\nThe new app does not fit: it is %d bytes over the %s %s partition.\n", new.size-new.limit, humanSize(new.limit), new.limitLabel)
+		} else {
+			fmt.Fprintf(w, "\nThe new app fills %.1f%% of the %s %s partition (%d bytes free).\n",
+				float64(new.size)*100/float64(new.limit), humanSize(new.limit), new.limitLabel, new.limit-new.size)
+		}
+	}
+
+	if old.objects == nil || new.objects == nil {
+		return
+	}
+	changes := largestChanges(old.objects, new.objects, top)
+	if len(changes) == 0 {
+		fmt.Fprintf(w, "\nNo data object in flash changed size.\n")
+		return
+	}
+	fmt.Fprintf(w, "\nData objects in flash that changed most:\n")
+	for _, c := range changes {
+		note := ""
+		switch {
+		case c.old == 0:
+			note = " (new)"
+		case c.new == 0:
+			note = " (removed)"
+		}
+		fmt.Fprintf(w, "  %+10d  %s%s\n", c.delta(), c.name, note)
+	}
+}
+
+// runDiff implements "diff old new".
+func runDiff(args []string) int {
+	fs := flag.NewFlagSet("diff", flag.ContinueOnError)
+	topFlag := fs.Int("top", 20, "how many data objects to list, for ELF files")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: %s diff [-top n] <old> <new>\n\n", os.Args[0])
+		fmt.Fprintf(fs.Output(), "Compares two builds: their app descriptions and how much each memory\n")
+		fmt.Fprintf(fs.Output(), "region grew. Each may be an app image, a merged image, or the\n")
+		fmt.Fprintf(fs.Output(), "firmware.elf beside a build's firmware.bin; with ELF files, the data\n")
+		fmt.Fprintf(fs.Output(), "objects in flash, such as built-in fonts and images, are compared too.\n\n")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if fs.NArg() != 2 {
+		fs.Usage()
+		return 2
+	}
+	var builds [2]appBuild
+	for i, path := range fs.Args() {
+		b, err := loadBuild(path)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%v\n", err)
+			return 1
+		}
+		builds[i] = b
+	}
+	if (builds[0].objects == nil) != (builds[1].objects == nil) {
+		fmt.Fprintf(os.Stderr, "Compare two images or two ELF files, not one of each.\n")
+		return 2
+	}
+	printDiff(os.Stdout, builds[0], builds[1], *topFlag)
+	return 0
+}
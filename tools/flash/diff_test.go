@@ -0,0 +1,105 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// segmentedApp builds an app image whose first segment, in flash data,
+// holds the app description, followed by segments of the given sizes at the
+// given addresses.
+func segmentedApp(version string, segs ...[2]uint32) []byte {
+	desc := appWithDesc("SUMI", version)[appDescOffset:]
+	data := make([]byte, appHeaderSize)
+	data[0], data[1] = imageMagic, byte(1+len(segs))
+	add := func(addr uint32, body []byte) {
+		data = binary.LittleEndian.AppendUint32(data, addr)
+		data = binary.LittleEndian.AppendUint32(data, uint32(len(body)))
+		data = append(data, body...)
+	}
+	add(0x3C000020, desc)
+	for _, s := range segs {
+		add(s[0], make([]byte, s[1]))
+	}
+	for len(data)%16 != 0 {
+		data = append(data, 0)
+	}
+	return data
+}
+
+func TestAppSegments(t *testing.T) {
+	data := segmentedApp("0.6.4", [2]uint32{0x42000020, 0x1000}, [2]uint32{0x3FC80000, 0x100}, [2]uint32{0x4037C000, 0x200})
+	regions, size, err := appSegments(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]uint32{"flash data": 224, "flash code": 0x1000, "DRAM": 0x100, "IRAM": 0x200}
+	for name, n := range want {
+		if regions[name] != n {
+			t.Errorf("%s = %d, want %d", name, regions[name], n)
+		}
+	}
+	if len(regions) != len(want) {
+		t.Errorf("regions = %v", regions)
+	}
+	if size != uint32(len(data)) {
+		t.Errorf("size = %d, want %d", size, len(data))
+	}
+	if _, _, err := appSegments(data[:100]); err == nil {
+		t.Error("truncated image: expected error")
+	}
+}
+
+func TestLoadBuild_MergedImage(t *testing.T) {
+	app := segmentedApp("0.6.4", [2]uint32{0x42000020, 0x1000})
+	merged := append(mergedImage()[:appOffset], app...)
+	path := filepath.Join(t.TempDir(), "full.bin")
+	if err := os.WriteFile(path, merged, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	b, err := loadBuild(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b.desc == nil || b.desc.Version != "0.6.4" || b.size != uint32(len(app)) || b.limitLabel != "app0" {
+		t.Errorf("got %+v", b)
+	}
+}
+
+func TestPrintDiff(t *testing.T) {
+	old := appBuild{
+		desc:    &appDesc{Project: "SUMI", Version: "0.6.3", IDF: "v4.4.7"},
+		size:    0x1000,
+		limit:   0x1800,
+		regions: map[string]uint32{"flash code": 0x800, "flash data": 0x800},
+		objects: map[string]uint32{"ui_10Bitmaps": 100, "SumiHomeBg": 48000, "oldTable": 10},
+	}
+	new := old
+	new.desc = &appDesc{Project: "SUMI", Version: "0.6.4", IDF: "v4.4.7"}
+	new.size, new.limitLabel = 0x2000, "app0"
+	new.regions = map[string]uint32{"flash code": 0x800, "flash data": 0x1800}
+	new.objects = map[string]uint32{"ui_10Bitmaps": 4196, "SumiHomeBg": 48000, "ui_12Bitmaps": 50}
+
+	var out bytes.Buffer
+	printDiff(&out, old, new, 2)
+	got := out.String()
+	for _, want := range []string{
+		"Firmware:  SUMI 0.6.3 -> SUMI 0.6.4\n",
+		"ESP-IDF:   v4.4.7\n",
+		"flash data                   2048       6144      +4096\n",
+		"does not fit: it is 2048 bytes over the 6K app0 partition",
+		"       +4096  ui_10Bitmaps\n",
+		"         +50  ui_12Bitmaps (new)\n",
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("output lacks %q:\n%s", want, got)
+		}
+	}
+	if strings.Contains(got, "SumiHomeBg") || strings.Contains(got, "oldTable") {
+		t.Errorf("unchanged or past -top objects listed:\n%s", got)
+	}
+}
@@ -0,0 +1,205 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// eraseTargets resolves a -region to the flash it covers: "nvs", "storage"
+// for the LittleFS partition, "all" for the whole chip, or any partition
+// label.
+func eraseTargets(parts []partition, region string, flashSize uint32) ([]flashArea, error) {
+	area := func(p partition) flashArea { return flashArea{p.label, p.offset, p.offset + p.size} }
+	switch region {
+	case "all":
+		return []flashArea{{"all flash", 0, flashSize}}, nil
+	case "nvs":
+		p, err := findNVSPartition(parts)
+		if err != nil {
+			return nil, err
+		}
+		return []flashArea{area(p)}, nil
+	case "storage":
+		p, err := findStoragePartition(parts)
+		if err != nil {
+			return nil, err
+		}
+		return []flashArea{area(p)}, nil
+	}
+	var labels []string
+	for _, p := range parts {
+		if p.label == region {
+			return []flashArea{area(p)}, nil
+		}
+		labels = append(labels, p.label)
+	}
+	return nil, fmt.Errorf("no region %q (want nvs, storage, all or a partition: %s)", region, strings.Join(labels, ", "))
+}
+
+// findStoragePartition returns the LittleFS partition the firmware mounts.
+func findStoragePartition(parts []partition) (partition, error) {
+	for _, p := range parts {
+		if p.ptype == partitionTypeData && (p.sub == partitionSubSPIFFS || p.sub == 0x83) {
+			return p, nil
+		}
+	}
+	return partition{}, errors.New("partition table has no spiffs or littlefs partition")
+}
+
+// factoryResetTargets are what a factory reset erases: saved settings, the
+// OTA selection, so the first slot boots, and storage. The firmware
+// recreates NVS and formats storage on its next boot.
+func factoryResetTargets(parts []partition) ([]flashArea, error) {
+	var areas []flashArea
+	for _, find := range []func([]partition) (partition, error){findNVSPartition, findOTAData, findStoragePartition} {
+		p, err := find(parts)
+		if err != nil {
+			return nil, err
+		}
+		areas = append(areas, flashArea{p.label, p.offset, p.offset + p.size})
+	}
+	return areas, nil
+}
+
+// confirm lists what is about to be erased and asks for "yes" on in.
+func confirm(w io.Writer, in io.Reader, areas []flashArea) bool {
+	fmt.Fprintf(w, "This erases:\n")
+	for _, a := range areas {
+		fmt.Fprintf(w, "  %-15s 0x%08x %7s\n", a.label, a.start, humanSize(a.end-a.start))
+	}
+	fmt.Fprintf(w, "Type yes to continue: ")
+	line, _ := bufio.NewReader(in).ReadString('\n')
+	return strings.TrimSpace(line) == "yes"
+}
+
+// eraseAreas erases each area by writing it blank. The ROM loader has no
+// erase command of its own, but erases whatever a write covers, and blank
+// data compresses to almost nothing.
+func eraseAreas(l *loader, areas []flashArea) error {
+	for _, a := range areas {
+		blank := bytes.Repeat([]byte{0xFF}, int(a.end-a.start))
+		err := l.writeFlash(a.start, blank, func(done, total int) {
+			fmt.Fprintf(os.Stderr, "\rErasing %s at 0x%08x... %3d%%", a.label, a.start, done*100/total)
+		})
+		if err != nil {
+			return fmt.Errorf("erasing %s: %w", a.label, err)
+		}
+		if err := l.verify(a.start, blank); err != nil {
+			return fmt.Errorf("erasing %s: %w", a.label, err)
+		}
+		fmt.Fprintf(os.Stderr, "\rErased %s at 0x%08x (%s)\n", a.label, a.start, humanSize(a.end-a.start))
+	}
+	return nil
+}
+
+// runErase implements "erase -region nvs|storage|all|<label>" and
+// "erase -factory-reset".
+func runErase(args []string) int {
+	fs := flag.NewFlagSet("erase", flag.ContinueOnError)
+	portFlag := fs.String("port", "", "serial port (auto-detected when a single ESP32 board is plugged in)")
+	baudFlag := fs.Int("baud", 115200, "baud rate to reach the bootloader at")
+	flashBaudFlag := fs.Int("flash-baud", 921600, "baud rate to switch to for erasing (0 stays at -baud)")
+	flashSizeFlag := fs.String("flash-size", "16MB", "size of the board's flash chip")
+	regionFlag := fs.String("region", "", "what to erase: nvs (settings), storage (LittleFS), all (everything, firmware included), or a partition label")
+	factoryFlag := fs.Bool("factory-reset", false, "erase settings, the OTA selection and storage, so the board boots like a fresh install")
+	storageFlag := fs.String("storage-image", "", "with -factory-reset, write this LittleFS image (from sumi-fsimage) to storage afterwards")
+	yesFlag := fs.Bool("yes", false, "do not ask for confirmation")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: %s erase (-region nvs|storage|all|<label> | -factory-reset [-storage-image littlefs.bin]) [flags]\n\n", os.Args[0])
+		fmt.Fprintf(fs.Output(), "Files on the SD card are not touched.\n\n")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if fs.NArg() != 0 || (*regionFlag == "") == !*factoryFlag || *storageFlag != "" && !*factoryFlag {
+		fs.Usage()
+		return 2
+	}
+	flashSize, err := parseFlashSize(*flashSizeFlag)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "-flash-size: %v\n", err)
+		return 2
+	}
+	var storageImage []byte
+	if *storageFlag != "" {
+		if storageImage, err = os.ReadFile(*storageFlag); err != nil {
+			fmt.Fprintf(os.Stderr, "%v\n", err)
+			return 1
+		}
+	}
+
+	name := *portFlag
+	if name == "" {
+		if name, err = detectPort(); err != nil {
+			fmt.Fprintf(os.Stderr, "Auto-detect failed: %v\n", err)
+			return 1
+		}
+		fmt.Fprintf(os.Stderr, "Auto-detected port: %s\n", name)
+	}
+	s, err := connect(name, *baudFlag, *flashBaudFlag, flashSize)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		return 1
+	}
+	defer s.port.Close()
+	var parts []partition
+	table, err := s.loader.readFlash(partitionTableOffset, partitionTableSize, nil)
+	if err == nil {
+		parts, err = parsePartitionTable(table)
+	}
+	if err != nil && *regionFlag != "all" {
+		fmt.Fprintf(os.Stderr, "Reading the partition table: %v\n", err)
+		return 1
+	}
+
+	var areas []flashArea
+	if *factoryFlag {
+		areas, err = factoryResetTargets(parts)
+	} else {
+		areas, err = eraseTargets(parts, *regionFlag, flashSize)
+	}
+	var storage partition
+	if err == nil && storageImage != nil {
+		if storage, err = findStoragePartition(parts); err == nil && uint32(len(storageImage)) > storage.size {
+			err = fmt.Errorf("%s is larger than the %s %s partition", *storageFlag, humanSize(storage.size), storage.label)
+		}
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		return 1
+	}
+	if *regionFlag == "all" {
+		fmt.Fprintf(os.Stderr, "Erasing all flash removes the firmware too; the board will not boot until it is flashed again.\n")
+	}
+	if !*yesFlag && !confirm(os.Stderr, os.Stdin, areas) {
+		fmt.Fprintf(os.Stderr, "Nothing erased.\n")
+		s.finish(true)
+		return 1
+	}
+	if err := eraseAreas(s.loader, areas); err != nil {
+		fmt.Fprintf(os.Stderr, "\n%v\n", err)
+		return 1
+	}
+	if storageImage != nil {
+		err := s.loader.writeFlash(storage.offset, storageImage, func(done, total int) {
+			fmt.Fprintf(os.Stderr, "\rWriting %s at 0x%08x... %3d%%", *storageFlag, storage.offset, done*100/total)
+		})
+		if err == nil {
+			err = s.loader.verify(storage.offset, storageImage)
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "\n%v\n", err)
+			return 1
+		}
+		fmt.Fprintf(os.Stderr, "\rWrote %s to %s\n", *storageFlag, storage.label)
+	}
+	s.finish(*regionFlag != "all")
+	return 0
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestEraseTargets(t *testing.T) {
+	parts, err := parsePartitionCSV(sumiCSV)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		region string
+		want   flashArea
+	}{
+		{"nvs", flashArea{"nvs", 0x9000, 0xE000}},
+		{"storage", flashArea{"spiffs", 0xC90000, 0xFF0000}},
+		{"coredump", flashArea{"coredump", 0xFF0000, 0x1000000}},
+		{"all", flashArea{"all flash", 0, 16 << 20}},
+	}
+	for _, tt := range tests {
+		areas, err := eraseTargets(parts, tt.region, 16<<20)
+		if err != nil {
+			t.Errorf("%s: %v", tt.region, err)
+			continue
+		}
+		if len(areas) != 1 || areas[0] != tt.want {
+			t.Errorf("%s: got %v, want %v", tt.region, areas, tt.want)
+		}
+	}
+	if _, err := eraseTargets(parts, "app2", 16<<20); err == nil || !strings.Contains(err.Error(), "app0, app1") {
+		t.Errorf("app2: got %v, want an error listing the partitions", err)
+	}
+
+	areas, err := factoryResetTargets(parts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var labels []string
+	for _, a := range areas {
+		labels = append(labels, a.label)
+	}
+	if got := strings.Join(labels, " "); got != "nvs otadata spiffs" {
+		t.Errorf("factory reset erases %s, want nvs otadata spiffs", got)
+	}
+}
+
+func TestConfirm(t *testing.T) {
+	areas := []flashArea{{"nvs", 0x9000, 0xE000}}
+	for input, want := range map[string]bool{"yes\n": true, " yes \n": true, "y\n": false, "": false, "nvs\n": false} {
+		var out bytes.Buffer
+		if got := confirm(&out, strings.NewReader(input), areas); got != want {
+			t.Errorf("%q: got %v, want %v", input, got, want)
+		}
+		if !strings.Contains(out.String(), "nvs") {
+			t.Errorf("%q: prompt %q does not name the region", input, out.String())
+		}
+	}
+}
+
+func TestEraseAreas(t *testing.T) {
+	rom := slotROM()
+	copy(rom.flash[0x9000:], bytes.Repeat([]byte{0x42}, 0x5000))
+	l := newLoader(rom)
+	if err := eraseAreas(l, []flashArea{{"nvs", 0x9000, 0xE000}}); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(rom.flash[0x9000:0xE000], bytes.Repeat([]byte{0xFF}, 0x5000)) {
+		t.Error("nvs is not blank")
+	}
+	if rom.flash[0x10000] != imageMagic {
+		t.Error("erasing nvs touched app0")
+	}
+}
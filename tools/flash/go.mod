@@ -0,0 +1,10 @@
+module sumi-flash
+
+go 1.21
+
+require go.bug.st/serial v1.6.2
+
+require (
+	github.com/creack/goselect v0.1.2 // indirect
+	golang.org/x/sys v0.19.0 // indirect
+)
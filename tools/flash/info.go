@@ -0,0 +1,260 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// appDescSize is the size of esp_app_desc_t, which follows the image header.
+const appDescSize = 256
+
+// appDescOffset is where esp_app_desc_t sits in an app image: after the
+// 24-byte image header and the first 8-byte segment header.
+const (
+	appDescOffset = 32
+	appDescMagic  = 0xABCD5432
+)
+
+// appDesc is what an app image's esp_app_desc_t says about its build.
+type appDesc struct {
+	Project   string `json:"project"`
+	Version   string `json:"version"`
+	BuildDate string `json:"build_date"`
+	IDF       string `json:"idf_version"`
+	ELFSHA256 string `json:"elf_sha256"`
+}
+
+// parseAppDesc reads the app description from the start of an app image.
+func parseAppDesc(data []byte) (appDesc, error) {
+	if len(data) < appDescOffset+176 || data[0] != imageMagic {
+		return appDesc{}, fmt.Errorf("not an ESP32 app image")
+	}
+	return decodeAppDesc(data[appDescOffset:])
+}
+
+// decodeAppDesc decodes an esp_app_desc_t.
+func decodeAppDesc(desc []byte) (appDesc, error) {
+	if len(desc) < 176 || binary.LittleEndian.Uint32(desc) != appDescMagic {
+		return appDesc{}, fmt.Errorf("image has no app description")
+	}
+	str := func(off, n int) string {
+		s, _, _ := bytes.Cut(desc[off:off+n], []byte{0})
+		return string(s)
+	}
+	return appDesc{
+		Version:   str(16, 32),
+		Project:   str(48, 32),
+		BuildDate: strings.TrimSpace(str(96, 16) + " " + str(80, 16)),
+		IDF:       str(112, 32),
+		ELFSHA256: hex.EncodeToString(desc[144:176]),
+	}, nil
+}
+
+// Board types as HardwareDetect stores them in NVS under cphw.
+var boardNames = map[byte]string{1: "X4", 2: "X3"}
+
+// boardDisplays are the panels of each board.
+var boardDisplays = map[string]string{"X4": "800x480", "X3": "792x528"}
+
+// slotInfo is one OTA app slot and what it holds.
+type slotInfo struct {
+	Label    string   `json:"label"`
+	Offset   uint32   `json:"offset"`
+	Boots    bool     `json:"boots"`
+	State    string   `json:"state,omitempty"`
+	Firmware *appDesc `json:"firmware,omitempty"`
+}
+
+// deviceInfo is what "info" reports.
+type deviceInfo struct {
+	Chip        *chipInfo  `json:"chip,omitempty"`
+	Firmware    *appDesc   `json:"firmware"`
+	Slots       []slotInfo `json:"slots"`
+	Board       string     `json:"board"`
+	BoardSource string     `json:"board_source,omitempty"`
+	Display     string     `json:"display,omitempty"`
+}
+
+// flashReader reads a range of the board's flash.
+type flashReader func(offset, size uint32) ([]byte, error)
+
+// readSlots reads the app description in each OTA slot and marks the one the
+// bootloader starts.
+func readSlots(read flashReader, st otaState) ([]slotInfo, error) {
+	boot, entry := bootSlot(st.entries, len(st.slots))
+	var slots []slotInfo
+	for i, p := range st.slots {
+		s := slotInfo{Label: p.label, Offset: p.offset, Boots: i == boot}
+		if s.Boots && entry >= 0 {
+			s.State = st.entries[entry].stateName()
+		}
+		head, err := read(p.offset, appDescOffset+appDescSize)
+		if err != nil {
+			return nil, err
+		}
+		if d, err := parseAppDesc(head); err == nil {
+			s.Firmware = &d
+		}
+		slots = append(slots, s)
+	}
+	return slots, nil
+}
+
+// readDeviceInfo collects the firmware in each OTA slot, which one boots, and
+// the board type the firmware detected, from the partition table, otadata,
+// the app descriptions and NVS.
+func readDeviceInfo(read flashReader) (deviceInfo, error) {
+	st, err := readOTAState(read)
+	if err != nil {
+		return deviceInfo{}, err
+	}
+	var info deviceInfo
+	if info.Slots, err = readSlots(read, st); err != nil {
+		return deviceInfo{}, err
+	}
+	for _, s := range info.Slots {
+		if s.Boots {
+			info.Firmware = s.Firmware
+		}
+	}
+
+	info.Board = "unknown"
+	if p, err := findNVSPartition(st.parts); err == nil {
+		image, err := read(p.offset, p.size)
+		if err != nil {
+			return deviceInfo{}, err
+		}
+		items, _ := parseNVS(image, func(string) {})
+		detected, override := byte(0), byte(0)
+		for _, it := range items {
+			if it.ns == "cphw" && it.typ == nvsU8 && len(it.data) > 0 {
+				switch it.key {
+				case "dev_det":
+					detected = it.data[0]
+				case "dev_ovr":
+					override = it.data[0]
+				}
+			}
+		}
+		switch {
+		case boardNames[override] != "":
+			info.Board, info.BoardSource = boardNames[override], "override"
+		case boardNames[detected] != "":
+			info.Board, info.BoardSource = boardNames[detected], "detected"
+		}
+		info.Display = boardDisplays[info.Board]
+	}
+	return info, nil
+}
+
+// printInfo writes info for a bug report.
+func printInfo(w io.Writer, info deviceInfo) {
+	if info.Chip != nil {
+		printChip(w, *info.Chip)
+	}
+	if info.Firmware != nil {
+		fmt.Fprintf(w, "Firmware:  %s %s\n", info.Firmware.Project, info.Firmware.Version)
+		fmt.Fprintf(w, "Built:     %s, ESP-IDF %s\n", info.Firmware.BuildDate, info.Firmware.IDF)
+		fmt.Fprintf(w, "ELF:       %s\n", info.Firmware.ELFSHA256)
+	} else {
+		fmt.Fprintf(w, "Firmware:  none in the slot the bootloader starts\n")
+	}
+	printSlots(w, info.Slots)
+	board := info.Board
+	if info.BoardSource != "" {
+		board += " (" + info.BoardSource + ")"
+	}
+	fmt.Fprintf(w, "Board:     %s\n", board)
+	if info.Display != "" {
+		fmt.Fprintf(w, "Display:   %s\n", info.Display)
+	}
+}
+
+// printSlots writes a line per OTA slot saying what it holds and whether it
+// boots.
+func printSlots(w io.Writer, slots []slotInfo) {
+	for _, s := range slots {
+		what := "empty"
+		if s.Firmware != nil {
+			what = s.Firmware.Project + " " + s.Firmware.Version
+		}
+		if s.Boots {
+			what += " (boots"
+			if s.State != "" {
+				what += ", " + s.State
+			}
+			what += ")"
+		}
+		fmt.Fprintf(w, "Slot:      %s at 0x%06x: %s\n", s.Label, s.Offset, what)
+	}
+}
+
+// runInfo implements "info [-json]".
+func runInfo(args []string) int {
+	fs := flag.NewFlagSet("info", flag.ContinueOnError)
+	portFlag := fs.String("port", "", "serial port (auto-detected when a single ESP32 board is plugged in)")
+	baudFlag := fs.Int("baud", 115200, "baud rate to reach the bootloader at")
+	flashBaudFlag := fs.Int("flash-baud", 921600, "baud rate to switch to for reading (0 stays at -baud)")
+	flashSizeFlag := fs.String("flash-size", "16MB", "size of the board's flash chip")
+	jsonFlag := fs.Bool("json", false, "print JSON instead of text")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: %s info [-json] [flags]\n\n", os.Args[0])
+		fmt.Fprintf(fs.Output(), "Reads the firmware version, build and board type from a board in its\n")
+		fmt.Fprintf(fs.Output(), "bootloader, so it works even when the firmware does not boot.\n\n")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if fs.NArg() != 0 {
+		fs.Usage()
+		return 2
+	}
+	flashSize, err := parseFlashSize(*flashSizeFlag)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "-flash-size: %v\n", err)
+		return 2
+	}
+	name := *portFlag
+	if name == "" {
+		if name, err = detectPort(); err != nil {
+			fmt.Fprintf(os.Stderr, "Auto-detect failed: %v\n", err)
+			return 1
+		}
+		fmt.Fprintf(os.Stderr, "Auto-detected port: %s\n", name)
+	}
+	s, err := connect(name, *baudFlag, *flashBaudFlag, flashSize)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		return 1
+	}
+	defer s.port.Close()
+	info, err := readDeviceInfo(func(offset, size uint32) ([]byte, error) {
+		return s.loader.readFlash(offset, size, nil)
+	})
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		return 1
+	}
+	if c, err := readChipInfo(s.loader.readReg); err == nil {
+		if id, err := s.loader.flashID(); err == nil {
+			c.addFlashID(id)
+		}
+		info.Chip = &c
+	}
+	s.finish(true)
+	if *jsonFlag {
+		b, _ := json.MarshalIndent(info, "", "  ")
+		fmt.Printf("%s\n", b)
+		return 0
+	}
+	printInfo(os.Stdout, info)
+	return 0
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+// appImage builds the start of an app image carrying version.
+func appImage(version string) []byte {
+	data := make([]byte, 256)
+	data[0] = imageMagic
+	binary.LittleEndian.PutUint32(data[appDescOffset:], appDescMagic)
+	copy(data[appDescOffset+16:], version)
+	return data
+}
+
+// appWithDesc is the start of an app image with a full app description.
+func appWithDesc(project, version string) []byte {
+	data := appImage(version)
+	desc := data[appDescOffset:]
+	copy(desc[48:], project)
+	copy(desc[80:], "12:34:56")
+	copy(desc[96:], "Oct 16 2026")
+	copy(desc[112:], "v4.4.7")
+	desc[144] = 0xAB
+	return data
+}
+
+// otaEntryBytes encodes an otadata entry with a correct CRC.
+func otaEntryBytes(seq, state uint32) []byte {
+	b := bytes.Repeat([]byte{0xFF}, otaEntrySize)
+	binary.LittleEndian.PutUint32(b, seq)
+	binary.LittleEndian.PutUint32(b[24:], state)
+	binary.LittleEndian.PutUint32(b[28:], otaSeqCRC(seq))
+	return b
+}
+
+func TestBootSlot(t *testing.T) {
+	entry := func(seq, state uint32) otaEntry { return parseOTAEntry(otaEntryBytes(seq, state)) }
+	blank := parseOTAEntry(bytes.Repeat([]byte{0xFF}, otaEntrySize))
+	badCRC := entry(5, otaStateValid)
+	badCRC.crc++
+	tests := []struct {
+		entries     [2]otaEntry
+		slot, entry int
+	}{
+		{[2]otaEntry{blank, blank}, 0, -1},
+		{[2]otaEntry{entry(1, otaStateValid), blank}, 0, 0},
+		{[2]otaEntry{entry(1, otaStateValid), entry(2, otaStateUndefined)}, 1, 1},
+		{[2]otaEntry{entry(3, otaStateValid), entry(2, otaStateValid)}, 0, 0},
+		{[2]otaEntry{entry(1, otaStateValid), entry(2, otaStateInvalid)}, 0, 0},
+		{[2]otaEntry{entry(1, otaStateValid), badCRC}, 0, 0},
+	}
+	for i, tt := range tests {
+		if slot, e := bootSlot(tt.entries, 2); slot != tt.slot || e != tt.entry {
+			t.Errorf("case %d: slot %d entry %d, want slot %d entry %d", i, slot, e, tt.slot, tt.entry)
+		}
+	}
+}
+
+func TestReadDeviceInfo(t *testing.T) {
+	flash := bytes.Repeat([]byte{0xFF}, 0x660000)
+	copy(flash[partitionTableOffset:], sumiTable())
+	nvs, err := encodeNVS([]nvsItem{{ns: "cphw", key: "dev_det", typ: nvsU8, data: []byte{2}}}, 0x5000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	copy(flash[0x9000:], nvs)
+	copy(flash[0xE000:], otaEntryBytes(1, otaStateValid))
+	copy(flash[0xF000:], otaEntryBytes(2, otaStatePendingVerify))
+	copy(flash[0x10000:], appWithDesc("SUMI", "0.6.4"))
+	copy(flash[0x650000:], appWithDesc("sumiboy", "1.1.0"))
+
+	info, err := readDeviceInfo(func(offset, size uint32) ([]byte, error) {
+		return flash[offset : offset+size], nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Firmware == nil || info.Firmware.Project != "sumiboy" || info.Firmware.BuildDate != "Oct 16 2026 12:34:56" {
+		t.Errorf("firmware %+v, want sumiboy from app1", info.Firmware)
+	}
+	if info.Board != "X3" || info.BoardSource != "detected" || info.Display != "792x528" {
+		t.Errorf("board %s (%s), display %s", info.Board, info.BoardSource, info.Display)
+	}
+	var out strings.Builder
+	printInfo(&out, info)
+	for _, want := range []string{
+		"Slot:      app0 at 0x010000: SUMI 0.6.4\n",
+		"Slot:      app1 at 0x650000: sumiboy 1.1.0 (boots, pending verify)\n",
+		"ESP-IDF v4.4.7\n",
+		fmt.Sprintf("ELF:       ab%s\n", strings.Repeat("00", 31)),
+	} {
+		if !strings.Contains(out.String(), want) {
+			t.Errorf("output lacks %q:\n%s", want, out.String())
+		}
+	}
+}
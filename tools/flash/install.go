@@ -0,0 +1,252 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+// defaultFeed lists the project's releases, newest first, in the GitHub
+// releases API format.
+const defaultFeed = "https://api.github.com/repos/psychoplath9450/SUMI/releases"
+
+// chipFamily is the chip SUMI boards carry, as named in ESP Web Tools
+// manifests.
+const chipFamily = "ESP32-C3"
+
+// release is the part of a GitHub release the installer uses.
+type release struct {
+	Tag        string         `json:"tag_name"`
+	Draft      bool           `json:"draft"`
+	Prerelease bool           `json:"prerelease"`
+	Assets     []releaseAsset `json:"assets"`
+}
+
+type releaseAsset struct {
+	Name string `json:"name"`
+	URL  string `json:"browser_download_url"`
+}
+
+// fetchReleases reads the release feed.
+func fetchReleases(client *http.Client, feed string) ([]release, error) {
+	resp, err := client.Get(feed)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("release feed: %s", resp.Status)
+	}
+	var releases []release
+	if err := json.NewDecoder(resp.Body).Decode(&releases); err != nil {
+		return nil, fmt.Errorf("release feed: %v", err)
+	}
+	return releases, nil
+}
+
+// pickRelease returns the newest release of a channel: "stable" takes only
+// full releases, "nightly" pre-releases as well.
+func pickRelease(releases []release, channel string) (release, error) {
+	if channel != "stable" && channel != "nightly" {
+		return release{}, fmt.Errorf("unknown channel %q (want stable or nightly)", channel)
+	}
+	for _, r := range releases {
+		if !r.Draft && (!r.Prerelease || channel == "nightly") {
+			return r, nil
+		}
+	}
+	return release{}, fmt.Errorf("no %s release in the feed", channel)
+}
+
+func (r release) asset(name string) (releaseAsset, bool) {
+	for _, a := range r.Assets {
+		if a.Name == name {
+			return a, true
+		}
+	}
+	return releaseAsset{}, false
+}
+
+// releaseImage picks the merged image for chipFamily out of a release and
+// the SHA-256 it must have. A manifest.json, as "merge -manifest" writes,
+// names both; without one, the single sumi-*-full.bin and its .sha256
+// sidecar are used.
+func releaseImage(client *http.Client, r release) (releaseAsset, string, error) {
+	if a, ok := r.asset("manifest.json"); ok {
+		body, err := download(client, a.URL, nil)
+		if err != nil {
+			return releaseAsset{}, "", fmt.Errorf("%s: %v", a.Name, err)
+		}
+		var m webManifest
+		if err := json.Unmarshal(body, &m); err != nil {
+			return releaseAsset{}, "", fmt.Errorf("%s: %v", a.Name, err)
+		}
+		for _, b := range m.Builds {
+			if b.ChipFamily != chipFamily || len(b.Parts) != 1 || b.Parts[0].Offset != 0 {
+				continue
+			}
+			img, ok := r.asset(b.Parts[0].Path)
+			if !ok {
+				return releaseAsset{}, "", fmt.Errorf("%s names %s, which the release does not have", a.Name, b.Parts[0].Path)
+			}
+			return img, b.Parts[0].SHA256, nil
+		}
+		return releaseAsset{}, "", fmt.Errorf("%s has no merged image for the %s", a.Name, chipFamily)
+	}
+
+	var images []releaseAsset
+	for _, a := range r.Assets {
+		if strings.HasPrefix(a.Name, "sumi-") && strings.HasSuffix(a.Name, "-full.bin") {
+			images = append(images, a)
+		}
+	}
+	if len(images) != 1 {
+		return releaseAsset{}, "", fmt.Errorf("release %s has %d sumi-*-full.bin images and no manifest.json to choose by", r.Tag, len(images))
+	}
+	sidecar, ok := r.asset(images[0].Name + ".sha256")
+	if !ok {
+		return releaseAsset{}, "", fmt.Errorf("release %s has no %s.sha256 to check the download against", r.Tag, images[0].Name)
+	}
+	body, err := download(client, sidecar.URL, nil)
+	if err != nil {
+		return releaseAsset{}, "", fmt.Errorf("%s: %v", sidecar.Name, err)
+	}
+	// sha256sum format: the digest, then the file name.
+	fields := strings.Fields(string(body))
+	if len(fields) == 0 {
+		return releaseAsset{}, "", fmt.Errorf("%s is empty", sidecar.Name)
+	}
+	return images[0], fields[0], nil
+}
+
+// progressReader reports how much of a body has been read.
+type progressReader struct {
+	r        io.Reader
+	done     int
+	total    int
+	progress func(done, total int)
+}
+
+func (p *progressReader) Read(b []byte) (int, error) {
+	n, err := p.r.Read(b)
+	p.done += n
+	p.progress(p.done, p.total)
+	return n, err
+}
+
+// download fetches url, calling progress as the body arrives.
+func download(client *http.Client, url string, progress func(done, total int)) ([]byte, error) {
+	resp, err := client.Get(url)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, errors.New(resp.Status)
+	}
+	var r io.Reader = resp.Body
+	if progress != nil && resp.ContentLength > 0 {
+		r = &progressReader{r: resp.Body, total: int(resp.ContentLength), progress: progress}
+	}
+	return io.ReadAll(r)
+}
+
+// fetchImage downloads the merged image of a release and checks it against
+// the release's SHA-256.
+func fetchImage(client *http.Client, r release) (string, []byte, error) {
+	a, want, err := releaseImage(client, r)
+	if err != nil {
+		return "", nil, err
+	}
+	data, err := download(client, a.URL, func(done, total int) {
+		fmt.Fprintf(os.Stderr, "\rDownloading %s... %3d%%", a.Name, done*100/total)
+	})
+	if err != nil {
+		return "", nil, fmt.Errorf("%s: %v", a.Name, err)
+	}
+	fmt.Fprintf(os.Stderr, "\rDownloaded %s (%d bytes)\n", a.Name, len(data))
+	sum := sha256.Sum256(data)
+	if got := hex.EncodeToString(sum[:]); !strings.EqualFold(got, want) {
+		return "", nil, fmt.Errorf("%s has SHA-256 %s, but the release lists %s; the download is corrupt", a.Name, got, want)
+	}
+	if !isFlashDump(data) {
+		return "", nil, fmt.Errorf("%s is not a merged image with a bootloader and partition table", a.Name)
+	}
+	return a.Name, data, nil
+}
+
+// runInstall implements "install [-channel stable]": it flashes the newest
+// release of a channel.
+func runInstall(args []string) int {
+	fs := flag.NewFlagSet("install", flag.ContinueOnError)
+	channelFlag := fs.String("channel", "stable", "release channel: stable, or nightly for pre-releases too")
+	feedFlag := fs.String("feed", defaultFeed, "release feed URL")
+	portFlag := fs.String("port", "", "serial port (auto-detected when a single ESP32 board is plugged in)")
+	baudFlag := fs.Int("baud", 115200, "baud rate to reach the bootloader at")
+	flashBaudFlag := fs.Int("flash-baud", 921600, "baud rate to switch to for writing (0 stays at -baud)")
+	flashSizeFlag := fs.String("flash-size", "16MB", "size of the board's flash chip")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: %s install [-channel stable|nightly] [flags]\n\n", os.Args[0])
+		fmt.Fprintf(fs.Output(), "Downloads the newest release's merged image, checks its SHA-256 and\n")
+		fmt.Fprintf(fs.Output(), "flashes it at 0.\n\n")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if fs.NArg() != 0 {
+		fs.Usage()
+		return 2
+	}
+	flashSize, err := parseFlashSize(*flashSizeFlag)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "-flash-size: %v\n", err)
+		return 2
+	}
+
+	client := &http.Client{Timeout: 5 * time.Minute}
+	releases, err := fetchReleases(client, *feedFlag)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		return 1
+	}
+	r, err := pickRelease(releases, *channelFlag)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		return 1
+	}
+	fmt.Fprintf(os.Stderr, "Newest %s release: %s\n", *channelFlag, r.Tag)
+	name, data, err := fetchImage(client, r)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "\n%v\n", err)
+		return 1
+	}
+	if uint64(len(data)) > uint64(flashSize) {
+		fmt.Fprintf(os.Stderr, "%s does not fit in %s of flash\n", name, *flashSizeFlag)
+		return 1
+	}
+
+	port := *portFlag
+	if port == "" {
+		if port, err = detectPort(); err != nil {
+			fmt.Fprintf(os.Stderr, "Auto-detect failed: %v\n", err)
+			return 1
+		}
+		fmt.Fprintf(os.Stderr, "Auto-detected port: %s\n", port)
+	}
+	images := []flashImage{{path: name, offset: 0, data: data}}
+	if err := flash(port, *baudFlag, *flashBaudFlag, flashSize, images, true, true); err != nil {
+		fmt.Fprintf(os.Stderr, "\nFlashing failed: %v\n", err)
+		return 1
+	}
+	fmt.Fprintf(os.Stderr, "Installed SUMI %s.\n", r.Tag)
+	return 0
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestPickRelease(t *testing.T) {
+	releases := []release{
+		{Tag: "v0.7.0", Draft: true},
+		{Tag: "v0.7.0-nightly.3", Prerelease: true},
+		{Tag: "v0.6.4"},
+	}
+	for channel, want := range map[string]string{"stable": "v0.6.4", "nightly": "v0.7.0-nightly.3"} {
+		if r, err := pickRelease(releases, channel); err != nil || r.Tag != want {
+			t.Errorf("%s: got %s, %v; want %s", channel, r.Tag, err, want)
+		}
+	}
+	if _, err := pickRelease(releases, "beta"); err == nil {
+		t.Error("unknown channel: expected error")
+	}
+	if _, err := pickRelease(releases[:2], "stable"); err == nil {
+		t.Error("no stable release: expected error")
+	}
+}
+
+// releaseServer serves a feed of one release holding files.
+func releaseServer(t *testing.T, files map[string][]byte) (*httptest.Server, release) {
+	mux := http.NewServeMux()
+	srv := httptest.NewServer(mux)
+	t.Cleanup(srv.Close)
+	r := release{Tag: "v0.6.4"}
+	for name, data := range files {
+		data := data
+		mux.HandleFunc("/download/"+name, func(w http.ResponseWriter, _ *http.Request) { w.Write(data) })
+		r.Assets = append(r.Assets, releaseAsset{Name: name, URL: srv.URL + "/download/" + name})
+	}
+	mux.HandleFunc("/releases", func(w http.ResponseWriter, _ *http.Request) { json.NewEncoder(w).Encode([]release{r}) })
+	return srv, r
+}
+
+func TestFetchImage(t *testing.T) {
+	img := mergedImage()
+	sum := sha256.Sum256(img)
+	digest := hex.EncodeToString(sum[:])
+	manifest, _ := json.Marshal(webManifest{Name: "SUMI", Builds: []manifestBuild{{
+		ChipFamily: chipFamily, Parts: []manifestPart{{Path: "sumi-v0.6.4-full.bin", SHA256: digest}},
+	}}})
+	tests := []struct {
+		name    string
+		files   map[string][]byte
+		wantErr string
+	}{
+		{"manifest", map[string][]byte{"manifest.json": manifest, "sumi-v0.6.4-full.bin": img}, ""},
+		{"sidecar", map[string][]byte{
+			"sumi-v0.6.4-full.bin":        img,
+			"sumi-v0.6.4-full.bin.sha256": []byte(digest + "  sumi-v0.6.4-full.bin\n"),
+		}, ""},
+		{"corrupt", map[string][]byte{
+			"sumi-v0.6.4-full.bin":        img[:len(img)-1],
+			"sumi-v0.6.4-full.bin.sha256": []byte(digest + "  sumi-v0.6.4-full.bin\n"),
+		}, "the download is corrupt"},
+		{"no checksum", map[string][]byte{"sumi-v0.6.4-full.bin": img}, "no sumi-v0.6.4-full.bin.sha256"},
+		{"app image", map[string][]byte{
+			"sumi-v0.6.4-full.bin":        appImage("0.6.4"),
+			"sumi-v0.6.4-full.bin.sha256": []byte(fmt.Sprintf("%x", sha256.Sum256(appImage("0.6.4")))),
+		}, "not a merged image"},
+	}
+	for _, tt := range tests {
+		srv, _ := releaseServer(t, tt.files)
+		releases, err := fetchReleases(srv.Client(), srv.URL+"/releases")
+		if err != nil {
+			t.Fatal(err)
+		}
+		name, data, err := fetchImage(srv.Client(), releases[0])
+		switch {
+		case tt.wantErr == "" && (err != nil || name != "sumi-v0.6.4-full.bin" || len(data) != len(img)):
+			t.Errorf("%s: got %s (%d bytes), %v", tt.name, name, len(data), err)
+		case tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)):
+			t.Errorf("%s: got %v, want an error containing %q", tt.name, err, tt.wantErr)
+		}
+	}
+}
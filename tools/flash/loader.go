@@ -0,0 +1,325 @@
+package main
+
+import (
+	"bytes"
+	"compress/zlib"
+	"crypto/md5"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+)
+
+// Serial bootloader commands, as documented in esptool's serial protocol
+// reference.
+const (
+	cmdSync          = 0x08
+	cmdWriteReg      = 0x09
+	cmdReadReg       = 0x0A
+	cmdSPISetParams  = 0x0B
+	cmdSPIAttach     = 0x0D
+	cmdReadFlashSlow = 0x0E
+	cmdChangeBaud    = 0x0F
+	cmdFlashDeflBeg  = 0x10
+	cmdFlashDeflData = 0x11
+	cmdSPIFlashMD5   = 0x13
+)
+
+const (
+	// romWriteSize is the block size the ROM loader accepts for flash data.
+	romWriteSize = 0x400
+	// romReadSize is the most the ROM loader returns per flash read.
+	romReadSize = 64
+	// checksumSeed starts the XOR checksum of a data packet's payload.
+	checksumSeed = 0xEF
+	// chipMagicReg holds a value that identifies the chip family.
+	chipMagicReg = 0x40001000
+)
+
+// Timeouts, scaled by size where the loader's work grows with the data.
+const (
+	defaultTimeout    = 3 * time.Second
+	syncTimeout       = 100 * time.Millisecond
+	eraseTimeoutPerMB = 30 * time.Second
+	writeTimeoutPerMB = 40 * time.Second
+	md5TimeoutPerMB   = 8 * time.Second
+)
+
+// esp32C3Magic are the chip magic values of the ESP32-C3 revisions.
+var esp32C3Magic = map[uint32]bool{0x6921506F: true, 0x1B31506F: true, 0x4881606F: true, 0x4361606F: true}
+
+// responseData is the length of the data that precedes the status bytes in
+// the responses of commands that return data in the body.
+var responseData = map[byte]int{cmdSPIFlashMD5: 32, cmdReadFlashSlow: romReadSize}
+
+// loaderPort is the part of serial.Port the loader uses.
+type loaderPort interface {
+	io.ReadWriter
+	SetReadTimeout(time.Duration) error
+}
+
+// loader talks to the ESP32 ROM serial bootloader.
+type loader struct {
+	port loaderPort
+	dec  slipDecoder
+	rx   [][]byte // decoded packets not yet consumed
+	buf  []byte
+}
+
+func newLoader(port loaderPort) *loader {
+	return &loader{port: port, buf: make([]byte, 4096)}
+}
+
+// command sends a request and waits for the matching response, returning its
+// value field and data. ROM responses end in status bytes whose first byte is
+// nonzero on failure, followed by the error code.
+func (l *loader) command(op byte, data []byte, checksum uint32, timeout time.Duration) (uint32, []byte, error) {
+	packet := make([]byte, 8, 8+len(data))
+	packet[1] = op
+	binary.LittleEndian.PutUint16(packet[2:], uint16(len(data)))
+	binary.LittleEndian.PutUint32(packet[4:], checksum)
+	packet = append(packet, data...)
+	if _, err := l.port.Write(slipEncode(packet)); err != nil {
+		return 0, nil, err
+	}
+	deadline := time.Now().Add(timeout)
+	for {
+		resp, err := l.packet(deadline)
+		if err != nil {
+			return 0, nil, fmt.Errorf("command 0x%02x: %w", op, err)
+		}
+		if len(resp) < 8 || resp[0] != 0x01 || resp[1] != op {
+			continue
+		}
+		value := binary.LittleEndian.Uint32(resp[4:])
+		body := resp[8:]
+		if n := int(binary.LittleEndian.Uint16(resp[2:])); n < len(body) {
+			body = body[:n]
+		}
+		status := body
+		if n := responseData[op]; len(body) > n {
+			status = body[n:]
+		}
+		if len(status) < 2 {
+			return 0, nil, fmt.Errorf("command 0x%02x: short response", op)
+		}
+		if status[0] != 0 {
+			return 0, nil, fmt.Errorf("command 0x%02x failed: %s", op, romError(status[1]))
+		}
+		return value, body, nil
+	}
+}
+
+// packet returns the next decoded packet, reading until deadline.
+func (l *loader) packet(deadline time.Time) ([]byte, error) {
+	for len(l.rx) == 0 {
+		wait := time.Until(deadline)
+		if wait <= 0 {
+			return nil, errTimeout
+		}
+		if err := l.port.SetReadTimeout(wait); err != nil {
+			return nil, err
+		}
+		n, err := l.port.Read(l.buf)
+		if err != nil {
+			return nil, err
+		}
+		l.rx = append(l.rx, l.dec.feed(l.buf[:n])...)
+	}
+	p := l.rx[0]
+	l.rx = l.rx[1:]
+	return p, nil
+}
+
+var errTimeout = errors.New("timed out waiting for the bootloader")
+
+// romError describes a ROM loader error code.
+func romError(code byte) string {
+	switch code {
+	case 0x05:
+		return "received message is invalid"
+	case 0x06:
+		return "failed to act on received message"
+	case 0x07:
+		return "invalid CRC in message"
+	case 0x08:
+		return "flash write error"
+	case 0x09:
+		return "flash read error"
+	case 0x0A:
+		return "flash read length error"
+	case 0x0B:
+		return "deflate error"
+	}
+	return fmt.Sprintf("error 0x%02x", code)
+}
+
+// sync establishes contact, trying attempts times. The ROM answers one SYNC
+// with several responses; the extras are drained.
+func (l *loader) sync(attempts int) error {
+	data := append([]byte{0x07, 0x07, 0x12, 0x20}, bytes.Repeat([]byte{0x55}, 32)...)
+	var err error
+	for i := 0; i < attempts; i++ {
+		if _, _, err = l.command(cmdSync, data, 0, syncTimeout); err == nil {
+			for {
+				if _, perr := l.packet(time.Now().Add(syncTimeout)); perr != nil {
+					return nil
+				}
+			}
+		}
+	}
+	return err
+}
+
+func (l *loader) readReg(addr uint32) (uint32, error) {
+	v, _, err := l.command(cmdReadReg, binary.LittleEndian.AppendUint32(nil, addr), 0, defaultTimeout)
+	return v, err
+}
+
+// writeReg sets a register; the mask and delay words are left at "all bits"
+// and "no delay".
+func (l *loader) writeReg(addr, value uint32) error {
+	var data []byte
+	for _, v := range []uint32{addr, value, 0xFFFFFFFF, 0} {
+		data = binary.LittleEndian.AppendUint32(data, v)
+	}
+	_, _, err := l.command(cmdWriteReg, data, 0, defaultTimeout)
+	return err
+}
+
+// spiAttach connects the ROM to the default SPI flash pins.
+func (l *loader) spiAttach() error {
+	_, _, err := l.command(cmdSPIAttach, make([]byte, 8), 0, defaultTimeout)
+	return err
+}
+
+// setFlashSize tells the ROM the flash chip's size.
+func (l *loader) setFlashSize(size uint32) error {
+	var data []byte
+	for _, v := range []uint32{0, size, 64 << 10, 4 << 10, 256, 0xFFFF} {
+		data = binary.LittleEndian.AppendUint32(data, v)
+	}
+	_, _, err := l.command(cmdSPISetParams, data, 0, defaultTimeout)
+	return err
+}
+
+// changeBaud switches the loader to baud; the caller then reopens its side
+// at the same rate.
+func (l *loader) changeBaud(baud int) error {
+	data := binary.LittleEndian.AppendUint32(nil, uint32(baud))
+	data = binary.LittleEndian.AppendUint32(data, 0)
+	_, _, err := l.command(cmdChangeBaud, data, 0, defaultTimeout)
+	return err
+}
+
+// perMB scales a timeout by a size in bytes, never going below the default.
+func perMB(t time.Duration, size int) time.Duration {
+	if d := time.Duration(float64(t) * float64(size) / 1e6); d > defaultTimeout {
+		return d
+	}
+	return defaultTimeout
+}
+
+// writeFlash writes image at offset, compressed, calling progress after each
+// block with the uncompressed bytes written so far.
+func (l *loader) writeFlash(offset uint32, image []byte, progress func(done, total int)) error {
+	var comp bytes.Buffer
+	zw, _ := zlib.NewWriterLevel(&comp, zlib.BestCompression)
+	zw.Write(image)
+	zw.Close()
+	blocks := (comp.Len() + romWriteSize - 1) / romWriteSize
+	eraseBlocks := (len(image) + romWriteSize - 1) / romWriteSize
+
+	var begin []byte
+	// Erase size, block count, block size, offset, and the "not encrypted"
+	// word that the ESP32-C3 ROM expects.
+	for _, v := range []uint32{uint32(eraseBlocks * romWriteSize), uint32(blocks), romWriteSize, offset, 0} {
+		begin = binary.LittleEndian.AppendUint32(begin, v)
+	}
+	if _, _, err := l.command(cmdFlashDeflBeg, begin, 0, perMB(eraseTimeoutPerMB, len(image))); err != nil {
+		return err
+	}
+	data := comp.Bytes()
+	for seq := 0; seq < blocks; seq++ {
+		block := data[seq*romWriteSize : min((seq+1)*romWriteSize, len(data))]
+		packet := make([]byte, 16, 16+len(block))
+		binary.LittleEndian.PutUint32(packet[0:], uint32(len(block)))
+		binary.LittleEndian.PutUint32(packet[4:], uint32(seq))
+		packet = append(packet, block...)
+		// Writing a block can mean inflating and flashing far more than its
+		// compressed size.
+		timeout := perMB(writeTimeoutPerMB, len(image)/blocks+1)
+		if _, _, err := l.command(cmdFlashDeflData, packet, checksum(block), timeout); err != nil {
+			return fmt.Errorf("block %d of %d: %w", seq+1, blocks, err)
+		}
+		if progress != nil {
+			progress(min((seq+1)*len(image)/blocks, len(image)), len(image))
+		}
+	}
+	return nil
+}
+
+// checksum is the XOR checksum of a data packet's payload.
+func checksum(data []byte) uint32 {
+	sum := byte(checksumSeed)
+	for _, b := range data {
+		sum ^= b
+	}
+	return uint32(sum)
+}
+
+// readFlash reads size bytes of flash at offset, calling progress after each
+// block. The ROM loader returns romReadSize bytes per command, so this suits
+// small regions such as the partition table better than whole partitions.
+func (l *loader) readFlash(offset, size uint32, progress func(done, total int)) ([]byte, error) {
+	out := make([]byte, 0, size)
+	for uint32(len(out)) < size {
+		n := min(size-uint32(len(out)), romReadSize)
+		data := binary.LittleEndian.AppendUint32(nil, offset+uint32(len(out)))
+		data = binary.LittleEndian.AppendUint32(data, n)
+		_, body, err := l.command(cmdReadFlashSlow, data, 0, defaultTimeout)
+		if err != nil {
+			return nil, fmt.Errorf("read at 0x%x: %w", offset+uint32(len(out)), err)
+		}
+		if len(body) < int(n) {
+			return nil, errors.New("short flash read response")
+		}
+		out = append(out, body[:n]...)
+		if progress != nil {
+			progress(len(out), int(size))
+		}
+	}
+	return out, nil
+}
+
+// flashMD5 has the loader hash size bytes of flash at offset, returning the
+// MD5 as hex.
+func (l *loader) flashMD5(offset, size uint32) (string, error) {
+	var data []byte
+	for _, v := range []uint32{offset, size, 0, 0} {
+		data = binary.LittleEndian.AppendUint32(data, v)
+	}
+	_, body, err := l.command(cmdSPIFlashMD5, data, 0, perMB(md5TimeoutPerMB, int(size)))
+	if err != nil {
+		return "", err
+	}
+	if len(body) < 32 {
+		return "", errors.New("short MD5 response")
+	}
+	return string(body[:32]), nil
+}
+
+// verify compares the flash's MD5 over the image's range with the image's.
+func (l *loader) verify(offset uint32, image []byte) error {
+	got, err := l.flashMD5(offset, uint32(len(image)))
+	if err != nil {
+		return err
+	}
+	want := md5.Sum(image)
+	if got != hex.EncodeToString(want[:]) {
+		return fmt.Errorf("verify failed at 0x%x: flash MD5 %s, image MD5 %x", offset, got, want)
+	}
+	return nil
+}
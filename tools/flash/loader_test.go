@@ -0,0 +1,219 @@
+package main
+
+import (
+	"bytes"
+	"compress/zlib"
+	"crypto/md5"
+	"encoding/binary"
+	"encoding/hex"
+	"io"
+	"os"
+	"testing"
+	"time"
+)
+
+// fakeROM plays the ESP32-C3 ROM loader: it answers commands written to it
+// and keeps the flash contents the loader writes.
+type fakeROM struct {
+	dec    slipDecoder
+	out    bytes.Buffer
+	flash  []byte
+	offset uint32
+	comp   bytes.Buffer
+	baud   uint32
+	failAt int // FLASH_DEFL_DATA sequence number to reject, or -1
+	regs   map[uint32]uint32
+	jedec  uint32 // what a flash RDID puts in SPI W0
+}
+
+func newFakeROM() *fakeROM {
+	return &fakeROM{flash: bytes.Repeat([]byte{0xFF}, 1<<20), failAt: -1, regs: map[uint32]uint32{chipMagicReg: 0x1B31506F}}
+}
+
+func (r *fakeROM) SetReadTimeout(time.Duration) error { return nil }
+
+func (r *fakeROM) Read(p []byte) (int, error) {
+	if r.out.Len() == 0 {
+		return 0, nil // a read timeout
+	}
+	return r.out.Read(p)
+}
+
+func (r *fakeROM) Write(p []byte) (int, error) {
+	for _, pkt := range r.dec.feed(p) {
+		r.handle(pkt)
+	}
+	return len(p), nil
+}
+
+func (r *fakeROM) respond(op byte, value uint32, data []byte, status byte) {
+	resp := []byte{0x01, op, 0, 0}
+	body := append(append([]byte(nil), data...), status, 0, 0, 0)
+	if status != 0 {
+		body[len(data)+1] = 0x07
+	}
+	binary.LittleEndian.PutUint16(resp[2:], uint16(len(body)))
+	resp = binary.LittleEndian.AppendUint32(resp, value)
+	r.out.Write(slipEncode(append(resp, body...)))
+}
+
+// inflate writes the compressed data received so far to flash.
+func (r *fakeROM) inflate() {
+	if r.comp.Len() == 0 {
+		return
+	}
+	zr, err := zlib.NewReader(&r.comp)
+	if err != nil {
+		panic(err)
+	}
+	data, _ := io.ReadAll(zr)
+	copy(r.flash[r.offset:], data)
+	r.comp.Reset()
+}
+
+func (r *fakeROM) handle(pkt []byte) {
+	op := pkt[1]
+	data := pkt[8:]
+	u32 := func(i int) uint32 { return binary.LittleEndian.Uint32(data[4*i:]) }
+	switch op {
+	case cmdSync:
+		for i := 0; i < 8; i++ {
+			r.respond(op, 0, nil, 0)
+		}
+	case cmdReadReg:
+		r.respond(op, r.regs[u32(0)], nil, 0)
+	case cmdWriteReg:
+		r.regs[u32(0)] = u32(1)
+		if u32(0) == spiCmdReg && u32(1)&spiCmdUsr != 0 {
+			// The user command completes at once.
+			r.regs[spiW0Reg], r.regs[spiCmdReg] = r.jedec, 0
+		}
+		r.respond(op, 0, nil, 0)
+	case cmdChangeBaud:
+		r.baud = u32(0)
+		r.respond(op, 0, nil, 0)
+	case cmdSPIAttach, cmdSPISetParams:
+		r.respond(op, 0, nil, 0)
+	case cmdFlashDeflBeg:
+		r.inflate()
+		r.offset = u32(3)
+		r.respond(op, 0, nil, 0)
+	case cmdFlashDeflData:
+		block := data[16:]
+		bad := checksum(block) != binary.LittleEndian.Uint32(pkt[4:]) || int(u32(1)) == r.failAt
+		if !bad {
+			r.comp.Write(block)
+		}
+		r.respond(op, 0, nil, map[bool]byte{false: 0, true: 1}[bad])
+	case cmdReadFlashSlow:
+		r.inflate()
+		block := make([]byte, romReadSize)
+		copy(block, r.flash[u32(0):u32(0)+u32(1)])
+		r.respond(op, 0, block, 0)
+	case cmdSPIFlashMD5:
+		r.inflate()
+		sum := md5.Sum(r.flash[u32(0) : u32(0)+u32(1)])
+		r.respond(op, 0, []byte(hex.EncodeToString(sum[:])), 0)
+	}
+}
+
+// testImage is data that compresses to several ROM blocks.
+func testImage() []byte {
+	img := make([]byte, 40000)
+	x := uint32(1)
+	for i := range img {
+		x = x*1664525 + 1013904223
+		img[i] = byte(x >> 24)
+	}
+	return img
+}
+
+func TestLoader_WriteAndVerify(t *testing.T) {
+	rom := newFakeROM()
+	l := newLoader(rom)
+	if err := l.sync(3); err != nil {
+		t.Fatal(err)
+	}
+	if magic, err := l.readReg(chipMagicReg); err != nil || !esp32C3Magic[magic] {
+		t.Fatalf("magic 0x%x, %v", magic, err)
+	}
+	if err := l.changeBaud(921600); err != nil || rom.baud != 921600 {
+		t.Fatalf("baud %d, %v", rom.baud, err)
+	}
+	img := testImage()
+	var last int
+	if err := l.writeFlash(0x1000, img, func(done, total int) { last = done }); err != nil {
+		t.Fatal(err)
+	}
+	if last != len(img) {
+		t.Errorf("progress ended at %d of %d", last, len(img))
+	}
+	if err := l.verify(0x1000, img); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(rom.flash[0x1000:0x1000+len(img)], img) {
+		t.Error("flash does not hold the image")
+	}
+	// A corrupted image must fail verification.
+	bad := append([]byte(nil), img...)
+	bad[100] ^= 1
+	if err := l.verify(0x1000, bad); err == nil {
+		t.Error("verify passed for different data")
+	}
+}
+
+func TestLoader_ReadFlash(t *testing.T) {
+	rom := newFakeROM()
+	img := testImage()[:200]
+	copy(rom.flash[0x8000:], img)
+	var last int
+	got, err := newLoader(rom).readFlash(0x8000, uint32(len(img)), func(done, total int) { last = done })
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, img) || last != len(img) {
+		t.Errorf("read %d bytes (progress %d), want the %d written", len(got), last, len(img))
+	}
+}
+
+func TestLoader_ReportsROMError(t *testing.T) {
+	rom := newFakeROM()
+	rom.failAt = 1
+	l := newLoader(rom)
+	err := l.writeFlash(0, testImage(), nil)
+	if err == nil || err.Error() != "block 2 of 40: command 0x11 failed: invalid CRC in message" {
+		t.Errorf("got %v", err)
+	}
+}
+
+func TestParseImageArg(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name string, data []byte) string {
+		path := dir + "/" + name
+		if err := os.WriteFile(path, data, 0644); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+	merged := make([]byte, partitionTableOffset+16)
+	merged[0] = imageMagic
+	copy(merged[partitionTableOffset:], partitionMagic)
+	app := []byte{imageMagic, 0x05}
+	tests := []struct {
+		arg    string
+		offset uint32
+	}{
+		{write("full.bin", merged), 0},
+		{write("firmware.bin", app), appOffset},
+		{write("app.bin", app) + "@0x650000", 0x650000},
+	}
+	for _, tt := range tests {
+		img, err := parseImageArg(tt.arg)
+		if err != nil || img.offset != tt.offset {
+			t.Errorf("parseImageArg(%q) = 0x%x, %v; want 0x%x", tt.arg, img.offset, err, tt.offset)
+		}
+	}
+	if _, err := parseImageArg(write("notes.txt", []byte("hello"))); err == nil {
+		t.Error("text file without an address: expected error")
+	}
+}
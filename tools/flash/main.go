@@ -0,0 +1,293 @@
+// Command sumi-flash writes SUMI firmware to an ESP32-C3 over its serial
+// bootloader, so flashing needs neither Python nor esptool.
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.bug.st/serial"
+)
+
+// Offsets from partitions.csv. An app image goes to the first OTA slot, and
+// otadata is erased so the bootloader starts that slot, as PlatformIO's
+// boot_app0.bin does.
+const (
+	appOffset     = 0x10000
+	otadataOffset = 0xE000
+	otadataSize   = 0x2000
+	// partitionTableOffset is where a merged image carries its partition
+	// table, which starts with partitionMagic.
+	partitionTableOffset = 0x8000
+)
+
+// imageMagic starts every ESP32 app and bootloader image.
+const imageMagic = 0xE9
+
+var partitionMagic = []byte{0xAA, 0x50}
+
+// flashImage is one file and where it goes.
+type flashImage struct {
+	path   string
+	offset uint32
+	data   []byte
+}
+
+// parseImageArg loads "file" or "file@address". Without an address, a merged
+// image (bootloader, partition table and app, like the release
+// sumi-vX.Y.Z-full.bin) goes to 0 and an app image to appOffset.
+func parseImageArg(arg string) (flashImage, error) {
+	path, addr, explicit := strings.Cut(arg, "@")
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return flashImage{}, err
+	}
+	if len(data) == 0 {
+		return flashImage{}, fmt.Errorf("%s is empty", path)
+	}
+	img := flashImage{path: path, data: data}
+	switch {
+	case explicit:
+		off, err := strconv.ParseUint(addr, 0, 32)
+		if err != nil {
+			return flashImage{}, fmt.Errorf("bad address %q in %s", addr, arg)
+		}
+		img.offset = uint32(off)
+	case isFlashDump(data):
+		img.offset = 0
+	case data[0] == imageMagic:
+		img.offset = appOffset
+	default:
+		return flashImage{}, fmt.Errorf("%s is not an ESP32 image; give its address as %s@0x...", path, path)
+	}
+	return img, nil
+}
+
+// isFlashDump reports whether data starts like a whole flash: a bootloader
+// at 0 and a partition table at partitionTableOffset.
+func isFlashDump(data []byte) bool {
+	return len(data) > partitionTableOffset+2 && data[0] == imageMagic &&
+		bytes.Equal(data[partitionTableOffset:partitionTableOffset+2], partitionMagic)
+}
+
+// parseFlashSize parses sizes such as "4MB" or "16MB".
+func parseFlashSize(s string) (uint32, error) {
+	n, err := strconv.ParseUint(strings.TrimSuffix(strings.ToUpper(s), "MB"), 10, 32)
+	if err != nil || n == 0 || n > 128 || n&(n-1) != 0 {
+		return 0, fmt.Errorf("bad flash size %q (want 2MB, 4MB, 8MB, 16MB, ...)", s)
+	}
+	return uint32(n) << 20, nil
+}
+
+func main() {
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "merge":
+			os.Exit(runMerge(os.Args[2:]))
+		case "partitions":
+			os.Exit(runPartitions(os.Args[2:]))
+		case "nvs":
+			os.Exit(runNVS(os.Args[2:]))
+		case "backup":
+			os.Exit(runBackup(os.Args[2:]))
+		case "restore":
+			os.Exit(runRestore(os.Args[2:]))
+		case "install":
+			os.Exit(runInstall(os.Args[2:]))
+		case "info":
+			os.Exit(runInfo(os.Args[2:]))
+		case "chip":
+			os.Exit(runChip(os.Args[2:]))
+		case "slot":
+			os.Exit(runSlot(os.Args[2:]))
+		case "erase":
+			os.Exit(runErase(os.Args[2:]))
+		case "diff":
+			os.Exit(runDiff(os.Args[2:]))
+		}
+	}
+
+	portFlag := flag.String("port", "", "serial port (auto-detected when a single ESP32 board is plugged in)")
+	baudFlag := flag.Int("baud", 115200, "baud rate to reach the bootloader at")
+	flashBaudFlag := flag.Int("flash-baud", 921600, "baud rate to switch to for writing (0 stays at -baud)")
+	flashSizeFlag := flag.String("flash-size", "16MB", "size of the board's flash chip")
+	verifyFlag := flag.Bool("verify", true, "check each written partition against the image's MD5")
+	resetFlag := flag.Bool("reset", true, "reset the board into the new firmware when done")
+	flag.Usage = func() {
+		out := flag.CommandLine.Output()
+		fmt.Fprintf(out, "Usage: %s [flags] <image>[@address] ...\n", os.Args[0])
+		fmt.Fprintf(out, "       %s merge -o <out.bin> (-build <dir> | <image>@<address> ...)\n", os.Args[0])
+		fmt.Fprintf(out, "       %s partitions [-resize label=size] [-o out] [table.bin|partitions.csv]\n", os.Args[0])
+		fmt.Fprintf(out, "       %s nvs [-set ns/key=value] [-delete ns/key] [-o nvs.bin] [-write] [nvs.bin]\n", os.Args[0])
+		fmt.Fprintf(out, "       %s backup <backup.bin[.gz]>\n", os.Args[0])
+		fmt.Fprintf(out, "       %s restore <backup.bin[.gz]>\n", os.Args[0])
+		fmt.Fprintf(out, "       %s install [-channel stable|nightly]\n", os.Args[0])
+		fmt.Fprintf(out, "       %s info [-json]\n", os.Args[0])
+		fmt.Fprintf(out, "       %s chip [-json]\n", os.Args[0])
+		fmt.Fprintf(out, "       %s slot [-boot label | -rollback | -mark valid|invalid]\n", os.Args[0])
+		fmt.Fprintf(out, "       %s erase (-region nvs|storage|all | -factory-reset [-storage-image littlefs.bin])\n", os.Args[0])
+		fmt.Fprintf(out, "       %s diff [-top n] <old> <new>\n\n", os.Args[0])
+		fmt.Fprintf(out, "A merged image such as sumi-v0.6.4-full.bin is written at 0, an app\n")
+		fmt.Fprintf(out, "image such as .pio/build/default/firmware.bin at 0x%x.\n\n", appOffset)
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if flag.NArg() == 0 {
+		flag.Usage()
+		os.Exit(2)
+	}
+	flashSize, err := parseFlashSize(*flashSizeFlag)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "-flash-size: %v\n", err)
+		os.Exit(2)
+	}
+	var images []flashImage
+	for _, arg := range flag.Args() {
+		img, err := parseImageArg(arg)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%v\n", err)
+			os.Exit(1)
+		}
+		if uint64(img.offset)+uint64(len(img.data)) > uint64(flashSize) {
+			fmt.Fprintf(os.Stderr, "%s does not fit in %s of flash at 0x%x\n", img.path, *flashSizeFlag, img.offset)
+			os.Exit(1)
+		}
+		if img.offset == appOffset {
+			images = append(images, flashImage{path: "otadata", offset: otadataOffset, data: bytes.Repeat([]byte{0xFF}, otadataSize)})
+		}
+		images = append(images, img)
+	}
+
+	name := *portFlag
+	if name == "" {
+		if name, err = detectPort(); err != nil {
+			fmt.Fprintf(os.Stderr, "Auto-detect failed: %v\n", err)
+			os.Exit(1)
+		}
+		fmt.Fprintf(os.Stderr, "Auto-detected port: %s\n", name)
+	}
+	if err := flash(name, *baudFlag, *flashBaudFlag, flashSize, images, *verifyFlag, *resetFlag); err != nil {
+		fmt.Fprintf(os.Stderr, "\nFlashing failed: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+// session is an open connection to the ROM bootloader.
+type session struct {
+	port     serial.Port
+	loader   *loader
+	resetSeq []lineStep
+}
+
+// connect enters the bootloader on the named port, switches to flashBaud and
+// attaches the flash chip.
+func connect(name string, baud, flashBaud int, flashSize uint32) (*session, error) {
+	port, err := serial.Open(name, &serial.Mode{BaudRate: baud})
+	if err != nil {
+		return nil, err
+	}
+	resetSeq, bootSeq := resetSequences(name)
+	l := newLoader(port)
+	fail := func(err error) (*session, error) {
+		port.Close()
+		return nil, err
+	}
+
+	fmt.Fprintf(os.Stderr, "Connecting")
+	for attempt := 0; ; attempt++ {
+		fmt.Fprintf(os.Stderr, ".")
+		if err := runLineSequence(port, bootSeq); err != nil {
+			return fail(err)
+		}
+		port.ResetInputBuffer()
+		if err = l.sync(5); err == nil {
+			break
+		}
+		if attempt == 3 {
+			return fail(fmt.Errorf("no answer from the bootloader (%v); hold BOOT while plugging the board in and try again", err))
+		}
+	}
+	fmt.Fprintf(os.Stderr, "\n")
+	magic, err := l.readReg(chipMagicReg)
+	if err != nil {
+		return fail(err)
+	}
+	if esp32C3Magic[magic] {
+		fmt.Fprintf(os.Stderr, "Chip is an ESP32-C3\n")
+	} else {
+		fmt.Fprintf(os.Stderr, "Warning: chip magic 0x%08x is not a known ESP32-C3; continuing\n", magic)
+	}
+	if flashBaud > 0 && flashBaud != baud {
+		if err := l.changeBaud(flashBaud); err != nil {
+			return fail(err)
+		}
+		time.Sleep(50 * time.Millisecond)
+		if err := port.SetMode(&serial.Mode{BaudRate: flashBaud}); err != nil {
+			return fail(err)
+		}
+		port.ResetInputBuffer()
+		fmt.Fprintf(os.Stderr, "Changed baud rate to %d\n", flashBaud)
+	}
+	if err := l.spiAttach(); err != nil {
+		return fail(err)
+	}
+	if err := l.setFlashSize(flashSize); err != nil {
+		return fail(err)
+	}
+	return &session{port: port, loader: l, resetSeq: resetSeq}, nil
+}
+
+// finish resets the board into its firmware if reset is set, or leaves it in
+// the bootloader.
+func (s *session) finish(reset bool) {
+	if !reset {
+		fmt.Fprintf(os.Stderr, "Leaving the board in the bootloader.\n")
+		return
+	}
+	fmt.Fprintf(os.Stderr, "Resetting into the firmware.\n")
+	// The USB Serial/JTAG port can vanish as the chip resets, so a failure
+	// here is not a failed operation.
+	if err := runLineSequence(s.port, s.resetSeq); err != nil {
+		fmt.Fprintf(os.Stderr, "Reset failed (%v); press the board's reset button.\n", err)
+	}
+}
+
+// flash enters the bootloader on the named port and writes the images.
+func flash(name string, baud, flashBaud int, flashSize uint32, images []flashImage, verify, reset bool) error {
+	s, err := connect(name, baud, flashBaud, flashSize)
+	if err != nil {
+		return err
+	}
+	defer s.port.Close()
+	l := s.loader
+	for _, img := range images {
+		start := time.Now()
+		err := l.writeFlash(img.offset, img.data, func(done, total int) {
+			fmt.Fprintf(os.Stderr, "\rWriting %s at 0x%08x... %3d%%", img.path, img.offset, done*100/total)
+		})
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(os.Stderr, "\rWrote %d bytes of %s at 0x%08x in %.1fs\n", len(img.data), img.path, img.offset, time.Since(start).Seconds())
+	}
+	if verify {
+		// Name regions by the table being written, else by the board's.
+		parts := imagePartitions(images)
+		if parts == nil {
+			if table, err := l.readFlash(partitionTableOffset, partitionTableSize, nil); err == nil {
+				parts, _ = parsePartitionTable(table)
+			}
+		}
+		fmt.Fprintf(os.Stderr, "Verifying:\n")
+		if err := verifyImages(l, images, parts, os.Stderr); err != nil {
+			return err
+		}
+	}
+	s.finish(reset)
+	return nil
+}
@@ -0,0 +1,178 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// buildParts returns the images of a PlatformIO build directory at their
+// offsets: bootloader, partition table, app, and the LittleFS image when one
+// was built. The app and filesystem offsets come from the partition table.
+func buildParts(dir string) ([]flashImage, error) {
+	read := func(name string) ([]byte, error) { return os.ReadFile(filepath.Join(dir, name)) }
+	table, err := read("partitions.bin")
+	if err != nil {
+		return nil, err
+	}
+	parts, err := parsePartitionTable(table)
+	if err != nil {
+		return nil, fmt.Errorf("partitions.bin: %v", err)
+	}
+	images := []flashImage{{path: "bootloader.bin", offset: 0}, {path: "partitions.bin", offset: partitionTableOffset, data: table}}
+	app, fs := -1, -1
+	for i, p := range parts {
+		if p.ptype == partitionTypeApp && app < 0 {
+			app = i
+		}
+		if p.ptype == partitionTypeData && p.sub == partitionSubSPIFFS && fs < 0 {
+			fs = i
+		}
+	}
+	if app < 0 {
+		return nil, errors.New("partitions.bin has no app partition")
+	}
+	images = append(images, flashImage{path: "firmware.bin", offset: parts[app].offset})
+	if _, err := os.Stat(filepath.Join(dir, "littlefs.bin")); err == nil {
+		if fs < 0 {
+			return nil, errors.New("littlefs.bin was built but partitions.bin has no filesystem partition")
+		}
+		images = append(images, flashImage{path: "littlefs.bin", offset: parts[fs].offset})
+	}
+	for i := range images {
+		if images[i].data == nil {
+			if images[i].data, err = read(images[i].path); err != nil {
+				return nil, err
+			}
+		}
+		images[i].path = filepath.Join(dir, images[i].path)
+	}
+	// A part larger than its partition would overwrite the next one.
+	for _, img := range images {
+		for _, p := range parts {
+			if img.offset == p.offset && uint32(len(img.data)) > p.size {
+				return nil, fmt.Errorf("%s is %d bytes, larger than partition %s (%d bytes)", img.path, len(img.data), p.label, p.size)
+			}
+		}
+	}
+	return images, nil
+}
+
+// mergeImages lays the images out in one flash image starting at 0, with
+// erased flash (0xFF) between them. Overlapping images are an error.
+func mergeImages(images []flashImage) ([]byte, error) {
+	sorted := append([]flashImage(nil), images...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].offset < sorted[j].offset })
+	var out []byte
+	for i, img := range sorted {
+		if i > 0 {
+			prev := sorted[i-1]
+			if img.offset < prev.offset+uint32(len(prev.data)) {
+				return nil, fmt.Errorf("%s at 0x%x overlaps %s at 0x%x", img.path, img.offset, prev.path, prev.offset)
+			}
+		}
+		out = append(out, bytes.Repeat([]byte{0xFF}, int(img.offset)-len(out))...)
+		out = append(out, img.data...)
+	}
+	return out, nil
+}
+
+// webManifest is an ESP Web Tools manifest for one merged image.
+type webManifest struct {
+	Name    string          `json:"name"`
+	Version string          `json:"version"`
+	Builds  []manifestBuild `json:"builds"`
+}
+
+type manifestBuild struct {
+	ChipFamily string         `json:"chipFamily"`
+	Parts      []manifestPart `json:"parts"`
+}
+
+type manifestPart struct {
+	Path   string `json:"path"`
+	Offset uint32 `json:"offset"`
+	SHA256 string `json:"sha256"`
+}
+
+// runMerge implements "merge -o <out.bin> (-build <dir> | <image>@<address> ...)".
+func runMerge(args []string) int {
+	fs := flag.NewFlagSet("merge", flag.ContinueOnError)
+	outFlag := fs.String("o", "", "merged image to write")
+	buildFlag := fs.String("build", "", "PlatformIO build directory to take bootloader.bin, partitions.bin, firmware.bin and littlefs.bin from")
+	manifestFlag := fs.String("manifest", "", "also write an ESP Web Tools manifest for the merged image to this file")
+	versionFlag := fs.String("version", "", "firmware version for the manifest")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: %s merge -o <out.bin> [-manifest file] (-build <dir> | <image>@<address> ...)\n", os.Args[0])
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if *outFlag == "" || (*buildFlag == "") == (fs.NArg() == 0) {
+		fs.Usage()
+		return 2
+	}
+	var images []flashImage
+	if *buildFlag != "" {
+		var err error
+		if images, err = buildParts(*buildFlag); err != nil {
+			fmt.Fprintf(os.Stderr, "%v\n", err)
+			return 1
+		}
+	}
+	for _, arg := range fs.Args() {
+		if !strings.Contains(arg, "@") {
+			fmt.Fprintf(os.Stderr, "%s: give every image an address, as %s@0x...\n", arg, arg)
+			return 2
+		}
+		img, err := parseImageArg(arg)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%v\n", err)
+			return 1
+		}
+		images = append(images, img)
+	}
+	merged, err := mergeImages(images)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		return 1
+	}
+	if err := os.WriteFile(*outFlag, merged, 0644); err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		return 1
+	}
+	// The sidecar has sha256sum's format, as scripts/merge_firmware.py
+	// writes it, so "sha256sum -c" checks a download.
+	sum := sha256.Sum256(merged)
+	digest := hex.EncodeToString(sum[:])
+	if err := os.WriteFile(*outFlag+".sha256", []byte(digest+"  "+filepath.Base(*outFlag)+"\n"), 0644); err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		return 1
+	}
+	for _, img := range images {
+		fmt.Fprintf(os.Stderr, "0x%08x %8d %s\n", img.offset, len(img.data), img.path)
+	}
+	fmt.Fprintf(os.Stderr, "Wrote %s (%d bytes, sha256 %s)\n", *outFlag, len(merged), digest)
+	if *manifestFlag != "" {
+		m := webManifest{Name: "SUMI", Version: *versionFlag, Builds: []manifestBuild{{
+			ChipFamily: "ESP32-C3",
+			Parts:      []manifestPart{{Path: filepath.Base(*outFlag), Offset: 0, SHA256: digest}},
+		}}}
+		b, _ := json.MarshalIndent(m, "", "  ")
+		if err := os.WriteFile(*manifestFlag, append(b, '\n'), 0644); err != nil {
+			fmt.Fprintf(os.Stderr, "%v\n", err)
+			return 1
+		}
+		fmt.Fprintf(os.Stderr, "Wrote %s\n", *manifestFlag)
+	}
+	return 0
+}
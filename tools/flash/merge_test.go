@@ -0,0 +1,80 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// sumiTable is partitions.csv.
+func sumiTable() []byte {
+	return encodePartitionTable([]partition{
+		{label: "nvs", ptype: partitionTypeData, sub: 0x02, offset: 0x9000, size: 0x5000},
+		{label: "otadata", ptype: partitionTypeData, sub: 0x00, offset: 0xE000, size: 0x2000},
+		{label: "app0", ptype: partitionTypeApp, sub: 0x10, offset: 0x10000, size: 0x640000},
+		{label: "app1", ptype: partitionTypeApp, sub: 0x11, offset: 0x650000, size: 0x640000},
+		{label: "spiffs", ptype: partitionTypeData, sub: partitionSubSPIFFS, offset: 0xC90000, size: 0x360000},
+	})
+}
+
+func TestBuildParts(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string][]byte{
+		"bootloader.bin": {imageMagic, 1, 2},
+		"partitions.bin": sumiTable(),
+		"firmware.bin":   {imageMagic, 3},
+		"littlefs.bin":   {4, 5},
+	}
+	for name, data := range files {
+		os.WriteFile(filepath.Join(dir, name), data, 0644)
+	}
+	images, err := buildParts(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, img := range images {
+		got = append(got, filepath.Base(img.path))
+	}
+	want := []string{"bootloader.bin", "partitions.bin", "firmware.bin", "littlefs.bin"}
+	if len(got) != len(want) {
+		t.Fatalf("parts %v", got)
+	}
+	offsets := []uint32{0, partitionTableOffset, 0x10000, 0xC90000}
+	for i := range want {
+		if got[i] != want[i] || images[i].offset != offsets[i] {
+			t.Errorf("part %d: %s at 0x%x, want %s at 0x%x", i, got[i], images[i].offset, want[i], offsets[i])
+		}
+	}
+
+	merged, err := mergeImages(images)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(merged) != 0xC90002 || merged[3] != 0xFF || merged[0x10001] != 3 || merged[0xC90001] != 5 {
+		t.Errorf("merged image laid out wrong (%d bytes)", len(merged))
+	}
+	// The merged image is recognized as one by the flasher.
+	if path := filepath.Join(dir, "full.bin"); os.WriteFile(path, merged, 0644) == nil {
+		if img, err := parseImageArg(path); err != nil || img.offset != 0 {
+			t.Errorf("merged image goes to 0x%x, %v", img.offset, err)
+		}
+	}
+}
+
+func TestBuildParts_AppTooLarge(t *testing.T) {
+	dir := t.TempDir()
+	os.WriteFile(filepath.Join(dir, "bootloader.bin"), []byte{imageMagic}, 0644)
+	os.WriteFile(filepath.Join(dir, "partitions.bin"), encodePartitionTable([]partition{{label: "app0", offset: 0x10000, size: 4}}), 0644)
+	os.WriteFile(filepath.Join(dir, "firmware.bin"), make([]byte, 5), 0644)
+	if _, err := buildParts(dir); err == nil {
+		t.Error("oversized app: expected error")
+	}
+}
+
+func TestMergeImages_Overlap(t *testing.T) {
+	_, err := mergeImages([]flashImage{{path: "a", offset: 0, data: make([]byte, 0x10)}, {path: "b", offset: 0x8, data: []byte{1}}})
+	if err == nil {
+		t.Error("overlapping images: expected error")
+	}
+}
@@ -0,0 +1,659 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"flag"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+)
+
+// NVS storage layout (ESP-IDF, format version 2): 4K pages, each a 32-byte
+// header, a bitmap of entry states and 126 32-byte entries. An item takes one
+// entry, or for strings and blob chunks one entry plus the entries its data
+// fills.
+const (
+	nvsPageSize      = 0x1000
+	nvsEntrySize     = 32
+	nvsEntriesOnPage = 126
+	nvsEntriesStart  = 64 // header and bitmap
+	nvsVersion       = 0xFE
+	nvsChunkAny      = 0xFF
+	nvsKeySize       = 16
+	// nvsMaxData is the most data one string or blob chunk can hold, the
+	// entries of a page after the item's own.
+	nvsMaxData = (nvsEntriesOnPage - 1) * nvsEntrySize
+)
+
+// Page states.
+const (
+	nvsPageEmpty  = 0xFFFFFFFF
+	nvsPageActive = 0xFFFFFFFE
+	nvsPageFull   = 0xFFFFFFFC
+)
+
+// Entry states in the page bitmap, two bits per entry.
+const (
+	nvsEntryEmpty   = 0x3
+	nvsEntryWritten = 0x2
+)
+
+// Item types. The low nibble of an integer type is its size in bytes and
+// 0x10 marks it signed.
+const (
+	nvsU8       = 0x01
+	nvsI8       = 0x11
+	nvsU16      = 0x02
+	nvsI16      = 0x12
+	nvsU32      = 0x04
+	nvsI32      = 0x14
+	nvsU64      = 0x08
+	nvsI64      = 0x18
+	nvsStr      = 0x21
+	nvsBlobV1   = 0x41 // single-page blob written by older IDF versions
+	nvsBlobData = 0x42
+	nvsBlobIdx  = 0x48
+)
+
+var nvsTypeNames = map[byte]string{
+	nvsU8: "u8", nvsI8: "i8", nvsU16: "u16", nvsI16: "i16", nvsU32: "u32", nvsI32: "i32",
+	nvsU64: "u64", nvsI64: "i64", nvsStr: "str", nvsBlobData: "blob",
+}
+
+// nvsItem is one key's value. data holds an integer's little-endian bytes, a
+// string without its terminating NUL, or a blob's contents.
+type nvsItem struct {
+	ns, key string
+	typ     byte // nvsBlobData for blobs of either version
+	data    []byte
+}
+
+// nvsCRC is the CRC-32 NVS uses, esp_rom_crc32_le seeded with 0xFFFFFFFF.
+func nvsCRC(parts ...[]byte) uint32 {
+	crc := uint32(0xFFFFFFFF)
+	for _, p := range parts {
+		crc = crc32.Update(crc, crc32.IEEETable, p)
+	}
+	return crc
+}
+
+// entryCRC covers an entry except its CRC field.
+func entryCRC(e []byte) uint32 {
+	return nvsCRC(e[0:4], e[8:32])
+}
+
+func entryState(page []byte, i int) byte {
+	return page[nvsEntrySize+i/4] >> (2 * (i % 4)) & 0x3
+}
+
+func entryKey(e []byte) string {
+	key, _, _ := bytes.Cut(e[8:8+nvsKeySize], []byte{0})
+	return string(key)
+}
+
+// parseNVS decodes the items of an NVS partition image. Entries whose CRC
+// does not match are skipped and reported through warn.
+func parseNVS(image []byte, warn func(string)) ([]nvsItem, error) {
+	if len(image) < nvsPageSize || len(image)%nvsPageSize != 0 {
+		return nil, fmt.Errorf("NVS image is %d bytes, not a whole number of %d-byte pages", len(image), nvsPageSize)
+	}
+	type rawItem struct {
+		ns    byte
+		typ   byte
+		chunk byte
+		key   string
+		data  []byte // the item's 8-byte data field
+		extra []byte // the entries after it, for strings and blob chunks
+	}
+	var raw []rawItem
+	namespaces := map[byte]string{}
+	for off := 0; off < len(image); off += nvsPageSize {
+		page := image[off : off+nvsPageSize]
+		state := binary.LittleEndian.Uint32(page)
+		if state != nvsPageActive && state != nvsPageFull {
+			continue
+		}
+		if binary.LittleEndian.Uint32(page[28:]) != nvsCRC(page[4:28]) {
+			warn(fmt.Sprintf("page at 0x%x: header CRC mismatch", off))
+		}
+		for i := 0; i < nvsEntriesOnPage; {
+			e := page[nvsEntriesStart+i*nvsEntrySize:][:nvsEntrySize]
+			if entryState(page, i) != nvsEntryWritten {
+				i++
+				continue
+			}
+			span := max(int(e[2]), 1)
+			if i+span > nvsEntriesOnPage {
+				warn(fmt.Sprintf("page at 0x%x entry %d: span %d runs past the page", off, i, span))
+				break
+			}
+			if binary.LittleEndian.Uint32(e[4:]) != entryCRC(e) {
+				warn(fmt.Sprintf("page at 0x%x entry %d (%q): CRC mismatch, skipped", off, i, entryKey(e)))
+				i += span
+				continue
+			}
+			item := rawItem{ns: e[0], typ: e[1], chunk: e[3], key: entryKey(e), data: e[24:32]}
+			if span > 1 {
+				item.extra = page[nvsEntriesStart+(i+1)*nvsEntrySize:][:(span-1)*nvsEntrySize]
+			}
+			if item.ns == 0 {
+				namespaces[item.data[0]] = item.key
+			} else {
+				raw = append(raw, item)
+			}
+			i += span
+		}
+	}
+
+	var items []nvsItem
+	chunks := map[string][]byte{} // ns, key and chunk index of each blob chunk
+	chunkID := func(ns byte, key string, chunk byte) string { return fmt.Sprintf("%d/%s/%d", ns, key, chunk) }
+	// variableData returns a string's or blob chunk's data, checking its CRC.
+	variableData := func(r rawItem) ([]byte, bool) {
+		size := int(binary.LittleEndian.Uint16(r.data))
+		if size > len(r.extra) || nvsCRC(r.extra[:size]) != binary.LittleEndian.Uint32(r.data[4:]) {
+			warn(fmt.Sprintf("%s/%s: data CRC mismatch, skipped", namespaces[r.ns], r.key))
+			return nil, false
+		}
+		return r.extra[:size], true
+	}
+	for _, r := range raw {
+		if r.typ == nvsBlobData {
+			if data, ok := variableData(r); ok {
+				chunks[chunkID(r.ns, r.key, r.chunk)] = data
+			}
+		}
+	}
+	for _, r := range raw {
+		ns, ok := namespaces[r.ns]
+		if !ok {
+			warn(fmt.Sprintf("%q is in unknown namespace %d, skipped", r.key, r.ns))
+			continue
+		}
+		item := nvsItem{ns: ns, key: r.key, typ: r.typ}
+		switch r.typ {
+		case nvsU8, nvsI8, nvsU16, nvsI16, nvsU32, nvsI32, nvsU64, nvsI64:
+			item.data = append([]byte(nil), r.data[:r.typ&0x0F]...)
+		case nvsStr:
+			data, ok := variableData(r)
+			if !ok {
+				continue
+			}
+			item.data = append([]byte(nil), bytes.TrimSuffix(data, []byte{0})...)
+		case nvsBlobV1:
+			data, ok := variableData(r)
+			if !ok {
+				continue
+			}
+			item.typ, item.data = nvsBlobData, append([]byte(nil), data...)
+		case nvsBlobIdx:
+			size := int(binary.LittleEndian.Uint32(r.data))
+			count, start := int(r.data[4]), int(r.data[5])
+			item.typ, item.data = nvsBlobData, []byte{}
+			for c := start; c < start+count; c++ {
+				chunk, ok := chunks[chunkID(r.ns, r.key, byte(c))]
+				if !ok {
+					warn(fmt.Sprintf("%s/%s: blob chunk %d missing", ns, r.key, c))
+					break
+				}
+				item.data = append(item.data, chunk...)
+			}
+			if len(item.data) != size {
+				warn(fmt.Sprintf("%s/%s: blob is %d bytes, index says %d; skipped", ns, r.key, len(item.data), size))
+				continue
+			}
+		case nvsBlobData:
+			continue // assembled through its index
+		default:
+			warn(fmt.Sprintf("%s/%s: unknown type 0x%02x, skipped", ns, r.key, r.typ))
+			continue
+		}
+		items = append(items, item)
+	}
+	sort.SliceStable(items, func(i, j int) bool {
+		if items[i].ns != items[j].ns {
+			return items[i].ns < items[j].ns
+		}
+		return items[i].key < items[j].key
+	})
+	return items, nil
+}
+
+// nvsPageWriter lays out entries page by page.
+type nvsPageWriter struct {
+	image []byte
+	page  int // current page
+	next  int // next free entry on it
+}
+
+// free is the number of entries left on the current page.
+func (w *nvsPageWriter) free() int {
+	return nvsEntriesOnPage - w.next
+}
+
+// nextPage moves to a fresh page. The last page is always left empty, as
+// NVS needs one free page to garbage-collect into.
+func (w *nvsPageWriter) nextPage() error {
+	if (w.page+2)*nvsPageSize >= len(w.image) {
+		return errors.New("settings do not fit in the NVS partition")
+	}
+	w.page++
+	w.next = 0
+	return nil
+}
+
+// add writes an item entry and the data entries after it, all on one page.
+func (w *nvsPageWriter) add(ns, typ, chunk byte, key string, field [8]byte, data []byte) error {
+	span := 1 + (len(data)+nvsEntrySize-1)/nvsEntrySize
+	if span > w.free() {
+		if err := w.nextPage(); err != nil {
+			return err
+		}
+	}
+	page := w.image[w.page*nvsPageSize:][:nvsPageSize]
+	e := page[nvsEntriesStart+w.next*nvsEntrySize:][:nvsEntrySize]
+	e[0], e[1], e[2], e[3] = ns, typ, byte(span), chunk
+	for i := 8; i < 8+nvsKeySize; i++ {
+		e[i] = 0
+	}
+	copy(e[8:8+nvsKeySize-1], key)
+	copy(e[24:], field[:])
+	binary.LittleEndian.PutUint32(e[4:], entryCRC(e))
+	copy(page[nvsEntriesStart+(w.next+1)*nvsEntrySize:], data)
+	for i := w.next; i < w.next+span; i++ {
+		page[nvsEntrySize+i/4] &^= (nvsEntryEmpty &^ nvsEntryWritten) << (2 * (i % 4))
+	}
+	w.next += span
+	return nil
+}
+
+// variableField is the data field of a string or blob chunk.
+func variableField(data []byte) [8]byte {
+	var f [8]byte
+	binary.LittleEndian.PutUint16(f[0:], uint16(len(data)))
+	f[2], f[3] = 0xFF, 0xFF
+	binary.LittleEndian.PutUint32(f[4:], nvsCRC(data))
+	return f
+}
+
+// encodeNVS builds a freshly written NVS partition image of size bytes
+// holding items, as ESP-IDF's nvs_partition_gen.py would.
+func encodeNVS(items []nvsItem, size int) ([]byte, error) {
+	if size < 2*nvsPageSize || size%nvsPageSize != 0 {
+		return nil, fmt.Errorf("NVS partition of %d bytes is too small", size)
+	}
+	w := &nvsPageWriter{image: bytes.Repeat([]byte{0xFF}, size)}
+	nsIndex := map[string]byte{}
+	for _, item := range items {
+		if len(item.key) == 0 || len(item.key) >= nvsKeySize || len(item.ns) >= nvsKeySize {
+			return nil, fmt.Errorf("%s/%s: namespace and key must be 1-15 characters", item.ns, item.key)
+		}
+		idx, ok := nsIndex[item.ns]
+		if !ok {
+			if len(nsIndex) == 254 {
+				return nil, errors.New("too many namespaces")
+			}
+			idx = byte(len(nsIndex) + 1)
+			nsIndex[item.ns] = idx
+			field := [8]byte{idx, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF}
+			if err := w.add(0, nvsU8, nvsChunkAny, item.ns, field, nil); err != nil {
+				return nil, err
+			}
+		}
+		var err error
+		switch item.typ {
+		case nvsStr:
+			data := append(append([]byte(nil), item.data...), 0)
+			if len(data) > nvsMaxData {
+				return nil, fmt.Errorf("%s/%s: string of %d bytes is too long", item.ns, item.key, len(item.data))
+			}
+			err = w.add(idx, nvsStr, nvsChunkAny, item.key, variableField(data), data)
+		case nvsBlobData:
+			err = w.addBlob(idx, item.key, item.data)
+		default:
+			field := [8]byte{0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF}
+			copy(field[:], item.data)
+			err = w.add(idx, item.typ, nvsChunkAny, item.key, field, nil)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	for p := 0; p <= w.page; p++ {
+		page := w.image[p*nvsPageSize:][:nvsPageSize]
+		state := uint32(nvsPageFull)
+		if p == w.page {
+			state = nvsPageActive
+		}
+		binary.LittleEndian.PutUint32(page[0:], state)
+		binary.LittleEndian.PutUint32(page[4:], uint32(p))
+		page[8] = nvsVersion
+		binary.LittleEndian.PutUint32(page[28:], nvsCRC(page[4:28]))
+	}
+	return w.image, nil
+}
+
+// addBlob writes a blob as chunks filling the free space of successive
+// pages, followed by its index entry.
+func (w *nvsPageWriter) addBlob(ns byte, key string, data []byte) error {
+	var chunks byte
+	for rest := data; ; {
+		if w.free() < 2 {
+			if err := w.nextPage(); err != nil {
+				return err
+			}
+		}
+		n := min(len(rest), (w.free()-1)*nvsEntrySize)
+		if err := w.add(ns, nvsBlobData, chunks, key, variableField(rest[:n]), rest[:n]); err != nil {
+			return err
+		}
+		chunks++
+		if rest = rest[n:]; len(rest) == 0 {
+			break
+		}
+	}
+	field := [8]byte{4: chunks, 5: 0, 6: 0xFF, 7: 0xFF}
+	binary.LittleEndian.PutUint32(field[0:], uint32(len(data)))
+	return w.add(ns, nvsBlobIdx, nvsChunkAny, key, field, nil)
+}
+
+// formatNVSValue renders an item's value for display and for -set.
+func formatNVSValue(item nvsItem) string {
+	switch item.typ {
+	case nvsStr:
+		return strconv.Quote(string(item.data))
+	case nvsBlobData:
+		return hex.EncodeToString(item.data)
+	}
+	var u uint64
+	for i := len(item.data) - 1; i >= 0; i-- {
+		u = u<<8 | uint64(item.data[i])
+	}
+	if item.typ&0x10 != 0 {
+		shift := 64 - 8*uint(len(item.data))
+		return strconv.FormatInt(int64(u<<shift)>>shift, 10)
+	}
+	return strconv.FormatUint(u, 10)
+}
+
+// parseNVSValue converts text to the data of an item of type typ. Strings may
+// be given bare or quoted; blobs are hex.
+func parseNVSValue(typ byte, text string) ([]byte, error) {
+	switch typ {
+	case nvsStr:
+		if s, err := strconv.Unquote(text); err == nil {
+			text = s
+		}
+		return []byte(text), nil
+	case nvsBlobData:
+		return hex.DecodeString(text)
+	}
+	size := int(typ & 0x0F)
+	var u uint64
+	if typ&0x10 != 0 {
+		v, err := strconv.ParseInt(text, 0, 8*size)
+		if err != nil {
+			return nil, err
+		}
+		u = uint64(v)
+	} else {
+		v, err := strconv.ParseUint(text, 0, 8*size)
+		if err != nil {
+			return nil, err
+		}
+		u = v
+	}
+	return binary.LittleEndian.AppendUint64(nil, u)[:size], nil
+}
+
+// applyNVSSet applies a -set value "namespace/key=value". An existing key
+// keeps its type; a new one names it, as "namespace/key:u8=value".
+func applyNVSSet(items []nvsItem, spec string) ([]nvsItem, error) {
+	name, value, ok := strings.Cut(spec, "=")
+	if !ok {
+		return nil, fmt.Errorf("expected namespace/key=value, got %q", spec)
+	}
+	name, typeName, typed := strings.Cut(name, ":")
+	ns, key, ok := strings.Cut(name, "/")
+	if !ok || ns == "" || key == "" {
+		return nil, fmt.Errorf("expected namespace/key=value, got %q", spec)
+	}
+	i := -1
+	for j, item := range items {
+		if item.ns == ns && item.key == key {
+			i = j
+		}
+	}
+	var typ byte
+	switch {
+	case typed:
+		if typ, ok = lookupName(nvsTypeNames, typeName); !ok || nvsTypeNames[typ] == "" {
+			return nil, fmt.Errorf("unknown type %q (want u8, i8, u16, i16, u32, i32, u64, i64, str or blob)", typeName)
+		}
+	case i >= 0:
+		typ = items[i].typ
+	default:
+		return nil, fmt.Errorf("%s/%s does not exist; give its type, as %s/%s:u8=%s", ns, key, ns, key, value)
+	}
+	data, err := parseNVSValue(typ, value)
+	if err != nil {
+		return nil, fmt.Errorf("%s/%s: bad %s value %q: %v", ns, key, nvsTypeNames[typ], value, err)
+	}
+	item := nvsItem{ns: ns, key: key, typ: typ, data: data}
+	if i >= 0 {
+		items[i] = item
+		return items, nil
+	}
+	return append(items, item), nil
+}
+
+// deleteNVSItem applies a -delete value "namespace/key".
+func deleteNVSItem(items []nvsItem, name string) ([]nvsItem, error) {
+	for i, item := range items {
+		if item.ns+"/"+item.key == name {
+			return append(items[:i], items[i+1:]...), nil
+		}
+	}
+	return nil, fmt.Errorf("%s does not exist", name)
+}
+
+// printNVS lists items one per line: namespace/key, type and value.
+func printNVS(w io.Writer, items []nvsItem) {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	for _, item := range items {
+		fmt.Fprintf(tw, "%s/%s\t%s\t%s\n", item.ns, item.key, nvsTypeNames[item.typ], formatNVSValue(item))
+	}
+	tw.Flush()
+}
+
+// findNVSPartition returns the partition NVS keeps settings in.
+func findNVSPartition(parts []partition) (partition, error) {
+	for _, p := range parts {
+		if p.ptype == partitionTypeData && p.sub == 0x02 && p.label == "nvs" {
+			return p, nil
+		}
+	}
+	return partition{}, errors.New("partition table has no nvs partition")
+}
+
+// runNVS implements "nvs [-set ns/key=value] [-delete ns/key] [-o nvs.bin]
+// [-write] [nvs.bin|dump.bin]".
+func runNVS(args []string) int {
+	fs := flag.NewFlagSet("nvs", flag.ContinueOnError)
+	portFlag := fs.String("port", "", "serial port to read NVS from when no file is given (auto-detected by default)")
+	baudFlag := fs.Int("baud", 115200, "baud rate to reach the bootloader at")
+	flashSizeFlag := fs.String("flash-size", "16MB", "size of the board's flash chip")
+	outFlag := fs.String("o", "", "write the (edited) NVS partition image to this file")
+	writeFlag := fs.Bool("write", false, "write the edited settings back to the board")
+	var sets, deletes []string
+	fs.Func("set", "set a value, as namespace/key=value; a new key names its type, as namespace/key:u8=value (repeatable)", func(s string) error {
+		sets = append(sets, s)
+		return nil
+	})
+	fs.Func("delete", "remove a key, as namespace/key (repeatable)", func(s string) error {
+		deletes = append(deletes, s)
+		return nil
+	})
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: %s nvs [-set ns/key=value] [-delete ns/key] [-o nvs.bin] [-write] [nvs.bin|dump.bin]\n", os.Args[0])
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if fs.NArg() > 1 || *writeFlag && fs.NArg() == 1 {
+		fs.Usage()
+		return 2
+	}
+	flashSize, err := parseFlashSize(*flashSizeFlag)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "-flash-size: %v\n", err)
+		return 2
+	}
+
+	var image []byte
+	var part partition
+	var s *session
+	if fs.NArg() == 1 {
+		image, err = loadNVSImage(fs.Arg(0))
+	} else {
+		s, part, image, err = readDeviceNVS(*portFlag, *baudFlag, flashSize)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to read NVS: %v\n", err)
+		return 1
+	}
+	if s != nil {
+		defer s.port.Close()
+	}
+	items, err := parseNVS(image, func(msg string) { fmt.Fprintf(os.Stderr, "Warning: %s\n", msg) })
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		return 1
+	}
+	for _, spec := range sets {
+		if items, err = applyNVSSet(items, spec); err != nil {
+			fmt.Fprintf(os.Stderr, "-set: %v\n", err)
+			return 2
+		}
+	}
+	for _, name := range deletes {
+		if items, err = deleteNVSItem(items, name); err != nil {
+			fmt.Fprintf(os.Stderr, "-delete: %v\n", err)
+			return 2
+		}
+	}
+	printNVS(os.Stdout, items)
+
+	edited := len(sets) > 0 || len(deletes) > 0
+	if *outFlag == "" && !*writeFlag {
+		if s != nil {
+			s.finish(true)
+		}
+		if edited {
+			fmt.Fprintf(os.Stderr, "Nothing written; give -o or -write to keep the changes.\n")
+		}
+		return 0
+	}
+	out, err := encodeNVS(items, len(image))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		return 1
+	}
+	if *outFlag != "" {
+		if err := os.WriteFile(*outFlag, out, 0644); err != nil {
+			fmt.Fprintf(os.Stderr, "%v\n", err)
+			return 1
+		}
+		fmt.Fprintf(os.Stderr, "Wrote %s\n", *outFlag)
+	}
+	if *writeFlag {
+		err := s.loader.writeFlash(part.offset, out, func(done, total int) {
+			fmt.Fprintf(os.Stderr, "\rWriting %s at 0x%08x... %3d%%", part.label, part.offset, done*100/total)
+		})
+		if err == nil {
+			err = s.loader.verify(part.offset, out)
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "\nWriting NVS failed: %v\n", err)
+			return 1
+		}
+		fmt.Fprintf(os.Stderr, "\rWrote %d bytes of %s at 0x%08x; hash verified.\n", len(out), part.label, part.offset)
+	}
+	if s != nil {
+		s.finish(true)
+	}
+	return 0
+}
+
+// loadNVSImage reads an NVS partition image, or the NVS partition of a full
+// flash dump.
+func loadNVSImage(path string) ([]byte, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	if len(data) <= partitionTableOffset || data[0] != imageMagic {
+		return data, nil
+	}
+	parts, err := parsePartitionTable(data[partitionTableOffset:min(len(data), partitionTableOffset+partitionTableSize)])
+	if err != nil {
+		return nil, fmt.Errorf("%s looks like a flash dump but %v", path, err)
+	}
+	p, err := findNVSPartition(parts)
+	if err != nil {
+		return nil, err
+	}
+	if uint64(p.offset)+uint64(p.size) > uint64(len(data)) {
+		return nil, fmt.Errorf("%s ends before the nvs partition at 0x%x", path, p.offset)
+	}
+	return data[p.offset : p.offset+p.size], nil
+}
+
+// readDeviceNVS reads the NVS partition off the board on the named port, or
+// the auto-detected one, and leaves the session open for writing it back.
+func readDeviceNVS(name string, baud int, flashSize uint32) (*session, partition, []byte, error) {
+	if name == "" {
+		var err error
+		if name, err = detectPort(); err != nil {
+			return nil, partition{}, nil, fmt.Errorf("auto-detect failed: %v", err)
+		}
+		fmt.Fprintf(os.Stderr, "Auto-detected port: %s\n", name)
+	}
+	s, err := connect(name, baud, 0, flashSize)
+	if err != nil {
+		return nil, partition{}, nil, err
+	}
+	fail := func(err error) (*session, partition, []byte, error) {
+		s.port.Close()
+		return nil, partition{}, nil, err
+	}
+	table, err := s.loader.readFlash(partitionTableOffset, partitionTableSize, nil)
+	if err != nil {
+		return fail(err)
+	}
+	parts, err := parsePartitionTable(table)
+	if err != nil {
+		return fail(err)
+	}
+	p, err := findNVSPartition(parts)
+	if err != nil {
+		return fail(err)
+	}
+	image, err := s.loader.readFlash(p.offset, p.size, func(done, total int) {
+		fmt.Fprintf(os.Stderr, "\rReading %s at 0x%08x... %3d%%", p.label, p.offset, done*100/total)
+	})
+	if err != nil {
+		return fail(err)
+	}
+	fmt.Fprintf(os.Stderr, "\n")
+	return s, p, image, nil
+}
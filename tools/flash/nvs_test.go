@@ -0,0 +1,121 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"strings"
+	"testing"
+)
+
+// firmwareSettings are keys the firmware keeps in NVS.
+func firmwareSettings() []nvsItem {
+	return []nvsItem{
+		{ns: "cphw", key: "dev_det", typ: nvsU8, data: []byte{1}},
+		{ns: "cphw", key: "dev_ovr", typ: nvsU8, data: []byte{2}},
+		{ns: "nvs.net80211", key: "sta.pswd", typ: nvsBlobData, data: append([]byte("hunter22"), make([]byte, 57)...)},
+		{ns: "nvs.net80211", key: "sta.ssid", typ: nvsBlobData, data: append([]byte{4, 0, 0, 0, 'S', 'U', 'M', 'I'}, make([]byte, 28)...)},
+		{ns: "sumiclock", key: "epoch", typ: nvsU32, data: []byte{0x00, 0x5E, 0x0F, 0x68}},
+		{ns: "test", key: "name", typ: nvsStr, data: []byte("Reader")},
+		{ns: "test", key: "offset", typ: nvsI16, data: []byte{0xFE, 0xFF}},
+	}
+}
+
+// itemStrings is printNVS's output with the column padding collapsed.
+func itemStrings(items []nvsItem) string {
+	var b strings.Builder
+	printNVS(&b, items)
+	var lines []string
+	for _, line := range strings.Split(strings.TrimSpace(b.String()), "\n") {
+		lines = append(lines, strings.Join(strings.Fields(line), " "))
+	}
+	return strings.Join(lines, "\n")
+}
+
+func TestNVS_RoundTrip(t *testing.T) {
+	items := firmwareSettings()
+	image, err := encodeNVS(items, 0x5000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := parseNVS(image, func(msg string) { t.Error(msg) })
+	if err != nil {
+		t.Fatal(err)
+	}
+	if itemStrings(got) != itemStrings(items) {
+		t.Errorf("decoded\n%s\nwant\n%s", itemStrings(got), itemStrings(items))
+	}
+	if !strings.Contains(itemStrings(got), "test/offset i16 -2") {
+		t.Errorf("signed value not shown as negative:\n%s", itemStrings(got))
+	}
+}
+
+func TestNVS_LargeBlobSpansPages(t *testing.T) {
+	blob := bytes.Repeat([]byte("0123456789"), 600) // more than a page holds
+	items := []nvsItem{{ns: "reader", key: "big", typ: nvsBlobData, data: blob}}
+	image, err := encodeNVS(items, 0x5000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := parseNVS(image, func(msg string) { t.Error(msg) })
+	if err != nil || len(got) != 1 || !bytes.Equal(got[0].data, blob) {
+		t.Fatalf("blob did not survive: %d items, %v", len(got), err)
+	}
+	if _, err := encodeNVS(items, 0x2000); err == nil {
+		t.Error("blob larger than the partition: expected error")
+	}
+}
+
+func TestNVS_SkipsCorruptEntry(t *testing.T) {
+	image, _ := encodeNVS(firmwareSettings(), 0x5000)
+	// The first item after the "cphw" namespace entry is cphw/dev_det.
+	image[nvsEntriesStart+nvsEntrySize+24] ^= 0xFF
+	var warnings []string
+	got, err := parseNVS(image, func(msg string) { warnings = append(warnings, msg) })
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != len(firmwareSettings())-1 || len(warnings) != 1 || !strings.Contains(warnings[0], "dev_det") {
+		t.Errorf("got %d items, warnings %q", len(got), warnings)
+	}
+}
+
+func TestNVS_PageHeader(t *testing.T) {
+	image, _ := encodeNVS(firmwareSettings(), 0x5000)
+	if state := binary.LittleEndian.Uint32(image); state != nvsPageActive {
+		t.Errorf("first page state 0x%x, want active", state)
+	}
+	if binary.LittleEndian.Uint32(image[28:]) != nvsCRC(image[4:28]) || image[8] != nvsVersion {
+		t.Error("bad page header")
+	}
+	if !bytes.Equal(image[nvsPageSize:], bytes.Repeat([]byte{0xFF}, 0x5000-nvsPageSize)) {
+		t.Error("pages after the settings are not left erased")
+	}
+}
+
+func TestApplyNVSSet(t *testing.T) {
+	items := firmwareSettings()
+	var err error
+	for _, spec := range []string{"cphw/dev_ovr=0", "test/name=\"Paper\"", "reader/font:u16=300", "nvs.net80211/sta.ssid=0400000041424344"} {
+		if items, err = applyNVSSet(items, spec); err != nil {
+			t.Fatalf("%s: %v", spec, err)
+		}
+	}
+	if items, err = deleteNVSItem(items, "sumiclock/epoch"); err != nil {
+		t.Fatal(err)
+	}
+	out := itemStrings(items)
+	for _, want := range []string{"cphw/dev_ovr u8 0", `test/name str "Paper"`, "reader/font u16 300", "sta.ssid blob 0400000041424344"} {
+		if !strings.Contains(out, want) {
+			t.Errorf("missing %q in\n%s", want, out)
+		}
+	}
+	if strings.Contains(out, "epoch") {
+		t.Error("deleted key still listed")
+	}
+
+	for _, spec := range []string{"cphw/dev_ovr=256", "cphw/new=1", "cphw/new:f32=1", "nokey=1"} {
+		if _, err := applyNVSSet(firmwareSettings(), spec); err == nil {
+			t.Errorf("%s: expected error", spec)
+		}
+	}
+}
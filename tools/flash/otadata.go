@@ -0,0 +1,174 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"sort"
+)
+
+// The otadata partition holds two copies of esp_ota_select_entry_t, one per
+// sector. The bootloader starts OTA slot (seq-1) % slots of the valid copy
+// with the higher sequence number, or the first slot if neither is valid.
+const (
+	otaEntrySize  = 32
+	otaSectorSize = 0x1000
+)
+
+// OTA image states, esp_ota_img_states_t.
+const (
+	otaStateNew           = 0
+	otaStatePendingVerify = 1
+	otaStateValid         = 2
+	otaStateInvalid       = 3
+	otaStateAborted       = 4
+	otaStateUndefined     = 0xFFFFFFFF
+)
+
+var otaStateNames = map[uint32]string{
+	otaStateNew: "new", otaStatePendingVerify: "pending verify", otaStateValid: "valid",
+	otaStateInvalid: "invalid", otaStateAborted: "aborted", otaStateUndefined: "undefined",
+}
+
+// otaEntry is one copy of the OTA selection.
+type otaEntry struct {
+	seq   uint32
+	state uint32
+	crc   uint32
+}
+
+func parseOTAEntry(b []byte) otaEntry {
+	return otaEntry{
+		seq:   binary.LittleEndian.Uint32(b),
+		state: binary.LittleEndian.Uint32(b[24:]),
+		crc:   binary.LittleEndian.Uint32(b[28:]),
+	}
+}
+
+// otaSeqCRC is the CRC the bootloader expects over an entry's sequence
+// number, esp_rom_crc32_le seeded with 0xFFFFFFFF.
+func otaSeqCRC(seq uint32) uint32 {
+	return crc32.Update(0xFFFFFFFF, crc32.IEEETable, binary.LittleEndian.AppendUint32(nil, seq))
+}
+
+// valid reports whether the bootloader would select by this entry.
+func (e otaEntry) valid() bool {
+	return e.seq != 0xFFFFFFFF && e.crc == otaSeqCRC(e.seq) &&
+		e.state != otaStateInvalid && e.state != otaStateAborted
+}
+
+// stateName is the entry's image state as text.
+func (e otaEntry) stateName() string {
+	if name, ok := otaStateNames[e.state]; ok {
+		return name
+	}
+	return "unknown"
+}
+
+// activeOTAEntry returns which of the two entries the bootloader goes by, or
+// -1 if neither is valid.
+func activeOTAEntry(entries [2]otaEntry) int {
+	active := -1
+	for i, e := range entries {
+		if e.valid() && (active < 0 || e.seq > entries[active].seq) {
+			active = i
+		}
+	}
+	return active
+}
+
+// otaSlots returns the OTA app partitions, ota_0 first.
+func otaSlots(parts []partition) []partition {
+	var slots []partition
+	for _, p := range parts {
+		if p.ptype == partitionTypeApp && p.sub >= 0x10 && p.sub < 0x20 {
+			slots = append(slots, p)
+		}
+	}
+	sort.Slice(slots, func(i, j int) bool { return slots[i].sub < slots[j].sub })
+	return slots
+}
+
+// findOTAData returns the otadata partition.
+func findOTAData(parts []partition) (partition, error) {
+	for _, p := range parts {
+		if p.ptype == partitionTypeData && p.sub == 0x00 {
+			return p, nil
+		}
+	}
+	return partition{}, errors.New("partition table has no otadata partition")
+}
+
+// bootSlot returns the index in slots of the app the bootloader starts, and
+// the entry that selects it, or -1 when otadata is blank and the first slot
+// boots.
+func bootSlot(entries [2]otaEntry, slots int) (slot, entry int) {
+	entry = activeOTAEntry(entries)
+	if entry < 0 || slots == 0 {
+		return 0, entry
+	}
+	return int((entries[entry].seq - 1) % uint32(slots)), entry
+}
+
+// encodeOTAEntry lays out an entry as it is stored, with a blank label.
+func encodeOTAEntry(e otaEntry) []byte {
+	b := bytes.Repeat([]byte{0xFF}, otaEntrySize)
+	binary.LittleEndian.PutUint32(b, e.seq)
+	binary.LittleEndian.PutUint32(b[24:], e.state)
+	binary.LittleEndian.PutUint32(b[28:], e.crc)
+	return b
+}
+
+// selectSlot returns the entry that makes the bootloader start slot, and the
+// sector to write it to. As esp_ota_set_boot_partition does, it takes the
+// next sequence number that maps to slot and replaces the entry that is not
+// active, so a torn write leaves the current selection in place.
+func selectSlot(entries [2]otaEntry, slot, slots int) (int, otaEntry) {
+	active := activeOTAEntry(entries)
+	seq := uint32(1)
+	sector := 0
+	if active >= 0 {
+		seq = entries[active].seq + 1
+		sector = 1 - active
+	}
+	for int((seq-1)%uint32(slots)) != slot {
+		seq++
+	}
+	return sector, otaEntry{seq: seq, state: otaStateUndefined, crc: otaSeqCRC(seq)}
+}
+
+// otaState is the OTA layout of a board and its current selection.
+type otaState struct {
+	parts   []partition
+	slots   []partition
+	otadata partition
+	entries [2]otaEntry
+}
+
+// readOTAState reads the partition table and both otadata entries.
+func readOTAState(read flashReader) (otaState, error) {
+	table, err := read(partitionTableOffset, partitionTableSize)
+	if err != nil {
+		return otaState{}, err
+	}
+	parts, err := parsePartitionTable(table)
+	if err != nil {
+		return otaState{}, err
+	}
+	st := otaState{parts: parts, slots: otaSlots(parts)}
+	if len(st.slots) == 0 {
+		return otaState{}, errors.New("partition table has no OTA app slots")
+	}
+	if st.otadata, err = findOTAData(parts); err != nil {
+		return otaState{}, err
+	}
+	for i := range st.entries {
+		b, err := read(st.otadata.offset+uint32(i)*otaSectorSize, otaEntrySize)
+		if err != nil {
+			return otaState{}, err
+		}
+		st.entries[i] = parseOTAEntry(b)
+	}
+	return st, nil
+}
@@ -0,0 +1,446 @@
+package main
+
+import (
+	"bytes"
+	"crypto/md5"
+	"encoding/binary"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+)
+
+// Partition table layout (ESP-IDF): 32-byte entries starting with
+// partitionMagic, ended by an MD5 entry or blank flash.
+const (
+	partitionEntrySize = 32
+	partitionTableSize = 0xC00
+	partitionTypeApp   = 0x00
+	partitionTypeData  = 0x01
+	partitionSubSPIFFS = 0x82 // also used for LittleFS
+	partitionEncrypted = 0x01 // flags bit
+	// firstPartitionOffset is the first address after the partition table's
+	// flash sector.
+	firstPartitionOffset = partitionTableOffset + 0x1000
+	// App partitions must start on a 64K boundary, the rest on a 4K sector.
+	appAlign  = 0x10000
+	dataAlign = 0x1000
+)
+
+var partitionMD5Magic = []byte{0xEB, 0xEB}
+
+// partition is one entry of a partition table.
+type partition struct {
+	label        string
+	ptype, sub   byte
+	offset, size uint32
+	flags        uint32
+}
+
+// Type and subtype names, as partitions.csv spells them.
+var (
+	partitionTypeNames = map[byte]string{partitionTypeApp: "app", partitionTypeData: "data"}
+	appSubtypeNames    = map[byte]string{0x00: "factory", 0x20: "test"}
+	dataSubtypeNames   = map[byte]string{
+		0x00: "ota", 0x01: "phy", 0x02: "nvs", 0x03: "coredump", 0x04: "nvs_keys",
+		0x05: "efuse", 0x06: "undefined", 0x80: "esphttpd", 0x81: "fat", partitionSubSPIFFS: "spiffs", 0x83: "littlefs",
+	}
+)
+
+func (p partition) typeName() string {
+	if name, ok := partitionTypeNames[p.ptype]; ok {
+		return name
+	}
+	return fmt.Sprintf("0x%02x", p.ptype)
+}
+
+func (p partition) subtypeName() string {
+	switch {
+	case p.ptype == partitionTypeApp && p.sub >= 0x10 && p.sub < 0x20:
+		return fmt.Sprintf("ota_%d", p.sub-0x10)
+	case p.ptype == partitionTypeApp && appSubtypeNames[p.sub] != "":
+		return appSubtypeNames[p.sub]
+	case p.ptype == partitionTypeData && dataSubtypeNames[p.sub] != "":
+		return dataSubtypeNames[p.sub]
+	}
+	return fmt.Sprintf("0x%02x", p.sub)
+}
+
+// align is the boundary the partition's offset must fall on.
+func (p partition) align() uint32 {
+	if p.ptype == partitionTypeApp {
+		return appAlign
+	}
+	return dataAlign
+}
+
+// parsePartitionTable reads the entries of a binary partition table, checking
+// its MD5 entry when it has one.
+func parsePartitionTable(table []byte) ([]partition, error) {
+	var parts []partition
+	for off := 0; off+partitionEntrySize <= len(table); off += partitionEntrySize {
+		e := table[off : off+partitionEntrySize]
+		if bytes.Equal(e[:2], partitionMD5Magic) {
+			if sum := md5.Sum(table[:off]); !bytes.Equal(e[16:], sum[:]) {
+				return nil, errors.New("partition table MD5 does not match its entries")
+			}
+			break
+		}
+		if !bytes.Equal(e[:2], partitionMagic) {
+			break
+		}
+		parts = append(parts, partition{
+			label:  strings.TrimRight(string(e[12:28]), "\x00"),
+			ptype:  e[2],
+			sub:    e[3],
+			offset: binary.LittleEndian.Uint32(e[4:]),
+			size:   binary.LittleEndian.Uint32(e[8:]),
+			flags:  binary.LittleEndian.Uint32(e[28:]),
+		})
+	}
+	if len(parts) == 0 {
+		return nil, errors.New("no partition entries")
+	}
+	return parts, nil
+}
+
+// encodePartitionTable builds the binary table for parts, with an MD5 entry,
+// padded with erased flash to partitionTableSize.
+func encodePartitionTable(parts []partition) []byte {
+	var table []byte
+	for _, p := range parts {
+		e := make([]byte, partitionEntrySize)
+		copy(e, partitionMagic)
+		e[2], e[3] = p.ptype, p.sub
+		binary.LittleEndian.PutUint32(e[4:], p.offset)
+		binary.LittleEndian.PutUint32(e[8:], p.size)
+		copy(e[12:27], p.label)
+		binary.LittleEndian.PutUint32(e[28:], p.flags)
+		table = append(table, e...)
+	}
+	sum := md5.Sum(table)
+	table = append(table, partitionMD5Magic...)
+	table = append(table, bytes.Repeat([]byte{0xFF}, 14)...)
+	table = append(table, sum[:]...)
+	return append(table, bytes.Repeat([]byte{0xFF}, partitionTableSize-len(table))...)
+}
+
+// parseSize parses a partition size or offset: hex, decimal, or a number of
+// kilobytes or megabytes such as 64K or 4M.
+func parseSize(s string) (uint32, error) {
+	s = strings.TrimSpace(s)
+	mult := uint64(1)
+	switch {
+	case strings.HasSuffix(strings.ToUpper(s), "K"):
+		mult, s = 1<<10, s[:len(s)-1]
+	case strings.HasSuffix(strings.ToUpper(s), "M"):
+		mult, s = 1<<20, s[:len(s)-1]
+	}
+	n, err := strconv.ParseUint(s, 0, 32)
+	if err != nil || n*mult > 1<<32-1 {
+		return 0, fmt.Errorf("bad size %q", s)
+	}
+	return uint32(n * mult), nil
+}
+
+// parsePartitionCSV reads a partition table in the partitions.csv format
+// ESP-IDF's gen_esp32part.py takes. An empty offset places the partition
+// after the previous one.
+func parsePartitionCSV(text string) ([]partition, error) {
+	var parts []partition
+	next := uint32(firstPartitionOffset)
+	for i, line := range strings.Split(text, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.Split(line, ",")
+		for len(fields) < 6 {
+			fields = append(fields, "")
+		}
+		for j := range fields {
+			fields[j] = strings.TrimSpace(fields[j])
+		}
+		fail := func(format string, args ...any) ([]partition, error) {
+			return nil, fmt.Errorf("line %d: %s", i+1, fmt.Sprintf(format, args...))
+		}
+		p := partition{label: fields[0]}
+		ptype, ok := lookupName(partitionTypeNames, fields[1])
+		if !ok {
+			return fail("unknown type %q", fields[1])
+		}
+		p.ptype = ptype
+		if p.sub, ok = lookupSubtype(ptype, fields[2]); !ok {
+			return fail("unknown subtype %q", fields[2])
+		}
+		var err error
+		if fields[3] == "" {
+			p.offset = (next + p.align() - 1) &^ (p.align() - 1)
+		} else if p.offset, err = parseSize(fields[3]); err != nil {
+			return fail("offset: %v", err)
+		}
+		if p.size, err = parseSize(fields[4]); err != nil {
+			return fail("size: %v", err)
+		}
+		for _, flag := range strings.Fields(strings.ReplaceAll(fields[5], ":", " ")) {
+			if flag != "encrypted" {
+				return fail("unknown flag %q", flag)
+			}
+			p.flags |= partitionEncrypted
+		}
+		next = p.offset + p.size
+		parts = append(parts, p)
+	}
+	if len(parts) == 0 {
+		return nil, errors.New("no partition entries")
+	}
+	return parts, nil
+}
+
+func lookupName(names map[byte]string, s string) (byte, bool) {
+	for v, name := range names {
+		if strings.EqualFold(name, s) {
+			return v, true
+		}
+	}
+	if n, err := strconv.ParseUint(s, 0, 8); err == nil {
+		return byte(n), true
+	}
+	return 0, false
+}
+
+func lookupSubtype(ptype byte, s string) (byte, bool) {
+	if ptype == partitionTypeApp {
+		if n, ok := strings.CutPrefix(strings.ToLower(s), "ota_"); ok {
+			if v, err := strconv.Atoi(n); err == nil && v >= 0 && v < 16 {
+				return byte(0x10 + v), true
+			}
+		}
+		return lookupName(appSubtypeNames, s)
+	}
+	return lookupName(dataSubtypeNames, s)
+}
+
+// formatPartitionCSV writes parts in the partitions.csv format.
+func formatPartitionCSV(w io.Writer, parts []partition) {
+	tw := tabwriter.NewWriter(w, 0, 0, 1, ' ', 0)
+	fmt.Fprintf(tw, "# Name,\tType,\tSubType,\tOffset,\tSize,\tFlags\n")
+	for _, p := range parts {
+		flags := ""
+		if p.flags&partitionEncrypted != 0 {
+			flags = "encrypted"
+		}
+		fmt.Fprintf(tw, "%s,\t%s,\t%s,\t0x%x,\t0x%x,\t%s\n", p.label, p.typeName(), p.subtypeName(), p.offset, p.size, flags)
+	}
+	tw.Flush()
+}
+
+// printPartitions shows parts as a table with sizes in readable units.
+func printPartitions(w io.Writer, parts []partition, flashSize uint32) {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(tw, "Name\tType\tSubType\tOffset\tEnd\tSize\n")
+	var used uint32
+	for _, p := range parts {
+		fmt.Fprintf(tw, "%s\t%s\t%s\t0x%06x\t0x%06x\t%s\n", p.label, p.typeName(), p.subtypeName(), p.offset, p.offset+p.size, humanSize(p.size))
+		used = max(used, p.offset+p.size)
+	}
+	tw.Flush()
+	if flashSize > used {
+		fmt.Fprintf(w, "%s of %s flash unallocated after 0x%06x\n", humanSize(flashSize-used), humanSize(flashSize), used)
+	}
+}
+
+// humanSize formats a byte count in K or M where it divides evenly enough.
+func humanSize(n uint32) string {
+	switch {
+	case n >= 1<<20 && n%(1<<20) == 0:
+		return fmt.Sprintf("%dM", n>>20)
+	case n >= 1<<20:
+		return fmt.Sprintf("%.2fM", float64(n)/(1<<20))
+	case n >= 1<<10 && n%(1<<10) == 0:
+		return fmt.Sprintf("%dK", n>>10)
+	}
+	return fmt.Sprintf("%d", n)
+}
+
+// validatePartitions checks that parts make a table the bootloader accepts
+// and that fits a flash chip of flashSize bytes.
+func validatePartitions(parts []partition, flashSize uint32) error {
+	if len(parts) > partitionTableSize/partitionEntrySize-1 {
+		return fmt.Errorf("%d partitions do not fit in the table", len(parts))
+	}
+	labels := map[string]bool{}
+	for _, p := range parts {
+		switch {
+		case p.label == "" || len(p.label) > 15:
+			return fmt.Errorf("partition label %q must be 1-15 characters", p.label)
+		case labels[p.label]:
+			return fmt.Errorf("partition %s is listed twice", p.label)
+		case p.offset < firstPartitionOffset:
+			return fmt.Errorf("partition %s at 0x%x overlaps the bootloader or partition table (first free offset 0x%x)", p.label, p.offset, firstPartitionOffset)
+		case p.offset%p.align() != 0:
+			return fmt.Errorf("partition %s at 0x%x is not aligned to 0x%x", p.label, p.offset, p.align())
+		case p.size == 0 || p.size%dataAlign != 0:
+			return fmt.Errorf("partition %s size 0x%x is not a multiple of 0x%x", p.label, p.size, dataAlign)
+		case uint64(p.offset)+uint64(p.size) > uint64(flashSize):
+			return fmt.Errorf("partition %s ends at 0x%x, past the end of %s flash", p.label, uint64(p.offset)+uint64(p.size), humanSize(flashSize))
+		}
+		labels[p.label] = true
+	}
+	sorted := append([]partition(nil), parts...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].offset < sorted[j].offset })
+	for i := 1; i < len(sorted); i++ {
+		if prev := sorted[i-1]; sorted[i].offset < prev.offset+prev.size {
+			return fmt.Errorf("partition %s at 0x%x overlaps %s (0x%x-0x%x)", sorted[i].label, sorted[i].offset, prev.label, prev.offset, prev.offset+prev.size)
+		}
+	}
+	return nil
+}
+
+// resizePartition applies a -resize value "label=size". A size of "max"
+// grows the partition up to the next one or the end of flash.
+func resizePartition(parts []partition, spec string, flashSize uint32) error {
+	label, size, ok := strings.Cut(spec, "=")
+	if !ok {
+		return fmt.Errorf("expected label=size, got %q", spec)
+	}
+	i := -1
+	for j, p := range parts {
+		if p.label == label {
+			i = j
+		}
+	}
+	if i < 0 {
+		return fmt.Errorf("no partition named %q", label)
+	}
+	if size == "max" {
+		end := flashSize
+		for _, p := range parts {
+			if p.offset > parts[i].offset && p.offset < end {
+				end = p.offset
+			}
+		}
+		parts[i].size = end - parts[i].offset
+		return nil
+	}
+	n, err := parseSize(size)
+	if err != nil {
+		return err
+	}
+	parts[i].size = n
+	return nil
+}
+
+// loadPartitions reads a partition table from a file: partitions.csv, a
+// binary table, or a flash dump that holds one at partitionTableOffset.
+func loadPartitions(path string) ([]partition, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	if strings.EqualFold(filepath.Ext(path), ".csv") {
+		return parsePartitionCSV(string(data))
+	}
+	if len(data) > partitionTableOffset && !bytes.HasPrefix(data, partitionMagic) {
+		data = data[partitionTableOffset:]
+	}
+	return parsePartitionTable(data[:min(len(data), partitionTableSize)])
+}
+
+// runPartitions implements "partitions [flags] [table.bin|partitions.csv|dump.bin]".
+func runPartitions(args []string) int {
+	fs := flag.NewFlagSet("partitions", flag.ContinueOnError)
+	portFlag := fs.String("port", "", "serial port to read the table from when no file is given (auto-detected by default)")
+	baudFlag := fs.Int("baud", 115200, "baud rate to reach the bootloader at")
+	flashSizeFlag := fs.String("flash-size", "16MB", "size of the board's flash chip")
+	outFlag := fs.String("o", "", "write the table to this file: partitions.csv format for .csv, binary otherwise")
+	var resizes []string
+	fs.Func("resize", "set a partition's size, as label=size (e.g. spiffs=4M, or spiffs=max to fill the free space after it); repeatable", func(s string) error {
+		resizes = append(resizes, s)
+		return nil
+	})
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: %s partitions [-resize label=size] [-o out.bin|out.csv] [table.bin|partitions.csv|dump.bin]\n", os.Args[0])
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if fs.NArg() > 1 {
+		fs.Usage()
+		return 2
+	}
+	flashSize, err := parseFlashSize(*flashSizeFlag)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "-flash-size: %v\n", err)
+		return 2
+	}
+
+	var parts []partition
+	if fs.NArg() == 1 {
+		parts, err = loadPartitions(fs.Arg(0))
+	} else {
+		parts, err = readDevicePartitions(*portFlag, *baudFlag, flashSize)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to read the partition table: %v\n", err)
+		return 1
+	}
+	for _, spec := range resizes {
+		if err := resizePartition(parts, spec, flashSize); err != nil {
+			fmt.Fprintf(os.Stderr, "-resize: %v\n", err)
+			return 2
+		}
+	}
+	printPartitions(os.Stdout, parts, flashSize)
+	if err := validatePartitions(parts, flashSize); err != nil {
+		fmt.Fprintf(os.Stderr, "Invalid partition table: %v\n", err)
+		return 1
+	}
+	if *outFlag == "" {
+		return 0
+	}
+	var out []byte
+	if strings.EqualFold(filepath.Ext(*outFlag), ".csv") {
+		var b bytes.Buffer
+		formatPartitionCSV(&b, parts)
+		out = b.Bytes()
+	} else {
+		out = encodePartitionTable(parts)
+	}
+	if err := os.WriteFile(*outFlag, out, 0644); err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		return 1
+	}
+	fmt.Fprintf(os.Stderr, "Wrote %s\n", *outFlag)
+	return 0
+}
+
+// readDevicePartitions reads the partition table off the board on the named
+// port, or the auto-detected one.
+func readDevicePartitions(name string, baud int, flashSize uint32) ([]partition, error) {
+	if name == "" {
+		var err error
+		if name, err = detectPort(); err != nil {
+			return nil, fmt.Errorf("auto-detect failed: %v", err)
+		}
+		fmt.Fprintf(os.Stderr, "Auto-detected port: %s\n", name)
+	}
+	s, err := connect(name, baud, 0, flashSize)
+	if err != nil {
+		return nil, err
+	}
+	defer s.port.Close()
+	table, err := s.loader.readFlash(partitionTableOffset, partitionTableSize, nil)
+	if err != nil {
+		return nil, err
+	}
+	s.finish(true)
+	return parsePartitionTable(table)
+}
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// sumiCSV is the repository's partitions.csv.
+const sumiCSV = `# Name,   Type, SubType, Offset,  Size, Flags
+nvs,      data, nvs,     0x9000,  0x5000,
+otadata,  data, ota,     0xe000,  0x2000,
+app0,     app,  ota_0,   0x10000, 0x640000,
+app1,     app,  ota_1,   0x650000,0x640000,
+spiffs,   data, spiffs,  0xc90000,0x360000,
+coredump, data, coredump,0xFF0000,0x10000,
+`
+
+func TestParsePartitionCSV(t *testing.T) {
+	parts, err := parsePartitionCSV(sumiCSV)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, p := range parts {
+		got = append(got, p.label+" "+p.typeName()+" "+p.subtypeName()+" "+humanSize(p.size))
+	}
+	want := []string{
+		"nvs data nvs 20K", "otadata data ota 8K", "app0 app ota_0 6.25M",
+		"app1 app ota_1 6.25M", "spiffs data spiffs 3.38M", "coredump data coredump 64K",
+	}
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("got %q, want %q", got, want)
+	}
+	if err := validatePartitions(parts, 16<<20); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestParsePartitionCSV_NextOffset(t *testing.T) {
+	parts, err := parsePartitionCSV("nvs, data, nvs, , 20K\nfactory, app, factory, , 1M\nstorage, data, littlefs, , 64K, encrypted\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	offsets := []uint32{0x9000, 0x10000, 0x110000}
+	for i, p := range parts {
+		if p.offset != offsets[i] {
+			t.Errorf("%s at 0x%x, want 0x%x", p.label, p.offset, offsets[i])
+		}
+	}
+	if parts[2].flags != partitionEncrypted {
+		t.Error("encrypted flag not set")
+	}
+}
+
+func TestPartitionTable_RoundTrip(t *testing.T) {
+	parts, _ := parsePartitionCSV(sumiCSV)
+	table := encodePartitionTable(parts)
+	if len(table) != partitionTableSize {
+		t.Fatalf("table is %d bytes", len(table))
+	}
+	back, err := parsePartitionTable(table)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var csv bytes.Buffer
+	formatPartitionCSV(&csv, back)
+	again, err := parsePartitionCSV(csv.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(again) != len(parts) {
+		t.Fatalf("%d partitions after the round trip, want %d", len(again), len(parts))
+	}
+	for i := range parts {
+		if again[i] != parts[i] {
+			t.Errorf("partition %d: %+v, want %+v", i, again[i], parts[i])
+		}
+	}
+
+	table[0x10] ^= 1
+	if _, err := parsePartitionTable(table); err == nil {
+		t.Error("corrupt table passed its MD5 check")
+	}
+}
+
+func TestValidatePartitions(t *testing.T) {
+	for _, tc := range []struct {
+		csv, want string
+	}{
+		{"nvs, data, nvs, 0x8000, 0x5000", "overlaps the bootloader"},
+		{"app0, app, ota_0, 0x11000, 0x10000", "not aligned"},
+		{"nvs, data, nvs, 0x9000, 0x5800", "not a multiple"},
+		{"nvs, data, nvs, 0x9000, 0x5000\nnvs2, data, nvs, 0xd000, 0x1000", "overlaps nvs"},
+		{"nvs, data, nvs, 0x9000, 0x5000\nnvs, data, nvs, 0xe000, 0x1000", "listed twice"},
+		{"app0, app, ota_0, 0x10000, 16M", "past the end"},
+	} {
+		parts, err := parsePartitionCSV(tc.csv)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := validatePartitions(parts, 16<<20); err == nil || !strings.Contains(err.Error(), tc.want) {
+			t.Errorf("%q: got %v, want an error about %q", tc.csv, err, tc.want)
+		}
+	}
+}
+
+func TestResizePartition(t *testing.T) {
+	parts, _ := parsePartitionCSV(sumiCSV)
+	if err := resizePartition(parts, "spiffs=4M", 16<<20); err != nil {
+		t.Fatal(err)
+	}
+	if err := validatePartitions(parts, 16<<20); err == nil {
+		t.Error("spiffs grown over coredump passed validation")
+	}
+	if err := resizePartition(parts, "spiffs=max", 16<<20); err != nil {
+		t.Fatal(err)
+	}
+	if parts[4].size != 0xFF0000-0xC90000 {
+		t.Errorf("spiffs=max gave size 0x%x", parts[4].size)
+	}
+	if err := resizePartition(parts, "books=1M", 16<<20); err == nil {
+		t.Error("unknown partition: expected error")
+	}
+}
+
+func TestLoadPartitions_FlashDump(t *testing.T) {
+	parts, _ := parsePartitionCSV(sumiCSV)
+	dump := bytes.Repeat([]byte{0xFF}, 0x10000)
+	dump[0] = imageMagic
+	copy(dump[partitionTableOffset:], encodePartitionTable(parts))
+	path := filepath.Join(t.TempDir(), "dump.bin")
+	os.WriteFile(path, dump, 0644)
+	got, err := loadPartitions(path)
+	if err != nil || len(got) != len(parts) {
+		t.Errorf("loadPartitions = %d partitions, %v", len(got), err)
+	}
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"go.bug.st/serial"
+)
+
+// Espressif's USB vendor ID and the product ID of the USB Serial/JTAG
+// controller built into the ESP32-C3.
+const (
+	espressifVID     = "303A"
+	espressifJTAGPID = "1001"
+)
+
+// portDetail is the USB identity of a serial port, where it has one.
+type portDetail struct {
+	name     string
+	usb      bool
+	vid, pid string
+}
+
+// lineStep sets one control line and then waits.
+type lineStep struct {
+	rts   bool // RTS rather than DTR
+	level bool
+	wait  time.Duration
+}
+
+func dtr(level bool, wait time.Duration) lineStep {
+	return lineStep{level: level, wait: wait}
+}
+
+func rts(level bool, wait time.Duration) lineStep {
+	return lineStep{rts: true, level: level, wait: wait}
+}
+
+// The sequences esptool uses, as in tools/monitor: a dev board's USB-UART
+// bridge drives EN and the boot strapping pin from RTS and DTR, and the
+// built-in USB Serial/JTAG controller decodes the same lines itself.
+var (
+	classicReset      = []lineStep{dtr(false, 0), rts(true, 100*time.Millisecond), rts(false, 0)}
+	classicBootloader = []lineStep{
+		dtr(false, 0), rts(true, 100*time.Millisecond),
+		dtr(true, 0), rts(false, 50*time.Millisecond),
+		dtr(false, 0),
+	}
+	usbJTAGReset      = []lineStep{dtr(false, 0), rts(true, 200*time.Millisecond), rts(false, 200*time.Millisecond)}
+	usbJTAGBootloader = []lineStep{
+		rts(false, 0), dtr(false, 100*time.Millisecond),
+		dtr(true, 0), rts(false, 100*time.Millisecond),
+		rts(true, 0), dtr(false, 0), rts(true, 100*time.Millisecond),
+		dtr(false, 0), rts(false, 0),
+	}
+)
+
+// resetSequences returns the reset and bootloader sequences for a port.
+func resetSequences(name string) (reset, bootloader []lineStep) {
+	if details, err := listPortDetails(); err == nil {
+		for _, d := range details {
+			if d.name == name && d.usb && strings.EqualFold(d.vid, espressifVID) && strings.EqualFold(d.pid, espressifJTAGPID) {
+				return usbJTAGReset, usbJTAGBootloader
+			}
+		}
+	}
+	return classicReset, classicBootloader
+}
+
+// runLineSequence plays a sequence on the port's control lines.
+func runLineSequence(p serial.Port, seq []lineStep) error {
+	for _, s := range seq {
+		set := p.SetDTR
+		if s.rts {
+			set = p.SetRTS
+		}
+		if err := set(s.level); err != nil {
+			return err
+		}
+		time.Sleep(s.wait)
+	}
+	return nil
+}
+
+// detectPort returns the only Espressif USB port.
+func detectPort() (string, error) {
+	details, err := listPortDetails()
+	if err != nil {
+		return "", fmt.Errorf("%v; name the port with -port", err)
+	}
+	var boards, all []string
+	for _, d := range details {
+		all = append(all, d.name)
+		if d.usb && strings.EqualFold(d.vid, espressifVID) {
+			boards = append(boards, d.name)
+		}
+	}
+	switch len(boards) {
+	case 0:
+		return "", fmt.Errorf("no ESP32 board found (ports: %v); is it plugged in?", all)
+	case 1:
+		return boards[0], nil
+	}
+	return "", fmt.Errorf("several boards found, pick one with -port: %v", boards)
+}
@@ -0,0 +1,77 @@
+package main
+
+import "bytes"
+
+// SLIP framing (RFC 1055) as the ESP32 serial bootloader uses it: every
+// packet is wrapped in slipEnd bytes, and slipEnd and slipEsc inside it are
+// escaped.
+const (
+	slipEnd    = 0xC0
+	slipEsc    = 0xDB
+	slipEscEnd = 0xDC
+	slipEscEsc = 0xDD
+)
+
+// slipEncode frames one packet.
+func slipEncode(packet []byte) []byte {
+	out := make([]byte, 0, len(packet)+8)
+	out = append(out, slipEnd)
+	for _, b := range packet {
+		switch b {
+		case slipEnd:
+			out = append(out, slipEsc, slipEscEnd)
+		case slipEsc:
+			out = append(out, slipEsc, slipEscEsc)
+		default:
+			out = append(out, b)
+		}
+	}
+	return append(out, slipEnd)
+}
+
+// slipDecoder collects frames from a byte stream that may hold other output,
+// such as the ROM's boot messages, between them.
+type slipDecoder struct {
+	in     bool // inside a frame
+	esc    bool
+	packet bytes.Buffer
+}
+
+// feed consumes b and returns the packets it completes.
+func (d *slipDecoder) feed(b []byte) [][]byte {
+	var packets [][]byte
+	for _, c := range b {
+		if !d.in {
+			if c == slipEnd {
+				d.in = true
+				d.packet.Reset()
+			}
+			continue
+		}
+		switch {
+		case d.esc:
+			d.esc = false
+			switch c {
+			case slipEscEnd:
+				d.packet.WriteByte(slipEnd)
+			case slipEscEsc:
+				d.packet.WriteByte(slipEsc)
+			default:
+				// Invalid escape: drop the frame and resynchronize.
+				d.in = false
+			}
+		case c == slipEsc:
+			d.esc = true
+		case c == slipEnd:
+			if d.packet.Len() == 0 {
+				// Back-to-back delimiters; the second opens a frame.
+				continue
+			}
+			packets = append(packets, append([]byte(nil), d.packet.Bytes()...))
+			d.in = false
+		default:
+			d.packet.WriteByte(c)
+		}
+	}
+	return packets
+}
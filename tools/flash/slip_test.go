@@ -0,0 +1,30 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestSLIP_RoundTrip(t *testing.T) {
+	packet := []byte{0x01, slipEnd, 0x02, slipEsc, 0x03}
+	frame := slipEncode(packet)
+	if want := []byte{slipEnd, 0x01, slipEsc, slipEscEnd, 0x02, slipEsc, slipEscEsc, 0x03, slipEnd}; !bytes.Equal(frame, want) {
+		t.Fatalf("encoded % x, want % x", frame, want)
+	}
+	// Boot messages around the frame and a frame split across reads.
+	var d slipDecoder
+	got := d.feed(append([]byte("ESP-ROM:esp32c3\r\n"), frame[:4]...))
+	got = append(got, d.feed(append(frame[4:], "waiting for download\r\n"...))...)
+	if len(got) != 1 || !bytes.Equal(got[0], packet) {
+		t.Errorf("decoded %q", got)
+	}
+}
+
+func TestSLIP_BackToBackFrames(t *testing.T) {
+	var d slipDecoder
+	stream := append(slipEncode([]byte{1}), slipEncode([]byte{2})...)
+	got := d.feed(stream)
+	if len(got) != 2 || got[0][0] != 1 || got[1][0] != 2 {
+		t.Errorf("decoded %v", got)
+	}
+}
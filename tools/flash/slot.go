@@ -0,0 +1,176 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// slotChange is a rewrite of one otadata entry.
+type slotChange struct {
+	sector int
+	entry  otaEntry
+	what   string
+}
+
+// planSlotChange works out the otadata write for one of: booting the slot
+// labelled boot, rolling back to the slot booted before, or marking the
+// booting image valid or invalid. A nil change means there is nothing to do.
+func planSlotChange(st otaState, slots []slotInfo, boot string, rollback bool, mark string) (*slotChange, error) {
+	n := len(st.slots)
+	current, active := bootSlot(st.entries, n)
+	switch {
+	case boot != "":
+		target := -1
+		var labels []string
+		for i, p := range st.slots {
+			labels = append(labels, p.label)
+			if p.label == boot {
+				target = i
+			}
+		}
+		switch {
+		case target < 0:
+			return nil, fmt.Errorf("no OTA slot %q (slots: %s)", boot, strings.Join(labels, ", "))
+		case slots[target].Firmware == nil:
+			return nil, fmt.Errorf("%s holds no app image", boot)
+		case target == current && active >= 0:
+			return nil, nil
+		}
+		sector, e := selectSlot(st.entries, target, n)
+		return &slotChange{sector, e, "boot " + boot}, nil
+
+	case rollback:
+		if active < 0 {
+			return nil, errors.New("otadata is blank, so the first slot boots and there is no earlier selection to roll back to")
+		}
+		other := st.entries[1-active]
+		if !other.valid() {
+			return nil, errors.New("the other otadata entry is not valid, so there is no earlier selection to roll back to; use -boot")
+		}
+		prev := int((other.seq - 1) % uint32(n))
+		switch {
+		case prev == current:
+			return nil, fmt.Errorf("the earlier selection was also %s; use -boot", st.slots[current].label)
+		case slots[prev].Firmware == nil:
+			return nil, fmt.Errorf("the earlier selection, %s, holds no app image", st.slots[prev].label)
+		}
+		// The bootloader skips an invalid entry and falls back to the other.
+		e := st.entries[active]
+		e.state = otaStateInvalid
+		return &slotChange{active, e, "roll back to " + st.slots[prev].label}, nil
+
+	case mark != "":
+		if active < 0 {
+			return nil, errors.New("otadata is blank; there is no selection to mark")
+		}
+		e := st.entries[active]
+		switch mark {
+		case "valid":
+			e.state = otaStateValid
+		case "invalid":
+			e.state = otaStateInvalid
+		default:
+			return nil, fmt.Errorf("-mark %q: want valid or invalid", mark)
+		}
+		if e.state == st.entries[active].state {
+			return nil, nil
+		}
+		return &slotChange{active, e, fmt.Sprintf("mark %s %s", st.slots[current].label, mark)}, nil
+	}
+	return nil, nil
+}
+
+// changeSlot applies the change planSlotChange works out, if any, and
+// returns the slots as they then are.
+func changeSlot(l *loader, boot string, rollback bool, mark string) ([]slotInfo, bool, error) {
+	read := func(offset, size uint32) ([]byte, error) { return l.readFlash(offset, size, nil) }
+	st, err := readOTAState(read)
+	if err != nil {
+		return nil, false, err
+	}
+	slots, err := readSlots(read, st)
+	if err != nil {
+		return nil, false, err
+	}
+	change, err := planSlotChange(st, slots, boot, rollback, mark)
+	if err != nil || change == nil {
+		return slots, false, err
+	}
+	sector := append(encodeOTAEntry(change.entry), bytes.Repeat([]byte{0xFF}, otaSectorSize-otaEntrySize)...)
+	offset := st.otadata.offset + uint32(change.sector)*otaSectorSize
+	if err := l.writeFlash(offset, sector, nil); err != nil {
+		return nil, false, err
+	}
+	if err := l.verify(offset, sector); err != nil {
+		return nil, false, err
+	}
+	fmt.Fprintf(os.Stderr, "Updated otadata to %s.\n", change.what)
+	st.entries[change.sector] = change.entry
+	slots, err = readSlots(read, st)
+	return slots, true, err
+}
+
+// runSlot implements "slot [-boot label | -rollback | -mark valid|invalid]".
+func runSlot(args []string) int {
+	fs := flag.NewFlagSet("slot", flag.ContinueOnError)
+	portFlag := fs.String("port", "", "serial port (auto-detected when a single ESP32 board is plugged in)")
+	baudFlag := fs.Int("baud", 115200, "baud rate to reach the bootloader at")
+	flashSizeFlag := fs.String("flash-size", "16MB", "size of the board's flash chip")
+	bootFlag := fs.String("boot", "", "boot the app in this OTA slot, e.g. app0, from now on")
+	rollbackFlag := fs.Bool("rollback", false, "go back to the slot that booted before the last update or switch")
+	markFlag := fs.String("mark", "", "mark the booting image valid or invalid")
+	resetFlag := fs.Bool("reset", true, "reset the board when done")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: %s slot [-boot label | -rollback | -mark valid|invalid] [flags]\n\n", os.Args[0])
+		fmt.Fprintf(fs.Output(), "Shows which OTA slot boots, or changes it by rewriting otadata. Only\n")
+		fmt.Fprintf(fs.Output(), "otadata is written; the apps in the slots are left as they are.\n\n")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	actions := 0
+	for _, set := range []bool{*bootFlag != "", *rollbackFlag, *markFlag != ""} {
+		if set {
+			actions++
+		}
+	}
+	if fs.NArg() != 0 || actions > 1 {
+		fs.Usage()
+		return 2
+	}
+	flashSize, err := parseFlashSize(*flashSizeFlag)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "-flash-size: %v\n", err)
+		return 2
+	}
+	name := *portFlag
+	if name == "" {
+		if name, err = detectPort(); err != nil {
+			fmt.Fprintf(os.Stderr, "Auto-detect failed: %v\n", err)
+			return 1
+		}
+		fmt.Fprintf(os.Stderr, "Auto-detected port: %s\n", name)
+	}
+	s, err := connect(name, *baudFlag, 0, flashSize)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		return 1
+	}
+	defer s.port.Close()
+	slots, changed, err := changeSlot(s.loader, *bootFlag, *rollbackFlag, *markFlag)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		return 1
+	}
+	if actions > 0 && !changed {
+		fmt.Fprintf(os.Stderr, "Nothing to change.\n")
+	}
+	printSlots(os.Stdout, slots)
+	s.finish(*resetFlag)
+	return 0
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+// slotROM is a fake board with two small OTA slots, app0 holding SUMI and
+// app1 SumiBoy, and blank otadata.
+func slotROM() *fakeROM {
+	rom := newFakeROM()
+	copy(rom.flash[partitionTableOffset:], encodePartitionTable([]partition{
+		{label: "nvs", ptype: partitionTypeData, sub: 0x02, offset: 0x9000, size: 0x5000},
+		{label: "otadata", ptype: partitionTypeData, sub: 0x00, offset: 0xE000, size: 0x2000},
+		{label: "app0", ptype: partitionTypeApp, sub: 0x10, offset: 0x10000, size: 0x40000},
+		{label: "app1", ptype: partitionTypeApp, sub: 0x11, offset: 0x50000, size: 0x40000},
+	}))
+	copy(rom.flash[0x10000:], appWithDesc("SUMI", "0.6.4"))
+	copy(rom.flash[0x50000:], appWithDesc("sumiboy", "1.1.0"))
+	return rom
+}
+
+func bootLabel(slots []slotInfo) string {
+	for _, s := range slots {
+		if s.Boots {
+			return s.Label + " " + s.State
+		}
+	}
+	return ""
+}
+
+func TestChangeSlot(t *testing.T) {
+	l := newLoader(slotROM())
+	steps := []struct {
+		boot     string
+		rollback bool
+		mark     string
+		want     string
+		changed  bool
+	}{
+		{"", false, "", "app0 ", false},
+		{"app1", false, "", "app1 undefined", true},
+		{"app1", false, "", "app1 undefined", false},
+		{"", false, "valid", "app1 valid", true},
+		{"app0", false, "", "app0 undefined", true},
+		{"", true, "", "app1 valid", true},
+	}
+	for i, s := range steps {
+		slots, changed, err := changeSlot(l, s.boot, s.rollback, s.mark)
+		if err != nil {
+			t.Fatalf("step %d: %v", i, err)
+		}
+		if got := bootLabel(slots); got != s.want || changed != s.changed {
+			t.Errorf("step %d: boots %q (changed %v), want %q (changed %v)", i, got, changed, s.want, s.changed)
+		}
+	}
+	// The entry that selected app0 is now invalid: nothing to go back to.
+	if _, _, err := changeSlot(l, "", true, ""); err == nil {
+		t.Error("second rollback: expected error")
+	}
+}
+
+func TestPlanSlotChange_Errors(t *testing.T) {
+	rom := slotROM()
+	l := newLoader(rom)
+	read := func(offset, size uint32) ([]byte, error) { return l.readFlash(offset, size, nil) }
+	st, err := readOTAState(read)
+	if err != nil {
+		t.Fatal(err)
+	}
+	slots, _ := readSlots(read, st)
+	tests := []struct {
+		boot     string
+		rollback bool
+		mark     string
+		want     string
+	}{
+		{"app2", false, "", `no OTA slot "app2" (slots: app0, app1)`},
+		{"", true, "", "otadata is blank"},
+		{"", false, "valid", "otadata is blank"},
+	}
+	for _, tt := range tests {
+		if _, err := planSlotChange(st, slots, tt.boot, tt.rollback, tt.mark); err == nil || !strings.HasPrefix(err.Error(), tt.want) {
+			t.Errorf("planSlotChange(%q, %v, %q) = %v, want %q", tt.boot, tt.rollback, tt.mark, err, tt.want)
+		}
+	}
+	slots[1].Firmware = nil
+	if _, err := planSlotChange(st, slots, "app1", false, ""); err == nil || err.Error() != "app1 holds no app image" {
+		t.Errorf("empty slot: got %v", err)
+	}
+}
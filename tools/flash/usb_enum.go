@@ -0,0 +1,17 @@
+//go:build !darwin || cgo
+
+package main
+
+import "go.bug.st/serial/enumerator"
+
+func listPortDetails() ([]portDetail, error) {
+	ports, err := enumerator.GetDetailedPortsList()
+	if err != nil {
+		return nil, err
+	}
+	details := make([]portDetail, len(ports))
+	for i, p := range ports {
+		details[i] = portDetail{name: p.Name, usb: p.IsUSB, vid: p.VID, pid: p.PID}
+	}
+	return details, nil
+}
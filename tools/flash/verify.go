@@ -0,0 +1,122 @@
+package main
+
+import (
+	"crypto/md5"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"sort"
+)
+
+// verifyRegion is a part of a written image that is checked on its own, so a
+// failure names the partition it hit.
+type verifyRegion struct {
+	label  string
+	offset uint32
+	data   []byte
+}
+
+// flashArea is a named range of flash.
+type flashArea struct {
+	label      string
+	start, end uint32
+}
+
+// flashAreas are the bootloader, the partition table and parts, in flash
+// order.
+func flashAreas(parts []partition) []flashArea {
+	areas := []flashArea{
+		{"bootloader", 0, partitionTableOffset},
+		{"partition table", partitionTableOffset, firstPartitionOffset},
+	}
+	for _, p := range parts {
+		areas = append(areas, flashArea{p.label, p.offset, p.offset + p.size})
+	}
+	sort.SliceStable(areas, func(i, j int) bool { return areas[i].start < areas[j].start })
+	return areas
+}
+
+// verifyRegions splits images at the boundaries of the areas in parts. Data
+// outside every area is labelled "unpartitioned", or by the image's name when
+// the partition table is unknown.
+func verifyRegions(images []flashImage, parts []partition) []verifyRegion {
+	areas := flashAreas(parts)
+	var regions []verifyRegion
+	for _, img := range images {
+		start, end := img.offset, img.offset+uint32(len(img.data))
+		cuts := []uint32{start, end}
+		for _, a := range areas {
+			for _, c := range []uint32{a.start, a.end} {
+				if c > start && c < end {
+					cuts = append(cuts, c)
+				}
+			}
+		}
+		sort.Slice(cuts, func(i, j int) bool { return cuts[i] < cuts[j] })
+		for i := 0; i+1 < len(cuts); i++ {
+			from, to := cuts[i], cuts[i+1]
+			if from == to {
+				continue
+			}
+			label := "unpartitioned"
+			if parts == nil {
+				label = img.path
+			}
+			for _, a := range areas {
+				if from >= a.start && to <= a.end {
+					label = a.label
+					break
+				}
+			}
+			data := img.data[from-start : to-start]
+			if n := len(regions); n > 0 && regions[n-1].label == label && regions[n-1].offset+uint32(len(regions[n-1].data)) == from {
+				regions[n-1].data = img.data[regions[n-1].offset-start : to-start]
+				continue
+			}
+			regions = append(regions, verifyRegion{label: label, offset: from, data: data})
+		}
+	}
+	return regions
+}
+
+// imagePartitions returns the partition table that images write, or nil if
+// they write none.
+func imagePartitions(images []flashImage) []partition {
+	for _, img := range images {
+		if img.offset > partitionTableOffset || uint64(img.offset)+uint64(len(img.data)) < partitionTableOffset+partitionTableSize {
+			continue
+		}
+		table := img.data[partitionTableOffset-img.offset:][:partitionTableSize]
+		if parts, err := parsePartitionTable(table); err == nil {
+			return parts
+		}
+	}
+	return nil
+}
+
+// verifyImages compares the flash's MD5 of each region of images with the
+// images', writing a pass or fail line per region to w. It fails if any
+// region does.
+func verifyImages(l *loader, images []flashImage, parts []partition, w io.Writer) error {
+	regions := verifyRegions(images, parts)
+	failed := 0
+	for _, r := range regions {
+		result := "OK"
+		got, err := l.flashMD5(r.offset, uint32(len(r.data)))
+		want := md5.Sum(r.data)
+		switch {
+		case err != nil:
+			result = fmt.Sprintf("FAILED (%v)", err)
+		case got != hex.EncodeToString(want[:]):
+			result = fmt.Sprintf("FAILED (flash MD5 %s, image MD5 %x)", got, want)
+		}
+		if result != "OK" {
+			failed++
+		}
+		fmt.Fprintf(w, "  %-15s 0x%08x %7s  %s\n", r.label, r.offset, humanSize(uint32(len(r.data))), result)
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d of %d regions do not match what was written; flash them again", failed, len(regions))
+	}
+	return nil
+}
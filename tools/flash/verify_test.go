@@ -0,0 +1,78 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+// mergedImage is a merged image with sumiTable that reaches into app0.
+func mergedImage() []byte {
+	img := testImage()[:0x9000]
+	img = append(img, bytes.Repeat([]byte{0xFF}, 0x12000-len(img))...)
+	img[0] = imageMagic
+	copy(img[partitionTableOffset:], sumiTable())
+	return img
+}
+
+func regionStrings(regions []verifyRegion) []string {
+	var out []string
+	for _, r := range regions {
+		out = append(out, fmt.Sprintf("%s@0x%x+0x%x", r.label, r.offset, len(r.data)))
+	}
+	return out
+}
+
+func TestVerifyRegions(t *testing.T) {
+	merged := []flashImage{{path: "full.bin", offset: 0, data: mergedImage()}}
+	parts := imagePartitions(merged)
+	if len(parts) != 5 {
+		t.Fatalf("imagePartitions found %d partitions, want 5", len(parts))
+	}
+	tests := []struct {
+		images []flashImage
+		parts  []partition
+		want   string
+	}{
+		{merged, parts, "bootloader@0x0+0x8000 partition table@0x8000+0x1000 nvs@0x9000+0x5000 otadata@0xe000+0x2000 app0@0x10000+0x2000"},
+		{
+			[]flashImage{{path: "otadata", offset: otadataOffset, data: make([]byte, otadataSize)}, {path: "firmware.bin", offset: appOffset, data: make([]byte, 100)}},
+			parts, "otadata@0xe000+0x2000 app0@0x10000+0x64",
+		},
+		{[]flashImage{{path: "blob.bin", offset: 0xFFF000, data: make([]byte, 0x2000)}}, parts, "unpartitioned@0xfff000+0x2000"},
+		{[]flashImage{{path: "firmware.bin", offset: appOffset, data: make([]byte, 100)}}, nil, "firmware.bin@0x10000+0x64"},
+	}
+	for _, tt := range tests {
+		if got := strings.Join(regionStrings(verifyRegions(tt.images, tt.parts)), " "); got != tt.want {
+			t.Errorf("verifyRegions(%s) = %s\nwant %s", tt.images[0].path, got, tt.want)
+		}
+	}
+}
+
+func TestVerifyImages(t *testing.T) {
+	rom := newFakeROM()
+	l := newLoader(rom)
+	img := mergedImage()
+	if err := l.writeFlash(0, img, nil); err != nil {
+		t.Fatal(err)
+	}
+	images := []flashImage{{path: "full.bin", offset: 0, data: img}}
+	var out bytes.Buffer
+	if err := verifyImages(l, images, imagePartitions(images), &out); err != nil {
+		t.Fatalf("%v\n%s", err, out.String())
+	}
+	if n := strings.Count(out.String(), " OK\n"); n != 5 {
+		t.Errorf("%d regions passed, want 5:\n%s", n, out.String())
+	}
+
+	rom.flash[0x10010] ^= 1
+	out.Reset()
+	err := verifyImages(l, images, imagePartitions(images), &out)
+	if err == nil || !strings.HasPrefix(err.Error(), "1 of 5 regions") {
+		t.Errorf("corrupted app0: got %v", err)
+	}
+	if !strings.Contains(out.String(), "app0            0x00010000      8K  FAILED (flash MD5") {
+		t.Errorf("no failure line for app0:\n%s", out.String())
+	}
+}
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// Where a full flash dump keeps its partition table, and the partition
+// subtypes LittleFS is stored under.
+const (
+	partitionTableOffset = 0x8000
+	partitionTableSize   = 0xC00
+	imageMagic           = 0xE9
+	subtypeSPIFFS        = 0x82
+	subtypeLittleFS      = 0x83
+)
+
+// littleFSPartition returns the LittleFS partition of a full flash dump, or
+// the data itself when it is not a dump.
+func littleFSPartition(data []byte) ([]byte, error) {
+	if !isDump(data) {
+		return data, nil
+	}
+	table := data[partitionTableOffset:min(len(data), partitionTableOffset+partitionTableSize)]
+	for off := 0; off+32 <= len(table) && table[off] == 0xAA && table[off+1] == 0x50; off += 32 {
+		e := table[off : off+32]
+		if e[2] != 0x01 || e[3] != subtypeSPIFFS && e[3] != subtypeLittleFS {
+			continue
+		}
+		start, size := binary.LittleEndian.Uint32(e[4:]), binary.LittleEndian.Uint32(e[8:])
+		label := strings.TrimRight(string(e[12:28]), "\x00")
+		if uint64(start)+uint64(size) > uint64(len(data)) {
+			return nil, fmt.Errorf("dump ends before the end of partition %s at 0x%x", label, start+size)
+		}
+		fmt.Fprintf(os.Stderr, "Reading partition %s at 0x%x (%d bytes)\n", label, start, size)
+		return data[start : start+size], nil
+	}
+	return nil, errors.New("flash dump has no LittleFS partition")
+}
+
+// extractTree writes every file under the directory at pair to dir, or only
+// lists them when dir is empty. Files that cannot be read are reported and
+// skipped, so one damaged file does not hold up the rest. It returns the
+// number of files written and of failures.
+func extractTree(r *lfsReader, pair [2]uint32, rel, dir string, seen map[[2]uint32]bool) (files, failed int) {
+	if seen[pair] {
+		fmt.Fprintf(os.Stderr, "%s: directory already visited, skipped\n", rel)
+		return 0, 1
+	}
+	seen[pair] = true
+	entries, err := r.readDir(pair)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s/: %v\n", rel, err)
+		return 0, 1
+	}
+	for _, e := range entries {
+		// Names come from the image; keep them inside dir.
+		if e.name == "" || e.name == "." || e.name == ".." || strings.ContainsAny(e.name, `/\`) {
+			fmt.Fprintf(os.Stderr, "%s: bad name %q, skipped\n", rel, e.name)
+			failed++
+			continue
+		}
+		name := path.Join(rel, e.name)
+		if e.nameType == lfsTypeDir {
+			child, err := e.dirPair()
+			if err == nil && dir != "" {
+				err = os.MkdirAll(filepath.Join(dir, filepath.FromSlash(name)), 0755)
+			}
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "%s/: %v\n", name, err)
+				failed++
+				continue
+			}
+			f, bad := extractTree(r, child, name, dir, seen)
+			files, failed = files+f, failed+bad
+			continue
+		}
+		data, err := r.readFile(e)
+		if err == nil && dir != "" {
+			err = os.WriteFile(filepath.Join(dir, filepath.FromSlash(name)), data, 0644)
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s: %v\n", name, err)
+			failed++
+			continue
+		}
+		fmt.Printf("%10d %s\n", len(data), name)
+		files++
+	}
+	return files, failed
+}
+
+// runExtract implements "extract [-o dir] [-l] <littlefs.bin|dump.bin>".
+func runExtract(args []string) int {
+	fs := flag.NewFlagSet("extract", flag.ContinueOnError)
+	outFlag := fs.String("o", "littlefs", "directory to extract into")
+	listFlag := fs.Bool("l", false, "only list the files")
+	blockSizeFlag := fs.Uint("block-size", 0, "filesystem block size (default: detected from the superblock)")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: %s extract [-o dir] [-l] <littlefs.bin|dump.bin>\n", os.Args[0])
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return 2
+	}
+	data, err := os.ReadFile(fs.Arg(0))
+	if err == nil {
+		data, err = littleFSPartition(data)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		return 1
+	}
+	r, err := newLFSReader(data, uint32(*blockSizeFlag))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s: %v\n", fs.Arg(0), err)
+		return 1
+	}
+	dir := *outFlag
+	if *listFlag {
+		dir = ""
+	} else if err := os.MkdirAll(dir, 0755); err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		return 1
+	}
+	files, failed := extractTree(r, [2]uint32{0, 1}, "", dir, map[[2]uint32]bool{})
+	switch {
+	case failed > 0:
+		fmt.Fprintf(os.Stderr, "%d files recovered, %d entries could not be read\n", files, failed)
+		return 1
+	case !*listFlag:
+		fmt.Fprintf(os.Stderr, "Extracted %d files to %s\n", files, dir)
+	}
+	return 0
+}
+
+// isDump reports whether data looks like a full flash dump rather than a
+// partition image.
+func isDump(data []byte) bool {
+	return len(data) > partitionTableOffset+2 && data[0] == imageMagic &&
+		bytes.Equal(data[partitionTableOffset:partitionTableOffset+2], []byte{0xAA, 0x50})
+}
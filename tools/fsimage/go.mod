@@ -0,0 +1,3 @@
+module sumi-fsimage
+
+go 1.21
@@ -0,0 +1,265 @@
+package main
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math/bits"
+)
+
+// lfsTypeDelete removes an id; images the firmware has written to have them.
+const lfsTypeDelete = 0x4FF
+
+// lfsReader reads files out of a littlefs image.
+type lfsReader struct {
+	image      []byte
+	blockSize  uint32
+	blockCount uint32
+}
+
+// lfsEntry is one id of a metadata pair: its name tag and struct tag.
+type lfsEntry struct {
+	nameType   uint32
+	name       string
+	structType uint32
+	structData []byte
+}
+
+// lfsMeta is a metadata pair as of its last valid commit.
+type lfsMeta struct {
+	entries []lfsEntry
+	tail    [2]uint32
+	split   bool // tail continues this directory
+}
+
+// newLFSReader checks the superblock and takes the geometry from it. A
+// blockSize of 0 tries the usual sizes.
+func newLFSReader(image []byte, blockSize uint32) (*lfsReader, error) {
+	sizes := []uint32{blockSize}
+	if blockSize == 0 {
+		sizes = []uint32{4096, 512, 1024, 2048, 8192, 16384}
+	}
+	for _, bs := range sizes {
+		if bs == 0 || len(image) < int(2*bs) || len(image)%int(bs) != 0 {
+			continue
+		}
+		r := &lfsReader{image: image, blockSize: bs, blockCount: uint32(len(image)) / bs}
+		m, err := r.fetch([2]uint32{0, 1})
+		if err != nil || len(m.entries) == 0 || m.entries[0].nameType != lfsTypeSuperblock || m.entries[0].name != lfsMagic {
+			continue
+		}
+		sb := m.entries[0].structData
+		if len(sb) < lfsSuperblockBytes {
+			return nil, errors.New("superblock is truncated")
+		}
+		version := binary.LittleEndian.Uint32(sb)
+		if version>>16 != lfsDiskVersion>>16 {
+			return nil, fmt.Errorf("littlefs disk version %d.%d is not supported", version>>16, version&0xFFFF)
+		}
+		if got := binary.LittleEndian.Uint32(sb[4:]); got != bs {
+			continue
+		}
+		if n := binary.LittleEndian.Uint32(sb[8:]); n < r.blockCount {
+			r.blockCount = n
+		}
+		return r, nil
+	}
+	return nil, errors.New("no littlefs superblock found")
+}
+
+func (r *lfsReader) block(n uint32) ([]byte, error) {
+	if n >= r.blockCount {
+		return nil, fmt.Errorf("block %d is outside the filesystem", n)
+	}
+	return r.image[n*r.blockSize:][:r.blockSize], nil
+}
+
+// lfsTagRecord is a tag of a valid commit with its data.
+type lfsTagRecord struct {
+	typ, id uint32
+	data    []byte
+}
+
+// commits returns the tags of a metadata block's valid commits, and whether
+// it has any. Reading stops at the first tag that is invalid or fails its
+// commit's CRC, as the rest was never completely written.
+func (r *lfsReader) commits(block []byte) ([]lfsTagRecord, bool) {
+	var valid, pending []lfsTagRecord
+	ok := false
+	crc := lfsCRC(0xFFFFFFFF, block[:4])
+	ptag := uint32(0xFFFFFFFF)
+	for off := 4; off+4 <= len(block); {
+		tag := binary.BigEndian.Uint32(block[off:]) ^ ptag
+		typ, id, size := tag>>20&0x7FF, tag>>10&0x3FF, tag&0x3FF
+		dsize := 4 + int(size)
+		if size == 0x3FF { // a deleted attribute has no data
+			dsize = 4
+		}
+		if tag>>31 != 0 || off+dsize > len(block) {
+			break
+		}
+		crc = lfsCRC(crc, block[off:off+4])
+		if typ&0x700 == lfsTypeCRC && typ != lfsTypeCRC|0xFF {
+			if size < 4 || binary.LittleEndian.Uint32(block[off+4:]) != crc {
+				break
+			}
+			valid = append(valid, pending...)
+			pending, ok = nil, true
+			ptag = tag ^ (typ&1)<<31
+			crc = 0xFFFFFFFF
+			off += dsize
+			continue
+		}
+		crc = lfsCRC(crc, block[off+4:off+dsize])
+		pending = append(pending, lfsTagRecord{typ: typ, id: id, data: block[off+4 : off+dsize]})
+		ptag = tag
+		off += dsize
+	}
+	return valid, ok
+}
+
+// fetch reads a metadata pair from whichever block holds the newer valid
+// commits.
+func (r *lfsReader) fetch(pair [2]uint32) (*lfsMeta, error) {
+	var best []lfsTagRecord
+	var bestRev uint32
+	found := false
+	for _, n := range pair {
+		block, err := r.block(n)
+		if err != nil {
+			return nil, err
+		}
+		rev := binary.LittleEndian.Uint32(block)
+		tags, ok := r.commits(block)
+		if !ok {
+			continue
+		}
+		// Revisions wrap, so compare them as a signed difference.
+		if !found || int32(rev-bestRev) > 0 {
+			best, bestRev, found = tags, rev, true
+		}
+	}
+	if !found {
+		return nil, fmt.Errorf("metadata pair %d,%d holds no valid commit", pair[0], pair[1])
+	}
+
+	m := &lfsMeta{tail: [2]uint32{lfsBlockNull, lfsBlockNull}}
+	grow := func(id uint32) {
+		for uint32(len(m.entries)) <= id {
+			m.entries = append(m.entries, lfsEntry{})
+		}
+	}
+	for _, t := range best {
+		switch {
+		case t.typ == lfsTypeCreate:
+			if t.id > uint32(len(m.entries)) {
+				grow(t.id - 1)
+			}
+			m.entries = append(m.entries[:t.id], append([]lfsEntry{{}}, m.entries[t.id:]...)...)
+		case t.typ == lfsTypeDelete:
+			if t.id < uint32(len(m.entries)) {
+				m.entries = append(m.entries[:t.id], m.entries[t.id+1:]...)
+			}
+		case t.typ&0x700 == 0 && t.id != lfsIDNone: // a name
+			grow(t.id)
+			m.entries[t.id].nameType, m.entries[t.id].name = t.typ, string(t.data)
+		case t.typ&0x700 == lfsTypeDirStruct && t.id != lfsIDNone:
+			grow(t.id)
+			m.entries[t.id].structType, m.entries[t.id].structData = t.typ, t.data
+		case t.typ == lfsTypeSoftTail || t.typ == lfsTypeHardTail:
+			if len(t.data) >= 8 {
+				m.tail = [2]uint32{binary.LittleEndian.Uint32(t.data), binary.LittleEndian.Uint32(t.data[4:])}
+				m.split = t.typ == lfsTypeHardTail
+			}
+		}
+	}
+	return m, nil
+}
+
+// readDir lists a directory, following the pairs it is split across.
+func (r *lfsReader) readDir(pair [2]uint32) ([]lfsEntry, error) {
+	var entries []lfsEntry
+	seen := map[[2]uint32]bool{}
+	for {
+		if seen[pair] {
+			return nil, fmt.Errorf("directory pairs loop at %d,%d", pair[0], pair[1])
+		}
+		seen[pair] = true
+		m, err := r.fetch(pair)
+		if err != nil {
+			return nil, err
+		}
+		for _, e := range m.entries {
+			if e.nameType == lfsTypeReg || e.nameType == lfsTypeDir {
+				entries = append(entries, e)
+			}
+		}
+		if !m.split {
+			return entries, nil
+		}
+		pair = m.tail
+	}
+}
+
+// dirPair is the metadata pair a directory entry points to.
+func (e lfsEntry) dirPair() ([2]uint32, error) {
+	if e.structType != lfsTypeDirStruct || len(e.structData) < 8 {
+		return [2]uint32{}, fmt.Errorf("%s: directory has no metadata pair", e.name)
+	}
+	return [2]uint32{binary.LittleEndian.Uint32(e.structData), binary.LittleEndian.Uint32(e.structData[4:])}, nil
+}
+
+// ctzCapacity is how much data block index i of a file holds: all of block
+// 0, and the rest after the block's skip-list pointers.
+func ctzCapacity(i int, blockSize uint32) int {
+	if i == 0 {
+		return int(blockSize)
+	}
+	return int(blockSize) - 4*(bits.TrailingZeros(uint(i))+1)
+}
+
+// readFile returns a file entry's contents.
+func (r *lfsReader) readFile(e lfsEntry) ([]byte, error) {
+	switch e.structType {
+	case lfsTypeInline:
+		return e.structData, nil
+	case lfsTypeCTZ:
+	default:
+		return nil, fmt.Errorf("%s: unknown file struct 0x%03x", e.name, e.structType)
+	}
+	if len(e.structData) < 8 {
+		return nil, fmt.Errorf("%s: truncated file struct", e.name)
+	}
+	head, size := binary.LittleEndian.Uint32(e.structData), int(binary.LittleEndian.Uint32(e.structData[4:]))
+	if size == 0 {
+		return []byte{}, nil
+	}
+	if size > len(r.image) {
+		return nil, fmt.Errorf("%s: size %d is larger than the filesystem", e.name, size)
+	}
+	// Index of the last block, then its predecessors by their first pointer.
+	last, total := 0, ctzCapacity(0, r.blockSize)
+	for total < size {
+		last++
+		total += ctzCapacity(last, r.blockSize)
+	}
+	blocks := make([]uint32, last+1)
+	blocks[last] = head
+	for i := last; i > 0; i-- {
+		b, err := r.block(blocks[i])
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", e.name, err)
+		}
+		blocks[i-1] = binary.LittleEndian.Uint32(b)
+	}
+	data := make([]byte, 0, size)
+	for i, n := range blocks {
+		b, err := r.block(n)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", e.name, err)
+		}
+		skip := int(r.blockSize) - ctzCapacity(i, r.blockSize)
+		data = append(data, b[skip:skip+min(size-len(data), ctzCapacity(i, r.blockSize))]...)
+	}
+	return data, nil
+}
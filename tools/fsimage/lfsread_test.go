@@ -0,0 +1,193 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// bigConfig has room for testTree's files, one block each.
+var bigConfig = lfsConfig{blockSize: 4096, blockCount: 256, progSize: 256, nameMax: 64}
+
+// testTree is a storage partition's worth of nested files.
+func testTree() *fsNode {
+	big := make([]byte, 3*4096+100)
+	for i := range big {
+		big[i] = byte(i * 13)
+	}
+	books := &fsNode{name: "books", dir: true}
+	for i := 0; i < 120; i++ { // enough to split the directory
+		books.children = append(books.children, &fsNode{name: fmt.Sprintf("book-%03d.epub", i), data: []byte(fmt.Sprint(i))})
+	}
+	return &fsNode{dir: true, children: []*fsNode{
+		books,
+		{name: "empty.txt"},
+		{name: "fonts", dir: true, children: []*fsNode{{name: "serif.bin", data: big}}},
+	}}
+}
+
+// collect reads every file in the image into a map by path.
+func collect(t *testing.T, r *lfsReader, pair [2]uint32, prefix string, out map[string][]byte) {
+	t.Helper()
+	entries, err := r.readDir(pair)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, e := range entries {
+		if e.nameType == lfsTypeDir {
+			child, err := e.dirPair()
+			if err != nil {
+				t.Fatal(err)
+			}
+			collect(t, r, child, prefix+e.name+"/", out)
+			continue
+		}
+		data, err := r.readFile(e)
+		if err != nil {
+			t.Fatal(err)
+		}
+		out[prefix+e.name] = data
+	}
+}
+
+func flatten(n *fsNode, prefix string, out map[string][]byte) {
+	for _, c := range n.children {
+		if c.dir {
+			flatten(c, prefix+c.name+"/", out)
+		} else {
+			out[prefix+c.name] = c.data
+		}
+	}
+}
+
+func TestReadLittleFS_RoundTrip(t *testing.T) {
+	tree := testTree()
+	image, _, err := buildLittleFS(bigConfig, tree)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r, err := newLFSReader(image, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, want := map[string][]byte{}, map[string][]byte{}
+	collect(t, r, [2]uint32{0, 1}, "", got)
+	flatten(tree, "", want)
+	if len(got) != len(want) {
+		t.Fatalf("read %d files, want %d", len(got), len(want))
+	}
+	for name, data := range want {
+		if !bytes.Equal(got[name], data) {
+			t.Errorf("%s: read %d bytes, want %d", name, len(got[name]), len(data))
+		}
+	}
+}
+
+// TestReadLittleFS_LaterCommits appends commits the way the firmware does:
+// a new inline file, a deleted file, and a torn commit that must be ignored.
+func TestReadLittleFS_LaterCommits(t *testing.T) {
+	root := &fsNode{dir: true, children: []*fsNode{{name: "a.txt", data: []byte("old")}, {name: "b.txt", data: []byte("bee")}}}
+	image, _, err := buildLittleFS(testConfig, root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	block := image[:4096]
+	c := &lfsCommit{buf: block, off: 4, ptag: 0xFFFFFFFF, crc: lfsCRC(0xFFFFFFFF, block[:4])}
+	for _, tag := range copyCommits(t, block) {
+		c.add(tag.typ, tag.id, tag.data)
+	}
+	c.finish(testConfig.progSize)
+	// Delete a.txt (id 1), which moves b.txt to id 1, then create c.txt.
+	c.add(lfsTypeDelete, 1, nil)
+	c.add(lfsTypeCreate, 2, nil)
+	c.add(lfsTypeReg, 2, []byte("c.txt"))
+	c.add(lfsTypeInline, 2, []byte("sea"))
+	c.finish(testConfig.progSize)
+	// A commit cut off by power loss: its CRC is wrong.
+	torn := c.off
+	c.add(lfsTypeDelete, 1, nil)
+	c.finish(testConfig.progSize)
+	block[torn+8] ^= 0xFF
+
+	r, err := newLFSReader(image, 4096)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := map[string][]byte{}
+	collect(t, r, [2]uint32{0, 1}, "", got)
+	if len(got) != 2 || string(got["b.txt"]) != "bee" || string(got["c.txt"]) != "sea" {
+		t.Errorf("files %q", got)
+	}
+}
+
+// copyCommits returns a copy of the tags of a block's commits, so a test can
+// write them again.
+func copyCommits(t *testing.T, block []byte) []lfsTagRecord {
+	t.Helper()
+	tags, ok := (&lfsReader{}).commits(block)
+	if !ok {
+		t.Fatal("no valid commit")
+	}
+	var out []lfsTagRecord
+	for _, tag := range tags {
+		out = append(out, lfsTagRecord{typ: tag.typ, id: tag.id, data: append([]byte(nil), tag.data...)})
+	}
+	return out
+}
+
+func TestReadLittleFS_NewerBlockOfPair(t *testing.T) {
+	image, _, _ := buildLittleFS(testConfig, &fsNode{dir: true, children: []*fsNode{{name: "old.txt"}}})
+	newer, _, _ := buildLittleFS(testConfig, &fsNode{dir: true, children: []*fsNode{{name: "new.txt"}}})
+	// The compacted copy in the pair's second block has a higher revision.
+	copy(image[4096:8192], newer[:4096])
+	binary.LittleEndian.PutUint32(image[4096:], 2)
+	c := &lfsCommit{buf: image[4096:8192], off: 4, ptag: 0xFFFFFFFF}
+	c.crc = lfsCRC(0xFFFFFFFF, c.buf[:4])
+	for _, tag := range copyCommits(t, newer[:4096]) {
+		c.add(tag.typ, tag.id, tag.data)
+	}
+	c.finish(testConfig.progSize)
+
+	r, err := newLFSReader(image, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	entries, _ := r.readDir([2]uint32{0, 1})
+	if len(entries) != 1 || entries[0].name != "new.txt" {
+		t.Errorf("entries %+v", entries)
+	}
+}
+
+func TestExtract_FlashDump(t *testing.T) {
+	image, _, err := buildLittleFS(bigConfig, testTree())
+	if err != nil {
+		t.Fatal(err)
+	}
+	const offset = 0x10000
+	dump := bytes.Repeat([]byte{0xFF}, offset+len(image))
+	dump[0] = imageMagic
+	entry := []byte{0xAA, 0x50, 0x01, subtypeSPIFFS}
+	entry = binary.LittleEndian.AppendUint32(entry, offset)
+	entry = binary.LittleEndian.AppendUint32(entry, uint32(len(image)))
+	entry = append(entry, []byte("spiffs\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00")...)
+	copy(dump[partitionTableOffset:], entry)
+	copy(dump[offset:], image)
+
+	dir := t.TempDir()
+	in := filepath.Join(dir, "dump.bin")
+	os.WriteFile(in, dump, 0644)
+	out := filepath.Join(dir, "out")
+	if code := runExtract([]string{"-o", out, in}); code != 0 {
+		t.Fatalf("exit %d", code)
+	}
+	data, err := os.ReadFile(filepath.Join(out, "fonts", "serif.bin"))
+	if err != nil || len(data) != 3*4096+100 {
+		t.Errorf("serif.bin: %d bytes, %v", len(data), err)
+	}
+	if _, err := os.Stat(filepath.Join(out, "books", "book-119.epub")); err != nil {
+		t.Error(err)
+	}
+}
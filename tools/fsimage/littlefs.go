@@ -0,0 +1,276 @@
+package main
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"math/bits"
+	"sort"
+)
+
+// On-disk littlefs v2.0, as described in littlefs's SPEC.md. Metadata lives
+// in pairs of blocks holding commits of tags; file contents live in CTZ
+// skip-lists of blocks.
+const (
+	lfsDiskVersion = 0x00020000
+	lfsMagic       = "littlefs"
+
+	// Tag types, the 11-bit field of a tag.
+	lfsTypeReg         = 0x001
+	lfsTypeDir         = 0x002
+	lfsTypeSuperblock  = 0x0FF
+	lfsTypeDirStruct   = 0x200
+	lfsTypeInline      = 0x201
+	lfsTypeCTZ         = 0x202
+	lfsTypeCreate      = 0x401
+	lfsTypeCRC         = 0x500
+	lfsTypeSoftTail    = 0x600
+	lfsTypeHardTail    = 0x601
+	lfsIDNone          = 0x3FF // id of tags that belong to no entry
+	lfsBlockNull       = 0xFFFFFFFF
+	lfsSuperblockBytes = 24
+	lfsFileMax         = 2147483647
+	lfsAttrMax         = 1022
+)
+
+// lfsConfig is the geometry an image is built for. It must match what the
+// firmware mounts the partition with, or the mount fails.
+type lfsConfig struct {
+	blockSize  uint32
+	blockCount uint32
+	// progSize aligns commits, so the firmware can append to a metadata
+	// block right after the image's commit.
+	progSize uint32
+	nameMax  uint32
+}
+
+// lfsTag packs a tag; the valid bit is 0 for a valid tag.
+func lfsTag(typ, id, size uint32) uint32 {
+	return typ<<20 | id<<10 | size
+}
+
+// lfsCRC is littlefs's CRC-32: the IEEE polynomial without the final
+// inversion, so a running value carries between calls.
+func lfsCRC(crc uint32, data []byte) uint32 {
+	return ^crc32.Update(^crc, crc32.IEEETable, data)
+}
+
+// fsNode is a file or directory to put in the image.
+type fsNode struct {
+	name     string
+	dir      bool
+	data     []byte
+	children []*fsNode // sorted by name
+}
+
+// lfsBuilder lays out an image: metadata pairs for each directory, then the
+// blocks of each file.
+type lfsBuilder struct {
+	cfg   lfsConfig
+	image []byte
+	next  uint32 // next free block
+}
+
+func (b *lfsBuilder) block(n uint32) []byte {
+	return b.image[n*b.cfg.blockSize:][:b.cfg.blockSize]
+}
+
+func (b *lfsBuilder) alloc() (uint32, error) {
+	if b.next >= b.cfg.blockCount {
+		return 0, fmt.Errorf("contents do not fit in %d blocks of %d bytes", b.cfg.blockCount, b.cfg.blockSize)
+	}
+	b.next++
+	return b.next - 1, nil
+}
+
+// writeCTZ stores data in a CTZ skip-list and returns its head, the last
+// block. Block i points back to blocks i-1, i-2, i-4, ... i-2^ctz(i).
+func (b *lfsBuilder) writeCTZ(data []byte) (uint32, error) {
+	var blocks []uint32
+	for i := 0; len(data) > 0; i++ {
+		n, err := b.alloc()
+		if err != nil {
+			return 0, err
+		}
+		buf := b.block(n)
+		off := 0
+		if i > 0 {
+			for k := 0; k <= bits.TrailingZeros(uint(i)); k++ {
+				binary.LittleEndian.PutUint32(buf[off:], blocks[i-1<<k])
+				off += 4
+			}
+		}
+		data = data[copy(buf[off:], data):]
+		blocks = append(blocks, n)
+	}
+	return blocks[len(blocks)-1], nil
+}
+
+// lfsCommit builds one metadata commit in a block.
+type lfsCommit struct {
+	buf  []byte
+	off  int
+	ptag uint32
+	crc  uint32
+}
+
+// newCommit starts the first commit of an erased metadata block.
+func newCommit(buf []byte) *lfsCommit {
+	binary.LittleEndian.PutUint32(buf, 1) // revision count
+	return &lfsCommit{buf: buf, off: 4, ptag: 0xFFFFFFFF, crc: lfsCRC(0xFFFFFFFF, buf[:4])}
+}
+
+// add appends a tag and its data. Tags are stored big-endian, each XORed
+// with the one before.
+func (c *lfsCommit) add(typ, id uint32, data []byte) {
+	tag := lfsTag(typ, id, uint32(len(data)))
+	binary.BigEndian.PutUint32(c.buf[c.off:], tag^c.ptag)
+	copy(c.buf[c.off+4:], data)
+	c.crc = lfsCRC(c.crc, c.buf[c.off:c.off+4+len(data)])
+	c.off += 4 + len(data)
+	c.ptag = tag
+}
+
+// finish ends the commit with a CRC tag whose length pads the commit to a
+// multiple of progSize. The padding is erased flash and outside the CRC. A
+// further commit can then be added after it.
+func (c *lfsCommit) finish(progSize uint32) {
+	end := (c.off + 8 + int(progSize) - 1) / int(progSize) * int(progSize)
+	tag := lfsTag(lfsTypeCRC, lfsIDNone, uint32(end-c.off-4))
+	binary.BigEndian.PutUint32(c.buf[c.off:], tag^c.ptag)
+	c.crc = lfsCRC(c.crc, c.buf[c.off:c.off+4])
+	binary.LittleEndian.PutUint32(c.buf[c.off+4:], c.crc)
+	c.off, c.ptag, c.crc = end, tag, 0xFFFFFFFF
+}
+
+// entryCost is the metadata an entry takes: its create, name and struct tags.
+func entryCost(n *fsNode) int {
+	cost := 4 + 4 + len(n.name) + 4 + 8
+	if !n.dir && len(n.data) == 0 {
+		cost -= 8 // an empty file is an empty inline struct
+	}
+	return cost
+}
+
+// lfsDir is one directory's metadata pairs. A directory too large for one
+// pair continues in the next through a hard tail.
+type lfsDir struct {
+	node   *fsNode
+	chunks [][]*fsNode
+	pairs  [][2]uint32
+}
+
+// buildLittleFS returns an image of cfg's geometry holding the tree under
+// root, and the number of blocks it uses.
+func buildLittleFS(cfg lfsConfig, root *fsNode) ([]byte, uint32, error) {
+	if cfg.blockSize < 128 || cfg.blockCount < 2 || cfg.progSize == 0 || cfg.blockSize%cfg.progSize != 0 {
+		return nil, 0, errors.New("bad filesystem geometry")
+	}
+	b := &lfsBuilder{cfg: cfg, image: make([]byte, int(cfg.blockSize)*int(cfg.blockCount))}
+	for i := range b.image {
+		b.image[i] = 0xFF
+	}
+
+	// Directories in breadth-first order, which is also the order of the
+	// threaded list of metadata pairs the allocator walks.
+	dirs := []*lfsDir{{node: root}}
+	for i := 0; i < len(dirs); i++ {
+		for _, c := range dirs[i].node.children {
+			if uint32(len(c.name)) > cfg.nameMax {
+				return nil, 0, fmt.Errorf("%s: name longer than %d bytes", c.name, cfg.nameMax)
+			}
+			if c.dir {
+				dirs = append(dirs, &lfsDir{node: c})
+			}
+		}
+	}
+	// Each pair's commit stays within half a block, leaving the rest for
+	// the firmware's own commits before it has to compact.
+	budget := int(cfg.blockSize)/2 - 4 - 12 - 8 - int(cfg.progSize)
+	for i, d := range dirs {
+		used := 0
+		if i == 0 {
+			used = 4 + 4 + len(lfsMagic) + 4 + lfsSuperblockBytes
+		}
+		var chunk []*fsNode
+		for _, c := range d.node.children {
+			if cost := entryCost(c); used+cost > budget && len(chunk) > 0 {
+				d.chunks = append(d.chunks, chunk)
+				chunk, used = nil, 0
+			}
+			chunk = append(chunk, c)
+			used += entryCost(c)
+		}
+		d.chunks = append(d.chunks, chunk)
+		for range d.chunks {
+			var pair [2]uint32
+			for j := range pair {
+				n, err := b.alloc()
+				if err != nil {
+					return nil, 0, err
+				}
+				pair[j] = n
+			}
+			d.pairs = append(d.pairs, pair)
+		}
+	}
+	firstPair := map[*fsNode][2]uint32{}
+	for _, d := range dirs {
+		firstPair[d.node] = d.pairs[0]
+	}
+
+	for i, d := range dirs {
+		for j, chunk := range d.chunks {
+			c := newCommit(b.block(d.pairs[j][0]))
+			id := uint32(0)
+			if i == 0 && j == 0 {
+				sb := make([]byte, lfsSuperblockBytes)
+				for k, v := range []uint32{lfsDiskVersion, cfg.blockSize, cfg.blockCount, cfg.nameMax, lfsFileMax, lfsAttrMax} {
+					binary.LittleEndian.PutUint32(sb[4*k:], v)
+				}
+				c.add(lfsTypeCreate, id, nil)
+				c.add(lfsTypeSuperblock, id, []byte(lfsMagic))
+				c.add(lfsTypeInline, id, sb)
+				id++
+			}
+			for _, n := range chunk {
+				c.add(lfsTypeCreate, id, nil)
+				switch {
+				case n.dir:
+					pair := firstPair[n]
+					c.add(lfsTypeDir, id, []byte(n.name))
+					c.add(lfsTypeDirStruct, id, binary.LittleEndian.AppendUint32(binary.LittleEndian.AppendUint32(nil, pair[0]), pair[1]))
+				case len(n.data) == 0:
+					c.add(lfsTypeReg, id, []byte(n.name))
+					c.add(lfsTypeInline, id, nil)
+				default:
+					head, err := b.writeCTZ(n.data)
+					if err != nil {
+						return nil, 0, err
+					}
+					c.add(lfsTypeReg, id, []byte(n.name))
+					c.add(lfsTypeCTZ, id, binary.LittleEndian.AppendUint32(binary.LittleEndian.AppendUint32(nil, head), uint32(len(n.data))))
+				}
+				id++
+			}
+			tailType, tail := uint32(lfsTypeHardTail), [2]uint32{lfsBlockNull, lfsBlockNull}
+			switch {
+			case j+1 < len(d.pairs):
+				tail = d.pairs[j+1]
+			case i+1 < len(dirs):
+				tailType, tail = lfsTypeSoftTail, dirs[i+1].pairs[0]
+			}
+			if tail[0] != lfsBlockNull {
+				c.add(tailType, lfsIDNone, binary.LittleEndian.AppendUint32(binary.LittleEndian.AppendUint32(nil, tail[0]), tail[1]))
+			}
+			c.finish(cfg.progSize)
+		}
+	}
+	return b.image, b.next, nil
+}
+
+// sortChildren orders a directory's entries as littlefs keeps them.
+func sortChildren(n *fsNode) {
+	sort.Slice(n.children, func(i, j int) bool { return n.children[i].name < n.children[j].name })
+}
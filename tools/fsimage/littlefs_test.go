@@ -0,0 +1,140 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+var testConfig = lfsConfig{blockSize: 4096, blockCount: 64, progSize: 256, nameMax: 64}
+
+// commitTags walks the first commit of a metadata block, checking its CRC,
+// and returns its tags as "type id size" strings and their data.
+func commitTags(t *testing.T, block []byte) ([]string, [][]byte) {
+	t.Helper()
+	crc := lfsCRC(0xFFFFFFFF, block[:4])
+	ptag := uint32(0xFFFFFFFF)
+	var tags []string
+	var data [][]byte
+	for off := 4; off+4 <= len(block); {
+		tag := binary.BigEndian.Uint32(block[off:]) ^ ptag
+		if tag>>31 != 0 {
+			t.Fatalf("invalid tag at %d before the commit's CRC", off)
+		}
+		typ, id, size := tag>>20&0x7FF, tag>>10&0x3FF, tag&0x3FF
+		crc = lfsCRC(crc, block[off:off+4])
+		if typ == lfsTypeCRC {
+			if got := binary.LittleEndian.Uint32(block[off+4:]); got != crc {
+				t.Fatalf("commit CRC 0x%08x, computed 0x%08x", got, crc)
+			}
+			if (off+4+int(size))%int(testConfig.progSize) != 0 {
+				t.Errorf("commit ends at %d, not on a program boundary", off+4+int(size))
+			}
+			return tags, data
+		}
+		crc = lfsCRC(crc, block[off+4:off+4+int(size)])
+		tags = append(tags, fmt.Sprintf("%03x %d %d", typ, id, size))
+		data = append(data, block[off+4:off+4+int(size)])
+		ptag = tag
+		off += 4 + int(size)
+	}
+	t.Fatal("commit has no CRC tag")
+	return nil, nil
+}
+
+func TestBuildLittleFS_Superblock(t *testing.T) {
+	root := &fsNode{dir: true, children: []*fsNode{
+		{name: "empty.txt"},
+		{name: "fonts", dir: true},
+	}}
+	image, used, err := buildLittleFS(testConfig, root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(image) != 64*4096 || used != 4 {
+		t.Fatalf("image is %d bytes using %d blocks", len(image), used)
+	}
+	tags, data := commitTags(t, image[:4096])
+	want := []string{
+		"401 0 0", "0ff 0 8", "201 0 24", // superblock
+		"401 1 0", "001 1 9", "201 1 0", // empty.txt
+		"401 2 0", "002 2 5", "200 2 8", // fonts
+		"600 1023 8", // soft tail to fonts
+	}
+	if strings.Join(tags, ", ") != strings.Join(want, ", ") {
+		t.Errorf("root tags\n%v\nwant\n%v", tags, want)
+	}
+	if string(data[1]) != lfsMagic || binary.LittleEndian.Uint32(data[2][8:]) != 64 {
+		t.Errorf("bad superblock %q %x", data[1], data[2])
+	}
+	if pair := data[8]; binary.LittleEndian.Uint32(pair) != 2 || binary.LittleEndian.Uint32(pair[4:]) != 3 {
+		t.Errorf("fonts is at %x, want blocks 2 and 3", pair)
+	}
+	// The second block of each pair is left erased.
+	if !bytes.Equal(image[4096:2*4096], bytes.Repeat([]byte{0xFF}, 4096)) {
+		t.Error("block 1 is not erased")
+	}
+	if tags, _ := commitTags(t, image[2*4096:3*4096]); len(tags) != 0 {
+		t.Errorf("empty directory has tags %v", tags)
+	}
+}
+
+func TestBuildLittleFS_CTZ(t *testing.T) {
+	data := make([]byte, 5*4096)
+	for i := range data {
+		data[i] = byte(i * 7)
+	}
+	root := &fsNode{dir: true, children: []*fsNode{{name: "book.epub", data: data}}}
+	image, used, err := buildLittleFS(testConfig, root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, attrs := commitTags(t, image[:4096])
+	ctz := attrs[len(attrs)-1]
+	head, size := binary.LittleEndian.Uint32(ctz), binary.LittleEndian.Uint32(ctz[4:])
+	// Blocks 2-7 hold the file: 4096 + 4092 + 4088 + 4092 + 4084 bytes fill
+	// five, and the last 28 bytes spill into a sixth.
+	if size != uint32(len(data)) || head != 7 || used != 8 {
+		t.Fatalf("head %d size %d used %d", head, size, used)
+	}
+	block := func(n uint32) []byte { return image[n*4096 : (n+1)*4096] }
+	// Block index 4 points back to indexes 3, 2 and 0.
+	var ptrs []uint32
+	for k := 0; k < 3; k++ {
+		ptrs = append(ptrs, binary.LittleEndian.Uint32(block(6)[4*k:]))
+	}
+	if fmt.Sprint(ptrs) != "[5 4 2]" {
+		t.Errorf("index 4 points to %v", ptrs)
+	}
+	if !bytes.Equal(block(2), data[:4096]) || !bytes.Equal(block(3)[4:], data[4096:8188]) {
+		t.Error("file data misplaced")
+	}
+}
+
+func TestBuildLittleFS_SplitsLargeDirectory(t *testing.T) {
+	root := &fsNode{dir: true}
+	for i := 0; i < 150; i++ {
+		root.children = append(root.children, &fsNode{name: fmt.Sprintf("chapter-%03d.txt", i)})
+	}
+	image, used, err := buildLittleFS(testConfig, root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tags, _ := commitTags(t, image[:4096])
+	if last := tags[len(tags)-1]; last != "601 1023 8" || used < 4 {
+		t.Errorf("root ends with %s using %d blocks; want a hard tail to a second pair", last, used)
+	}
+}
+
+func TestBuildLittleFS_Errors(t *testing.T) {
+	long := &fsNode{dir: true, children: []*fsNode{{name: strings.Repeat("x", 65), data: []byte{1}}}}
+	if _, _, err := buildLittleFS(testConfig, long); err == nil {
+		t.Error("long name: expected error")
+	}
+	big := &fsNode{dir: true, children: []*fsNode{{name: "big", data: make([]byte, 64*4096)}}}
+	if _, _, err := buildLittleFS(testConfig, big); err == nil {
+		t.Error("file larger than the partition: expected error")
+	}
+}
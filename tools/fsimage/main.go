@@ -0,0 +1,170 @@
+// Command sumi-fsimage packs a local directory into a LittleFS image that the
+// firmware mounts from its storage partition, so fonts, books and other files
+// can be provisioned in the same flash step as the firmware. Its extract
+// subcommand does the reverse, recovering files from an image or flash dump.
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// The firmware mounts the "spiffs" partition of partitions.csv as LittleFS
+// with 4K blocks. defaultSize is that partition's size.
+const (
+	defaultSize = 0x360000
+	blockSize   = 0x1000
+	progSize    = 256
+	nameMax     = 64
+)
+
+// readTree loads the directory at path. Files are read into memory; the
+// partition is only a few megabytes.
+func readTree(path string) (*fsNode, error) {
+	root := &fsNode{dir: true}
+	var walk func(dir string, n *fsNode) error
+	walk = func(dir string, n *fsNode) error {
+		entries, err := os.ReadDir(dir)
+		if err != nil {
+			return err
+		}
+		for _, e := range entries {
+			child := &fsNode{name: e.Name(), dir: e.IsDir()}
+			full := filepath.Join(dir, e.Name())
+			switch {
+			case e.IsDir():
+				if err := walk(full, child); err != nil {
+					return err
+				}
+			case e.Type().IsRegular():
+				if child.data, err = os.ReadFile(full); err != nil {
+					return err
+				}
+			default:
+				fmt.Fprintf(os.Stderr, "Skipping %s: not a regular file\n", full)
+				continue
+			}
+			n.children = append(n.children, child)
+		}
+		sortChildren(n)
+		return nil
+	}
+	return root, walk(path, root)
+}
+
+// partitionSize finds the size of the LittleFS partition (subtype spiffs or
+// littlefs) in a partitions.csv.
+func partitionSize(path string) (uint32, error) {
+	text, err := os.ReadFile(path)
+	if err != nil {
+		return 0, err
+	}
+	for _, line := range strings.Split(string(text), "\n") {
+		fields := strings.Split(line, ",")
+		if len(fields) < 5 || strings.HasPrefix(strings.TrimSpace(line), "#") {
+			continue
+		}
+		sub := strings.TrimSpace(fields[2])
+		if strings.TrimSpace(fields[1]) == "data" && (sub == "spiffs" || sub == "littlefs") {
+			return parseSize(fields[4])
+		}
+	}
+	return 0, errors.New("no spiffs or littlefs partition")
+}
+
+// parseSize parses a size such as 0x360000, 3456K or 4M.
+func parseSize(s string) (uint32, error) {
+	s = strings.TrimSpace(s)
+	mult := uint64(1)
+	switch {
+	case strings.HasSuffix(strings.ToUpper(s), "K"):
+		mult, s = 1<<10, s[:len(s)-1]
+	case strings.HasSuffix(strings.ToUpper(s), "M"):
+		mult, s = 1<<20, s[:len(s)-1]
+	}
+	n, err := strconv.ParseUint(s, 0, 32)
+	if err != nil || n*mult > 1<<32-1 {
+		return 0, fmt.Errorf("bad size %q", s)
+	}
+	return uint32(n * mult), nil
+}
+
+// treeStats counts the files and directories under n and their bytes.
+func treeStats(n *fsNode) (files, dirs, bytes int) {
+	for _, c := range n.children {
+		if c.dir {
+			f, d, b := treeStats(c)
+			files, dirs, bytes = files+f, dirs+d+1, bytes+b
+		} else {
+			files, bytes = files+1, bytes+len(c.data)
+		}
+	}
+	return
+}
+
+func main() {
+	if len(os.Args) > 1 && os.Args[1] == "extract" {
+		os.Exit(runExtract(os.Args[2:]))
+	}
+
+	outFlag := flag.String("o", "littlefs.bin", "image file to write")
+	sizeFlag := flag.String("size", "", fmt.Sprintf("partition size (default: from -partitions, else 0x%x)", defaultSize))
+	partitionsFlag := flag.String("partitions", "partitions.csv", "partition table to take the LittleFS partition's size from")
+	flag.Usage = func() {
+		out := flag.CommandLine.Output()
+		fmt.Fprintf(out, "Usage: %s [flags] <directory>\n", os.Args[0])
+		fmt.Fprintf(out, "       %s extract [-o dir] [-l] <littlefs.bin|dump.bin>\n\n", os.Args[0])
+		fmt.Fprintf(out, "Write the image to the storage partition, e.g. with\n")
+		fmt.Fprintf(out, "sumi-flash littlefs.bin@0xc90000.\n\n")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if flag.NArg() != 1 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	size := uint32(defaultSize)
+	var err error
+	switch {
+	case *sizeFlag != "":
+		size, err = parseSize(*sizeFlag)
+	case *partitionsFlag != "":
+		if s, perr := partitionSize(*partitionsFlag); perr == nil {
+			size = s
+		} else if !errors.Is(perr, os.ErrNotExist) {
+			err = fmt.Errorf("%s: %v", *partitionsFlag, perr)
+		}
+	}
+	if err == nil && (size == 0 || size%blockSize != 0) {
+		err = fmt.Errorf("partition size 0x%x is not a multiple of the 0x%x block size", size, blockSize)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		os.Exit(2)
+	}
+
+	root, err := readTree(flag.Arg(0))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		os.Exit(1)
+	}
+	cfg := lfsConfig{blockSize: blockSize, blockCount: size / blockSize, progSize: progSize, nameMax: nameMax}
+	image, used, err := buildLittleFS(cfg, root)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		os.Exit(1)
+	}
+	if err := os.WriteFile(*outFlag, image, 0644); err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		os.Exit(1)
+	}
+	files, dirs, bytes := treeStats(root)
+	fmt.Fprintf(os.Stderr, "Wrote %s: %d files in %d directories, %d bytes; %d of %d blocks used\n",
+		*outFlag, files, dirs, bytes, used, cfg.blockCount)
+}
@@ -0,0 +1,41 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestPartitionSize(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "partitions.csv")
+	os.WriteFile(path, []byte(`# Name,   Type, SubType, Offset,  Size, Flags
+nvs,      data, nvs,     0x9000,  0x5000,
+app0,     app,  ota_0,   0x10000, 0x640000,
+spiffs,   data, spiffs,  0xc90000,0x360000,
+`), 0644)
+	if size, err := partitionSize(path); err != nil || size != 0x360000 {
+		t.Errorf("partitionSize = 0x%x, %v", size, err)
+	}
+}
+
+func TestReadTree(t *testing.T) {
+	dir := t.TempDir()
+	os.MkdirAll(filepath.Join(dir, "fonts"), 0755)
+	os.WriteFile(filepath.Join(dir, "fonts", "serif.bin"), []byte("glyphs"), 0644)
+	os.WriteFile(filepath.Join(dir, "b.txt"), []byte("b"), 0644)
+	os.WriteFile(filepath.Join(dir, "a.txt"), nil, 0644)
+	root, err := readTree(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, c := range root.children {
+		names = append(names, c.name)
+	}
+	if len(names) != 3 || names[0] != "a.txt" || names[1] != "b.txt" || names[2] != "fonts" {
+		t.Errorf("children %v", names)
+	}
+	if files, dirs, bytes := treeStats(root); files != 3 || dirs != 1 || bytes != 7 {
+		t.Errorf("treeStats = %d files, %d dirs, %d bytes", files, dirs, bytes)
+	}
+}
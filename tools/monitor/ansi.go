@@ -0,0 +1,108 @@
+package main
+
+import "strings"
+
+const esc = 0x1b
+
+// ansiState is where an ansiParser is within an escape sequence.
+type ansiState int
+
+const (
+	ansiGround    ansiState = iota // plain text
+	ansiEscape                     // after ESC
+	ansiInter                      // ESC followed by intermediate bytes, e.g. ESC (
+	ansiCSI                        // inside ESC [ ... final byte
+	ansiOSC                        // inside ESC ] ... BEL or ESC \
+	ansiOSCEscape                  // ESC seen inside an OSC string
+)
+
+// ansiParser splits a byte stream into plain text and escape sequences. Its
+// state carries across feed calls, so a sequence cut by a read boundary is
+// still recognized once the rest arrives. CSI (ESC [ ... final byte), OSC
+// (ESC ] ... BEL or ESC \) and short escapes such as ESC ( B are recognized.
+type ansiParser struct {
+	state ansiState
+	seq   []byte // the sequence so far, while state != ansiGround
+}
+
+// feed calls text for each run of plain text in b and seq for each escape
+// sequence completed by b. The slices are only valid during the call.
+func (p *ansiParser) feed(b []byte, text, seq func([]byte)) {
+	start := 0
+	for i, c := range b {
+		if p.state == ansiGround {
+			if c != esc {
+				continue
+			}
+			if start < i {
+				text(b[start:i])
+			}
+			p.state, p.seq = ansiEscape, append(p.seq[:0], c)
+			continue
+		}
+		p.seq = append(p.seq, c)
+		done := false
+		switch p.state {
+		case ansiEscape:
+			switch {
+			case c == '[':
+				p.state = ansiCSI
+			case c == ']':
+				p.state = ansiOSC
+			case c >= 0x20 && c <= 0x2f:
+				p.state = ansiInter
+			default:
+				done = true
+			}
+		case ansiInter:
+			done = c < 0x20 || c > 0x2f
+		case ansiCSI:
+			done = c >= 0x40 && c <= 0x7e
+		case ansiOSC:
+			if c == 0x07 {
+				done = true
+			} else if c == esc {
+				p.state = ansiOSCEscape
+			}
+		case ansiOSCEscape:
+			if c == '\\' {
+				done = true
+			} else if c != esc {
+				p.state = ansiOSC
+			}
+		}
+		if done {
+			seq(p.seq)
+			p.state = ansiGround
+			start = i + 1
+		}
+	}
+	if p.state == ansiGround && start < len(b) {
+		text(b[start:])
+	}
+}
+
+// pending reports whether a sequence has been started but not finished.
+func (p *ansiParser) pending() bool {
+	return p.state != ansiGround
+}
+
+// scanANSI splits s into plain text and escape sequences, calling text for each
+// run of plain text and seq for each complete or trailing partial sequence.
+func scanANSI(s string, text, seq func(string)) {
+	var p ansiParser
+	p.feed([]byte(s), func(b []byte) { text(string(b)) }, func(b []byte) { seq(string(b)) })
+	if p.pending() {
+		seq(string(p.seq))
+	}
+}
+
+// stripANSI removes escape sequences from s, including a trailing partial one.
+func stripANSI(s string) string {
+	if strings.IndexByte(s, esc) < 0 {
+		return s
+	}
+	var b strings.Builder
+	scanANSI(s, func(t string) { b.WriteString(t) }, func(string) {})
+	return b.String()
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestStripANSI(t *testing.T) {
+	cases := map[string]string{
+		"plain":                         "plain",
+		"\x1b[31mred\x1b[0m":            "red",
+		"a\x1b[1;32;40mb":               "ab",
+		"\x1b]0;title\x07after":         "after",
+		"\x1b]0;title\x1b\\after":       "after",
+		"x\x1b(By":                      "xy",
+		"cut off \x1b[3":                "cut off ",
+		"\x1b[?25l[BOOT] ok\x1b[K":      "[BOOT] ok",
+		"\x1b[38;5;208mwarm\x1b[39m ok": "warm ok",
+	}
+	for in, want := range cases {
+		if got := stripANSI(in); got != want {
+			t.Errorf("stripANSI(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
+
+// feedChunks runs s through one parser in chunks of size n and returns the
+// plain text and the sequences it reported.
+func feedChunks(s string, n int) (string, []string) {
+	var p ansiParser
+	var text strings.Builder
+	var seqs []string
+	for i := 0; i < len(s); i += n {
+		end := min(i+n, len(s))
+		p.feed([]byte(s[i:end]), func(b []byte) { text.Write(b) }, func(b []byte) { seqs = append(seqs, string(b)) })
+	}
+	return text.String(), seqs
+}
+
+func TestANSIParser_SplitAcrossReads(t *testing.T) {
+	in := "a\x1b[1;31mred\x1b[0m \x1b]0;title\x1b\\b\x1b(Bc\x1b]2;t\x07d"
+	wantSeqs := []string{"\x1b[1;31m", "\x1b[0m", "\x1b]0;title\x1b\\", "\x1b(B", "\x1b]2;t\x07"}
+	for _, n := range []int{1, 2, 3, 5, len(in)} {
+		text, seqs := feedChunks(in, n)
+		if text != "ared bcd" {
+			t.Errorf("chunk %d: text = %q", n, text)
+		}
+		assertSliceEqual(t, seqs, wantSeqs)
+	}
+}
+
+func TestANSIParser_PendingAtBoundary(t *testing.T) {
+	var p ansiParser
+	var text []string
+	collect := func(b []byte) { text = append(text, string(b)) }
+	p.feed([]byte("x\x1b[3"), collect, func([]byte) {})
+	if !p.pending() {
+		t.Fatal("expected a pending sequence")
+	}
+	p.feed([]byte("2my"), collect, func([]byte) {})
+	if p.pending() {
+		t.Error("sequence should be complete")
+	}
+	assertSliceEqual(t, text, []string{"x", "y"})
+}
@@ -0,0 +1,203 @@
+package main
+
+import (
+	"debug/dwarf"
+	"debug/elf"
+	"errors"
+	"fmt"
+	"io"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+var (
+	// Xtensa panics print "Backtrace: 0x400d1234:0x3ffb5e40 0x400d5678:0x3ffb5e60 ...",
+	// program counter first in each pc:sp pair.
+	backtracePair = regexp.MustCompile(`(0x[0-9a-fA-F]{8}):0x[0-9a-fA-F]{8}`)
+	// RISC-V chips such as SUMI's ESP32-C3 dump registers instead; MEPC is the
+	// faulting instruction and RA the caller.
+	riscvCodeRegister = regexp.MustCompile(`\b(?:MEPC|RA)\s*:\s*(0x[0-9a-fA-F]{8})`)
+)
+
+// crashAddresses returns the code addresses worth decoding in a panic line.
+func crashAddresses(text string) []uint64 {
+	var matches [][]string
+	if strings.Contains(text, "Backtrace:") {
+		matches = backtracePair.FindAllStringSubmatch(text, -1)
+	} else {
+		matches = riscvCodeRegister.FindAllStringSubmatch(text, -1)
+	}
+	var addrs []uint64
+	for _, m := range matches {
+		if a, err := strconv.ParseUint(m[1][2:], 16, 64); err == nil && a != 0 {
+			addrs = append(addrs, a)
+		}
+	}
+	return addrs
+}
+
+// funcRange is one function's code range.
+type funcRange struct {
+	low, high uint64
+	name      string
+}
+
+// lineRow is one row of the DWARF line table.
+type lineRow struct {
+	addr uint64
+	file string
+	line int
+	end  bool // first address past a sequence
+}
+
+// symbolizer maps code addresses in a firmware ELF to function, file and line,
+// like addr2line, using the ELF's DWARF debug info.
+type symbolizer struct {
+	funcs []funcRange // sorted by low
+	rows  []lineRow   // sorted by addr
+}
+
+func loadSymbolizer(path string) (*symbolizer, error) {
+	f, err := elf.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	d, err := f.DWARF()
+	if err != nil {
+		return nil, fmt.Errorf("%s has no usable debug info: %w", path, err)
+	}
+	s := &symbolizer{}
+	if err := s.loadFuncs(d); err != nil {
+		return nil, err
+	}
+	if err := s.loadLines(d); err != nil {
+		return nil, err
+	}
+	if len(s.funcs) == 0 && len(s.rows) == 0 {
+		return nil, errors.New(path + " has no function or line information")
+	}
+	return s, nil
+}
+
+func (s *symbolizer) loadFuncs(d *dwarf.Data) error {
+	r := d.Reader()
+	for {
+		e, err := r.Next()
+		if err != nil {
+			return err
+		}
+		if e == nil {
+			break
+		}
+		if e.Tag != dwarf.TagSubprogram {
+			continue
+		}
+		ranges, err := d.Ranges(e)
+		if err != nil || len(ranges) == 0 {
+			continue
+		}
+		name := subprogramName(d, e)
+		for _, rg := range ranges {
+			s.funcs = append(s.funcs, funcRange{low: rg[0], high: rg[1], name: name})
+		}
+	}
+	sort.Slice(s.funcs, func(i, j int) bool { return s.funcs[i].low < s.funcs[j].low })
+	return nil
+}
+
+// subprogramName returns a function's name, following the declaration or
+// abstract origin that out-of-line C++ methods and inlined copies point to.
+func subprogramName(d *dwarf.Data, e *dwarf.Entry) string {
+	for depth := 0; e != nil && depth < 4; depth++ {
+		if name, ok := e.Val(dwarf.AttrName).(string); ok {
+			return name
+		}
+		ref, ok := e.Val(dwarf.AttrSpecification).(dwarf.Offset)
+		if !ok {
+			if ref, ok = e.Val(dwarf.AttrAbstractOrigin).(dwarf.Offset); !ok {
+				break
+			}
+		}
+		r := d.Reader()
+		r.Seek(ref)
+		e, _ = r.Next()
+	}
+	return "??"
+}
+
+func (s *symbolizer) loadLines(d *dwarf.Data) error {
+	r := d.Reader()
+	for {
+		cu, err := r.Next()
+		if err != nil {
+			return err
+		}
+		if cu == nil {
+			break
+		}
+		if cu.Tag != dwarf.TagCompileUnit {
+			r.SkipChildren()
+			continue
+		}
+		lr, err := d.LineReader(cu)
+		r.SkipChildren()
+		if err != nil || lr == nil {
+			continue
+		}
+		var le dwarf.LineEntry
+		for {
+			if err := lr.Next(&le); err != nil {
+				if err == io.EOF {
+					break
+				}
+				return err
+			}
+			file := ""
+			if le.File != nil {
+				file = le.File.Name
+			}
+			s.rows = append(s.rows, lineRow{addr: le.Address, file: file, line: le.Line, end: le.EndSequence})
+		}
+	}
+	// Where one sequence ends at the address the next begins, the end row
+	// must sort first so the address resolves to the new sequence.
+	sort.SliceStable(s.rows, func(i, j int) bool {
+		a, b := s.rows[i], s.rows[j]
+		return a.addr < b.addr || a.addr == b.addr && a.end && !b.end
+	})
+	return nil
+}
+
+// lookup returns the function, file and line containing pc.
+func (s *symbolizer) lookup(pc uint64) (fn, file string, line int, ok bool) {
+	fn = "??"
+	i := sort.Search(len(s.funcs), func(i int) bool { return s.funcs[i].low > pc }) - 1
+	// Ranges can nest (inlined code), so take the innermost one that still
+	// covers pc, which is the last to start.
+	for ; i >= 0; i-- {
+		if pc < s.funcs[i].high {
+			fn, ok = s.funcs[i].name, true
+			break
+		}
+	}
+	j := sort.Search(len(s.rows), func(j int) bool { return s.rows[j].addr > pc }) - 1
+	if j >= 0 && !s.rows[j].end {
+		file, line, ok = s.rows[j].file, s.rows[j].line, true
+	}
+	return fn, file, line, ok
+}
+
+// decode renders one address the way addr2line -pf does.
+func (s *symbolizer) decode(pc uint64) string {
+	fn, file, line, ok := s.lookup(pc)
+	if !ok {
+		return fmt.Sprintf("0x%08x: ??", pc)
+	}
+	if file == "" {
+		return fmt.Sprintf("0x%08x: %s", pc, fn)
+	}
+	return fmt.Sprintf("0x%08x: %s at %s:%d", pc, fn, file, line)
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"os"
+	"reflect"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+func TestCrashAddresses(t *testing.T) {
+	tests := []struct {
+		line string
+		want []uint64
+	}{
+		{"Backtrace: 0x400d1234:0x3ffb5e40 0x400d5678:0x3ffb5e60 0x00000000:0x00000000", []uint64{0x400d1234, 0x400d5678}},
+		{"MEPC    : 0x42001234  RA      : 0x42005678  SP      : 0x3fc8f0a0", []uint64{0x42001234, 0x42005678}},
+		{"[EPUB] open 0x42001234:0x3ffb5e40", nil},
+		{"[BLE] ready", nil},
+	}
+	for _, tt := range tests {
+		if got := crashAddresses(tt.line); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%q: got %x, want %x", tt.line, got, tt.want)
+		}
+	}
+}
+
+func TestSymbolizer_DecodesOwnBinary(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("test binary is not ELF")
+	}
+	exe, err := os.Executable()
+	if err != nil {
+		t.Skip(err)
+	}
+	sym, err := loadSymbolizer(exe)
+	if err != nil {
+		t.Skip(err)
+	}
+	pc := uint64(reflect.ValueOf(TestCrashAddresses).Pointer())
+	got := sym.decode(pc)
+	if !strings.Contains(got, "TestCrashAddresses") || !strings.Contains(got, "backtrace_test.go:") {
+		t.Errorf("got %q", got)
+	}
+	if got := sym.decode(1); got != "0x00000001: ??" {
+		t.Errorf("unmapped address: got %q", got)
+	}
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"regexp"
+	"sync"
+	"time"
+)
+
+const (
+	// defaultBootBanner matches the ROM banner and the firmware's version line.
+	defaultBootBanner = `ESP-ROM:|Starting SUMI version`
+	// defaultBootReady matches the state machine entering its first state.
+	defaultBootReady = `\[SM\] Initial state`
+)
+
+// bootWatch flags a boot that prints its banner and then goes quiet before
+// reaching the ready state. Unlike a general idle timeout it is only armed once
+// a banner is seen, and a ready line disarms it.
+type bootWatch struct {
+	banner *regexp.Regexp
+	ready  *regexp.Regexp // nil to stay armed until the session ends
+	window time.Duration
+
+	mu    sync.Mutex
+	armed bool
+	last  time.Time
+}
+
+// observe records a line received at now.
+func (w *bootWatch) observe(line string, now time.Time) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	switch {
+	case w.banner.MatchString(line):
+		w.armed = true
+		w.last = now
+	case w.ready != nil && w.ready.MatchString(line):
+		w.armed = false
+	case w.armed:
+		w.last = now
+	}
+}
+
+// hung reports whether the watch is armed and nothing has arrived for window.
+func (w *bootWatch) hung(now time.Time) bool {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.armed && now.Sub(w.last) >= w.window
+}
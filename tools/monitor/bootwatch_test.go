@@ -0,0 +1,51 @@
+package main
+
+import (
+	"regexp"
+	"testing"
+	"time"
+)
+
+func newTestBootWatch() *bootWatch {
+	return &bootWatch{
+		banner: regexp.MustCompile(defaultBootBanner),
+		ready:  regexp.MustCompile(defaultBootReady),
+		window: 5 * time.Second,
+	}
+}
+
+func TestBootWatch_NotArmedWithoutBanner(t *testing.T) {
+	w := newTestBootWatch()
+	t0 := time.Unix(0, 0)
+	w.observe("[HOME] Loaded 3 recent books", t0)
+	if w.hung(t0.Add(time.Hour)) {
+		t.Error("watch fired without a boot banner")
+	}
+}
+
+func TestBootWatch_HangAfterBanner(t *testing.T) {
+	w := newTestBootWatch()
+	t0 := time.Unix(0, 0)
+	w.observe("[12] [   ] Starting SUMI version 0.6.4", t0)
+	w.observe("[40] [FS] LittleFS mounted", t0.Add(time.Second))
+	if w.hung(t0.Add(5 * time.Second)) {
+		t.Error("output after the banner should push the deadline out")
+	}
+	if !w.hung(t0.Add(6 * time.Second)) {
+		t.Error("expected a hang once the window passed without output")
+	}
+}
+
+func TestBootWatch_ReadyDisarms(t *testing.T) {
+	w := newTestBootWatch()
+	t0 := time.Unix(0, 0)
+	w.observe("ESP-ROM:esp32s3-20210327", t0)
+	w.observe("[SM] Initial state: 1", t0.Add(time.Second))
+	if w.hung(t0.Add(time.Minute)) {
+		t.Error("reaching the ready state should disarm the watch")
+	}
+	w.observe("ESP-ROM:esp32s3-20210327", t0.Add(2*time.Minute))
+	if !w.hung(t0.Add(3 * time.Minute)) {
+		t.Error("a new banner should re-arm the watch")
+	}
+}
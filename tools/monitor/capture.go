@@ -0,0 +1,188 @@
+package main
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+)
+
+// A -capture file (.sumicap) records the bytes exchanged with the device in
+// both directions, as they were read and written, with timing precise enough
+// to debug framing in the file-transfer protocol. It starts with
+// captureMagic and the session's wall-clock start in big-endian Unix
+// nanoseconds, followed by records of:
+//
+//	length  4 bytes  big-endian payload length
+//	time    8 bytes  big-endian nanoseconds since the start, from the
+//	                 monotonic clock so wall-clock steps cannot reorder records
+//	dir     1 byte   'R' for bytes from the device, 'T' for bytes sent to it
+//	payload length bytes
+//
+// A record is one read from or one write to the port.
+const captureMagic = "SUMICAP1"
+
+// captureDir maps the direction to its record byte.
+func captureDir(d direction) byte {
+	if d == dirTX {
+		return 'T'
+	}
+	return 'R'
+}
+
+// captureWriter serializes records from the read path and from every writer
+// to the port onto one file.
+type captureWriter struct {
+	mu    sync.Mutex
+	w     io.Writer
+	start time.Time
+}
+
+func newCaptureWriter(w io.Writer, start time.Time) (*captureWriter, error) {
+	head := make([]byte, len(captureMagic)+8)
+	copy(head, captureMagic)
+	binary.BigEndian.PutUint64(head[len(captureMagic):], uint64(start.UnixNano()))
+	if _, err := w.Write(head); err != nil {
+		return nil, err
+	}
+	return &captureWriter{w: w, start: start}, nil
+}
+
+func (c *captureWriter) record(d direction, p []byte) error {
+	var head [13]byte
+	binary.BigEndian.PutUint32(head[0:4], uint32(len(p)))
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	binary.BigEndian.PutUint64(head[4:12], uint64(time.Since(c.start)))
+	head[12] = captureDir(d)
+	if _, err := c.w.Write(head[:]); err != nil {
+		return err
+	}
+	_, err := c.w.Write(p)
+	return err
+}
+
+// Write records p as received, so the writer can sit on an io.TeeReader.
+func (c *captureWriter) Write(p []byte) (int, error) {
+	if err := c.record(dirRX, p); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// captureTap wraps the port connection and records everything written to it.
+type captureTap struct {
+	io.ReadWriteCloser
+	c *captureWriter
+}
+
+func (t *captureTap) Write(p []byte) (int, error) {
+	n, err := t.ReadWriteCloser.Write(p)
+	if n > 0 {
+		t.c.record(dirTX, p[:n])
+	}
+	return n, err
+}
+
+// captureRecord is one record read back from a capture.
+type captureRecord struct {
+	at      time.Duration // since the start of the capture
+	dir     direction
+	payload []byte
+}
+
+// readCapture calls fn for every record in a capture, after reporting the
+// capture's start time through start.
+func readCapture(r io.Reader, start func(time.Time), fn func(captureRecord) error) error {
+	br := bufio.NewReader(r)
+	head := make([]byte, len(captureMagic)+8)
+	if _, err := io.ReadFull(br, head); err != nil || string(head[:len(captureMagic)]) != captureMagic {
+		return errors.New("not a capture (bad magic)")
+	}
+	start(time.Unix(0, int64(binary.BigEndian.Uint64(head[len(captureMagic):]))))
+	var rec [13]byte
+	for {
+		if _, err := io.ReadFull(br, rec[:]); err != nil {
+			if err == io.EOF {
+				return nil
+			}
+			return fmt.Errorf("truncated record header: %w", err)
+		}
+		r := captureRecord{at: time.Duration(binary.BigEndian.Uint64(rec[4:12]))}
+		switch rec[12] {
+		case 'R':
+			r.dir = dirRX
+		case 'T':
+			r.dir = dirTX
+		default:
+			return fmt.Errorf("unknown direction %q", rec[12])
+		}
+		r.payload = make([]byte, binary.BigEndian.Uint32(rec[0:4]))
+		if _, err := io.ReadFull(br, r.payload); err != nil {
+			return fmt.Errorf("truncated record payload: %w", err)
+		}
+		if err := fn(r); err != nil {
+			return err
+		}
+	}
+}
+
+// dumpCapture pretty-prints a capture, one record per line with its time
+// since the start, the gap since the previous record in the same direction
+// and the payload quoted, or as hex rows when hexRecords is set.
+func dumpCapture(r io.Reader, w io.Writer, hexRecords bool) error {
+	bw := bufio.NewWriter(w)
+	defer bw.Flush()
+	var prev [2]time.Duration
+	var offset [2]int64
+	return readCapture(r, func(start time.Time) {
+		fmt.Fprintf(bw, "Capture started %s\n", start.Format(transcriptStamp))
+	}, func(rec captureRecord) error {
+		gap := rec.at - prev[rec.dir]
+		prev[rec.dir] = rec.at
+		fmt.Fprintf(bw, "%12.6f %+10.6f %s %5d", rec.at.Seconds(), gap.Seconds(), rec.dir, len(rec.payload))
+		if !hexRecords {
+			_, err := fmt.Fprintf(bw, " %q\n", rec.payload)
+			return err
+		}
+		bw.WriteByte('\n')
+		for _, row := range hexRows(rec.payload, offset[rec.dir]) {
+			fmt.Fprintf(bw, "    %s\n", row)
+		}
+		offset[rec.dir] += int64(len(rec.payload))
+		return nil
+	})
+}
+
+// runDump implements "dump [-hex] <capture>".
+func runDump(args []string) int {
+	fs := flag.NewFlagSet("dump", flag.ContinueOnError)
+	hexFlag := fs.Bool("hex", false, "show payloads as hex rows, offsets counted per direction")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: %s dump [-hex] <capture-file>\n", os.Args[0])
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return 2
+	}
+	in, err := os.Open(fs.Arg(0))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to open capture: %v\n", err)
+		return 1
+	}
+	defer in.Close()
+	if err := dumpCapture(in, os.Stdout, *hexFlag); err != nil {
+		fmt.Fprintf(os.Stderr, "Dump failed: %v\n", err)
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"strings"
+	"testing"
+	"time"
+)
+
+// nopConn is a port that accepts every write.
+type nopConn struct{ io.Reader }
+
+func (nopConn) Write(p []byte) (int, error) { return len(p), nil }
+func (nopConn) Close() error                { return nil }
+
+func TestCapture_RoundTrip(t *testing.T) {
+	var buf bytes.Buffer
+	start := time.Date(2026, 3, 1, 9, 30, 0, 0, time.UTC)
+	c, err := newCaptureWriter(&buf, start)
+	if err != nil {
+		t.Fatal(err)
+	}
+	port := &captureTap{ReadWriteCloser: nopConn{}, c: c}
+	port.Write([]byte("BLE:PUT 2\n"))
+	c.Write([]byte{0x00, 0xff, '\n'})
+	var got []captureRecord
+	var started time.Time
+	err = readCapture(&buf, func(s time.Time) { started = s }, func(r captureRecord) error {
+		got = append(got, r)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !started.Equal(start) || len(got) != 2 {
+		t.Fatalf("start %v, records %+v", started, got)
+	}
+	if got[0].dir != dirTX || string(got[0].payload) != "BLE:PUT 2\n" || got[1].dir != dirRX || !bytes.Equal(got[1].payload, []byte{0x00, 0xff, '\n'}) {
+		t.Errorf("records %+v", got)
+	}
+	if got[1].at < got[0].at {
+		t.Errorf("times out of order: %v then %v", got[0].at, got[1].at)
+	}
+}
+
+// testCapture builds a capture with fixed record times.
+func testCapture() []byte {
+	var buf bytes.Buffer
+	c, _ := newCaptureWriter(&buf, time.Date(2026, 3, 1, 9, 30, 0, 0, time.UTC))
+	head := buf.Len()
+	c.record(dirTX, []byte("PUT\n"))
+	c.record(dirRX, []byte("OK\r\n"))
+	c.record(dirRX, []byte("\x01\x02"))
+	b := buf.Bytes()
+	// Overwrite the times: 1ms, 2.5ms, 4ms.
+	off := head
+	for _, at := range []time.Duration{time.Millisecond, 2500 * time.Microsecond, 4 * time.Millisecond} {
+		for i := 0; i < 8; i++ {
+			b[off+4+i] = byte(uint64(at) >> (56 - 8*i))
+		}
+		off += 13 + int(b[off+3])
+	}
+	return b
+}
+
+func TestDumpCapture(t *testing.T) {
+	var out strings.Builder
+	if err := dumpCapture(bytes.NewReader(testCapture()), &out, false); err != nil {
+		t.Fatal(err)
+	}
+	want := "Capture started 2026-03-01T09:30:00.000000Z\n" +
+		"    0.001000  +0.001000 TX     4 \"PUT\\n\"\n" +
+		"    0.002500  +0.002500 RX     4 \"OK\\r\\n\"\n" +
+		"    0.004000  +0.001500 RX     2 \"\\x01\\x02\"\n"
+	if out.String() != want {
+		t.Errorf("got\n%s\nwant\n%s", out.String(), want)
+	}
+	out.Reset()
+	if err := dumpCapture(bytes.NewReader(testCapture()), &out, true); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(out.String(), "    00000004  01 02") {
+		t.Errorf("hex rows do not continue the RX offset:\n%s", out.String())
+	}
+	if err := dumpCapture(strings.NewReader("SUMICMB1"), &out, false); err == nil {
+		t.Error("wrong magic: expected error")
+	}
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"fmt"
+	"hash/fnv"
+	"regexp"
+)
+
+// ansiColor is an SGR foreground color code.
+type ansiColor int
+
+const (
+	colorRed     ansiColor = 31
+	colorGreen   ansiColor = 32
+	colorYellow  ansiColor = 33
+	colorBlue    ansiColor = 34
+	colorMagenta ansiColor = 35
+	colorCyan    ansiColor = 36
+
+	colorBrightGreen   ansiColor = 92
+	colorBrightBlue    ansiColor = 94
+	colorBrightMagenta ansiColor = 95
+	colorBrightCyan    ansiColor = 96
+)
+
+// portPalette holds the colors used to tell ports apart. Red and yellow are left
+// out so a port prefix is never mistaken for an error or warning.
+var portPalette = []ansiColor{
+	colorGreen, colorBlue, colorMagenta, colorCyan,
+	colorBrightGreen, colorBrightBlue, colorBrightMagenta, colorBrightCyan,
+}
+
+// colorForPort derives a port's color from an FNV-1a hash of its name, so a board
+// keeps the same color regardless of connection order, run or machine.
+func colorForPort(name string) ansiColor {
+	h := fnv.New32a()
+	h.Write([]byte(name))
+	return portPalette[h.Sum32()%uint32(len(portPalette))]
+}
+
+// wrap surrounds s with the color's SGR sequence and a reset.
+func (c ansiColor) wrap(s string) string {
+	return fmt.Sprintf("\x1b[%dm%s\x1b[0m", int(c), s)
+}
+
+// logLevelPattern matches the level letter of an ESP-IDF log line
+// ("E (1234) wifi: ...") or an Arduino-ESP32 core log line ("[  1234][W][...").
+var logLevelPattern = regexp.MustCompile(`^(?:([EWIDV]) \(\d+\) |\[\s*\d+\]\[([EWIDV])\])`)
+
+// levelColor returns the color for an error or warning log line.
+func levelColor(text string) (ansiColor, bool) {
+	m := logLevelPattern.FindStringSubmatch(text)
+	if m == nil {
+		return 0, false
+	}
+	switch m[1] + m[2] {
+	case "E":
+		return colorRed, true
+	case "W":
+		return colorYellow, true
+	}
+	return 0, false
+}
+
+// useColor resolves -color: auto colors a terminal unless NO_COLOR is set,
+// always and never are unconditional.
+func useColor(mode string, tty bool, noColor bool) (bool, error) {
+	switch mode {
+	case "auto":
+		return tty && !noColor, nil
+	case "always":
+		return true, nil
+	case "never":
+		return false, nil
+	}
+	return false, fmt.Errorf("unknown color mode %q (want auto, always or never)", mode)
+}
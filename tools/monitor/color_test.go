@@ -0,0 +1,93 @@
+package main
+
+import "testing"
+
+func TestColorForPort_Deterministic(t *testing.T) {
+	// Pinned values: these must not change between releases, or logs and
+	// screenshots from different sessions stop matching.
+	tests := map[string]ansiColor{
+		"/dev/ttyACM0":         colorGreen,
+		"/dev/cu.usbmodem1101": colorBrightBlue,
+		"COM3":                 colorBrightCyan,
+	}
+	for name, want := range tests {
+		if got := colorForPort(name); got != want {
+			t.Errorf("colorForPort(%q) = %d, want %d", name, got, want)
+		}
+	}
+}
+
+func TestColorForPort_InPalette(t *testing.T) {
+	for _, name := range []string{"", "/dev/ttyACM0", "/dev/ttyACM1", "COM3", "COM10"} {
+		c := colorForPort(name)
+		found := false
+		for _, p := range portPalette {
+			if p == c {
+				found = true
+			}
+		}
+		if !found || c == colorRed || c == colorYellow {
+			t.Errorf("colorForPort(%q) = %d, not a port palette color", name, c)
+		}
+	}
+}
+
+func TestColorForPort_Spread(t *testing.T) {
+	seen := map[ansiColor]bool{}
+	for _, name := range []string{"/dev/ttyACM0", "/dev/ttyACM1", "/dev/ttyACM2", "/dev/ttyACM3", "/dev/ttyACM4", "/dev/ttyACM5"} {
+		seen[colorForPort(name)] = true
+	}
+	if len(seen) < 3 {
+		t.Errorf("six ports mapped to only %d colors", len(seen))
+	}
+}
+
+func TestAnsiColorWrap(t *testing.T) {
+	if got := colorCyan.wrap("ACM0"); got != "\x1b[36mACM0\x1b[0m" {
+		t.Errorf("got %q", got)
+	}
+}
+
+func TestLevelColor(t *testing.T) {
+	tests := []struct {
+		line string
+		want ansiColor
+		ok   bool
+	}{
+		{"E (1234) wifi: connect failed", colorRed, true},
+		{"W (88) boot: PRO CPU has been reset", colorYellow, true},
+		{"I (5) cpu_start: Starting scheduler", 0, false},
+		{"[  1042][E][sd_diskio.cpp:802] sdcard_mount(): f_mount failed", colorRed, true},
+		{"[     7][W][esp32-hal-psram.c:71] psramInit(): PSRAM not found", colorYellow, true},
+		{"[BLE] Error starting advertising", 0, false},
+		{"E(12) missing space", 0, false},
+	}
+	for _, tt := range tests {
+		got, ok := levelColor(tt.line)
+		if got != tt.want || ok != tt.ok {
+			t.Errorf("levelColor(%q) = %v, %v; want %v, %v", tt.line, got, ok, tt.want, tt.ok)
+		}
+	}
+}
+
+func TestUseColor(t *testing.T) {
+	tests := []struct {
+		mode         string
+		tty, noColor bool
+		want         bool
+	}{
+		{"auto", true, false, true},
+		{"auto", false, false, false},
+		{"auto", true, true, false},
+		{"always", false, true, true},
+		{"never", true, false, false},
+	}
+	for _, tt := range tests {
+		if got, err := useColor(tt.mode, tt.tty, tt.noColor); err != nil || got != tt.want {
+			t.Errorf("useColor(%q, tty=%v, NO_COLOR=%v) = %v, %v", tt.mode, tt.tty, tt.noColor, got, err)
+		}
+	}
+	if _, err := useColor("yes", true, false); err == nil {
+		t.Error("expected error for unknown mode")
+	}
+}
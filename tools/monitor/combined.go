@@ -0,0 +1,186 @@
+package main
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+)
+
+// The -combined file holds raw device bytes and human annotations in one
+// artifact. It starts with combinedMagic, followed by records of:
+//
+//	kind    1 byte   recordRaw or recordAnnotation
+//	time    8 bytes  big-endian Unix nanoseconds
+//	length  4 bytes  big-endian payload length
+//	payload length bytes, copied verbatim
+//
+// Every payload is length-delimited, so raw chunks may contain any byte and
+// both streams can be split back out exactly with the extract subcommand.
+const combinedMagic = "SUMICMB1"
+
+const (
+	recordRaw        byte = 'R'
+	recordAnnotation byte = 'A'
+)
+
+// combinedWriter serializes records from the read path and the sinks onto one file.
+type combinedWriter struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+func newCombinedWriter(w io.Writer) (*combinedWriter, error) {
+	if _, err := io.WriteString(w, combinedMagic); err != nil {
+		return nil, err
+	}
+	return &combinedWriter{w: w}, nil
+}
+
+func (c *combinedWriter) record(kind byte, t time.Time, payload []byte) error {
+	var head [13]byte
+	head[0] = kind
+	binary.BigEndian.PutUint64(head[1:9], uint64(t.UnixNano()))
+	binary.BigEndian.PutUint32(head[9:13], uint32(len(payload)))
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, err := c.w.Write(head[:]); err != nil {
+		return err
+	}
+	_, err := c.w.Write(payload)
+	return err
+}
+
+// Write records p as a raw chunk, so the writer can sit on an io.TeeReader.
+func (c *combinedWriter) Write(p []byte) (int, error) {
+	if err := c.record(recordRaw, time.Now(), p); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// annotate records a free-form annotation such as a session notice.
+func (c *combinedWriter) annotate(text string) error {
+	return c.record(recordAnnotation, time.Now(), []byte(text))
+}
+
+// combinedSink records each formatted line as an annotation.
+type combinedSink struct {
+	c *combinedWriter
+}
+
+func (s combinedSink) Write(ev LineEvent) error {
+	return s.c.record(recordAnnotation, ev.Time, []byte(formatText(ev)))
+}
+
+// readCombined calls fn for every record in a combined file.
+func readCombined(r io.Reader, fn func(kind byte, t time.Time, payload []byte) error) error {
+	br := bufio.NewReader(r)
+	magic := make([]byte, len(combinedMagic))
+	if _, err := io.ReadFull(br, magic); err != nil || string(magic) != combinedMagic {
+		return errors.New("not a combined capture (bad magic)")
+	}
+	var head [13]byte
+	for {
+		if _, err := io.ReadFull(br, head[:]); err != nil {
+			if err == io.EOF {
+				return nil
+			}
+			return fmt.Errorf("truncated record header: %w", err)
+		}
+		kind := head[0]
+		t := time.Unix(0, int64(binary.BigEndian.Uint64(head[1:9])))
+		payload := make([]byte, binary.BigEndian.Uint32(head[9:13]))
+		if _, err := io.ReadFull(br, payload); err != nil {
+			return fmt.Errorf("truncated record payload: %w", err)
+		}
+		if kind != recordRaw && kind != recordAnnotation {
+			return fmt.Errorf("unknown record kind %q", kind)
+		}
+		if err := fn(kind, t, payload); err != nil {
+			return err
+		}
+	}
+}
+
+// splitCombined writes the raw and annotation streams of a combined file to
+// separate writers; either may be nil to discard that stream.
+func splitCombined(r io.Reader, raw, text io.Writer) error {
+	return readCombined(r, func(kind byte, _ time.Time, payload []byte) error {
+		w := text
+		if kind == recordRaw {
+			w = raw
+		}
+		if w == nil {
+			return nil
+		}
+		_, err := w.Write(payload)
+		return err
+	})
+}
+
+// runExtract implements "extract [-raw file] [-text file] <combined>".
+func runExtract(args []string) int {
+	fs := flag.NewFlagSet("extract", flag.ContinueOnError)
+	rawFlag := fs.String("raw", "", "write the raw device bytes to this file")
+	textFlag := fs.String("text", "", "write the annotation text to this file (- for stdout)")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: %s extract [-raw file] [-text file] <combined-file>\n", os.Args[0])
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if fs.NArg() != 1 || (*rawFlag == "" && *textFlag == "") {
+		fs.Usage()
+		return 2
+	}
+	in, err := os.Open(fs.Arg(0))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to open capture: %v\n", err)
+		return 1
+	}
+	defer in.Close()
+
+	var raw, text io.Writer
+	var outputs []*os.File
+	open := func(path string) (io.Writer, error) {
+		if path == "-" {
+			return os.Stdout, nil
+		}
+		f, err := os.Create(path)
+		if err != nil {
+			return nil, err
+		}
+		outputs = append(outputs, f)
+		return f, nil
+	}
+	if *rawFlag != "" {
+		if raw, err = open(*rawFlag); err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to create %s: %v\n", *rawFlag, err)
+			return 1
+		}
+	}
+	if *textFlag != "" {
+		if text, err = open(*textFlag); err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to create %s: %v\n", *textFlag, err)
+			return 1
+		}
+	}
+	err = splitCombined(in, raw, text)
+	for _, f := range outputs {
+		if cerr := f.Close(); err == nil {
+			err = cerr
+		}
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Extract failed: %v\n", err)
+		return 1
+	}
+	return 0
+}
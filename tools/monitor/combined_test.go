@@ -0,0 +1,75 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCombined_RoundTrip(t *testing.T) {
+	var file bytes.Buffer
+	c, err := newCombinedWriter(&file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Raw chunks deliberately contain newlines, NULs and bytes that look like
+	// record headers; annotations contain multi-byte text.
+	rawChunks := [][]byte{
+		[]byte("ESP-ROM:esp32s3\r\n[BOOT] Boot"),
+		{0x00, 'A', 0xff, 0x00, 0x00, 0x00, 0x05, '\n'},
+		[]byte(" count: 1\r\n"),
+	}
+	annotations := []string{"Monitoring /dev/ttyACM0 at 115200 baud\n", "[BOOT] Boot count: 1\n", "日本語\n"}
+	c.annotate(annotations[0])
+	c.Write(rawChunks[0])
+	c.Write(rawChunks[1])
+	combinedSink{c}.Write(LineEvent{Time: time.Now(), Text: "[BOOT] Boot count: 1"})
+	c.Write(rawChunks[2])
+	c.annotate(annotations[2])
+
+	var raw, text bytes.Buffer
+	if err := splitCombined(bytes.NewReader(file.Bytes()), &raw, &text); err != nil {
+		t.Fatal(err)
+	}
+	if want := bytes.Join(rawChunks, nil); !bytes.Equal(raw.Bytes(), want) {
+		t.Errorf("raw stream = %q, want %q", raw.Bytes(), want)
+	}
+	if want := strings.Join(annotations, ""); text.String() != want {
+		t.Errorf("text stream = %q, want %q", text.String(), want)
+	}
+}
+
+func TestCombined_RecordOrderAndTime(t *testing.T) {
+	var file bytes.Buffer
+	c, _ := newCombinedWriter(&file)
+	t0 := time.Unix(1700000000, 123456789)
+	c.record(recordRaw, t0, []byte("a"))
+	c.record(recordAnnotation, t0.Add(time.Millisecond), []byte("b"))
+	var kinds []byte
+	var times []time.Time
+	err := readCombined(&file, func(kind byte, ts time.Time, _ []byte) error {
+		kinds = append(kinds, kind)
+		times = append(times, ts)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(kinds) != "RA" || !times[0].Equal(t0) || !times[1].Equal(t0.Add(time.Millisecond)) {
+		t.Errorf("kinds %q times %v", kinds, times)
+	}
+}
+
+func TestCombined_Malformed(t *testing.T) {
+	if err := splitCombined(strings.NewReader("not a capture"), nil, nil); err == nil {
+		t.Error("expected bad magic error")
+	}
+	var file bytes.Buffer
+	c, _ := newCombinedWriter(&file)
+	c.Write([]byte("truncated payload"))
+	short := file.Bytes()[:file.Len()-3]
+	if err := splitCombined(bytes.NewReader(short), nil, nil); err == nil {
+		t.Error("expected truncated record error")
+	}
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"bytes"
+	"debug/elf"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+// ESP-IDF prints a core dump sent to the UART between these markers, as
+// base64 lines of the raw dump: a small header, the ELF core file and a
+// checksum.
+const (
+	coreDumpStart = "CORE DUMP START"
+	coreDumpEnd   = "CORE DUMP END"
+)
+
+// coreDumpCollector gathers a core dump printed to the console and saves its
+// ELF core file in dir, instead of letting the base64 scroll past.
+type coreDumpCollector struct {
+	dir    string
+	now    func() time.Time
+	onSave func(path string) // called after a core file is written; may be nil
+	open   bool
+	b64    strings.Builder
+}
+
+// observe consumes the lines of a core dump and returns true, with a notice
+// to show in place of the start and end markers. Other lines are left alone.
+func (c *coreDumpCollector) observe(text string) (consumed bool, notice string) {
+	switch {
+	case strings.Contains(text, coreDumpStart):
+		c.open = true
+		c.b64.Reset()
+		return true, "[COREDUMP] Capturing core dump..."
+	case !c.open:
+		return false, ""
+	case strings.Contains(text, coreDumpEnd):
+		c.open = false
+		path, size, err := c.save()
+		if err != nil {
+			return true, fmt.Sprintf("[COREDUMP] Core dump not saved: %v", err)
+		}
+		if c.onSave != nil {
+			c.onSave(path)
+		}
+		return true, fmt.Sprintf("[COREDUMP] Core dump saved to %s (%d bytes)", path, size)
+	}
+	c.b64.WriteString(strings.TrimSpace(text))
+	return true, ""
+}
+
+// save decodes the collected base64 and writes the ELF core file inside it.
+func (c *coreDumpCollector) save() (path string, size int, err error) {
+	raw, err := base64.StdEncoding.DecodeString(c.b64.String())
+	if err != nil {
+		return "", 0, fmt.Errorf("bad base64: %v", err)
+	}
+	core, err := coreDumpELF(raw)
+	if err != nil {
+		return "", 0, err
+	}
+	if err := os.MkdirAll(c.dir, 0o755); err != nil {
+		return "", 0, err
+	}
+	now := time.Now
+	if c.now != nil {
+		now = c.now
+	}
+	path = stampedPath(c.dir, "coredump", ".elf", now())
+	return path, len(core), os.WriteFile(path, core, 0o644)
+}
+
+// coreDumpELF cuts the ELF core file out of a raw dump, dropping the ESP-IDF
+// header before it and the checksum after it.
+func coreDumpELF(raw []byte) ([]byte, error) {
+	start := bytes.Index(raw, []byte(elf.ELFMAG))
+	if start < 0 {
+		return nil, errors.New("no ELF core file in the dump (only the ELF core dump format is supported)")
+	}
+	core := raw[start:]
+	f, err := elf.NewFile(bytes.NewReader(core))
+	if err != nil {
+		return nil, fmt.Errorf("bad ELF core file: %v", err)
+	}
+	defer f.Close()
+	if f.Type != elf.ET_CORE {
+		return nil, fmt.Errorf("dump holds an ELF %v, not a core file", f.Type)
+	}
+	// The file ends with whichever of its headers, segments or section
+	// header table ends last.
+	ehsize, phentsize, shentsize := uint64(52), uint64(32), uint64(40)
+	phoff, shoff := uint64(f.ByteOrder.Uint32(core[28:])), uint64(f.ByteOrder.Uint32(core[32:]))
+	if f.Class == elf.ELFCLASS64 {
+		ehsize, phentsize, shentsize = 64, 56, 64
+		phoff, shoff = f.ByteOrder.Uint64(core[32:]), f.ByteOrder.Uint64(core[40:])
+	}
+	end := max(ehsize, phoff+uint64(len(f.Progs))*phentsize)
+	if len(f.Sections) > 0 {
+		end = max(end, shoff+uint64(len(f.Sections))*shentsize)
+	}
+	for _, p := range f.Progs {
+		end = max(end, p.Off+p.Filesz)
+	}
+	if end > uint64(len(core)) {
+		return nil, fmt.Errorf("core file truncated: %d of %d bytes", len(core), end)
+	}
+	return core[:end], nil
+}
+
+// coreDumpInfo runs ESP-IDF's core dump tool on a saved core against the
+// firmware ELF, writing its report (crashed task, registers, backtraces) to w.
+func coreDumpInfo(core, firmware string, w io.Writer) error {
+	args := []string{"info_corefile", "--core", core, "--core-format", "elf", firmware}
+	for _, tool := range []string{"esp-coredump", "espcoredump.py"} {
+		if path, err := exec.LookPath(tool); err == nil {
+			cmd := exec.Command(path, args...)
+			cmd.Stdout, cmd.Stderr = w, w
+			return cmd.Run()
+		}
+	}
+	return errors.New("esp-coredump not found on PATH (pip install esp-coredump)")
+}
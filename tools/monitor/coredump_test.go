@@ -0,0 +1,85 @@
+package main
+
+import (
+	"bytes"
+	"debug/elf"
+	"encoding/base64"
+	"encoding/binary"
+	"os"
+	"testing"
+	"time"
+)
+
+// tinyCore builds a 32-bit RISC-V ELF core file with one note segment.
+func tinyCore() []byte {
+	var b bytes.Buffer
+	hdr := elf.Header32{
+		Type: uint16(elf.ET_CORE), Machine: uint16(elf.EM_RISCV), Version: uint32(elf.EV_CURRENT),
+		Phoff: 52, Ehsize: 52, Phentsize: 32, Phnum: 1, Shentsize: 40,
+	}
+	copy(hdr.Ident[:], elf.ELFMAG)
+	hdr.Ident[elf.EI_CLASS] = byte(elf.ELFCLASS32)
+	hdr.Ident[elf.EI_DATA] = byte(elf.ELFDATA2LSB)
+	hdr.Ident[elf.EI_VERSION] = byte(elf.EV_CURRENT)
+	binary.Write(&b, binary.LittleEndian, hdr)
+	binary.Write(&b, binary.LittleEndian, elf.Prog32{Type: uint32(elf.PT_NOTE), Off: 84, Filesz: 16})
+	b.Write([]byte("note payload 16!"))
+	return b.Bytes()
+}
+
+func TestCoreDumpCollector(t *testing.T) {
+	dir := t.TempDir()
+	var saved []string
+	c := &coreDumpCollector{
+		dir:    dir,
+		now:    func() time.Time { return time.Date(2026, 3, 1, 9, 30, 0, 0, time.Local) },
+		onSave: func(path string) { saved = append(saved, path) },
+	}
+	core := tinyCore()
+	// The ESP-IDF header before the core file and the checksum after it.
+	raw := append(append(make([]byte, 20), core...), 0xde, 0xad, 0xbe, 0xef)
+	b64 := base64.StdEncoding.EncodeToString(raw)
+
+	if consumed, _ := c.observe("[BOOT] ready"); consumed {
+		t.Error("ordinary line consumed before a dump")
+	}
+	lines := []string{"================= CORE DUMP START ================="}
+	for len(b64) > 0 {
+		n := min(76, len(b64))
+		lines = append(lines, b64[:n]+"\r")
+		b64 = b64[n:]
+	}
+	lines = append(lines, "================= CORE DUMP END =================")
+	var notices []string
+	for _, l := range lines {
+		consumed, notice := c.observe(l)
+		if !consumed {
+			t.Fatalf("dump line %q not consumed", l)
+		}
+		if notice != "" {
+			notices = append(notices, notice)
+		}
+	}
+	path := dir + string(os.PathSeparator) + "coredump-20260301-093000.elf"
+	assertSliceEqual(t, notices, []string{
+		"[COREDUMP] Capturing core dump...",
+		"[COREDUMP] Core dump saved to " + path + " (100 bytes)",
+	})
+	assertSliceEqual(t, saved, []string{path})
+	got, err := os.ReadFile(path)
+	if err != nil || !bytes.Equal(got, core) {
+		t.Errorf("saved core %x, %v; want %x", got, err, core)
+	}
+	if consumed, _ := c.observe("[BOOT] ready"); consumed {
+		t.Error("ordinary line consumed after a dump")
+	}
+}
+
+func TestCoreDumpELF_Errors(t *testing.T) {
+	if _, err := coreDumpELF([]byte("binary format dump")); err == nil {
+		t.Error("expected error for a dump without an ELF core")
+	}
+	if _, err := coreDumpELF(tinyCore()[:90]); err == nil {
+		t.Error("expected error for a truncated core")
+	}
+}
@@ -0,0 +1,295 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"html"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// transcriptLine is one device line recovered from a capture. Time is zero
+// for raw captures, which carry no timing.
+type transcriptLine struct {
+	Time time.Time
+	Text string
+}
+
+// transcriptBuilder reassembles lines from raw chunks. A line takes the time
+// of the chunk its first byte arrived in.
+type transcriptBuilder struct {
+	lines []transcriptLine
+	cur   []byte
+	start time.Time
+	open  bool
+}
+
+func (b *transcriptBuilder) add(t time.Time, p []byte) {
+	for _, c := range p {
+		if !b.open {
+			b.start, b.open = t, true
+		}
+		if c == '\n' {
+			b.emit()
+			continue
+		}
+		b.cur = append(b.cur, c)
+	}
+}
+
+func (b *transcriptBuilder) emit() {
+	text := strings.TrimSuffix(string(b.cur), "\r")
+	b.lines = append(b.lines, transcriptLine{Time: b.start, Text: text})
+	b.cur, b.open = b.cur[:0], false
+}
+
+func (b *transcriptBuilder) finish() []transcriptLine {
+	if len(b.cur) > 0 {
+		b.emit()
+	}
+	return b.lines
+}
+
+// readTranscript reads a -combined capture (using only its raw records) or,
+// failing the magic check, a plain -log or raw byte capture.
+func readTranscript(r io.Reader) ([]transcriptLine, error) {
+	br := bufio.NewReader(r)
+	var b transcriptBuilder
+	if magic, _ := br.Peek(len(combinedMagic)); string(magic) == combinedMagic {
+		err := readCombined(br, func(kind byte, t time.Time, payload []byte) error {
+			if kind == recordRaw {
+				b.add(t, payload)
+			}
+			return nil
+		})
+		return b.finish(), err
+	}
+	data, err := io.ReadAll(br)
+	b.add(time.Time{}, data)
+	return b.finish(), err
+}
+
+// sanitizeControl replaces control bytes other than tab, and bytes that are not
+// valid UTF-8, with \xNN escapes.
+func sanitizeControl(s string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); {
+		r, size := utf8.DecodeRuneInString(s[i:])
+		switch {
+		case r == utf8.RuneError && size == 1, r < 0x20 && r != '\t', r == 0x7f:
+			fmt.Fprintf(&b, "\\x%02x", s[i])
+		default:
+			b.WriteString(s[i : i+size])
+		}
+		i += size
+	}
+	return b.String()
+}
+
+// exportOptions controls how a transcript is rendered.
+type exportOptions struct {
+	format    string // text, html or json
+	stripANSI bool   // text and json only; html always renders colors
+	sanitize  bool
+}
+
+const exportStamp = "15:04:05.000"
+
+// cleanLine applies the strip and sanitize options to a text or json line.
+func (o exportOptions) cleanLine(s string) string {
+	if o.stripANSI {
+		s = stripANSI(s)
+	}
+	if o.sanitize {
+		s = sanitizeControl(s)
+	}
+	return s
+}
+
+// writeTranscript renders lines to w in the chosen format.
+func writeTranscript(w io.Writer, lines []transcriptLine, o exportOptions) error {
+	bw := bufio.NewWriter(w)
+	switch o.format {
+	case "text":
+		for _, l := range lines {
+			if !l.Time.IsZero() {
+				bw.WriteString(l.Time.Format(exportStamp) + " ")
+			}
+			bw.WriteString(o.cleanLine(l.Text) + "\n")
+		}
+	case "json":
+		enc := json.NewEncoder(bw)
+		enc.SetEscapeHTML(false)
+		for _, l := range lines {
+			rec := struct {
+				Time string `json:"time,omitempty"`
+				Line string `json:"line"`
+			}{Line: o.cleanLine(l.Text)}
+			if !l.Time.IsZero() {
+				rec.Time = l.Time.Format(time.RFC3339Nano)
+			}
+			enc.Encode(rec)
+		}
+	case "html":
+		writeHTMLTranscript(bw, lines, o.sanitize)
+	default:
+		return fmt.Errorf("unknown export format %q (want text, html or json)", o.format)
+	}
+	return bw.Flush()
+}
+
+// htmlColors maps SGR foreground codes to CSS colors.
+var htmlColors = map[int]string{
+	30: "#000000", 31: "#cd3131", 32: "#0dbc79", 33: "#e5e510",
+	34: "#2472c8", 35: "#bc3fbc", 36: "#11a8cd", 37: "#e5e5e5",
+	90: "#666666", 91: "#f14c4c", 92: "#23d18b", 93: "#f5f543",
+	94: "#3b8eea", 95: "#d670d6", 96: "#29b8db", 97: "#ffffff",
+}
+
+// sgrState is the color state carried between lines of an HTML transcript.
+type sgrState struct {
+	fg   int // 0 for the default color
+	bold bool
+}
+
+// apply updates the state from the parameters of an SGR sequence.
+func (s *sgrState) apply(params string) {
+	for _, p := range strings.Split(params, ";") {
+		n, _ := strconv.Atoi(p) // empty means 0
+		switch {
+		case n == 0:
+			*s = sgrState{}
+		case n == 1:
+			s.bold = true
+		case n == 22:
+			s.bold = false
+		case n == 39:
+			s.fg = 0
+		case htmlColors[n] != "":
+			s.fg = n
+		}
+	}
+}
+
+func (s sgrState) style() string {
+	var css []string
+	if s.fg != 0 {
+		css = append(css, "color:"+htmlColors[s.fg])
+	}
+	if s.bold {
+		css = append(css, "font-weight:bold")
+	}
+	return strings.Join(css, ";")
+}
+
+// html renders text as HTML, turning SGR color sequences into styled spans
+// and dropping other escape sequences. The color state carries over to the
+// next call.
+func (s *sgrState) html(text string, sanitize bool) string {
+	var b strings.Builder
+	scanANSI(text, func(t string) {
+		if sanitize {
+			t = sanitizeControl(t)
+		}
+		t = html.EscapeString(t)
+		if style := s.style(); style != "" {
+			t = `<span style="` + style + `">` + t + "</span>"
+		}
+		b.WriteString(t)
+	}, func(seq string) {
+		if len(seq) >= 3 && seq[1] == '[' && seq[len(seq)-1] == 'm' {
+			s.apply(seq[2 : len(seq)-1])
+		}
+	})
+	return b.String()
+}
+
+// htmlTranscriptHead opens an HTML transcript; timestamps go in class t.
+const htmlTranscriptHead = "<!DOCTYPE html>\n<html><head><meta charset=\"utf-8\"><title>SUMI serial transcript</title>\n" +
+	"<style>body{background:#1e1e1e;color:#d4d4d4}.t{color:#808080}</style></head>\n<body><pre>\n"
+
+func writeHTMLTranscript(w *bufio.Writer, lines []transcriptLine, sanitize bool) {
+	w.WriteString(htmlTranscriptHead)
+	var state sgrState
+	for _, l := range lines {
+		if !l.Time.IsZero() {
+			w.WriteString(`<span class="t">` + l.Time.Format(exportStamp) + "</span> ")
+		}
+		w.WriteString(state.html(l.Text, sanitize))
+		w.WriteString("\n")
+	}
+	w.WriteString("</pre></body></html>\n")
+}
+
+// runExport implements "export <capture> [-format text|html|json] [-o file]".
+func runExport(args []string) int {
+	fs := flag.NewFlagSet("export", flag.ContinueOnError)
+	formatFlag := fs.String("format", "text", "transcript format: text, html or json")
+	outFlag := fs.String("o", "-", "write the transcript to this file (- for stdout)")
+	stripFlag := fs.Bool("strip-ansi", true, "remove ANSI escape sequences from text and json output (html renders colors)")
+	sanitizeFlag := fs.Bool("sanitize", true, "show control bytes and invalid UTF-8 as \\xNN escapes")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: %s export <capture> [-format text|html|json] [-o file]\n", os.Args[0])
+		fs.PrintDefaults()
+	}
+	// Flags may come before or after the capture path.
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if fs.NArg() < 1 {
+		fs.Usage()
+		return 2
+	}
+	path := fs.Arg(0)
+	if err := fs.Parse(fs.Args()[1:]); err != nil {
+		return 2
+	}
+	if fs.NArg() != 0 {
+		fs.Usage()
+		return 2
+	}
+	switch *formatFlag {
+	case "text", "html", "json":
+	default:
+		fmt.Fprintf(os.Stderr, "Unknown export format %q (want text, html or json)\n", *formatFlag)
+		return 2
+	}
+
+	in, err := os.Open(path)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to open capture: %v\n", err)
+		return 1
+	}
+	lines, err := readTranscript(in)
+	in.Close()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Export failed: %v\n", err)
+		return 1
+	}
+	opts := exportOptions{format: *formatFlag, stripANSI: *stripFlag, sanitize: *sanitizeFlag}
+
+	if *outFlag == "-" {
+		err = writeTranscript(os.Stdout, lines, opts)
+	} else {
+		f, ferr := os.Create(*outFlag)
+		if ferr != nil {
+			fmt.Fprintf(os.Stderr, "Failed to create %s: %v\n", *outFlag, ferr)
+			return 1
+		}
+		err = writeTranscript(f, lines, opts)
+		if cerr := f.Close(); err == nil {
+			err = cerr
+		}
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Export failed: %v\n", err)
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+)
+
+// sampleCapture builds a combined capture whose lines are split across raw
+// chunks and carry color, a stray control byte and an annotation.
+func sampleCapture(t *testing.T, t0 time.Time) []byte {
+	t.Helper()
+	var file bytes.Buffer
+	c, err := newCombinedWriter(&file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.record(recordAnnotation, t0, []byte("Monitoring /dev/ttyACM0 at 115200 baud\n"))
+	c.record(recordRaw, t0, []byte("[BOOT] Boot count: 3\r\n\x1b[31m[ERR] SD"))
+	c.record(recordRaw, t0.Add(250*time.Millisecond), []byte(" mount failed\x1b[0m\r\nbell\x07 <ok>\r\n"))
+	return file.Bytes()
+}
+
+func exportSample(t *testing.T, o exportOptions) (string, time.Time) {
+	t.Helper()
+	t0 := time.Date(2026, 3, 1, 9, 30, 15, 0, time.Local)
+	lines, err := readTranscript(bytes.NewReader(sampleCapture(t, t0)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var out bytes.Buffer
+	if err := writeTranscript(&out, lines, o); err != nil {
+		t.Fatal(err)
+	}
+	return out.String(), t0
+}
+
+func TestExport_Text(t *testing.T) {
+	got, t0 := exportSample(t, exportOptions{format: "text", stripANSI: true, sanitize: true})
+	s0 := t0.Format(exportStamp)
+	s1 := t0.Add(250 * time.Millisecond).Format(exportStamp)
+	want := s0 + " [BOOT] Boot count: 3\n" +
+		s0 + " [ERR] SD mount failed\n" +
+		s1 + " bell\\x07 <ok>\n"
+	if got != want {
+		t.Errorf("got:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+func TestExport_TextKeepsANSI(t *testing.T) {
+	got, _ := exportSample(t, exportOptions{format: "text", stripANSI: false, sanitize: false})
+	if !strings.Contains(got, "\x1b[31m[ERR] SD mount failed\x1b[0m\n") || !strings.Contains(got, "bell\x07") {
+		t.Errorf("escapes not preserved: %q", got)
+	}
+}
+
+func TestExport_JSON(t *testing.T) {
+	got, t0 := exportSample(t, exportOptions{format: "json", stripANSI: true, sanitize: true})
+	lines := strings.Split(strings.TrimSuffix(got, "\n"), "\n")
+	want := []string{
+		`{"time":"` + t0.Format(time.RFC3339Nano) + `","line":"[BOOT] Boot count: 3"}`,
+		`{"time":"` + t0.Format(time.RFC3339Nano) + `","line":"[ERR] SD mount failed"}`,
+		`{"time":"` + t0.Add(250*time.Millisecond).Format(time.RFC3339Nano) + `","line":"bell\\x07 <ok>"}`,
+	}
+	assertSliceEqual(t, lines, want)
+}
+
+func TestExport_HTML(t *testing.T) {
+	got, t0 := exportSample(t, exportOptions{format: "html", sanitize: true})
+	for _, want := range []string{
+		"<!DOCTYPE html>",
+		`<span class="t">` + t0.Format(exportStamp) + `</span> [BOOT] Boot count: 3` + "\n",
+		`<span style="color:#cd3131">[ERR] SD mount failed</span>` + "\n",
+		"bell\\x07 &lt;ok&gt;\n",
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("missing %q in:\n%s", want, got)
+		}
+	}
+	if strings.Contains(got, "\x1b") {
+		t.Error("escape bytes leaked into HTML")
+	}
+}
+
+func TestExport_HTMLColorSpansLines(t *testing.T) {
+	lines := []transcriptLine{{Text: "\x1b[1;32mgreen"}, {Text: "still\x1b[39m plain"}}
+	var out bytes.Buffer
+	writeTranscript(&out, lines, exportOptions{format: "html"})
+	want := `<span style="color:#0dbc79;font-weight:bold">still</span><span style="font-weight:bold"> plain</span>`
+	if !strings.Contains(out.String(), want) {
+		t.Errorf("missing %q in:\n%s", want, out.String())
+	}
+}
+
+func TestExport_RawCaptureHasNoTimes(t *testing.T) {
+	lines, err := readTranscript(strings.NewReader("one\r\ntwo\nthree"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var out bytes.Buffer
+	writeTranscript(&out, lines, exportOptions{format: "text", stripANSI: true, sanitize: true})
+	if out.String() != "one\ntwo\nthree\n" {
+		t.Errorf("got %q", out.String())
+	}
+}
+
+func TestSanitizeControl(t *testing.T) {
+	if got := sanitizeControl("a\tb\x00c\x7f\xffé"); got != "a\tb\\x00c\\x7f\\xffé" {
+		t.Errorf("got %q", got)
+	}
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// errInjectedFault is returned by faultConn once its fault condition trips.
+var errInjectedFault = errors.New("injected fault: connection dropped")
+
+// faultSpec describes when faultConn drops the connection. Zero fields are unset.
+type faultSpec struct {
+	after time.Duration // drop this long after the connection opens
+	lines int           // drop after this many lines have been read
+}
+
+// parseFaultSpec parses a comma-separated -fault-inject value such as
+// "after=30s" or "lines=200,after=1m".
+func parseFaultSpec(s string) (faultSpec, error) {
+	var spec faultSpec
+	for _, part := range strings.Split(s, ",") {
+		key, val, ok := strings.Cut(strings.TrimSpace(part), "=")
+		if !ok {
+			return spec, fmt.Errorf("fault-inject: expected key=value, got %q", part)
+		}
+		switch key {
+		case "after":
+			d, err := time.ParseDuration(val)
+			if err != nil || d <= 0 {
+				return spec, fmt.Errorf("fault-inject: bad duration %q", val)
+			}
+			spec.after = d
+		case "lines":
+			n, err := strconv.Atoi(val)
+			if err != nil || n <= 0 {
+				return spec, fmt.Errorf("fault-inject: bad line count %q", val)
+			}
+			spec.lines = n
+		default:
+			return spec, fmt.Errorf("fault-inject: unknown key %q (want after or lines)", key)
+		}
+	}
+	return spec, nil
+}
+
+// faultConn is a testing aid that wraps a connection and simulates a disconnect
+// when its faultSpec trips, so recovery paths can be exercised without pulling
+// a USB cable. It works over any io.ReadWriteCloser, including PTYs.
+type faultConn struct {
+	io.ReadWriteCloser
+	spec    faultSpec
+	lines   int
+	tripped atomic.Bool
+	timer   *time.Timer
+}
+
+func newFaultConn(conn io.ReadWriteCloser, spec faultSpec) *faultConn {
+	c := &faultConn{ReadWriteCloser: conn, spec: spec}
+	if spec.after > 0 {
+		// Closing the underlying connection unblocks a pending Read.
+		c.timer = time.AfterFunc(spec.after, c.trip)
+	}
+	return c
+}
+
+func (c *faultConn) trip() {
+	if c.tripped.CompareAndSwap(false, true) {
+		c.ReadWriteCloser.Close()
+	}
+}
+
+func (c *faultConn) Read(p []byte) (int, error) {
+	if c.tripped.Load() {
+		return 0, errInjectedFault
+	}
+	n, err := c.ReadWriteCloser.Read(p)
+	if c.tripped.Load() {
+		return 0, errInjectedFault
+	}
+	if c.spec.lines > 0 {
+		c.lines += bytes.Count(p[:n], []byte{'\n'})
+		if c.lines >= c.spec.lines {
+			c.trip()
+		}
+	}
+	return n, err
+}
+
+func (c *faultConn) Write(p []byte) (int, error) {
+	if c.tripped.Load() {
+		return 0, errInjectedFault
+	}
+	return c.ReadWriteCloser.Write(p)
+}
+
+func (c *faultConn) Close() error {
+	if c.timer != nil {
+		c.timer.Stop()
+	}
+	if c.tripped.Swap(true) {
+		return nil
+	}
+	return c.ReadWriteCloser.Close()
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"bufio"
+	"io"
+	"strings"
+	"testing"
+	"time"
+)
+
+// pipeConn adapts an io.Pipe reader to io.ReadWriteCloser for fault tests.
+type pipeConn struct {
+	*io.PipeReader
+}
+
+func (pipeConn) Write(p []byte) (int, error) { return len(p), nil }
+
+func TestParseFaultSpec(t *testing.T) {
+	spec, err := parseFaultSpec("lines=200, after=1m")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if spec.lines != 200 || spec.after != time.Minute {
+		t.Errorf("got %+v", spec)
+	}
+	for _, bad := range []string{"", "after", "after=soon", "lines=0", "bytes=5"} {
+		if _, err := parseFaultSpec(bad); err == nil {
+			t.Errorf("parseFaultSpec(%q): expected error", bad)
+		}
+	}
+}
+
+func TestFaultConn_AfterLines(t *testing.T) {
+	pr, pw := io.Pipe()
+	go func() {
+		pw.Write([]byte("one\ntwo\n"))
+		pw.Write([]byte("three\n"))
+	}()
+	conn := newFaultConn(pipeConn{pr}, faultSpec{lines: 2})
+	scanner := bufio.NewScanner(conn)
+	var got []string
+	for scanner.Scan() {
+		got = append(got, scanner.Text())
+	}
+	assertSliceEqual(t, got, []string{"one", "two"})
+	if scanner.Err() != errInjectedFault {
+		t.Errorf("expected injected fault, got %v", scanner.Err())
+	}
+}
+
+func TestFaultConn_AfterDuration(t *testing.T) {
+	pr, _ := io.Pipe()
+	conn := newFaultConn(pipeConn{pr}, faultSpec{after: 10 * time.Millisecond})
+	done := make(chan error, 1)
+	go func() {
+		_, err := conn.Read(make([]byte, 16))
+		done <- err
+	}()
+	select {
+	case err := <-done:
+		if err != errInjectedFault {
+			t.Errorf("expected injected fault, got %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("blocked read was not interrupted by the fault")
+	}
+	if _, err := conn.Write([]byte("x")); err != errInjectedFault {
+		t.Errorf("write after fault: got %v", err)
+	}
+}
+
+func TestFaultConn_PassThrough(t *testing.T) {
+	conn := newFaultConn(pipeConnFrom("a\nb\n"), faultSpec{lines: 10})
+	data, err := io.ReadAll(conn)
+	if err != nil || string(data) != "a\nb\n" {
+		t.Errorf("got %q, %v", data, err)
+	}
+}
+
+func pipeConnFrom(s string) pipeConn {
+	pr, pw := io.Pipe()
+	go func() {
+		io.Copy(pw, strings.NewReader(s))
+		pw.Close()
+	}()
+	return pipeConn{pr}
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+)
+
+// compilePatterns compiles the expressions given to a repeatable regex flag.
+func compilePatterns(flagName string, exprs []string) ([]*regexp.Regexp, error) {
+	var res []*regexp.Regexp
+	for _, e := range exprs {
+		re, err := regexp.Compile(e)
+		if err != nil {
+			return nil, fmt.Errorf("invalid -%s: %v", flagName, err)
+		}
+		res = append(res, re)
+	}
+	return res, nil
+}
+
+// matchFilter keeps lines that match any include pattern, or every line when
+// there are none, and that match no exclude pattern. It returns nil when there
+// are no patterns at all.
+func matchFilter(include, exclude []*regexp.Regexp) func(LineEvent) bool {
+	if len(include) == 0 && len(exclude) == 0 {
+		return nil
+	}
+	return func(ev LineEvent) bool {
+		for _, re := range exclude {
+			if re.MatchString(ev.Text) {
+				return false
+			}
+		}
+		if len(include) == 0 {
+			return true
+		}
+		for _, re := range include {
+			if re.MatchString(ev.Text) {
+				return true
+			}
+		}
+		return false
+	}
+}
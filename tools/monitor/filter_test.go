@@ -0,0 +1,58 @@
+package main
+
+import "testing"
+
+func filterLines(t *testing.T, include, exclude []string, lines []string) []string {
+	t.Helper()
+	inc, err := compilePatterns("include", include)
+	if err != nil {
+		t.Fatal(err)
+	}
+	exc, err := compilePatterns("exclude", exclude)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keep := matchFilter(inc, exc)
+	var kept []string
+	for _, l := range lines {
+		if keep == nil || keep(LineEvent{Text: l}) {
+			kept = append(kept, l)
+		}
+	}
+	return kept
+}
+
+var filterSample = []string{
+	"[EPUB] Opening /books/dune.epub",
+	"[FONT] Loaded noto-serif 18",
+	"[BLE] Advertising",
+	"[EPUB] Chapter 3 cached",
+	"[MEM] Free heap: 143000",
+}
+
+func TestMatchFilter_Include(t *testing.T) {
+	got := filterLines(t, []string{`^\[EPUB\]`, `^\[FONT\]`}, nil, filterSample)
+	assertSliceEqual(t, got, []string{filterSample[0], filterSample[1], filterSample[3]})
+}
+
+func TestMatchFilter_Exclude(t *testing.T) {
+	got := filterLines(t, nil, []string{`^\[(BLE|MEM)\]`}, filterSample)
+	assertSliceEqual(t, got, []string{filterSample[0], filterSample[1], filterSample[3]})
+}
+
+func TestMatchFilter_ExcludeWins(t *testing.T) {
+	got := filterLines(t, []string{`EPUB`}, []string{`cached`}, filterSample)
+	assertSliceEqual(t, got, []string{filterSample[0]})
+}
+
+func TestMatchFilter_NoPatterns(t *testing.T) {
+	if matchFilter(nil, nil) != nil {
+		t.Error("expected a nil filter without patterns")
+	}
+}
+
+func TestCompilePatterns_Invalid(t *testing.T) {
+	if _, err := compilePatterns("include", []string{"[EPUB"}); err == nil {
+		t.Error("expected error for invalid regex")
+	}
+}
@@ -0,0 +1,36 @@
+package main
+
+import "fmt"
+
+// flushMode selects when buffered stdout is flushed.
+type flushMode int
+
+const (
+	// flushLine flushes after every line: lowest latency, one write per line.
+	flushLine flushMode = iota
+	// flushBatch flushes only when every buffered line has been handled and the
+	// reader is about to wait for more data, so bursts become one write.
+	flushBatch
+)
+
+func parseFlushMode(s string) (flushMode, error) {
+	switch s {
+	case "line":
+		return flushLine, nil
+	case "batch":
+		return flushBatch, nil
+	}
+	return 0, fmt.Errorf("unknown flush mode %q (want line or batch)", s)
+}
+
+// applyFlushMode returns the hook to call after each line: it flushes in line
+// mode and does nothing in batch mode, where lines flushes instead each time
+// it has handed out every complete line and is about to wait for more data,
+// so a burst of lines becomes one write.
+func applyFlushMode(mode flushMode, lines *lineReader, flush func() error) func() error {
+	if mode == flushBatch {
+		lines.beforeWait = flush
+		return func() error { return nil }
+	}
+	return flush
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"testing"
+)
+
+// chunkReader returns one chunk per Read, like a serial port delivering bursts.
+type chunkReader struct {
+	chunks []string
+}
+
+func (c *chunkReader) Read(p []byte) (int, error) {
+	if len(c.chunks) == 0 {
+		return 0, io.EOF
+	}
+	n := copy(p, c.chunks[0])
+	c.chunks = c.chunks[1:]
+	return n, nil
+}
+
+// flushCounter is a mock buffered sink that counts flushes.
+type flushCounter struct {
+	pending, flushed bytes.Buffer
+	flushes          int
+}
+
+func (f *flushCounter) Write(p []byte) (int, error) { return f.pending.Write(p) }
+
+func (f *flushCounter) Flush() error {
+	f.flushes++
+	f.pending.WriteTo(&f.flushed)
+	return nil
+}
+
+func runFlushMode(t *testing.T, mode flushMode) *flushCounter {
+	t.Helper()
+	out := &flushCounter{}
+	lines := newLineReader(&chunkReader{chunks: []string{"a\nb\nc\n", "d\ne\n"}}, 0)
+	afterLine := applyFlushMode(mode, lines, out.Flush)
+	for lines.Scan() {
+		out.Write([]byte(lines.Text() + "\n"))
+		afterLine()
+	}
+	out.Flush()
+	if out.flushed.String() != "a\nb\nc\nd\ne\n" {
+		t.Errorf("output %q", out.flushed.String())
+	}
+	return out
+}
+
+func TestFlushMode_Line(t *testing.T) {
+	// One flush per line plus the final flush.
+	if got := runFlushMode(t, flushLine).flushes; got != 6 {
+		t.Errorf("line mode flushed %d times, want 6", got)
+	}
+}
+
+func TestFlushMode_Batch(t *testing.T) {
+	// One flush before each of the three reads (two chunks and EOF) plus the
+	// final flush; bursts of lines are not flushed individually.
+	if got := runFlushMode(t, flushBatch).flushes; got != 4 {
+		t.Errorf("batch mode flushed %d times, want 4", got)
+	}
+}
+
+func TestParseFlushMode(t *testing.T) {
+	if m, err := parseFlushMode("batch"); err != nil || m != flushBatch {
+		t.Errorf("got %v, %v", m, err)
+	}
+	if _, err := parseFlushMode("never"); err == nil {
+		t.Error("expected error")
+	}
+}
@@ -0,0 +1,14 @@
+package main
+
+import (
+	"fmt"
+	"io"
+)
+
+// writeFrame writes payload as a netstring: the decimal byte length, a colon, the
+// payload bytes and a trailing comma. The payload may contain newlines, so a
+// reader never has to guess where one logical line ends.
+func writeFrame(w io.Writer, payload string) error {
+	_, err := fmt.Fprintf(w, "%d:%s,", len(payload), payload)
+	return err
+}
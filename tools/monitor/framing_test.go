@@ -0,0 +1,64 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"testing"
+)
+
+// readFrame is the reference reader for the length-prefixed stdout format.
+func readFrame(r *bufio.Reader) (string, error) {
+	head, err := r.ReadString(':')
+	if err != nil {
+		return "", err
+	}
+	n, err := strconv.Atoi(head[:len(head)-1])
+	if err != nil || n < 0 {
+		return "", fmt.Errorf("bad frame length %q", head)
+	}
+	buf := make([]byte, n+1)
+	if _, err := io.ReadFull(r, buf); err != nil {
+		return "", err
+	}
+	if buf[n] != ',' {
+		return "", errors.New("missing frame terminator")
+	}
+	return string(buf[:n]), nil
+}
+
+func TestWriteFrame_Format(t *testing.T) {
+	var buf bytes.Buffer
+	if err := writeFrame(&buf, "hello"); err != nil {
+		t.Fatal(err)
+	}
+	if buf.String() != "5:hello," {
+		t.Errorf("got %q", buf.String())
+	}
+}
+
+func TestWriteFrame_RoundTrip(t *testing.T) {
+	lines := []string{"[EPUB] open", "", "multi\nline\npanic", "comma,colon:", "日本語"}
+	var buf bytes.Buffer
+	for _, l := range lines {
+		if err := writeFrame(&buf, l); err != nil {
+			t.Fatal(err)
+		}
+	}
+	r := bufio.NewReader(&buf)
+	for _, want := range lines {
+		got, err := readFrame(r)
+		if err != nil {
+			t.Fatalf("readFrame: %v", err)
+		}
+		if got != want {
+			t.Errorf("got %q, want %q", got, want)
+		}
+	}
+	if _, err := readFrame(r); err != io.EOF {
+		t.Errorf("expected EOF after last frame, got %v", err)
+	}
+}
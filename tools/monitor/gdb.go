@@ -0,0 +1,219 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"sync"
+)
+
+// gdbMaxPacket bounds a candidate GDB packet; a '$' in log text that runs
+// longer without a checksum is text after all.
+const gdbMaxPacket = 4096
+
+// gdbBridge separates the ESP32 GDB stub's remote protocol packets from log
+// text on the port. Packets go to the gdb client connected to the bridge's
+// TCP port, and everything the client sends goes to the device, so a
+// debugger can attach without stopping the monitor. Read returns the
+// remaining text.
+//
+// A packet is "$payload#xx" with a valid checksum and no newline. The stub
+// acknowledges the client's packets with a bare '+' or '-', which is taken
+// from the text only while such a reply is due.
+type gdbBridge struct {
+	src    io.Reader
+	port   io.Writer
+	notice func(string) // reports the stub waiting and clients coming and going
+	attach string       // how to attach, added to the waiting notice
+
+	mu      sync.Mutex
+	client  net.Conn
+	ackDue  bool // the client sent a packet the stub has not acknowledged
+	noticed bool
+	pkt     []byte // candidate packet, from its '$'
+	text    []byte // text ready for Read
+	buf     []byte
+
+	// Offsets in the text stand for different ones in the port's stream
+	// once bytes are taken out; rawOffset maps them back.
+	kept    int64    // text bytes so far
+	gaps    []gdbGap // bytes taken out, oldest first
+	skipped int64    // bytes in gaps rawOffset has passed
+}
+
+// gdbGap is n bytes taken out of the stream where the text was at byte at.
+type gdbGap struct {
+	at, n int64
+}
+
+func newGDBBridge(src io.Reader, port io.Writer, notice func(string)) *gdbBridge {
+	return &gdbBridge{src: src, port: port, notice: notice, buf: make([]byte, 4096)}
+}
+
+func (b *gdbBridge) Read(p []byte) (int, error) {
+	for len(b.text) == 0 {
+		n, err := b.src.Read(b.buf)
+		b.mu.Lock()
+		for _, c := range b.buf[:n] {
+			b.feed(c)
+		}
+		if err != nil && len(b.pkt) > 0 {
+			// The port is gone; nothing will complete the packet.
+			b.keep(b.pkt...)
+			b.pkt = nil
+		}
+		b.mu.Unlock()
+		if err != nil {
+			if len(b.text) > 0 {
+				break
+			}
+			return 0, err
+		}
+	}
+	n := copy(p, b.text)
+	b.text = b.text[n:]
+	return n, nil
+}
+
+// feed classifies one byte from the device. The caller holds mu.
+func (b *gdbBridge) feed(c byte) {
+	if len(b.pkt) == 0 {
+		switch {
+		case c == '$':
+			b.pkt = append(b.pkt, c)
+		case (c == '+' || c == '-') && b.client != nil && b.ackDue:
+			b.ackDue = false
+			b.client.Write([]byte{c})
+			b.drop(1)
+		default:
+			b.keep(c)
+		}
+		return
+	}
+	if c == '$' || c == '\n' || len(b.pkt) >= gdbMaxPacket {
+		// Not a packet: return it to the text and look at c afresh.
+		b.keep(b.pkt...)
+		b.pkt = nil
+		b.feed(c)
+		return
+	}
+	b.pkt = append(b.pkt, c)
+	hash := bytes.IndexByte(b.pkt, '#')
+	if hash < 0 || len(b.pkt) < hash+3 {
+		return
+	}
+	pkt := b.pkt
+	b.pkt = nil
+	sum, err := strconv.ParseUint(string(pkt[hash+1:]), 16, 8)
+	if err != nil || byte(sum) != gdbChecksum(pkt[1:hash]) {
+		b.keep(pkt...)
+		return
+	}
+	b.drop(len(pkt))
+	b.deliver(pkt)
+}
+
+// keep passes bytes on as text. The caller holds mu.
+func (b *gdbBridge) keep(c ...byte) {
+	b.text = append(b.text, c...)
+	b.kept += int64(len(c))
+}
+
+// drop records n bytes taken out of the stream. The caller holds mu.
+func (b *gdbBridge) drop(n int) {
+	b.gaps = append(b.gaps, gdbGap{at: b.kept, n: int64(n)})
+}
+
+// rawOffset maps an offset in the text Read returns to the matching offset
+// in the port's stream, as -raw and -capture record it, by adding back the
+// packets and acknowledgements taken out before it. Offsets must not go
+// backwards from one call to the next.
+func (b *gdbBridge) rawOffset(off int64) int64 {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for len(b.gaps) > 0 && b.gaps[0].at <= off {
+		b.skipped += b.gaps[0].n
+		b.gaps = b.gaps[1:]
+	}
+	return off + b.skipped
+}
+
+// deliver sends a packet from the stub to the client. Without one, the first
+// packet prompts the user to attach. The caller holds mu.
+func (b *gdbBridge) deliver(pkt []byte) {
+	if b.client != nil {
+		b.client.Write(pkt)
+		return
+	}
+	if !b.noticed && b.notice != nil {
+		b.noticed = true
+		msg := fmt.Sprintf("GDB stub is waiting (%s)", pkt)
+		if b.attach != "" {
+			msg += "; attach with: " + b.attach
+		}
+		b.notice(msg)
+	}
+}
+
+func gdbChecksum(payload []byte) byte {
+	var sum byte
+	for _, c := range payload {
+		sum += c
+	}
+	return sum
+}
+
+// serve accepts gdb clients on ln, one at a time. A second client is turned
+// away while the first is attached.
+func (b *gdbBridge) serve(ln net.Listener) {
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		b.mu.Lock()
+		busy := b.client != nil
+		if !busy {
+			b.client, b.ackDue, b.noticed = conn, false, false
+		}
+		b.mu.Unlock()
+		if busy {
+			conn.Close()
+			continue
+		}
+		if b.notice != nil {
+			b.notice("GDB attached from " + conn.RemoteAddr().String())
+		}
+		go b.forward(conn)
+	}
+}
+
+// forward copies the client's bytes to the device until the client leaves.
+func (b *gdbBridge) forward(conn net.Conn) {
+	buf := make([]byte, 4096)
+	for {
+		n, err := conn.Read(buf)
+		if n > 0 {
+			b.mu.Lock()
+			if bytes.IndexByte(buf[:n], '#') >= 0 {
+				b.ackDue = true
+			}
+			b.mu.Unlock()
+			if _, werr := b.port.Write(buf[:n]); werr != nil {
+				err = werr
+			}
+		}
+		if err != nil {
+			break
+		}
+	}
+	conn.Close()
+	b.mu.Lock()
+	b.client = nil
+	b.mu.Unlock()
+	if b.notice != nil {
+		b.notice("GDB detached")
+	}
+}
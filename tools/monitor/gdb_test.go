@@ -0,0 +1,102 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestGDBBridge_SeparatesPackets(t *testing.T) {
+	var notices []string
+	in := "Guru Meditation Error\n$T0b#e6costs $5 #ok\n$T0b#00\nEntering gdb stub now.\n"
+	b := newGDBBridge(strings.NewReader(in), io.Discard, func(s string) { notices = append(notices, s) })
+	b.attach = "gdb -ex 'target remote :3333'"
+	out, err := io.ReadAll(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The valid stop packet is taken out; the '$' in text and the packet
+	// with a bad checksum stay.
+	if want := "Guru Meditation Error\ncosts $5 #ok\n$T0b#00\nEntering gdb stub now.\n"; string(out) != want {
+		t.Errorf("text %q, want %q", out, want)
+	}
+	assertSliceEqual(t, notices, []string{"GDB stub is waiting ($T0b#e6); attach with: gdb -ex 'target remote :3333'"})
+}
+
+func TestGDBBridge_RawOffset(t *testing.T) {
+	in := "boot\n$T0b#e6panic\n$S05#b8done\n"
+	b := newGDBBridge(strings.NewReader(in), io.Discard, nil)
+	lines := newLineReader(b, 0)
+	var got []int64
+	for lines.Scan() {
+		off := b.rawOffset(lines.lineStart)
+		if !strings.HasPrefix(in[off:], lines.Text()) {
+			t.Errorf("%q at raw offset %d, where the stream has %q", lines.Text(), off, in[off:])
+		}
+		got = append(got, off)
+	}
+	if want := []int64{0, 12, 25}; fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("offsets %v, want %v", got, want)
+	}
+}
+
+func TestGDBBridge_UnfinishedPacketIsText(t *testing.T) {
+	b := newGDBBridge(strings.NewReader("price: $12"), io.Discard, nil)
+	if out, _ := io.ReadAll(b); string(out) != "price: $12" {
+		t.Errorf("text %q", out)
+	}
+}
+
+func TestGDBBridge_Client(t *testing.T) {
+	device, stub := io.Pipe()
+	var port bytes.Buffer
+	b := newGDBBridge(device, &port, nil)
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	go b.serve(ln)
+	gdb, err := net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer gdb.Close()
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		b.mu.Lock()
+		attached := b.client != nil
+		b.mu.Unlock()
+		if attached {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("client never attached")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	gdb.Write([]byte("$?#3f"))
+	for {
+		b.mu.Lock()
+		due := b.ackDue
+		b.mu.Unlock()
+		if due {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	go stub.Write([]byte("+$S05#b8log+\n"))
+	text := make([]byte, 5)
+	if _, err := io.ReadFull(b, text); err != nil || string(text) != "log+\n" {
+		t.Errorf("text %q, %v", text, err)
+	}
+	gdb.SetReadDeadline(time.Now().Add(2 * time.Second))
+	got := make([]byte, len("+$S05#b8"))
+	if _, err := io.ReadFull(gdb, got); err != nil || string(got) != "+$S05#b8" {
+		t.Errorf("gdb got %q, %v", got, err)
+	}
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"sync"
+	"time"
+	"unicode"
+	"unicode/utf8"
+)
+
+// guardSampleBytes is how many bytes the garbage guard accumulates before it
+// judges a sample, so a single stray byte cannot trip or reset it.
+const guardSampleBytes = 32
+
+// printableRatio returns the fraction of data made up of printable text: valid
+// UTF-8 that is printable or whitespace. Multi-byte characters such as CJK
+// count as printable; invalid bytes and control codes do not.
+func printableRatio(data []byte) float64 {
+	if len(data) == 0 {
+		return 1
+	}
+	printable := 0
+	for i := 0; i < len(data); {
+		r, size := utf8.DecodeRune(data[i:])
+		if r != utf8.RuneError && (unicode.IsPrint(r) || unicode.IsSpace(r)) {
+			printable += size
+		}
+		i += size
+	}
+	return float64(printable) / float64(len(data))
+}
+
+// garbageGuard watches the raw byte stream and warns when the printable ratio
+// stays below threshold for window, the usual symptom of a wrong baud rate.
+type garbageGuard struct {
+	threshold float64
+	window    time.Duration
+	warn      func(ratio float64) // called once per sustained bad stretch
+
+	mu       sync.Mutex
+	sample   []byte
+	badSince time.Time // zero while output looks healthy
+	warned   bool
+}
+
+// observe feeds received bytes into the guard and reports whether a warning
+// should be raised now.
+func (g *garbageGuard) observe(data []byte, now time.Time) (bool, float64) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.sample = append(g.sample, data...)
+	if len(g.sample) < guardSampleBytes {
+		return false, 0
+	}
+	ratio := printableRatio(g.sample)
+	g.sample = g.sample[:0]
+	if ratio >= g.threshold {
+		g.badSince = time.Time{}
+		g.warned = false
+		return false, ratio
+	}
+	if g.badSince.IsZero() {
+		g.badSince = now
+	}
+	if !g.warned && now.Sub(g.badSince) >= g.window {
+		g.warned = true
+		return true, ratio
+	}
+	return false, ratio
+}
+
+// Write lets the guard sit on an io.TeeReader over the connection.
+func (g *garbageGuard) Write(p []byte) (int, error) {
+	if warn, ratio := g.observe(p, time.Now()); warn && g.warn != nil {
+		g.warn(ratio)
+	}
+	return len(p), nil
+}
+
+// guardReader puts a garbage guard on src that calls warn, or returns src
+// as it is when threshold is 0 or binary is set. -hex and -raw are for
+// binary streams, so little printable text is no sign of a wrong baud rate
+// there.
+func guardReader(src io.Reader, threshold float64, window time.Duration, binary bool, warn func(float64)) io.Reader {
+	if threshold <= 0 || binary {
+		return src
+	}
+	return io.TeeReader(src, &garbageGuard{threshold: threshold, window: window, warn: warn})
+}
+
+// baudWarning prints the prominent wrong-baud warning.
+func baudWarning(w io.Writer, baud int, window time.Duration) func(float64) {
+	hint := "check the -speed the firmware was built with"
+	if baud != 115200 {
+		hint = "SUMI firmware uses 115200"
+	}
+	return func(ratio float64) {
+		fmt.Fprintf(w, "\n*** Only %.0f%% of the data received over %s is printable. "+
+			"The baud rate (%d) may be wrong; %s. ***\n", ratio*100, window, baud, hint)
+	}
+}
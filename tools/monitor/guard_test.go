@@ -0,0 +1,100 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"math"
+	"testing"
+	"time"
+)
+
+func TestPrintableRatio(t *testing.T) {
+	tests := []struct {
+		data []byte
+		want float64
+	}{
+		{nil, 1},
+		{[]byte("[EPUB] open book\r\n\t"), 1},
+		{[]byte("日本語テキスト"), 1},
+		{[]byte{0xff, 0xfe, 0x00, 0x01}, 0},
+		{[]byte{'o', 'k', 0x00, 0x80}, 0.5},
+		{[]byte{0xe6, 0x97}, 0}, // truncated multi-byte character
+	}
+	for _, tt := range tests {
+		if got := printableRatio(tt.data); math.Abs(got-tt.want) > 1e-9 {
+			t.Errorf("printableRatio(%q) = %v, want %v", tt.data, got, tt.want)
+		}
+	}
+}
+
+var garbage = bytes.Repeat([]byte{0xf0, 0x00, 0x8f, 0x1b}, 16)
+
+func TestGarbageGuard_Sustained(t *testing.T) {
+	g := &garbageGuard{threshold: 0.6, window: 2 * time.Second}
+	t0 := time.Unix(0, 0)
+	if warn, _ := g.observe(garbage, t0); warn {
+		t.Fatal("warned on the first bad sample")
+	}
+	if warn, _ := g.observe(garbage, t0.Add(time.Second)); warn {
+		t.Fatal("warned before the window elapsed")
+	}
+	warn, ratio := g.observe(garbage, t0.Add(2*time.Second))
+	if !warn || ratio > 0.3 {
+		t.Fatalf("expected a warning after the window, got %v (ratio %v)", warn, ratio)
+	}
+	if warn, _ := g.observe(garbage, t0.Add(3*time.Second)); warn {
+		t.Error("warned twice for the same bad stretch")
+	}
+}
+
+func TestGarbageGuard_RecoveryResets(t *testing.T) {
+	g := &garbageGuard{threshold: 0.6, window: time.Second}
+	t0 := time.Unix(0, 0)
+	good := bytes.Repeat([]byte("[HOME] ok\n"), 4)
+	g.observe(garbage, t0)
+	g.observe(good, t0.Add(500*time.Millisecond))
+	if warn, _ := g.observe(garbage, t0.Add(1500*time.Millisecond)); warn {
+		t.Error("readable output should restart the window")
+	}
+	if warn, _ := g.observe(garbage, t0.Add(2500*time.Millisecond)); !warn {
+		t.Error("expected a warning for the new bad stretch")
+	}
+}
+
+func TestGarbageGuard_SmallChunksAccumulate(t *testing.T) {
+	g := &garbageGuard{threshold: 0.6, window: 0}
+	t0 := time.Unix(0, 0)
+	for i := 0; i < guardSampleBytes-1; i++ {
+		if warn, _ := g.observe([]byte{0xff}, t0); warn {
+			t.Fatalf("judged a sample of only %d bytes", i+1)
+		}
+	}
+	if warn, _ := g.observe([]byte{0xff}, t0); !warn {
+		t.Error("expected a warning once the sample filled")
+	}
+}
+
+func TestGuardReader(t *testing.T) {
+	stream := bytes.Repeat(garbage, 8)
+	tests := []struct {
+		name      string
+		threshold float64
+		binary    bool
+		warn      bool
+	}{
+		{"default", 0.6, false, true},
+		{"-hex or -raw", 0.6, true, false},
+		{"disabled", 0, false, false},
+	}
+	for _, tt := range tests {
+		warned := false
+		src := guardReader(bytes.NewReader(stream), tt.threshold, 0, tt.binary, func(float64) { warned = true })
+		got, err := io.ReadAll(src)
+		if err != nil || !bytes.Equal(got, stream) {
+			t.Errorf("%s: read %d bytes, %v", tt.name, len(got), err)
+		}
+		if warned != tt.warn {
+			t.Errorf("%s: warned %v, want %v", tt.name, warned, tt.warn)
+		}
+	}
+}
@@ -0,0 +1,189 @@
+package main
+
+import (
+	"encoding/hex"
+	"fmt"
+	"io"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Consecutive samples needed to enter and to leave hex mode.
+const (
+	hexEnterSamples = 3
+	hexExitSamples  = 3
+)
+
+// hexSwitch shows a hex dump in place of the text display while the stream
+// looks binary, and returns to text once it is readable again. Entering takes
+// hexEnterSamples samples in a row below enter; leaving takes hexExitSamples in
+// a row at or above the higher exit ratio, so output hovering around either
+// threshold does not flap between modes. It is driven from the read loop only.
+type hexSwitch struct {
+	enter, exit float64
+	out         io.Writer
+	flush       func() error
+
+	sample    []byte
+	bad, good int
+	offset    int64          // stream bytes seen so far
+	dumper    io.WriteCloser // non-nil while in hex mode
+	stretches []hexStretch   // shown as hex and not yet passed by the text display
+}
+
+// hexStretch is a range of stream offsets shown as hex; to is -1 while open.
+type hexStretch struct {
+	from, to int64
+}
+
+func newHexSwitch(threshold float64, out io.Writer, flush func() error) *hexSwitch {
+	return &hexSwitch{enter: threshold, exit: min(threshold+0.2, 0.95), out: out, flush: flush}
+}
+
+// active reports whether the hex dump currently replaces the text display.
+func (h *hexSwitch) active() bool {
+	return h.dumper != nil
+}
+
+// judge updates the mode from the printable ratio of one sample.
+func (h *hexSwitch) judge(ratio float64) {
+	if h.dumper == nil {
+		if ratio < h.enter {
+			h.bad++
+		} else {
+			h.bad = 0
+		}
+		if h.bad >= hexEnterSamples {
+			h.bad = 0
+			fmt.Fprintf(h.out, "\n*** Output is not readable text (%.0f%% printable); showing hex from offset 0x%X. ***\n", ratio*100, h.offset)
+			h.dumper = hex.Dumper(h.out)
+			h.stretches = append(h.stretches, hexStretch{from: h.offset, to: -1})
+		}
+		return
+	}
+	if ratio >= h.exit {
+		h.good++
+	} else {
+		h.good = 0
+	}
+	if h.good >= hexExitSamples {
+		h.good = 0
+		h.dumper.Close()
+		h.dumper = nil
+		h.stretches[len(h.stretches)-1].to = h.offset
+		fmt.Fprintf(h.out, "\n*** Readable output resumed at offset 0x%X; back to text. ***\n", h.offset)
+	}
+}
+
+// Write lets the switch sit on an io.TeeReader over the connection. Data is
+// judged in guardSampleBytes samples, so a mode change lands on a sample
+// boundary and the bytes after it are shown in the new mode.
+func (h *hexSwitch) Write(p []byte) (int, error) {
+	n := len(p)
+	for len(p) > 0 {
+		k := min(guardSampleBytes-len(h.sample), len(p))
+		if h.dumper != nil {
+			h.dumper.Write(p[:k])
+		}
+		h.sample = append(h.sample, p[:k]...)
+		h.offset += int64(k)
+		p = p[k:]
+		if len(h.sample) == guardSampleBytes {
+			h.judge(printableRatio(h.sample))
+			h.sample = h.sample[:0]
+		}
+	}
+	if h.flush != nil {
+		h.flush()
+	}
+	return n, nil
+}
+
+// close ends an open hex dump, printing its partial last row.
+func (h *hexSwitch) close() {
+	if h.dumper != nil {
+		h.dumper.Close()
+		h.dumper = nil
+	}
+}
+
+// shownAsHex reports whether any of the line spanning [start, end) fell in a
+// hex stretch. Lines reach the display after their bytes passed Write, so this
+// goes by offset rather than the current mode.
+func (h *hexSwitch) shownAsHex(start, end int64) bool {
+	for len(h.stretches) > 0 && h.stretches[0].to >= 0 && start >= h.stretches[0].to {
+		h.stretches = h.stretches[1:]
+	}
+	if len(h.stretches) == 0 {
+		return false
+	}
+	s := h.stretches[0]
+	return end > s.from && (s.to < 0 || start < s.to)
+}
+
+// textFilter wraps a display filter so bytes shown as hex are not repeated as text.
+func (h *hexSwitch) textFilter(next func(LineEvent) bool) func(LineEvent) bool {
+	return func(ev LineEvent) bool {
+		end := ev.Offset + int64(len(ev.Text)) + 1 // through the newline
+		return !h.shownAsHex(ev.Offset, end) && (next == nil || next(ev))
+	}
+}
+
+// binaryLine reports whether a line holds bytes that would mangle the terminal:
+// control characters other than tab, or invalid UTF-8. ANSI sequences and a
+// trailing CR are normal log output and do not count.
+func binaryLine(text string) bool {
+	text = strings.TrimSuffix(stripANSI(text), "\r")
+	for i := 0; i < len(text); {
+		r, size := utf8.DecodeRuneInString(text[i:])
+		if r == utf8.RuneError && size <= 1 || r != '\t' && unicode.IsControl(r) {
+			return true
+		}
+		i += size
+	}
+	return false
+}
+
+// hexRows renders data the way hex.Dump does, with row offsets counted from
+// the stream offset base instead of zero.
+func hexRows(data []byte, base int64) []string {
+	var rows []string
+	for i := 0; i < len(data); i += 16 {
+		chunk := data[i:min(i+16, len(data))]
+		var b strings.Builder
+		fmt.Fprintf(&b, "%08x ", base+int64(i))
+		for j := 0; j < 16; j++ {
+			if j == 8 {
+				b.WriteByte(' ')
+			}
+			if j < len(chunk) {
+				fmt.Fprintf(&b, " %02x", chunk[j])
+			} else {
+				b.WriteString("   ")
+			}
+		}
+		b.WriteString("  |")
+		for _, c := range chunk {
+			if c < 32 || c > 126 {
+				c = '.'
+			}
+			b.WriteByte(c)
+		}
+		b.WriteByte('|')
+		rows = append(rows, b.String())
+	}
+	return rows
+}
+
+// hexEvents splits a binary line into one event per dump row. Rows keep the
+// line's prefix so timestamps still line up.
+func hexEvents(ev LineEvent) []LineEvent {
+	var events []LineEvent
+	for _, row := range hexRows([]byte(ev.Text), ev.Offset) {
+		e := ev
+		e.Text = row
+		events = append(events, e)
+	}
+	return events
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"bytes"
+	"encoding/hex"
+	"strings"
+	"testing"
+)
+
+func TestHexSwitch_Hysteresis(t *testing.T) {
+	h := newHexSwitch(0.6, &bytes.Buffer{}, nil)
+	steps := []struct {
+		ratio float64
+		hex   bool
+	}{
+		{0.3, false}, {0.3, false}, {0.7, false}, // a good sample resets the count
+		{0.3, false}, {0.3, false}, {0.3, true}, // three bad in a row enter hex
+		{0.9, true}, {0.9, true}, {0.7, true}, // 0.7 is above enter but below exit
+		{0.9, true}, {0.9, true}, {0.9, false}, // three at or above exit leave hex
+		{0.5, false}, {0.65, false}, {0.5, false}, // hovering around enter does not flap
+	}
+	for i, s := range steps {
+		h.judge(s.ratio)
+		if h.active() != s.hex {
+			t.Fatalf("step %d (ratio %.2f): hex = %v, want %v", i, s.ratio, h.active(), s.hex)
+		}
+	}
+}
+
+func TestHexSwitch_DumpsAfterSwitch(t *testing.T) {
+	var out bytes.Buffer
+	flushes := 0
+	h := newHexSwitch(0.6, &out, func() error { flushes++; return nil })
+	hidden := h.textFilter(nil)
+
+	binary := bytes.Repeat([]byte{0x00, 0xff, 0x80, 0x01}, 8) // one 32-byte sample
+	for i := 0; i < hexEnterSamples; i++ {
+		h.Write(binary)
+	}
+	if !h.active() || hidden(LineEvent{Offset: 0x60}) {
+		t.Fatal("expected hex mode with text hidden")
+	}
+	if !hidden(LineEvent{Offset: 0x10, Text: "boot"}) {
+		t.Error("a line from before the switch should still be shown")
+	}
+	if hidden(LineEvent{Offset: 0x50, Text: strings.Repeat("?", 0x20)}) {
+		t.Error("a line running into the hex stretch should be hidden")
+	}
+	if !strings.Contains(out.String(), "showing hex from offset 0x60") {
+		t.Errorf("missing switch notice: %q", out.String())
+	}
+	out.Reset()
+	h.Write([]byte("AB\x00"))
+	h.dumper.Close() // flush the partial row
+	if want := "00000000  41 42 00"; !strings.HasPrefix(out.String(), want) {
+		t.Errorf("dump = %q, want prefix %q", out.String(), want)
+	}
+	if flushes != hexEnterSamples+1 {
+		t.Errorf("flushes = %d, want one per write", flushes)
+	}
+}
+
+func TestHexSwitch_ReturnsToText(t *testing.T) {
+	var out bytes.Buffer
+	h := newHexSwitch(0.6, &out, nil)
+	h.Write(bytes.Repeat([]byte{0xff}, guardSampleBytes*hexEnterSamples))
+	text := []byte(strings.Repeat("[BOOT] Boot count: 1\r\n", 5)) // 110 bytes
+	h.Write(text)
+	shown := h.textFilter(nil)
+	if h.active() || shown(LineEvent{Offset: 0x70, Text: "x"}) || !shown(LineEvent{Offset: 0xC0}) {
+		t.Fatal("expected text mode for lines after the hex stretch")
+	}
+	if !strings.Contains(out.String(), "back to text") {
+		t.Errorf("missing return notice: %q", out.String())
+	}
+}
+
+func TestHexSwitch_FilterChains(t *testing.T) {
+	h := newHexSwitch(0.6, &bytes.Buffer{}, nil)
+	f := h.textFilter(sampleFilter(nil, 2))
+	var shown []bool
+	for i := 0; i < 4; i++ {
+		shown = append(shown, f(LineEvent{}))
+	}
+	if !shown[0] || shown[1] || !shown[2] || shown[3] {
+		t.Errorf("text mode should defer to the next filter, got %v", shown)
+	}
+}
+
+func TestBinaryLine(t *testing.T) {
+	tests := []struct {
+		text string
+		want bool
+	}{
+		{"[BLE] ready\r", false},
+		{"\x1b[0;32mI (42) wifi: connected\x1b[0m", false},
+		{"name\tsize\tcrc", false},
+		{"[FT] frame \x02\x00\x10", true},
+		{"\xa5\x5a\xff", true},
+	}
+	for _, tt := range tests {
+		if got := binaryLine(tt.text); got != tt.want {
+			t.Errorf("binaryLine(%q) = %v, want %v", tt.text, got, tt.want)
+		}
+	}
+}
+
+func TestHexRows_MatchDumpLayout(t *testing.T) {
+	data := []byte("\x02BLE frame\x00\x01\x02\x03\x04\x05\x06\xff\xfe")
+	want := strings.Split(strings.TrimSuffix(hex.Dump(data), "\n"), "\n")
+	assertSliceEqual(t, hexRows(data, 0), want)
+	if rows := hexRows(data, 0x1000); !strings.HasPrefix(rows[1], "00001010  ") {
+		t.Errorf("row offset not based on stream offset: %q", rows[1])
+	}
+}
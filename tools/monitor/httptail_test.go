@@ -0,0 +1,51 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestTailHandler(t *testing.T) {
+	ring := newLineRing(3)
+	for _, l := range []string{"[BOOT] a", "[HOME] b", "[EPUB] c", "[EPUB] d"} {
+		ring.Write(LineEvent{Text: l})
+	}
+	srv := httptest.NewServer(tailHandler(ring))
+	defer srv.Close()
+
+	tests := []struct {
+		query string
+		code  int
+		body  string
+	}{
+		{"", 200, "[HOME] b\n[EPUB] c\n[EPUB] d\n"},
+		{"?n=1", 200, "[EPUB] d\n"},
+		{"?n=100", 200, "[HOME] b\n[EPUB] c\n[EPUB] d\n"},
+		{"?n=-1", 400, ""},
+	}
+	for _, tt := range tests {
+		resp, err := http.Get(srv.URL + tt.query)
+		if err != nil {
+			t.Fatal(err)
+		}
+		body := make([]byte, 256)
+		n, _ := resp.Body.Read(body)
+		resp.Body.Close()
+		if resp.StatusCode != tt.code {
+			t.Errorf("%q: status %d, want %d", tt.query, resp.StatusCode, tt.code)
+		}
+		if tt.code == 200 && string(body[:n]) != tt.body {
+			t.Errorf("%q: body %q, want %q", tt.query, body[:n], tt.body)
+		}
+	}
+
+	resp, err := http.Post(srv.URL, "text/plain", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusMethodNotAllowed {
+		t.Errorf("POST status %d", resp.StatusCode)
+	}
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// lineEndings maps -eol names to the bytes appended to each sent line.
+var lineEndings = map[string]string{
+	"lf":   "\n",
+	"cr":   "\r",
+	"crlf": "\r\n",
+	"none": "",
+}
+
+func parseLineEnding(name string) (string, error) {
+	eol, ok := lineEndings[name]
+	if !ok {
+		return "", fmt.Errorf("unknown line ending %q (want lf, cr, crlf or none)", name)
+	}
+	return eol, nil
+}
+
+// sendLine writes line to w terminated by eol, replacing any line ending the
+// line already carries.
+func sendLine(w io.Writer, line, eol string) error {
+	_, err := io.WriteString(w, strings.TrimRight(line, "\r\n")+eol)
+	return err
+}
+
+// forwardInput sends each line read from in to w until in is exhausted or a
+// write fails. Lines escape reports as handled are not sent; escape may be nil.
+func forwardInput(in io.Reader, w io.Writer, eol string, escape func(line string) bool) error {
+	sc := bufio.NewScanner(in)
+	for sc.Scan() {
+		if escape != nil && escape(strings.TrimRight(sc.Text(), "\r")) {
+			continue
+		}
+		if err := sendLine(w, sc.Text(), eol); err != nil {
+			return err
+		}
+	}
+	return sc.Err()
+}
+
+// stringList is a flag.Value that collects every use of a repeatable flag.
+type stringList []string
+
+func (l *stringList) String() string {
+	return strings.Join(*l, ", ")
+}
+
+func (l *stringList) Set(v string) error {
+	*l = append(*l, v)
+	return nil
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"strings"
+	"testing"
+)
+
+func TestParseLineEnding(t *testing.T) {
+	for name, want := range map[string]string{"lf": "\n", "cr": "\r", "crlf": "\r\n", "none": ""} {
+		if got, err := parseLineEnding(name); err != nil || got != want {
+			t.Errorf("parseLineEnding(%q) = %q, %v", name, got, err)
+		}
+	}
+	if _, err := parseLineEnding("CRLF"); err == nil {
+		t.Error("expected error for unknown ending")
+	}
+}
+
+func TestSendLine_ReplacesEnding(t *testing.T) {
+	var buf bytes.Buffer
+	sendLine(&buf, "status", "\r\n")
+	sendLine(&buf, "reboot\n", "\r")
+	sendLine(&buf, "ping\r\n", "")
+	if got, want := buf.String(), "status\r\nreboot\rping"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestForwardInput(t *testing.T) {
+	var buf bytes.Buffer
+	if err := forwardInput(strings.NewReader("help\r\nls /books\n\nver"), &buf, "\r\n", nil); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := buf.String(), "help\r\nls /books\r\n\r\nver\r\n"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestForwardInput_Escapes(t *testing.T) {
+	var buf bytes.Buffer
+	var handled []string
+	escape := func(line string) bool {
+		if line == "~r" {
+			handled = append(handled, line)
+			return true
+		}
+		return false
+	}
+	if err := forwardInput(strings.NewReader("ver\n~r\r\n~x\n"), &buf, "\n", escape); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := buf.String(), "ver\n~x\n"; got != want || len(handled) != 1 {
+		t.Errorf("got %q (handled %v), want %q", got, handled, want)
+	}
+}
+
+func TestStringList_Repeatable(t *testing.T) {
+	var sends stringList
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	fs.Var(&sends, "send", "")
+	if err := fs.Parse([]string{"-send", "a", "-send", `b\r`}); err != nil {
+		t.Fatal(err)
+	}
+	assertSliceEqual(t, sends, []string{"a", `b\r`})
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"time"
+)
+
+// readChunk is one read from the port.
+type readChunk struct {
+	data []byte
+	err  error
+}
+
+// lineReader splits a byte stream into lines of any length, like a
+// bufio.Scanner with bufio.ScanLines but without its 64 KiB limit. When idle
+// is set, a partial line that has waited that long for its newline (a login
+// prompt, a progress dot) is returned as a line of its own instead of being
+// held back; the rest of it starts the next line.
+//
+// The port is read on a separate goroutine, so Scan can time out; everything
+// else, including beforeWait, runs on the caller's goroutine.
+type lineReader struct {
+	idle       time.Duration
+	beforeWait func() error // called before blocking for more data; nil for none
+	chunks     chan readChunk
+	buf        []byte // received bytes not yet returned
+	next       int64  // stream offset of buf[0]
+	err        error  // read error to report once buf is drained
+	text       string
+	lineStart  int64 // stream offset where the current line started
+}
+
+func newLineReader(r io.Reader, idle time.Duration) *lineReader {
+	l := &lineReader{idle: idle, chunks: make(chan readChunk, 16)}
+	go func() {
+		for {
+			b := make([]byte, 4096)
+			n, err := r.Read(b)
+			if n > 0 || err != nil {
+				l.chunks <- readChunk{b[:n], err}
+			}
+			if err != nil {
+				return
+			}
+		}
+	}()
+	return l
+}
+
+// Scan advances to the next line, which Text returns. It returns false once
+// the stream has ended and every line has been returned.
+func (l *lineReader) Scan() bool {
+	for {
+		if i := bytes.IndexByte(l.buf, '\n'); i >= 0 {
+			l.take(i, i+1)
+			return true
+		}
+		if l.err != nil {
+			if len(l.buf) > 0 {
+				l.take(len(l.buf), len(l.buf))
+				return true
+			}
+			return false
+		}
+		if l.beforeWait != nil {
+			if err := l.beforeWait(); err != nil {
+				l.err = err
+				continue
+			}
+		}
+		if l.idle <= 0 || len(l.buf) == 0 {
+			l.receive(<-l.chunks)
+			continue
+		}
+		t := time.NewTimer(l.idle)
+		select {
+		case c := <-l.chunks:
+			t.Stop()
+			l.receive(c)
+		case <-t.C:
+			l.take(len(l.buf), len(l.buf))
+			return true
+		}
+	}
+}
+
+func (l *lineReader) receive(c readChunk) {
+	l.buf = append(l.buf, c.data...)
+	l.err = c.err
+}
+
+// take makes the first n bytes of buf the current line, dropping a trailing
+// CR, and consumes advance bytes.
+func (l *lineReader) take(n, advance int) {
+	l.text = string(bytes.TrimSuffix(l.buf[:n], []byte{'\r'}))
+	l.lineStart = l.next
+	l.next += int64(advance)
+	l.buf = l.buf[advance:]
+}
+
+// Text returns the current line without its terminator.
+func (l *lineReader) Text() string {
+	return l.text
+}
+
+// Err returns the error that ended the stream, or nil at a clean end.
+func (l *lineReader) Err() error {
+	if l.err == io.EOF {
+		return nil
+	}
+	return l.err
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLineReader_Offsets(t *testing.T) {
+	input := "boot\r\n\nESP-ROM:esp32s3\npartial"
+	l := newLineReader(strings.NewReader(input), 0)
+	var offsets []int64
+	var lines []string
+	for l.Scan() {
+		lines = append(lines, l.Text())
+		offsets = append(offsets, l.lineStart)
+	}
+	assertSliceEqual(t, lines, []string{"boot", "", "ESP-ROM:esp32s3", "partial"})
+	want := []int64{0, 6, 7, 23}
+	for i := range want {
+		if offsets[i] != want[i] {
+			t.Errorf("line %d: offset %d, want %d", i, offsets[i], want[i])
+		}
+	}
+	if l.Err() != nil {
+		t.Errorf("clean end reported %v", l.Err())
+	}
+}
+
+func TestLineReader_LongLine(t *testing.T) {
+	long := strings.Repeat("x", 1<<20)
+	l := newLineReader(strings.NewReader(long+"\nnext\n"), 0)
+	if !l.Scan() || l.Text() != long {
+		t.Fatalf("long line lost: %d bytes", len(l.Text()))
+	}
+	if !l.Scan() || l.Text() != "next" || l.lineStart != 1<<20+1 {
+		t.Errorf("after long line: %q at %d", l.Text(), l.lineStart)
+	}
+}
+
+func TestLineReader_PartialLineAfterIdle(t *testing.T) {
+	r, w := io.Pipe()
+	l := newLineReader(r, 20*time.Millisecond)
+	go func() {
+		io.WriteString(w, "[BOOT] ready\nsumi> ")
+		time.Sleep(200 * time.Millisecond)
+		io.WriteString(w, "help\n")
+		w.CloseWithError(errors.New("port closed"))
+	}()
+	var lines []string
+	for l.Scan() {
+		lines = append(lines, l.Text())
+	}
+	assertSliceEqual(t, lines, []string{"[BOOT] ready", "sumi> ", "help"})
+	if l.Err() == nil || l.Err().Error() != "port closed" {
+		t.Errorf("err %v", l.Err())
+	}
+}
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+
+	"go.bug.st/serial"
+	"golang.org/x/sys/unix"
+)
+
+// openPTY opens a pseudo-terminal and returns its master and the name of its
+// slave, which stands in for a serial device.
+func openPTY(t *testing.T) (int, string) {
+	t.Helper()
+	master, err := unix.Open("/dev/ptmx", unix.O_RDWR|unix.O_NOCTTY|unix.O_CLOEXEC, 0)
+	if err != nil {
+		t.Skipf("no pseudo-terminals: %v", err)
+	}
+	t.Cleanup(func() { unix.Close(master) })
+	if err := unix.IoctlSetPointerInt(master, unix.TIOCSPTLCK, 0); err != nil {
+		t.Fatal(err)
+	}
+	n, err := unix.IoctlGetInt(master, unix.TIOCGPTN)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return master, fmt.Sprintf("/dev/pts/%d", n)
+}
+
+func TestOpenLocalPort_ActualBaudRate(t *testing.T) {
+	_, name := openPTY(t)
+	for _, baud := range []int{115200, 921600} {
+		p, err := openPort(name, &serial.Mode{BaudRate: baud})
+		if err != nil {
+			t.Fatal(err)
+		}
+		rate, ok := actualBaudRate(p)
+		p.Close()
+		if !ok || rate != baud {
+			t.Errorf("opened at %d, read back %d, %v", baud, rate, ok)
+		}
+	}
+}
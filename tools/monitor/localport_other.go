@@ -0,0 +1,11 @@
+//go:build !unix
+
+package main
+
+import "go.bug.st/serial"
+
+// openLocalPort opens a serial device. Windows has no way to read back the
+// rate the driver set, so the port doesn't report it.
+func openLocalPort(name string, mode *serial.Mode) (serial.Port, error) {
+	return serial.Open(name, mode)
+}
@@ -0,0 +1,48 @@
+//go:build unix
+
+package main
+
+import (
+	"go.bug.st/serial"
+	"golang.org/x/sys/unix"
+)
+
+// localPort is a serial device with a second descriptor for reading its line
+// settings back. go.bug.st/serial keeps its own descriptor to itself and
+// takes the device exclusively, so the second one is opened first.
+type localPort struct {
+	serial.Port
+	fd int
+}
+
+// openLocalPort opens a serial device that can report the baud rate its
+// driver set. If the extra descriptor can't be had, the port is opened
+// without it.
+func openLocalPort(name string, mode *serial.Mode) (serial.Port, error) {
+	fd, err := unix.Open(name, unix.O_RDONLY|unix.O_NOCTTY|unix.O_NONBLOCK|unix.O_CLOEXEC, 0)
+	if err != nil {
+		return serial.Open(name, mode)
+	}
+	p, err := serial.Open(name, mode)
+	if err != nil {
+		unix.Close(fd)
+		return nil, err
+	}
+	return &localPort{Port: p, fd: fd}, nil
+}
+
+// ActualBaudRate reads the output speed from the device. A driver that can't
+// divide its clock down to the requested rate stores the one it picked.
+func (p *localPort) ActualBaudRate() (int, error) {
+	t, err := unix.IoctlGetTermios(p.fd, ioctlReadSpeed)
+	if err != nil {
+		return 0, err
+	}
+	return int(t.Ospeed), nil
+}
+
+func (p *localPort) Close() error {
+	err := p.Port.Close()
+	unix.Close(p.fd)
+	return err
+}
@@ -0,0 +1,31 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// defaultLocateCmd is sent by -locate unless -locate-cmd overrides it. The
+// command that beeps or flashes a board depends on the firmware build.
+const defaultLocateCmd = "locate"
+
+// parseCommand expands Go-style escapes (\r, \n, \x1b, ...) in a command given
+// on the command line.
+func parseCommand(s string) (string, error) {
+	out, err := strconv.Unquote(`"` + strings.ReplaceAll(s, `"`, `\"`) + `"`)
+	if err != nil {
+		return "", fmt.Errorf("bad escape in command %q", s)
+	}
+	return out, nil
+}
+
+// sendCommand writes cmd followed by a newline unless it already ends in one.
+func sendCommand(w io.Writer, cmd string) error {
+	if !strings.HasSuffix(cmd, "\n") {
+		cmd += "\n"
+	}
+	_, err := io.WriteString(w, cmd)
+	return err
+}
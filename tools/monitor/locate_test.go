@@ -0,0 +1,34 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestParseCommand(t *testing.T) {
+	tests := map[string]string{
+		"locate":         "locate",
+		`beep 3\r\n`:     "beep 3\r\n",
+		`say "hi"`:       `say "hi"`,
+		`\x1b[identify]`: "\x1b[identify]",
+		`path C:\\books`: `path C:\books`,
+	}
+	for in, want := range tests {
+		got, err := parseCommand(in)
+		if err != nil || got != want {
+			t.Errorf("parseCommand(%q) = %q, %v; want %q", in, got, err, want)
+		}
+	}
+	if _, err := parseCommand(`bad \q`); err == nil {
+		t.Error("expected error for unknown escape")
+	}
+}
+
+func TestSendCommand(t *testing.T) {
+	var buf bytes.Buffer
+	sendCommand(&buf, "locate")
+	sendCommand(&buf, "beep\r\n")
+	if buf.String() != "locate\nbeep\r\n" {
+		t.Errorf("got %q", buf.String())
+	}
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"regexp"
+	"strconv"
+)
+
+// logRecord is a firmware log line split into its parts. Fields a line does
+// not carry are left empty; a line in no known format is all msg.
+type logRecord struct {
+	ts    int64 // device milliseconds since boot, valid when hasTS
+	hasTS bool
+	level string
+	tag   string
+	msg   string
+}
+
+var (
+	// ESP-IDF: "E (1234) wifi: connect failed"
+	idfLogLine = regexp.MustCompile(`^([EWIDV]) \((\d+)\) ([^:]+): ?(.*)$`)
+	// Arduino-ESP32 core: "[  1042][E][sd_diskio.cpp:802] sdcard_mount(): f_mount failed"
+	arduinoLogLine = regexp.MustCompile(`^\[\s*(\d+)\]\[([EWIDV])\]\[([^\]]+)\] ?(.*)$`)
+	// SUMI: "[1042] [EPUB] Opening ..." or "[EPUB] Opening ..."
+	sumiLogLine = regexp.MustCompile(`^(?:\[(\d+)\] )?\[([A-Z][A-Z0-9_]*)\] ?(.*)$`)
+)
+
+// parseLogLine splits text, with any color codes removed, into a logRecord.
+func parseLogLine(text string) logRecord {
+	text = stripANSI(text)
+	rec := logRecord{msg: text}
+	setTS := func(s string) {
+		if ts, err := strconv.ParseInt(s, 10, 64); err == nil {
+			rec.ts, rec.hasTS = ts, true
+		}
+	}
+	if m := idfLogLine.FindStringSubmatch(text); m != nil {
+		setTS(m[2])
+		rec.level, rec.tag, rec.msg = m[1], m[3], m[4]
+	} else if m := arduinoLogLine.FindStringSubmatch(text); m != nil {
+		setTS(m[1])
+		rec.level, rec.tag, rec.msg = m[2], m[3], m[4]
+	} else if m := sumiLogLine.FindStringSubmatch(text); m != nil {
+		if m[1] != "" {
+			setTS(m[1])
+		}
+		rec.tag, rec.msg = m[2], m[3]
+	}
+	return rec
+}
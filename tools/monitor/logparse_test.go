@@ -0,0 +1,24 @@
+package main
+
+import "testing"
+
+func TestParseLogLine(t *testing.T) {
+	tests := []struct {
+		line string
+		want logRecord
+	}{
+		{"E (1234) wifi: connect failed", logRecord{ts: 1234, hasTS: true, level: "E", tag: "wifi", msg: "connect failed"}},
+		{"\x1b[0;33mW (88) boot: PRO CPU reset\x1b[0m", logRecord{ts: 88, hasTS: true, level: "W", tag: "boot", msg: "PRO CPU reset"}},
+		{"[  1042][E][sd_diskio.cpp:802] sdcard_mount(): f_mount failed",
+			logRecord{ts: 1042, hasTS: true, level: "E", tag: "sd_diskio.cpp:802", msg: "sdcard_mount(): f_mount failed"}},
+		{"[5120] [THUMB] Stored /thumbs/a.bin (4096 bytes)", logRecord{ts: 5120, hasTS: true, tag: "THUMB", msg: "Stored /thumbs/a.bin (4096 bytes)"}},
+		{"[BOOT] Boot count: 3", logRecord{tag: "BOOT", msg: "Boot count: 3"}},
+		{"ESP-ROM:esp32c3-api1-20210207", logRecord{msg: "ESP-ROM:esp32c3-api1-20210207"}},
+		{"[0x3fc9] not a tag", logRecord{msg: "[0x3fc9] not a tag"}},
+	}
+	for _, tt := range tests {
+		if got := parseLogLine(tt.line); got != tt.want {
+			t.Errorf("parseLogLine(%q) = %+v, want %+v", tt.line, got, tt.want)
+		}
+	}
+}
@@ -2,18 +2,68 @@ package main
 
 import (
 	"bufio"
+	"errors"
 	"flag"
 	"fmt"
 	"io"
+	"net"
+	"net/http"
 	"os"
 	"os/signal"
+	"regexp"
 	"runtime"
+	"slices"
+	"strconv"
 	"strings"
+	"sync/atomic"
+	"time"
 
 	"go.bug.st/serial"
 )
 
-// filterPorts returns port names matching known ESP32 CDC patterns for the given OS.
+// matchTimeoutStatus is the exit status when -timeout expires before -match
+// has matched, the one timeout(1) uses.
+const matchTimeoutStatus = 124
+
+// matchEnd is how a -match run ended.
+type matchEnd int
+
+const (
+	matchFound matchEnd = iota
+	matchTimedOut
+	matchPortClosed
+)
+
+// matchResult returns the exit status and message for a -match run that
+// ended as end: 0 once a line matched, matchTimeoutStatus when -timeout
+// expired first and 1 when the port closed first, so that a CI job can tell
+// a device that never booted from one that went away.
+func matchResult(end matchEnd, pattern string, timeout time.Duration) (int, string) {
+	switch end {
+	case matchFound:
+		return 0, fmt.Sprintf("Matched -match %q.", pattern)
+	case matchTimedOut:
+		return matchTimeoutStatus, fmt.Sprintf("No line matched -match %q within %s.", pattern, timeout)
+	default:
+		return 1, fmt.Sprintf("Port closed before a line matched -match %q.", pattern)
+	}
+}
+
+// watchLine checks a received line against -match and the -on triggers,
+// running any trigger commands, and returns the exit status that ends the
+// session, or -1 to carry on. It is given the line as it was before -pipe,
+// so a line the decoder hides or rewrites still counts.
+func watchLine(ev LineEvent, match *regexp.Regexp, triggers []trigger, stderr io.Writer) int {
+	if match != nil && match.MatchString(ev.Text) {
+		code, msg := matchResult(matchFound, match.String(), 0)
+		fmt.Fprintf(stderr, "\n%s\n", msg)
+		return code
+	}
+	return fireTriggers(triggers, ev, stderr)
+}
+
+// filterPorts returns port names matching known ESP32 CDC patterns for the given
+// OS. On an OS without known patterns every port is a candidate.
 func filterPorts(ports []string, goos string) []string {
 	var candidates []string
 	for _, p := range ports {
@@ -30,6 +80,16 @@ func filterPorts(ports []string, goos string) []string {
 			if strings.HasPrefix(p, "COM") {
 				candidates = append(candidates, p)
 			}
+		case "freebsd", "openbsd":
+			// Each USB serial device has a callout node (cuaU*), which opens
+			// without waiting for carrier, and a dial-in node (ttyU*). Take the
+			// callout node, and the dial-in node only when it has no twin.
+			if strings.HasPrefix(p, "/dev/cuaU") ||
+				strings.HasPrefix(p, "/dev/ttyU") && !slices.Contains(ports, "/dev/cuaU"+strings.TrimPrefix(p, "/dev/ttyU")) {
+				candidates = append(candidates, p)
+			}
+		default:
+			candidates = append(candidates, p)
 		}
 	}
 	return candidates
@@ -47,30 +107,492 @@ func selectPort(candidates []string, allPorts []string) (string, error) {
 	}
 }
 
-func autoDetectPort() (string, error) {
+// portBusyFunc reports whether a port is currently held by another process.
+type portBusyFunc func(name string) bool
+
+// skipBusyPorts drops candidates that isBusy reports as held by another process.
+func skipBusyPorts(candidates []string, isBusy portBusyFunc) []string {
+	var free []string
+	for _, p := range candidates {
+		if !isBusy(p) {
+			free = append(free, p)
+		}
+	}
+	return free
+}
+
+// isPortBusy test-opens a port with DTR and RTS deasserted, so the board is not
+// reset, and reports whether the open failed because the port is in use.
+func isPortBusy(name string) bool {
+	mode := &serial.Mode{BaudRate: 115200, InitialStatusBits: &serial.ModemOutputBits{}}
+	port, err := serial.Open(name, mode)
+	if err != nil {
+		var perr *serial.PortError
+		return errors.As(err, &perr) && perr.Code() == serial.PortBusy
+	}
+	port.Close()
+	return false
+}
+
+// baudReporter is implemented by ports that can report the baud rate actually
+// configured: local devices on Linux and the BSDs, and rfc2217:// ports.
+type baudReporter interface {
+	ActualBaudRate() (int, error)
+}
+
+// actualBaudRate returns the configured baud rate if the port can report it.
+func actualBaudRate(port serial.Port) (int, bool) {
+	r, ok := port.(baudReporter)
+	if !ok {
+		return 0, false
+	}
+	rate, err := r.ActualBaudRate()
+	if err != nil || rate <= 0 {
+		return 0, false
+	}
+	return rate, true
+}
+
+// checkBaudRate warns on w when port runs at a rate too far from requested to
+// read, and with verbose says what rate it runs at.
+func checkBaudRate(w io.Writer, port serial.Port, requested int, verbose bool) {
+	actual, ok := actualBaudRate(port)
+	if !ok {
+		if verbose {
+			fmt.Fprintf(w, "Driver does not report the configured baud rate\n")
+		}
+		return
+	}
+	if verbose {
+		fmt.Fprintf(w, "Driver configured %d baud\n", actual)
+	}
+	if baudMismatch(requested, actual) {
+		fmt.Fprintf(w, "Warning: requested %d baud but the driver configured %d; output may be garbled\n", requested, actual)
+	}
+}
+
+// baudMismatch reports whether actual is more than 2% away from requested, beyond
+// which a UART starts misframing bytes.
+func baudMismatch(requested, actual int) bool {
+	if requested <= 0 {
+		return false
+	}
+	diff := actual - requested
+	if diff < 0 {
+		diff = -diff
+	}
+	return diff*100 > requested*2
+}
+
+// looseSelect picks from ports that did not match the known patterns: the sole
+// port if there is one, otherwise whatever choose returns. A nil choose means
+// the user cannot be asked.
+func looseSelect(ports []string, choose func([]string) (string, error)) (string, error) {
+	switch {
+	case len(ports) == 0:
+		return "", fmt.Errorf("no serial ports found")
+	case len(ports) == 1:
+		return ports[0], nil
+	case choose == nil:
+		return "", fmt.Errorf("no known ESP32 port and several others, specify one with -port: %v", ports)
+	}
+	return choose(ports)
+}
+
+// promptPort lists ports on out and reads the user's numbered choice from in.
+func promptPort(in io.Reader, out io.Writer, ports []string) (string, error) {
+	fmt.Fprintf(out, "No known ESP32 port found. Available ports:\n")
+	for i, p := range ports {
+		fmt.Fprintf(out, "  %d) %s\n", i+1, p)
+	}
+	fmt.Fprintf(out, "Choose a port [1-%d]: ", len(ports))
+	line, err := bufio.NewReader(in).ReadString('\n')
+	if err != nil && line == "" {
+		return "", fmt.Errorf("no port chosen: %w", err)
+	}
+	n, err := strconv.Atoi(strings.TrimSpace(line))
+	if err != nil || n < 1 || n > len(ports) {
+		return "", fmt.Errorf("invalid choice %q", strings.TrimSpace(line))
+	}
+	return ports[n-1], nil
+}
+
+func autoDetectPort(skipBusy, loose bool) (string, error) {
 	ports, err := serial.GetPortsList()
 	if err != nil {
 		return "", fmt.Errorf("failed to list serial ports: %w", err)
 	}
-	candidates := filterPorts(ports, runtime.GOOS)
-	return selectPort(candidates, ports)
+	candidates := candidatePorts(ports, runtime.GOOS)
+	if skipBusy {
+		candidates = skipBusyPorts(candidates, isPortBusy)
+	}
+	if len(candidates) > 0 || !loose {
+		return selectPort(candidates, ports)
+	}
+	others := ports
+	if skipBusy {
+		others = skipBusyPorts(others, isPortBusy)
+	}
+	var choose func([]string) (string, error)
+	if _, tty := terminalWidth(os.Stdin); tty {
+		choose = func(ports []string) (string, error) { return promptPort(os.Stdin, os.Stderr, ports) }
+	}
+	return looseSelect(others, choose)
+}
+
+// hiddenFlags are development aids left out of the -h listing.
+var hiddenFlags = map[string]bool{"fault-inject": true}
+
+// usage prints flag help without the hidden development flags.
+func usage() {
+	out := flag.CommandLine.Output()
+	fmt.Fprintf(out, "Usage of %s:\n", os.Args[0])
+	visible := flag.NewFlagSet(os.Args[0], flag.ContinueOnError)
+	visible.SetOutput(out)
+	flag.VisitAll(func(f *flag.Flag) {
+		if !hiddenFlags[f.Name] {
+			visible.Var(f.Value, f.Name, f.Usage)
+		}
+	})
+	visible.PrintDefaults()
 }
 
 func main() {
-	portFlag := flag.String("port", "", "serial port (e.g. /dev/ttyACM0, COM3). Auto-detect if omitted")
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "extract":
+			os.Exit(runExtract(os.Args[2:]))
+		case "export":
+			os.Exit(runExport(os.Args[2:]))
+		case "replay":
+			os.Exit(runReplay(os.Args[2:]))
+		case "dump":
+			os.Exit(runDump(os.Args[2:]))
+		}
+	}
+
+	var portFlag stringList
+	flag.Var(&portFlag, "port", "serial port (e.g. /dev/ttyACM0, COM3, rfc2217://host:4000 or tcp://host:4000); repeat to monitor several at once. Auto-detect if omitted")
+	allFlag := flag.Bool("all", false, "monitor every candidate port at once, each line labeled with its port")
 	speedFlag := flag.Int("speed", 115200, "baud rate")
 	logFlag := flag.String("log", "", "log file path (output to both stdout and file)")
+	splitByTagFlag := flag.String("split-by-tag", "", "also write each log tag's lines to its own file in this directory (EPUB.log, BLE.log, ...)")
+	logDirFlag := flag.String("log-dir", "", "write each session's log to a new file in this directory, named by -log-name")
+	logNameFlag := flag.String("log-name", defaultLogName, "file name template for -log-dir: {device} (USB serial number), {port}, {date} and {time}")
+	verboseFlag := flag.Bool("v", false, "verbose output")
+	stampWrapFlag := flag.Bool("stamp-wrap", false, "hard-wrap long lines at the terminal width with a hanging indent (log file stays unwrapped)")
+	stdoutFormatFlag := flag.String("stdout-format", "text", "stdout format: text, json, jsonl (parsed level/tag/msg records), csv or length-prefixed (netstrings, for piping into other tools)")
+	logMaxSizeFlag := flag.String("log-max-size", "", "rotate the -log file before it grows past this size, e.g. 100M (K, M, G suffixes)")
+	logMaxAgeFlag := flag.Duration("log-max-age", 0, "rotate the -log file after it has been open this long, e.g. 1h")
+	logKeepFlag := flag.Int("log-keep", 0, "rotated -log files to keep, deleting the oldest (0 keeps all)")
+	logANSIFlag := flag.String("log-ansi", "auto", "ANSI color codes in the -log file: keep, strip, html (colored HTML page) or auto (html for a .html path, else keep); the terminal keeps them")
+	logFormatFlag := flag.String("log-format", "text", "log file format: text, json, jsonl, csv or length-prefixed")
+	looseFlag := flag.Bool("loose", false, "if no known ESP32 port is found, fall back to the other ports (picks the only one or asks)")
+	skipBusyFlag := flag.Bool("skip-busy", false, "during auto-detect, ignore ports already open in another process")
+	statusBlockFlag := flag.String("status-block", "", "redraw a repeating status block in place: <start-regexp>..<end-regexp>")
+	bootIdleFlag := flag.Duration("abort-on-idle-at-boot", 0, "exit with status 2 if output stops for this long after a boot banner, before the ready line")
+	stallFlag := flag.Duration("stall", 0, "warn when no bytes arrive from the device for this long (e.g. 10s)")
+	stallRunFlag := flag.String("stall-run", "", "with -stall, run this command when output stalls (the warning is in SUMI_LINE)")
+	stallBellFlag := flag.Bool("stall-bell", false, "with -stall, ring the terminal bell when output stalls")
+	bootLoopFlag := flag.Int("boot-loop", 3, "warn when the device resets this many times within -boot-loop-window (0 disables)")
+	bootLoopWindowFlag := flag.Duration("boot-loop-window", time.Minute, "how close together -boot-loop resets must be")
+	bootBannerFlag := flag.String("boot-banner", defaultBootBanner, "regexp that arms -abort-on-idle-at-boot")
+	bootReadyFlag := flag.String("boot-ready", defaultBootReady, "regexp that disarms -abort-on-idle-at-boot (empty to stay armed)")
+	probeAllFlag := flag.Bool("probe-all", false, "listen briefly to every candidate port, report each board's banner and exit")
+	bannerTimeoutFlag := flag.Duration("banner-timeout", defaultBannerTimeout, "how long banner detection (-probe-all) waits for a board to identify itself")
+	readyFdFlag := flag.Int("ready-fd", 0, "write \"READY <port>\" to this file descriptor once reading starts (2 for stderr)")
+	colorFlag := flag.String("color", "auto", "color error and warning lines on stdout: auto (terminals, unless NO_COLOR is set), always or never")
+	elfFlag := flag.String("elf", "", "firmware ELF with debug info; panic backtraces are decoded to function, file and line")
+	timestampFlag := flag.String("timestamp", "", "prefix each line on stdout and in -log with its time: absolute, relative (since start) or delta (since the previous line)")
+	showOffsetFlag := flag.Bool("show-offset", false, "prefix each line with the raw-stream byte offset where it started (@0x1A3F)")
+	minPrintableFlag := flag.Float64("min-printable-ratio", 0.6, "warn when the printable share of received bytes stays below this (0 disables; off with -hex and -raw)")
+	printableWindowFlag := flag.Duration("printable-window", 3*time.Second, "how long -min-printable-ratio must be breached before warning")
+	locateFlag := flag.Bool("locate", false, "send the identify command so the board beeps or flashes, then exit")
+	locateCmdFlag := flag.String("locate-cmd", defaultLocateCmd, "identify command sent by -locate (firmware specific, Go escapes allowed)")
+	hexdumpOnErrorFlag := flag.Bool("hexdump-on-error", false, "show a hex dump instead of text while output stays below -min-printable-ratio")
+	hexFlag := flag.Bool("hex", false, "show lines holding binary bytes as a hex+ASCII dump; readable lines print normally")
+	tuiFlag := flag.Bool("tui", false, "full-screen view with pause, scrollback, search and a status bar (keys: space, j/k, b/f, g/G, /, n/N, R reset, B bootloader, S screenshot, q)")
+	vtFlag := flag.Bool("vt", false, "interpret ANSI cursor control into a virtual screen and print snapshots of it")
+	vtSizeFlag := flag.String("vt-size", "80x24", "virtual screen size for -vt, <cols>x<rows>")
+	vtIntervalFlag := flag.Duration("vt-interval", 500*time.Millisecond, "how often -vt prints the screen when it has changed")
+	utf8OffsetsFlag := flag.Bool("utf8-offsets", false, "list the stream offsets of malformed UTF-8 in the exit summary")
+	metricsFlag := flag.String("metrics", "", "write heap, render time and battery telemetry from the device to this CSV file")
+	gdbFlag := flag.String("gdb", "", "serve the device's GDB stub on this address (e.g. localhost:3333) for gdb's target remote, while other output keeps showing")
+	syslogFlag := flag.String("syslog", "", "forward device lines to syslog (RFC 5424, hostname = device serial number): local, udp://host:port or tcp://host:port")
+	prometheusFlag := flag.String("prometheus", "", "serve device telemetry (heap, battery, refresh latency, resets) for Prometheus at http://<address>/metrics (e.g. :9464)")
+	rawFlag := flag.String("raw", "", "also write the unmodified byte stream from the port to this file")
+	combinedFlag := flag.String("combined", "", "write raw bytes and formatted lines interleaved to one file (split with \"extract\")")
+	httpTailFlag := flag.String("http-tail", "", "serve the most recent lines as plain text over HTTP on this address (e.g. :8334)")
+	serveFlag := flag.String("serve", "", "re-broadcast the stream to WebSocket, HTTP and raw TCP clients on this address (e.g. :8333)")
+	serveFormatFlag := flag.String("serve-format", "text", "-serve record format: text, json, jsonl, csv or length-prefixed")
+	httpTailLinesFlag := flag.Int("http-tail-lines", 200, "how many lines -http-tail keeps")
+	sampleFlag := flag.Int("sample", 1, "show and log only every Nth line (detectors still see every line)")
+	partialLineFlag := flag.Duration("partial-line", 500*time.Millisecond, "show a line that has waited this long for its newline (a prompt, progress dots) instead of holding it back; 0 waits for the newline")
+	flushModeFlag := flag.String("flush-mode", "line", "stdout flushing: line (lowest latency) or batch (fewer writes at high baud)")
+	matchFlag := flag.String("match", "", "wait for a line matching this regexp and exit 0; exit 1 if the port closes first (for CI)")
+	timeoutFlag := flag.Duration("timeout", 0, fmt.Sprintf("with -match, exit with status %d if nothing has matched after this long", matchTimeoutStatus))
+	pipeFlag := flag.String("pipe", "", "pass each line through this command (one line in, one line out; an empty answer hides the line) before it is shown or logged; -match and -on see lines as received")
+	var onFlag stringList
+	flag.Var(&onFlag, "on", "act on lines matching a regex: \"<regexp>=>exit <status>\" or \"<regexp>=>run <command>\" (repeatable)")
+	var includeFlag, excludeFlag stringList
+	flag.Var(&includeFlag, "include", "show and log only lines matching this regex (repeatable; any may match)")
+	flag.Var(&excludeFlag, "exclude", "hide lines matching this regex from stdout and the log (repeatable)")
+	var sendFlag stringList
+	flag.Var(&sendFlag, "send", "send this command once the port is open (Go escapes allowed, repeatable)")
+	interactiveFlag := flag.Bool("interactive", false, "send each line typed on stdin to the device; ~r on its own line resets it, ~b enters the bootloader and ~s takes a screenshot")
+	resetFlag := flag.Bool("reset", false, "reset the device through DTR/RTS after opening the port, so the boot log is captured")
+	bootloaderFlag := flag.Bool("bootloader", false, "put the device into download mode through DTR/RTS after opening the port")
+	screenshotFlag := flag.Bool("screenshot", false, "ask the device for a screenshot after opening the port")
+	screenshotDirFlag := flag.String("screenshot-dir", ".", "where screenshots sent by the device are saved as PNG")
+	coreDumpDirFlag := flag.String("coredump-dir", ".", "where core dumps printed by the device are saved as ELF core files (decoded with esp-coredump when -elf is set)")
+	eolFlag := flag.String("eol", "lf", "line ending for -send and -interactive: lf, cr, crlf or none")
+	waitFlag := flag.Bool("wait", false, "if no device is attached yet, wait for one (or for -port) to appear and attach as soon as it does, catching its first boot lines")
+	reconnectFlag := flag.Bool("reconnect", false, "when the port drops (reset, reflash, unplug), wait for it to return and keep monitoring")
+	captureFlag := flag.String("capture", "", "record the exact bytes read from and written to the port, with timing, to this .sumicap file (print it with the dump subcommand)")
+	transcriptFlag := flag.String("transcript", "", "write device output (RX) and everything sent to it (TX) to this file with timestamps")
+	requireGroupFlag := flag.Bool("require-group", false, "on Linux, exit before opening the port if your user lacks access to it")
+	faultFlag := flag.String("fault-inject", "", "testing aid: drop the connection (after=<duration>, lines=<n>)")
+	profileFlag := flag.String("profile", "", "apply the named profile from the profile file; flags given here override it")
+	configFlag := flag.String("config", "", "profile file for -profile (default ~/.config/sumi/monitor.toml)")
+	flag.Usage = usage
 	flag.Parse()
 
-	portName := *portFlag
-	if portName == "" {
-		detected, err := autoDetectPort()
+	if *profileFlag != "" {
+		if err := useProfile(*configFlag, *profileFlag); err != nil {
+			fmt.Fprintf(os.Stderr, "-profile: %v\n", err)
+			os.Exit(1)
+		}
+	}
+
+	var fault faultSpec
+	if *faultFlag != "" {
+		var err error
+		if fault, err = parseFaultSpec(*faultFlag); err != nil {
+			fmt.Fprintf(os.Stderr, "%v\n", err)
+			os.Exit(1)
+		}
+	}
+
+	stdoutFormat, err := lookupFormatter(*stdoutFormatFlag)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "-stdout-format: %v\n", err)
+		os.Exit(1)
+	}
+	logFormat, err := lookupFormatter(*logFormatFlag)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "-log-format: %v\n", err)
+		os.Exit(1)
+	}
+
+	rotation := logRotation{maxAge: *logMaxAgeFlag, keep: *logKeepFlag}
+	if *logMaxSizeFlag != "" {
+		if rotation.maxSize, err = parseByteSize(*logMaxSizeFlag); err != nil {
+			fmt.Fprintf(os.Stderr, "-log-max-size: %v\n", err)
+			os.Exit(1)
+		}
+	}
+	if *logFlag != "" && *logDirFlag != "" {
+		fmt.Fprintf(os.Stderr, "-log and -log-dir cannot be combined\n")
+		os.Exit(1)
+	}
+	if rotation != (logRotation{}) && *logFlag == "" && *logDirFlag == "" {
+		fmt.Fprintf(os.Stderr, "-log-max-size, -log-max-age and -log-keep need -log or -log-dir\n")
+		os.Exit(1)
+	}
+	// sessionLog names the -log-dir file for a port and creates the
+	// directory.
+	sessionLog := func(port string) (string, error) {
+		if err := os.MkdirAll(*logDirFlag, 0755); err != nil {
+			return "", err
+		}
+		return sessionLogPath(*logDirFlag, *logNameFlag, port, deviceSerial(port), time.Now())
+	}
+	if *logDirFlag != "" {
+		if _, err := sessionLogPath(*logDirFlag, *logNameFlag, "", "", time.Now()); err != nil {
+			fmt.Fprintf(os.Stderr, "-log-name: %v\n", err)
+			os.Exit(1)
+		}
+	}
+	if rotation.maxAge < 0 || rotation.keep < 0 {
+		fmt.Fprintf(os.Stderr, "-log-max-age and -log-keep cannot be negative\n")
+		os.Exit(1)
+	}
+
+	var block *statusBlock
+	if *statusBlockFlag != "" {
+		if block, err = parseStatusBlock(*statusBlockFlag); err != nil {
+			fmt.Fprintf(os.Stderr, "%v\n", err)
+			os.Exit(1)
+		}
+	}
+
+	var screen *vtScreen
+	if *vtFlag {
+		rows, cols, err := parseVTSize(*vtSizeFlag)
 		if err != nil {
-			fmt.Fprintf(os.Stderr, "Auto-detect failed: %v\n", err)
+			fmt.Fprintf(os.Stderr, "%v\n", err)
 			os.Exit(1)
 		}
-		portName = detected
-		fmt.Fprintf(os.Stderr, "Auto-detected port: %s\n", portName)
+		screen = newVTScreen(rows, cols)
+	}
+
+	var prefixers []prefixer
+	if *timestampFlag != "" {
+		stamp, err := timestampPrefix(*timestampFlag, time.Now())
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "-timestamp: %v\n", err)
+			os.Exit(1)
+		}
+		prefixers = append(prefixers, stamp)
+	}
+	if *showOffsetFlag {
+		prefixers = append(prefixers, offsetPrefix)
+	}
+
+	var triggers []trigger
+	for _, spec := range onFlag {
+		t, err := parseTrigger(spec)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%v\n", err)
+			os.Exit(1)
+		}
+		triggers = append(triggers, t)
+	}
+	if len(triggers) > 0 && *vtFlag {
+		fmt.Fprintf(os.Stderr, "-on cannot be combined with -vt\n")
+		os.Exit(1)
+	}
+	var match *regexp.Regexp
+	if *matchFlag != "" {
+		if match, err = regexp.Compile(*matchFlag); err != nil {
+			fmt.Fprintf(os.Stderr, "-match: %v\n", err)
+			os.Exit(1)
+		}
+		if *vtFlag {
+			fmt.Fprintf(os.Stderr, "-match cannot be combined with -vt\n")
+			os.Exit(1)
+		}
+	}
+	if *pipeFlag != "" && *vtFlag {
+		fmt.Fprintf(os.Stderr, "-pipe cannot be combined with -vt\n")
+		os.Exit(1)
+	}
+	if (*stallRunFlag != "" || *stallBellFlag) && *stallFlag <= 0 {
+		fmt.Fprintf(os.Stderr, "-stall-run and -stall-bell need -stall\n")
+		os.Exit(1)
+	}
+	if *timeoutFlag != 0 && (match == nil || *timeoutFlag < 0) {
+		fmt.Fprintf(os.Stderr, "-timeout needs -match and a positive duration\n")
+		os.Exit(1)
+	}
+
+	include, err := compilePatterns("include", includeFlag)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		os.Exit(1)
+	}
+	exclude, err := compilePatterns("exclude", excludeFlag)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		os.Exit(1)
+	}
+	lineFilter := matchFilter(include, exclude)
+
+	if *sampleFlag < 1 {
+		fmt.Fprintf(os.Stderr, "-sample must be at least 1\n")
+		os.Exit(1)
+	}
+	// sampled returns the display filter for one sink; each sink samples
+	// independently so its count only includes lines it would have shown.
+	sampled := func() func(LineEvent) bool {
+		if *sampleFlag == 1 {
+			return lineFilter
+		}
+		return sampleFilter(lineFilter, *sampleFlag)
+	}
+
+	var syms *symbolizer
+	if *elfFlag != "" {
+		if syms, err = loadSymbolizer(*elfFlag); err != nil {
+			fmt.Fprintf(os.Stderr, "-elf: %v\n", err)
+			os.Exit(1)
+		}
+	}
+
+	eol, err := parseLineEnding(*eolFlag)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "-eol: %v\n", err)
+		os.Exit(1)
+	}
+	var sends []string
+	for _, s := range sendFlag {
+		cmd, err := parseCommand(s)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "-send: %v\n", err)
+			os.Exit(1)
+		}
+		sends = append(sends, cmd)
+	}
+
+	if *hexdumpOnErrorFlag && (*minPrintableFlag <= 0 || *vtFlag || *stdoutFormatFlag != "text") {
+		fmt.Fprintf(os.Stderr, "-hexdump-on-error needs text stdout and -min-printable-ratio above 0, and cannot be combined with -vt\n")
+		os.Exit(1)
+	}
+
+	if *hexFlag && (*vtFlag || *stdoutFormatFlag != "text") {
+		fmt.Fprintf(os.Stderr, "-hex needs text stdout and cannot be combined with -vt\n")
+		os.Exit(1)
+	}
+
+	if *tuiFlag {
+		_, inTTY := terminalWidth(os.Stdin)
+		_, outTTY := terminalWidth(os.Stdout)
+		if !inTTY || !outTTY {
+			fmt.Fprintf(os.Stderr, "-tui needs a terminal on stdin and stdout\n")
+			os.Exit(1)
+		}
+		if *stdoutFormatFlag != "text" || *vtFlag || *interactiveFlag || *hexFlag || *hexdumpOnErrorFlag || *stampWrapFlag || *statusBlockFlag != "" {
+			fmt.Fprintf(os.Stderr, "-tui needs text stdout and cannot be combined with -vt, -interactive, -hex, -hexdump-on-error, -stamp-wrap or -status-block\n")
+			os.Exit(1)
+		}
+	}
+
+	var boot *bootWatch
+	if *bootIdleFlag > 0 {
+		banner, err := regexp.Compile(*bootBannerFlag)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Invalid -boot-banner: %v\n", err)
+			os.Exit(1)
+		}
+		boot = &bootWatch{banner: banner, window: *bootIdleFlag}
+		if *bootReadyFlag != "" {
+			if boot.ready, err = regexp.Compile(*bootReadyFlag); err != nil {
+				fmt.Fprintf(os.Stderr, "Invalid -boot-ready: %v\n", err)
+				os.Exit(1)
+			}
+		}
+	}
+
+	if *probeAllFlag {
+		banner, err := regexp.Compile(*bootBannerFlag)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Invalid -boot-banner: %v\n", err)
+			os.Exit(1)
+		}
+		ports, err := serial.GetPortsList()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to list serial ports: %v\n", err)
+			os.Exit(1)
+		}
+		candidates := candidatePorts(ports, runtime.GOOS)
+		if len(candidates) == 0 {
+			fmt.Fprintf(os.Stderr, "No candidate ports (available: %v)\n", ports)
+			os.Exit(1)
+		}
+		for _, name := range candidates {
+			fmt.Println(probePort(name, *speedFlag, *bannerTimeoutFlag, banner))
+		}
+		return
 	}
 
 	mode := &serial.Mode{
@@ -80,42 +602,755 @@ func main() {
 		StopBits: serial.OneStopBit,
 	}
 
-	port, err := serial.Open(portName, mode)
+	if len(portFlag) > 1 || *allFlag {
+		names := []string(portFlag)
+		if *allFlag {
+			ports, err := serial.GetPortsList()
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Failed to list serial ports: %v\n", err)
+				os.Exit(1)
+			}
+			if names = candidatePorts(ports, runtime.GOOS); len(names) == 0 {
+				fmt.Fprintf(os.Stderr, "No candidate ports (available: %v)\n", ports)
+				os.Exit(1)
+			}
+		}
+		var set []string
+		flag.Visit(func(f *flag.Flag) { set = append(set, f.Name) })
+		if bad := unsupportedMultiPortFlags(set); len(bad) > 0 {
+			fmt.Fprintf(os.Stderr, "Not supported when monitoring several ports: %s\n", strings.Join(bad, " "))
+			os.Exit(1)
+		}
+
+		stdout := bufio.NewWriter(os.Stdout)
+		m := &multiPort{
+			open: func(name string) (io.ReadCloser, error) {
+				return openPort(name, mode)
+			},
+			flush:  stdout.Flush,
+			prefix: prefixChain(prefixers),
+			idle:   *partialLineFlag,
+		}
+		if *stdoutFormatFlag == "text" {
+			term := &terminalSink{out: stdout, file: os.Stdout, filter: sampled()}
+			_, term.tty = terminalWidth(os.Stdout)
+			if term.color, err = useColor(*colorFlag, term.tty, os.Getenv("NO_COLOR") != ""); err != nil {
+				fmt.Fprintf(os.Stderr, "-color: %v\n", err)
+				os.Exit(1)
+			}
+			m.out = labelSink{next: term, color: term.color}
+		} else {
+			m.out = &writerSink{w: stdout, format: stdoutFormat, filter: sampled()}
+		}
+		if *logFlag != "" || *logDirFlag != "" {
+			m.newLog = func(name string) (Sink, io.Closer, error) {
+				path := portLogPath(*logFlag, name)
+				if *logDirFlag != "" {
+					var err error
+					if path, err = sessionLog(name); err != nil {
+						return nil, nil, err
+					}
+				}
+				f, err := openRotatingFile(path, rotation)
+				if err != nil {
+					return nil, nil, err
+				}
+				fmt.Fprintf(os.Stderr, "Logging %s to %s\n", name, path)
+				return &writerSink{w: f, format: logFormat, filter: sampled()}, f, nil
+			}
+		}
+		if *syslogFlag != "" {
+			sink, err := newSyslogSink(*syslogFlag)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "-syslog: %v\n", err)
+				os.Exit(1)
+			}
+			defer sink.Close()
+			m.extra = append(m.extra, sink)
+			fmt.Fprintf(os.Stderr, "Forwarding to syslog at %s\n", *syslogFlag)
+		}
+		fmt.Fprintf(os.Stderr, "Monitoring %s at %d baud. Press Ctrl+C to exit.\n", strings.Join(names, ", "), *speedFlag)
+		os.Exit(m.run(names, interruptChan()))
+	}
+
+	var portName string
+	if len(portFlag) == 1 {
+		portName = portFlag[0]
+	}
+	if portName == "" && *waitFlag {
+		fmt.Fprintf(os.Stderr, "Waiting for a device to appear...\n")
+		detected, err := waitForPort(func() ([]string, error) {
+			ports, err := serial.GetPortsList()
+			if err != nil {
+				return nil, err
+			}
+			candidates := candidatePorts(ports, runtime.GOOS)
+			if *skipBusyFlag {
+				candidates = skipBusyPorts(candidates, isPortBusy)
+			}
+			return candidates, nil
+		}, waitPoll)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Auto-detect failed: %v\n", err)
+			os.Exit(1)
+		}
+		portName = detected
+		fmt.Fprintf(os.Stderr, "Device appeared: %s\n", portName)
+	}
+	if portName == "" {
+		detected, err := autoDetectPort(*skipBusyFlag, *looseFlag)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Auto-detect failed: %v\n", err)
+			os.Exit(1)
+		}
+		portName = detected
+		fmt.Fprintf(os.Stderr, "Auto-detected port: %s\n", portName)
+	}
+
+	if ok, hint := checkDialoutPermission(portName); !ok {
+		fmt.Fprintf(os.Stderr, "%s\n", hint)
+		if *requireGroupFlag {
+			os.Exit(1)
+		}
+	}
+
+	port, err := openPort(portName, mode)
+	if err != nil && *waitFlag && !isNetworkPort(portName) {
+		fmt.Fprintf(os.Stderr, "Waiting for %s...\n", portName)
+		port, err = waitOpen(func() (serial.Port, error) { return openPort(portName, mode) }, waitPoll), nil
+	}
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "Failed to open %s: %v\n", portName, err)
 		os.Exit(1)
 	}
-	defer port.Close()
+	var conn io.ReadWriteCloser = port
+	if *faultFlag != "" {
+		conn = newFaultConn(port, fault)
+		fmt.Fprintf(os.Stderr, "Fault injection enabled: %s\n", *faultFlag)
+	}
+	if *reconnectFlag {
+		// reopen tries the same name first; an auto-detected board may come
+		// back under a different one after re-enumeration.
+		reopen := func() (io.ReadWriteCloser, string, error) {
+			name := portName
+			p, err := openPort(name, mode)
+			if err != nil && len(portFlag) == 0 {
+				if name, err = autoDetectPort(*skipBusyFlag, false); err == nil {
+					p, err = openPort(name, mode)
+				}
+			}
+			if err != nil {
+				return nil, "", err
+			}
+			if *faultFlag != "" {
+				return newFaultConn(p, fault), name, nil
+			}
+			return p, name, nil
+		}
+		rc := newReconnectingConn(conn, reopen)
+		rc.lost = func(err error) {
+			fmt.Fprintf(os.Stderr, "\n*** Lost %s (%v); waiting for it to come back... ***\n", portName, err)
+		}
+		rc.back = func(name string) {
+			fmt.Fprintf(os.Stderr, "*** Reconnected to %s ***\n", name)
+		}
+		conn = rc
+	}
+	var transcript *transcriptSink
+	if *transcriptFlag != "" {
+		f, err := os.OpenFile(*transcriptFlag, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to open transcript file: %v\n", err)
+			os.Exit(1)
+		}
+		defer f.Close()
+		transcript = &transcriptSink{w: f}
+		conn = &txTap{ReadWriteCloser: conn, sink: transcript, port: portName}
+	}
+	var capture *captureWriter
+	if *captureFlag != "" {
+		f, err := os.Create(*captureFlag)
+		if err == nil {
+			defer f.Close()
+			capture, err = newCaptureWriter(f, time.Now())
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to open capture file: %v\n", err)
+			os.Exit(1)
+		}
+		conn = &captureTap{ReadWriteCloser: conn, c: capture}
+		fmt.Fprintf(os.Stderr, "Capturing to %s\n", *captureFlag)
+	}
+	defer conn.Close()
+
+	checkBaudRate(os.Stderr, port, *speedFlag, *verboseFlag)
+
+	resetSeq, bootSeq, jtag := resetSequences(portName)
+	// controlLines plays a DTR/RTS sequence on the current port and describes
+	// the outcome.
+	controlLines := func(what string, seq []lineStep) string {
+		m := modemLinesOf(conn)
+		if m == nil {
+			return what + " failed: the port is not connected"
+		}
+		if err := runLineSequence(m, seq); err != nil {
+			return fmt.Sprintf("%s failed: %v", what, err)
+		}
+		return what + " done"
+	}
+	// requestScreenshot asks the firmware to send its framebuffer; the read
+	// loop saves it when it arrives.
+	requestScreenshot := func() string {
+		if _, err := io.WriteString(conn, screenshotRequest); err != nil {
+			return fmt.Sprintf("Screenshot request failed: %v", err)
+		}
+		return "Screenshot requested"
+	}
+	if *resetFlag && *bootloaderFlag {
+		fmt.Fprintf(os.Stderr, "-reset and -bootloader cannot be combined\n")
+		os.Exit(1)
+	}
+	if (*resetFlag || *bootloaderFlag) && jtag && !*reconnectFlag {
+		fmt.Fprintf(os.Stderr, "Note: %s re-enumerates when the chip resets; add -reconnect to keep monitoring it\n", portName)
+	}
+	if *resetFlag {
+		fmt.Fprintf(os.Stderr, "%s\n", controlLines("Reset", resetSeq))
+	}
+	if *bootloaderFlag {
+		fmt.Fprintf(os.Stderr, "%s\n", controlLines("Bootloader entry", bootSeq))
+	}
+
+	if *locateFlag {
+		cmd, err := parseCommand(*locateCmdFlag)
+		if err == nil {
+			err = sendCommand(conn, cmd)
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Locate failed: %v\n", err)
+			os.Exit(1)
+		}
+		fmt.Fprintf(os.Stderr, "Sent identify command to %s\n", portName)
+		return
+	}
 
 	fmt.Fprintf(os.Stderr, "Monitoring %s at %d baud. Press Ctrl+C to exit.\n", portName, *speedFlag)
 
-	var out io.Writer = os.Stdout
+	flushMode, err := parseFlushMode(*flushModeFlag)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "-flush-mode: %v\n", err)
+		os.Exit(1)
+	}
+	stdout := bufio.NewWriter(os.Stdout)
+
+	displayFilter := sampled()
+	var hexSw *hexSwitch
+	if *hexdumpOnErrorFlag {
+		hexSw = newHexSwitch(*minPrintableFlag, stdout, stdout.Flush)
+		displayFilter = hexSw.textFilter(displayFilter)
+	}
+
+	var sinks []Sink
+	// telemetry gets lines as received, before -pipe rewrites or hides
+	// them, like the session stats and boot watch.
+	var telemetry []Sink
+	stdoutTTY := false
+	var tui *tuiSink
+	if *tuiFlag {
+		tui = &tuiSink{view: newTUIView(portName, *speedFlag, time.Now()), filter: displayFilter}
+		tui.actions = map[string]func() string{
+			"R": func() string { return controlLines("Reset", resetSeq) },
+			"B": func() string { return controlLines("Bootloader entry", bootSeq) },
+			"S": requestScreenshot,
+		}
+		sinks = append(sinks, tui)
+	} else if *stdoutFormatFlag == "text" {
+		term := &terminalSink{out: stdout, file: os.Stdout, block: block, filter: displayFilter, hex: *hexFlag}
+		var width int
+		width, term.tty = terminalWidth(os.Stdout)
+		if term.color, err = useColor(*colorFlag, term.tty, os.Getenv("NO_COLOR") != ""); err != nil {
+			fmt.Fprintf(os.Stderr, "-color: %v\n", err)
+			os.Exit(1)
+		}
+		if *stampWrapFlag && term.tty {
+			term.wrapWidth = new(atomic.Int32)
+			term.wrapWidth.Store(int32(width))
+			watchTerminalWidth(os.Stdout, term.wrapWidth)
+		}
+		sinks = append(sinks, term)
+		stdoutTTY = term.tty
+	} else {
+		sinks = append(sinks, &writerSink{w: stdout, format: stdoutFormat, filter: displayFilter})
+	}
+
+	if *logDirFlag != "" {
+		if *logFlag, err = sessionLog(portName); err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to create log file: %v\n", err)
+			os.Exit(1)
+		}
+	}
 	if *logFlag != "" {
-		f, err := os.OpenFile(*logFlag, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+		ansiMode, err := resolveLogANSI(*logANSIFlag, *logFlag)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "-log-ansi: %v\n", err)
+			os.Exit(1)
+		}
+		if ansiMode == "html" && *logFormatFlag != "text" {
+			fmt.Fprintf(os.Stderr, "-log-ansi html needs -log-format text\n")
+			os.Exit(1)
+		}
+		f, err := openRotatingFile(*logFlag, rotation)
 		if err != nil {
 			fmt.Fprintf(os.Stderr, "Failed to open log file: %v\n", err)
 			os.Exit(1)
 		}
 		defer f.Close()
-		out = io.MultiWriter(os.Stdout, f)
+		format := logFormat
+		switch ansiMode {
+		case "strip":
+			format = stripped(logFormat)
+		case "html":
+			f.header = htmlTranscriptHead
+			format = htmlFormatter()
+		}
+		sinks = append(sinks, &writerSink{w: f, format: format, filter: sampled()})
 		fmt.Fprintf(os.Stderr, "Logging to %s\n", *logFlag)
 	}
 
+	if *splitByTagFlag != "" {
+		split, err := newTagSplitSink(*splitByTagFlag, logFormat)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to create -split-by-tag directory: %v\n", err)
+			os.Exit(1)
+		}
+		defer split.Close()
+		split.filter = sampled()
+		sinks = append(sinks, split)
+		fmt.Fprintf(os.Stderr, "Splitting lines by tag into %s\n", *splitByTagFlag)
+	}
+
+	if transcript != nil {
+		sinks = append(sinks, transcript)
+		fmt.Fprintf(os.Stderr, "Writing transcript to %s\n", *transcriptFlag)
+	}
+
+	if *metricsFlag != "" {
+		f, err := os.Create(*metricsFlag)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to open metrics file: %v\n", err)
+			os.Exit(1)
+		}
+		defer f.Close()
+		telemetry = append(telemetry, newMetricsSink(f))
+		fmt.Fprintf(os.Stderr, "Writing metrics to %s\n", *metricsFlag)
+	}
+
+	var raw io.Writer
+	if *rawFlag != "" {
+		f, err := os.Create(*rawFlag)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to open raw capture file: %v\n", err)
+			os.Exit(1)
+		}
+		defer f.Close()
+		raw = f
+		fmt.Fprintf(os.Stderr, "Capturing raw bytes to %s\n", *rawFlag)
+	}
+
+	var combined *combinedWriter
+	if *combinedFlag != "" {
+		f, err := os.Create(*combinedFlag)
+		if err == nil {
+			defer f.Close()
+			combined, err = newCombinedWriter(f)
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to open combined file: %v\n", err)
+			os.Exit(1)
+		}
+		combined.annotate(fmt.Sprintf("Monitoring %s at %d baud\n", portName, *speedFlag))
+		sinks = append(sinks, combinedSink{combined})
+		fmt.Fprintf(os.Stderr, "Writing combined capture to %s\n", *combinedFlag)
+	}
+
+	if *httpTailFlag != "" {
+		if *httpTailLinesFlag <= 0 {
+			fmt.Fprintf(os.Stderr, "-http-tail-lines must be positive\n")
+			os.Exit(1)
+		}
+		ring := newLineRing(*httpTailLinesFlag)
+		sinks = append(sinks, ring)
+		ln, err := net.Listen("tcp", *httpTailFlag)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to listen on %s: %v\n", *httpTailFlag, err)
+			os.Exit(1)
+		}
+		go http.Serve(ln, tailHandler(ring))
+		fmt.Fprintf(os.Stderr, "Serving the last %d lines at http://%s/\n", *httpTailLinesFlag, ln.Addr())
+	}
+
+	if *syslogFlag != "" {
+		sink, err := newSyslogSink(*syslogFlag)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "-syslog: %v\n", err)
+			os.Exit(1)
+		}
+		defer sink.Close()
+		sinks = append(sinks, sink)
+		fmt.Fprintf(os.Stderr, "Forwarding to syslog at %s\n", *syslogFlag)
+	}
+
+	var gdbListener net.Listener
+	if *gdbFlag != "" {
+		if gdbListener, err = net.Listen("tcp", *gdbFlag); err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to listen on %s: %v\n", *gdbFlag, err)
+			os.Exit(1)
+		}
+		defer gdbListener.Close()
+		fmt.Fprintf(os.Stderr, "GDB bridge listening on %s\n", gdbListener.Addr())
+	}
+
+	if *prometheusFlag != "" {
+		exporter := newPromExporter(portName)
+		telemetry = append(telemetry, exporter)
+		ln, err := net.Listen("tcp", *prometheusFlag)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to listen on %s: %v\n", *prometheusFlag, err)
+			os.Exit(1)
+		}
+		mux := http.NewServeMux()
+		mux.Handle("/metrics", exporter)
+		go http.Serve(ln, mux)
+		fmt.Fprintf(os.Stderr, "Serving metrics at http://%s/metrics\n", ln.Addr())
+	}
+
+	if *serveFlag != "" {
+		format, err := lookupFormatter(*serveFormatFlag)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "-serve-format: %v\n", err)
+			os.Exit(1)
+		}
+		hub := newStreamHub(format)
+		sinks = append(sinks, hub)
+		ln, err := net.Listen("tcp", *serveFlag)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to listen on %s: %v\n", *serveFlag, err)
+			os.Exit(1)
+		}
+		go hub.serve(ln)
+		fmt.Fprintf(os.Stderr, "Streaming to WebSocket and TCP clients on %s\n", ln.Addr())
+	}
+
+	var utf8Seen utf8Stats
+	session := newSessionStats(time.Now())
+	session.resets.limit, session.resets.window = *bootLoopFlag, *bootLoopWindowFlag
+	_, stderrTTY := terminalWidth(os.Stderr)
+
+	// shutdown ends the session from another goroutine. Closing the connection
+	// unblocks the read loop, which then flushes output, prints the summary and
+	// exits with code; a read that never unblocks is cut short after a grace
+	// period.
+	var exiting atomic.Bool
+	var exitCode atomic.Int32
+	shutdown := func(code int) {
+		if !exiting.CompareAndSwap(false, true) {
+			return
+		}
+		exitCode.Store(int32(code))
+		conn.Close()
+		time.AfterFunc(2*time.Second, func() { os.Exit(code) })
+	}
+
+	// restoreTerminal leaves the -tui screen; it is a no-op otherwise.
+	restoreTerminal := func() {}
+
+	// finish runs once the read loop has ended.
+	finish := func(readErr error) {
+		restoreTerminal()
+		if hexSw != nil {
+			hexSw.close()
+		}
+		stdout.Flush()
+		if readErr != nil && !exiting.Load() {
+			fmt.Fprintf(os.Stderr, "Read error: %v\n", readErr)
+		}
+		if match != nil && !exiting.Load() {
+			code, msg := matchResult(matchPortClosed, *matchFlag, *timeoutFlag)
+			fmt.Fprintf(os.Stderr, "%s\n", msg)
+			exitCode.Store(int32(code))
+		}
+		session.report(os.Stderr, time.Now())
+		utf8Seen.report(os.Stderr, *utf8OffsetsFlag)
+		conn.Close()
+		os.Exit(int(exitCode.Load()))
+	}
+
 	// Handle Ctrl+C
 	sig := make(chan os.Signal, 1)
 	signal.Notify(sig, os.Interrupt)
 	go func() {
 		<-sig
 		fmt.Fprintf(os.Stderr, "\nExiting.\n")
-		port.Close()
-		os.Exit(0)
+		shutdown(0)
 	}()
 
-	scanner := bufio.NewScanner(port)
-	for scanner.Scan() {
-		fmt.Fprintln(out, scanner.Text())
+	if *timeoutFlag > 0 {
+		time.AfterFunc(*timeoutFlag, func() {
+			code, msg := matchResult(matchTimedOut, *matchFlag, *timeoutFlag)
+			fmt.Fprintf(os.Stderr, "\n%s\n", msg)
+			shutdown(code)
+		})
+	}
+
+	var stall *stallWatch
+	if *stallFlag > 0 {
+		stall = newStallWatch(*stallFlag, time.Now())
+		go func() {
+			for now := range time.Tick(100 * time.Millisecond) {
+				msg, stalled := stall.check(now)
+				if msg == "" {
+					continue
+				}
+				if !stalled {
+					fmt.Fprintf(os.Stderr, "%s\n", msg)
+					continue
+				}
+				shown := msg
+				if stderrTTY {
+					shown = colorYellow.wrap(shown)
+				}
+				if *stallBellFlag {
+					shown += "\a"
+				}
+				fmt.Fprintf(os.Stderr, "\n%s\n", shown)
+				if *stallRunFlag != "" {
+					runTrigger(*stallRunFlag, LineEvent{Time: now, Port: portName, Text: msg}, os.Stderr)
+				}
+			}
+		}()
+	}
+
+	if boot != nil {
+		go func() {
+			for now := range time.Tick(100 * time.Millisecond) {
+				if boot.hung(now) {
+					fmt.Fprintf(os.Stderr, "\nPossible hang: no output for %s after the boot banner.\n", boot.window)
+					shutdown(2)
+					return
+				}
+			}
+		}()
+	}
+
+	src := rawTap(conn, raw)
+	if capture != nil {
+		src = io.TeeReader(src, capture)
+	}
+	if stall != nil {
+		src = io.TeeReader(src, stall)
+	}
+	var bridge *gdbBridge
+	if gdbListener != nil {
+		// Before anything that inspects text, so protocol packets never
+		// reach the guards, the hex view or the lines.
+		bridge = newGDBBridge(src, conn, func(msg string) { fmt.Fprintf(os.Stderr, "%s\n", msg) })
+		bridge.attach = strings.TrimSpace(fmt.Sprintf("gdb -ex 'target remote %s' %s", gdbListener.Addr(), *elfFlag))
+		go bridge.serve(gdbListener)
+		src = bridge
+	}
+	src = guardReader(src, *minPrintableFlag, *printableWindowFlag, *hexFlag || raw != nil, baudWarning(os.Stderr, *speedFlag, *printableWindowFlag))
+	if hexSw != nil {
+		src = io.TeeReader(src, hexSw)
+	}
+	if combined != nil {
+		src = io.TeeReader(src, combined)
+	}
+
+	if *readyFdFlag > 0 {
+		if err := signalReadyFd(*readyFdFlag, portName); err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to signal readiness: %v\n", err)
+		}
+	}
+
+	for _, cmd := range sends {
+		if err := sendLine(conn, cmd, eol); err != nil {
+			fmt.Fprintf(os.Stderr, "Send failed: %v\n", err)
+			os.Exit(1)
+		}
 	}
-	if err := scanner.Err(); err != nil {
-		fmt.Fprintf(os.Stderr, "Read error: %v\n", err)
+	if *screenshotFlag {
+		fmt.Fprintf(os.Stderr, "%s\n", requestScreenshot())
+	}
+	if tui != nil {
+		restore, err := makeRaw(os.Stdin)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "-tui: %v\n", err)
+			os.Exit(1)
+		}
+		fmt.Fprint(os.Stdout, "\x1b[?1049h\x1b[?25l")
+		restoreTerminal = func() {
+			fmt.Fprint(os.Stdout, "\x1b[?25h\x1b[?1049l")
+			restore()
+		}
+		size := func() (int, int) {
+			rows, cols, ok := terminalSize(os.Stdout)
+			if !ok {
+				return 24, 80
+			}
+			return rows, cols
+		}
+		tui.run(os.Stdin, os.Stdout, size, func() { shutdown(0) })
+	}
+	if *interactiveFlag {
+		go func() {
+			escape := func(line string) bool {
+				switch line {
+				case "~r":
+					fmt.Fprintf(os.Stderr, "%s\n", controlLines("Reset", resetSeq))
+				case "~b":
+					fmt.Fprintf(os.Stderr, "%s\n", controlLines("Bootloader entry", bootSeq))
+				case "~s":
+					fmt.Fprintf(os.Stderr, "%s\n", requestScreenshot())
+				default:
+					return false
+				}
+				return true
+			}
+			if err := forwardInput(os.Stdin, conn, eol, escape); err != nil && !exiting.Load() {
+				fmt.Fprintf(os.Stderr, "Input error: %v\n", err)
+			}
+		}()
+	}
+
+	if screen != nil {
+		// emitScreen sends a changed screen through the sinks as a header line
+		// followed by one event per row, clearing the terminal first.
+		emitScreen := func() {
+			lines, changed := screen.snapshot()
+			if !changed {
+				return
+			}
+			if stdoutTTY {
+				fmt.Fprint(stdout, "\x1b[H\x1b[2J")
+			}
+			now := time.Now()
+			sinks = fanOut(sinks, LineEvent{Time: now, Port: portName, Text: "--- screen " + now.Format("15:04:05.000") + " ---"})
+			for _, l := range lines {
+				ev := LineEvent{Time: now, Port: portName, Text: l}
+				telemetry = fanOut(telemetry, ev)
+				sinks = fanOut(sinks, ev)
+			}
+		}
+		copyErr := make(chan error, 1)
+		go func() {
+			_, err := io.Copy(screen, src)
+			copyErr <- err
+		}()
+		ticker := time.NewTicker(*vtIntervalFlag)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				emitScreen()
+				stdout.Flush()
+			case err := <-copyErr:
+				emitScreen()
+				finish(err)
+			}
+		}
+	}
+
+	prefix := prefixChain(prefixers)
+
+	var pipe *linePipe
+	if *pipeFlag != "" {
+		if pipe, err = startLinePipe(*pipeFlag, portName, os.Stderr); err != nil {
+			fmt.Fprintf(os.Stderr, "-pipe: %v\n", err)
+			os.Exit(1)
+		}
+	}
+	shots := &screenshotCollector{dir: *screenshotDirFlag}
+	cores := &coreDumpCollector{dir: *coreDumpDirFlag}
+	if *elfFlag != "" {
+		cores.onSave = func(path string) {
+			stdout.Flush()
+			if err := coreDumpInfo(path, *elfFlag, os.Stderr); err != nil {
+				fmt.Fprintf(os.Stderr, "Core dump not decoded: %v\n", err)
+			}
+		}
+	}
+	// watch ends the session when a line calls for it.
+	watch := func(ev LineEvent) {
+		if exiting.Load() {
+			return
+		}
+		if code := watchLine(ev, match, triggers, os.Stderr); code >= 0 {
+			shutdown(code)
+		}
+	}
+	// onReceived takes each line as it arrived, shown or hidden by -pipe.
+	onReceived := func(ev LineEvent) {
+		telemetry = fanOut(telemetry, ev)
+		watch(ev)
+	}
+	lines := newLineReader(src, *partialLineFlag)
+	afterLine := applyFlushMode(flushMode, lines, stdout.Flush)
+	for lines.Scan() {
+		ev := LineEvent{Time: time.Now(), Port: portName, Offset: lines.lineStart, Text: lines.Text()}
+		if bridge != nil {
+			// Offsets are in the raw stream, packets and all.
+			ev.Offset = bridge.rawOffset(ev.Offset)
+		}
+		utf8Seen.scan(ev.Text, ev.Offset)
+		if warning := session.observe(ev.Text, ev.Time); warning != "" {
+			stdout.Flush()
+			if stderrTTY {
+				warning = colorRed.wrap(warning)
+			}
+			fmt.Fprintf(os.Stderr, "\n%s\n", warning)
+		}
+		if boot != nil {
+			boot.observe(ev.Text, ev.Time)
+		}
+		// Screenshot records and core dumps never reach the sinks; they are
+		// replaced by notices saying where they went.
+		consumed, notice := shots.observe(ev.Text)
+		if !consumed {
+			consumed, notice = cores.observe(ev.Text)
+		}
+		if consumed {
+			if notice == "" {
+				afterLine()
+				continue
+			}
+			ev.Text = notice
+		}
+		received := ev
+		if pipe != nil {
+			text, show, err := pipe.apply(ev.Text)
+			switch {
+			case err != nil:
+				fmt.Fprintf(os.Stderr, "-pipe: %v; showing lines unchanged\n", err)
+				pipe.close()
+				pipe = nil
+			case !show:
+				onReceived(received)
+				afterLine()
+				continue
+			default:
+				ev.Text = text
+			}
+		}
+		ev.Prefix = prefix(ev)
+		sinks = fanOut(sinks, ev)
+		if syms != nil {
+			for _, pc := range crashAddresses(ev.Text) {
+				decoded := ev
+				decoded.Text = "  " + syms.decode(pc)
+				sinks = fanOut(sinks, decoded)
+			}
+		}
+		onReceived(received)
+		afterLine()
 	}
+	finish(lines.Err())
 }
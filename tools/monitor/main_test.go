@@ -1,7 +1,13 @@
 package main
 
 import (
+	"bytes"
+	"io"
+	"strings"
 	"testing"
+	"time"
+
+	"go.bug.st/serial"
 )
 
 func TestFilterPorts_Linux(t *testing.T) {
@@ -33,14 +39,22 @@ func TestFilterPorts_NoMatch(t *testing.T) {
 	}
 }
 
-func TestFilterPorts_UnknownOS(t *testing.T) {
-	ports := []string{"/dev/ttyACM0", "COM3"}
-	got := filterPorts(ports, "freebsd")
-	if got != nil {
-		t.Errorf("expected nil for unknown OS, got %v", got)
+func TestFilterPorts_BSD(t *testing.T) {
+	ports := []string{"/dev/cuaU0", "/dev/ttyU0", "/dev/ttyU1", "/dev/cuau0", "/dev/ttyu0"}
+	for _, goos := range []string{"freebsd", "openbsd"} {
+		got := filterPorts(ports, goos)
+		// ttyU1 has no callout twin, so it is the only way to reach that device
+		assertSliceEqual(t, got, []string{"/dev/cuaU0", "/dev/ttyU1"})
 	}
 }
 
+func TestFilterPorts_UnknownOS(t *testing.T) {
+	ports := []string{"/dev/ttyACM0", "/dev/term/a"}
+	got := filterPorts(ports, "illumos")
+	// Without known patterns every port is offered rather than none
+	assertSliceEqual(t, got, ports)
+}
+
 func TestFilterPorts_Empty(t *testing.T) {
 	got := filterPorts(nil, "linux")
 	if got != nil {
@@ -72,6 +86,86 @@ func TestSelectPort_Multiple(t *testing.T) {
 	}
 }
 
+func TestSkipBusyPorts(t *testing.T) {
+	busy := map[string]bool{"/dev/ttyACM0": true}
+	got := skipBusyPorts([]string{"/dev/ttyACM0", "/dev/ttyACM1"}, func(name string) bool {
+		return busy[name]
+	})
+	assertSliceEqual(t, got, []string{"/dev/ttyACM1"})
+}
+
+func TestSkipBusyPorts_AllBusy(t *testing.T) {
+	got := skipBusyPorts([]string{"/dev/ttyACM0"}, func(string) bool { return true })
+	if got != nil {
+		t.Errorf("expected nil when every port is busy, got %v", got)
+	}
+}
+
+func TestBaudMismatch(t *testing.T) {
+	tests := []struct {
+		requested, actual int
+		want              bool
+	}{
+		{115200, 115200, false},
+		{921600, 920000, false},
+		{921600, 900000, true},
+		{115200, 125000, true},
+		{0, 9600, false},
+	}
+	for _, tt := range tests {
+		if got := baudMismatch(tt.requested, tt.actual); got != tt.want {
+			t.Errorf("baudMismatch(%d, %d) = %v, want %v", tt.requested, tt.actual, got, tt.want)
+		}
+	}
+}
+
+// ratePort is a port whose driver reports running at rate.
+type ratePort struct {
+	serial.Port
+	rate int
+}
+
+func (p ratePort) ActualBaudRate() (int, error) { return p.rate, nil }
+
+func TestCheckBaudRate(t *testing.T) {
+	tests := []struct {
+		rate int
+		warn bool
+	}{
+		{115200, false},
+		{117000, false}, // 1.6% off
+		{112896, false}, // 2% off exactly
+		{117600, true},  // 2.1% off
+		{112800, true},
+		{0, false}, // not reported
+	}
+	for _, tt := range tests {
+		var out bytes.Buffer
+		checkBaudRate(&out, ratePort{rate: tt.rate}, 115200, false)
+		if got := strings.Contains(out.String(), "Warning: requested 115200 baud"); got != tt.warn || !tt.warn && out.Len() != 0 {
+			t.Errorf("driver at %d: got %q, want warning %v", tt.rate, out.String(), tt.warn)
+		}
+	}
+}
+
+func TestMatchResult(t *testing.T) {
+	tests := []struct {
+		end      matchEnd
+		wantCode int
+		wantMsg  string
+	}{
+		{matchFound, 0, `Matched -match "SUMI ready".`},
+		{matchTimedOut, 124, `No line matched -match "SUMI ready" within 30s.`},
+		{matchPortClosed, 1, `Port closed before a line matched -match "SUMI ready".`},
+	}
+	for _, tt := range tests {
+		code, msg := matchResult(tt.end, "SUMI ready", 30*time.Second)
+		if code != tt.wantCode || msg != tt.wantMsg {
+			t.Errorf("matchResult(%d) = %d, %q; want %d, %q", tt.end, code, msg, tt.wantCode, tt.wantMsg)
+		}
+	}
+}
+
 func assertSliceEqual(t *testing.T, got, want []string) {
 	t.Helper()
 	if len(got) != len(want) {
@@ -83,3 +177,45 @@ func assertSliceEqual(t *testing.T, got, want []string) {
 		}
 	}
 }
+
+func TestLooseSelect_SolePort(t *testing.T) {
+	port, err := looseSelect([]string{"/dev/ttyUSB0"}, nil)
+	if err != nil || port != "/dev/ttyUSB0" {
+		t.Errorf("got %q, %v", port, err)
+	}
+}
+
+func TestLooseSelect_NoPorts(t *testing.T) {
+	if _, err := looseSelect(nil, nil); err == nil {
+		t.Error("expected error for no ports")
+	}
+}
+
+func TestLooseSelect_SeveralWithoutPrompt(t *testing.T) {
+	_, err := looseSelect([]string{"/dev/ttyUSB0", "/dev/ttyS0"}, nil)
+	if err == nil || !strings.Contains(err.Error(), "-port") {
+		t.Errorf("expected a -port hint, got %v", err)
+	}
+}
+
+func TestLooseSelect_Prompt(t *testing.T) {
+	var out strings.Builder
+	ports := []string{"/dev/ttyUSB0", "/dev/ttyS0"}
+	port, err := looseSelect(ports, func(p []string) (string, error) {
+		return promptPort(strings.NewReader("2\n"), &out, p)
+	})
+	if err != nil || port != "/dev/ttyS0" {
+		t.Errorf("got %q, %v", port, err)
+	}
+	if !strings.Contains(out.String(), "  1) /dev/ttyUSB0\n  2) /dev/ttyS0\n") {
+		t.Errorf("prompt listing = %q", out.String())
+	}
+}
+
+func TestPromptPort_InvalidChoice(t *testing.T) {
+	for _, in := range []string{"0\n", "3\n", "usb\n", ""} {
+		if _, err := promptPort(strings.NewReader(in), io.Discard, []string{"a", "b"}); err == nil {
+			t.Errorf("input %q: expected error", in)
+		}
+	}
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"encoding/csv"
+	"io"
+	"regexp"
+	"time"
+)
+
+// metricColumns are the value columns of a -metrics file, after time and port.
+var metricColumns = []string{"heap_free", "heap_min_free", "heap_largest", "render_ms", "refresh_wait_ms", "battery_mv", "battery_pct"}
+
+// metricPatterns recognize the firmware's telemetry lines; each capture group
+// fills the column of the same position in columns.
+var metricPatterns = []struct {
+	re      *regexp.Regexp
+	columns []string
+}{
+	// main.cpp, every 10 s: "[1234] [MEM] Free: 151234 bytes, Total: 327680 bytes, Min Free: 120000 bytes"
+	{regexp.MustCompile(`\[MEM\] Free: (\d+) bytes, Total: \d+ bytes, Min Free: (\d+) bytes`), []string{"heap_free", "heap_min_free"}},
+	// Core::logMemory: "[MEM] reader open: free=151234, largest=65536"
+	{regexp.MustCompile(`\[MEM\] .*: free=(\d+), largest=(\d+)`), []string{"heap_free", "heap_largest"}},
+	{regexp.MustCompile(`\[GFX\] Render took (\d+) ms`), []string{"render_ms"}},
+	{regexp.MustCompile(`waitForRefresh: waited (\d+) ms`), []string{"refresh_wait_ms"}},
+	// main.cpp, every 10 s: "[1234] [BAT] Battery: 3987 mV, 82%"
+	{regexp.MustCompile(`\[BAT\] Battery: (\d+) mV, (\d+)%`), []string{"battery_mv", "battery_pct"}},
+}
+
+// parseMetrics returns the telemetry values in a line by column name, or nil.
+func parseMetrics(text string) map[string]string {
+	for _, p := range metricPatterns {
+		if m := p.re.FindStringSubmatch(text); m != nil {
+			values := make(map[string]string, len(p.columns))
+			for i, col := range p.columns {
+				values[col] = m[i+1]
+			}
+			return values
+		}
+	}
+	return nil
+}
+
+// metricsSink writes a CSV row for every telemetry line, for plotting heap
+// leaks, render times and battery drain. A row fills only the columns its
+// line reports.
+type metricsSink struct {
+	w      *csv.Writer
+	header bool
+}
+
+func newMetricsSink(w io.Writer) *metricsSink {
+	return &metricsSink{w: csv.NewWriter(w)}
+}
+
+func (s *metricsSink) Write(ev LineEvent) error {
+	if ev.Dir != dirRX {
+		return nil
+	}
+	values := parseMetrics(ev.Text)
+	if values == nil {
+		return nil
+	}
+	if !s.header {
+		s.w.Write(append([]string{"time", "port"}, metricColumns...))
+		s.header = true
+	}
+	row := []string{ev.Time.Format(time.RFC3339Nano), ev.Port}
+	for _, col := range metricColumns {
+		row = append(row, values[col])
+	}
+	s.w.Write(row)
+	s.w.Flush()
+	return s.w.Error()
+}
@@ -0,0 +1,35 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+	"time"
+)
+
+func TestMetricsSink(t *testing.T) {
+	var buf bytes.Buffer
+	s := newMetricsSink(&buf)
+	at := time.Date(2026, 3, 1, 9, 0, 0, 0, time.UTC)
+	for i, text := range []string{
+		"[10012] [MEM] Free: 151234 bytes, Total: 327680 bytes, Min Free: 120000 bytes",
+		"[10013] [BAT] Battery: 3987 mV, 82%",
+		"[HOME] Loaded 3 recent books",
+		"[MEM] Display initialized: free=180000, largest=90000",
+		"[10500] [GFX] Render took 412 ms",
+		"[10900]   waitForRefresh: waited 380 ms",
+	} {
+		if err := s.Write(LineEvent{Time: at.Add(time.Duration(i) * time.Second), Port: "/dev/ttyACM0", Text: text}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	s.Write(LineEvent{Time: at, Text: "[GFX] Render took 1 ms", Dir: dirTX})
+	want := "time,port,heap_free,heap_min_free,heap_largest,render_ms,refresh_wait_ms,battery_mv,battery_pct\n" +
+		"2026-03-01T09:00:00Z,/dev/ttyACM0,151234,120000,,,,,\n" +
+		"2026-03-01T09:00:01Z,/dev/ttyACM0,,,,,,3987,82\n" +
+		"2026-03-01T09:00:03Z,/dev/ttyACM0,180000,,90000,,,,\n" +
+		"2026-03-01T09:00:04Z,/dev/ttyACM0,,,,412,,,\n" +
+		"2026-03-01T09:00:05Z,/dev/ttyACM0,,,,,380,,\n"
+	if buf.String() != want {
+		t.Errorf("got\n%s\nwant\n%s", buf.String(), want)
+	}
+}
@@ -0,0 +1,175 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// multiPortFlags are the flags that apply when several ports are monitored at
+// once. The rest assume a single port.
+var multiPortFlags = map[string]bool{
+	"port": true, "all": true, "speed": true, "v": true,
+	"log": true, "log-dir": true, "log-name": true, "log-format": true, "log-max-size": true, "log-max-age": true, "log-keep": true, "stdout-format": true,
+	"timestamp": true, "show-offset": true, "include": true, "exclude": true,
+	"sample": true, "color": true, "partial-line": true,
+	"profile": true, "config": true, "syslog": true,
+}
+
+// unsupportedMultiPortFlags returns the flags in set, sorted, that do not apply
+// to several ports.
+func unsupportedMultiPortFlags(set []string) []string {
+	var bad []string
+	for _, name := range set {
+		if !multiPortFlags[name] {
+			bad = append(bad, "-"+name)
+		}
+	}
+	sort.Strings(bad)
+	return bad
+}
+
+// portLabel is the name shown before each line when monitoring several ports.
+func portLabel(name string) string {
+	return filepath.Base(name)
+}
+
+// portLogPath derives one port's log file from the -log path, so
+// monitor.log becomes monitor-ttyACM0.log.
+func portLogPath(path, port string) string {
+	ext := filepath.Ext(path)
+	return strings.TrimSuffix(path, ext) + "-" + portLabel(port) + ext
+}
+
+// labelSink adds the port label to each event's prefix before passing it on,
+// coloring it by port when color is set.
+type labelSink struct {
+	next  Sink
+	color bool
+}
+
+func (s labelSink) Write(ev LineEvent) error {
+	label := "[" + portLabel(ev.Port) + "]"
+	if s.color {
+		label = colorForPort(ev.Port).wrap(label)
+	}
+	ev.Prefix = label + " " + ev.Prefix
+	return s.next.Write(ev)
+}
+
+// readPortLines sends each line read from r to events, tagged with the port,
+// until r ends. A partial line is sent once it has waited idle for its newline.
+func readPortLines(name string, r io.Reader, idle time.Duration, events chan<- LineEvent) error {
+	lines := newLineReader(r, idle)
+	for lines.Scan() {
+		events <- LineEvent{Time: time.Now(), Port: name, Offset: lines.lineStart, Text: lines.Text()}
+	}
+	return lines.Err()
+}
+
+// multiPort monitors several ports at once, interleaving their lines on one
+// output in arrival order. Each port may have its own log sink.
+type multiPort struct {
+	open   func(name string) (io.ReadCloser, error)
+	newLog func(name string) (Sink, io.Closer, error) // nil without -log
+	out    Sink
+	extra  []Sink // fed every port's lines too, e.g. -syslog
+	flush  func() error
+	prefix func(LineEvent) string
+	idle   time.Duration // see lineReader
+}
+
+// run opens every port and monitors them until all have ended or stop is
+// closed. It returns the process exit code.
+func (m *multiPort) run(names []string, stop <-chan struct{}) int {
+	var conns []io.ReadCloser
+	logs := map[string]Sink{}
+	var closers []io.Closer
+	defer func() {
+		for _, c := range closers {
+			c.Close()
+		}
+	}()
+	for _, name := range names {
+		conn, err := m.open(name)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to open %s: %v\n", name, err)
+			for _, c := range conns {
+				c.Close()
+			}
+			return 1
+		}
+		conns = append(conns, conn)
+		if m.newLog != nil {
+			sink, closer, err := m.newLog(name)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Failed to open log file for %s: %v\n", name, err)
+				for _, c := range conns {
+					c.Close()
+				}
+				return 1
+			}
+			logs[name] = sink
+			closers = append(closers, closer)
+		}
+	}
+
+	var exiting atomic.Bool
+	go func() {
+		<-stop
+		exiting.Store(true)
+		for _, c := range conns {
+			c.Close()
+		}
+	}()
+
+	events := make(chan LineEvent, 64)
+	var wg sync.WaitGroup
+	for i, name := range names {
+		wg.Add(1)
+		go func(name string, conn io.Reader) {
+			defer wg.Done()
+			if err := readPortLines(name, conn, m.idle, events); err != nil && !exiting.Load() {
+				fmt.Fprintf(os.Stderr, "Read error on %s: %v\n", name, err)
+			}
+		}(name, conns[i])
+	}
+	go func() {
+		wg.Wait()
+		close(events)
+	}()
+
+	sinks := append([]Sink{m.out}, m.extra...)
+	for ev := range events {
+		ev.Prefix = m.prefix(ev)
+		sinks = fanOut(sinks, ev)
+		if log := logs[ev.Port]; log != nil {
+			if err := log.Write(ev); err != nil {
+				fmt.Fprintf(os.Stderr, "Write error, logging for %s disabled: %v\n", ev.Port, err)
+				delete(logs, ev.Port)
+			}
+		}
+		m.flush()
+	}
+	return 0
+}
+
+// interruptChan returns a channel closed on the first Ctrl+C.
+func interruptChan() <-chan struct{} {
+	stop := make(chan struct{})
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, os.Interrupt)
+	go func() {
+		<-sig
+		fmt.Fprintf(os.Stderr, "\nExiting.\n")
+		close(stop)
+	}()
+	return stop
+}
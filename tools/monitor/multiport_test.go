@@ -0,0 +1,107 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+)
+
+type nopCloser struct{}
+
+func (nopCloser) Close() error { return nil }
+
+func TestMultiPort_InterleavesWithLabelsAndPerPortLogs(t *testing.T) {
+	inputs := map[string]string{
+		"/dev/ttyACM0": "[BOOT] Boot count: 1\n[SM] Initial state\n",
+		"/dev/ttyACM1": "[BOOT] Boot count: 7\n",
+	}
+	var out bytes.Buffer
+	logs := map[string]*bytes.Buffer{}
+	m := &multiPort{
+		open: func(name string) (io.ReadCloser, error) {
+			return io.NopCloser(strings.NewReader(inputs[name])), nil
+		},
+		newLog: func(name string) (Sink, io.Closer, error) {
+			logs[name] = &bytes.Buffer{}
+			return &writerSink{w: logs[name], format: formatText}, nopCloser{}, nil
+		},
+		out:    labelSink{next: &terminalSink{out: &out}},
+		flush:  func() error { return nil },
+		prefix: prefixChain(nil),
+	}
+	if code := m.run([]string{"/dev/ttyACM0", "/dev/ttyACM1"}, make(chan struct{})); code != 0 {
+		t.Fatalf("exit code %d", code)
+	}
+
+	// Ports are read concurrently, so only per-port order is fixed.
+	var acm0, acm1 []string
+	for _, l := range strings.Split(strings.TrimSuffix(out.String(), "\n"), "\n") {
+		switch {
+		case strings.HasPrefix(l, "[ttyACM0] "):
+			acm0 = append(acm0, strings.TrimPrefix(l, "[ttyACM0] "))
+		case strings.HasPrefix(l, "[ttyACM1] "):
+			acm1 = append(acm1, strings.TrimPrefix(l, "[ttyACM1] "))
+		default:
+			t.Errorf("unlabeled line %q", l)
+		}
+	}
+	assertSliceEqual(t, acm0, []string{"[BOOT] Boot count: 1", "[SM] Initial state"})
+	assertSliceEqual(t, acm1, []string{"[BOOT] Boot count: 7"})
+	if logs["/dev/ttyACM0"].String() != inputs["/dev/ttyACM0"] || logs["/dev/ttyACM1"].String() != inputs["/dev/ttyACM1"] {
+		t.Errorf("per-port logs should be plain and unlabeled: %q", logs)
+	}
+}
+
+func TestMultiPort_OpenFailure(t *testing.T) {
+	closed := 0
+	m := &multiPort{
+		open: func(name string) (io.ReadCloser, error) {
+			if name == "COM9" {
+				return nil, errors.New("access denied")
+			}
+			return closeCounter{&closed}, nil
+		},
+		out:    &writerSink{w: io.Discard, format: formatText},
+		flush:  func() error { return nil },
+		prefix: prefixChain(nil),
+	}
+	if code := m.run([]string{"COM3", "COM9"}, make(chan struct{})); code != 1 {
+		t.Errorf("exit code %d, want 1", code)
+	}
+	if closed != 1 {
+		t.Errorf("ports opened before the failure should be closed, closed %d", closed)
+	}
+}
+
+type closeCounter struct{ n *int }
+
+func (closeCounter) Read([]byte) (int, error) { return 0, io.EOF }
+func (c closeCounter) Close() error           { *c.n++; return nil }
+
+func TestLabelSink_Color(t *testing.T) {
+	var out bytes.Buffer
+	labelSink{next: &terminalSink{out: &out}, color: true}.Write(LineEvent{Port: "/dev/ttyACM0", Prefix: "+0.100s ", Text: "x"})
+	if want := colorForPort("/dev/ttyACM0").wrap("[ttyACM0]") + " +0.100s x\n"; out.String() != want {
+		t.Errorf("got %q, want %q", out.String(), want)
+	}
+}
+
+func TestPortLogPath(t *testing.T) {
+	tests := map[[2]string]string{
+		{"monitor.log", "/dev/ttyACM0"}:       "monitor-ttyACM0.log",
+		{"logs/sumi", "/dev/cu.usbmodem1101"}: "logs/sumi-cu.usbmodem1101",
+		{"run.txt", "COM3"}:                   "run-COM3.txt",
+	}
+	for in, want := range tests {
+		if got := portLogPath(in[0], in[1]); got != want {
+			t.Errorf("portLogPath(%q, %q) = %q, want %q", in[0], in[1], got, want)
+		}
+	}
+}
+
+func TestUnsupportedMultiPortFlags(t *testing.T) {
+	got := unsupportedMultiPortFlags([]string{"port", "vt", "log", "reconnect", "timestamp"})
+	assertSliceEqual(t, got, []string{"-reconnect", "-vt"})
+}
@@ -0,0 +1,398 @@
+package main
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"go.bug.st/serial"
+)
+
+// netDialTimeout bounds connecting to a tcp:// or rfc2217:// port.
+const netDialTimeout = 5 * time.Second
+
+// netBaudTimeout bounds waiting for an rfc2217:// server to say which baud
+// rate it set.
+const netBaudTimeout = time.Second
+
+// Telnet bytes (RFC 854) and the RFC 2217 COM-PORT option commands used here.
+const (
+	telnetSE   = 240
+	telnetSB   = 250
+	telnetWILL = 251
+	telnetWONT = 252
+	telnetDO   = 253
+	telnetDONT = 254
+	telnetIAC  = 255
+
+	telnetBinary  = 0
+	telnetSGA     = 3
+	telnetComPort = 44
+
+	comSetBaud     = 1
+	comSetDataSize = 2
+	comSetParity   = 3
+	comSetStopSize = 4
+	comSetControl  = 5
+	comPurgeData   = 12
+
+	// A server answers each command with its code plus comReply.
+	comReply = 100
+
+	comBreakOn  = 5
+	comBreakOff = 6
+	comDTROn    = 8
+	comDTROff   = 9
+	comRTSOn    = 11
+	comRTSOff   = 12
+)
+
+// isNetworkPort reports whether name is a tcp:// or rfc2217:// URL rather than
+// a local device.
+func isNetworkPort(name string) bool {
+	return strings.HasPrefix(name, "tcp://") || strings.HasPrefix(name, "rfc2217://")
+}
+
+// openPort opens a local serial device, or a port exported over the network
+// by ser2net or a similar server when name is a tcp:// or rfc2217:// URL.
+func openPort(name string, mode *serial.Mode) (serial.Port, error) {
+	if !isNetworkPort(name) {
+		return openLocalPort(name, mode)
+	}
+	u, err := url.Parse(name)
+	if err != nil || u.Port() == "" {
+		return nil, fmt.Errorf("%s: expected %s://host:port", name, strings.SplitN(name, ":", 2)[0])
+	}
+	conn, err := net.DialTimeout("tcp", u.Host, netDialTimeout)
+	if err != nil {
+		return nil, err
+	}
+	p := &netPort{conn: conn, rfc2217: u.Scheme == "rfc2217", timeout: serial.NoTimeout, answered: map[[2]byte]bool{}}
+	if p.rfc2217 {
+		// The server's acknowledgements of these offers need no reply.
+		for _, key := range [][2]byte{
+			{telnetDO, telnetComPort}, {telnetDO, telnetBinary}, {telnetWILL, telnetBinary},
+			{telnetDO, telnetSGA}, {telnetWILL, telnetSGA},
+		} {
+			p.answered[key] = true
+		}
+		// Offer the COM-PORT option and an 8-bit clean session, then apply
+		// the line settings to the remote port.
+		p.send([]byte{
+			telnetIAC, telnetWILL, telnetComPort,
+			telnetIAC, telnetWILL, telnetBinary, telnetIAC, telnetDO, telnetBinary,
+			telnetIAC, telnetWILL, telnetSGA, telnetIAC, telnetDO, telnetSGA,
+		})
+	}
+	if err := p.SetMode(mode); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return p, nil
+}
+
+// netPort is a serial.Port reached over TCP. tcp:// is a raw byte pipe, as
+// ser2net's raw mode serves, with line settings fixed on the server. rfc2217://
+// is a Telnet session whose COM-PORT option carries the baud rate, framing and
+// DTR/RTS to the remote port.
+type netPort struct {
+	conn    net.Conn
+	rfc2217 bool
+	timeout time.Duration
+
+	wmu sync.Mutex // serializes writes of data and negotiation
+
+	// Telnet receive state, carried across reads.
+	state    int
+	verb     byte
+	sub      []byte
+	answered map[[2]byte]bool
+
+	baud    int    // the rate the server reported setting, 0 until it does
+	pending []byte // data read while waiting for baud
+}
+
+// Telnet receive states.
+const (
+	tnData = iota
+	tnIAC
+	tnOption
+	tnSub
+	tnSubIAC
+)
+
+func (p *netPort) send(b []byte) error {
+	p.wmu.Lock()
+	defer p.wmu.Unlock()
+	_, err := p.conn.Write(b)
+	return err
+}
+
+// comPort sends one COM-PORT option command.
+func (p *netPort) comPort(cmd byte, value ...byte) error {
+	b := []byte{telnetIAC, telnetSB, telnetComPort, cmd}
+	for _, v := range value {
+		b = append(b, v)
+		if v == telnetIAC {
+			b = append(b, v)
+		}
+	}
+	return p.send(append(b, telnetIAC, telnetSE))
+}
+
+// errNetPortUnsupported is returned for modem control on a raw tcp:// port.
+var errNetPortUnsupported = errors.New("not supported on a raw tcp:// port; use rfc2217://")
+
+func (p *netPort) SetMode(mode *serial.Mode) error {
+	if !p.rfc2217 || mode == nil {
+		return nil
+	}
+	baud := make([]byte, 4)
+	binary.BigEndian.PutUint32(baud, uint32(mode.BaudRate))
+	data := byte(mode.DataBits)
+	if data == 0 {
+		data = 8
+	}
+	parity := map[serial.Parity]byte{
+		serial.NoParity: 1, serial.OddParity: 2, serial.EvenParity: 3, serial.MarkParity: 4, serial.SpaceParity: 5,
+	}[mode.Parity]
+	stop := map[serial.StopBits]byte{
+		serial.OneStopBit: 1, serial.TwoStopBits: 2, serial.OnePointFiveStopBits: 3,
+	}[mode.StopBits]
+	for _, err := range []error{
+		p.comPort(comSetBaud, baud...),
+		p.comPort(comSetDataSize, data),
+		p.comPort(comSetParity, parity),
+		p.comPort(comSetStopSize, stop),
+	} {
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Read returns received data, or 0 and no error once the read timeout passes,
+// as serial ports do. Telnet negotiation is answered and never returned.
+func (p *netPort) Read(b []byte) (int, error) {
+	if len(p.pending) > 0 {
+		n := copy(b, p.pending)
+		p.pending = p.pending[n:]
+		return n, nil
+	}
+	for {
+		if p.timeout >= 0 {
+			p.conn.SetReadDeadline(time.Now().Add(p.timeout))
+		} else {
+			p.conn.SetReadDeadline(time.Time{})
+		}
+		n, err := p.conn.Read(b)
+		if !p.rfc2217 {
+			if errors.Is(err, os.ErrDeadlineExceeded) {
+				return n, nil
+			}
+			return n, err
+		}
+		n = p.unwrap(b[:n])
+		if errors.Is(err, os.ErrDeadlineExceeded) {
+			return n, nil
+		}
+		if n > 0 || err != nil {
+			return n, err
+		}
+	}
+}
+
+// unwrap removes Telnet commands from b in place, answering option requests,
+// and returns the number of data bytes left.
+func (p *netPort) unwrap(b []byte) int {
+	n := 0
+	for _, c := range b {
+		switch p.state {
+		case tnData:
+			if c == telnetIAC {
+				p.state = tnIAC
+				continue
+			}
+			b[n] = c
+			n++
+		case tnIAC:
+			switch {
+			case c == telnetIAC:
+				b[n] = c
+				n++
+				p.state = tnData
+			case c >= telnetWILL:
+				p.verb, p.state = c, tnOption
+			case c == telnetSB:
+				p.state = tnSub
+			default:
+				p.state = tnData
+			}
+		case tnOption:
+			p.answer(p.verb, c)
+			p.state = tnData
+		case tnSub:
+			if c == telnetIAC {
+				p.state = tnSubIAC
+			} else {
+				p.sub = append(p.sub, c)
+			}
+		case tnSubIAC:
+			switch c {
+			case telnetSE:
+				p.subnegotiation(p.sub)
+				p.sub, p.state = p.sub[:0], tnData
+			case telnetIAC:
+				p.sub, p.state = append(p.sub, c), tnSub
+			default:
+				p.state = tnSub
+			}
+		}
+	}
+	return n
+}
+
+// subnegotiation handles a server's COM-PORT reply. Only the baud rate is
+// kept; notifications such as NOTIFY-LINESTATE are not used.
+func (p *netPort) subnegotiation(sub []byte) {
+	if len(sub) == 6 && sub[0] == telnetComPort && sub[1] == comReply+comSetBaud {
+		p.baud = int(binary.BigEndian.Uint32(sub[2:]))
+	}
+}
+
+// ActualBaudRate returns the rate an rfc2217:// server reports having set on
+// the remote port, which it may have rounded to one the UART supports. Data
+// received while waiting for the reply is kept for Read.
+func (p *netPort) ActualBaudRate() (int, error) {
+	if !p.rfc2217 {
+		return 0, errNetPortUnsupported
+	}
+	buf := make([]byte, 256)
+	deadline := time.Now().Add(netBaudTimeout)
+	for p.baud == 0 && time.Now().Before(deadline) {
+		p.conn.SetReadDeadline(deadline)
+		n, err := p.conn.Read(buf)
+		p.pending = append(p.pending, buf[:p.unwrap(buf[:n])]...)
+		if err != nil {
+			break
+		}
+	}
+	if p.baud == 0 {
+		return 0, errors.New("the server did not report its baud rate")
+	}
+	return p.baud, nil
+}
+
+// answer replies once to each option request, agreeing to the binary, SGA and
+// COM-PORT options and refusing the rest.
+func (p *netPort) answer(verb, opt byte) {
+	key := [2]byte{verb, opt}
+	if p.answered[key] {
+		return
+	}
+	p.answered[key] = true
+	ok := opt == telnetBinary || opt == telnetSGA || opt == telnetComPort && verb == telnetDO
+	var reply byte
+	switch verb {
+	case telnetDO:
+		reply = telnetWONT
+		if ok {
+			reply = telnetWILL
+		}
+	case telnetWILL:
+		reply = telnetDONT
+		if ok {
+			reply = telnetDO
+		}
+	default:
+		return
+	}
+	p.send([]byte{telnetIAC, reply, opt})
+}
+
+// Write sends data, doubling 0xFF bytes inside a Telnet session.
+func (p *netPort) Write(b []byte) (int, error) {
+	if !p.rfc2217 {
+		p.wmu.Lock()
+		defer p.wmu.Unlock()
+		return p.conn.Write(b)
+	}
+	escaped := make([]byte, 0, len(b))
+	for _, c := range b {
+		escaped = append(escaped, c)
+		if c == telnetIAC {
+			escaped = append(escaped, c)
+		}
+	}
+	if err := p.send(escaped); err != nil {
+		return 0, err
+	}
+	return len(b), nil
+}
+
+func (p *netPort) Drain() error { return nil }
+
+func (p *netPort) ResetInputBuffer() error {
+	if !p.rfc2217 {
+		return nil
+	}
+	return p.comPort(comPurgeData, 1)
+}
+
+func (p *netPort) ResetOutputBuffer() error {
+	if !p.rfc2217 {
+		return nil
+	}
+	return p.comPort(comPurgeData, 2)
+}
+
+func (p *netPort) SetDTR(dtr bool) error {
+	if !p.rfc2217 {
+		return errNetPortUnsupported
+	}
+	if dtr {
+		return p.comPort(comSetControl, comDTROn)
+	}
+	return p.comPort(comSetControl, comDTROff)
+}
+
+func (p *netPort) SetRTS(rts bool) error {
+	if !p.rfc2217 {
+		return errNetPortUnsupported
+	}
+	if rts {
+		return p.comPort(comSetControl, comRTSOn)
+	}
+	return p.comPort(comSetControl, comRTSOff)
+}
+
+func (p *netPort) GetModemStatusBits() (*serial.ModemStatusBits, error) {
+	return nil, errors.New("modem status is not available on network ports")
+}
+
+func (p *netPort) SetReadTimeout(t time.Duration) error {
+	p.timeout = t
+	return nil
+}
+
+func (p *netPort) Close() error {
+	return p.conn.Close()
+}
+
+func (p *netPort) Break(d time.Duration) error {
+	if !p.rfc2217 {
+		return errNetPortUnsupported
+	}
+	if err := p.comPort(comSetControl, comBreakOn); err != nil {
+		return err
+	}
+	time.Sleep(d)
+	return p.comPort(comSetControl, comBreakOff)
+}
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"go.bug.st/serial"
+)
+
+// fakeSer2net accepts one connection and hands it to the test.
+func fakeSer2net(t *testing.T) (addr string, accepted <-chan net.Conn) {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	ch := make(chan net.Conn, 1)
+	go func() {
+		c, err := ln.Accept()
+		if err == nil {
+			ch <- c
+		}
+	}()
+	return ln.Addr().String(), ch
+}
+
+func TestOpenPort_RawTCP(t *testing.T) {
+	addr, accepted := fakeSer2net(t)
+	p, err := openPort("tcp://"+addr, &serial.Mode{BaudRate: 115200})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer p.Close()
+	server := <-accepted
+	defer server.Close()
+
+	server.Write([]byte("[BOOT] \xff ok\n"))
+	buf := make([]byte, 64)
+	n, err := p.Read(buf)
+	if err != nil || string(buf[:n]) != "[BOOT] \xff ok\n" {
+		t.Errorf("read %q, %v", buf[:n], err)
+	}
+	if err := p.SetDTR(false); err == nil {
+		t.Error("expected DTR control to be refused on a raw port")
+	}
+
+	p.SetReadTimeout(20 * time.Millisecond)
+	if n, err := p.Read(buf); n != 0 || err != nil {
+		t.Errorf("timed-out read returned %d, %v", n, err)
+	}
+}
+
+func TestOpenPort_RFC2217(t *testing.T) {
+	addr, accepted := fakeSer2net(t)
+	p, err := openPort("rfc2217://"+addr, &serial.Mode{BaudRate: 115200, Parity: serial.NoParity, StopBits: serial.OneStopBit})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer p.Close()
+	server := <-accepted
+	defer server.Close()
+
+	want := []byte{
+		255, 251, 44, 255, 251, 0, 255, 253, 0, 255, 251, 3, 255, 253, 3,
+		255, 250, 44, 1, 0, 1, 0xc2, 0, 255, 240, // baud 115200
+		255, 250, 44, 2, 8, 255, 240,
+		255, 250, 44, 3, 1, 255, 240,
+		255, 250, 44, 4, 1, 255, 240,
+	}
+	got := make([]byte, len(want))
+	if _, err := io.ReadFull(server, got); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, want) {
+		t.Fatalf("negotiation % x, want % x", got, want)
+	}
+
+	// An escaped 0xFF, an ack that needs no reply, a refused option and a
+	// server notification, all split across reads.
+	server.Write([]byte("ok \xff\xff"))
+	server.Write([]byte("\xff\xfd\x00\xff\xfb\x01\xff\xfa\x2c\x6b"))
+	server.Write([]byte("\x00\xff\xf0done"))
+	var data []byte
+	buf := make([]byte, 64)
+	for len(data) < len("ok \xffdone") {
+		n, err := p.Read(buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		data = append(data, buf[:n]...)
+	}
+	if string(data) != "ok \xffdone" {
+		t.Errorf("data %q", data)
+	}
+
+	p.Write([]byte{'a', 0xff})
+	p.SetDTR(false)
+	want = []byte{255, 254, 1, 'a', 255, 255, 255, 250, 44, 5, 9, 255, 240}
+	got = make([]byte, len(want))
+	if _, err := io.ReadFull(server, got); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, want) {
+		t.Errorf("sent % x, want % x", got, want)
+	}
+}
+
+func TestNetPort_ActualBaudRate(t *testing.T) {
+	addr, accepted := fakeSer2net(t)
+	p, err := openPort("rfc2217://"+addr, &serial.Mode{BaudRate: 921600})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer p.Close()
+	server := <-accepted
+	defer server.Close()
+
+	// Log arrives ahead of the SET-BAUDRATE reply, which reports the
+	// 900000 the remote UART could manage.
+	server.Write([]byte("boot\n\xff\xfa\x2c\x65\x00\x0d\xbb\xa0\xff\xf0"))
+	rate, ok := actualBaudRate(p)
+	if !ok || rate != 900000 {
+		t.Fatalf("actualBaudRate = %d, %v; want 900000", rate, ok)
+	}
+	buf := make([]byte, 64)
+	if n, err := p.Read(buf); err != nil || string(buf[:n]) != "boot\n" {
+		t.Errorf("read %q, %v; want the log that came first", buf[:n], err)
+	}
+}
+
+func TestNetPort_ActualBaudRate_RawTCP(t *testing.T) {
+	addr, accepted := fakeSer2net(t)
+	p, err := openPort("tcp://"+addr, &serial.Mode{BaudRate: 115200})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer p.Close()
+	server := <-accepted
+	defer server.Close()
+	if _, ok := actualBaudRate(p); ok {
+		t.Error("a raw tcp:// port has no way to know the remote rate")
+	}
+}
+
+func TestOpenPort_BadURL(t *testing.T) {
+	if _, err := openPort("rfc2217://host-without-port", nil); err == nil {
+		t.Error("expected error for a URL without a port")
+	}
+}
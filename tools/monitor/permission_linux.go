@@ -0,0 +1,72 @@
+//go:build linux
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/user"
+	"slices"
+	"strconv"
+	"syscall"
+)
+
+// deviceNode is the ownership and mode of a device file.
+type deviceNode struct {
+	uid, gid int
+	mode     os.FileMode
+}
+
+// statDevice is replaced in tests.
+var statDevice = func(path string) (deviceNode, error) {
+	fi, err := os.Stat(path)
+	if err != nil {
+		return deviceNode{}, err
+	}
+	st, ok := fi.Sys().(*syscall.Stat_t)
+	if !ok {
+		return deviceNode{}, fmt.Errorf("no ownership information for %s", path)
+	}
+	return deviceNode{uid: int(st.Uid), gid: int(st.Gid), mode: fi.Mode().Perm()}, nil
+}
+
+// processIdentity returns the effective user and group IDs of this process,
+// including supplementary groups. Replaced in tests.
+var processIdentity = func() (uid int, gids []int) {
+	gids, _ = os.Getgroups()
+	return os.Geteuid(), append(gids, os.Getegid())
+}
+
+// groupName resolves a group ID, falling back to the number. Replaced in tests.
+var groupName = func(gid int) string {
+	if g, err := user.LookupGroupId(strconv.Itoa(gid)); err == nil {
+		return g.Name
+	}
+	return strconv.Itoa(gid)
+}
+
+// checkDialoutPermission reports whether the current user can open port for
+// reading and writing, and if not, how to fix it. A port that cannot be
+// inspected is reported as ok so the open itself reports the problem.
+func checkDialoutPermission(port string) (ok bool, hint string) {
+	dev, err := statDevice(port)
+	if err != nil {
+		return true, ""
+	}
+	uid, gids := processIdentity()
+	switch {
+	case uid == 0,
+		dev.mode&0o006 == 0o006,
+		uid == dev.uid && dev.mode&0o600 == 0o600,
+		slices.Contains(gids, dev.gid) && dev.mode&0o060 == 0o060:
+		return true, ""
+	}
+	if dev.mode&0o060 != 0o060 {
+		return false, fmt.Sprintf("%s (mode %v) is not read/writable by its group, so joining a group will not help.\n"+
+			"Check the udev rules for the device.", port, dev.mode)
+	}
+	group := groupName(dev.gid)
+	return false, fmt.Sprintf("You are not in the %q group that owns %s. Add yourself with:\n"+
+		"  sudo usermod -aG %s $USER\n"+
+		"then log out and back in for the change to take effect.", group, port, group)
+}
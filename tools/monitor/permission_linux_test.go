@@ -0,0 +1,74 @@
+//go:build linux
+
+package main
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+// stubPermissions replaces the stat and identity lookups for one test.
+func stubPermissions(t *testing.T, dev deviceNode, uid int, gids ...int) {
+	t.Helper()
+	oldStat, oldID, oldName := statDevice, processIdentity, groupName
+	t.Cleanup(func() { statDevice, processIdentity, groupName = oldStat, oldID, oldName })
+	statDevice = func(string) (deviceNode, error) { return dev, nil }
+	processIdentity = func() (int, []int) { return uid, gids }
+	groupName = func(gid int) string {
+		if gid == 20 {
+			return "dialout"
+		}
+		return "uucp"
+	}
+}
+
+func TestCheckDialoutPermission_Allowed(t *testing.T) {
+	cases := []struct {
+		name string
+		dev  deviceNode
+		uid  int
+		gids []int
+	}{
+		{"root", deviceNode{uid: 0, gid: 20, mode: 0o660}, 0, nil},
+		{"in group", deviceNode{uid: 0, gid: 20, mode: 0o660}, 1000, []int{1000, 20}},
+		{"owner", deviceNode{uid: 1000, gid: 20, mode: 0o600}, 1000, []int{1000}},
+		{"world", deviceNode{uid: 0, gid: 20, mode: 0o666}, 1000, []int{1000}},
+	}
+	for _, c := range cases {
+		stubPermissions(t, c.dev, c.uid, c.gids...)
+		if ok, hint := checkDialoutPermission("/dev/ttyACM0"); !ok {
+			t.Errorf("%s: denied with %q", c.name, hint)
+		}
+	}
+}
+
+func TestCheckDialoutPermission_UsermodHint(t *testing.T) {
+	stubPermissions(t, deviceNode{uid: 0, gid: 20, mode: 0o660}, 1000, 1000, 27)
+	ok, hint := checkDialoutPermission("/dev/ttyACM0")
+	if ok {
+		t.Fatal("expected denial")
+	}
+	want := "You are not in the \"dialout\" group that owns /dev/ttyACM0. Add yourself with:\n" +
+		"  sudo usermod -aG dialout $USER\n" +
+		"then log out and back in for the change to take effect."
+	if hint != want {
+		t.Errorf("hint = %q, want %q", hint, want)
+	}
+}
+
+func TestCheckDialoutPermission_GroupCannotWrite(t *testing.T) {
+	stubPermissions(t, deviceNode{uid: 0, gid: 14, mode: 0o640}, 1000, 1000)
+	ok, hint := checkDialoutPermission("/dev/ttyUSB0")
+	if ok || strings.Contains(hint, "usermod") || !strings.Contains(hint, "udev") {
+		t.Errorf("ok=%v hint=%q", ok, hint)
+	}
+}
+
+func TestCheckDialoutPermission_StatFailure(t *testing.T) {
+	stubPermissions(t, deviceNode{}, 1000)
+	statDevice = func(string) (deviceNode, error) { return deviceNode{}, errors.New("no such file") }
+	if ok, hint := checkDialoutPermission("/dev/missing"); !ok || hint != "" {
+		t.Errorf("ok=%v hint=%q, want the open to report the error", ok, hint)
+	}
+}
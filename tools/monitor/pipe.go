@@ -0,0 +1,63 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+// linePipe runs a user's decoder for -pipe: each line is written to the
+// program's stdin and the line it prints back replaces it. The program must
+// answer every line with exactly one line, flushing as it goes; an empty
+// answer hides the line.
+type linePipe struct {
+	cmd *exec.Cmd
+	in  io.WriteCloser
+	out *bufio.Reader
+}
+
+// startLinePipe starts command through the shell, with the port in SUMI_PORT.
+// Its stderr goes to stderr.
+func startLinePipe(command, port string, stderr io.Writer) (*linePipe, error) {
+	cmd := shellCommand(command)
+	cmd.Env = append(os.Environ(), "SUMI_PORT="+port)
+	cmd.Stderr = stderr
+	in, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, err
+	}
+	out, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, err
+	}
+	return &linePipe{cmd: cmd, in: in, out: bufio.NewReader(out)}, nil
+}
+
+// apply sends one line through the program and returns its answer, and
+// whether the line should be shown.
+func (p *linePipe) apply(text string) (string, bool, error) {
+	if _, err := io.WriteString(p.in, text+"\n"); err != nil {
+		return "", false, err
+	}
+	reply, err := p.out.ReadString('\n')
+	if err != nil {
+		if err == io.EOF {
+			err = errors.New("program exited")
+		}
+		return "", false, err
+	}
+	reply = strings.TrimSuffix(strings.TrimSuffix(reply, "\n"), "\r")
+	return reply, reply != "", nil
+}
+
+// close ends the program's input and waits for it to exit.
+func (p *linePipe) close() error {
+	p.in.Close()
+	return p.cmd.Wait()
+}
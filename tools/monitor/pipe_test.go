@@ -0,0 +1,125 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLinePipe(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("uses a POSIX shell loop")
+	}
+	p, err := startLinePipe(`while read -r l; do case "$l" in T:*) echo "telemetry ${l#T:} ($SUMI_PORT)";; noise*) echo;; *) echo "$l";; esac; done`, "/dev/ttyACM0", io.Discard)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, l := range []string{"[BOOT] ready", "noise 123", "T:42", ""} {
+		text, show, err := p.apply(l)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if show {
+			got = append(got, text)
+		}
+	}
+	assertSliceEqual(t, got, []string{"[BOOT] ready", "telemetry 42 (/dev/ttyACM0)"})
+	if err := p.close(); err != nil {
+		t.Errorf("close: %v", err)
+	}
+}
+
+func TestLinePipe_Exited(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("uses a POSIX shell")
+	}
+	p, err := startLinePipe("true", "", io.Discard)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer p.close()
+	if _, _, err := p.apply("line"); err == nil {
+		t.Error("expected an error once the program has exited")
+	}
+}
+
+func TestLinePipe_HiddenLineStillMatches(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("uses a POSIX shell loop")
+	}
+	p, err := startLinePipe(`while read -r l; do case "$l" in *panic*) echo;; *) echo "$l";; esac; done`, "", io.Discard)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer p.close()
+	received := LineEvent{Text: "Guru Meditation Error: Core 0 panic'ed"}
+	if _, show, err := p.apply(received.Text); err != nil || show {
+		t.Fatalf("apply = %v, %v; want the line hidden", show, err)
+	}
+	if code := watchLine(received, regexp.MustCompile(`panic`), nil, io.Discard); code != 0 {
+		t.Errorf("-match on a hidden line: status %d, want 0", code)
+	}
+	on, err := parseTrigger("panic=>exit 3")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if code := watchLine(received, nil, []trigger{on}, io.Discard); code != 3 {
+		t.Errorf("-on on a hidden line: status %d, want 3", code)
+	}
+}
+
+func TestLinePipe_TelemetrySeesReceivedLines(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("uses a POSIX shell loop")
+	}
+	// A decoder that hides heap reports and rewrites battery ones.
+	p, err := startLinePipe(`while read -r l; do case "$l" in *MEM*) echo;; *BAT*) echo "battery ok";; *) echo "$l";; esac; done`, "", io.Discard)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer p.close()
+	var csv bytes.Buffer
+	prom := newPromExporter("COM3")
+	telemetry := []Sink{newMetricsSink(&csv), prom}
+	t0 := time.Date(2026, 10, 16, 9, 0, 0, 0, time.UTC)
+	var shown []string
+	for _, l := range []string{
+		"[1000] [MEM] Free: 151234 bytes, Total: 327680 bytes, Min Free: 120000 bytes",
+		"[1000] [BAT] Battery: 3987 mV, 82%",
+		"[1200] [GFX] Render took 412 ms",
+	} {
+		received := LineEvent{Time: t0, Port: "COM3", Text: l}
+		telemetry = fanOut(telemetry, received)
+		if text, show, err := p.apply(l); err != nil {
+			t.Fatal(err)
+		} else if show {
+			shown = append(shown, text)
+		}
+	}
+	assertSliceEqual(t, shown, []string{"battery ok", "[1200] [GFX] Render took 412 ms"})
+	want := "time,port,heap_free,heap_min_free,heap_largest,render_ms,refresh_wait_ms,battery_mv,battery_pct\n" +
+		"2026-10-16T09:00:00Z,COM3,151234,120000,,,,,\n" +
+		"2026-10-16T09:00:00Z,COM3,,,,,,3987,82\n" +
+		"2026-10-16T09:00:00Z,COM3,,,,412,,,\n"
+	if csv.String() != want {
+		t.Errorf("metrics\n%s\nwant\n%s", csv.String(), want)
+	}
+	rec := httptest.NewRecorder()
+	prom.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	for _, gauge := range []string{
+		`sumi_heap_free_bytes{port="COM3"} 151234`,
+		`sumi_battery_percent{port="COM3"} 82`,
+		`sumi_lines_total{port="COM3"} 3`,
+	} {
+		if !strings.Contains(rec.Body.String(), gauge) {
+			t.Errorf("/metrics lacks %s:\n%s", gauge, rec.Body.String())
+		}
+	}
+}
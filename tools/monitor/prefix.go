@@ -0,0 +1,47 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// prefixer renders one element of a text line's prefix, such as its offset.
+type prefixer func(LineEvent) string
+
+// prefixChain joins prefixers into one prefix, each element followed by a space.
+func prefixChain(ps []prefixer) func(LineEvent) string {
+	return func(ev LineEvent) string {
+		var b strings.Builder
+		for _, p := range ps {
+			b.WriteString(p(ev))
+			b.WriteByte(' ')
+		}
+		return b.String()
+	}
+}
+
+// offsetPrefix renders the raw-stream offset at which the line started.
+func offsetPrefix(ev LineEvent) string {
+	return fmt.Sprintf("@0x%04X", ev.Offset)
+}
+
+// timestampPrefix returns the -timestamp prefixer for mode: wall-clock time
+// (absolute), time since start (relative) or time since the previous line
+// (delta).
+func timestampPrefix(mode string, start time.Time) (prefixer, error) {
+	switch mode {
+	case "absolute":
+		return func(ev LineEvent) string { return ev.Time.Format("15:04:05.000") }, nil
+	case "relative":
+		return func(ev LineEvent) string { return fmt.Sprintf("+%.3fs", ev.Time.Sub(start).Seconds()) }, nil
+	case "delta":
+		last := start
+		return func(ev LineEvent) string {
+			d := ev.Time.Sub(last)
+			last = ev.Time
+			return fmt.Sprintf("+%.3fs", d.Seconds())
+		}, nil
+	}
+	return nil, fmt.Errorf("unknown timestamp mode %q (want absolute, relative or delta)", mode)
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestPrefixChain(t *testing.T) {
+	ev := LineEvent{Offset: 0x1A3F, Text: "x"}
+	if got := prefixChain([]prefixer{offsetPrefix})(ev); got != "@0x1A3F " {
+		t.Errorf("got %q", got)
+	}
+	double := prefixChain([]prefixer{offsetPrefix, func(LineEvent) string { return "[ACM0]" }})
+	if got := double(ev); got != "@0x1A3F [ACM0] " {
+		t.Errorf("got %q", got)
+	}
+	if got := prefixChain(nil)(ev); got != "" {
+		t.Errorf("empty chain gave %q", got)
+	}
+}
+
+func TestTimestampPrefix(t *testing.T) {
+	start := time.Date(2026, 3, 1, 9, 30, 15, 0, time.UTC)
+	times := []time.Time{start.Add(1500 * time.Millisecond), start.Add(1512 * time.Millisecond), start.Add(4 * time.Second)}
+	want := map[string][]string{
+		"absolute": {"09:30:16.500", "09:30:16.512", "09:30:19.000"},
+		"relative": {"+1.500s", "+1.512s", "+4.000s"},
+		"delta":    {"+1.500s", "+0.012s", "+2.488s"},
+	}
+	for mode, w := range want {
+		p, err := timestampPrefix(mode, start)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var got []string
+		for _, ts := range times {
+			got = append(got, p(LineEvent{Time: ts}))
+		}
+		assertSliceEqual(t, got, w)
+	}
+	if _, err := timestampPrefix("iso", start); err == nil {
+		t.Error("expected error for unknown mode")
+	}
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+
+	"go.bug.st/serial"
+)
+
+// defaultBannerTimeout is how long banner detection waits for a board to
+// identify itself; booting boards need a few seconds.
+const defaultBannerTimeout = 3 * time.Second
+
+// versionPattern extracts the firmware version from its startup line.
+var versionPattern = regexp.MustCompile(`SUMI version (\S+)`)
+
+// probeResult is what -probe-all learned about one port.
+type probeResult struct {
+	port    string
+	banner  string // first line matching the banner pattern
+	version string // firmware version, if a version line was seen
+	first   string // first line of any kind, for boards without a banner
+	silent  bool
+	err     error
+}
+
+func (r probeResult) String() string {
+	switch {
+	case r.err != nil:
+		return fmt.Sprintf("%s: error: %v", r.port, r.err)
+	case r.silent:
+		return fmt.Sprintf("%s: silent", r.port)
+	case r.version != "":
+		return fmt.Sprintf("%s: SUMI %s (%q)", r.port, r.version, r.banner)
+	case r.banner != "":
+		return fmt.Sprintf("%s: banner %q", r.port, r.banner)
+	default:
+		return fmt.Sprintf("%s: output but no banner: %q", r.port, r.first)
+	}
+}
+
+// detectBanner fills in the banner, version and first-line fields from the lines
+// a board printed during the probe window.
+func detectBanner(r *probeResult, lines []string, banner *regexp.Regexp) {
+	for _, l := range lines {
+		if l == "" {
+			continue
+		}
+		if r.first == "" {
+			r.first = l
+		}
+		if r.banner == "" && banner.MatchString(l) {
+			r.banner = l
+		}
+		if m := versionPattern.FindStringSubmatch(l); m != nil && r.version == "" {
+			r.version = m[1]
+			r.banner = l
+		}
+	}
+	r.silent = r.first == ""
+}
+
+// splitProbeLines splits captured bytes into trimmed lines.
+func splitProbeLines(data []byte) []string {
+	var lines []string
+	for _, l := range bytes.Split(data, []byte{'\n'}) {
+		lines = append(lines, strings.TrimRight(string(l), "\r"))
+	}
+	return lines
+}
+
+// timedReader is the part of serial.Port used to listen with a deadline.
+type timedReader interface {
+	SetReadTimeout(t time.Duration) error
+	Read(p []byte) (int, error)
+}
+
+// listenForBanner reads from r until the firmware version line arrives or
+// timeout elapses, and returns the lines received. The timeout is the banner
+// timeout only; it is independent of any idle or session timeout.
+func listenForBanner(r timedReader, timeout time.Duration) ([]string, error) {
+	var data []byte
+	buf := make([]byte, 1024)
+	deadline := time.Now().Add(timeout)
+	for remaining := timeout; remaining > 0; remaining = time.Until(deadline) {
+		if err := r.SetReadTimeout(remaining); err != nil {
+			return nil, err
+		}
+		n, err := r.Read(buf)
+		data = append(data, buf[:n]...)
+		if err != nil {
+			return splitProbeLines(data), err
+		}
+		if complete := data[:bytes.LastIndexByte(data, '\n')+1]; versionPattern.Match(complete) {
+			break
+		}
+	}
+	return splitProbeLines(data), nil
+}
+
+// probePort opens a port with DTR and RTS deasserted, so the board is not reset,
+// and listens for up to timeout.
+func probePort(name string, baud int, timeout time.Duration, banner *regexp.Regexp) probeResult {
+	r := probeResult{port: name}
+	mode := &serial.Mode{BaudRate: baud, InitialStatusBits: &serial.ModemOutputBits{}}
+	port, err := serial.Open(name, mode)
+	if err != nil {
+		r.err = err
+		return r
+	}
+	defer port.Close()
+
+	lines, err := listenForBanner(port, timeout)
+	if err != nil {
+		r.err = err
+		return r
+	}
+	detectBanner(&r, lines, banner)
+	return r
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"errors"
+	"regexp"
+	"testing"
+	"time"
+)
+
+func TestDetectBanner_Version(t *testing.T) {
+	var r probeResult
+	lines := splitProbeLines([]byte("ESP-ROM:esp32s3-20210327\r\n[12] [   ] Starting SUMI version 0.6.4\r\n[40] [FS] LittleFS mounted\r\n"))
+	detectBanner(&r, lines, regexp.MustCompile(defaultBootBanner))
+	if r.silent || r.version != "0.6.4" {
+		t.Fatalf("got %+v", r)
+	}
+	if r.banner != "[12] [   ] Starting SUMI version 0.6.4" {
+		t.Errorf("banner = %q", r.banner)
+	}
+}
+
+func TestDetectBanner_Silent(t *testing.T) {
+	r := probeResult{port: "/dev/ttyACM1"}
+	detectBanner(&r, splitProbeLines(nil), regexp.MustCompile(defaultBootBanner))
+	if !r.silent {
+		t.Fatal("expected silent")
+	}
+	if r.String() != "/dev/ttyACM1: silent" {
+		t.Errorf("got %q", r.String())
+	}
+}
+
+func TestDetectBanner_NoBanner(t *testing.T) {
+	r := probeResult{port: "COM3"}
+	detectBanner(&r, splitProbeLines([]byte("[HOME] Loaded 3 recent books\n")), regexp.MustCompile(defaultBootBanner))
+	if r.silent || r.banner != "" || r.first != "[HOME] Loaded 3 recent books" {
+		t.Fatalf("got %+v", r)
+	}
+	if r.String() != `COM3: output but no banner: "[HOME] Loaded 3 recent books"` {
+		t.Errorf("got %q", r.String())
+	}
+}
+
+func TestProbeResult_Error(t *testing.T) {
+	r := probeResult{port: "COM3", err: errors.New("Serial port busy")}
+	if r.String() != "COM3: error: Serial port busy" {
+		t.Errorf("got %q", r.String())
+	}
+}
+
+// scriptedPort delivers data at a fixed time after it was created and honors
+// read timeouts like a serial port: a timed-out read returns 0, nil.
+type scriptedPort struct {
+	start   time.Time
+	at      time.Duration
+	data    []byte
+	sent    bool
+	timeout time.Duration
+}
+
+func (p *scriptedPort) SetReadTimeout(t time.Duration) error {
+	p.timeout = t
+	return nil
+}
+
+func (p *scriptedPort) Read(buf []byte) (int, error) {
+	wait := time.Until(p.start.Add(p.at))
+	if p.sent || wait > p.timeout {
+		time.Sleep(p.timeout)
+		return 0, nil
+	}
+	time.Sleep(wait)
+	p.sent = true
+	return copy(buf, p.data), nil
+}
+
+func TestListenForBanner_RespectsTimeout(t *testing.T) {
+	port := &scriptedPort{start: time.Now(), at: 150 * time.Millisecond, data: []byte("Starting SUMI version 0.6.4\n")}
+	lines, err := listenForBanner(port, 30*time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var r probeResult
+	detectBanner(&r, lines, regexp.MustCompile(defaultBootBanner))
+	if !r.silent {
+		t.Errorf("banner after the timeout should not be seen, got %+v", r)
+	}
+}
+
+func TestListenForBanner_StopsAtVersion(t *testing.T) {
+	port := &scriptedPort{start: time.Now(), at: 20 * time.Millisecond, data: []byte("ESP-ROM:esp32s3\r\nStarting SUMI version 0.6.4\r\n")}
+	begin := time.Now()
+	lines, err := listenForBanner(port, 2*time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if elapsed := time.Since(begin); elapsed > time.Second {
+		t.Errorf("listening continued for %v after the version line", elapsed)
+	}
+	var r probeResult
+	detectBanner(&r, lines, regexp.MustCompile(defaultBootBanner))
+	if r.version != "0.6.4" {
+		t.Errorf("got %+v", r)
+	}
+}
@@ -0,0 +1,288 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"go.bug.st/serial"
+)
+
+// Profiles live in a small TOML file, one table per profile:
+//
+//	[profiles.work-bench]
+//	port = "/dev/ttyACM*"     # a glob; several matches monitor them all
+//	speed = 921600
+//	elf = "~/sumi/.pio/build/default/firmware.elf"
+//	include = ['\[BLE', '\[SM\]']
+//	log-dir = "~/sumi-logs"   # a new log file per session
+//
+// Keys are flag names, and flags given on the command line win.
+// Only the TOML this needs is understood: strings, numbers, booleans and
+// one-line arrays of them.
+
+// profilePath returns the default profile file, ~/.config/sumi/monitor.toml
+// (or under $XDG_CONFIG_HOME when set).
+func profilePath() (string, error) {
+	dir := os.Getenv("XDG_CONFIG_HOME")
+	if dir == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return "", err
+		}
+		dir = filepath.Join(home, ".config")
+	}
+	return filepath.Join(dir, "sumi", "monitor.toml"), nil
+}
+
+// useProfile applies the named profile from path, or from profilePath when
+// path is empty, to the command-line flags.
+func useProfile(path, name string) error {
+	if path == "" {
+		var err error
+		if path, err = profilePath(); err != nil {
+			return err
+		}
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	profiles, err := parseProfiles(f)
+	if err != nil {
+		return fmt.Errorf("%s: %v", path, err)
+	}
+	settings, ok := profiles[name]
+	if !ok {
+		names := make([]string, 0, len(profiles))
+		for n := range profiles {
+			names = append(names, n)
+		}
+		sort.Strings(names)
+		return fmt.Errorf("no profile %q in %s (have: %s)", name, path, strings.Join(names, ", "))
+	}
+	explicit := map[string]bool{}
+	flag.Visit(func(f *flag.Flag) { explicit[f.Name] = true })
+	return applyProfile(flag.CommandLine, name, settings, explicit, serial.GetPortsList)
+}
+
+// profileSetting is one key of a profile with its value, or values for an
+// array.
+type profileSetting struct {
+	key    string
+	values []string
+	line   int
+}
+
+var (
+	profileTable = regexp.MustCompile(`^\[\s*profiles\.([A-Za-z0-9_-]+)\s*\]$`)
+	profileKey   = regexp.MustCompile(`^([A-Za-z0-9_-]+)\s*=\s*`)
+)
+
+// parseProfiles reads a profile file into settings by profile name, in file
+// order.
+func parseProfiles(r io.Reader) (map[string][]profileSetting, error) {
+	profiles := map[string][]profileSetting{}
+	current := ""
+	sc := bufio.NewScanner(r)
+	for n := 1; sc.Scan(); n++ {
+		line := strings.TrimSpace(sc.Text())
+		if line == "" || line[0] == '#' {
+			continue
+		}
+		if line[0] == '[' {
+			m := profileTable.FindStringSubmatch(stripTOMLComment(line))
+			if m == nil {
+				return nil, fmt.Errorf("line %d: expected [profiles.<name>], got %s", n, line)
+			}
+			current = m[1]
+			if _, dup := profiles[current]; dup {
+				return nil, fmt.Errorf("line %d: profile %q defined twice", n, current)
+			}
+			profiles[current] = nil
+			continue
+		}
+		m := profileKey.FindStringSubmatch(line)
+		if m == nil {
+			return nil, fmt.Errorf("line %d: expected key = value, got %s", n, line)
+		}
+		if current == "" {
+			return nil, fmt.Errorf("line %d: %s is outside a [profiles.<name>] table", n, m[1])
+		}
+		values, err := parseTOMLValue(line[len(m[0]):])
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %s: %v", n, m[1], err)
+		}
+		profiles[current] = append(profiles[current], profileSetting{key: m[1], values: values, line: n})
+	}
+	return profiles, sc.Err()
+}
+
+// parseTOMLValue parses a scalar or a one-line array, returning the values
+// as flag text. A trailing comment is allowed.
+func parseTOMLValue(s string) ([]string, error) {
+	if strings.HasPrefix(s, "[") {
+		var values []string
+		rest := strings.TrimSpace(s[1:])
+		for !strings.HasPrefix(rest, "]") {
+			v, after, err := nextTOMLScalar(rest)
+			if err != nil {
+				return nil, err
+			}
+			values = append(values, v)
+			rest = strings.TrimSpace(after)
+			if strings.HasPrefix(rest, ",") {
+				rest = strings.TrimSpace(rest[1:])
+			} else if !strings.HasPrefix(rest, "]") {
+				return nil, errors.New("arrays must be on one line, values separated by commas")
+			}
+		}
+		return values, checkTOMLEnd(rest[1:])
+	}
+	v, rest, err := nextTOMLScalar(s)
+	if err != nil {
+		return nil, err
+	}
+	return []string{v}, checkTOMLEnd(rest)
+}
+
+// nextTOMLScalar parses a string, number or boolean at the start of s.
+func nextTOMLScalar(s string) (value, rest string, err error) {
+	switch {
+	case s == "":
+		return "", "", errors.New("missing value")
+	case s[0] == '\'':
+		// Literal string: no escapes, which suits regexps.
+		end := strings.IndexByte(s[1:], '\'')
+		if end < 0 {
+			return "", "", errors.New("unterminated string")
+		}
+		return s[1 : end+1], s[end+2:], nil
+	case s[0] == '"':
+		for i := 1; i < len(s); i++ {
+			switch s[i] {
+			case '\\':
+				i++
+			case '"':
+				v, err := strconv.Unquote(s[:i+1])
+				if err != nil {
+					return "", "", fmt.Errorf("bad string %s", s[:i+1])
+				}
+				return v, s[i+1:], nil
+			}
+		}
+		return "", "", errors.New("unterminated string")
+	}
+	end := strings.IndexAny(s, ",] \t#")
+	if end < 0 {
+		end = len(s)
+	}
+	v := s[:end]
+	if _, err := strconv.ParseFloat(strings.ReplaceAll(v, "_", ""), 64); err != nil && v != "true" && v != "false" {
+		return "", "", fmt.Errorf("expected a string, number or boolean, got %q (quote strings)", v)
+	}
+	return strings.ReplaceAll(v, "_", ""), s[end:], nil
+}
+
+// checkTOMLEnd reports anything but a comment after a value.
+func checkTOMLEnd(rest string) error {
+	if rest = stripTOMLComment(rest); strings.TrimSpace(rest) != "" {
+		return fmt.Errorf("unexpected %q after the value", strings.TrimSpace(rest))
+	}
+	return nil
+}
+
+func stripTOMLComment(s string) string {
+	if i := strings.IndexByte(s, '#'); i >= 0 {
+		return strings.TrimSpace(s[:i])
+	}
+	return s
+}
+
+// expandHome replaces a leading ~/ with the home directory.
+func expandHome(s string) string {
+	if rest, ok := strings.CutPrefix(s, "~/"); ok {
+		if home, err := os.UserHomeDir(); err == nil {
+			return filepath.Join(home, rest)
+		}
+	}
+	return s
+}
+
+// applyProfile sets the flags of fs from a profile's settings, except those
+// in explicit, which were given on the command line. A port glob is matched
+// against ports.
+func applyProfile(fs *flag.FlagSet, name string, settings []profileSetting, explicit map[string]bool, ports func() ([]string, error)) error {
+	for _, s := range settings {
+		key := s.key
+		if key == "baud" {
+			key = "speed"
+		}
+		values := make([]string, len(s.values))
+		for i, v := range s.values {
+			values[i] = expandHome(v)
+		}
+		switch key {
+		case "log", "log-dir":
+			// Either on the command line replaces the profile's log.
+			if explicit["log"] || explicit["log-dir"] {
+				continue
+			}
+		case "port":
+			if explicit["port"] || explicit["all"] {
+				continue
+			}
+			var err error
+			if values, err = expandPortGlobs(values, ports); err != nil {
+				return fmt.Errorf("profile %s, line %d: %v", name, s.line, err)
+			}
+		}
+		if fs.Lookup(key) == nil {
+			return fmt.Errorf("profile %s, line %d: unknown setting %q", name, s.line, s.key)
+		}
+		if explicit[key] {
+			continue
+		}
+		for _, v := range values {
+			if err := fs.Set(key, v); err != nil {
+				return fmt.Errorf("profile %s, line %d: %s: %v", name, s.line, s.key, err)
+			}
+		}
+	}
+	return nil
+}
+
+// expandPortGlobs replaces port patterns with the ports they match. A name
+// without glob characters is kept as is, so network ports pass through.
+func expandPortGlobs(patterns []string, ports func() ([]string, error)) ([]string, error) {
+	var out []string
+	for _, p := range patterns {
+		if !strings.ContainsAny(p, "*?[") {
+			out = append(out, p)
+			continue
+		}
+		available, err := ports()
+		if err != nil {
+			return nil, fmt.Errorf("listing serial ports: %v", err)
+		}
+		n := len(out)
+		for _, name := range available {
+			if ok, _ := filepath.Match(p, name); ok {
+				out = append(out, name)
+			}
+		}
+		if len(out) == n {
+			return nil, fmt.Errorf("no serial port matches %q (available: %v)", p, available)
+		}
+	}
+	return out, nil
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"flag"
+	"strings"
+	"testing"
+)
+
+const testProfiles = `# bench setups
+[profiles.work-bench]
+port = "/dev/ttyACM*"   # whichever board is plugged in
+baud = 921_600
+elf = "/builds/firmware.elf"
+include = ['\[BLE', "\\[SM\\]"]
+timestamp = true
+
+[profiles.ci]
+speed = 115200
+`
+
+func TestParseProfiles(t *testing.T) {
+	profiles, err := parseProfiles(strings.NewReader(testProfiles))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(profiles) != 2 {
+		t.Fatalf("got %d profiles", len(profiles))
+	}
+	var got []string
+	for _, s := range profiles["work-bench"] {
+		got = append(got, s.key+"="+strings.Join(s.values, "|"))
+	}
+	assertSliceEqual(t, got, []string{
+		"port=/dev/ttyACM*", "baud=921600", "elf=/builds/firmware.elf",
+		`include=\[BLE|\[SM\]`, "timestamp=true",
+	})
+}
+
+func TestParseProfiles_Errors(t *testing.T) {
+	for _, bad := range []string{
+		"speed = 115200",                    // outside a table
+		"[serial]",                          // not a profile table
+		"[profiles.a]\nport = /dev/ttyACM0", // unquoted string
+		"[profiles.a]\nport = \"/dev/tty",   // unterminated
+		"[profiles.a]\ninclude = ['a',",     // multi-line array
+		"[profiles.a]\nspeed = 1 2",         // trailing junk
+		"[profiles.a]\n[profiles.a]",        // duplicate
+	} {
+		if _, err := parseProfiles(strings.NewReader(bad)); err == nil {
+			t.Errorf("%q: expected error", bad)
+		}
+	}
+}
+
+// profileFlags returns a flag set with the flags the tests apply.
+func profileFlags() (*flag.FlagSet, *int, *string, *stringList, *stringList) {
+	fs := flag.NewFlagSet("monitor", flag.ContinueOnError)
+	speed := fs.Int("speed", 115200, "")
+	log := fs.String("log", "", "")
+	fs.Bool("all", false, "")
+	var ports, include stringList
+	fs.Var(&ports, "port", "")
+	fs.Var(&include, "include", "")
+	return fs, speed, log, &ports, &include
+}
+
+func TestApplyProfile_CommandLineWins(t *testing.T) {
+	fs, speed, _, ports, include := profileFlags()
+	fs.Parse([]string{"-speed", "9600"})
+	settings := []profileSetting{
+		{key: "baud", values: []string{"921600"}},
+		{key: "port", values: []string{"/dev/ttyACM*"}},
+		{key: "include", values: []string{"a", "b"}},
+	}
+	list := func() ([]string, error) { return []string{"/dev/ttyS0", "/dev/ttyACM1", "/dev/ttyACM0"}, nil }
+	if err := applyProfile(fs, "bench", settings, map[string]bool{"speed": true}, list); err != nil {
+		t.Fatal(err)
+	}
+	if *speed != 9600 {
+		t.Errorf("speed = %d, want the command line's 9600", *speed)
+	}
+	assertSliceEqual(t, *ports, []string{"/dev/ttyACM1", "/dev/ttyACM0"})
+	assertSliceEqual(t, *include, []string{"a", "b"})
+}
+
+func TestApplyProfile_CommandLineLogReplacesLogDir(t *testing.T) {
+	fs, _, log, _, _ := profileFlags()
+	logDir := fs.String("log-dir", "", "")
+	fs.Parse([]string{"-log", "today.log"})
+	if err := applyProfile(fs, "bench", []profileSetting{{key: "log-dir", values: []string{"/var/log/sumi"}}}, map[string]bool{"log": true}, nil); err != nil {
+		t.Fatal(err)
+	}
+	if *log != "today.log" || *logDir != "" {
+		t.Errorf("log = %q, log-dir = %q", *log, *logDir)
+	}
+}
+
+func TestApplyProfile_Errors(t *testing.T) {
+	list := func() ([]string, error) { return []string{"/dev/ttyS0"}, nil }
+	for _, s := range []profileSetting{
+		{key: "colour", values: []string{"always"}},
+		{key: "port", values: []string{"/dev/ttyACM*"}},
+		{key: "speed", values: []string{"fast"}},
+	} {
+		fs, _, _, _, _ := profileFlags()
+		if err := applyProfile(fs, "bench", []profileSetting{s}, nil, list); err == nil {
+			t.Errorf("%s: expected error", s.key)
+		}
+	}
+}
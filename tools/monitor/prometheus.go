@@ -0,0 +1,79 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// promGauges maps -metrics columns to the Prometheus gauges that carry them.
+var promGauges = []struct{ column, name, help string }{
+	{"heap_free", "sumi_heap_free_bytes", "Free heap last reported by the device."},
+	{"heap_min_free", "sumi_heap_min_free_bytes", "Lowest free heap since boot."},
+	{"heap_largest", "sumi_heap_largest_block_bytes", "Largest allocatable heap block."},
+	{"render_ms", "sumi_render_milliseconds", "Duration of the last screen render."},
+	{"refresh_wait_ms", "sumi_refresh_wait_milliseconds", "Time the last display refresh kept the renderer waiting."},
+	{"battery_mv", "sumi_battery_millivolts", "Battery voltage."},
+	{"battery_pct", "sumi_battery_percent", "Battery charge estimated from the voltage."},
+}
+
+// promExporter keeps the latest telemetry values and serves them in the
+// Prometheus text format, for graphing bench devices over days.
+type promExporter struct {
+	mu     sync.Mutex
+	port   string
+	values map[string]float64 // by -metrics column
+	lines  int
+	resets resetWatch
+}
+
+func newPromExporter(port string) *promExporter {
+	return &promExporter{port: port, values: map[string]float64{}}
+}
+
+func (p *promExporter) Write(ev LineEvent) error {
+	if ev.Dir != dirRX {
+		return nil
+	}
+	values := parseMetrics(ev.Text)
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.lines++
+	p.resets.observe(ev.Text, ev.Time)
+	for col, v := range values {
+		if f, err := strconv.ParseFloat(v, 64); err == nil {
+			p.values[col] = f
+		}
+	}
+	return nil
+}
+
+// ServeHTTP writes the current values. Gauges appear once the device has
+// reported them.
+func (p *promExporter) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodGet && req.Method != http.MethodHead {
+		w.Header().Set("Allow", "GET, HEAD")
+		http.Error(w, "read-only endpoint", http.StatusMethodNotAllowed)
+		return
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	label := fmt.Sprintf("{port=%q}", p.port)
+	var b strings.Builder
+	metric := func(name, kind, help string, v float64) {
+		fmt.Fprintf(&b, "# HELP %s %s\n# TYPE %s %s\n%s%s %s\n", name, help, name, kind, name, label, strconv.FormatFloat(v, 'g', -1, 64))
+	}
+	for _, g := range promGauges {
+		if v, ok := p.values[g.column]; ok {
+			metric(g.name, "gauge", g.help, v)
+		}
+	}
+	metric("sumi_lines_total", "counter", "Lines received from the device.", float64(p.lines))
+	metric("sumi_resets_total", "counter", "Device resets announced by the ROM.", float64(p.resets.total()))
+	metric("sumi_brownout_resets_total", "counter", "Resets caused by the brownout detector.", float64(p.resets.counts["brownout"]))
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	w.Write([]byte(b.String()))
+}
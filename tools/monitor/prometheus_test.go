@@ -0,0 +1,56 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestPromExporter(t *testing.T) {
+	p := newPromExporter("/dev/ttyACM0")
+	for _, text := range []string{
+		"rst:0xf (BROWNOUT_RST),boot:0xc (SPI_FAST_FLASH_BOOT)",
+		"[10012] [MEM] Free: 151234 bytes, Total: 327680 bytes, Min Free: 120000 bytes",
+		"[10013] [BAT] Battery: 3987 mV, 82%",
+		"[10900]   waitForRefresh: waited 380 ms",
+		"[20012] [MEM] Free: 150000 bytes, Total: 327680 bytes, Min Free: 119000 bytes",
+	} {
+		p.Write(LineEvent{Text: text})
+	}
+	rec := httptest.NewRecorder()
+	p.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	want := `# HELP sumi_heap_free_bytes Free heap last reported by the device.
+# TYPE sumi_heap_free_bytes gauge
+sumi_heap_free_bytes{port="/dev/ttyACM0"} 150000
+# HELP sumi_heap_min_free_bytes Lowest free heap since boot.
+# TYPE sumi_heap_min_free_bytes gauge
+sumi_heap_min_free_bytes{port="/dev/ttyACM0"} 119000
+# HELP sumi_refresh_wait_milliseconds Time the last display refresh kept the renderer waiting.
+# TYPE sumi_refresh_wait_milliseconds gauge
+sumi_refresh_wait_milliseconds{port="/dev/ttyACM0"} 380
+# HELP sumi_battery_millivolts Battery voltage.
+# TYPE sumi_battery_millivolts gauge
+sumi_battery_millivolts{port="/dev/ttyACM0"} 3987
+# HELP sumi_battery_percent Battery charge estimated from the voltage.
+# TYPE sumi_battery_percent gauge
+sumi_battery_percent{port="/dev/ttyACM0"} 82
+# HELP sumi_lines_total Lines received from the device.
+# TYPE sumi_lines_total counter
+sumi_lines_total{port="/dev/ttyACM0"} 5
+# HELP sumi_resets_total Device resets announced by the ROM.
+# TYPE sumi_resets_total counter
+sumi_resets_total{port="/dev/ttyACM0"} 1
+# HELP sumi_brownout_resets_total Resets caused by the brownout detector.
+# TYPE sumi_brownout_resets_total counter
+sumi_brownout_resets_total{port="/dev/ttyACM0"} 1
+`
+	if got := rec.Body.String(); got != want {
+		t.Errorf("got\n%s\nwant\n%s", got, want)
+	}
+
+	rec = httptest.NewRecorder()
+	p.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/metrics", nil))
+	if rec.Code != http.StatusMethodNotAllowed {
+		t.Errorf("POST status %d", rec.Code)
+	}
+}
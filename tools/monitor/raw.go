@@ -0,0 +1,14 @@
+package main
+
+import "io"
+
+// rawTap copies every byte read from src to w, the -raw capture file, or
+// returns src as it is when w is nil. It goes first in the reader chain, so
+// w holds exactly what the port delivered, NULs, CRs and partial lines
+// included, before anything is decoded, split into lines or filtered.
+func rawTap(src io.Reader, w io.Writer) io.Reader {
+	if w == nil {
+		return src
+	}
+	return io.TeeReader(src, w)
+}
@@ -0,0 +1,33 @@
+package main
+
+import (
+	"bytes"
+	"regexp"
+	"testing"
+)
+
+func TestRawTap_BeforeLinesAndFilters(t *testing.T) {
+	stream := []byte("\x00ets Jun  8 2016\r\n[DBG] heap 81234\n\xf0\x8f\x1b\n[BOOT] ok\r\npartial")
+	var raw bytes.Buffer
+	lines := newLineReader(rawTap(bytes.NewReader(stream), &raw), 0)
+	keep := matchFilter(nil, []*regexp.Regexp{regexp.MustCompile(`^\[DBG\]`)})
+	var shown []string
+	for lines.Scan() {
+		if ev := (LineEvent{Text: lines.Text()}); keep(ev) {
+			shown = append(shown, ev.Text)
+		}
+	}
+	if len(shown) != 4 {
+		t.Errorf("shown %q, want the DBG line filtered out", shown)
+	}
+	if !bytes.Equal(raw.Bytes(), stream) {
+		t.Errorf("raw capture %q, want %q", raw.Bytes(), stream)
+	}
+}
+
+func TestRawTap_Off(t *testing.T) {
+	src := bytes.NewReader(nil)
+	if rawTap(src, nil) != src {
+		t.Error("rawTap without a file should leave the reader alone")
+	}
+}
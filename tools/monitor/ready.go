@@ -0,0 +1,30 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+)
+
+// signalReady writes the readiness handshake: a single "READY <port>" line.
+func signalReady(w io.Writer, port string) error {
+	_, err := fmt.Fprintf(w, "READY %s\n", port)
+	return err
+}
+
+// signalReadyFd sends the handshake on file descriptor fd, inherited from the
+// parent process. Descriptors other than stdout and stderr are closed afterwards
+// so the parent sees EOF as well as the line.
+func signalReadyFd(fd int, port string) error {
+	f := os.NewFile(uintptr(fd), fmt.Sprintf("ready-fd-%d", fd))
+	if f == nil {
+		return fmt.Errorf("invalid descriptor %d", fd)
+	}
+	err := signalReady(f, port)
+	if fd > 2 {
+		if cerr := f.Close(); err == nil {
+			err = cerr
+		}
+	}
+	return err
+}
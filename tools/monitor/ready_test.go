@@ -0,0 +1,16 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestSignalReady(t *testing.T) {
+	var buf bytes.Buffer
+	if err := signalReady(&buf, "/dev/ttyACM0"); err != nil {
+		t.Fatal(err)
+	}
+	if buf.String() != "READY /dev/ttyACM0\n" {
+		t.Errorf("got %q", buf.String())
+	}
+}
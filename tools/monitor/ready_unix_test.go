@@ -0,0 +1,37 @@
+//go:build unix
+
+package main
+
+import (
+	"io"
+	"os"
+	"syscall"
+	"testing"
+)
+
+func TestSignalReadyFd(t *testing.T) {
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	// signalReadyFd closes the descriptor it is given, so hand it a duplicate:
+	// w's finalizer would otherwise close the number again once it has been
+	// reused by another file or socket.
+	fd, err := syscall.Dup(int(w.Fd()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.Close()
+	if err := signalReadyFd(fd, "COM3"); err != nil {
+		t.Fatal(err)
+	}
+	// The write end is closed by signalReadyFd, so ReadAll sees EOF.
+	data, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != "READY COM3\n" {
+		t.Errorf("got %q", data)
+	}
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"sync"
+	"time"
+)
+
+// reconnectPoll is how often -reconnect tries to reopen a lost port.
+const reconnectPoll = 500 * time.Millisecond
+
+// errDisconnected is returned by writes while the port is gone.
+var errDisconnected = errors.New("port disconnected")
+
+// reconnectingConn keeps a session alive across device resets and unplugs.
+// When a read fails it closes the connection, calls open every poll until the
+// port is back, and resumes reading from the new connection. Only Close ends it.
+type reconnectingConn struct {
+	open func() (io.ReadWriteCloser, string, error)
+	poll time.Duration
+	lost func(err error)   // called when a read fails
+	back func(name string) // called once a new connection is open
+
+	mu     sync.Mutex
+	conn   io.ReadWriteCloser // nil while disconnected
+	closed bool
+	done   chan struct{} // closed by Close
+}
+
+func newReconnectingConn(conn io.ReadWriteCloser, open func() (io.ReadWriteCloser, string, error)) *reconnectingConn {
+	return &reconnectingConn{open: open, poll: reconnectPoll, conn: conn, done: make(chan struct{})}
+}
+
+func (r *reconnectingConn) Read(p []byte) (int, error) {
+	for {
+		r.mu.Lock()
+		conn, closed := r.conn, r.closed
+		r.mu.Unlock()
+		if closed {
+			return 0, io.EOF
+		}
+		n, err := conn.Read(p)
+		if err == nil || n > 0 {
+			return n, nil
+		}
+		r.mu.Lock()
+		if r.closed {
+			r.mu.Unlock()
+			return 0, err
+		}
+		r.conn = nil
+		r.mu.Unlock()
+		conn.Close()
+		if r.lost != nil {
+			r.lost(err)
+		}
+		if !r.reopen() {
+			return 0, err
+		}
+	}
+}
+
+// reopen polls until the port can be opened again, or reports false once the
+// connection has been closed.
+func (r *reconnectingConn) reopen() bool {
+	for {
+		select {
+		case <-r.done:
+			return false
+		case <-time.After(r.poll):
+		}
+		conn, name, err := r.open()
+		if err != nil {
+			continue
+		}
+		r.mu.Lock()
+		if r.closed {
+			r.mu.Unlock()
+			conn.Close()
+			return false
+		}
+		r.conn = conn
+		r.mu.Unlock()
+		if r.back != nil {
+			r.back(name)
+		}
+		return true
+	}
+}
+
+func (r *reconnectingConn) Write(p []byte) (int, error) {
+	r.mu.Lock()
+	conn := r.conn
+	r.mu.Unlock()
+	if conn == nil {
+		return 0, errDisconnected
+	}
+	return conn.Write(p)
+}
+
+func (r *reconnectingConn) Close() error {
+	r.mu.Lock()
+	if r.closed {
+		r.mu.Unlock()
+		return nil
+	}
+	r.closed = true
+	close(r.done)
+	conn := r.conn
+	r.mu.Unlock()
+	if conn != nil {
+		return conn.Close()
+	}
+	return nil
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+	"time"
+)
+
+// scriptedConn yields its data and then fails, like a board that resets.
+type scriptedConn struct {
+	r      io.Reader
+	closed bool
+}
+
+func (c *scriptedConn) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	if err == io.EOF {
+		err = errors.New("device reset")
+	}
+	return n, err
+}
+func (c *scriptedConn) Write(p []byte) (int, error) { return len(p), nil }
+func (c *scriptedConn) Close() error                { c.closed = true; return nil }
+
+func TestReconnectingConn_ResumesAfterLoss(t *testing.T) {
+	first := &scriptedConn{r: strings.NewReader("[BOOT] Boot count: 1\n")}
+	second := &scriptedConn{r: strings.NewReader("[BOOT] Boot count: 2\n")}
+	attempts := 0
+	var rc *reconnectingConn
+	rc = newReconnectingConn(first, func() (io.ReadWriteCloser, string, error) {
+		attempts++
+		switch attempts {
+		case 1:
+			return nil, "", errors.New("no such file")
+		case 2:
+			return second, "/dev/ttyACM1", nil
+		}
+		rc.Close() // the second connection failed too; end the test
+		return nil, "", errors.New("gone")
+	})
+	rc.poll = time.Millisecond
+	var events []string
+	rc.lost = func(err error) { events = append(events, "lost: "+err.Error()) }
+	rc.back = func(name string) { events = append(events, "back: "+name) }
+
+	var lines []string
+	sc := bufio.NewScanner(rc)
+	for sc.Scan() {
+		lines = append(lines, sc.Text())
+	}
+	assertSliceEqual(t, lines, []string{"[BOOT] Boot count: 1", "[BOOT] Boot count: 2"})
+	assertSliceEqual(t, events, []string{"lost: device reset", "back: /dev/ttyACM1", "lost: device reset"})
+	if !first.closed || !second.closed {
+		t.Error("lost connections should be closed")
+	}
+}
+
+func TestReconnectingConn_CloseStopsWaiting(t *testing.T) {
+	rc := newReconnectingConn(&scriptedConn{r: strings.NewReader("")}, func() (io.ReadWriteCloser, string, error) {
+		return nil, "", errors.New("not yet")
+	})
+	rc.poll = time.Millisecond
+	done := make(chan error, 1)
+	go func() {
+		_, err := rc.Read(make([]byte, 16))
+		done <- err
+	}()
+	time.Sleep(20 * time.Millisecond)
+	if _, err := rc.Write([]byte("status\n")); err != errDisconnected {
+		t.Errorf("write while disconnected = %v, want errDisconnected", err)
+	}
+	rc.Close()
+	select {
+	case err := <-done:
+		if err == nil {
+			t.Error("expected the read to fail after Close")
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Read did not return after Close")
+	}
+}
@@ -0,0 +1,247 @@
+package main
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+)
+
+// parseRecordedLine recovers the device line and its time from one line of a
+// saved session: a -transcript record, a json, jsonl or csv log record, or
+// plain text, which carries no time. Lines the device did not send (TX
+// transcript records) are reported as not ok.
+func parseRecordedLine(s string) (line transcriptLine, ok bool) {
+	if stamp, rest, found := strings.Cut(s, " "); found {
+		if t, err := time.Parse(transcriptStamp, stamp); err == nil {
+			dir, text, _ := strings.Cut(rest, " ")
+			switch dir {
+			case "RX":
+				return transcriptLine{Time: t, Text: text}, true
+			case "TX":
+				return transcriptLine{}, false
+			}
+		}
+	}
+	if strings.HasPrefix(s, "{") {
+		var rec struct {
+			Time string  `json:"time"`
+			Line *string `json:"line"`
+			Tag  string  `json:"tag"`
+			Msg  *string `json:"msg"`
+		}
+		if json.Unmarshal([]byte(s), &rec) == nil {
+			t, _ := time.Parse(time.RFC3339Nano, rec.Time)
+			switch {
+			case rec.Line != nil:
+				return transcriptLine{Time: t, Text: *rec.Line}, true
+			case rec.Msg != nil && rec.Tag != "":
+				// jsonl keeps the parsed fields, not the raw line.
+				return transcriptLine{Time: t, Text: "[" + rec.Tag + "] " + *rec.Msg}, true
+			case rec.Msg != nil:
+				return transcriptLine{Time: t, Text: *rec.Msg}, true
+			}
+		}
+	}
+	if fields, err := csv.NewReader(strings.NewReader(s)).Read(); err == nil && len(fields) == 4 {
+		if t, err := time.Parse(time.RFC3339Nano, fields[0]); err == nil {
+			return transcriptLine{Time: t, Text: fields[3]}, true
+		}
+	}
+	return transcriptLine{Text: s}, true
+}
+
+// readReplay reads a saved session for replay: a -combined capture, or a file
+// of -log, -transcript or plain text lines.
+func readReplay(r io.Reader) ([]transcriptLine, error) {
+	br := bufio.NewReader(r)
+	if magic, _ := br.Peek(len(combinedMagic)); string(magic) == combinedMagic {
+		return readTranscript(br)
+	}
+	var lines []transcriptLine
+	sc := bufio.NewScanner(br)
+	sc.Buffer(nil, 1<<20)
+	for sc.Scan() {
+		if l, ok := parseRecordedLine(strings.TrimSuffix(sc.Text(), "\r")); ok {
+			lines = append(lines, l)
+		}
+	}
+	return lines, sc.Err()
+}
+
+// replayPause returns how long to wait before a line recorded at t when the
+// previous line was recorded at prev, played rate times faster and capped at
+// maxGap (0 for no cap). Lines without a time play immediately.
+func replayPause(prev, t time.Time, rate float64, maxGap time.Duration) time.Duration {
+	if prev.IsZero() || t.IsZero() || !t.After(prev) {
+		return 0
+	}
+	d := time.Duration(float64(t.Sub(prev)) / rate)
+	if maxGap > 0 && d > maxGap {
+		d = maxGap
+	}
+	return d
+}
+
+// replaySleep waits between replayed lines. Replaced in tests.
+var replaySleep = time.Sleep
+
+// runReplay implements the replay subcommand: it plays a saved session back
+// through the same filters, backtrace decoder and -on rules as a live port.
+func runReplay(args []string) int {
+	fs := flag.NewFlagSet("replay", flag.ContinueOnError)
+	realtimeFlag := fs.Bool("realtime", false, "wait between lines as long as the recording did")
+	rateFlag := fs.Float64("rate", 1, "with -realtime, play this many times faster than recorded")
+	maxGapFlag := fs.Duration("max-gap", 5*time.Second, "with -realtime, never wait longer than this between lines (0 for no cap)")
+	formatFlag := fs.String("stdout-format", "text", "output format: text, json, jsonl, csv or length-prefixed")
+	elfFlag := fs.String("elf", "", "firmware ELF with debug info; panic backtraces are decoded to function, file and line")
+	var includeFlag, excludeFlag, onFlag stringList
+	fs.Var(&includeFlag, "include", "show only lines matching this regex (repeatable; any may match)")
+	fs.Var(&excludeFlag, "exclude", "hide lines matching this regex (repeatable)")
+	fs.Var(&onFlag, "on", "act on lines matching a regex: \"<regexp>=>exit <status>\" or \"<regexp>=>run <command>\" (repeatable)")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: %s replay <session> [-realtime] [-rate n] [-include re] [-elf firmware.elf]\n", os.Args[0])
+		fs.PrintDefaults()
+	}
+	// Flags may come before or after the session path.
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if fs.NArg() < 1 {
+		fs.Usage()
+		return 2
+	}
+	path := fs.Arg(0)
+	if err := fs.Parse(fs.Args()[1:]); err != nil {
+		return 2
+	}
+	if fs.NArg() != 0 {
+		fs.Usage()
+		return 2
+	}
+	if *rateFlag <= 0 {
+		fmt.Fprintf(os.Stderr, "-rate must be positive\n")
+		return 2
+	}
+	format, err := lookupFormatter(*formatFlag)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "-stdout-format: %v\n", err)
+		return 2
+	}
+	include, err := compilePatterns("include", includeFlag)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		return 2
+	}
+	exclude, err := compilePatterns("exclude", excludeFlag)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		return 2
+	}
+	var triggers []trigger
+	for _, spec := range onFlag {
+		t, err := parseTrigger(spec)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%v\n", err)
+			return 2
+		}
+		triggers = append(triggers, t)
+	}
+	var syms *symbolizer
+	if *elfFlag != "" {
+		if syms, err = loadSymbolizer(*elfFlag); err != nil {
+			fmt.Fprintf(os.Stderr, "-elf: %v\n", err)
+			return 1
+		}
+	}
+
+	in, err := os.Open(path)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to open session: %v\n", err)
+		return 1
+	}
+	lines, err := readReplay(in)
+	in.Close()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Replay failed: %v\n", err)
+		return 1
+	}
+
+	stdout := bufio.NewWriter(os.Stdout)
+	defer stdout.Flush()
+	p := replayer{
+		out:      &writerSink{w: stdout, format: format, filter: matchFilter(include, exclude)},
+		flush:    stdout.Flush,
+		syms:     syms,
+		triggers: triggers,
+		port:     path,
+	}
+	if *realtimeFlag {
+		p.rate, p.maxGap = *rateFlag, *maxGapFlag
+		if !hasReplayTimes(lines) {
+			fmt.Fprintf(os.Stderr, "%s has no recorded times; playing without pauses\n", path)
+		}
+	}
+	return p.play(lines, os.Stderr)
+}
+
+// replayer plays recorded lines to a sink.
+type replayer struct {
+	out      Sink
+	flush    func() error
+	syms     *symbolizer // nil without -elf
+	triggers []trigger
+	port     string
+	rate     float64 // 0 plays as fast as possible
+	maxGap   time.Duration
+}
+
+// play sends every line through the sink and returns the exit status: that of
+// a matching -on exit rule, or 0 once the recording ends.
+func (p *replayer) play(lines []transcriptLine, stderr io.Writer) int {
+	var prev time.Time
+	var offset int64
+	for _, l := range lines {
+		if p.rate > 0 {
+			if d := replayPause(prev, l.Time, p.rate, p.maxGap); d > 0 {
+				p.flush()
+				replaySleep(d)
+			}
+		}
+		if !l.Time.IsZero() {
+			prev = l.Time
+		}
+		ev := LineEvent{Time: l.Time, Port: p.port, Offset: offset, Text: l.Text}
+		offset += int64(len(l.Text)) + 1
+		if err := p.out.Write(ev); err != nil {
+			fmt.Fprintf(stderr, "Write error: %v\n", err)
+			return 1
+		}
+		if p.syms != nil {
+			for _, pc := range crashAddresses(ev.Text) {
+				decoded := ev
+				decoded.Text = "  " + p.syms.decode(pc)
+				p.out.Write(decoded)
+			}
+		}
+		if code := fireTriggers(p.triggers, ev, stderr); code >= 0 {
+			return code
+		}
+	}
+	return 0
+}
+
+// hasReplayTimes reports whether any line carries a recorded time.
+func hasReplayTimes(lines []transcriptLine) bool {
+	for _, l := range lines {
+		if !l.Time.IsZero() {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestReadReplay_Formats(t *testing.T) {
+	session := strings.Join([]string{
+		"2026-03-01T09:30:15.250000Z TX locate",
+		"2026-03-01T09:30:15.262104Z RX [BLE] Identify",
+		`{"time":"2026-03-01T09:30:16Z","port":"/dev/ttyACM0","offset":0,"line":"[EPUB] open"}`,
+		`{"time":"2026-03-01T09:30:17Z","port":"/dev/ttyACM0","tag":"SD","msg":"mounted"}`,
+		`2026-03-01T09:30:18Z,/dev/ttyACM0,42,"[HOME] ready, 3 books"`,
+		"plain line\r",
+	}, "\n")
+	lines, err := readReplay(strings.NewReader(session))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var texts, times []string
+	for _, l := range lines {
+		texts = append(texts, l.Text)
+		times = append(times, l.Time.Format("15:04:05.000"))
+	}
+	assertSliceEqual(t, texts, []string{"[BLE] Identify", "[EPUB] open", "[SD] mounted", "[HOME] ready, 3 books", "plain line"})
+	assertSliceEqual(t, times, []string{"09:30:15.262", "09:30:16.000", "09:30:17.000", "09:30:18.000", "00:00:00.000"})
+}
+
+func TestReplayPause(t *testing.T) {
+	t0 := time.Date(2026, 3, 1, 0, 0, 0, 0, time.UTC)
+	tests := []struct {
+		prev, t time.Time
+		rate    float64
+		maxGap  time.Duration
+		want    time.Duration
+	}{
+		{t0, t0.Add(time.Second), 1, 5 * time.Second, time.Second},
+		{t0, t0.Add(time.Second), 4, 5 * time.Second, 250 * time.Millisecond},
+		{t0, t0.Add(time.Hour), 1, 5 * time.Second, 5 * time.Second},
+		{t0, t0.Add(time.Hour), 1, 0, time.Hour},
+		{time.Time{}, t0, 1, 0, 0},
+		{t0, time.Time{}, 1, 0, 0},
+		{t0.Add(time.Second), t0, 1, 0, 0},
+	}
+	for i, tt := range tests {
+		if got := replayPause(tt.prev, tt.t, tt.rate, tt.maxGap); got != tt.want {
+			t.Errorf("%d: got %v, want %v", i, got, tt.want)
+		}
+	}
+}
+
+func TestReplayer_PlaysFiltersAndTriggers(t *testing.T) {
+	var slept []time.Duration
+	old := replaySleep
+	replaySleep = func(d time.Duration) { slept = append(slept, d) }
+	defer func() { replaySleep = old }()
+
+	t0 := time.Date(2026, 3, 1, 0, 0, 0, 0, time.UTC)
+	lines := []transcriptLine{
+		{Time: t0, Text: "[BOOT] start"},
+		{Time: t0.Add(2 * time.Second), Text: "[BLE] adv"},
+		{Time: t0.Add(3 * time.Second), Text: "Guru Meditation Error"},
+		{Time: t0.Add(4 * time.Second), Text: "[BOOT] start"},
+	}
+	var out bytes.Buffer
+	guru, _ := parseTrigger("Guru=>exit 2")
+	p := replayer{
+		out:      &writerSink{w: &out, format: formatText, filter: func(ev LineEvent) bool { return !strings.HasPrefix(ev.Text, "[BLE]") }},
+		flush:    func() error { return nil },
+		triggers: []trigger{guru},
+		rate:     2,
+	}
+	if code := p.play(lines, &bytes.Buffer{}); code != 2 {
+		t.Errorf("exit status %d, want 2", code)
+	}
+	if out.String() != "[BOOT] start\nGuru Meditation Error\n" {
+		t.Errorf("got %q", out.String())
+	}
+	if len(slept) != 2 || slept[0] != time.Second || slept[1] != 500*time.Millisecond {
+		t.Errorf("slept %v", slept)
+	}
+}
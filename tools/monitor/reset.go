@@ -0,0 +1,108 @@
+package main
+
+import (
+	"io"
+	"strings"
+	"time"
+)
+
+// espressifJTAGPID is the product ID of the USB Serial/JTAG controller.
+const espressifJTAGPID = "1001"
+
+// modemLines is the part of serial.Port that drives DTR and RTS.
+type modemLines interface {
+	SetDTR(bool) error
+	SetRTS(bool) error
+}
+
+// lineStep sets one control line and then waits.
+type lineStep struct {
+	rts   bool // RTS rather than DTR
+	level bool
+	wait  time.Duration
+}
+
+func dtr(level bool, wait time.Duration) lineStep {
+	return lineStep{level: level, wait: wait}
+}
+
+func rts(level bool, wait time.Duration) lineStep {
+	return lineStep{rts: true, level: level, wait: wait}
+}
+
+// The sequences esptool uses. On a dev board's USB-UART bridge, RTS pulls EN
+// (reset) low and DTR pulls the boot strapping pin low through a transistor
+// pair; the ESP32-C3's built-in USB Serial/JTAG controller decodes the same
+// lines itself and needs the order below to latch download mode.
+var (
+	classicReset      = []lineStep{dtr(false, 0), rts(true, 100*time.Millisecond), rts(false, 0)}
+	classicBootloader = []lineStep{
+		dtr(false, 0), rts(true, 100*time.Millisecond),
+		dtr(true, 0), rts(false, 50*time.Millisecond),
+		dtr(false, 0),
+	}
+	usbJTAGReset      = []lineStep{dtr(false, 0), rts(true, 200*time.Millisecond), rts(false, 200*time.Millisecond)}
+	usbJTAGBootloader = []lineStep{
+		rts(false, 0), dtr(false, 100*time.Millisecond),
+		dtr(true, 0), rts(false, 100*time.Millisecond),
+		rts(true, 0), dtr(false, 0), rts(true, 100*time.Millisecond),
+		dtr(false, 0), rts(false, 0),
+	}
+)
+
+// resetSequences returns the reset and bootloader sequences for a port: the
+// USB Serial/JTAG ones for a board's built-in controller, otherwise the classic
+// ones for a USB-UART bridge or a network port.
+func resetSequences(name string) (reset, bootloader []lineStep, jtag bool) {
+	if details, err := detailedPorts(); err == nil {
+		for _, d := range details {
+			if d.name == name && d.usb && strings.EqualFold(d.vid, espressifVID) && strings.EqualFold(d.pid, espressifJTAGPID) {
+				return usbJTAGReset, usbJTAGBootloader, true
+			}
+		}
+	}
+	return classicReset, classicBootloader, false
+}
+
+// lineSleep waits between line changes. Replaced in tests.
+var lineSleep = time.Sleep
+
+// runLineSequence plays a reset sequence on the port's control lines.
+func runLineSequence(m modemLines, seq []lineStep) error {
+	for _, s := range seq {
+		set := m.SetDTR
+		if s.rts {
+			set = m.SetRTS
+		}
+		if err := set(s.level); err != nil {
+			return err
+		}
+		if s.wait > 0 {
+			lineSleep(s.wait)
+		}
+	}
+	return nil
+}
+
+// modemLinesOf finds the port under the wrappers the monitor puts around it,
+// following a reconnecting connection to whichever port is current. It
+// returns nil while disconnected or when the port has no control lines.
+func modemLinesOf(c io.ReadWriteCloser) modemLines {
+	switch v := c.(type) {
+	case modemLines:
+		return v
+	case *reconnectingConn:
+		v.mu.Lock()
+		inner := v.conn
+		v.mu.Unlock()
+		if inner == nil {
+			return nil
+		}
+		return modemLinesOf(inner)
+	case *faultConn:
+		return modemLinesOf(v.ReadWriteCloser)
+	case *txTap:
+		return modemLinesOf(v.ReadWriteCloser)
+	}
+	return nil
+}
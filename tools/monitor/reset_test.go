@@ -0,0 +1,68 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"testing"
+	"time"
+)
+
+// recordingLines logs each control line change and the waits between them.
+type recordingLines struct {
+	io.ReadWriteCloser
+	log *[]string
+}
+
+func (r recordingLines) SetDTR(level bool) error {
+	*r.log = append(*r.log, fmt.Sprintf("DTR=%v", level))
+	return nil
+}
+
+func (r recordingLines) SetRTS(level bool) error {
+	*r.log = append(*r.log, fmt.Sprintf("RTS=%v", level))
+	return nil
+}
+
+func TestRunLineSequence_ClassicBootloader(t *testing.T) {
+	var log []string
+	old := lineSleep
+	lineSleep = func(d time.Duration) { log = append(log, d.String()) }
+	defer func() { lineSleep = old }()
+
+	if err := runLineSequence(recordingLines{log: &log}, classicBootloader); err != nil {
+		t.Fatal(err)
+	}
+	assertSliceEqual(t, log, []string{"DTR=false", "RTS=true", "100ms", "DTR=true", "RTS=false", "50ms", "DTR=false"})
+}
+
+func TestResetSequences_PicksUSBJTAG(t *testing.T) {
+	stubDetailedPorts(t, []portDetail{
+		{name: "/dev/ttyACM0", usb: true, vid: "303a", pid: "1001"},
+		{name: "/dev/ttyUSB0", usb: true, vid: "10C4", pid: "EA60"},
+	}, nil)
+	if _, boot, jtag := resetSequences("/dev/ttyACM0"); !jtag || len(boot) != len(usbJTAGBootloader) {
+		t.Error("built-in USB Serial/JTAG port should use its own sequences")
+	}
+	if reset, _, jtag := resetSequences("/dev/ttyUSB0"); jtag || len(reset) != len(classicReset) {
+		t.Error("USB-UART bridge should use the classic sequences")
+	}
+}
+
+func TestModemLinesOf_UnwrapsConnections(t *testing.T) {
+	var log []string
+	port := recordingLines{ReadWriteCloser: pipeConn{}, log: &log}
+	rc := newReconnectingConn(newFaultConn(port, faultSpec{}), nil)
+	tap := &txTap{ReadWriteCloser: rc, sink: &transcriptSink{w: &bytes.Buffer{}}}
+	m := modemLinesOf(tap)
+	if m == nil {
+		t.Fatal("port not found under the wrappers")
+	}
+	m.SetRTS(true)
+	assertSliceEqual(t, log, []string{"RTS=true"})
+
+	rc.conn = nil
+	if modemLinesOf(tap) != nil {
+		t.Error("expected no control lines while disconnected")
+	}
+}
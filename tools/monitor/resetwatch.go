@@ -0,0 +1,121 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+)
+
+// resetReasonPattern matches the reset reason the ROM prints on every boot,
+// e.g. "rst:0xf (BROWNOUT_RST),boot:0x8 (SPI_FAST_FLASH_BOOT)".
+var resetReasonPattern = regexp.MustCompile(`rst:0x[0-9a-fA-F]+ \(([A-Z0-9_]+)\)`)
+
+// resetCauses are lines the firmware prints just before it resets itself,
+// which a software reset reason alone does not reveal.
+var resetCauses = []struct{ marker, cause string }{
+	{"Guru Meditation Error", "panic"},
+	{"abort() was called", "panic"},
+	{"Task watchdog got triggered", "watchdog"},
+	{"Interrupt wdt timeout", "watchdog"},
+	{"Brownout detector was triggered", "brownout"},
+}
+
+// classifyReset turns a ROM reset reason into brownout, watchdog, power-on,
+// deep sleep or software.
+func classifyReset(name string) string {
+	switch {
+	case strings.Contains(name, "BROWNOUT"):
+		return "brownout"
+	case strings.Contains(name, "WDT"):
+		return "watchdog"
+	case strings.Contains(name, "POWERON"):
+		return "power-on"
+	case strings.Contains(name, "SLEEP"):
+		return "deep sleep"
+	}
+	return "software"
+}
+
+// resetWatch counts device resets by cause and notices a boot loop: limit or
+// more resets within window.
+type resetWatch struct {
+	limit   int
+	window  time.Duration
+	pending string // cause announced since the last reset
+	recent  []resetEvent
+	counts  map[string]int
+}
+
+type resetEvent struct {
+	time  time.Time
+	cause string
+}
+
+// observe checks one line and, while the device is boot-looping, returns a
+// warning for every new reset.
+func (w *resetWatch) observe(text string, t time.Time) string {
+	for _, c := range resetCauses {
+		if strings.Contains(text, c.marker) {
+			w.pending = c.cause
+			return ""
+		}
+	}
+	m := resetReasonPattern.FindStringSubmatch(text)
+	if m == nil {
+		return ""
+	}
+	cause := classifyReset(m[1])
+	if cause == "software" && w.pending != "" {
+		cause = w.pending
+	}
+	w.pending = ""
+	if w.counts == nil {
+		w.counts = map[string]int{}
+	}
+	w.counts[cause]++
+	if w.limit <= 0 || w.window <= 0 {
+		return ""
+	}
+	w.recent = append(w.recent, resetEvent{t, cause})
+	for len(w.recent) > 0 && t.Sub(w.recent[0].time) > w.window {
+		w.recent = w.recent[1:]
+	}
+	if len(w.recent) < w.limit {
+		return ""
+	}
+	causes := map[string]int{}
+	for _, r := range w.recent {
+		causes[r.cause]++
+	}
+	return fmt.Sprintf("*** Boot loop: %d resets in the last %s (%s) ***", len(w.recent), w.window, describeCounts(causes))
+}
+
+// total returns the number of resets seen.
+func (w *resetWatch) total() int {
+	n := 0
+	for _, c := range w.counts {
+		n += c
+	}
+	return n
+}
+
+// describeCounts lists counts as "3 panic, 1 brownout", most frequent first.
+func describeCounts(counts map[string]int) string {
+	names := make([]string, 0, len(counts))
+	for name := range counts {
+		names = append(names, name)
+	}
+	sort.Slice(names, func(i, j int) bool {
+		if counts[names[i]] != counts[names[j]] {
+			return counts[names[i]] > counts[names[j]]
+		}
+		return names[i] < names[j]
+	})
+	parts := make([]string, len(names))
+	for i, name := range names {
+		parts[i] = fmt.Sprintf("%d %s", counts[name], name)
+	}
+	return strings.Join(parts, ", ")
+}
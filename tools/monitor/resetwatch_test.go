@@ -0,0 +1,50 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestClassifyReset(t *testing.T) {
+	for name, want := range map[string]string{
+		"BROWNOUT_RST":   "brownout",
+		"TG0WDT_SYS_RST": "watchdog",
+		"RTC_WDT_RST":    "watchdog",
+		"POWERON":        "power-on",
+		"DSLEEP":         "deep sleep",
+		"RTC_SW_CPU_RST": "software",
+	} {
+		if got := classifyReset(name); got != want {
+			t.Errorf("classifyReset(%q) = %q, want %q", name, got, want)
+		}
+	}
+}
+
+func TestResetWatch_BootLoop(t *testing.T) {
+	w := &resetWatch{limit: 3, window: time.Minute}
+	start := time.Date(2026, 3, 1, 9, 0, 0, 0, time.UTC)
+	var warnings []string
+	feed := func(sec int, lines ...string) {
+		for _, l := range lines {
+			if warn := w.observe(l, start.Add(time.Duration(sec)*time.Second)); warn != "" {
+				warnings = append(warnings, warn)
+			}
+		}
+	}
+	feed(0, "rst:0x1 (POWERON),boot:0xc (SPI_FAST_FLASH_BOOT)")
+	feed(5, "Guru Meditation Error: Core  0 panic'ed (Load access fault)", "rst:0x3 (RTC_SW_SYS_RST),boot:0xc (SPI_FAST_FLASH_BOOT)")
+	if len(warnings) != 0 {
+		t.Fatalf("two resets are not a loop: %v", warnings)
+	}
+	feed(10, "E (123) task_wdt: Task watchdog got triggered.", "rst:0x3 (RTC_SW_SYS_RST),boot:0xc (SPI_FAST_FLASH_BOOT)")
+	feed(15, "Guru Meditation Error: Core  0 panic'ed (Load access fault)", "rst:0x3 (RTC_SW_SYS_RST),boot:0xc (SPI_FAST_FLASH_BOOT)")
+	// Long after the loop, a single reset is quiet again.
+	feed(200, "rst:0x3 (RTC_SW_SYS_RST),boot:0xc (SPI_FAST_FLASH_BOOT)")
+	assertSliceEqual(t, warnings, []string{
+		"*** Boot loop: 3 resets in the last 1m0s (1 panic, 1 power-on, 1 watchdog) ***",
+		"*** Boot loop: 4 resets in the last 1m0s (2 panic, 1 power-on, 1 watchdog) ***",
+	})
+	if w.total() != 5 || describeCounts(w.counts) != "2 panic, 1 power-on, 1 software, 1 watchdog" {
+		t.Errorf("totals %d: %s", w.total(), describeCounts(w.counts))
+	}
+}
@@ -0,0 +1,43 @@
+package main
+
+import "sync"
+
+// lineRing keeps the most recent formatted lines in a fixed-size ring.
+type lineRing struct {
+	mu    sync.Mutex
+	lines []string
+	next  int
+	full  bool
+}
+
+func newLineRing(size int) *lineRing {
+	return &lineRing{lines: make([]string, size)}
+}
+
+// Write stores an event's text line, evicting the oldest when full.
+func (r *lineRing) Write(ev LineEvent) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.lines[r.next] = formatText(ev)
+	r.next = (r.next + 1) % len(r.lines)
+	if r.next == 0 {
+		r.full = true
+	}
+	return nil
+}
+
+// last returns up to n of the newest lines, oldest first.
+func (r *lineRing) last(n int) []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	count := r.next
+	if r.full {
+		count = len(r.lines)
+	}
+	n = min(n, count)
+	out := make([]string, n)
+	for i := 0; i < n; i++ {
+		out[i] = r.lines[(r.next-n+i+len(r.lines))%len(r.lines)]
+	}
+	return out
+}
@@ -0,0 +1,32 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+)
+
+func fillRing(r *lineRing, n int) {
+	for i := 0; i < n; i++ {
+		r.Write(LineEvent{Text: fmt.Sprint(i)})
+	}
+}
+
+func TestLineRing_NotFull(t *testing.T) {
+	r := newLineRing(5)
+	fillRing(r, 3)
+	assertSliceEqual(t, r.last(10), []string{"0\n", "1\n", "2\n"})
+	assertSliceEqual(t, r.last(2), []string{"1\n", "2\n"})
+}
+
+func TestLineRing_Wraps(t *testing.T) {
+	r := newLineRing(3)
+	fillRing(r, 7)
+	assertSliceEqual(t, r.last(3), []string{"4\n", "5\n", "6\n"})
+	assertSliceEqual(t, r.last(1), []string{"6\n"})
+}
+
+func TestLineRing_Empty(t *testing.T) {
+	if got := newLineRing(4).last(4); len(got) != 0 {
+		t.Errorf("got %v", got)
+	}
+}
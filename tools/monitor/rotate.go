@@ -0,0 +1,242 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// rotatedStamp names rotated logs, so monitor.log becomes
+// monitor-20260301-120000.log and names sort in rotation order.
+const rotatedStamp = "20060102-150405"
+
+// defaultLogName is the -log-name template for -log-dir.
+const defaultLogName = "{device}-{date}-{time}.log"
+
+var logNamePlaceholder = regexp.MustCompile(`\{[^}]*\}`)
+
+// sessionLogPath names a new session's log file in dir from template, whose
+// {device} is the device's USB serial number (the port name when it has
+// none), {port} the port name, and {date} and {time} when the session
+// started. A name already taken gets a numeric suffix, so a session never
+// appends to an earlier one.
+func sessionLogPath(dir, template, port, serial string, t time.Time) (string, error) {
+	if serial == "" {
+		serial = portLabel(port)
+	}
+	values := map[string]string{
+		"{device}": serial,
+		"{port}":   portLabel(port),
+		"{date}":   t.Format("2006-01-02"),
+		"{time}":   t.Format("150405"),
+	}
+	var bad string
+	name := logNamePlaceholder.ReplaceAllStringFunc(template, func(p string) string {
+		v, ok := values[p]
+		if !ok {
+			bad = p
+		}
+		// Serial numbers and network ports may hold characters that are
+		// not safe in a file name.
+		return strings.Map(func(r rune) rune {
+			if strings.ContainsRune(`/\:*?"<>|`, r) {
+				return '_'
+			}
+			return r
+		}, v)
+	})
+	if bad != "" {
+		return "", fmt.Errorf("unknown placeholder %s in %q (want {device}, {port}, {date} or {time})", bad, template)
+	}
+	path := filepath.Join(dir, name)
+	ext := filepath.Ext(path)
+	for i := 2; ; i++ {
+		if _, err := os.Stat(path); errors.Is(err, os.ErrNotExist) {
+			return path, nil
+		}
+		path = fmt.Sprintf("%s-%d%s", strings.TrimSuffix(filepath.Join(dir, name), ext), i, ext)
+	}
+}
+
+// logRotation configures -log rotation. Zero fields are unset.
+type logRotation struct {
+	maxSize int64         // rotate before a write would grow the file past this
+	maxAge  time.Duration // rotate once the file has been open this long
+	keep    int           // rotated files to retain; 0 keeps them all
+}
+
+// parseByteSize parses sizes such as "500K", "100M" or "2G" (powers of 1024);
+// a bare number is bytes.
+func parseByteSize(s string) (int64, error) {
+	mult := int64(1)
+	num := strings.ToUpper(strings.TrimSpace(s))
+	for _, u := range []struct {
+		suffix string
+		mult   int64
+	}{{"K", 1 << 10}, {"M", 1 << 20}, {"G", 1 << 30}} {
+		if strings.HasSuffix(num, u.suffix) {
+			num, mult = strings.TrimSuffix(num, u.suffix), u.mult
+			break
+		}
+	}
+	n, err := strconv.ParseInt(num, 10, 64)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("bad size %q (want a number with optional K, M or G)", s)
+	}
+	return n * mult, nil
+}
+
+// rotatingFile is an append-only log file that moves itself aside to a
+// timestamped name when it grows past maxSize or has been open for maxAge, then
+// deletes the oldest rotated files beyond keep. Sinks write one record per
+// call, so rotation never splits a line.
+type rotatingFile struct {
+	path   string
+	rot    logRotation
+	now    func() time.Time
+	f      *os.File
+	size   int64
+	opened time.Time
+	header string // written at the start of every new file, e.g. an HTML preamble
+}
+
+func openRotatingFile(path string, rot logRotation) (*rotatingFile, error) {
+	r := &rotatingFile{path: path, rot: rot, now: time.Now}
+	if err := r.open(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+func (r *rotatingFile) open() error {
+	f, err := os.OpenFile(r.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	r.f, r.size, r.opened = f, info.Size(), r.now()
+	return nil
+}
+
+func (r *rotatingFile) Write(p []byte) (int, error) {
+	if r.due(len(p)) {
+		if err := r.rotate(); err != nil {
+			return 0, err
+		}
+	}
+	if r.size == 0 && r.header != "" {
+		n, err := io.WriteString(r.f, r.header)
+		r.size += int64(n)
+		if err != nil {
+			return 0, err
+		}
+	}
+	n, err := r.f.Write(p)
+	r.size += int64(n)
+	return n, err
+}
+
+// due reports whether the file should rotate before a write of n bytes. An
+// empty file is never rotated, so a record larger than maxSize still lands.
+func (r *rotatingFile) due(n int) bool {
+	if r.size == 0 {
+		return false
+	}
+	if r.rot.maxSize > 0 && r.size+int64(n) > r.rot.maxSize {
+		return true
+	}
+	return r.rot.maxAge > 0 && r.now().Sub(r.opened) >= r.rot.maxAge
+}
+
+func (r *rotatingFile) rotate() error {
+	if err := r.f.Close(); err != nil {
+		return err
+	}
+	ext := filepath.Ext(r.path)
+	base := strings.TrimSuffix(r.path, ext) + "-" + r.now().Format(rotatedStamp)
+	name := base + ext
+	// Size rotation can outpace the one-second stamp.
+	for i := 1; ; i++ {
+		if _, err := os.Stat(name); errors.Is(err, os.ErrNotExist) {
+			break
+		}
+		name = fmt.Sprintf("%s.%d%s", base, i, ext)
+	}
+	if err := os.Rename(r.path, name); err != nil {
+		return err
+	}
+	if err := r.open(); err != nil {
+		return err
+	}
+	return r.prune()
+}
+
+// rotatedLogs returns the rotated files of path, oldest first.
+func rotatedLogs(path string) ([]string, error) {
+	ext := filepath.Ext(path)
+	stem := strings.TrimSuffix(filepath.Base(path), ext)
+	// Only stamped names, so per-port logs such as monitor-ttyACM0.log are
+	// never mistaken for rotated ones.
+	pattern := regexp.MustCompile("^" + regexp.QuoteMeta(stem) + `-(\d{8}-\d{6})(?:\.(\d+))?` + regexp.QuoteMeta(ext) + "$")
+	entries, err := os.ReadDir(filepath.Dir(path))
+	if err != nil {
+		return nil, err
+	}
+	type rotated struct {
+		name, stamp string
+		seq         int
+	}
+	var found []rotated
+	for _, e := range entries {
+		m := pattern.FindStringSubmatch(e.Name())
+		if m == nil {
+			continue
+		}
+		seq, _ := strconv.Atoi(m[2])
+		found = append(found, rotated{filepath.Join(filepath.Dir(path), e.Name()), m[1], seq})
+	}
+	sort.Slice(found, func(i, j int) bool {
+		if found[i].stamp != found[j].stamp {
+			return found[i].stamp < found[j].stamp
+		}
+		return found[i].seq < found[j].seq
+	})
+	names := make([]string, len(found))
+	for i, f := range found {
+		names[i] = f.name
+	}
+	return names, nil
+}
+
+// prune deletes the oldest rotated files beyond keep.
+func (r *rotatingFile) prune() error {
+	if r.rot.keep <= 0 {
+		return nil
+	}
+	old, err := rotatedLogs(r.path)
+	if err != nil {
+		return err
+	}
+	for len(old) > r.rot.keep {
+		if err := os.Remove(old[0]); err != nil {
+			return err
+		}
+		old = old[1:]
+	}
+	return nil
+}
+
+func (r *rotatingFile) Close() error {
+	return r.f.Close()
+}
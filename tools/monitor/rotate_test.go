@@ -0,0 +1,134 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestParseByteSize(t *testing.T) {
+	tests := []struct {
+		in   string
+		want int64
+	}{
+		{"512", 512},
+		{"500K", 500 << 10},
+		{"100m", 100 << 20},
+		{"2G", 2 << 30},
+	}
+	for _, tt := range tests {
+		if got, err := parseByteSize(tt.in); err != nil || got != tt.want {
+			t.Errorf("parseByteSize(%q) = %d, %v; want %d", tt.in, got, err, tt.want)
+		}
+	}
+	for _, bad := range []string{"", "M", "-1", "10T"} {
+		if _, err := parseByteSize(bad); err == nil {
+			t.Errorf("parseByteSize(%q): expected error", bad)
+		}
+	}
+}
+
+// fakeClock returns a time that the test advances by hand.
+func fakeClock(now *time.Time) func() time.Time {
+	return func() time.Time { return *now }
+}
+
+func TestRotatingFile_SizeRotationKeepsNewest(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "monitor.log")
+	now := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	r := &rotatingFile{path: path, rot: logRotation{maxSize: 10, keep: 2}, now: fakeClock(&now)}
+	if err := r.open(); err != nil {
+		t.Fatal(err)
+	}
+	// A per-port log must survive pruning.
+	os.WriteFile(filepath.Join(dir, "monitor-ttyACM0.log"), nil, 0644)
+	for _, line := range []string{"line one\n", "line two\n", "line three\n", "line four\n"} {
+		if _, err := r.Write([]byte(line)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	r.Close()
+	old, err := rotatedLogs(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{filepath.Join(dir, "monitor-20260301-120000.1.log"), filepath.Join(dir, "monitor-20260301-120000.2.log")}
+	assertSliceEqual(t, old, want)
+	if b, _ := os.ReadFile(old[1]); string(b) != "line three\n" {
+		t.Errorf("newest rotated file holds %q", b)
+	}
+	if b, _ := os.ReadFile(path); string(b) != "line four\n" {
+		t.Errorf("current file holds %q", b)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "monitor-ttyACM0.log")); err != nil {
+		t.Error("per-port log was pruned")
+	}
+}
+
+func TestRotatingFile_AgeRotation(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "monitor.log")
+	now := time.Date(2026, 3, 1, 23, 0, 0, 0, time.UTC)
+	r := &rotatingFile{path: path, rot: logRotation{maxAge: time.Hour}, now: fakeClock(&now)}
+	if err := r.open(); err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	r.Write([]byte("before midnight\n"))
+	now = now.Add(59 * time.Minute)
+	r.Write([]byte("still the first file\n"))
+	now = now.Add(time.Minute)
+	r.Write([]byte("after midnight\n"))
+	old, _ := rotatedLogs(path)
+	assertSliceEqual(t, old, []string{filepath.Join(filepath.Dir(path), "monitor-20260302-000000.log")})
+	if b, _ := os.ReadFile(old[0]); string(b) != "before midnight\nstill the first file\n" {
+		t.Errorf("rotated file holds %q", b)
+	}
+}
+
+func TestRotatingFile_HeaderStartsEachFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "monitor.html")
+	now := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	r := &rotatingFile{path: path, rot: logRotation{maxAge: time.Hour}, now: fakeClock(&now), header: "<pre>\n"}
+	if err := r.open(); err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	r.Write([]byte("one\n"))
+	now = now.Add(time.Hour)
+	r.Write([]byte("two\n"))
+	old, _ := rotatedLogs(path)
+	if len(old) != 1 {
+		t.Fatalf("rotated %v", old)
+	}
+	if b, _ := os.ReadFile(old[0]); string(b) != "<pre>\none\n" {
+		t.Errorf("rotated file holds %q", b)
+	}
+	if b, _ := os.ReadFile(path); string(b) != "<pre>\ntwo\n" {
+		t.Errorf("new file holds %q", b)
+	}
+}
+
+func TestSessionLogPath(t *testing.T) {
+	dir := t.TempDir()
+	at := time.Date(2026, 3, 1, 9, 5, 7, 0, time.UTC)
+	path, err := sessionLogPath(dir, defaultLogName, "/dev/ttyACM0", "7C:DF:A1:B2", at)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := filepath.Join(dir, "7C_DF_A1_B2-2026-03-01-090507.log"); path != want {
+		t.Errorf("got %s, want %s", path, want)
+	}
+	// A second session in the same second gets its own file.
+	os.WriteFile(path, nil, 0644)
+	if path, _ = sessionLogPath(dir, defaultLogName, "/dev/ttyACM0", "7C:DF:A1:B2", at); path != filepath.Join(dir, "7C_DF_A1_B2-2026-03-01-090507-2.log") {
+		t.Errorf("second session got %s", path)
+	}
+	if path, _ = sessionLogPath(dir, "{port}_{date}.txt", "/dev/ttyACM0", "", at); path != filepath.Join(dir, "ttyACM0_2026-03-01.txt") {
+		t.Errorf("port template got %s", path)
+	}
+	if _, err := sessionLogPath(dir, "{board}.log", "/dev/ttyACM0", "", at); err == nil {
+		t.Error("unknown placeholder: expected error")
+	}
+}
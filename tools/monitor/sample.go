@@ -0,0 +1,15 @@
+package main
+
+// sampleFilter thins a sink to the first of every n events that pass prev, so
+// sampling counts lines after filtering. prev may be nil. Detectors such as the
+// boot watch run before the sinks and still see every line.
+func sampleFilter(prev func(LineEvent) bool, n int) func(LineEvent) bool {
+	seen := 0
+	return func(ev LineEvent) bool {
+		if prev != nil && !prev(ev) {
+			return false
+		}
+		seen++
+		return (seen-1)%n == 0
+	}
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSampleFilter_EveryNth(t *testing.T) {
+	keep := sampleFilter(nil, 3)
+	var kept []int
+	for i := 0; i < 10; i++ {
+		if keep(LineEvent{}) {
+			kept = append(kept, i)
+		}
+	}
+	if fmt.Sprint(kept) != "[0 3 6 9]" {
+		t.Errorf("kept %v", kept)
+	}
+}
+
+func TestSampleFilter_CountsAfterFilter(t *testing.T) {
+	isHeap := func(ev LineEvent) bool { return strings.HasPrefix(ev.Text, "[HP]") }
+	var buf bytes.Buffer
+	sink := &writerSink{w: &buf, format: formatText, filter: sampleFilter(isHeap, 2)}
+	for _, l := range []string{"[HP] 1", "[EPUB] x", "[HP] 2", "[EPUB] y", "[HP] 3", "[HP] 4", "[HP] 5"} {
+		sink.Write(LineEvent{Text: l})
+	}
+	if buf.String() != "[HP] 1\n[HP] 3\n[HP] 5\n" {
+		t.Errorf("got %q", buf.String())
+	}
+}
+
+func TestSampleFilter_DetectorsSeeEveryLine(t *testing.T) {
+	// The read loop feeds detectors before fanning out to sampled sinks.
+	w := &bootWatch{banner: regexp.MustCompile(defaultBootBanner), window: time.Second}
+	var buf bytes.Buffer
+	sinks := []Sink{&writerSink{w: &buf, format: formatText, filter: sampleFilter(nil, 10)}}
+	t0 := time.Unix(0, 0)
+	for i, l := range []string{"[HP] heap", "Starting SUMI version 0.6.4"} {
+		ev := LineEvent{Time: t0.Add(time.Duration(i) * time.Millisecond), Text: l}
+		w.observe(ev.Text, ev.Time)
+		sinks = fanOut(sinks, ev)
+	}
+	if buf.String() != "[HP] heap\n" {
+		t.Errorf("sink got %q", buf.String())
+	}
+	if !w.hung(t0.Add(2 * time.Second)) {
+		t.Error("the banner was sampled out but must still arm the boot watch")
+	}
+}
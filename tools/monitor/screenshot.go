@@ -0,0 +1,147 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"hash/crc32"
+	"image"
+	"image/png"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// The firmware sends its framebuffer as APC escape records (ESC _ ... ESC \),
+// one per line, which terminals ignore:
+//
+//	ESC_SUMIFB begin w=480 h=800 bpp=1 size=48000 ESC\
+//	ESC_SUMIFB data <base64> ESC\     (repeated)
+//	ESC_SUMIFB end crc=<crc32 of the pixels, hex> ESC\
+//
+// Pixels are portrait rows, top to bottom, MSB first, with 1 for white.
+// screenshotRequest asks the firmware for one (src/util/SerialScreenshot.h).
+const (
+	screenshotPrefix  = "\x1b_SUMIFB "
+	screenshotSuffix  = "\x1b\\"
+	screenshotRequest = screenshotPrefix + "shot" + screenshotSuffix
+)
+
+// screenshotCollector reassembles framebuffer dumps from the line stream and
+// saves each one as a PNG in dir.
+type screenshotCollector struct {
+	dir  string
+	now  func() time.Time
+	open bool // between begin and end
+	w, h int
+	size int
+	data []byte
+}
+
+// observe consumes a protocol line and returns true, with a notice to show in
+// its place once a screenshot is complete or has failed. Other lines are left
+// alone.
+func (c *screenshotCollector) observe(text string) (consumed bool, notice string) {
+	i := strings.Index(text, screenshotPrefix)
+	if i < 0 {
+		return false, ""
+	}
+	body := strings.TrimSuffix(strings.TrimSuffix(text[i+len(screenshotPrefix):], "\r"), screenshotSuffix)
+	verb, args, _ := strings.Cut(body, " ")
+	switch verb {
+	case "begin":
+		c.open, c.data = true, nil
+		c.w, c.h, c.size = 0, 0, 0
+		for _, kv := range strings.Fields(args) {
+			k, v, _ := strings.Cut(kv, "=")
+			n, _ := strconv.Atoi(v)
+			switch k {
+			case "w":
+				c.w = n
+			case "h":
+				c.h = n
+			case "size":
+				c.size = n
+			}
+		}
+		if c.w <= 0 || c.h <= 0 || c.size != (c.w+7)/8*c.h {
+			c.open = false
+			return true, fmt.Sprintf("[SCR] Screenshot dropped: bad header %q", args)
+		}
+	case "data":
+		if !c.open {
+			return true, ""
+		}
+		chunk, err := base64.StdEncoding.DecodeString(args)
+		if err != nil || len(c.data)+len(chunk) > c.size {
+			c.open = false
+			return true, "[SCR] Screenshot dropped: corrupt data record"
+		}
+		c.data = append(c.data, chunk...)
+	case "end":
+		if !c.open {
+			return true, ""
+		}
+		c.open = false
+		want, _ := strings.CutPrefix(args, "crc=")
+		if len(c.data) != c.size {
+			return true, fmt.Sprintf("[SCR] Screenshot dropped: got %d of %d bytes", len(c.data), c.size)
+		}
+		if fmt.Sprintf("%08x", crc32.ChecksumIEEE(c.data)) != strings.ToLower(want) {
+			return true, "[SCR] Screenshot dropped: checksum mismatch"
+		}
+		path, err := c.save()
+		if err != nil {
+			return true, fmt.Sprintf("[SCR] Screenshot not saved: %v", err)
+		}
+		return true, "[SCR] Screenshot saved to " + path
+	}
+	return true, ""
+}
+
+// save writes the collected pixels as a PNG named after the current time.
+func (c *screenshotCollector) save() (string, error) {
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, decodeFramebuffer(c.data, c.w, c.h)); err != nil {
+		return "", err
+	}
+	if err := os.MkdirAll(c.dir, 0o755); err != nil {
+		return "", err
+	}
+	now := time.Now
+	if c.now != nil {
+		now = c.now
+	}
+	path := stampedPath(c.dir, "sumi", ".png", now())
+	return path, os.WriteFile(path, buf.Bytes(), 0o644)
+}
+
+// stampedPath names a new file in dir after t, numbering it when a file of
+// that name already exists.
+func stampedPath(dir, prefix, ext string, t time.Time) string {
+	name := prefix + "-" + t.Format("20060102-150405")
+	path := filepath.Join(dir, name+ext)
+	for n := 1; ; n++ {
+		if _, err := os.Stat(path); os.IsNotExist(err) {
+			return path
+		}
+		path = filepath.Join(dir, fmt.Sprintf("%s.%d%s", name, n, ext))
+	}
+}
+
+// decodeFramebuffer turns 1-bit rows (MSB first, 1 = white) into a grayscale
+// image.
+func decodeFramebuffer(data []byte, w, h int) *image.Gray {
+	img := image.NewGray(image.Rect(0, 0, w, h))
+	stride := (w + 7) / 8
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			if data[y*stride+x/8]&(0x80>>(x%8)) != 0 {
+				img.Pix[y*img.Stride+x] = 0xff
+			}
+		}
+	}
+	return img
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"encoding/base64"
+	"fmt"
+	"hash/crc32"
+	"image/png"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+// screenshotRecords encodes pixels the way the firmware does.
+func screenshotRecords(w, h int, pixels []byte) []string {
+	lines := []string{fmt.Sprintf("\x1b_SUMIFB begin w=%d h=%d bpp=1 size=%d\x1b\\", w, h, len(pixels))}
+	for i := 0; i < len(pixels); i += 48 {
+		end := min(i+48, len(pixels))
+		lines = append(lines, "\x1b_SUMIFB data "+base64.StdEncoding.EncodeToString(pixels[i:end])+"\x1b\\")
+	}
+	return append(lines, fmt.Sprintf("\x1b_SUMIFB end crc=%08x\x1b\\", crc32.ChecksumIEEE(pixels)))
+}
+
+func TestScreenshotCollector(t *testing.T) {
+	dir := t.TempDir()
+	c := &screenshotCollector{dir: dir, now: func() time.Time { return time.Date(2026, 3, 1, 9, 30, 0, 0, time.Local) }}
+	// 10x100: two bytes per row, first pixel of each row white, rest black.
+	pixels := make([]byte, 2*100)
+	for y := 0; y < 100; y++ {
+		pixels[2*y] = 0x80
+	}
+	if consumed, _ := c.observe("[BOOT] ready"); consumed {
+		t.Error("ordinary line consumed")
+	}
+	var notices []string
+	for _, l := range screenshotRecords(10, 100, pixels) {
+		consumed, notice := c.observe(l + "\r")
+		if !consumed {
+			t.Fatalf("record %q not consumed", l)
+		}
+		if notice != "" {
+			notices = append(notices, notice)
+		}
+	}
+	path := dir + string(os.PathSeparator) + "sumi-20260301-093000.png"
+	assertSliceEqual(t, notices, []string{"[SCR] Screenshot saved to " + path})
+
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	img, err := png.Decode(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b := img.Bounds(); b.Dx() != 10 || b.Dy() != 100 {
+		t.Fatalf("bounds %v", b)
+	}
+	if r, _, _, _ := img.At(0, 50).RGBA(); r != 0xffff {
+		t.Errorf("pixel (0,50) should be white, got %#x", r)
+	}
+	if r, _, _, _ := img.At(9, 50).RGBA(); r != 0 {
+		t.Errorf("pixel (9,50) should be black, got %#x", r)
+	}
+
+	// A second shot in the same second gets a numbered name.
+	for _, l := range screenshotRecords(10, 100, pixels) {
+		_, notice := c.observe(l)
+		if notice != "" && !strings.HasSuffix(notice, "sumi-20260301-093000.1.png") {
+			t.Errorf("second notice %q", notice)
+		}
+	}
+}
+
+func TestScreenshotCollector_Corrupt(t *testing.T) {
+	c := &screenshotCollector{dir: t.TempDir()}
+	records := screenshotRecords(8, 2, []byte{0xff, 0x00})
+	records[len(records)-1] = "\x1b_SUMIFB end crc=00000000\x1b\\"
+	var notices []string
+	for _, l := range records {
+		if _, notice := c.observe(l); notice != "" {
+			notices = append(notices, notice)
+		}
+	}
+	assertSliceEqual(t, notices, []string{"[SCR] Screenshot dropped: checksum mismatch"})
+
+	// Data without a begin record is swallowed silently.
+	if consumed, notice := c.observe("\x1b_SUMIFB data AAAA\x1b\\"); !consumed || notice != "" {
+		t.Errorf("stray data: %v %q", consumed, notice)
+	}
+	if _, notice := c.observe("\x1b_SUMIFB begin w=8 h=2 bpp=1 size=3\x1b\\"); !strings.Contains(notice, "bad header") {
+		t.Errorf("bad header notice %q", notice)
+	}
+}
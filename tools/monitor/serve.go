@@ -0,0 +1,153 @@
+package main
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/binary"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// streamClientBuffer is how many records a -serve client may fall behind
+// before it is dropped, so a stalled viewer never holds up the monitor.
+const streamClientBuffer = 256
+
+// streamSniffTimeout is how long -serve waits for an HTTP request before
+// treating a new connection as a raw TCP client such as nc.
+const streamSniffTimeout = 300 * time.Millisecond
+
+// websocketGUID is the fixed key suffix from RFC 6455.
+const websocketGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
+// streamHub re-broadcasts formatted records to every connected -serve client.
+type streamHub struct {
+	format formatter
+
+	mu      sync.Mutex
+	clients map[chan string]struct{}
+}
+
+func newStreamHub(format formatter) *streamHub {
+	return &streamHub{format: format, clients: map[chan string]struct{}{}}
+}
+
+// Write hands the record to each client without blocking; a client whose
+// buffer is full is disconnected.
+func (h *streamHub) Write(ev LineEvent) error {
+	rec := h.format(ev)
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for ch := range h.clients {
+		select {
+		case ch <- rec:
+		default:
+			delete(h.clients, ch)
+			close(ch)
+		}
+	}
+	return nil
+}
+
+func (h *streamHub) subscribe() chan string {
+	ch := make(chan string, streamClientBuffer)
+	h.mu.Lock()
+	h.clients[ch] = struct{}{}
+	h.mu.Unlock()
+	return ch
+}
+
+func (h *streamHub) unsubscribe(ch chan string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if _, ok := h.clients[ch]; ok {
+		delete(h.clients, ch)
+		close(ch)
+	}
+}
+
+// serve accepts clients on ln until it is closed. A connection that opens with
+// an HTTP GET gets a WebSocket stream when it asks to upgrade and a plain-text
+// response body otherwise; anything else gets the raw record stream.
+func (h *streamHub) serve(ln net.Listener) error {
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			return err
+		}
+		go h.handle(conn)
+	}
+}
+
+func (h *streamHub) handle(conn net.Conn) {
+	defer conn.Close()
+	br := bufio.NewReader(conn)
+	conn.SetReadDeadline(time.Now().Add(streamSniffTimeout))
+	peek, _ := br.Peek(4)
+	conn.SetReadDeadline(time.Time{})
+	send := func(rec string) error {
+		_, err := io.WriteString(conn, rec)
+		return err
+	}
+	if string(peek) == "GET " {
+		req, err := http.ReadRequest(br)
+		if err != nil {
+			return
+		}
+		if strings.EqualFold(req.Header.Get("Upgrade"), "websocket") {
+			key := req.Header.Get("Sec-WebSocket-Key")
+			if key == "" {
+				io.WriteString(conn, "HTTP/1.1 400 Bad Request\r\nConnection: close\r\n\r\n")
+				return
+			}
+			io.WriteString(conn, "HTTP/1.1 101 Switching Protocols\r\nUpgrade: websocket\r\nConnection: Upgrade\r\n"+
+				"Sec-WebSocket-Accept: "+websocketAccept(key)+"\r\n\r\n")
+			send = func(rec string) error {
+				_, err := conn.Write(websocketTextFrame(strings.TrimSuffix(rec, "\n")))
+				return err
+			}
+		} else {
+			io.WriteString(conn, "HTTP/1.1 200 OK\r\nContent-Type: text/plain; charset=utf-8\r\nCache-Control: no-store\r\nConnection: close\r\n\r\n")
+		}
+	}
+
+	ch := h.subscribe()
+	defer h.unsubscribe(ch)
+	// Clients only listen; reading until they hang up (or send a WebSocket
+	// close frame and then hang up) is how a quiet stream notices they left.
+	go func() {
+		io.Copy(io.Discard, br)
+		h.unsubscribe(ch)
+	}()
+	for rec := range ch {
+		if send(rec) != nil {
+			return
+		}
+	}
+}
+
+// websocketAccept computes the Sec-WebSocket-Accept value for a client key.
+func websocketAccept(key string) string {
+	sum := sha1.Sum([]byte(key + websocketGUID))
+	return base64.StdEncoding.EncodeToString(sum[:])
+}
+
+// websocketTextFrame builds one unmasked, unfragmented server text frame.
+func websocketTextFrame(text string) []byte {
+	frame := []byte{0x81}
+	switch n := len(text); {
+	case n < 126:
+		frame = append(frame, byte(n))
+	case n <= 0xFFFF:
+		frame = append(frame, 126)
+		frame = binary.BigEndian.AppendUint16(frame, uint16(n))
+	default:
+		frame = append(frame, 127)
+		frame = binary.BigEndian.AppendUint64(frame, uint64(n))
+	}
+	return append(frame, text...)
+}
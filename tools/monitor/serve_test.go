@@ -0,0 +1,115 @@
+package main
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+// startHub serves a text hub on a loopback port.
+func startHub(t *testing.T) (*streamHub, string) {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	hub := newStreamHub(formatText)
+	go hub.serve(ln)
+	return hub, ln.Addr().String()
+}
+
+// waitClients waits until the hub has n clients.
+func waitClients(t *testing.T, hub *streamHub, n int) {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for time.Now().Before(deadline) {
+		hub.mu.Lock()
+		got := len(hub.clients)
+		hub.mu.Unlock()
+		if got == n {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("hub never reached %d clients", n)
+}
+
+func TestStreamHub_RawTCP(t *testing.T) {
+	hub, addr := startHub(t)
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	waitClients(t, hub, 1)
+	hub.Write(LineEvent{Text: "[BLE] ready"})
+	line, err := bufio.NewReader(conn).ReadString('\n')
+	if err != nil || line != "[BLE] ready\n" {
+		t.Errorf("got %q, %v", line, err)
+	}
+	conn.Close()
+	waitClients(t, hub, 0)
+}
+
+func TestStreamHub_WebSocket(t *testing.T) {
+	hub, addr := startHub(t)
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	io.WriteString(conn, "GET /logs HTTP/1.1\r\nHost: sumi\r\nUpgrade: websocket\r\nConnection: Upgrade\r\n"+
+		"Sec-WebSocket-Key: dGhlIHNhbXBsZSBub25jZQ==\r\nSec-WebSocket-Version: 13\r\n\r\n")
+	br := bufio.NewReader(conn)
+	var head strings.Builder
+	for {
+		l, err := br.ReadString('\n')
+		if err != nil {
+			t.Fatal(err)
+		}
+		if l == "\r\n" {
+			break
+		}
+		head.WriteString(l)
+	}
+	// The accept value for this key is the worked example in RFC 6455.
+	if !strings.HasPrefix(head.String(), "HTTP/1.1 101") || !strings.Contains(head.String(), "Sec-WebSocket-Accept: s3pPLMBiTxaQ9kYGzzhZRbK+xOo=") {
+		t.Fatalf("handshake %q", head.String())
+	}
+	waitClients(t, hub, 1)
+	hub.Write(LineEvent{Text: "[EPUB] open"})
+	frame := make([]byte, 2+len("[EPUB] open"))
+	if _, err := io.ReadFull(br, frame); err != nil {
+		t.Fatal(err)
+	}
+	if string(frame) != "\x81\x0b[EPUB] open" {
+		t.Errorf("frame %q", frame)
+	}
+}
+
+func TestStreamHub_DropsStalledClient(t *testing.T) {
+	hub := newStreamHub(formatText)
+	ch := hub.subscribe()
+	for i := 0; i <= streamClientBuffer; i++ {
+		hub.Write(LineEvent{Text: "x"})
+	}
+	if len(hub.clients) != 0 {
+		t.Fatal("stalled client was not dropped")
+	}
+	for range ch {
+	}
+	hub.unsubscribe(ch) // already gone; must not panic
+}
+
+func TestWebsocketTextFrame_Lengths(t *testing.T) {
+	if f := websocketTextFrame(strings.Repeat("a", 200)); f[1] != 126 || f[2] != 0 || f[3] != 200 || len(f) != 204 {
+		t.Errorf("16-bit length header % x", f[:4])
+	}
+	if f := websocketTextFrame(strings.Repeat("a", 70000)); f[1] != 127 || len(f) != 10+70000 {
+		t.Errorf("64-bit length header % x", f[:10])
+	}
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"time"
+)
+
+// sessionStats keeps the figures for the end-of-session summary, so a long
+// soak test can be judged without reading its log.
+type sessionStats struct {
+	start  time.Time
+	lines  int
+	levels map[string]int // by ESP-IDF/Arduino level letter; "" for untagged lines
+	resets resetWatch
+	last   time.Time // time of the previous line
+	gap    time.Duration
+	gapEnd time.Time // time of the line that ended the largest gap
+}
+
+func newSessionStats(start time.Time) *sessionStats {
+	return &sessionStats{start: start, levels: map[string]int{}}
+}
+
+// observe counts one received line and returns the reset watch's boot-loop
+// warning, if any.
+func (s *sessionStats) observe(text string, t time.Time) string {
+	s.lines++
+	level := ""
+	if m := logLevelPattern.FindStringSubmatch(text); m != nil {
+		level = m[1] + m[2]
+	}
+	s.levels[level]++
+	// The gap before the first line is time to connect, not silence.
+	if !s.last.IsZero() {
+		if d := t.Sub(s.last); d > s.gap {
+			s.gap, s.gapEnd = d, t
+		}
+	}
+	s.last = t
+	return s.resets.observe(text, t)
+}
+
+// report writes the session lines of the exit summary.
+func (s *sessionStats) report(w io.Writer, end time.Time) {
+	fmt.Fprintf(w, "Session: %s, %d line(s): %d error, %d warning, %d info, %d debug, %d verbose, %d untagged\n",
+		end.Sub(s.start).Round(time.Second), s.lines,
+		s.levels["E"], s.levels["W"], s.levels["I"], s.levels["D"], s.levels["V"], s.levels[""])
+	fmt.Fprintf(w, "Resets: %d", s.resets.total())
+	if len(s.resets.counts) > 0 {
+		fmt.Fprintf(w, " (%s)", describeCounts(s.resets.counts))
+	}
+	if s.gap > 0 {
+		fmt.Fprintf(w, "; largest gap in output %s, ending at %s", s.gap.Round(time.Millisecond), s.gapEnd.Format("15:04:05"))
+	}
+	fmt.Fprintln(w)
+}
@@ -0,0 +1,34 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+	"time"
+)
+
+func TestSessionStats(t *testing.T) {
+	start := time.Date(2026, 3, 1, 9, 0, 0, 0, time.UTC)
+	s := newSessionStats(start)
+	at := func(sec float64) time.Time { return start.Add(time.Duration(sec * float64(time.Second))) }
+	s.observe("ESP-ROM:esp32c3-api1-20210207", at(5))
+	s.observe("rst:0x1 (POWERON),boot:0xc (SPI_FAST_FLASH_BOOT)", at(5))
+	s.observe("E (1234) wifi: timeout", at(6))
+	s.observe("[  2000][W][sd.cpp:12] slow card", at(7))
+	s.observe("I (3000) boot: ready", at(48.5))
+	s.observe("Brownout detector was triggered", at(50))
+	s.observe("rst:0xf (BROWNOUT_RST),boot:0xc (SPI_FAST_FLASH_BOOT)", at(51))
+
+	var out bytes.Buffer
+	s.report(&out, start.Add(2*time.Hour+3*time.Minute))
+	want := "Session: 2h3m0s, 7 line(s): 1 error, 1 warning, 1 info, 0 debug, 0 verbose, 4 untagged\n" +
+		"Resets: 2 (1 brownout, 1 power-on); largest gap in output 41.5s, ending at 09:00:48\n"
+	if out.String() != want {
+		t.Errorf("report\n%q\nwant\n%q", out.String(), want)
+	}
+
+	out.Reset()
+	newSessionStats(start).report(&out, start.Add(time.Second))
+	if want := "Session: 1s, 0 line(s): 0 error, 0 warning, 0 info, 0 debug, 0 verbose, 0 untagged\nResets: 0\n"; out.String() != want {
+		t.Errorf("empty report %q", out.String())
+	}
+}
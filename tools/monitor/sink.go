@@ -0,0 +1,268 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"html"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+	"unicode/utf8"
+)
+
+// LineEvent is one line read from the device. The read loop builds exactly one
+// per line and every sink formats it independently.
+type LineEvent struct {
+	Time   time.Time
+	Port   string
+	Offset int64  // raw-stream byte offset where the line started
+	Prefix string // rendered text prefix from the enabled prefixers
+	Text   string
+	Dir    direction // dirRX for device output, dirTX for bytes sent to it
+}
+
+// Sink consumes line events for one destination (stdout, log file, ...).
+type Sink interface {
+	Write(LineEvent) error
+}
+
+// formatter renders a LineEvent as a complete record, terminator included.
+type formatter func(LineEvent) string
+
+// formatters maps -stdout-format and -log-format names to their formatter.
+var formatters = map[string]formatter{
+	"text":            formatText,
+	"json":            formatJSON,
+	"jsonl":           formatJSONL,
+	"csv":             formatCSV,
+	"length-prefixed": formatFramed,
+}
+
+// lookupFormatter returns the named formatter or an error listing the choices.
+func lookupFormatter(name string) (formatter, error) {
+	if f, ok := formatters[name]; ok {
+		return f, nil
+	}
+	names := make([]string, 0, len(formatters))
+	for n := range formatters {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+	return nil, fmt.Errorf("unknown format %q (want %s)", name, strings.Join(names, ", "))
+}
+
+func formatText(ev LineEvent) string {
+	return ev.Prefix + ev.Text + "\n"
+}
+
+func formatJSON(ev LineEvent) string {
+	b, _ := json.Marshal(struct {
+		Time   string `json:"time"`
+		Port   string `json:"port"`
+		Offset int64  `json:"offset"`
+		Line   string `json:"line"`
+	}{ev.Time.Format(time.RFC3339Nano), ev.Port, ev.Offset, ev.Text})
+	return string(b) + "\n"
+}
+
+// formatJSONL parses the line as a firmware log record. ts is the device's
+// milliseconds since boot and is omitted, like level and tag, when the line
+// does not carry it.
+func formatJSONL(ev LineEvent) string {
+	rec := parseLogLine(ev.Text)
+	out := struct {
+		Time  string `json:"time"`
+		Port  string `json:"port"`
+		TS    *int64 `json:"ts,omitempty"`
+		Level string `json:"level,omitempty"`
+		Tag   string `json:"tag,omitempty"`
+		Msg   string `json:"msg"`
+	}{Time: ev.Time.Format(time.RFC3339Nano), Port: ev.Port, Level: rec.level, Tag: rec.tag, Msg: rec.msg}
+	if rec.hasTS {
+		out.TS = &rec.ts
+	}
+	b, _ := json.Marshal(out)
+	return string(b) + "\n"
+}
+
+func formatCSV(ev LineEvent) string {
+	var buf bytes.Buffer
+	w := csv.NewWriter(&buf)
+	w.Write([]string{ev.Time.Format(time.RFC3339Nano), ev.Port, strconv.FormatInt(ev.Offset, 10), ev.Text})
+	w.Flush()
+	return buf.String()
+}
+
+func formatFramed(ev LineEvent) string {
+	var buf bytes.Buffer
+	writeFrame(&buf, ev.Prefix+ev.Text)
+	return buf.String()
+}
+
+// resolveLogANSI resolves -log-ansi for a log file: auto writes HTML for a
+// .html path and keeps escape sequences otherwise.
+func resolveLogANSI(mode, path string) (string, error) {
+	switch mode {
+	case "auto":
+		if strings.EqualFold(filepath.Ext(path), ".html") {
+			return "html", nil
+		}
+		return "keep", nil
+	case "keep", "strip", "html":
+		return mode, nil
+	}
+	return "", fmt.Errorf("unknown mode %q (want auto, keep, strip or html)", mode)
+}
+
+// stripped wraps a formatter to remove ANSI escape sequences from the line.
+func stripped(f formatter) formatter {
+	return func(ev LineEvent) string {
+		ev.Text = stripANSI(ev.Text)
+		return f(ev)
+	}
+}
+
+// htmlFormatter renders lines as HTML with the device's colors, for a log
+// file opened with htmlTranscriptHead. The color state runs across lines, as
+// on a terminal.
+func htmlFormatter() formatter {
+	var state sgrState
+	return func(ev LineEvent) string {
+		prefix := ""
+		if ev.Prefix != "" {
+			prefix = `<span class="t">` + html.EscapeString(ev.Prefix) + "</span>"
+		}
+		return prefix + state.html(ev.Text, false) + "\n"
+	}
+}
+
+// writerSink formats events onto an io.Writer, skipping events its filter rejects.
+type writerSink struct {
+	w      io.Writer
+	format formatter
+	filter func(LineEvent) bool // nil accepts every event
+}
+
+func (s *writerSink) Write(ev LineEvent) error {
+	if s.filter != nil && !s.filter(ev) {
+		return nil
+	}
+	_, err := io.WriteString(s.w, s.format(ev))
+	return err
+}
+
+// terminalSink writes plain text to a terminal, applying the terminal-only
+// -stamp-wrap and -status-block presentation.
+type terminalSink struct {
+	out       io.Writer
+	file      *os.File             // the terminal behind out, for size queries
+	filter    func(LineEvent) bool // nil accepts every event
+	tty       bool
+	color     bool          // color error and warning lines
+	hex       bool          // dump binary lines as hex rows (-hex)
+	wrapWidth *atomic.Int32 // nil unless -stamp-wrap is active
+	block     *statusBlock  // nil unless -status-block is set
+
+	// blockRows is the height of the status block at the bottom of the
+	// terminal, or 0 once other output has scrolled past it.
+	blockRows int
+}
+
+func (s *terminalSink) Write(ev LineEvent) error {
+	if s.filter != nil && !s.filter(ev) {
+		return nil
+	}
+	if s.hex && binaryLine(ev.Text) {
+		for _, e := range hexEvents(ev) {
+			if _, err := s.printLine(e); err != nil {
+				return err
+			}
+		}
+		s.blockRows = 0
+		return nil
+	}
+	events, isBlock := []LineEvent{ev}, false
+	if s.block != nil {
+		events, isBlock = s.block.add(ev)
+	}
+	if isBlock && s.tty {
+		if _, err := io.WriteString(s.out, blockRedraw(s.blockRows)); err != nil {
+			return err
+		}
+		s.blockRows = 0
+		for _, e := range events {
+			rows, err := s.printLine(e)
+			if err != nil {
+				return err
+			}
+			s.blockRows += rows
+		}
+		return nil
+	}
+	for _, e := range events {
+		if _, err := s.printLine(e); err != nil {
+			return err
+		}
+		s.blockRows = 0
+	}
+	return nil
+}
+
+// printLine writes one line and returns the terminal rows it used.
+func (s *terminalSink) printLine(ev LineEvent) (int, error) {
+	line := ev.Prefix + ev.Text
+	if s.wrapWidth != nil {
+		indent := utf8.RuneCountInString(ev.Prefix)
+		if indent == 0 {
+			indent = wrapIndent
+		}
+		wrapped := wrapLine(line, int(s.wrapWidth.Load()), indent)
+		for _, l := range wrapped {
+			if _, err := fmt.Fprintln(s.out, s.colorize(ev, l)); err != nil {
+				return 0, err
+			}
+		}
+		return len(wrapped), nil
+	}
+	if _, err := fmt.Fprintln(s.out, s.colorize(ev, line)); err != nil {
+		return 0, err
+	}
+	if s.block != nil && s.tty {
+		width, _ := terminalWidth(s.file)
+		return displayRows(line, width), nil
+	}
+	return 1, nil
+}
+
+// colorize colors one printed row of ev by its log level when color is on. Rows
+// are colored after wrapping so escape codes never count toward the width.
+func (s *terminalSink) colorize(ev LineEvent, row string) string {
+	if !s.color {
+		return row
+	}
+	if c, ok := levelColor(ev.Text); ok {
+		return c.wrap(row)
+	}
+	return row
+}
+
+// fanOut delivers an event to every sink. A sink that fails is reported once and
+// dropped so a closed pipe does not flood stderr.
+func fanOut(sinks []Sink, ev LineEvent) []Sink {
+	live := sinks[:0]
+	for _, s := range sinks {
+		if err := s.Write(ev); err != nil {
+			fmt.Fprintf(os.Stderr, "Write error, output disabled: %v\n", err)
+			continue
+		}
+		live = append(live, s)
+	}
+	return live
+}
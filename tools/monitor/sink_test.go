@@ -0,0 +1,137 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+var testEvent = LineEvent{
+	Time:   time.Date(2026, 3, 1, 12, 0, 0, 500000000, time.UTC),
+	Port:   "/dev/ttyACM0",
+	Offset: 4096,
+	Text:   `[EPUB] open "book, vol 1"`,
+}
+
+func TestFormatters(t *testing.T) {
+	tests := []struct {
+		name string
+		want string
+	}{
+		{"text", "[EPUB] open \"book, vol 1\"\n"},
+		{"json", `{"time":"2026-03-01T12:00:00.5Z","port":"/dev/ttyACM0","offset":4096,"line":"[EPUB] open \"book, vol 1\""}` + "\n"},
+		{"jsonl", `{"time":"2026-03-01T12:00:00.5Z","port":"/dev/ttyACM0","tag":"EPUB","msg":"open \"book, vol 1\""}` + "\n"},
+		{"csv", `2026-03-01T12:00:00.5Z,/dev/ttyACM0,4096,"[EPUB] open ""book, vol 1"""` + "\n"},
+		{"length-prefixed", `25:[EPUB] open "book, vol 1",`},
+	}
+	for _, tt := range tests {
+		f, err := lookupFormatter(tt.name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := f(testEvent); got != tt.want {
+			t.Errorf("%s: got %q, want %q", tt.name, got, tt.want)
+		}
+	}
+	if _, err := lookupFormatter("xml"); err == nil {
+		t.Error("expected error for unknown format")
+	}
+}
+
+func TestFormatText_Prefix(t *testing.T) {
+	ev := LineEvent{Prefix: "@0x0010 ", Text: "[BLE] ready"}
+	if got := formatText(ev); got != "@0x0010 [BLE] ready\n" {
+		t.Errorf("got %q", got)
+	}
+}
+
+func TestWriterSink_Filter(t *testing.T) {
+	var buf bytes.Buffer
+	sink := &writerSink{w: &buf, format: formatText, filter: func(ev LineEvent) bool {
+		return strings.HasPrefix(ev.Text, "[EPUB]")
+	}}
+	for _, text := range []string{"[EPUB] a", "[BLE] b", "[EPUB] c"} {
+		sink.Write(LineEvent{Text: text})
+	}
+	if buf.String() != "[EPUB] a\n[EPUB] c\n" {
+		t.Errorf("got %q", buf.String())
+	}
+}
+
+// failSink fails every write.
+type failSink struct{}
+
+func (failSink) Write(LineEvent) error { return errors.New("broken pipe") }
+
+func TestFanOut_SinksFormatIndependently(t *testing.T) {
+	var text, js bytes.Buffer
+	sinks := []Sink{
+		&writerSink{w: &text, format: formatText},
+		failSink{},
+		&writerSink{w: &js, format: formatJSON},
+	}
+	sinks = fanOut(sinks, testEvent)
+	if len(sinks) != 2 {
+		t.Fatalf("expected failing sink to be dropped, have %d sinks", len(sinks))
+	}
+	if text.String() != formatText(testEvent) || js.String() != formatJSON(testEvent) {
+		t.Errorf("got text %q json %q", text.String(), js.String())
+	}
+}
+
+func TestTerminalSink_ColorsLevelsOnly(t *testing.T) {
+	var out bytes.Buffer
+	term := &terminalSink{out: &out, color: true}
+	term.Write(LineEvent{Prefix: "+0.001s ", Text: "E (10) sd: mount failed"})
+	term.Write(LineEvent{Text: "I (11) sd: retrying"})
+	want := "\x1b[31m+0.001s E (10) sd: mount failed\x1b[0m\nI (11) sd: retrying\n"
+	if out.String() != want {
+		t.Errorf("got %q, want %q", out.String(), want)
+	}
+}
+
+func TestTerminalSink_HexDumpsBinaryLines(t *testing.T) {
+	var out bytes.Buffer
+	term := &terminalSink{out: &out, hex: true}
+	term.Write(LineEvent{Text: "[BLE] ready"})
+	term.Write(LineEvent{Prefix: "+0.002s ", Offset: 12, Text: "\x02\x00\x10"})
+	want := "[BLE] ready\n+0.002s 0000000c  02 00 10" + strings.Repeat(" ", 42) + "|...|\n"
+	if out.String() != want {
+		t.Errorf("got %q, want %q", out.String(), want)
+	}
+}
+
+func TestResolveLogANSI(t *testing.T) {
+	for _, tt := range []struct{ mode, path, want string }{
+		{"auto", "monitor.log", "keep"},
+		{"auto", "bench/Monitor.HTML", "html"},
+		{"strip", "monitor.html", "strip"},
+		{"keep", "monitor.log", "keep"},
+	} {
+		if got, err := resolveLogANSI(tt.mode, tt.path); err != nil || got != tt.want {
+			t.Errorf("resolveLogANSI(%q, %q) = %q, %v; want %q", tt.mode, tt.path, got, err, tt.want)
+		}
+	}
+	if _, err := resolveLogANSI("color", "monitor.log"); err == nil {
+		t.Error("expected error for an unknown mode")
+	}
+}
+
+func TestLogANSIFormatters(t *testing.T) {
+	ev := LineEvent{Prefix: "12:00:00.000 ", Text: "\x1b[0;31mE (12) sd: <mount> failed\x1b[0m"}
+	if got := stripped(formatText)(ev); got != "12:00:00.000 E (12) sd: <mount> failed\n" {
+		t.Errorf("stripped %q", got)
+	}
+	format := htmlFormatter()
+	want := `<span class="t">12:00:00.000 </span><span style="color:#cd3131">E (12) sd: &lt;mount&gt; failed</span>` + "\n"
+	if got := format(ev); got != want {
+		t.Errorf("html\n%q\nwant\n%q", got, want)
+	}
+	// Color left on at the end of a line carries into the next one.
+	format(LineEvent{Text: "\x1b[33mW (13) low"})
+	if got := format(LineEvent{Text: "battery"}); got != `<span style="color:#e5e510">battery</span>`+"\n" {
+		t.Errorf("carried color %q", got)
+	}
+}
@@ -0,0 +1,9 @@
+//go:build linux && !ppc64 && !ppc64le
+
+package main
+
+import "golang.org/x/sys/unix"
+
+// ioctlReadSpeed reads struct termios2, whose c_ospeed holds the rate the
+// driver actually set, including rates with no Bxxx constant.
+const ioctlReadSpeed = unix.TCGETS2
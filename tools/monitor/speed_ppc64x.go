@@ -0,0 +1,8 @@
+//go:build linux && (ppc64 || ppc64le)
+
+package main
+
+import "golang.org/x/sys/unix"
+
+// PowerPC has no TCGETS2; its termios carries c_ospeed already.
+const ioctlReadSpeed = unix.TCGETS
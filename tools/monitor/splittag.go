@@ -0,0 +1,84 @@
+package main
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// maxTagFiles bounds the files -split-by-tag keeps open; tags beyond it share
+// untaggedLog, so a device printing garbage cannot exhaust descriptors.
+const maxTagFiles = 128
+
+// untaggedLog holds the lines that carry no tag.
+const untaggedLog = "untagged"
+
+// tagSplitSink writes each line to a file named after its log tag in dir, so
+// EPUB lines land in EPUB.log and BLE lines in BLE.log.
+type tagSplitSink struct {
+	dir    string
+	format formatter
+	filter func(LineEvent) bool // nil accepts every event
+	files  map[string]*os.File
+}
+
+func newTagSplitSink(dir string, format formatter) (*tagSplitSink, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	return &tagSplitSink{dir: dir, format: format, files: map[string]*os.File{}}, nil
+}
+
+// tagFileName turns a tag into a file name, replacing characters that are
+// not safe in one. Arduino tags are source locations such as
+// sd_diskio.cpp:802, so the line number is dropped.
+func tagFileName(tag string) string {
+	if i := strings.LastIndexByte(tag, ':'); i > 0 {
+		tag = tag[:i]
+	}
+	name := strings.Map(func(r rune) rune {
+		if r == '.' || r == '-' || r == '_' || ('0' <= r && r <= '9') || ('A' <= r && r <= 'Z') || ('a' <= r && r <= 'z') {
+			return r
+		}
+		return '_'
+	}, strings.TrimSpace(tag))
+	if strings.Trim(name, "._") == "" {
+		return untaggedLog
+	}
+	return name
+}
+
+func (s *tagSplitSink) Write(ev LineEvent) error {
+	if s.filter != nil && !s.filter(ev) {
+		return nil
+	}
+	name := untaggedLog
+	if tag := parseLogLine(ev.Text).tag; tag != "" {
+		name = tagFileName(tag)
+	}
+	f, ok := s.files[name]
+	if !ok && len(s.files) >= maxTagFiles {
+		name = untaggedLog
+		f, ok = s.files[name]
+	}
+	if !ok {
+		var err error
+		if f, err = os.OpenFile(filepath.Join(s.dir, name+".log"), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644); err != nil {
+			return err
+		}
+		s.files[name] = f
+	}
+	_, err := io.WriteString(f, s.format(ev))
+	return err
+}
+
+func (s *tagSplitSink) Close() error {
+	var first error
+	for _, f := range s.files {
+		if err := f.Close(); err != nil && first == nil {
+			first = err
+		}
+	}
+	return first
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestTagFileName(t *testing.T) {
+	tests := []struct{ tag, want string }{
+		{"EPUB", "EPUB"},
+		{"sd_diskio.cpp:802", "sd_diskio.cpp"},
+		{"wifi/init", "wifi_init"},
+		{"..", untaggedLog},
+	}
+	for _, tt := range tests {
+		if got := tagFileName(tt.tag); got != tt.want {
+			t.Errorf("tagFileName(%q) = %q, want %q", tt.tag, got, tt.want)
+		}
+	}
+}
+
+func TestTagSplitSink(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "logs")
+	s, err := newTagSplitSink(dir, formatText)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, line := range []string{
+		"[1042] [EPUB] Opening book",
+		"E (812) BLE: adv failed",
+		"ESP-ROM:esp32c3-api1-20210207",
+		"[1050] [EPUB] Chapter 3",
+	} {
+		if err := s.Write(LineEvent{Text: line}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]string{
+		"EPUB.log":     "[1042] [EPUB] Opening book\n[1050] [EPUB] Chapter 3\n",
+		"BLE.log":      "E (812) BLE: adv failed\n",
+		"untagged.log": "ESP-ROM:esp32c3-api1-20210207\n",
+	}
+	for name, content := range want {
+		if b, err := os.ReadFile(filepath.Join(dir, name)); err != nil || string(b) != content {
+			t.Errorf("%s holds %q, %v; want %q", name, b, err, content)
+		}
+	}
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+// stallWatch notices when the port goes quiet. It sits in the read chain as a
+// writer, so any byte counts as activity, not just complete lines.
+type stallWatch struct {
+	limit time.Duration
+
+	mu      sync.Mutex
+	last    time.Time
+	stalled bool
+	since   time.Time // last byte before the stall
+}
+
+func newStallWatch(limit time.Duration, now time.Time) *stallWatch {
+	return &stallWatch{limit: limit, last: now}
+}
+
+func (w *stallWatch) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	w.last = time.Now()
+	w.mu.Unlock()
+	return len(p), nil
+}
+
+// check returns a warning the first time nothing has arrived for limit, with
+// stalled set, and a note once output resumes; otherwise "".
+func (w *stallWatch) check(now time.Time) (msg string, stalled bool) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	quiet := now.Sub(w.last)
+	switch {
+	case !w.stalled && quiet >= w.limit:
+		w.stalled, w.since = true, w.last
+		return fmt.Sprintf("Output stalled: nothing from the device for %s (crash or unexpected deep sleep?)", w.limit), true
+	case w.stalled && quiet < w.limit:
+		w.stalled = false
+		return fmt.Sprintf("Output resumed after %s.", w.last.Sub(w.since).Round(time.Second)), false
+	}
+	return "", w.stalled
+}
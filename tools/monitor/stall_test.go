@@ -0,0 +1,30 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestStallWatch(t *testing.T) {
+	start := time.Now()
+	w := newStallWatch(10*time.Second, start)
+	if msg, _ := w.check(start.Add(9 * time.Second)); msg != "" {
+		t.Errorf("early warning %q", msg)
+	}
+	msg, stalled := w.check(start.Add(10 * time.Second))
+	if !stalled || !strings.HasPrefix(msg, "Output stalled: nothing from the device for 10s") {
+		t.Errorf("got %q, %v", msg, stalled)
+	}
+	if msg, stalled := w.check(start.Add(time.Minute)); msg != "" || !stalled {
+		t.Errorf("warned twice: %q, %v", msg, stalled)
+	}
+	time.Sleep(10 * time.Millisecond)
+	w.Write([]byte("x"))
+	if msg, stalled := w.check(time.Now()); stalled || msg != "Output resumed after 0s." {
+		t.Errorf("got %q, %v after output", msg, stalled)
+	}
+	if msg, _ := w.check(time.Now()); msg != "" {
+		t.Errorf("resumed twice: %q", msg)
+	}
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// maxStatusBlockLines bounds how many lines are held back waiting for a status
+// block's end pattern before the block is given up on and printed normally.
+const maxStatusBlockLines = 64
+
+// statusBlock recognizes a multi-line status report bounded by start and end
+// patterns so it can be redrawn in place instead of scrolling.
+type statusBlock struct {
+	start, end *regexp.Regexp
+	pending    []LineEvent
+	inBlock    bool
+}
+
+// parseStatusBlock parses a -status-block value of the form "<start>..<end>".
+func parseStatusBlock(spec string) (*statusBlock, error) {
+	startExpr, endExpr, ok := strings.Cut(spec, "..")
+	if !ok || startExpr == "" || endExpr == "" {
+		return nil, fmt.Errorf("status-block: expected <start-regexp>..<end-regexp>, got %q", spec)
+	}
+	start, err := regexp.Compile(startExpr)
+	if err != nil {
+		return nil, fmt.Errorf("status-block start: %w", err)
+	}
+	end, err := regexp.Compile(endExpr)
+	if err != nil {
+		return nil, fmt.Errorf("status-block end: %w", err)
+	}
+	return &statusBlock{start: start, end: end}, nil
+}
+
+// add feeds one line through the recognizer. It returns the lines that are ready
+// to print and whether they form a complete status block. Lines inside an
+// unfinished block are held back and nil is returned.
+func (b *statusBlock) add(ev LineEvent) ([]LineEvent, bool) {
+	if !b.inBlock {
+		if !b.start.MatchString(ev.Text) {
+			return []LineEvent{ev}, false
+		}
+		// The end pattern is only tried from the line after the start, so the
+		// same marker line can open and close a block.
+		b.inBlock = true
+		b.pending = append(b.pending[:0], ev)
+		return nil, false
+	}
+	b.pending = append(b.pending, ev)
+	if b.end.MatchString(ev.Text) {
+		b.inBlock = false
+		return append([]LineEvent(nil), b.pending...), true
+	}
+	if len(b.pending) >= maxStatusBlockLines {
+		b.inBlock = false
+		return append([]LineEvent(nil), b.pending...), false
+	}
+	return nil, false
+}
+
+// blockRedraw returns the escape sequence that moves the cursor up over the
+// previous block's rows and clears them, or "" if there is nothing to redraw.
+func blockRedraw(rows int) string {
+	if rows <= 0 {
+		return ""
+	}
+	return fmt.Sprintf("\x1b[%dA\r\x1b[J", rows)
+}
@@ -0,0 +1,80 @@
+package main
+
+import "testing"
+
+func feedStatusBlock(b *statusBlock, lines ...string) (printed [][]string, blocks []bool) {
+	for _, l := range lines {
+		out, isBlock := b.add(LineEvent{Text: l})
+		if out != nil {
+			var texts []string
+			for _, ev := range out {
+				texts = append(texts, ev.Text)
+			}
+			printed = append(printed, texts)
+			blocks = append(blocks, isBlock)
+		}
+	}
+	return printed, blocks
+}
+
+func TestParseStatusBlock(t *testing.T) {
+	if _, err := parseStatusBlock("^== STATUS$..^==$"); err != nil {
+		t.Fatal(err)
+	}
+	for _, bad := range []string{"", "start", "..end", "start..", "(..x"} {
+		if _, err := parseStatusBlock(bad); err == nil {
+			t.Errorf("parseStatusBlock(%q): expected error", bad)
+		}
+	}
+}
+
+func TestStatusBlock_Recognizes(t *testing.T) {
+	b, _ := parseStatusBlock(`^\[STATUS\] begin..^\[STATUS\] end`)
+	printed, blocks := feedStatusBlock(b,
+		"[EPUB] open",
+		"[STATUS] begin",
+		"battery 87%",
+		"page 12/300",
+		"[STATUS] end",
+		"[EPUB] render",
+	)
+	if len(printed) != 3 {
+		t.Fatalf("expected 3 outputs, got %v", printed)
+	}
+	assertSliceEqual(t, printed[0], []string{"[EPUB] open"})
+	assertSliceEqual(t, printed[1], []string{"[STATUS] begin", "battery 87%", "page 12/300", "[STATUS] end"})
+	assertSliceEqual(t, printed[2], []string{"[EPUB] render"})
+	if blocks[0] || !blocks[1] || blocks[2] {
+		t.Errorf("block flags = %v", blocks)
+	}
+}
+
+func TestStatusBlock_SameMarker(t *testing.T) {
+	b, _ := parseStatusBlock("^----$..^----$")
+	printed, blocks := feedStatusBlock(b, "----", "heap 120k", "----")
+	if len(printed) != 1 || !blocks[0] {
+		t.Fatalf("got %v %v", printed, blocks)
+	}
+	assertSliceEqual(t, printed[0], []string{"----", "heap 120k", "----"})
+}
+
+func TestStatusBlock_UnterminatedIsReleased(t *testing.T) {
+	b, _ := parseStatusBlock("^begin$..^end$")
+	lines := []string{"begin"}
+	for i := 1; i < maxStatusBlockLines; i++ {
+		lines = append(lines, "x")
+	}
+	printed, blocks := feedStatusBlock(b, lines...)
+	if len(printed) != 1 || blocks[0] || len(printed[0]) != maxStatusBlockLines {
+		t.Fatalf("expected one released non-block of %d lines, got %d outputs", maxStatusBlockLines, len(printed))
+	}
+}
+
+func TestBlockRedraw(t *testing.T) {
+	if blockRedraw(0) != "" {
+		t.Error("expected no redraw for zero rows")
+	}
+	if got := blockRedraw(3); got != "\x1b[3A\r\x1b[J" {
+		t.Errorf("got %q", got)
+	}
+}
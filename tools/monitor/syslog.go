@@ -0,0 +1,162 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// syslogFacility is local0, the facility syslog leaves to site use.
+const syslogFacility = 16
+
+// syslogSockets are the local syslog sockets, in the order they are tried:
+// Linux (rsyslog and journald), macOS, the BSDs.
+var syslogSockets = []string{"/dev/log", "/var/run/syslog", "/var/run/log"}
+
+// parseSyslogTarget resolves -syslog: "local" for the local syslog socket,
+// udp://host[:port] or tcp://host[:port] for a remote server, or a bare
+// host[:port] for UDP. Remote ports default to 514.
+func parseSyslogTarget(s string) (network, addr string, err error) {
+	if s == "local" {
+		for _, path := range syslogSockets {
+			if _, err := os.Stat(path); err == nil {
+				return "unixgram", path, nil
+			}
+		}
+		return "", "", errors.New("no local syslog socket found")
+	}
+	network, addr = "udp", s
+	if strings.Contains(s, "://") {
+		u, err := url.Parse(s)
+		if err != nil || (u.Scheme != "udp" && u.Scheme != "tcp") || u.Host == "" {
+			return "", "", fmt.Errorf("bad syslog target %q (want local, udp://host:port or tcp://host:port)", s)
+		}
+		network, addr = u.Scheme, u.Host
+	}
+	if _, _, err := net.SplitHostPort(addr); err != nil {
+		addr = net.JoinHostPort(strings.Trim(addr, "[]"), "514")
+	}
+	return network, addr, nil
+}
+
+// syslogSeverity maps a log line's level letter to a syslog severity. Lines
+// without a level are informational.
+func syslogSeverity(level string) int {
+	switch level {
+	case "E":
+		return 3
+	case "W":
+		return 4
+	case "D", "V":
+		return 7
+	}
+	return 6
+}
+
+// syslogField returns s as an RFC 5424 header field: printable ASCII without
+// spaces, at most max characters, or "-" when empty.
+func syslogField(s string, max int) string {
+	s = strings.Map(func(r rune) rune {
+		if r <= ' ' || r > '~' {
+			return -1
+		}
+		return r
+	}, s)
+	if len(s) > max {
+		s = s[:max]
+	}
+	if s == "" {
+		return "-"
+	}
+	return s
+}
+
+// syslogMessage formats one device line as an RFC 5424 message. The firmware
+// tag becomes the MSGID.
+func syslogMessage(ev LineEvent, hostname string) string {
+	rec := parseLogLine(ev.Text)
+	return fmt.Sprintf("<%d>1 %s %s sumi - %s - %s",
+		syslogFacility*8+syslogSeverity(rec.level),
+		ev.Time.UTC().Format("2006-01-02T15:04:05.000000Z"),
+		syslogField(hostname, 255), syslogField(rec.tag, 32), stripANSI(ev.Text))
+}
+
+// syslogSink forwards device lines to syslog. Each message names its device
+// in the HOSTNAME field, by USB serial number where the port has one, so a
+// bench of readers can share one server.
+type syslogSink struct {
+	network, addr string
+	hostname      func(port string) string
+	dial          func(network, addr string) (net.Conn, error)
+
+	mu    sync.Mutex
+	conn  net.Conn
+	hosts map[string]string // hostname by port
+}
+
+// newSyslogSink connects to a -syslog target.
+func newSyslogSink(target string) (*syslogSink, error) {
+	network, addr, err := parseSyslogTarget(target)
+	if err != nil {
+		return nil, err
+	}
+	s := &syslogSink{network: network, addr: addr, hostname: syslogHostname, hosts: map[string]string{}}
+	s.dial = func(network, addr string) (net.Conn, error) {
+		return net.DialTimeout(network, addr, 5*time.Second)
+	}
+	if s.conn, err = s.dial(network, addr); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// syslogHostname names the device on port: its USB serial number, or the
+// port name when it has none.
+func syslogHostname(port string) string {
+	if serial := deviceSerial(port); serial != "" {
+		return serial
+	}
+	return portLabel(port)
+}
+
+func (s *syslogSink) Write(ev LineEvent) error {
+	if ev.Dir != dirRX {
+		return nil
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	host, ok := s.hosts[ev.Port]
+	if !ok {
+		host = s.hostname(ev.Port)
+		s.hosts[ev.Port] = host
+	}
+	msg := syslogMessage(ev, host)
+	if s.network == "tcp" {
+		// RFC 6587 octet counting, so a line may hold anything.
+		msg = fmt.Sprintf("%d %s", len(msg), msg)
+	}
+	if _, err := s.conn.Write([]byte(msg)); err == nil {
+		return nil
+	}
+	// The server or the local daemon may have restarted; reconnect once
+	// before giving up.
+	s.conn.Close()
+	conn, err := s.dial(s.network, s.addr)
+	if err != nil {
+		return err
+	}
+	s.conn = conn
+	_, err = conn.Write([]byte(msg))
+	return err
+}
+
+func (s *syslogSink) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.conn.Close()
+}
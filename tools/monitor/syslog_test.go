@@ -0,0 +1,119 @@
+package main
+
+import (
+	"bufio"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestParseSyslogTarget(t *testing.T) {
+	tests := []struct{ in, network, addr string }{
+		{"logs.example", "udp", "logs.example:514"},
+		{"10.0.0.5:5514", "udp", "10.0.0.5:5514"},
+		{"udp://logs.example", "udp", "logs.example:514"},
+		{"tcp://logs.example:601", "tcp", "logs.example:601"},
+		{"tcp://[::1]", "tcp", "[::1]:514"},
+	}
+	for _, tt := range tests {
+		network, addr, err := parseSyslogTarget(tt.in)
+		if err != nil || network != tt.network || addr != tt.addr {
+			t.Errorf("parseSyslogTarget(%q) = %s %s, %v; want %s %s", tt.in, network, addr, err, tt.network, tt.addr)
+		}
+	}
+	for _, bad := range []string{"http://logs.example", "tcp://"} {
+		if _, _, err := parseSyslogTarget(bad); err == nil {
+			t.Errorf("parseSyslogTarget(%q): expected error", bad)
+		}
+	}
+}
+
+func TestSyslogMessage(t *testing.T) {
+	at := time.Date(2026, 3, 1, 12, 0, 0, 250000000, time.UTC)
+	tests := []struct{ text, want string }{
+		{"[1042] [EPUB] Opening book", "<134>1 2026-03-01T12:00:00.250000Z 7C:DF:A1 sumi - EPUB - [1042] [EPUB] Opening book"},
+		{"\x1b[0;31mE (812) wifi: connect failed\x1b[0m", "<131>1 2026-03-01T12:00:00.250000Z 7C:DF:A1 sumi - wifi - E (812) wifi: connect failed"},
+		{"ESP-ROM:esp32c3-api1-20210207", "<134>1 2026-03-01T12:00:00.250000Z 7C:DF:A1 sumi - - - ESP-ROM:esp32c3-api1-20210207"},
+	}
+	for _, tt := range tests {
+		if got := syslogMessage(LineEvent{Time: at, Text: tt.text}, "7C:DF:A1"); got != tt.want {
+			t.Errorf("syslogMessage(%q)\n got %q\nwant %q", tt.text, got, tt.want)
+		}
+	}
+	if got := syslogField("my bench\x01", 5); got != "myben" {
+		t.Errorf("syslogField = %q", got)
+	}
+}
+
+func TestSyslogSink_UDP(t *testing.T) {
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pc.Close()
+	s, err := newSyslogSink("udp://" + pc.LocalAddr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	s.hostname = func(port string) string { return "SN-" + portLabel(port) }
+	s.Write(LineEvent{Port: "/dev/ttyACM0", Text: "help", Dir: dirTX})
+	s.Write(LineEvent{Time: time.Unix(0, 0), Port: "/dev/ttyACM0", Text: "[BLE] ready"})
+	buf := make([]byte, 512)
+	pc.SetReadDeadline(time.Now().Add(2 * time.Second))
+	n, _, err := pc.ReadFrom(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "<134>1 1970-01-01T00:00:00.000000Z SN-ttyACM0 sumi - BLE - [BLE] ready"; string(buf[:n]) != want {
+		t.Errorf("got %q, want %q", buf[:n], want)
+	}
+}
+
+func TestSyslogSink_TCPFramingAndReconnect(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	s, err := newSyslogSink("tcp://" + ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	s.hostname = func(string) string { return "SN1" }
+	first, err := ln.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	first.Close()
+	// The first write after the server drops may still succeed locally, so
+	// keep writing until the sink has reconnected.
+	done := make(chan net.Conn, 1)
+	go func() {
+		if c, err := ln.Accept(); err == nil {
+			done <- c
+		}
+	}()
+	var conn net.Conn
+	for i := 0; conn == nil && i < 50; i++ {
+		if err := s.Write(LineEvent{Time: time.Unix(0, 0), Text: "x"}); err != nil {
+			t.Fatal(err)
+		}
+		select {
+		case conn = <-done:
+		case <-time.After(20 * time.Millisecond):
+		}
+	}
+	if conn == nil {
+		t.Fatal("sink never reconnected")
+	}
+	defer conn.Close()
+	s.Write(LineEvent{Time: time.Unix(0, 0), Text: "x"})
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	line, err := bufio.NewReader(conn).ReadString('x')
+	want := "51 <134>1 1970-01-01T00:00:00.000000Z SN1 sumi - - - x"
+	if err != nil || line[len(line)-len(want):] != want {
+		t.Errorf("got %q, %v; want a frame %q", line, err, want)
+	}
+}
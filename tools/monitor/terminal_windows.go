@@ -0,0 +1,68 @@
+//go:build windows
+
+package main
+
+import (
+	"os"
+	"sync/atomic"
+	"time"
+
+	"golang.org/x/sys/windows"
+)
+
+// terminalWidth returns the column count of the console behind f, or false if f
+// is not a console.
+func terminalWidth(f *os.File) (int, bool) {
+	var info windows.ConsoleScreenBufferInfo
+	if err := windows.GetConsoleScreenBufferInfo(windows.Handle(f.Fd()), &info); err != nil {
+		return 0, false
+	}
+	return int(info.Window.Right-info.Window.Left) + 1, true
+}
+
+// watchTerminalWidth polls the width of f into width, since Windows consoles have
+// no resize signal.
+func watchTerminalWidth(f *os.File, width *atomic.Int32) {
+	go func() {
+		for range time.Tick(time.Second) {
+			if w, ok := terminalWidth(f); ok {
+				width.Store(int32(w))
+			}
+		}
+	}()
+}
+
+// terminalSize returns the rows and columns of the console window behind f.
+func terminalSize(f *os.File) (rows, cols int, ok bool) {
+	var info windows.ConsoleScreenBufferInfo
+	if err := windows.GetConsoleScreenBufferInfo(windows.Handle(f.Fd()), &info); err != nil {
+		return 0, 0, false
+	}
+	return int(info.Window.Bottom-info.Window.Top) + 1, int(info.Window.Right-info.Window.Left) + 1, true
+}
+
+// makeRaw switches the console input behind f to unprocessed virtual-terminal
+// input, so keys arrive one at a time as the escape sequences a Unix terminal
+// sends, and enables escape-sequence output on stdout. It returns a func
+// restoring both modes.
+func makeRaw(f *os.File) (func(), error) {
+	in, out := windows.Handle(f.Fd()), windows.Handle(os.Stdout.Fd())
+	var inMode, outMode uint32
+	if err := windows.GetConsoleMode(in, &inMode); err != nil {
+		return nil, err
+	}
+	if err := windows.GetConsoleMode(out, &outMode); err != nil {
+		return nil, err
+	}
+	if err := windows.SetConsoleMode(in, windows.ENABLE_VIRTUAL_TERMINAL_INPUT); err != nil {
+		return nil, err
+	}
+	if err := windows.SetConsoleMode(out, outMode|windows.ENABLE_PROCESSED_OUTPUT|windows.ENABLE_VIRTUAL_TERMINAL_PROCESSING); err != nil {
+		windows.SetConsoleMode(in, inMode)
+		return nil, err
+	}
+	return func() {
+		windows.SetConsoleMode(in, inMode)
+		windows.SetConsoleMode(out, outMode)
+	}, nil
+}
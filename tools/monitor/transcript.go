@@ -0,0 +1,65 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"time"
+)
+
+// direction tells device output apart from bytes sent to the device.
+type direction int
+
+const (
+	dirRX direction = iota
+	dirTX
+)
+
+func (d direction) String() string {
+	if d == dirTX {
+		return "TX"
+	}
+	return "RX"
+}
+
+const transcriptStamp = "2006-01-02T15:04:05.000000Z07:00"
+
+// transcriptSink writes both directions to one -transcript file in the order
+// they happen, one record per line:
+//
+//	2026-03-01T09:30:15.250000+01:00 TX locate
+//	2026-03-01T09:30:15.262104+01:00 RX [BLE] Identify
+//
+// RX events arrive from the read loop and TX events from whichever goroutine
+// writes to the port, so writes are serialized.
+type transcriptSink struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+func (s *transcriptSink) Write(ev LineEvent) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	_, err := fmt.Fprintf(s.w, "%s %s %s\n", ev.Time.Format(transcriptStamp), ev.Dir, ev.Text)
+	return err
+}
+
+// txTap wraps the port connection and records everything written to it as TX
+// events, one per line sent, so every sender is captured without opting in.
+type txTap struct {
+	io.ReadWriteCloser
+	sink Sink
+	port string
+}
+
+func (t *txTap) Write(p []byte) (int, error) {
+	n, err := t.ReadWriteCloser.Write(p)
+	if n > 0 {
+		now := time.Now()
+		for _, line := range strings.Split(strings.TrimRight(string(p[:n]), "\r\n"), "\n") {
+			t.sink.Write(LineEvent{Time: now, Port: t.port, Dir: dirTX, Text: strings.TrimSuffix(line, "\r")})
+		}
+	}
+	return n, err
+}
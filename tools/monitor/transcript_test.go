@@ -0,0 +1,87 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// transcriptFields returns the direction and text of each transcript record,
+// checking that every record carries a parseable timestamp.
+func transcriptFields(t *testing.T, out string) []string {
+	t.Helper()
+	var fields []string
+	for _, rec := range strings.Split(strings.TrimSuffix(out, "\n"), "\n") {
+		stamp, rest, _ := strings.Cut(rec, " ")
+		if _, err := time.Parse(transcriptStamp, stamp); err != nil {
+			t.Errorf("record %q: %v", rec, err)
+		}
+		fields = append(fields, rest)
+	}
+	return fields
+}
+
+func TestTranscript_InterleavedOrder(t *testing.T) {
+	var out bytes.Buffer
+	sink := &transcriptSink{w: &out}
+	r, w := io.Pipe()
+	defer w.Close()
+	conn := &txTap{ReadWriteCloser: pipeConn{r}, sink: sink, port: "/dev/ttyACM0"}
+
+	rx := func(text string) { sink.Write(LineEvent{Time: time.Now(), Text: text}) }
+	rx("[BOOT] Boot count: 3")
+	sendCommand(conn, "locate")
+	rx("[BLE] Identify")
+	conn.Write([]byte("status\r\nversion\r\n"))
+	rx("SUMI 0.6.4")
+
+	assertSliceEqual(t, transcriptFields(t, out.String()), []string{
+		"RX [BOOT] Boot count: 3",
+		"TX locate",
+		"RX [BLE] Identify",
+		"TX status",
+		"TX version",
+		"RX SUMI 0.6.4",
+	})
+}
+
+func TestTranscript_ConcurrentWritersKeepRecordsWhole(t *testing.T) {
+	var out bytes.Buffer
+	sink := &transcriptSink{w: &out}
+	r, w := io.Pipe()
+	defer w.Close()
+	conn := &txTap{ReadWriteCloser: pipeConn{r}, sink: sink}
+
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 100; i++ {
+			sink.Write(LineEvent{Time: time.Now(), Text: fmt.Sprintf("rx %d", i)})
+		}
+	}()
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 100; i++ {
+			sendCommand(conn, fmt.Sprintf("tx %d", i))
+		}
+	}()
+	wg.Wait()
+
+	next := map[string]int{"RX rx": 0, "TX tx": 0}
+	for _, f := range transcriptFields(t, out.String()) {
+		var n int
+		key := f[:5]
+		if _, err := fmt.Sscanf(f[6:], "%d", &n); err != nil || n != next[key] {
+			t.Fatalf("record %q out of order (want %s %d)", f, key, next[key])
+		}
+		next[key]++
+	}
+	if next["RX rx"] != 100 || next["TX tx"] != 100 {
+		t.Errorf("record counts %v", next)
+	}
+}
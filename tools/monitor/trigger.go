@@ -0,0 +1,97 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"regexp"
+	"runtime"
+	"strconv"
+	"strings"
+)
+
+// trigger is one -on rule: when a line matches pattern, run command and/or end
+// the session with exit status exit.
+type trigger struct {
+	spec    string
+	pattern *regexp.Regexp
+	command string // run through the shell; empty for none
+	exit    int    // -1 to keep monitoring
+}
+
+// parseTrigger parses a -on value of the form "<regexp>=>exit <status>" or
+// "<regexp>=>run <command>".
+func parseTrigger(spec string) (trigger, error) {
+	expr, action, ok := strings.Cut(spec, "=>")
+	if !ok || expr == "" {
+		return trigger{}, fmt.Errorf("on: expected <regexp>=>exit <status> or <regexp>=>run <command>, got %q", spec)
+	}
+	re, err := regexp.Compile(expr)
+	if err != nil {
+		return trigger{}, fmt.Errorf("on: %v", err)
+	}
+	t := trigger{spec: spec, pattern: re, exit: -1}
+	verb, arg, _ := strings.Cut(strings.TrimSpace(action), " ")
+	arg = strings.TrimSpace(arg)
+	switch verb {
+	case "exit":
+		code, err := strconv.Atoi(arg)
+		if err != nil || code < 0 || code > 125 {
+			return trigger{}, fmt.Errorf("on: exit status must be 0-125, got %q", arg)
+		}
+		t.exit = code
+	case "run":
+		if arg == "" {
+			return trigger{}, fmt.Errorf("on: run needs a command in %q", spec)
+		}
+		t.command = arg
+	default:
+		return trigger{}, fmt.Errorf("on: unknown action %q (want exit or run)", verb)
+	}
+	return t, nil
+}
+
+// runTrigger is how a trigger's command is started; tests replace it.
+var runTrigger = startTriggerCommand
+
+// startTriggerCommand runs a trigger's command in the background through the
+// shell, with the matched line and port in SUMI_LINE and SUMI_PORT. Its output
+// goes to stderr so it never mixes with the monitored stream.
+func startTriggerCommand(command string, ev LineEvent, stderr io.Writer) {
+	cmd := shellCommand(command)
+	cmd.Env = append(os.Environ(), "SUMI_LINE="+ev.Text, "SUMI_PORT="+ev.Port)
+	cmd.Stdout, cmd.Stderr = stderr, stderr
+	if err := cmd.Start(); err != nil {
+		fmt.Fprintf(stderr, "-on: %v\n", err)
+		return
+	}
+	go cmd.Wait()
+}
+
+// shellCommand runs a command line through the platform's shell.
+func shellCommand(command string) *exec.Cmd {
+	if runtime.GOOS == "windows" {
+		return exec.Command("cmd", "/C", command)
+	}
+	return exec.Command("sh", "-c", command)
+}
+
+// fireTriggers runs the triggers matching ev and returns the exit status of
+// the first matching exit rule, or -1 if none matched.
+func fireTriggers(triggers []trigger, ev LineEvent, stderr io.Writer) int {
+	exit := -1
+	for _, t := range triggers {
+		if !t.pattern.MatchString(ev.Text) {
+			continue
+		}
+		if t.command != "" {
+			runTrigger(t.command, ev, stderr)
+		}
+		if t.exit >= 0 && exit < 0 {
+			fmt.Fprintf(stderr, "\n*** Matched -on %q; exiting with status %d. ***\n", t.spec, t.exit)
+			exit = t.exit
+		}
+	}
+	return exit
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestParseTrigger(t *testing.T) {
+	tr, err := parseTrigger("Guru Meditation=>exit 2")
+	if err != nil || tr.exit != 2 || tr.command != "" || !tr.pattern.MatchString("Guru Meditation Error: Core 0 panic'ed") {
+		t.Errorf("exit rule: %+v, %v", tr, err)
+	}
+	tr, err = parseTrigger(`\[SM\] Initial state=>run ./flash-ok.sh --board c3`)
+	if err != nil || tr.exit != -1 || tr.command != "./flash-ok.sh --board c3" {
+		t.Errorf("run rule: %+v, %v", tr, err)
+	}
+	for _, bad := range []string{"Guru Meditation", "=>exit 1", "([=>exit 1", "x=>exit", "x=>exit 200", "x=>run", "x=>reboot"} {
+		if _, err := parseTrigger(bad); err == nil {
+			t.Errorf("parseTrigger(%q): expected error", bad)
+		}
+	}
+}
+
+func TestFireTriggers(t *testing.T) {
+	var ran []string
+	old := runTrigger
+	runTrigger = func(command string, ev LineEvent, stderr io.Writer) { ran = append(ran, command+" "+ev.Text) }
+	defer func() { runTrigger = old }()
+
+	var triggers []trigger
+	for _, spec := range []string{"panic=>run notify", "Guru=>exit 2", "panic=>exit 3"} {
+		tr, _ := parseTrigger(spec)
+		triggers = append(triggers, tr)
+	}
+	var stderr bytes.Buffer
+	if code := fireTriggers(triggers, LineEvent{Text: "[BLE] ready"}, &stderr); code != -1 || len(ran) != 0 {
+		t.Errorf("unmatched line fired: code %d, ran %v", code, ran)
+	}
+	code := fireTriggers(triggers, LineEvent{Text: "Guru Meditation Error: Core 0 panic'ed"}, &stderr)
+	if code != 2 {
+		t.Errorf("first exit rule should win, got %d", code)
+	}
+	assertSliceEqual(t, ran, []string{"notify Guru Meditation Error: Core 0 panic'ed"})
+	if !strings.Contains(stderr.String(), "exiting with status 2") {
+		t.Errorf("stderr %q", stderr.String())
+	}
+}
@@ -0,0 +1,325 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+// tuiScrollback is how many lines the -tui view keeps for scrolling back.
+const tuiScrollback = 10000
+
+// tuiView is the state of the full-screen -tui display: a scrollback of lines,
+// a bottom line that follows the stream unless paused, an incremental search
+// and error/warning counts for the status bar. Line indexes are absolute, so
+// trimming the scrollback never moves the view.
+type tuiView struct {
+	port  string
+	baud  int
+	start time.Time
+
+	lines   []LineEvent
+	dropped int  // lines trimmed from the front of the scrollback
+	bottom  int  // index one past the bottom line shown while paused
+	paused  bool // the view stays put while lines keep arriving
+	rows    int  // lines shown in the last render, for paging
+
+	errors, warnings int
+
+	searching bool // typing a query
+	query     string
+	notice    string // outcome of the last key action, until the next key
+}
+
+func newTUIView(port string, baud int, start time.Time) *tuiView {
+	return &tuiView{port: port, baud: baud, start: start}
+}
+
+// add appends one line to the scrollback.
+func (v *tuiView) add(ev LineEvent) {
+	switch c, _ := levelColor(ev.Text); c {
+	case colorRed:
+		v.errors++
+	case colorYellow:
+		v.warnings++
+	}
+	v.lines = append(v.lines, ev)
+	if n := len(v.lines) - tuiScrollback; n > 0 {
+		v.lines = append(v.lines[:0], v.lines[n:]...)
+		v.dropped += n
+	}
+}
+
+// total is the absolute index one past the newest line.
+func (v *tuiView) total() int {
+	return v.dropped + len(v.lines)
+}
+
+// viewBottom is the absolute index one past the bottom line on screen. A
+// paused view never starts above the oldest line kept.
+func (v *tuiView) viewBottom() int {
+	if !v.paused {
+		return v.total()
+	}
+	return max(v.bottom, min(v.dropped+max(v.rows, 1), v.total()))
+}
+
+// scroll moves the view by n lines, negative being back in time. Scrolling
+// back pauses the view; reaching the newest line again resumes following.
+func (v *tuiView) scroll(n int) {
+	bottom := v.viewBottom() + n
+	if bottom >= v.total() {
+		v.paused = false
+		return
+	}
+	v.paused, v.bottom = true, bottom
+}
+
+// togglePause freezes or resumes the view.
+func (v *tuiView) togglePause() {
+	if v.paused {
+		v.paused = false
+		return
+	}
+	v.paused, v.bottom = true, v.total()
+}
+
+// find moves the view so the nearest line matching the query before (dir -1)
+// or after (dir 1) the current bottom line becomes the bottom line. It reports
+// whether a match was found.
+func (v *tuiView) find(dir int, includeBottom bool) bool {
+	if v.query == "" {
+		return false
+	}
+	i := v.viewBottom() - 1
+	if !includeBottom {
+		i += dir
+	}
+	for ; i >= v.dropped && i < v.total(); i += dir {
+		if strings.Contains(stripANSI(v.lines[i-v.dropped].Text), v.query) {
+			v.paused, v.bottom = true, i+1
+			return true
+		}
+	}
+	return false
+}
+
+// key handles one keypress and reports whether the user asked to quit.
+func (v *tuiView) key(k string) bool {
+	if v.searching {
+		switch k {
+		case "\r", "\n":
+			v.searching = false
+		case "\x1b":
+			v.searching, v.query = false, ""
+		case "\x7f", "\b":
+			if v.query != "" {
+				_, size := utf8.DecodeLastRuneInString(v.query)
+				v.query = v.query[:len(v.query)-size]
+			}
+		default:
+			if len(k) > 0 && k[0] >= ' ' {
+				v.query += k
+				// Incremental: the newest match as the query grows.
+				v.find(-1, true)
+			}
+		}
+		return false
+	}
+	page := max(v.rows-1, 1)
+	switch k {
+	case "q", "\x03":
+		return true
+	case " ", "p":
+		v.togglePause()
+	case "k", "\x1b[A":
+		v.scroll(-1)
+	case "j", "\x1b[B":
+		v.scroll(1)
+	case "\x1b[5~", "b":
+		v.scroll(-page)
+	case "\x1b[6~", "f":
+		v.scroll(page)
+	case "g", "\x1b[H":
+		v.paused, v.bottom = true, 0
+	case "G", "\x1b[F":
+		v.paused = false
+	case "/":
+		v.searching, v.query = true, ""
+	case "n":
+		v.find(-1, false)
+	case "N":
+		v.find(1, false)
+	case "\x1b":
+		v.query = ""
+	}
+	return false
+}
+
+// render draws the whole screen: lines above, the status bar on the last row.
+func (v *tuiView) render(rows, cols int, now time.Time) string {
+	var b strings.Builder
+	b.WriteString("\x1b[H")
+	v.rows = max(rows-1, 0)
+	bottom := v.viewBottom()
+	top := max(bottom-v.rows, v.dropped)
+	for i := 0; i < v.rows; i++ {
+		if n := top + i; n < bottom {
+			ev := v.lines[n-v.dropped]
+			b.WriteString(v.renderLine(stripANSI(ev.Prefix+ev.Text), cols))
+		}
+		b.WriteString("\x1b[K\r\n")
+	}
+	b.WriteString("\x1b[7m")
+	b.WriteString(padRunes(v.status(now, bottom), cols))
+	b.WriteString("\x1b[0m")
+	return b.String()
+}
+
+// renderLine cuts a line to the screen width and shows search matches in
+// reverse video.
+func (v *tuiView) renderLine(line string, cols int) string {
+	line = truncateRunes(line, cols)
+	if v.query == "" {
+		return line
+	}
+	return strings.ReplaceAll(line, v.query, "\x1b[7m"+v.query+"\x1b[27m")
+}
+
+// status is the status bar text.
+func (v *tuiView) status(now time.Time, bottom int) string {
+	up := now.Sub(v.start).Truncate(time.Second)
+	s := fmt.Sprintf(" %s | %d baud | up %s | E:%d W:%d", v.port, v.baud, up, v.errors, v.warnings)
+	if v.paused {
+		s += fmt.Sprintf(" | PAUSED, %d newer", v.total()-bottom)
+	}
+	switch {
+	case v.notice != "":
+		s += " | " + v.notice
+	case v.searching:
+		s += " | /" + v.query
+	case v.query != "":
+		s += " | /" + v.query + " (n older, N newer)"
+	default:
+		s += " | space pause, / search, q quit"
+	}
+	return s
+}
+
+// truncateRunes cuts s to at most n runes.
+func truncateRunes(s string, n int) string {
+	if utf8.RuneCountInString(s) <= n {
+		return s
+	}
+	return string([]rune(s)[:n])
+}
+
+// padRunes cuts or space-pads s to exactly n runes.
+func padRunes(s string, n int) string {
+	s = truncateRunes(s, n)
+	return s + strings.Repeat(" ", n-utf8.RuneCountInString(s))
+}
+
+// splitKeys splits one read from the terminal into keypresses, keeping escape
+// sequences for arrows and paging whole. An ESC at the end of a read is a lone
+// Esc key.
+func splitKeys(p []byte) []string {
+	var keys []string
+	for len(p) > 0 {
+		n := 1
+		if p[0] == 0x1b && len(p) > 2 && p[1] == '[' {
+			n = 2
+			for n < len(p) && (p[n] < 0x40 || p[n] > 0x7e) {
+				n++
+			}
+			n = min(n+1, len(p))
+		} else if r, size := utf8.DecodeRune(p); r != utf8.RuneError {
+			n = size
+		}
+		keys = append(keys, string(p[:n]))
+		p = p[n:]
+	}
+	return keys
+}
+
+// tuiSink shows the stream in the -tui full-screen view. Lines and keys arrive
+// on different goroutines; a ticker redraws the screen when anything changed
+// and once a second for the uptime.
+type tuiSink struct {
+	mu      sync.Mutex
+	view    *tuiView
+	filter  func(LineEvent) bool     // nil accepts every event
+	actions map[string]func() string // keys that act on the device, returning a notice
+	dirty   bool
+}
+
+func (s *tuiSink) Write(ev LineEvent) error {
+	if s.filter != nil && !s.filter(ev) {
+		return nil
+	}
+	s.mu.Lock()
+	s.view.add(ev)
+	s.dirty = true
+	s.mu.Unlock()
+	return nil
+}
+
+// run reads keys from in and redraws onto out until the user quits, then calls
+// quit. size reports the screen's rows and columns.
+func (s *tuiSink) run(in io.Reader, out io.Writer, size func() (int, int), quit func()) {
+	go func() {
+		buf := make([]byte, 64)
+		for {
+			n, err := in.Read(buf)
+			if err != nil {
+				return
+			}
+			done := false
+			for _, k := range splitKeys(buf[:n]) {
+				s.mu.Lock()
+				s.view.notice = ""
+				action := s.actions[k]
+				if s.view.searching {
+					action = nil
+				}
+				if action == nil {
+					done = s.view.key(k)
+				}
+				s.mu.Unlock()
+				if action != nil {
+					// Actions pause for the reset sequence; run them
+					// without holding up the display.
+					notice := action()
+					s.mu.Lock()
+					s.view.notice = notice
+					s.mu.Unlock()
+				}
+				if done {
+					break
+				}
+			}
+			s.mu.Lock()
+			s.dirty = true
+			s.mu.Unlock()
+			if done {
+				quit()
+				return
+			}
+		}
+	}()
+	go func() {
+		last := time.Time{}
+		for now := range time.Tick(50 * time.Millisecond) {
+			s.mu.Lock()
+			if s.dirty || now.Sub(last) >= time.Second {
+				rows, cols := size()
+				io.WriteString(out, s.view.render(rows, cols, now))
+				s.dirty, last = false, now
+			}
+			s.mu.Unlock()
+		}
+	}()
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+)
+
+// tuiWith returns a view holding n numbered lines, rendered once at 5 rows so
+// paging knows the screen height.
+func tuiWith(n int) *tuiView {
+	v := newTUIView("/dev/ttyACM0", 115200, time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC))
+	for i := 0; i < n; i++ {
+		v.add(LineEvent{Text: fmt.Sprintf("[APP] line %d", i)})
+	}
+	v.render(5, 80, v.start)
+	return v
+}
+
+// shown returns the line text rows of a render, without the status bar.
+func shown(v *tuiView) []string {
+	screen := v.render(5, 80, v.start)
+	rows := strings.Split(strings.TrimPrefix(screen, "\x1b[H"), "\x1b[K\r\n")
+	return rows[:len(rows)-1]
+}
+
+func TestTUIView_FollowsAndPauses(t *testing.T) {
+	v := tuiWith(10)
+	assertSliceEqual(t, shown(v), []string{"[APP] line 6", "[APP] line 7", "[APP] line 8", "[APP] line 9"})
+	v.key(" ")
+	v.add(LineEvent{Text: "[APP] line 10"})
+	assertSliceEqual(t, shown(v), []string{"[APP] line 6", "[APP] line 7", "[APP] line 8", "[APP] line 9"})
+	if s := v.status(v.start, v.viewBottom()); !strings.Contains(s, "PAUSED, 1 newer") {
+		t.Errorf("status %q", s)
+	}
+	v.key(" ")
+	assertSliceEqual(t, shown(v)[3:], []string{"[APP] line 10"})
+}
+
+func TestTUIView_Scrollback(t *testing.T) {
+	v := tuiWith(10)
+	v.key("k")
+	assertSliceEqual(t, shown(v), []string{"[APP] line 5", "[APP] line 6", "[APP] line 7", "[APP] line 8"})
+	v.key("g")
+	assertSliceEqual(t, shown(v), []string{"[APP] line 0", "[APP] line 1", "[APP] line 2", "[APP] line 3"})
+	v.key("\x1b[6~")
+	assertSliceEqual(t, shown(v), []string{"[APP] line 3", "[APP] line 4", "[APP] line 5", "[APP] line 6"})
+	v.key("f")
+	v.key("f")
+	if v.paused {
+		t.Error("paging past the newest line should resume following")
+	}
+}
+
+func TestTUIView_IncrementalSearch(t *testing.T) {
+	v := tuiWith(10)
+	for _, k := range splitKeys([]byte("/line 2")) {
+		v.key(k)
+	}
+	v.key("\r")
+	// A match on the first screen leaves the view at the top.
+	rows := shown(v)
+	if rows[2] != "[APP] \x1b[7mline 2\x1b[27m" || !v.paused {
+		t.Errorf("match not shown highlighted: %q", rows)
+	}
+	v.key("x")
+	v.query = "line"
+	v.key("N")
+	if v.viewBottom() != 5 {
+		t.Errorf("N should move to the next newer match, bottom %d", v.viewBottom())
+	}
+	v.key("\x1b")
+	if v.query != "" {
+		t.Error("Esc should clear the search")
+	}
+}
+
+func TestTUIView_CountsAndTrims(t *testing.T) {
+	v := tuiWith(0)
+	v.add(LineEvent{Text: "E (10) sd: mount failed"})
+	v.add(LineEvent{Text: "W (11) wifi: weak signal"})
+	v.add(LineEvent{Text: "I (12) wifi: connected"})
+	if s := v.status(v.start.Add(90*time.Second), v.total()); !strings.Contains(s, "up 1m30s | E:1 W:1") {
+		t.Errorf("status %q", s)
+	}
+	for i := 0; i < tuiScrollback; i++ {
+		v.add(LineEvent{Text: "x"})
+	}
+	if len(v.lines) != tuiScrollback || v.dropped != 3 {
+		t.Errorf("scrollback holds %d lines, dropped %d", len(v.lines), v.dropped)
+	}
+}
+
+func TestSplitKeys(t *testing.T) {
+	got := splitKeys([]byte("q\x1b[A\x1b[5~é\x1b"))
+	assertSliceEqual(t, got, []string{"q", "\x1b[A", "\x1b[5~", "é", "\x1b"})
+}
+
+func TestTUIView_QuitKeys(t *testing.T) {
+	v := tuiWith(1)
+	if !v.key("q") || !v.key("\x03") || v.key("j") {
+		t.Error("q and Ctrl+C should quit, other keys should not")
+	}
+}
@@ -0,0 +1,57 @@
+package main
+
+import "strings"
+
+// espressifVID is Espressif's USB vendor ID. The USB Serial/JTAG controller
+// built into the ESP32-C3 that SUMI runs on, and into the ESP32-S3, enumerates
+// as 303A:1001 whatever name the OS gives the port.
+const espressifVID = "303A"
+
+// portDetail is the USB identity of a serial port, where it has one.
+type portDetail struct {
+	name     string
+	usb      bool
+	vid, pid string
+	serial   string // USB serial number, empty when the device has none
+}
+
+// detailedPorts lists ports with their USB details. Replaced in tests.
+var detailedPorts = listPortDetails
+
+// usbBoardPorts returns the ports whose USB vendor is Espressif.
+func usbBoardPorts(details []portDetail) []string {
+	var boards []string
+	for _, d := range details {
+		if d.usb && strings.EqualFold(d.vid, espressifVID) {
+			boards = append(boards, d.name)
+		}
+	}
+	return boards
+}
+
+// candidatePorts returns the ports auto-detection considers: positively
+// identified Espressif USB devices when there are any, otherwise the ports whose
+// names match the known patterns for goos.
+func candidatePorts(ports []string, goos string) []string {
+	if details, err := detailedPorts(); err == nil {
+		if boards := usbBoardPorts(details); len(boards) > 0 {
+			return boards
+		}
+	}
+	return filterPorts(ports, goos)
+}
+
+// deviceSerial returns the USB serial number of port, or "" when it has none
+// or USB details are unavailable.
+func deviceSerial(port string) string {
+	details, err := detailedPorts()
+	if err != nil {
+		return ""
+	}
+	for _, d := range details {
+		if d.name == port && d.usb {
+			return d.serial
+		}
+	}
+	return ""
+}
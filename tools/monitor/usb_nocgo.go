@@ -0,0 +1,11 @@
+//go:build darwin && !cgo
+
+package main
+
+import "errors"
+
+// listPortDetails is unavailable because USB enumeration on macOS needs cgo;
+// auto-detection falls back to port names.
+func listPortDetails() ([]portDetail, error) {
+	return nil, errors.New("USB enumeration on macOS needs a cgo build")
+}
@@ -0,0 +1,40 @@
+package main
+
+import (
+	"errors"
+	"testing"
+)
+
+func stubDetailedPorts(t *testing.T, details []portDetail, err error) {
+	t.Helper()
+	old := detailedPorts
+	t.Cleanup(func() { detailedPorts = old })
+	detailedPorts = func() ([]portDetail, error) { return details, err }
+}
+
+func TestUSBBoardPorts(t *testing.T) {
+	details := []portDetail{
+		{name: "/dev/ttyUSB0", usb: true, vid: "303a", pid: "1001"},
+		{name: "/dev/ttyUSB1", usb: true, vid: "10C4", pid: "EA60"},
+		{name: "/dev/ttyS0"},
+		{name: "COM7", usb: true, vid: "303A", pid: "1001"},
+	}
+	assertSliceEqual(t, usbBoardPorts(details), []string{"/dev/ttyUSB0", "COM7"})
+}
+
+func TestCandidatePorts_PrefersUSBMatch(t *testing.T) {
+	stubDetailedPorts(t, []portDetail{
+		{name: "COM3", usb: true, vid: "067B", pid: "2303"},
+		{name: "COM9", usb: true, vid: "303A", pid: "1001"},
+	}, nil)
+	assertSliceEqual(t, candidatePorts([]string{"COM3", "COM9"}, "windows"), []string{"COM9"})
+}
+
+func TestCandidatePorts_FallsBackToNames(t *testing.T) {
+	ports := []string{"/dev/ttyACM0", "/dev/ttyS0"}
+	stubDetailedPorts(t, []portDetail{{name: "/dev/ttyS0"}}, nil)
+	assertSliceEqual(t, candidatePorts(ports, "linux"), []string{"/dev/ttyACM0"})
+
+	stubDetailedPorts(t, nil, errors.New("enumeration unsupported"))
+	assertSliceEqual(t, candidatePorts(ports, "linux"), []string{"/dev/ttyACM0"})
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"unicode/utf8"
+)
+
+// maxRecordedOffsets bounds how many malformed-sequence offsets are kept.
+const maxRecordedOffsets = 20
+
+// utf8Stats counts malformed UTF-8 in received text. Malformed byte runs point
+// at the serial link (noise, a wrong baud, dropped bytes) while well-formed
+// U+FFFD characters were sent by the firmware itself, which separates link
+// corruption from firmware that genuinely emits bad text.
+type utf8Stats struct {
+	mu           sync.Mutex
+	invalid      int     // maximal runs of bytes that are not valid UTF-8
+	replacements int     // well-formed U+FFFD characters
+	offsets      []int64 // stream offsets of the first malformed runs
+}
+
+// scan counts malformed runs and replacement characters in one line of text
+// that started at the given raw-stream offset.
+func (s *utf8Stats) scan(text string, offset int64) {
+	if utf8.ValidString(text) && !strings.ContainsRune(text, utf8.RuneError) {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	inRun := false
+	for i := 0; i < len(text); {
+		r, size := utf8.DecodeRuneInString(text[i:])
+		switch {
+		case r == utf8.RuneError && size == 1:
+			if !inRun {
+				s.invalid++
+				if len(s.offsets) < maxRecordedOffsets {
+					s.offsets = append(s.offsets, offset+int64(i))
+				}
+			}
+			inRun = true
+		case r == utf8.RuneError:
+			s.replacements++
+			inRun = false
+		default:
+			inRun = false
+		}
+		i += size
+	}
+}
+
+// report writes the UTF-8 line of the exit summary.
+func (s *utf8Stats) report(w io.Writer, withOffsets bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	fmt.Fprintf(w, "UTF-8: %d malformed sequence(s), %d replacement character(s) from the device\n", s.invalid, s.replacements)
+	if withOffsets && len(s.offsets) > 0 {
+		hex := make([]string, len(s.offsets))
+		for i, off := range s.offsets {
+			hex[i] = fmt.Sprintf("0x%X", off)
+		}
+		more := ""
+		if s.invalid > len(s.offsets) {
+			more = fmt.Sprintf(" (first %d of %d)", len(s.offsets), s.invalid)
+		}
+		fmt.Fprintf(w, "  malformed at offsets%s: %s\n", more, strings.Join(hex, " "))
+	}
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestUTF8Stats_Clean(t *testing.T) {
+	var s utf8Stats
+	s.scan("[FONT] 日本語 ok", 0)
+	if s.invalid != 0 || s.replacements != 0 {
+		t.Errorf("got %d invalid, %d replacements", s.invalid, s.replacements)
+	}
+}
+
+func TestUTF8Stats_Corrupted(t *testing.T) {
+	var s utf8Stats
+	// "日本" with the second character's last byte dropped, then a stray
+	// continuation byte, then a lone lead byte at the end of the line.
+	line := string([]byte{0xe6, 0x97, 0xa5, 0xe6, 0x9c, ' ', 'a', 0x80, 'b', 0xe8})
+	s.scan(line, 100)
+	if s.invalid != 3 {
+		t.Errorf("invalid = %d, want 3", s.invalid)
+	}
+	want := []int64{103, 107, 109}
+	if len(s.offsets) != len(want) {
+		t.Fatalf("offsets = %v, want %v", s.offsets, want)
+	}
+	for i := range want {
+		if s.offsets[i] != want[i] {
+			t.Errorf("offset %d = %d, want %d", i, s.offsets[i], want[i])
+		}
+	}
+}
+
+func TestUTF8Stats_ReplacementFromDevice(t *testing.T) {
+	var s utf8Stats
+	s.scan("[FONT] missing glyph ��", 0)
+	if s.invalid != 0 || s.replacements != 2 {
+		t.Errorf("got %d invalid, %d replacements", s.invalid, s.replacements)
+	}
+}
+
+func TestUTF8Stats_OffsetsCapped(t *testing.T) {
+	var s utf8Stats
+	for i := 0; i < maxRecordedOffsets+5; i++ {
+		s.scan(string([]byte{'x', 0xff}), int64(i*3))
+	}
+	if s.invalid != maxRecordedOffsets+5 || len(s.offsets) != maxRecordedOffsets {
+		t.Errorf("invalid %d, %d offsets", s.invalid, len(s.offsets))
+	}
+	var buf bytes.Buffer
+	s.report(&buf, true)
+	if !bytes.Contains(buf.Bytes(), []byte("(first 20 of 25)")) || !bytes.Contains(buf.Bytes(), []byte("0x1 0x4")) {
+		t.Errorf("report = %q", buf.String())
+	}
+}
+
+func TestUTF8Stats_ReportWithoutOffsets(t *testing.T) {
+	var s utf8Stats
+	s.scan(string([]byte{0xff}), 0)
+	var buf bytes.Buffer
+	s.report(&buf, false)
+	if buf.String() != "UTF-8: 1 malformed sequence(s), 0 replacement character(s) from the device\n" {
+		t.Errorf("got %q", buf.String())
+	}
+}
@@ -0,0 +1,245 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"unicode/utf8"
+)
+
+// vtState is the escape-sequence parser state of a vtScreen.
+type vtState int
+
+const (
+	vtGround vtState = iota
+	vtEscape         // after ESC
+	vtCSI            // after ESC [
+)
+
+// vtScreen is a minimal VT100 interpreter. It keeps a character grid and applies
+// the cursor-movement and erase sequences firmware uses to draw serial
+// dashboards; colors and other attributes are accepted and ignored.
+type vtScreen struct {
+	mu         sync.Mutex
+	rows, cols int
+	cells      [][]rune
+	row, col   int
+	savedRow   int
+	savedCol   int
+	state      vtState
+	params     []byte
+	partial    []byte // incomplete UTF-8 sequence from the previous write
+	dirty      bool
+}
+
+func newVTScreen(rows, cols int) *vtScreen {
+	s := &vtScreen{rows: rows, cols: cols, cells: make([][]rune, rows)}
+	for i := range s.cells {
+		s.cells[i] = blankRow(cols)
+	}
+	return s
+}
+
+func blankRow(cols int) []rune {
+	row := make([]rune, cols)
+	for i := range row {
+		row[i] = ' '
+	}
+	return row
+}
+
+// parseVTSize parses a -vt-size value such as "80x24" (columns x rows).
+func parseVTSize(s string) (rows, cols int, err error) {
+	c, r, ok := strings.Cut(s, "x")
+	if ok {
+		cols, err = strconv.Atoi(c)
+		if err == nil {
+			rows, err = strconv.Atoi(r)
+		}
+	}
+	if !ok || err != nil || rows <= 0 || cols <= 0 {
+		return 0, 0, fmt.Errorf("vt-size: expected <cols>x<rows>, got %q", s)
+	}
+	return rows, cols, nil
+}
+
+// Write feeds raw bytes from the device into the interpreter. Sequences and
+// multi-byte characters may be split across writes.
+func (s *vtScreen) Write(p []byte) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	data := append(s.partial, p...)
+	s.partial = nil
+	for len(data) > 0 {
+		if data[0] >= utf8.RuneSelf && !utf8.FullRune(data) {
+			s.partial = append([]byte(nil), data...)
+			break
+		}
+		r, size := utf8.DecodeRune(data)
+		data = data[size:]
+		s.feed(r)
+	}
+	return len(p), nil
+}
+
+func (s *vtScreen) feed(r rune) {
+	switch s.state {
+	case vtEscape:
+		switch r {
+		case '[':
+			s.state = vtCSI
+			s.params = s.params[:0]
+			return
+		case '7':
+			s.savedRow, s.savedCol = s.row, s.col
+		case '8':
+			s.row, s.col = s.savedRow, s.savedCol
+		case 'c':
+			s.clear(2)
+			s.row, s.col = 0, 0
+		}
+		s.state = vtGround
+	case vtCSI:
+		if r >= 0x40 && r <= 0x7e {
+			s.csi(r)
+			s.state = vtGround
+		} else {
+			s.params = append(s.params, byte(r))
+		}
+	default:
+		s.ground(r)
+	}
+}
+
+func (s *vtScreen) ground(r rune) {
+	switch r {
+	case 0x1b:
+		s.state = vtEscape
+	case '\r':
+		s.col = 0
+	case '\n':
+		s.lineFeed()
+	case '\b':
+		if s.col > 0 {
+			s.col--
+		}
+	case '\t':
+		s.col = min((s.col/8+1)*8, s.cols-1)
+	default:
+		if r < 0x20 || r == 0x7f {
+			return
+		}
+		if s.col >= s.cols {
+			s.col = 0
+			s.lineFeed()
+		}
+		s.cells[s.row][s.col] = r
+		s.col++
+		s.dirty = true
+	}
+}
+
+func (s *vtScreen) lineFeed() {
+	if s.row < s.rows-1 {
+		s.row++
+		return
+	}
+	copy(s.cells, s.cells[1:])
+	s.cells[s.rows-1] = blankRow(s.cols)
+	s.dirty = true
+}
+
+// param returns the i-th numeric CSI parameter, or def when absent or zero.
+func (s *vtScreen) param(i, def int) int {
+	fields := strings.Split(strings.TrimLeft(string(s.params), "?"), ";")
+	if i >= len(fields) {
+		return def
+	}
+	n, err := strconv.Atoi(fields[i])
+	if err != nil || n == 0 {
+		return def
+	}
+	return n
+}
+
+func (s *vtScreen) csi(final rune) {
+	switch final {
+	case 'H', 'f':
+		s.row = clamp(s.param(0, 1)-1, 0, s.rows-1)
+		s.col = clamp(s.param(1, 1)-1, 0, s.cols-1)
+	case 'A':
+		s.row = clamp(s.row-s.param(0, 1), 0, s.rows-1)
+	case 'B':
+		s.row = clamp(s.row+s.param(0, 1), 0, s.rows-1)
+	case 'C':
+		s.col = clamp(s.col+s.param(0, 1), 0, s.cols-1)
+	case 'D':
+		s.col = clamp(s.col-s.param(0, 1), 0, s.cols-1)
+	case 'G':
+		s.col = clamp(s.param(0, 1)-1, 0, s.cols-1)
+	case 'J':
+		s.clear(s.param(0, 0))
+	case 'K':
+		s.clearLine(s.param(0, 0))
+	case 's':
+		s.savedRow, s.savedCol = s.row, s.col
+	case 'u':
+		s.row, s.col = s.savedRow, s.savedCol
+	}
+}
+
+// clear implements ED: 0 erases below the cursor, 1 above, 2 the whole screen.
+func (s *vtScreen) clear(mode int) {
+	switch mode {
+	case 0:
+		s.clearLine(0)
+		for r := s.row + 1; r < s.rows; r++ {
+			s.cells[r] = blankRow(s.cols)
+		}
+	case 1:
+		s.clearLine(1)
+		for r := 0; r < s.row; r++ {
+			s.cells[r] = blankRow(s.cols)
+		}
+	default:
+		for r := range s.cells {
+			s.cells[r] = blankRow(s.cols)
+		}
+	}
+	s.dirty = true
+}
+
+// clearLine implements EL: 0 erases to the end of the line, 1 to its start, 2 all.
+func (s *vtScreen) clearLine(mode int) {
+	line := s.cells[s.row]
+	from, to := s.col, s.cols
+	switch mode {
+	case 1:
+		from, to = 0, min(s.col+1, s.cols)
+	case 2:
+		from = 0
+	}
+	for c := from; c < to; c++ {
+		line[c] = ' '
+	}
+	s.dirty = true
+}
+
+// snapshot returns the screen rows with trailing blanks trimmed and reports
+// whether anything changed since the previous snapshot.
+func (s *vtScreen) snapshot() ([]string, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	changed := s.dirty
+	s.dirty = false
+	lines := make([]string, s.rows)
+	for i, row := range s.cells {
+		lines[i] = strings.TrimRight(string(row), " ")
+	}
+	return lines, changed
+}
+
+func clamp(v, lo, hi int) int {
+	return max(lo, min(v, hi))
+}
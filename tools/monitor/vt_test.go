@@ -0,0 +1,73 @@
+package main
+
+import "testing"
+
+func screenOf(t *testing.T, rows, cols int, input string) []string {
+	t.Helper()
+	s := newVTScreen(rows, cols)
+	s.Write([]byte(input))
+	lines, _ := s.snapshot()
+	return lines
+}
+
+func TestVTScreen_Dashboard(t *testing.T) {
+	input := "\x1b[2J\x1b[H\x1b[1mSUMI status\x1b[0m\r\n" +
+		"Battery: 87%\r\nPage: 12/300\r\n" +
+		"\x1b[2;10H92" + // overwrite the battery value in place
+		"\x1b[3;7H\x1b[K13/300"
+	want := []string{"SUMI status", "Battery: 92%", "Page: 13/300", ""}
+	assertSliceEqual(t, screenOf(t, 4, 20, input), want)
+}
+
+func TestVTScreen_SplitSequences(t *testing.T) {
+	input := "\x1b[2;3Hx\x1b[1;1H日本"
+	s := newVTScreen(3, 10)
+	for i := 0; i < len(input); i++ {
+		s.Write([]byte{input[i]})
+	}
+	lines, changed := s.snapshot()
+	if !changed {
+		t.Error("expected the screen to be dirty")
+	}
+	assertSliceEqual(t, lines, []string{"日本", "  x", ""})
+}
+
+func TestVTScreen_ScrollAndWrap(t *testing.T) {
+	got := screenOf(t, 2, 4, "abcdef\r\nghi")
+	assertSliceEqual(t, got, []string{"ef", "ghi"})
+}
+
+func TestVTScreen_EraseModes(t *testing.T) {
+	base := "1111\r\n2222\r\n3333\x1b[2;3H"
+	assertSliceEqual(t, screenOf(t, 3, 4, base+"\x1b[J"), []string{"1111", "22", ""})
+	assertSliceEqual(t, screenOf(t, 3, 4, base+"\x1b[1J"), []string{"", "   2", "3333"})
+	assertSliceEqual(t, screenOf(t, 3, 4, base+"\x1b[2K"), []string{"1111", "", "3333"})
+}
+
+func TestVTScreen_CursorMovesAndSave(t *testing.T) {
+	got := screenOf(t, 3, 10, "\x1b[2;2H\x1b7\x1b[3Bx\x1b8y\x1b[Az\x1b[5Gw")
+	assertSliceEqual(t, got, []string{"  z w", " y", " x"})
+}
+
+func TestVTScreen_SnapshotClearsDirty(t *testing.T) {
+	s := newVTScreen(2, 5)
+	s.Write([]byte("hi"))
+	if _, changed := s.snapshot(); !changed {
+		t.Fatal("expected change")
+	}
+	if _, changed := s.snapshot(); changed {
+		t.Error("second snapshot without input should be unchanged")
+	}
+}
+
+func TestParseVTSize(t *testing.T) {
+	rows, cols, err := parseVTSize("80x24")
+	if err != nil || rows != 24 || cols != 80 {
+		t.Errorf("got %d rows %d cols, %v", rows, cols, err)
+	}
+	for _, bad := range []string{"", "80", "x24", "0x24", "80x-1"} {
+		if _, _, err := parseVTSize(bad); err == nil {
+			t.Errorf("parseVTSize(%q): expected error", bad)
+		}
+	}
+}
@@ -0,0 +1,34 @@
+package main
+
+import (
+	"time"
+
+	"go.bug.st/serial"
+)
+
+// waitPoll is how often -wait enumerates ports. Enumeration is cheap, and a
+// short interval is what catches a board's first boot lines.
+const waitPoll = 50 * time.Millisecond
+
+// waitForPort calls find every poll until it reports at least one port, then
+// picks it as auto-detection would. find's errors are treated as no ports
+// yet, since enumeration can fail while a device is settling.
+func waitForPort(find func() ([]string, error), poll time.Duration) (string, error) {
+	for {
+		if found, err := find(); err == nil && len(found) > 0 {
+			return selectPort(found, found)
+		}
+		time.Sleep(poll)
+	}
+}
+
+// waitOpen calls open every poll until it succeeds. A device node can exist
+// briefly before udev grants access, so every error means not yet.
+func waitOpen(open func() (serial.Port, error), poll time.Duration) serial.Port {
+	for {
+		if p, err := open(); err == nil {
+			return p
+		}
+		time.Sleep(poll)
+	}
+}
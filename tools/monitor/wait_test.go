@@ -0,0 +1,43 @@
+package main
+
+import (
+	"errors"
+	"testing"
+
+	"go.bug.st/serial"
+)
+
+func TestWaitForPort(t *testing.T) {
+	calls := 0
+	find := func() ([]string, error) {
+		calls++
+		switch calls {
+		case 1:
+			return nil, nil
+		case 2:
+			return nil, errors.New("enumeration failed")
+		}
+		return []string{"/dev/ttyACM0"}, nil
+	}
+	if got, err := waitForPort(find, 0); err != nil || got != "/dev/ttyACM0" || calls != 3 {
+		t.Errorf("got %q, %v after %d polls", got, err, calls)
+	}
+	two := func() ([]string, error) { return []string{"/dev/ttyACM0", "/dev/ttyACM1"}, nil }
+	if _, err := waitForPort(two, 0); err == nil {
+		t.Error("two new boards: expected an error asking for -port")
+	}
+}
+
+func TestWaitOpen(t *testing.T) {
+	calls := 0
+	want := &netPort{}
+	got := waitOpen(func() (serial.Port, error) {
+		if calls++; calls < 3 {
+			return nil, errors.New("permission denied")
+		}
+		return want, nil
+	}, 0)
+	if got != want || calls != 3 {
+		t.Errorf("got %v after %d tries", got, calls)
+	}
+}
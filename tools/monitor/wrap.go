@@ -0,0 +1,36 @@
+package main
+
+import (
+	"strings"
+	"unicode/utf8"
+)
+
+// wrapIndent is the hanging indent for continuation lines of unprefixed output.
+const wrapIndent = 2
+
+// wrapLine hard-wraps line at width columns. Continuation lines are indented by
+// indent spaces so they sit under the content instead of the line's prefix.
+func wrapLine(line string, width, indent int) []string {
+	runes := []rune(line)
+	if width <= indent || len(runes) <= width {
+		return []string{line}
+	}
+	pad := strings.Repeat(" ", indent)
+	lines := []string{string(runes[:width])}
+	runes = runes[width:]
+	for len(runes) > 0 {
+		n := min(width-indent, len(runes))
+		lines = append(lines, pad+string(runes[:n]))
+		runes = runes[n:]
+	}
+	return lines
+}
+
+// displayRows returns how many terminal rows line occupies at width columns.
+func displayRows(line string, width int) int {
+	n := utf8.RuneCountInString(line)
+	if width <= 0 || n <= width {
+		return 1
+	}
+	return (n + width - 1) / width
+}
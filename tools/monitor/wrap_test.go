@@ -0,0 +1,42 @@
+package main
+
+import "testing"
+
+func TestWrapLine_Short(t *testing.T) {
+	assertSliceEqual(t, wrapLine("[EPUB] ok", 20, 2), []string{"[EPUB] ok"})
+}
+
+func TestWrapLine_HangingIndent(t *testing.T) {
+	got := wrapLine("abcdefghijklmnop", 8, 2)
+	want := []string{"abcdefgh", "  ijklmn", "  op"}
+	assertSliceEqual(t, got, want)
+}
+
+func TestWrapLine_Multibyte(t *testing.T) {
+	got := wrapLine("日本語のテキスト", 4, 1)
+	want := []string{"日本語の", " テキス", " ト"}
+	assertSliceEqual(t, got, want)
+}
+
+func TestWrapLine_WidthNotWiderThanIndent(t *testing.T) {
+	assertSliceEqual(t, wrapLine("abcdef", 2, 2), []string{"abcdef"})
+}
+
+func TestDisplayRows(t *testing.T) {
+	tests := []struct {
+		line  string
+		width int
+		want  int
+	}{
+		{"", 80, 1},
+		{"abcd", 4, 1},
+		{"abcde", 4, 2},
+		{"abcdefghi", 4, 3},
+		{"abc", 0, 1},
+	}
+	for _, tt := range tests {
+		if got := displayRows(tt.line, tt.width); got != tt.want {
+			t.Errorf("displayRows(%q, %d) = %d, want %d", tt.line, tt.width, got, tt.want)
+		}
+	}
+}